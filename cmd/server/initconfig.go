@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/pkg/blossomclient"
+)
+
+// probeTimeout bounds each capability probe of init-config
+const probeTimeout = 10 * time.Second
+
+// initServer is an upstream server written by init-config
+type initServer struct {
+	URL        string
+	Priority   int
+	Probed     bool   // Capabilities were probed (the server answered)
+	Error      string // Why the server couldn't be probed
+	Mirror     bool
+	UploadHead bool
+	List       bool
+	Delete     bool
+}
+
+// initConfigTemplate is the configuration written by init-config: the main options with
+// their defaults, see config/config.example.yaml for all of them
+var initConfigTemplate = template.Must(template.New("config").Parse(`# Blossom Proxy Server Configuration
+# Generated by "blossom_espelhator init-config" on {{.Generated}}
+# Only the main options are listed; see config/config.example.yaml for all of them
+
+# Upstream Blossom servers uploads are forwarded to (at least min_upload_servers)
+# Lower priority values are preferred by the "priority" strategy
+upstream_servers:
+{{- range .Servers}}
+  - url: "{{.URL}}"
+    priority: {{.Priority}}
+{{- if .Probed}}
+    # Capabilities probed when this file was generated: BUD-04 PUT /mirror, BUD-06
+    # HEAD /upload preflight, BUD-02 GET /list and DELETE (unset = auto-detect)
+    supports_mirror: {{.Mirror}}
+    supports_upload_head: {{.UploadHead}}
+    supports_list: {{.List}}
+    supports_delete: {{.Delete}}
+{{- else}}
+{{- if .Error}}
+    # Not probed ({{.Error}})
+{{- end}}
+    # Optional endpoints: BUD-04 PUT /mirror and BUD-06 HEAD /upload preflight (default: false)
+    # supports_mirror: true
+    # supports_upload_head: true
+{{- end}}
+{{- end}}
+
+# Proxy server configuration
+server:
+  # Address to listen on (host:port or :port)
+  listen_addr: ":8080"
+
+  # Minimum number of upstream servers that must receive an upload for it to succeed
+  min_upload_servers: {{.MinUploadServers}}
+
+  # Server picked for download redirects and the primary URL of upload responses:
+  # round_robin, random, priority, weighted, health_based, throughput_aware or local
+  redirect_strategy: "round_robin"
+
+  # GET /<sha256>: "redirect" (307 to an upstream) or "proxy" (stream through the proxy)
+  download_mode: "redirect"
+
+  # Public URL of the proxy, used for local URLs (default: derived from the request)
+  # base_url: "https://blossom.example.com"
+
+  # Timeout for download/HEAD/DELETE requests to upstream servers
+  timeout: 30s
+  # Upload timeout bounds (the authorization event's expiration picks a value in between)
+  min_upload_timeout: 5m
+  max_upload_timeout: 30m
+
+  # Consecutive failures before an upstream server is marked unhealthy
+  max_failures: 5
+
+  # Probe every upstream in the background this often (0s = disabled)
+  health_check_interval: 0s
+
+  # Location cache of blobs on the upstream servers
+  cache_ttl: 5m
+  cache_max_size: 1000
+
+  # Keep downloaded blobs on local disk up to this total size (0 = disabled)
+  blob_cache_max_bytes: 0
+
+  # Nostr authorization (kind 24242): pubkeys allowed to upload, mirror, delete and list
+  # (hex or npub; empty = authentication disabled)
+  allowed_pubkeys: []
+
+  # Status pages (/stats, /metrics, ...): "public", "minimal" or "auth"
+  status_access: "public"
+`))
+
+// runInitConfig implements "init-config": writes a commented configuration file with the
+// default settings, probing the upstream servers given as arguments for their capabilities
+func runInitConfig(args []string) error {
+	fs := flag.NewFlagSet("init-config", flag.ExitOnError)
+	output := fs.String("o", "config/config.yaml", "Path of the configuration file to write")
+	force := fs.Bool("force", false, "Overwrite an existing file")
+	noProbe := fs.Bool("no-probe", false, "Don't probe the upstream servers for their capabilities")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s init-config [options] [upstream URL ...]\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if _, err := os.Stat(*output); err == nil && !*force {
+		return fmt.Errorf("%s already exists (use -force to overwrite)", *output)
+	}
+
+	urls := fs.Args()
+	if len(urls) == 0 {
+		urls = []string{"https://blossom1.example.com", "https://blossom2.example.com"}
+		*noProbe = true
+	}
+	servers := make([]initServer, len(urls))
+	for i, url := range urls {
+		servers[i] = initServer{URL: strings.TrimRight(url, "/"), Priority: i + 1}
+	}
+	if !*noProbe {
+		probeServers(servers)
+	}
+
+	minUploadServers := 2
+	if len(servers) < minUploadServers {
+		minUploadServers = len(servers)
+	}
+
+	var buf bytes.Buffer
+	if err := initConfigTemplate.Execute(&buf, map[string]interface{}{
+		"Generated":        time.Now().UTC().Format(time.RFC3339),
+		"Servers":          servers,
+		"MinUploadServers": minUploadServers,
+	}); err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(*output); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	if err := os.WriteFile(*output, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", *output, err)
+	}
+	log.Printf("Wrote %s with %d upstream servers", *output, len(servers))
+
+	// The file must load as is; report what needs fixing otherwise (e.g. an invalid URL)
+	if _, err := config.Load(*output); err != nil {
+		logging.Warnf(context.Background(), "%v", err)
+	}
+	return nil
+}
+
+// probeServers probes the capabilities of every server in parallel
+func probeServers(servers []initServer) {
+	var wg sync.WaitGroup
+	for i := range servers {
+		wg.Add(1)
+		go func(s *initServer) {
+			defer wg.Done()
+			probeServer(s)
+			if s.Probed {
+				log.Printf("%s: mirror=%t upload_head=%t list=%t delete=%t", s.URL, s.Mirror, s.UploadHead, s.List, s.Delete)
+			} else {
+				logging.Warnf(context.Background(), "%s: not probed: %s", s.URL, s.Error)
+			}
+		}(&servers[i])
+	}
+	wg.Wait()
+}
+
+// probeServer fills in the capabilities of a server from its answers to harmless requests:
+// unauthenticated requests for an empty blob/pubkey that a server implementing the endpoint
+// rejects (400, 401, ...) or answers, while one lacking it answers 404, 405 or 501
+func probeServer(s *initServer) {
+	cl := blossomclient.New(s.URL, "", probeTimeout, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 4*probeTimeout)
+	defer cancel()
+
+	if err := cl.CheckHealth(ctx); err != nil {
+		s.Error = err.Error()
+		return
+	}
+
+	const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" // SHA-256 of nothing
+	zeroPubkey := strings.Repeat("0", 64)
+
+	resp, err := cl.HeadUpload(ctx, map[string]string{"X-SHA-256": emptyHash, "X-Content-Length": "0", "X-Content-Type": "application/octet-stream"})
+	if err == nil {
+		resp.Body.Close()
+		s.UploadHead = endpointImplemented(resp.StatusCode)
+	}
+	_, err = cl.Mirror(ctx, strings.NewReader(`{"url":""}`), "application/json", nil)
+	s.Mirror = err == nil || endpointImplementedErr(err)
+	_, err = cl.List(ctx, zeroPubkey)
+	s.List = err == nil || endpointImplementedErr(err)
+	err = cl.Delete(ctx, emptyHash, nil)
+	s.Delete = err == nil || endpointImplementedErr(err)
+	s.Probed = true
+}
+
+// endpointImplemented reports whether a status shows the endpoint exists
+func endpointImplemented(status int) bool {
+	switch status {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return false
+	}
+	return status != 0
+}
+
+// endpointImplementedErr reports whether an error is an HTTP answer of an existing endpoint
+func endpointImplementedErr(err error) bool {
+	var httpErr *blossomclient.HTTPError
+	return errors.As(err, &httpErr) && endpointImplemented(httpErr.StatusCode)
+}
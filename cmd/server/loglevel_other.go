@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "github.com/girino/blossom_espelhator/internal/logging"
+
+// watchLogLevelSignal does nothing on this platform (no SIGUSR1); use /admin/log-level instead
+func watchLogLevelSignal(debugLog *logging.Levels) {}
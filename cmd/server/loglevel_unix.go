@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// watchLogLevelSignal toggles debug logging every time the process receives SIGUSR1: off
+// if any component has it on, otherwise back on for the components that had it
+func watchLogLevelSignal(debugLog *logging.Levels) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR1)
+	go func() {
+		for range sigChan {
+			if enabled := debugLog.Toggle(); len(enabled) > 0 {
+				log.Printf("SIGUSR1: debug logging enabled for %s", strings.Join(enabled, ", "))
+			} else {
+				log.Printf("SIGUSR1: debug logging disabled")
+			}
+		}
+	}()
+}
@@ -1,44 +1,201 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/hex"
 	"flag"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/girino/blossom_espelhator/internal/blobstore"
+	"github.com/girino/blossom_espelhator/internal/blocklist"
 	"github.com/girino/blossom_espelhator/internal/cache"
+	"github.com/girino/blossom_espelhator/internal/cluster"
 	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/discovery"
 	"github.com/girino/blossom_espelhator/internal/handler"
+	"github.com/girino/blossom_espelhator/internal/healthcheck"
+	"github.com/girino/blossom_espelhator/internal/integrity"
+	"github.com/girino/blossom_espelhator/internal/journal"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/quarantine"
+	"github.com/girino/blossom_espelhator/internal/recovery"
+	"github.com/girino/blossom_espelhator/internal/repair"
+	"github.com/girino/blossom_espelhator/internal/spool"
 	"github.com/girino/blossom_espelhator/internal/stats"
+	"github.com/girino/blossom_espelhator/internal/tracing"
 	"github.com/girino/blossom_espelhator/internal/upstream"
+	"github.com/girino/blossom_espelhator/internal/version"
 )
 
+// restartShutdownTimeout is how long in-flight requests may take to finish when the
+// process restarts to apply a new upstream set from the discovery event
+const restartShutdownTimeout = 30 * time.Second
+
+// tracingFlushTimeout is how long pending spans may take to be exported on shutdown
+const tracingFlushTimeout = 5 * time.Second
+
+// cacheFlushInterval is how often changed cache entries are written to cache_path
+const cacheFlushInterval = 10 * time.Second
+
+// sharedCacheFlushInterval is how often changed cache entries are written to Redis, where
+// the other instances find them
+const sharedCacheFlushInterval = time.Second
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "init-config" {
+		if err := runInitConfig(os.Args[2:]); err != nil {
+			logging.Fatalf("init-config: %v", err)
+		}
+		return
+	}
+
 	configPath := flag.String("config", "config/config.yaml", "Path to configuration file")
-	verbose := flag.Bool("v", false, "Enable verbose debug logging")
-	flag.BoolVar(verbose, "verbose", false, "Enable verbose debug logging")
+	verbose := flag.Bool("v", false, "Enable verbose debug logging (all components)")
+	flag.BoolVar(verbose, "verbose", false, "Enable verbose debug logging (all components)")
+	debugComponents := flag.String("debug", "", "Enable debug logging of some components only (comma-separated: "+strings.Join(logging.Components, ", ")+")")
+	logFormat := flag.String("log-format", logging.FormatText, "Log output format (text or json)")
+	logLevel := flag.String("log-level", "info", "Minimum level of logged lines (debug, info, warn or error); debug enables every component like -v")
+	enableChaos := flag.Bool("enable-chaos", false, "Inject the faults from upstream chaos sections (staging only)")
 	flag.Parse()
 
-	// Load configuration
-	cfg, err := config.Load(*configPath)
+	// Every line goes through log/slog, with the request ID of the request it belongs to
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		logging.Fatalf("Invalid -log-level: %v", err)
+	}
+	if err := logging.Setup(os.Stderr, *logFormat, level); err != nil {
+		logging.Fatalf("Invalid -log-format: %v", err)
+	}
+
+	// Debug logging is set per component, and can be toggled at runtime with SIGUSR1 or
+	// /admin/log-level
+	enabledComponents, err := logging.ParseComponents(*debugComponents)
+	if err != nil {
+		logging.Fatalf("Invalid -debug: %v", err)
+	}
+	if *verbose || level <= slog.LevelDebug {
+		enabledComponents = logging.Components
+	}
+	debugLog := logging.NewLevels(enabledComponents)
+	watchLogLevelSignal(debugLog)
+
+	// Load configuration, taking the upstream set from the discovery event if configured
+	var upstreamDiscovery *discovery.Discoverer
+	cfg, err := config.LoadWith(*configPath, func(c *config.Config) error {
+		if c.UpstreamDiscovery == nil {
+			return nil
+		}
+		d, err := discovery.New(c.UpstreamDiscovery, debugLog.Flag(logging.Upstream))
+		if err != nil {
+			return err
+		}
+		upstreamDiscovery = d
+		return d.Prepare(c)
+	})
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		logging.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Export OpenTelemetry spans of client and upstream requests (disabled unless tracing_endpoint is set)
+	shutdownTracing, err := tracing.Setup(&cfg.Server)
+	if err != nil {
+		logging.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	if cfg.Server.TracingEndpoint != "" {
+		log.Printf("Exporting traces to %s (sample ratio %g)", cfg.Server.TracingEndpoint, cfg.Server.TracingSampleRatio)
+	}
+
+	// Open the persistent cache file (disabled unless cache_path is set), or the Redis
+	// cache shared with other instances
+	var cacheStore cache.Store
+	flushInterval := cacheFlushInterval
+	switch {
+	case cfg.Server.CacheBackend == "redis":
+		redisOpts := cache.RedisOptions{
+			Addr:     cfg.Server.CacheRedisAddr,
+			Username: cfg.Server.CacheRedisUsername,
+			Password: cfg.Server.CacheRedisPassword,
+			DB:       cfg.Server.CacheRedisDB,
+			PoolSize: cfg.Server.CacheRedisPoolSize,
+			Prefix:   cfg.Server.CacheRedisKeyPrefix,
+			TTL:      cfg.Server.CacheTTL,
+		}
+		if cfg.Server.CacheRedisTLS {
+			host, _, _ := net.SplitHostPort(cfg.Server.CacheRedisAddr)
+			redisOpts.TLS = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+		}
+		cacheStore, err = cache.OpenRedisStore(redisOpts)
+		if err != nil {
+			logging.Fatalf("Failed to open redis cache: %v", err)
+		}
+		flushInterval = sharedCacheFlushInterval
+	case cfg.Server.CachePath != "":
+		cacheStore, err = cache.OpenBoltStore(cfg.Server.CachePath)
+		if err != nil {
+			logging.Fatalf("Failed to open cache file: %v", err)
+		}
+	}
+
+	// Open the hash blocklist (persisted if blocklist_path is set)
+	bannedHashes, err := blocklist.Open(cfg.Server.BlocklistPath)
+	if err != nil {
+		logging.Fatalf("Failed to open blocklist: %v", err)
 	}
 
 	// Initialize cache with TTL and max size from config
+	// Blocked hashes are never cached, whether found here, by a peer or in the shared store
 	cache := cache.New(cfg.Server.CacheTTL, cfg.Server.CacheMaxSize)
+	cache.SetNegativeTTL(cfg.Server.NegativeCacheTTL)
+	cache.SetBlocked(bannedHashes.Contains)
+	if cacheStore != nil {
+		loaded, err := cache.SetStore(cacheStore)
+		if err != nil {
+			logging.Fatalf("Failed to load cache: %v", err)
+		}
+		if cfg.Server.CacheBackend == "redis" {
+			log.Printf("Sharing the cache through redis at %s", cfg.Server.CacheRedisAddr)
+		} else {
+			log.Printf("Loaded %d cache entries from %s", loaded, cfg.Server.CachePath)
+		}
+		defer cache.Close()
+	}
+
+	// Initialize quarantine for replicas that failed verification
+	replicaQuarantine := quarantine.New()
 
 	// Initialize stats tracker
 	statsTracker := stats.New(cfg.Server.MaxFailures)
 
 	// Initialize upstream manager
-	upstreamManager, err := upstream.New(cfg, *verbose)
+	upstreamManager, err := upstream.New(cfg, debugLog)
 	if err != nil {
-		log.Fatalf("Failed to initialize upstream manager: %v", err)
+		logging.Fatalf("Failed to initialize upstream manager: %v", err)
+	}
+	// Thresholds come from the manager so servers added at runtime get their own
+	statsTracker.SetMaxFailuresFunc(upstreamManager.MaxFailuresFor)
+
+	// Chaos testing faults are only injected when explicitly requested on the command line,
+	// so a staging config copied to production can't break real mirrors
+	// (servers added later through /admin/upstreams get their chaos section injected too)
+	if *enableChaos {
+		if upstreamManager.EnableChaos() == 0 {
+			logging.Warnf(context.Background(), "-enable-chaos set but no upstream server has a chaos section")
+		}
+	} else {
+		for _, server := range cfg.UpstreamServers {
+			if server.Chaos != nil {
+				logging.Warnf(context.Background(), "Ignoring chaos section for %s (start with -enable-chaos to inject faults)", server.URL)
+			}
+		}
 	}
 
 	// Initialize stats for all upstream servers (they all start as healthy)
@@ -46,10 +203,139 @@ func main() {
 	statsTracker.InitializeServers(allServerURLs)
 
 	// Set failure getter for health_based strategy
-	upstreamManager.SetFailureGetter(statsTracker.GetTotalFailures)
+	upstreamManager.SetFailureGetter(statsTracker.GetFailuresFor)
+
+	// Warn when live traffic takes a server down or brings it back, naming whom to contact
+	statsTracker.SetHealthChangeFunc(func(serverURL string, healthy bool) {
+		if healthy {
+			log.Printf("Upstream %s is healthy again", serverURL)
+			return
+		}
+		logging.Warnf(context.Background(), "Upstream %s marked unhealthy after repeated failures%s", serverURL, upstreamManager.ContactHint(serverURL))
+	})
+
+	// Feed upload/mirror throughput into stats and back into throughput_aware selection
+	upstreamManager.SetThroughputRecorder(statsTracker.RecordThroughput)
+	upstreamManager.SetThroughputGetter(statsTracker.GetThroughputFor)
+	upstreamManager.SetLatencyRecorder(statsTracker.RecordLatency)
+	upstreamManager.SetTransferRecorder(statsTracker.RecordSentUpstream)
+
+	// Background jobs are stopped when the server shuts down
+	bgCtx, bgCancel := context.WithCancel(context.Background())
+	defer bgCancel()
+
+	if cacheStore != nil {
+		cache.StartFlushing(bgCtx, flushInterval)
+	}
+
+	// Share cache entries, health and counters with the other instances (disabled unless cluster is set)
+	var clusterState *cluster.Cluster
+	if cfg.Cluster != nil {
+		clusterState = cluster.New(cfg.Cluster, cache, statsTracker, allServerURLs, debugLog.Flag(logging.Cache))
+	}
+
+	// Servers added or removed through /admin/upstreams are tracked like configured ones
+	upstreamManager.SetServerObserver(func(serverURL string, added bool) {
+		if added {
+			statsTracker.InitializeServers([]string{serverURL})
+		} else {
+			statsTracker.RemoveServer(serverURL)
+			cache.ForgetServer(serverURL)
+		}
+		if clusterState != nil {
+			clusterState.SetKnownServer(serverURL, added)
+		}
+	})
+
+	// Start integrity spot checks (disabled unless integrity_check_interval is set)
+	// In a cluster, only the leader downloads blobs to verify them
+	integrityChecker := integrity.New(upstreamManager, cache, replicaQuarantine, statsTracker,
+		cfg.Server.IntegrityCheckInterval, cfg.Server.IntegrityCheckTimeout, cfg.Server.IntegrityCheckMaxBytes, debugLog.Flag(logging.Upstream))
+	if clusterState != nil {
+		integrityChecker.SetGate(clusterState.IsLeader)
+	}
+	integrityChecker.Start(bgCtx)
+
+	// Start replication repair (disabled unless repair_interval is set)
+	// In a cluster, only the leader mirrors under-replicated blobs
+	// The repairer also mirrors trending blobs if prefetch_min_popularity is set
+	var replicationRepair *repair.Repairer
+	if cfg.Server.RepairInterval > 0 || cfg.Server.PrefetchMinPopularity > 0 {
+		replicationRepair, err = repair.New(upstreamManager, cache, replicaQuarantine, statsTracker, cfg.Server.RepairInterval,
+			cfg.Server.RepairBatchSize, cfg.Server.Timeout, cfg.Server.MaxUploadTimeout, cfg.Server.RepairSecretKey, debugLog.Flag(logging.Upstream))
+		if err != nil {
+			logging.Fatalf("Failed to initialize replication repair: %v", err)
+		}
+		if clusterState != nil {
+			replicationRepair.SetGate(clusterState.IsLeader)
+		}
+		replicationRepair.SetBlocked(bannedHashes.Contains)
+		replicationRepair.Start(bgCtx)
+	}
+
+	// Start active health checks (disabled unless health_check_interval is set)
+	healthChecker := healthcheck.New(upstreamManager, statsTracker, cfg.Server.HealthCheckInterval, cfg.Server.HealthCheckTimeout,
+		cfg.Server.HealthCheckFailureThreshold, cfg.Server.HealthCheckRecoveryThreshold, debugLog.Flag(logging.Upstream))
+	healthChecker.Start(bgCtx)
+
+	// Initialize upload spool (disabled unless upload_spool_retention is set)
+	var uploadSpool *spool.Spool
+	if cfg.Server.UploadSpoolRetention > 0 {
+		uploadSpool, err = spool.New(cfg.Server.UploadSpoolDir, cfg.Server.UploadSpoolRetention, cfg.Server.UploadSpoolMaxBytes, debugLog.Flag(logging.Handler))
+		if err != nil {
+			logging.Fatalf("Failed to initialize upload spool: %v", err)
+		}
+		uploadSpool.Start(bgCtx)
+	}
+
+	// Open the local blob cache (disabled unless blob_cache_max_bytes is set)
+	var blobStore *blobstore.Store
+	if cfg.Server.BlobCacheMaxBytes > 0 {
+		blobStore, err = blobstore.New(cfg.Server.BlobCacheDir, cfg.Server.BlobCacheMaxBytes, cfg.Server.BlobCacheMaxBlobBytes, debugLog.Flag(logging.Cache))
+		if err != nil {
+			logging.Fatalf("Failed to initialize blob cache: %v", err)
+		}
+		blobStore.SetBlocked(bannedHashes.Contains)
+	}
+
+	// Open the pending-operation journal (disabled unless journal_path is set)
+	var pendingOps *journal.Journal
+	if cfg.Server.JournalPath != "" {
+		pendingOps, err = journal.Open(cfg.Server.JournalPath, cfg.Server.JournalRetryInterval, cfg.Server.MaxUploadTimeout,
+			cfg.Server.JournalMaxAttempts, cfg.Server.JournalMaxAge, debugLog.Flag(logging.Handler))
+		if err != nil {
+			logging.Fatalf("Failed to open journal: %v", err)
+		}
+		defer pendingOps.Close()
+	}
 
 	// Initialize handler
-	blossomHandler := handler.New(upstreamManager, cache, statsTracker, cfg, *verbose)
+	blossomHandler := handler.New(upstreamManager, cache, replicaQuarantine, uploadSpool, pendingOps, statsTracker, cfg, debugLog)
+	if blobStore != nil {
+		blossomHandler.SetBlobStore(blobStore)
+	}
+	if replicationRepair != nil {
+		blossomHandler.SetRepairer(replicationRepair)
+	}
+	blossomHandler.SetBlocklist(bannedHashes)
+	blossomHandler.SetLifecycle(bgCtx)
+	if clusterState != nil {
+		blossomHandler.SetCluster(clusterState)
+		if pendingOps != nil {
+			clusterState.SetJournal(pendingOps)
+		}
+	}
+
+	// Push metrics to statsd (disabled unless statsd_addr is set)
+	blossomHandler.StartStatsd(bgCtx)
+
+	// Replay journaled operations once the handlers are registered
+	if pendingOps != nil {
+		pendingOps.Start(bgCtx)
+	}
+	if clusterState != nil {
+		clusterState.Start(bgCtx)
+	}
 
 	// Setup routes
 	mux := http.NewServeMux()
@@ -57,9 +343,41 @@ func main() {
 	// Health check endpoint
 	mux.HandleFunc("/health", blossomHandler.HandleHealth)
 
+	// Compact status for uptime monitors and crawlers
+	mux.HandleFunc("/status.json", blossomHandler.HandleStatusJSON)
+
 	// Stats endpoint
 	mux.HandleFunc("/stats", blossomHandler.HandleStats)
 
+	// Prometheus metrics endpoint
+	mux.HandleFunc("/metrics", blossomHandler.HandleMetrics)
+
+	// Capability matrix of the upstream servers
+	mux.HandleFunc("/admin/capabilities", blossomHandler.HandleCapabilities)
+
+	// Runtime management of the upstream servers (add, remove, pause, resume)
+	mux.HandleFunc("/admin/upstreams", blossomHandler.HandleUpstreams)
+
+	// Effective configuration, including runtime changes
+	mux.HandleFunc("/admin/config", blossomHandler.HandleConfig)
+
+	// Export and import of the cache and statistics (blue/green deploys)
+	mux.HandleFunc("/admin/snapshot", blossomHandler.HandleSnapshot)
+
+	// Most downloaded blobs
+	mux.HandleFunc("/admin/popular", blossomHandler.HandlePopular)
+
+	// Banned blob hashes (list, block, unblock)
+	mux.HandleFunc("/admin/blocklist", blossomHandler.HandleBlocklist)
+
+	// Debug logging on/off at runtime
+	mux.HandleFunc("/admin/log-level", blossomHandler.HandleLogLevel)
+
+	// State exchange between cluster instances
+	if clusterState != nil {
+		mux.HandleFunc(cluster.SyncPath, clusterState.HandleSync)
+	}
+
 	// Upload endpoint
 	mux.HandleFunc("/upload", blossomHandler.HandleUpload)
 
@@ -134,7 +452,7 @@ func main() {
 	// Create HTTP server
 	server := &http.Server{
 		Addr:    cfg.Server.ListenAddr,
-		Handler: mux,
+		Handler: logging.Middleware(tracing.Middleware(recovery.Middleware(blossomHandler.RateLimit(mux)))),
 	}
 
 	// Setup graceful shutdown
@@ -143,16 +461,60 @@ func main() {
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Starting Blossom proxy server on %s", cfg.Server.ListenAddr)
+		log.Printf("Starting Blossom proxy server %s on %s", version.Version, cfg.Server.ListenAddr)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
+			logging.Fatalf("Server failed: %v", err)
 		}
 	}()
 
-	// Wait for interrupt signal
-	<-sigChan
+	// A new upstream set published in the discovery event is applied by restarting the
+	// process, once the configuration it leads to was validated
+	restartChan := make(chan struct{}, 1)
+	if upstreamDiscovery != nil {
+		upstreamDiscovery.Start(bgCtx, func(prepare func(*config.Config) error) error {
+			_, err := config.LoadWith(*configPath, prepare)
+			return err
+		}, func() {
+			restartChan <- struct{}{}
+		})
+	}
+
+	// Wait for interrupt signal or an upstream set change
+	select {
+	case <-sigChan:
+	case <-restartChan:
+		logging.Warnf(context.Background(), "Restarting to apply the new upstream set (in-flight requests get %v to finish)", restartShutdownTimeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), restartShutdownTimeout)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logging.Warnf(context.Background(), "Graceful shutdown incomplete: %v", err)
+		}
+		cancel()
+		bgCancel()
+		if pendingOps != nil {
+			pendingOps.Close()
+		}
+		if err := cache.Close(); err != nil {
+			logging.Warnf(context.Background(), "Failed to save cache: %v", err)
+		}
+		flushTraces(shutdownTracing)
+		if err := restartProcess(); err != nil {
+			// The process manager (e.g. Docker's restart policy) has to start it again
+			logging.Fatalf("Failed to restart: %v", err)
+		}
+	}
 	log.Println("Shutting down server...")
+	bgCancel()
+	flushTraces(shutdownTracing)
 
 	// Server shutdown is handled automatically by the OS
 	// In a production environment, you might want to use server.Shutdown(context)
 }
+
+// flushTraces exports the spans still pending before the process exits or restarts
+func flushTraces(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		logging.Warnf(ctx, "Failed to export pending traces: %v", err)
+	}
+}
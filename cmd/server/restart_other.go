@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// restartProcess can't replace the process on this platform; the caller exits instead
+func restartProcess() error {
+	return errors.New("restarting in place is not supported on this platform")
+}
@@ -0,0 +1,80 @@
+package activity
+
+import (
+	"sync"
+	"time"
+)
+
+// Outcomes of a recorded operation
+const (
+	OutcomeSuccess = "success" // Stored on (or deleted from) every targeted server
+	OutcomePartial = "partial" // Quorum reached, but some targeted servers failed
+	OutcomeFailed  = "failed"
+	OutcomeAborted = "aborted" // The client went away mid-upload
+	OutcomeQueued  = "queued"  // Kept locally while no upstream is healthy
+)
+
+// Event is one upload, mirror or delete handled by the proxy
+type Event struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"` // upload, mirror or delete
+	Hash      string    `json:"hash"`      // Empty if the upload failed before its hash was known
+	Size      int64     `json:"size"`      // Bytes received (-1 if unknown, e.g. deletes and mirrors)
+	Outcome   string    `json:"outcome"`
+	Servers   int       `json:"servers"` // Servers where the operation succeeded
+	Targets   int       `json:"targets"` // Servers the operation was sent to
+}
+
+// Feed keeps the latest events in memory, in a fixed-size ring
+// A nil *Feed (activity feed disabled) ignores everything
+type Feed struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+	size   int
+}
+
+// New creates a feed keeping the latest size events, or returns nil if size is not positive
+func New(size int) *Feed {
+	if size <= 0 {
+		return nil
+	}
+	return &Feed{
+		events: make([]Event, 0, size),
+		size:   size,
+	}
+}
+
+// Record adds an event, replacing the oldest one once the feed is full
+func (f *Feed) Record(event Event) {
+	if f == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.events) < f.size {
+		f.events = append(f.events, event)
+		return
+	}
+	f.events[f.next] = event
+	f.next = (f.next + 1) % f.size
+}
+
+// Recent returns the events in the feed, newest first
+func (f *Feed) Recent() []Event {
+	if f == nil {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	recent := make([]Event, 0, len(f.events))
+	for i := len(f.events) - 1; i >= 0; i-- {
+		recent = append(recent, f.events[(f.next+i)%len(f.events)])
+	}
+	return recent
+}
@@ -1,18 +1,26 @@
 package auth
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/girino/blossom_espelhator/internal/logging"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
 )
 
+// maxClockSkew is how far in the future an event's created_at may be, to tolerate clients
+// whose clock is slightly ahead
+const maxClockSkew = time.Minute
+
 // AuthError represents an authentication error
 type AuthError struct {
 	Reason string
@@ -52,6 +60,8 @@ func ParseAuthorizationHeader(authHeader string) (*nostr.Event, error) {
 }
 
 // ValidateEvent validates a Nostr authorization event per BUD-01
+// The "t" tag must match requiredVerb (not checked if requiredVerb is empty), created_at
+// must be in the past and the "expiration" tag in the future
 // Returns error with HTTP status code if validation fails
 func ValidateEvent(event *nostr.Event, requiredVerb string, allowedPubkeys map[string]bool, verbose bool) error {
 	if event == nil {
@@ -77,7 +87,7 @@ func ValidateEvent(event *nostr.Event, requiredVerb string, allowedPubkeys map[s
 	valid, err := event.CheckSignature()
 	if err != nil {
 		if verbose {
-			log.Printf("[DEBUG] Auth: signature verification error: %v", err)
+			logging.Debugf(context.Background(), logging.Auth, "Auth: signature verification error: %v", err)
 		}
 		return &AuthError{Reason: fmt.Sprintf("Failed to verify signature: %v", err), Code: http.StatusUnauthorized}
 	}
@@ -85,7 +95,31 @@ func ValidateEvent(event *nostr.Event, requiredVerb string, allowedPubkeys map[s
 		return &AuthError{Reason: "Invalid signature", Code: http.StatusUnauthorized}
 	}
 
-	// 4. Check pubkey is in allowed list
+	// 4. Check the event is current: created in the past, not expired yet
+	now := time.Now()
+	if event.CreatedAt.Time().After(now.Add(maxClockSkew)) {
+		return &AuthError{Reason: "Authorization event created_at is in the future", Code: http.StatusUnauthorized}
+	}
+	expiration := event.Tags.Find("expiration")
+	if expiration == nil {
+		return &AuthError{Reason: "Authorization event has no expiration tag", Code: http.StatusUnauthorized}
+	}
+	expiresAt, err := strconv.ParseInt(expiration[1], 10, 64)
+	if err != nil {
+		return &AuthError{Reason: "Authorization event has an invalid expiration tag", Code: http.StatusUnauthorized}
+	}
+	if expiresAt <= now.Unix() {
+		return &AuthError{Reason: "Authorization event has expired", Code: http.StatusUnauthorized}
+	}
+
+	// 5. Check the verb matches the endpoint
+	if requiredVerb != "" {
+		if verb := event.Tags.Find("t"); verb == nil || verb[1] != requiredVerb {
+			return &AuthError{Reason: fmt.Sprintf("Authorization event must have a \"t\" tag with value %q", requiredVerb), Code: http.StatusUnauthorized}
+		}
+	}
+
+	// 6. Check pubkey is in allowed list
 	if len(allowedPubkeys) > 0 {
 		pubkeyLower := strings.ToLower(event.PubKey)
 		if !allowedPubkeys[pubkeyLower] {
@@ -94,12 +128,43 @@ func ValidateEvent(event *nostr.Event, requiredVerb string, allowedPubkeys map[s
 	}
 
 	if verbose {
-		log.Printf("[DEBUG] Auth: validated event - pubkey=%s", event.PubKey)
+		logging.Debugf(context.Background(), logging.Auth, "Auth: validated event - pubkey=%s", event.PubKey)
 	}
 
 	return nil
 }
 
+// Verbs of the proxy's own endpoints, never sent to upstreams: events for them can't be
+// replayed from the upload, delete, list or get events forwarded to the upstream servers
+const (
+	VerbAdmin  = "admin"  // /admin/* endpoints (with "method" and "u" tags, see ValidateRequestBinding)
+	VerbStatus = "status" // Status pages (/stats, /health, the home page, ...)
+)
+
+// ValidateRequestBinding checks that an event was signed for this very request (as in
+// NIP-98): its "method" tag must be the request method and its "u" tag a URL with the
+// request's path and query. The host isn't compared, as reverse proxies may rewrite it
+func ValidateRequestBinding(event *nostr.Event, r *http.Request) error {
+	method := event.Tags.Find("method")
+	if method == nil || !strings.EqualFold(method[1], r.Method) {
+		return &AuthError{Reason: fmt.Sprintf("Authorization event must have a \"method\" tag with value %q", r.Method), Code: http.StatusUnauthorized}
+	}
+	u := event.Tags.Find("u")
+	if u == nil {
+		return &AuthError{Reason: "Authorization event must have a \"u\" tag with the request URL", Code: http.StatusUnauthorized}
+	}
+	signed, err := url.Parse(u[1])
+	if err != nil || signed.RequestURI() != r.URL.RequestURI() {
+		return &AuthError{Reason: fmt.Sprintf("Authorization event \"u\" tag doesn't match the request URL %s", r.URL.RequestURI()), Code: http.StatusUnauthorized}
+	}
+	return nil
+}
+
+// NormalizePubkey converts a pubkey (hex or npub) to lowercase hex
+func NormalizePubkey(input string) (string, error) {
+	return normalizePubkey(input)
+}
+
 // normalizePubkey converts a pubkey string (hex or npub format) to normalized hex format (lowercase, 64 chars)
 // Returns the hex pubkey and an error if conversion fails
 func normalizePubkey(input string) (string, error) {
@@ -167,7 +232,7 @@ func BuildAllowedPubkeysMap(allowedPubkeys []string) map[string]bool {
 	for _, pubkey := range allowedPubkeys {
 		normalized, err := normalizePubkey(pubkey)
 		if err != nil {
-			log.Printf("[WARN] Invalid pubkey in allowed_pubkeys configuration: %s (error: %v)", pubkey, err)
+			logging.Warnf(context.Background(), "Invalid pubkey in allowed_pubkeys configuration: %s (error: %v)", pubkey, err)
 			continue
 		}
 		m[normalized] = true
@@ -178,19 +243,84 @@ func BuildAllowedPubkeysMap(allowedPubkeys []string) map[string]bool {
 // ValidateAuth validates the Authorization header for a request
 // Returns the pubkey if valid, or an error with HTTP status code
 func ValidateAuth(r *http.Request, requiredVerb string, allowedPubkeys map[string]bool, verbose bool) (string, error) {
+	event, err := Authenticate(r, requiredVerb, allowedPubkeys, verbose)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(event.PubKey), nil
+}
+
+// Authenticate validates the Authorization header for a request like ValidateAuth, but
+// returns the whole event (e.g. for its expiration or "x" tags)
+func Authenticate(r *http.Request, requiredVerb string, allowedPubkeys map[string]bool, verbose bool) (*nostr.Event, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		return "", &AuthError{Reason: "Authorization header not found", Code: http.StatusUnauthorized}
+		return nil, &AuthError{Reason: "Authorization header not found", Code: http.StatusUnauthorized}
 	}
 
 	event, err := ParseAuthorizationHeader(authHeader)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if err := ValidateEvent(event, requiredVerb, allowedPubkeys, verbose); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return strings.ToLower(event.PubKey), nil
+	return event, nil
+}
+
+// NormalizeSecretKey converts a secret key string (hex or nsec bech32 format) to lowercase hex
+func NormalizeSecretKey(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if strings.HasPrefix(strings.ToLower(input), "nsec") {
+		typ, data, err := nip19.Decode(input)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode nsec: %w", err)
+		}
+		if typ != "nsec" {
+			return "", fmt.Errorf("decoded type is not nsec: %s", typ)
+		}
+		switch v := data.(type) {
+		case string:
+			input = v
+		case []byte:
+			input = hex.EncodeToString(v)
+		default:
+			return "", fmt.Errorf("unexpected data type from nip19.Decode for secret key: %T", data)
+		}
+	}
+
+	secretKey := strings.ToLower(input)
+	if len(secretKey) != 64 {
+		return "", fmt.Errorf("secret key has wrong length: %d (expected 64 hex characters)", len(secretKey))
+	}
+	if _, err := hex.DecodeString(secretKey); err != nil {
+		return "", fmt.Errorf("secret key is not valid hex: %w", err)
+	}
+	return secretKey, nil
+}
+
+// SignAuthorization creates a BUD-01 Authorization header value ("Nostr <base64 event>")
+// for the given verb and blob hash, signed with secretKey (hex) and valid for ttl
+func SignAuthorization(secretKey string, verb string, hash string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	event := nostr.Event{
+		Kind:      24242,
+		CreatedAt: nostr.Timestamp(now.Unix()),
+		Tags: nostr.Tags{
+			{"t", verb},
+			{"x", hash},
+			{"expiration", strconv.FormatInt(now.Add(ttl).Unix(), 10)},
+		},
+		Content: "blossom_espelhator " + verb,
+	}
+	if err := event.Sign(secretKey); err != nil {
+		return "", fmt.Errorf("failed to sign authorization event: %w", err)
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(eventJSON), nil
 }
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// signedEvent returns a kind 24242 event signed with secretKey, created now with tags
+// (edit may change it before it is signed)
+func signedEvent(t *testing.T, secretKey string, tags nostr.Tags, edit func(*nostr.Event)) *nostr.Event {
+	t.Helper()
+	event := &nostr.Event{
+		Kind:      24242,
+		CreatedAt: nostr.Now(),
+		Tags:      tags,
+	}
+	if edit != nil {
+		edit(event)
+	}
+	if err := event.Sign(secretKey); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func expiresIn(d time.Duration) nostr.Tag {
+	return nostr.Tag{"expiration", strconv.FormatInt(time.Now().Add(d).Unix(), 10)}
+}
+
+func TestValidateEvent(t *testing.T) {
+	secretKey := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(secretKey)
+	otherKey := nostr.GeneratePrivateKey()
+
+	tests := []struct {
+		name    string
+		event   *nostr.Event
+		verb    string
+		allowed map[string]bool
+		code    int // 0 = valid
+	}{
+		{"valid", signedEvent(t, secretKey, nostr.Tags{{"t", "upload"}, expiresIn(time.Minute)}, nil), "upload", nil, 0},
+		{"any verb", signedEvent(t, secretKey, nostr.Tags{{"t", "list"}, expiresIn(time.Minute)}, nil), "", nil, 0},
+		{"allowed pubkey", signedEvent(t, secretKey, nostr.Tags{{"t", "upload"}, expiresIn(time.Minute)}, nil), "upload", map[string]bool{pubkey: true}, 0},
+		{"no event", nil, "upload", nil, http.StatusUnauthorized},
+		{"wrong kind", signedEvent(t, secretKey, nostr.Tags{{"t", "upload"}, expiresIn(time.Minute)}, func(e *nostr.Event) { e.Kind = 1 }), "upload", nil, http.StatusUnauthorized},
+		{"wrong verb", signedEvent(t, secretKey, nostr.Tags{{"t", "delete"}, expiresIn(time.Minute)}, nil), "upload", nil, http.StatusUnauthorized},
+		{"no verb", signedEvent(t, secretKey, nostr.Tags{expiresIn(time.Minute)}, nil), "upload", nil, http.StatusUnauthorized},
+		{"expired", signedEvent(t, secretKey, nostr.Tags{{"t", "upload"}, expiresIn(-time.Minute)}, nil), "upload", nil, http.StatusUnauthorized},
+		{"no expiration", signedEvent(t, secretKey, nostr.Tags{{"t", "upload"}}, nil), "upload", nil, http.StatusUnauthorized},
+		{"invalid expiration", signedEvent(t, secretKey, nostr.Tags{{"t", "upload"}, {"expiration", "soon"}}, nil), "upload", nil, http.StatusUnauthorized},
+		{"created in the future", signedEvent(t, secretKey, nostr.Tags{{"t", "upload"}, expiresIn(time.Hour)}, func(e *nostr.Event) {
+			e.CreatedAt = nostr.Timestamp(time.Now().Add(10 * time.Minute).Unix())
+		}), "upload", nil, http.StatusUnauthorized},
+		{"pubkey not allowed", signedEvent(t, otherKey, nostr.Tags{{"t", "upload"}, expiresIn(time.Minute)}, nil), "upload", map[string]bool{pubkey: true}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateEvent(tt.event, tt.verb, tt.allowed, false)
+			if tt.code == 0 {
+				if err != nil {
+					t.Errorf("ValidateEvent: %v, want valid", err)
+				}
+				return
+			}
+			var authErr *AuthError
+			if !errors.As(err, &authErr) || authErr.Code != tt.code {
+				t.Errorf("ValidateEvent error = %v, want an AuthError with code %d", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestValidateEventRejectsTamperedEvent(t *testing.T) {
+	event := signedEvent(t, nostr.GeneratePrivateKey(), nostr.Tags{{"t", "upload"}, expiresIn(time.Minute)}, nil)
+	event.Tags = append(event.Tags, nostr.Tag{"x", "0000000000000000000000000000000000000000000000000000000000000000"})
+	if err := ValidateEvent(event, "upload", nil, false); err == nil {
+		t.Error("event with tags added after signing was accepted")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	secretKey := nostr.GeneratePrivateKey()
+	header, err := SignAuthorization(secretKey, "upload", "ab", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventJSON, _ := json.Marshal(signedEvent(t, secretKey, nostr.Tags{{"t", "upload"}, expiresIn(time.Minute)}, nil))
+
+	tests := []struct {
+		name   string
+		header string
+		valid  bool
+	}{
+		{"signed header", header, true},
+		{"lowercase scheme", "nostr " + base64.StdEncoding.EncodeToString(eventJSON), true},
+		{"missing", "", false},
+		{"other scheme", "Bearer " + base64.StdEncoding.EncodeToString(eventJSON), false},
+		{"not base64", "Nostr !!!", false},
+		{"not an event", "Nostr " + base64.StdEncoding.EncodeToString([]byte("{")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/upload", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			event, err := Authenticate(r, "upload", nil, false)
+			if tt.valid && (err != nil || event == nil) {
+				t.Errorf("Authenticate: %v, want the event", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Authenticate accepted the header")
+			}
+		})
+	}
+}
+
+func TestValidateRequestBinding(t *testing.T) {
+	secretKey := nostr.GeneratePrivateKey()
+	r := httptest.NewRequest(http.MethodPost, "/admin/servers?pause=1", nil)
+
+	tests := []struct {
+		name  string
+		tags  nostr.Tags
+		valid bool
+	}{
+		{"bound", nostr.Tags{{"method", "POST"}, {"u", "https://proxy.example.com/admin/servers?pause=1"}}, true},
+		{"other method", nostr.Tags{{"method", "DELETE"}, {"u", "https://proxy.example.com/admin/servers?pause=1"}}, false},
+		{"other path", nostr.Tags{{"method", "POST"}, {"u", "https://proxy.example.com/admin/blocklist"}}, false},
+		{"other query", nostr.Tags{{"method", "POST"}, {"u", "https://proxy.example.com/admin/servers"}}, false},
+		{"no u tag", nostr.Tags{{"method", "POST"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRequestBinding(signedEvent(t, secretKey, tt.tags, nil), r)
+			if (err == nil) != tt.valid {
+				t.Errorf("ValidateRequestBinding error = %v, want valid = %v", err, tt.valid)
+			}
+		})
+	}
+}
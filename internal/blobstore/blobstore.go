@@ -0,0 +1,434 @@
+package blobstore
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// typeSuffix names the file next to each blob holding its content type
+const typeSuffix = ".type"
+
+// Store caches downloaded blobs on local disk so repeat downloads of hot blobs are served
+// by the proxy without contacting upstream servers. The total size is bounded by maxBytes,
+// evicting the least recently used blobs; blobs are only stored once their SHA-256 matches
+// their hash. With an admission policy (see SetAdmission), blobs only get in when they are
+// popular enough. All methods are safe to call on a nil *Store (caching disabled)
+type Store struct {
+	mu           sync.Mutex
+	dir          string
+	maxBytes     int64
+	maxBlobBytes int64
+	entries      map[string]*list.Element // Values are *entry, keyed by hash
+	lru          *list.List               // Most recently used first
+	size         int64
+	filling      map[string]bool // Hashes being written
+	hits         int64
+	misses       int64
+	rejected     int64                     // Blobs not stored by the admission policy
+	popularity   func(hash string) float64 // Popularity score of a blob (nil = admit every blob)
+	minScore     float64                   // Popularity score a blob needs to be stored
+	blocked      func(hash string) bool    // Reports hashes that are never stored (nil = none)
+	verbose      *logging.Flag
+}
+
+// entry is a stored blob
+type entry struct {
+	hash        string
+	contentType string
+	size        int64
+	modTime     time.Time
+}
+
+// Info describes a stored blob
+type Info struct {
+	ContentType string
+	Size        int64
+	ModTime     time.Time // When the blob was stored
+}
+
+// Counters are the usage counters of the store
+type Counters struct {
+	Entries  int     `json:"entries"`
+	Bytes    int64   `json:"bytes"`
+	MaxBytes int64   `json:"max_bytes"`
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRate  float64 `json:"hit_rate"`
+	Rejected int64   `json:"rejected"` // Blobs not stored by the admission policy
+}
+
+// New creates a store in dir, picking up the blobs left there by a previous run
+// maxBytes bounds the total size of the stored blobs; blobs larger than maxBlobBytes are
+// never stored
+func New(dir string, maxBytes int64, maxBlobBytes int64, verbose *logging.Flag) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create blob cache directory: %w", err)
+	}
+	s := &Store{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		maxBlobBytes: maxBlobBytes,
+		entries:      make(map[string]*list.Element),
+		lru:          list.New(),
+		filling:      make(map[string]bool),
+		verbose:      verbose,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load indexes the blobs already in the directory, the most recently stored being treated
+// as the most recently used, and removes leftovers of interrupted writes
+func (s *Store) load() error {
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read blob cache directory: %w", err)
+	}
+
+	var loaded []*entry
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(s.dir, name))
+			continue
+		}
+		if !isHash(name) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		contentType, _ := os.ReadFile(filepath.Join(s.dir, name+typeSuffix))
+		loaded = append(loaded, &entry{
+			hash:        name,
+			contentType: string(contentType),
+			size:        info.Size(),
+			modTime:     info.ModTime(),
+		})
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].modTime.After(loaded[j].modTime) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range loaded {
+		s.entries[e.hash] = s.lru.PushBack(e)
+		s.size += e.size
+	}
+	s.evictLocked()
+
+	if len(s.entries) > 0 {
+		log.Printf("Blob cache: loaded %d blobs (%d bytes) from %s", len(s.entries), s.size, s.dir)
+	}
+	return nil
+}
+
+// isHash reports whether name is a lowercase hex SHA-256
+func isHash(name string) bool {
+	if len(name) != 64 {
+		return false
+	}
+	for _, c := range name {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// blobPath returns the path of a stored blob
+func (s *Store) blobPath(hash string) string {
+	return filepath.Join(s.dir, hash)
+}
+
+// Open returns the stored blob with the given hash, counting a hit or a miss
+// The caller must close the file
+func (s *Store) Open(hash string) (*os.File, Info, bool) {
+	if s == nil {
+		return nil, Info{}, false
+	}
+	hash = strings.ToLower(hash)
+
+	s.mu.Lock()
+	elem, exists := s.entries[hash]
+	if !exists {
+		s.misses++
+		s.mu.Unlock()
+		return nil, Info{}, false
+	}
+	s.lru.MoveToFront(elem)
+	e := elem.Value.(*entry)
+	info := Info{ContentType: e.contentType, Size: e.size, ModTime: e.modTime}
+	s.mu.Unlock()
+
+	file, err := os.Open(s.blobPath(hash))
+	if err != nil {
+		// Removed behind our back - forget it
+		s.Remove(hash)
+		s.mu.Lock()
+		s.misses++
+		s.mu.Unlock()
+		return nil, Info{}, false
+	}
+
+	s.mu.Lock()
+	s.hits++
+	s.mu.Unlock()
+	return file, info, true
+}
+
+// Remove deletes a stored blob (e.g., after it was deleted upstream)
+func (s *Store) Remove(hash string) {
+	if s == nil {
+		return
+	}
+	hash = strings.ToLower(hash)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, exists := s.entries[hash]; exists {
+		s.removeLocked(elem)
+	}
+}
+
+// removeLocked deletes a stored blob and its files (must be called with lock held)
+func (s *Store) removeLocked(elem *list.Element) {
+	e := elem.Value.(*entry)
+	s.lru.Remove(elem)
+	delete(s.entries, e.hash)
+	s.size -= e.size
+	os.Remove(s.blobPath(e.hash))
+	os.Remove(s.blobPath(e.hash) + typeSuffix)
+}
+
+// evictLocked removes the least recently used blobs until the store fits in maxBytes
+// (must be called with lock held)
+func (s *Store) evictLocked() {
+	for s.size > s.maxBytes && s.lru.Len() > 0 {
+		oldest := s.lru.Back()
+		s.verbose.Debugf(context.Background(), "Blob cache: evicting %s (%d bytes)", oldest.Value.(*entry).hash, oldest.Value.(*entry).size)
+		s.removeLocked(oldest)
+	}
+}
+
+// SetAdmission only stores blobs whose popularity score is at least minScore, and that
+// are not less popular than the blobs they would evict, so a burst of one-off downloads
+// doesn't flush the hot blobs out of the store
+func (s *Store) SetAdmission(popularity func(hash string) float64, minScore float64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.popularity = popularity
+	s.minScore = minScore
+}
+
+// SetBlocked refuses to store the blobs whose hash blocked reports (e.g. the blocklist)
+// It is checked again when a blob is committed, so a download already running can't store
+// a blob removed when its hash was blocked
+func (s *Store) SetBlocked(blocked func(hash string) bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocked = blocked
+}
+
+// blockedLocked reports whether hash may not be stored (must be called with lock held)
+func (s *Store) blockedLocked(hash string) bool {
+	return s.blocked != nil && s.blocked(hash)
+}
+
+// admitLocked reports whether the admission policy lets a blob of the given size (-1 if
+// unknown) in (must be called with lock held)
+func (s *Store) admitLocked(hash string, size int64) bool {
+	if s.popularity == nil {
+		return true
+	}
+	score := s.popularity(hash)
+	if score < s.minScore {
+		return false
+	}
+	if size < 0 {
+		return true
+	}
+	// Compare with the blobs eviction would remove, least recently used first
+	freed := s.maxBytes - s.size
+	for elem := s.lru.Back(); elem != nil && freed < size; elem = elem.Prev() {
+		victim := elem.Value.(*entry)
+		if s.popularity(victim.hash) > score {
+			return false
+		}
+		freed += victim.size
+	}
+	return true
+}
+
+// Counters returns the usage counters of the store
+func (s *Store) Counters() Counters {
+	if s == nil {
+		return Counters{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counters := Counters{
+		Entries:  len(s.entries),
+		Bytes:    s.size,
+		MaxBytes: s.maxBytes,
+		Hits:     s.hits,
+		Misses:   s.misses,
+		Rejected: s.rejected,
+	}
+	if lookups := s.hits + s.misses; lookups > 0 {
+		counters.HitRate = float64(s.hits) / float64(lookups)
+	}
+	return counters
+}
+
+// Writer stores a blob while it is downloaded
+// Write never fails, so storage problems cannot break the download it is fed from
+type Writer struct {
+	store       *Store
+	hash        string
+	contentType string
+	file        *os.File
+	hasher      hash.Hash
+	written     int64
+	failed      error
+}
+
+// Create starts storing a blob of the given size (-1 if unknown)
+// Returns nil if the blob is not to be stored: caching disabled, already stored or being
+// stored, larger than the blob size limit, blocked (see SetBlocked), or not admitted (see
+// SetAdmission)
+func (s *Store) Create(hash string, contentType string, size int64) *Writer {
+	if s == nil || size > s.maxBlobBytes || size > s.maxBytes {
+		return nil
+	}
+	hash = strings.ToLower(hash)
+
+	s.mu.Lock()
+	_, exists := s.entries[hash]
+	if exists || s.filling[hash] || s.blockedLocked(hash) {
+		s.mu.Unlock()
+		return nil
+	}
+	if !s.admitLocked(hash, size) {
+		s.rejected++
+		s.mu.Unlock()
+		s.verbose.Debugf(context.Background(), "Blob cache: not admitting %s (not popular enough)", hash)
+		return nil
+	}
+	s.filling[hash] = true
+	s.mu.Unlock()
+
+	file, err := os.CreateTemp(s.dir, hash+"-*.tmp")
+	if err != nil {
+		logging.Warnf(context.Background(), "Blob cache: failed to create file: %v", err)
+		s.mu.Lock()
+		delete(s.filling, hash)
+		s.mu.Unlock()
+		return nil
+	}
+	return &Writer{store: s, hash: hash, contentType: contentType, file: file, hasher: sha256.New()}
+}
+
+// Write appends data to the blob being stored
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.failed != nil {
+		return len(p), nil
+	}
+	if w.written+int64(len(p)) > w.store.maxBlobBytes {
+		w.failed = fmt.Errorf("blob exceeds %d bytes", w.store.maxBlobBytes)
+		return len(p), nil
+	}
+	n, err := w.file.Write(p)
+	w.hasher.Write(p[:n])
+	w.written += int64(n)
+	if err != nil {
+		w.failed = err
+	}
+	return len(p), nil
+}
+
+// Commit stores the blob once it was fully written, if its SHA-256 matches its hash
+func (w *Writer) Commit() error {
+	if w == nil {
+		return nil
+	}
+	s := w.store
+	tmpPath := w.file.Name()
+	closeErr := w.file.Close()
+
+	err := w.failed
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if sum := hex.EncodeToString(w.hasher.Sum(nil)); sum != w.hash {
+			err = fmt.Errorf("hash mismatch (got %s)", sum)
+		}
+	}
+	if err == nil && w.contentType != "" {
+		err = os.WriteFile(s.blobPath(w.hash)+typeSuffix, []byte(w.contentType), 0o600)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, s.blobPath(w.hash))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.filling, w.hash)
+	if err == nil && s.blockedLocked(w.hash) {
+		os.Remove(s.blobPath(w.hash))
+		err = fmt.Errorf("blob is blocked")
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		os.Remove(s.blobPath(w.hash) + typeSuffix)
+		return fmt.Errorf("blob cache: not storing %s: %w", w.hash, err)
+	}
+
+	s.entries[w.hash] = s.lru.PushFront(&entry{
+		hash:        w.hash,
+		contentType: w.contentType,
+		size:        w.written,
+		modTime:     time.Now(),
+	})
+	s.size += w.written
+	s.evictLocked()
+
+	s.verbose.Debugf(context.Background(), "Blob cache: stored %s (%d bytes, %d blobs, %d bytes total)", w.hash, w.written, len(s.entries), s.size)
+	return nil
+}
+
+// Abort discards a blob that was not fully downloaded
+func (w *Writer) Abort() {
+	if w == nil {
+		return
+	}
+	w.file.Close()
+	os.Remove(w.file.Name())
+
+	w.store.mu.Lock()
+	delete(w.store.filling, w.hash)
+	w.store.mu.Unlock()
+}
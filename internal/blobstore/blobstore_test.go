@@ -0,0 +1,236 @@
+package blobstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+func newTestStore(t *testing.T, dir string, maxBytes int64, maxBlobBytes int64) *Store {
+	t.Helper()
+	s, err := New(dir, maxBytes, maxBlobBytes, logging.NewLevels(nil).Flag(logging.Cache))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s
+}
+
+func hashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// store writes data to s under hash and commits it
+func store(s *Store, hash string, data []byte) error {
+	w := s.Create(hash, "image/png", int64(len(data)))
+	if w == nil {
+		return os.ErrExist
+	}
+	w.Write(data)
+	return w.Commit()
+}
+
+// read returns the stored blob with the given hash, or nil if it isn't stored
+func read(t *testing.T, s *Store, hash string) []byte {
+	t.Helper()
+	f, _, ok := s.Open(hash)
+	if !ok {
+		return nil
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestStoreAndOpen(t *testing.T) {
+	s := newTestStore(t, t.TempDir(), 1<<20, 1<<20)
+	blob := []byte("cached blob")
+	hash := hashOf(blob)
+
+	if err := store(s, hash, blob); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	f, info, ok := s.Open(hash)
+	if !ok {
+		t.Fatal("stored blob not found")
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if !bytes.Equal(data, blob) || info.ContentType != "image/png" || info.Size != int64(len(blob)) {
+		t.Errorf("got %q, %+v; want the blob with its content type and size", data, info)
+	}
+	if s.Create(hash, "", int64(len(blob))) != nil {
+		t.Error("Create returned a writer for a blob already stored")
+	}
+
+	s.Open(hashOf([]byte("missing")))
+	if counters := s.Counters(); counters.Hits != 1 || counters.Misses != 1 || counters.Entries != 1 || counters.Bytes != int64(len(blob)) {
+		t.Errorf("counters = %+v, want 1 hit, 1 miss, 1 entry of %d bytes", counters, len(blob))
+	}
+
+	s.Remove(hash)
+	if read(t, s, hash) != nil {
+		t.Error("removed blob still served")
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, hash)); !os.IsNotExist(err) {
+		t.Error("removed blob still on disk")
+	}
+}
+
+func TestStoreRejectsHashMismatch(t *testing.T) {
+	s := newTestStore(t, t.TempDir(), 1<<20, 1<<20)
+	hash := hashOf([]byte("expected"))
+
+	if err := store(s, hash, []byte("something else")); err == nil {
+		t.Fatal("blob with the wrong content was stored")
+	}
+	if read(t, s, hash) != nil {
+		t.Error("blob with the wrong content is served")
+	}
+	if entries, _ := os.ReadDir(s.dir); len(entries) != 0 {
+		t.Errorf("%d files left behind, want none", len(entries))
+	}
+	// The hash can be stored once the right content comes along
+	if err := store(s, hash, []byte("expected")); err != nil {
+		t.Errorf("store after a mismatch: %v", err)
+	}
+}
+
+func TestStoreSizeLimits(t *testing.T) {
+	s := newTestStore(t, t.TempDir(), 100, 40)
+
+	big := bytes.Repeat([]byte("b"), 41)
+	if s.Create(hashOf(big), "", int64(len(big))) != nil {
+		t.Error("Create returned a writer for a blob over max_blob_bytes")
+	}
+	// A blob of unknown size is dropped once it grows past the limit
+	if err := func() error {
+		w := s.Create(hashOf(big), "", -1)
+		w.Write(big)
+		return w.Commit()
+	}(); err == nil {
+		t.Error("blob of unknown size over max_blob_bytes was stored")
+	}
+
+	// The least recently used blobs are evicted to stay within max_bytes
+	var hashes []string
+	for _, c := range []string{"1", "2", "3"} {
+		blob := bytes.Repeat([]byte(c), 40)
+		hashes = append(hashes, hashOf(blob))
+		if err := store(s, hashOf(blob), blob); err != nil {
+			t.Fatalf("store %s: %v", c, err)
+		}
+		if c == "2" {
+			read(t, s, hashes[0]) // Blob 1 was used more recently than blob 2
+		}
+	}
+	if read(t, s, hashes[1]) != nil {
+		t.Error("least recently used blob was not evicted")
+	}
+	if read(t, s, hashes[0]) == nil || read(t, s, hashes[2]) == nil {
+		t.Error("recently used blobs were evicted")
+	}
+	if used := s.Counters().Bytes; used > 100 {
+		t.Errorf("store holds %d bytes, want at most 100", used)
+	}
+}
+
+func TestStoreReloadsBlobs(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, 1<<20, 1<<20)
+	blob := []byte("survives restarts")
+	hash := hashOf(blob)
+	if err := store(s, hash, blob); err != nil {
+		t.Fatal(err)
+	}
+	// Leftover of a write interrupted by a crash
+	if err := os.WriteFile(filepath.Join(dir, hash+"-123.tmp"), []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := newTestStore(t, dir, 1<<20, 1<<20)
+	f, info, ok := reloaded.Open(hash)
+	if !ok {
+		t.Fatal("blob stored before the restart not found")
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if !bytes.Equal(data, blob) || info.ContentType != "image/png" {
+		t.Errorf("reloaded blob = %q (%s), want %q (image/png)", data, info.ContentType, blob)
+	}
+	if _, err := os.Stat(filepath.Join(dir, hash+"-123.tmp")); !os.IsNotExist(err) {
+		t.Error("leftover temporary file was not removed")
+	}
+}
+
+func TestAbortAndNilStore(t *testing.T) {
+	s := newTestStore(t, t.TempDir(), 1<<20, 1<<20)
+	blob := []byte("interrupted download")
+	hash := hashOf(blob)
+
+	w := s.Create(hash, "", int64(len(blob)))
+	if s.Create(hash, "", int64(len(blob))) != nil {
+		t.Error("Create returned a second writer for a blob being stored")
+	}
+	w.Write(blob[:5])
+	w.Abort()
+	if read(t, s, hash) != nil {
+		t.Error("aborted blob is served")
+	}
+	if retry := s.Create(hash, "", int64(len(blob))); retry == nil {
+		t.Error("blob can't be stored again after an aborted write")
+	} else {
+		retry.Abort()
+	}
+
+	var disabled *Store
+	if disabled.Create(hash, "", 1) != nil {
+		t.Error("nil store returned a writer")
+	}
+	if _, _, ok := disabled.Open(hash); ok {
+		t.Error("nil store returned a blob")
+	}
+	disabled.Remove(hash)
+	if counters := disabled.Counters(); counters != (Counters{}) {
+		t.Errorf("nil store counters = %+v, want zero", counters)
+	}
+}
+
+func TestStoreRefusesBlockedBlobs(t *testing.T) {
+	s := newTestStore(t, t.TempDir(), 1<<20, 1<<20)
+	blob := []byte("blocked blob")
+	hash := hashOf(blob)
+	blocked := false
+	s.SetBlocked(func(h string) bool { return blocked && h == hash })
+
+	// A download running when the hash gets blocked isn't stored
+	w := s.Create(hash, "", int64(len(blob)))
+	w.Write(blob)
+	blocked = true
+	if err := w.Commit(); err == nil {
+		t.Error("blob blocked while it was downloaded was stored")
+	}
+	if read(t, s, hash) != nil {
+		t.Error("blocked blob is served")
+	}
+	if entries, _ := os.ReadDir(s.dir); len(entries) != 0 {
+		t.Errorf("%d files left behind, want none", len(entries))
+	}
+	if s.Create(hash, "", int64(len(blob))) != nil {
+		t.Error("Create returned a writer for a blocked blob")
+	}
+
+	other := []byte("another blob")
+	if err := store(s, hashOf(other), other); err != nil {
+		t.Errorf("store of another blob: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package blocklist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry describes a blocked blob
+type Entry struct {
+	Hash      string    `json:"hash"`
+	Reason    string    `json:"reason,omitempty"`
+	BlockedAt time.Time `json:"blocked_at"`
+}
+
+// Blocklist is a set of banned blob hashes, which the proxy refuses to upload, mirror or
+// serve. Changes are written to a JSON file (if configured) so they survive restarts
+// A nil *Blocklist blocks nothing
+type Blocklist struct {
+	mu      sync.RWMutex
+	path    string           // JSON file persisting the entries ("" keeps them in memory)
+	entries map[string]Entry // Hash -> entry
+}
+
+// Open loads the blocklist persisted at path, or starts an empty one if the file doesn't
+// exist yet. With an empty path, the blocklist is kept in memory only
+func Open(path string) (*Blocklist, error) {
+	b := &Blocklist{path: path, entries: make(map[string]Entry)}
+	if path == "" {
+		return b, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blocklist: %w", err)
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse blocklist %s: %w", path, err)
+	}
+	for _, entry := range entries {
+		entry.Hash = strings.ToLower(entry.Hash)
+		if !validHash(entry.Hash) {
+			return nil, fmt.Errorf("invalid hash %q in blocklist %s", entry.Hash, path)
+		}
+		b.entries[entry.Hash] = entry
+	}
+	return b, nil
+}
+
+// validHash reports whether s is a lowercase SHA-256 hex digest
+func validHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains reports whether hash is blocked
+func (b *Blocklist) Contains(hash string) bool {
+	if b == nil || hash == "" {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.entries) == 0 {
+		return false
+	}
+	_, blocked := b.entries[strings.ToLower(hash)]
+	return blocked
+}
+
+// Add blocks hash and persists the blocklist. Returns false if it was already blocked
+func (b *Blocklist) Add(hash string, reason string) (bool, error) {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if !validHash(hash) {
+		return false, fmt.Errorf("invalid hash %q (expected 64 hex characters)", hash)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, exists := b.entries[hash]; exists {
+		return false, nil
+	}
+	b.entries[hash] = Entry{Hash: hash, Reason: reason, BlockedAt: time.Now().UTC()}
+	if err := b.saveLocked(); err != nil {
+		delete(b.entries, hash)
+		return false, err
+	}
+	return true, nil
+}
+
+// Remove unblocks hash and persists the blocklist. Returns false if it wasn't blocked
+func (b *Blocklist) Remove(hash string) (bool, error) {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, exists := b.entries[hash]
+	if !exists {
+		return false, nil
+	}
+	delete(b.entries, hash)
+	if err := b.saveLocked(); err != nil {
+		b.entries[hash] = entry
+		return false, err
+	}
+	return true, nil
+}
+
+// Entries returns the blocked blobs, most recently blocked first
+func (b *Blocklist) Entries() []Entry {
+	if b == nil {
+		return nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.sortedLocked()
+}
+
+// Len returns the number of blocked hashes
+func (b *Blocklist) Len() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.entries)
+}
+
+// sortedLocked returns the entries, most recently blocked first (must be called with lock held)
+func (b *Blocklist) sortedLocked() []Entry {
+	entries := make([]Entry, 0, len(b.entries))
+	for _, entry := range b.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].BlockedAt.Equal(entries[j].BlockedAt) {
+			return entries[i].BlockedAt.After(entries[j].BlockedAt)
+		}
+		return entries[i].Hash < entries[j].Hash
+	})
+	return entries
+}
+
+// saveLocked writes the entries to the blocklist file, replacing it atomically (must be
+// called with lock held)
+func (b *Blocklist) saveLocked() error {
+	if b.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(b.sortedLocked(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode blocklist: %w", err)
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write blocklist: %w", err)
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		return fmt.Errorf("failed to write blocklist: %w", err)
+	}
+	return nil
+}
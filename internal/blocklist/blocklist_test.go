@@ -0,0 +1,84 @@
+package blocklist
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlocklistPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.json")
+	b, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	hash := strings.Repeat("ab", 32)
+
+	if added, err := b.Add(strings.ToUpper(hash), "abuse report"); err != nil || !added {
+		t.Fatalf("Add = %v, %v; want added", added, err)
+	}
+	if added, err := b.Add(hash, "again"); err != nil || added {
+		t.Errorf("second Add = %v, %v; want already blocked", added, err)
+	}
+	if _, err := b.Add("not-a-hash", ""); err == nil {
+		t.Error("invalid hash blocked")
+	}
+	if !b.Contains(hash) || !b.Contains(strings.ToUpper(hash)) {
+		t.Error("blocked hash not reported, whatever its case")
+	}
+	if b.Contains(strings.Repeat("cd", 32)) || b.Contains("") {
+		t.Error("hash reported blocked without being added")
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	entries := reopened.Entries()
+	if len(entries) != 1 || entries[0].Hash != hash || entries[0].Reason != "abuse report" {
+		t.Errorf("reopened entries = %+v, want the blocked hash with its reason", entries)
+	}
+
+	if removed, err := reopened.Remove(hash); err != nil || !removed {
+		t.Fatalf("Remove = %v, %v; want removed", removed, err)
+	}
+	if removed, _ := reopened.Remove(hash); removed {
+		t.Error("hash removed twice")
+	}
+	if again, _ := Open(path); again.Len() != 0 {
+		t.Errorf("%d hashes blocked after the removal was saved, want 0", again.Len())
+	}
+}
+
+func TestOpenRejectsInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"corrupt.json":      "[{",
+		"invalid-hash.json": `[{"hash":"1234"}]`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(path); err == nil {
+			t.Errorf("Open(%s) accepted the file", name)
+		}
+	}
+}
+
+func TestMemoryAndNilBlocklist(t *testing.T) {
+	b, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := strings.Repeat("ef", 32)
+	if _, err := b.Add(hash, ""); err != nil || !b.Contains(hash) {
+		t.Errorf("in-memory blocklist: Add error %v, Contains %v", err, b.Contains(hash))
+	}
+
+	var disabled *Blocklist
+	if disabled.Contains(hash) || disabled.Len() != 0 || disabled.Entries() != nil {
+		t.Error("nil blocklist blocks something")
+	}
+}
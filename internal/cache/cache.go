@@ -1,15 +1,17 @@
 package cache
 
 import (
+	"net/http"
 	"sync"
 	"time"
 )
 
 // cacheEntry stores the servers list and when it was created
 type cacheEntry struct {
-	servers   []string
-	createdAt time.Time
-	lastAccess time.Time // For LRU eviction
+	servers    []string
+	createdAt  time.Time
+	lastAccess time.Time              // For LRU eviction
+	headers    map[string]http.Header // HEAD response headers keyed by server URL (may be nil)
 }
 
 // Cache stores hash-to-server mappings in memory with TTL and size limits
@@ -19,6 +21,29 @@ type Cache struct {
 	items    map[string]*cacheEntry
 	ttl      time.Duration
 	maxSize  int
+	observer func(hash string)        // Notified of local changes to an entry's servers (optional)
+	blocked  func(hash string) bool   // Reports hashes that are never cached (optional, see SetBlocked)
+	hits     int64                    // Get calls answered from the cache
+	misses   int64                    // Get calls for missing or expired entries
+	store    Store                    // Persists the entries across restarts (optional, see SetStore)
+	shared   bool                     // store is a SharedStore
+	dirty    map[string]bool          // Hashes changed since the last flush to store (false: only dropped locally)
+	fetching map[string]chan struct{} // Hashes being looked up in the shared store -> closed when done
+
+	negativeTTL  time.Duration        // How long hashes found on no upstream are remembered (0: disabled)
+	negatives    map[string]time.Time // Hashes found on no upstream -> when that is forgotten
+	negativeHits int64                // IsNegative calls answered from the negative cache
+}
+
+// Counters summarizes cache usage
+type Counters struct {
+	Entries int     `json:"entries"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"` // hits / (hits + misses), 0 before the first lookup
+
+	NegativeEntries int   `json:"negative_entries"` // Hashes remembered as found on no upstream
+	NegativeHits    int64 `json:"negative_hits"`    // Lookups answered as missing from the negative cache
 }
 
 // New creates a new cache instance with TTL and max size
@@ -30,6 +55,39 @@ func New(ttl time.Duration, maxSize int) *Cache {
 	}
 }
 
+// SetObserver registers a function notified with the hash of every entry whose servers are
+// added, changed or removed (except through Apply). It is called with the cache locked and
+// must not call back into the cache
+func (c *Cache) SetObserver(observer func(hash string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.observer = observer
+}
+
+// SetBlocked makes the cache refuse entries for the hashes blocked reports (e.g. the
+// blocklist), whether added locally, applied from a peer or fetched from a shared store
+// It is called with the cache locked, so a lookup already running can't add back an entry
+// removed when its hash was blocked
+func (c *Cache) SetBlocked(blocked func(hash string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blocked = blocked
+}
+
+// blockedLocked reports whether hash may not be cached (must be called with lock held)
+func (c *Cache) blockedLocked(hash string) bool {
+	return c.blocked != nil && c.blocked(hash)
+}
+
+// notifyLocked reports a change to the observer and marks the entry for persistence
+// (must be called with lock held)
+func (c *Cache) notifyLocked(hash string) {
+	c.markDirtyLocked(hash)
+	if c.observer != nil {
+		c.observer(hash)
+	}
+}
+
 // extractHash extracts the hash (first 64 characters) from a path
 // If the path is shorter than 64 characters, it returns the path as-is
 func extractHash(path string) string {
@@ -46,7 +104,7 @@ func (c *Cache) evictOldest() {
 	}
 
 	now := time.Now()
-	
+
 	// First, evict all expired entries
 	expiredHashes := make([]string, 0)
 	for hash, entry := range c.items {
@@ -54,12 +112,13 @@ func (c *Cache) evictOldest() {
 			expiredHashes = append(expiredHashes, hash)
 		}
 	}
-	
+
 	// Delete all expired entries
 	for _, hash := range expiredHashes {
 		delete(c.items, hash)
+		c.markDroppedLocked(hash)
 	}
-	
+
 	// If we're still at max size after removing expired entries, evict the oldest (LRU)
 	if len(c.items) >= c.maxSize {
 		// Find the entry with the oldest lastAccess time
@@ -77,6 +136,7 @@ func (c *Cache) evictOldest() {
 
 		if oldestHash != "" {
 			delete(c.items, oldestHash)
+			c.markDroppedLocked(oldestHash)
 		}
 	}
 }
@@ -86,53 +146,95 @@ func (c *Cache) evictOldest() {
 func (c *Cache) Add(path string, servers []string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	
+
 	hash := extractHash(path)
+	if c.blockedLocked(hash) {
+		return
+	}
 	now := time.Now()
-	
+
 	// If adding a new entry and we're at max size, evict oldest
 	if _, exists := c.items[hash]; !exists && len(c.items) >= c.maxSize {
 		c.evictOldest()
 	}
-	
+
 	c.items[hash] = &cacheEntry{
 		servers:    servers,
 		createdAt:  now,
 		lastAccess: now,
 	}
+	delete(c.negatives, hash)
+	c.notifyLocked(hash)
 }
 
 // Get retrieves the list of servers for a given path
 // The path may include an extension, but only the hash (first 64 chars) is used for lookup
+// Entries missing locally are looked up in the shared store, if any (see SharedStore),
+// waiting at most sharedFetchWait for it
 // Returns false if the entry doesn't exist or has expired
 func (c *Cache) Get(path string) ([]string, bool) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	
+
 	hash := extractHash(path)
 	entry, exists := c.items[hash]
-	if !exists {
-		return nil, false
-	}
-	
+
 	// Check if entry has expired
-	if c.ttl > 0 && time.Since(entry.createdAt) > c.ttl {
+	if exists && c.ttl > 0 && time.Since(entry.createdAt) > c.ttl {
 		delete(c.items, hash)
-		return nil, false
+		c.markDroppedLocked(hash)
+		exists = false
 	}
-	
+	if !exists {
+		shared, _ := c.store.(SharedStore)
+		if shared == nil {
+			c.misses++
+			c.mu.Unlock()
+			return nil, false
+		}
+		return c.waitShared(shared, hash)
+	}
+
 	// Update lastAccess for LRU
 	entry.lastAccess = time.Now()
+	c.hits++
+	c.mu.Unlock()
 	return entry.servers, true
 }
 
+// Counters returns the number of entries and the hits and misses of Get so far
+// Lookups with Peek are not counted
+func (c *Cache) Counters() Counters {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	counters := Counters{
+		Entries:      len(c.items),
+		Hits:         c.hits,
+		Misses:       c.misses,
+		NegativeHits: c.negativeHits,
+	}
+	now := time.Now()
+	for _, expiresAt := range c.negatives {
+		if !now.After(expiresAt) {
+			counters.NegativeEntries++
+		}
+	}
+	if total := c.hits + c.misses; total > 0 {
+		counters.HitRate = float64(c.hits) / float64(total)
+	}
+	return counters
+}
+
 // Remove removes a path from the cache
 // The path may include an extension, but only the hash (first 64 chars) is used for removal
 func (c *Cache) Remove(path string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	hash := extractHash(path)
-	delete(c.items, hash)
+	if _, exists := c.items[hash]; exists {
+		delete(c.items, hash)
+		c.notifyLocked(hash)
+	}
 }
 
 // AddServer adds a server to the list for a given path if it doesn't already exist
@@ -140,8 +242,12 @@ func (c *Cache) Remove(path string) {
 func (c *Cache) AddServer(path string, server string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	
+
 	hash := extractHash(path)
+	if c.blockedLocked(hash) {
+		return
+	}
+	delete(c.negatives, hash)
 	entry, exists := c.items[hash]
 	if !exists {
 		// Create new entry if it doesn't exist
@@ -155,9 +261,10 @@ func (c *Cache) AddServer(path string, server string) {
 			lastAccess: now,
 		}
 		c.items[hash] = entry
+		c.notifyLocked(hash)
 		return
 	}
-	
+
 	// Check if entry has expired
 	if c.ttl > 0 && time.Since(entry.createdAt) > c.ttl {
 		// Entry expired, create new one
@@ -168,9 +275,10 @@ func (c *Cache) AddServer(path string, server string) {
 			lastAccess: now,
 		}
 		c.items[hash] = entry
+		c.notifyLocked(hash)
 		return
 	}
-	
+
 	// Check if server already exists
 	for _, s := range entry.servers {
 		if s == server {
@@ -181,6 +289,7 @@ func (c *Cache) AddServer(path string, server string) {
 	// Add server
 	entry.servers = append(entry.servers, server)
 	entry.lastAccess = time.Now()
+	c.notifyLocked(hash)
 }
 
 // RemoveServer removes a server from the list for a given path
@@ -188,30 +297,182 @@ func (c *Cache) AddServer(path string, server string) {
 func (c *Cache) RemoveServer(path string, server string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	
+
 	hash := extractHash(path)
 	entry, exists := c.items[hash]
 	if !exists {
 		return
 	}
-	
+
 	// Check if entry has expired
 	if c.ttl > 0 && time.Since(entry.createdAt) > c.ttl {
 		delete(c.items, hash)
+		c.markDroppedLocked(hash)
 		return
 	}
-	
+
 	newServers := make([]string, 0, len(entry.servers))
 	for _, s := range entry.servers {
 		if s != server {
 			newServers = append(newServers, s)
 		}
 	}
-	
+
+	if len(newServers) == len(entry.servers) {
+		return
+	}
 	if len(newServers) == 0 {
 		delete(c.items, hash)
 	} else {
 		entry.servers = newServers
 		entry.lastAccess = time.Now()
 	}
+	c.notifyLocked(hash)
+}
+
+// ForgetServer removes a server from every entry (e.g., after it was removed from the
+// upstream set), deleting the entries left without servers
+// Returns the number of entries that listed the server
+func (c *Cache) ForgetServer(server string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := 0
+	for hash, entry := range c.items {
+		newServers := make([]string, 0, len(entry.servers))
+		for _, s := range entry.servers {
+			if s != server {
+				newServers = append(newServers, s)
+			}
+		}
+		if len(newServers) == len(entry.servers) {
+			continue
+		}
+		changed++
+		if len(newServers) == 0 {
+			delete(c.items, hash)
+		} else {
+			entry.servers = newServers
+			delete(entry.headers, server)
+		}
+		c.notifyLocked(hash)
+	}
+	return changed
+}
+
+// Peek returns a copy of the servers for a hash without updating its LRU access time
+// Returns false if the entry doesn't exist or has expired
+func (c *Cache) Peek(path string) ([]string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, exists := c.items[extractHash(path)]
+	if !exists || (c.ttl > 0 && time.Since(entry.createdAt) > c.ttl) {
+		return nil, false
+	}
+	servers := make([]string, len(entry.servers))
+	copy(servers, entry.servers)
+	return servers, true
+}
+
+// Apply replaces the servers of an entry with a change made elsewhere (another instance
+// of a cluster), without notifying the observer. An empty list removes the entry
+// Cached HEAD metadata is kept for the servers still listed, blocked hashes are dropped
+func (c *Cache) Apply(path string, servers []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hash := extractHash(path)
+	c.markDirtyLocked(hash)
+	if len(servers) == 0 || c.blockedLocked(hash) {
+		delete(c.items, hash)
+		return
+	}
+	delete(c.negatives, hash)
+
+	now := time.Now()
+	entry, exists := c.items[hash]
+	if !exists {
+		if len(c.items) >= c.maxSize {
+			c.evictOldest()
+		}
+		c.items[hash] = &cacheEntry{
+			servers:    servers,
+			createdAt:  now,
+			lastAccess: now,
+		}
+		return
+	}
+	entry.servers = servers
+	entry.createdAt = now
+}
+
+// Snapshot returns a copy of all non-expired hash-to-servers mappings
+// Reading a snapshot does not update LRU access times
+func (c *Cache) Snapshot() map[string][]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	result := make(map[string][]string, len(c.items))
+	for hash, entry := range c.items {
+		if c.ttl > 0 && now.Sub(entry.createdAt) > c.ttl {
+			continue
+		}
+		servers := make([]string, len(entry.servers))
+		copy(servers, entry.servers)
+		result[hash] = servers
+	}
+	return result
+}
+
+// SetHeaders stores HEAD response metadata for the servers of an existing entry
+// The path may include an extension, but only the hash (first 64 chars) is used
+// Headers for servers not present in the entry are ignored
+func (c *Cache) SetHeaders(path string, headers map[string]http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hash := extractHash(path)
+	entry, exists := c.items[hash]
+	if !exists {
+		return
+	}
+
+	if entry.headers == nil {
+		entry.headers = make(map[string]http.Header, len(headers))
+	}
+	for _, server := range entry.servers {
+		if h, ok := headers[server]; ok && h != nil {
+			entry.headers[server] = h.Clone()
+		}
+	}
+}
+
+// GetHeaders returns a copy of the cached HEAD metadata for a path, keyed by server URL
+// Only servers still listed in the entry are returned
+// Returns false if the entry doesn't exist, has expired, or has no metadata
+func (c *Cache) GetHeaders(path string) (map[string]http.Header, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	hash := extractHash(path)
+	entry, exists := c.items[hash]
+	if !exists || len(entry.headers) == 0 {
+		return nil, false
+	}
+	if c.ttl > 0 && time.Since(entry.createdAt) > c.ttl {
+		return nil, false
+	}
+
+	result := make(map[string]http.Header, len(entry.headers))
+	for _, server := range entry.servers {
+		if h, ok := entry.headers[server]; ok {
+			result[server] = h.Clone()
+		}
+	}
+	if len(result) == 0 {
+		return nil, false
+	}
+	return result, true
 }
@@ -0,0 +1,82 @@
+package cache
+
+import "time"
+
+// SetNegativeTTL sets how long a hash found on no upstream is remembered as missing
+// (see AddNegative). 0 or negative disables negative caching
+func (c *Cache) SetNegativeTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.negativeTTL = ttl
+	if ttl <= 0 {
+		c.negatives = nil
+	}
+}
+
+// AddNegative remembers that a path was found on no upstream, so lookups can be answered
+// without asking them again until the negative TTL elapses
+// The path may include an extension, but only the hash (first 64 chars) is stored
+// Adding servers to the hash's entry (Add, AddServer, Apply) forgets it
+func (c *Cache) AddNegative(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.negativeTTL <= 0 {
+		return
+	}
+	if c.negatives == nil {
+		c.negatives = make(map[string]time.Time)
+	}
+
+	hash := extractHash(path)
+	now := time.Now()
+	if _, exists := c.negatives[hash]; !exists && len(c.negatives) >= c.maxSize {
+		c.evictNegativeLocked(now)
+	}
+	c.negatives[hash] = now.Add(c.negativeTTL)
+}
+
+// IsNegative reports whether a path was recently found on no upstream
+// The path may include an extension, but only the hash (first 64 chars) is used
+func (c *Cache) IsNegative(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hash := extractHash(path)
+	expiresAt, exists := c.negatives[hash]
+	if !exists {
+		return false
+	}
+	if time.Now().After(expiresAt) {
+		delete(c.negatives, hash)
+		return false
+	}
+	c.negativeHits++
+	return true
+}
+
+// RemoveNegative forgets that a path was found on no upstream (e.g., after it was uploaded)
+func (c *Cache) RemoveNegative(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.negatives, extractHash(path))
+}
+
+// evictNegativeLocked drops expired negative entries, then the one expiring first if the
+// negative cache is still full (must be called with lock held)
+func (c *Cache) evictNegativeLocked(now time.Time) {
+	var soonestHash string
+	var soonest time.Time
+	for hash, expiresAt := range c.negatives {
+		if now.After(expiresAt) {
+			delete(c.negatives, hash)
+			continue
+		}
+		if soonestHash == "" || expiresAt.Before(soonest) {
+			soonestHash = hash
+			soonest = expiresAt
+		}
+	}
+	if len(c.negatives) >= c.maxSize && soonestHash != "" {
+		delete(c.negatives, soonestHash)
+	}
+}
@@ -0,0 +1,350 @@
+package cache
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// redisTimeout bounds connecting to Redis, each exchange with it and the wait for a
+	// free connection of the pool
+	redisTimeout = time.Second
+	// redisRetryDelay is how long requests fail fast after Redis couldn't be reached, so
+	// lookups don't each wait for a timeout while it is down
+	redisRetryDelay = 5 * time.Second
+)
+
+// errRedisNil is the reply to GET for a missing key
+var errRedisNil = errors.New("redis: nil")
+
+// RedisOptions configures the connections of a RedisStore
+type RedisOptions struct {
+	Addr     string      // Redis server as "host:port"
+	Username string      // ACL user (default: none, the password alone authenticates)
+	Password string      // Sent with AUTH if set
+	DB       int         // Database number
+	TLS      *tls.Config // Connect over TLS (nil: plain TCP)
+	PoolSize int         // Connections open at once (default: 1)
+	Prefix   string      // Keys are Prefix followed by the hash
+	TTL      time.Duration
+}
+
+// RedisStore is a SharedStore backed by Redis: several proxy instances using the same
+// Redis (and key prefix) share their hash-to-servers mappings. Entries expire in Redis
+// with the cache TTL, counted from when they were created
+// Requests use a pool of connections, so a slow exchange doesn't hold up the others
+type RedisStore struct {
+	opts  RedisOptions
+	slots chan struct{} // One per connection in use
+
+	mu        sync.Mutex
+	idle      []*redisConn
+	downUntil time.Time // Requests fail fast until then after a connection failure
+	closed    bool
+}
+
+// redisConn is a connection of the pool
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// OpenRedisStore connects to the Redis server of opts, authenticating and selecting the
+// database, to check that it is reachable
+func OpenRedisStore(opts RedisOptions) (*RedisStore, error) {
+	s := &RedisStore{
+		opts:  opts,
+		slots: make(chan struct{}, max(opts.PoolSize, 1)),
+	}
+	c, err := s.get()
+	if err == nil {
+		_, err = s.do(c, "PING")
+	}
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to connect to redis at %s: %w", opts.Addr, err)
+	}
+	return s, nil
+}
+
+// Load implements Store. Entries are fetched when looked up (see Fetch), so nothing is
+// loaded up front
+func (s *RedisStore) Load() (map[string]StoredEntry, error) {
+	return map[string]StoredEntry{}, nil
+}
+
+// Fetch implements SharedStore
+func (s *RedisStore) Fetch(hash string) (StoredEntry, bool, error) {
+	c, err := s.get()
+	if err != nil {
+		return StoredEntry{}, false, err
+	}
+	reply, err := s.do(c, "GET", s.opts.Prefix+hash)
+	if errors.Is(err, errRedisNil) {
+		return StoredEntry{}, false, nil
+	}
+	if err != nil {
+		return StoredEntry{}, false, err
+	}
+	var entry StoredEntry
+	if err := json.Unmarshal([]byte(reply), &entry); err != nil {
+		return StoredEntry{}, false, fmt.Errorf("invalid entry for %s: %w", hash, err)
+	}
+	return entry, true, nil
+}
+
+// Save implements Store. The commands are pipelined; entries already past the TTL are
+// deleted instead of written
+func (s *RedisStore) Save(entries map[string]StoredEntry, removed []string) error {
+	var commands [][]string
+	now := time.Now()
+	for hash, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if s.opts.TTL <= 0 {
+			commands = append(commands, []string{"SET", s.opts.Prefix + hash, string(data)})
+			continue
+		}
+		remaining := s.opts.TTL - now.Sub(entry.CreatedAt)
+		if remaining < time.Millisecond {
+			removed = append(removed, hash)
+			continue
+		}
+		commands = append(commands, []string{"SET", s.opts.Prefix + hash, string(data), "PX", strconv.FormatInt(remaining.Milliseconds(), 10)})
+	}
+	for _, hash := range removed {
+		commands = append(commands, []string{"DEL", s.opts.Prefix + hash})
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+
+	c, err := s.get()
+	if err != nil {
+		return err
+	}
+	err = c.exchange(commands)
+	s.put(c, err)
+	return err
+}
+
+// Close implements Store. Connections in use are closed when they are given back
+func (s *RedisStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var err error
+	for _, c := range s.idle {
+		if closeErr := c.conn.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	s.idle = nil
+	return err
+}
+
+// get takes a connection from the pool, opening one if none is idle, and waiting up to
+// redisTimeout while all of them are in use. It must be given back with put
+func (s *RedisStore) get() (*redisConn, error) {
+	timer := time.NewTimer(redisTimeout)
+	defer timer.Stop()
+	select {
+	case s.slots <- struct{}{}:
+	case <-timer.C:
+		return nil, fmt.Errorf("no redis connection available after %v", redisTimeout)
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		<-s.slots
+		return nil, errors.New("redis store closed")
+	}
+	if n := len(s.idle); n > 0 {
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return c, nil
+	}
+	if time.Now().Before(s.downUntil) {
+		downUntil := s.downUntil
+		s.mu.Unlock()
+		<-s.slots
+		return nil, fmt.Errorf("redis at %s unreachable, retrying after %s", s.opts.Addr, downUntil.Format(time.TimeOnly))
+	}
+	s.mu.Unlock()
+
+	c, err := s.dial()
+	if err != nil {
+		s.mu.Lock()
+		s.downUntil = time.Now().Add(redisRetryDelay)
+		s.mu.Unlock()
+		<-s.slots
+		return nil, err
+	}
+	return c, nil
+}
+
+// put gives a connection back to the pool, closing it instead if err shows it is broken
+func (s *RedisStore) put(c *redisConn, err error) {
+	s.mu.Lock()
+	if s.closed || (err != nil && !isRedisReplyError(err)) {
+		c.conn.Close()
+	} else {
+		s.idle = append(s.idle, c)
+	}
+	s.mu.Unlock()
+	<-s.slots
+}
+
+// do sends a single command over a connection of the pool and gives it back
+func (s *RedisStore) do(c *redisConn, args ...string) (string, error) {
+	reply, err := c.do(args)
+	s.put(c, err)
+	return reply, err
+}
+
+// dial opens a connection, authenticating and selecting the database
+func (s *RedisStore) dial() (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if s.opts.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.opts.Addr, s.opts.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", s.opts.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	var setup [][]string
+	switch {
+	case s.opts.Username != "":
+		setup = append(setup, []string{"AUTH", s.opts.Username, s.opts.Password})
+	case s.opts.Password != "":
+		setup = append(setup, []string{"AUTH", s.opts.Password})
+	}
+	if s.opts.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.opts.DB)})
+	}
+	if len(setup) > 0 {
+		if err := c.exchange(setup); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do sends a single command and returns its reply
+func (c *redisConn) do(args []string) (string, error) {
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+	if _, err := c.conn.Write(encodeRedisCommand(args)); err != nil {
+		return "", err
+	}
+	return c.readReply()
+}
+
+// exchange writes commands and reads their replies
+// Returns the first error reply, if any, or the error that broke the connection
+func (c *redisConn) exchange(commands [][]string) error {
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+	var buf []byte
+	for _, args := range commands {
+		buf = append(buf, encodeRedisCommand(args)...)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for range commands {
+		_, err := c.readReply()
+		if err != nil && !isRedisReplyError(err) {
+			return err
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// redisReplyError is an error reply sent by Redis; the connection stays usable
+type redisReplyError string
+
+func (e redisReplyError) Error() string { return "redis: " + string(e) }
+
+func isRedisReplyError(err error) bool {
+	var replyErr redisReplyError
+	return errors.As(err, &replyErr) || errors.Is(err, errRedisNil)
+}
+
+// encodeRedisCommand encodes a command as a RESP array of bulk strings
+func encodeRedisCommand(args []string) []byte {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+// readReply reads a RESP reply. Simple strings, integers and bulk strings are returned
+// as strings; arrays are read and discarded
+func (c *redisConn) readReply() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", redisReplyError(line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("redis: invalid bulk length %q", line[1:])
+		}
+		if size < 0 {
+			return "", errRedisNil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return "", err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("redis: invalid array length %q", line[1:])
+		}
+		for i := 0; i < count; i++ {
+			if _, err := c.readReply(); err != nil && !isRedisReplyError(err) {
+				return "", err
+			}
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("redis: unexpected reply %q", line)
+}
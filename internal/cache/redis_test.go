@@ -0,0 +1,252 @@
+package cache
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a minimal Redis server answering AUTH, SELECT, PING, GET, SET and DEL
+type fakeRedis struct {
+	password string
+
+	mu      sync.Mutex
+	data    map[string]string
+	dbs     map[string]bool // Databases selected
+	conns   int             // Connections accepted
+	open    int             // Connections open
+	peak    int             // Most connections open at once
+	holdGet chan struct{}   // If set, GET waits for it to be closed
+}
+
+// newFakeRedis serves a fakeRedis on l (a new local listener if nil)
+func newFakeRedis(t *testing.T, password string, l net.Listener) (*fakeRedis, string) {
+	t.Helper()
+	if l == nil {
+		var err error
+		if l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := &fakeRedis{password: password, data: make(map[string]string), dbs: make(map[string]bool)}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f, l.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	f.mu.Lock()
+	f.conns++
+	f.open++
+	f.peak = max(f.peak, f.open)
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.open--
+		f.mu.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+	authenticated := f.password == ""
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(args[0])
+		if !authenticated && command != "AUTH" {
+			io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		f.mu.Lock()
+		hold := f.holdGet
+		f.mu.Unlock()
+		if command == "GET" && hold != nil {
+			<-hold
+		}
+
+		f.mu.Lock()
+		switch command {
+		case "AUTH":
+			if args[len(args)-1] == f.password {
+				authenticated = true
+				io.WriteString(conn, "+OK\r\n")
+			} else {
+				io.WriteString(conn, "-WRONGPASS invalid password\r\n")
+			}
+		case "SELECT":
+			f.dbs[args[1]] = true
+			io.WriteString(conn, "+OK\r\n")
+		case "PING":
+			io.WriteString(conn, "+PONG\r\n")
+		case "GET":
+			if value, ok := f.data[args[1]]; ok {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+			} else {
+				io.WriteString(conn, "$-1\r\n")
+			}
+		case "SET":
+			f.data[args[1]] = args[2]
+			io.WriteString(conn, "+OK\r\n")
+		case "DEL":
+			delete(f.data, args[1])
+			io.WriteString(conn, ":1\r\n")
+		default:
+			io.WriteString(conn, "-ERR unknown command\r\n")
+		}
+		f.mu.Unlock()
+	}
+}
+
+// readCommand reads a RESP array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, count)
+	for i := range args {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func TestRedisStoreSaveAndFetch(t *testing.T) {
+	f, addr := newFakeRedis(t, "secret", nil)
+	store, err := OpenRedisStore(RedisOptions{Addr: addr, Username: "espelhator", Password: "secret", DB: 2, Prefix: "test:", TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("OpenRedisStore: %v", err)
+	}
+	defer store.Close()
+
+	hash := strings.Repeat("ab", 32)
+	entry := StoredEntry{Servers: []string{"https://a.example.com"}, CreatedAt: time.Now().Truncate(time.Second)}
+	expired := StoredEntry{Servers: []string{"https://b.example.com"}, CreatedAt: time.Now().Add(-2 * time.Hour)}
+	if err := store.Save(map[string]StoredEntry{hash: entry, strings.Repeat("cd", 32): expired}, nil); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	got, found, err := store.Fetch(hash)
+	if err != nil || !found || got.Servers[0] != entry.Servers[0] || !got.CreatedAt.Equal(entry.CreatedAt) {
+		t.Errorf("Fetch = %+v, %v, %v; want the saved entry", got, found, err)
+	}
+	if _, found, _ := store.Fetch(strings.Repeat("cd", 32)); found {
+		t.Error("entry past the TTL was written")
+	}
+
+	if err := store.Save(nil, []string{hash}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, found, err := store.Fetch(hash); found || err != nil {
+		t.Errorf("Fetch after removal = %v, %v; want not found", found, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.dbs["2"] {
+		t.Error("database 2 was not selected")
+	}
+	if _, ok := f.data["test:"+hash]; ok {
+		t.Error("removed entry still in redis")
+	}
+}
+
+func TestRedisStoreRejectsWrongPassword(t *testing.T) {
+	_, addr := newFakeRedis(t, "secret", nil)
+	if _, err := OpenRedisStore(RedisOptions{Addr: addr, Password: "wrong"}); err == nil {
+		t.Error("OpenRedisStore succeeded with a wrong password")
+	}
+	if _, err := OpenRedisStore(RedisOptions{Addr: addr}); err == nil {
+		t.Error("OpenRedisStore succeeded without the password")
+	}
+}
+
+func TestRedisStorePoolsConnections(t *testing.T) {
+	f, addr := newFakeRedis(t, "", nil)
+	store, err := OpenRedisStore(RedisOptions{Addr: addr, PoolSize: 3, Prefix: "test:"})
+	if err != nil {
+		t.Fatalf("OpenRedisStore: %v", err)
+	}
+	defer store.Close()
+
+	// Slow lookups don't serialize the others, up to the pool size
+	hold := make(chan struct{})
+	f.mu.Lock()
+	f.holdGet = hold
+	f.mu.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Fetch(strings.Repeat("ef", 32))
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(hold)
+	wg.Wait()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.peak != 3 {
+		t.Errorf("%d connections open at once, want the pool size of 3", f.peak)
+	}
+	if f.conns != 3 {
+		t.Errorf("%d connections opened, want the 3 of the pool reused", f.conns)
+	}
+}
+
+func TestRedisStoreTLS(t *testing.T) {
+	// Borrow the certificate of a TLS test server and the client configuration trusting it
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+	clientConfig := tlsServer.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	clientConfig.ServerName = "example.com"
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", tlsServer.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, addr := newFakeRedis(t, "", l)
+
+	store, err := OpenRedisStore(RedisOptions{Addr: addr, TLS: clientConfig, Prefix: "test:"})
+	if err != nil {
+		t.Fatalf("OpenRedisStore over TLS: %v", err)
+	}
+	defer store.Close()
+	if _, _, err := store.Fetch(strings.Repeat("ab", 32)); err != nil {
+		t.Errorf("Fetch over TLS: %v", err)
+	}
+
+	if _, err := OpenRedisStore(RedisOptions{Addr: addr, TLS: &tls.Config{ServerName: "example.com"}}); err == nil {
+		t.Error("OpenRedisStore accepted an untrusted certificate")
+	}
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"sort"
+	"time"
+)
+
+// Export returns a copy of the non-expired entries with their creation time, keyed by
+// hash, for another instance to Import. HEAD metadata is not exported
+func (c *Cache) Export() map[string]StoredEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	result := make(map[string]StoredEntry, len(c.items))
+	for hash, entry := range c.items {
+		if c.ttl > 0 && now.Sub(entry.createdAt) > c.ttl {
+			continue
+		}
+		servers := make([]string, len(entry.servers))
+		copy(servers, entry.servers)
+		result[hash] = StoredEntry{Servers: servers, CreatedAt: entry.createdAt}
+	}
+	return result
+}
+
+// Import adds entries exported by another instance, keeping their creation time so they
+// expire when they would have there. Servers for which keep returns false are dropped
+// from the entries; entries left without servers, expired, or older than the local entry
+// for the same hash are skipped. Imported entries never evict local ones: the newest are
+// imported until the cache is full
+// Imported entries are persisted like local changes but not announced to the observer
+// Returns the number of entries imported
+func (c *Cache) Import(entries map[string]StoredEntry, keep func(serverURL string) bool) int {
+	now := time.Now()
+	hashes := make([]string, 0, len(entries))
+	for hash, entry := range entries {
+		if len(hash) != 64 || (c.ttl > 0 && now.Sub(entry.CreatedAt) > c.ttl) || entry.CreatedAt.After(now) {
+			continue
+		}
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return entries[hashes[i]].CreatedAt.After(entries[hashes[j]].CreatedAt)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	imported := 0
+	for _, hash := range hashes {
+		entry := entries[hash]
+		servers := make([]string, 0, len(entry.Servers))
+		for _, serverURL := range entry.Servers {
+			if keep(serverURL) {
+				servers = append(servers, serverURL)
+			}
+		}
+		if len(servers) == 0 {
+			continue
+		}
+
+		existing, exists := c.items[hash]
+		if exists && !existing.createdAt.Before(entry.CreatedAt) {
+			continue
+		}
+		if !exists && len(c.items) >= c.maxSize {
+			continue
+		}
+		if exists {
+			existing.servers = servers
+			existing.createdAt = entry.CreatedAt
+		} else {
+			c.items[hash] = &cacheEntry{
+				servers:    servers,
+				createdAt:  entry.CreatedAt,
+				lastAccess: entry.CreatedAt,
+			}
+		}
+		delete(c.negatives, hash)
+		c.markDirtyLocked(hash)
+		imported++
+	}
+	return imported
+}
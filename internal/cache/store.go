@@ -0,0 +1,344 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/recovery"
+	bolt "go.etcd.io/bbolt"
+)
+
+// StoredEntry is a cache entry as persisted by a Store
+type StoredEntry struct {
+	Servers   []string  `json:"servers"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Store persists the hash-to-servers mappings of the cache so they survive restarts,
+// sparing a HEAD check of every blob requested after one. HEAD metadata is not persisted
+type Store interface {
+	// Load returns every persisted entry, keyed by hash
+	Load() (map[string]StoredEntry, error)
+	// Save writes entries and deletes the removed hashes in a single transaction
+	Save(entries map[string]StoredEntry, removed []string) error
+	Close() error
+}
+
+// SharedStore is a Store shared by several proxy instances: entries missing from the
+// local cache are looked up in it, so blobs located by one instance are known to all
+// Entries only dropped locally (expired or evicted) are left in it for the others
+type SharedStore interface {
+	Store
+	// Fetch returns the entry persisted for hash, if any
+	Fetch(hash string) (StoredEntry, bool, error)
+}
+
+var storeBucket = []byte("locations")
+
+// BoltStore is a Store backed by a BoltDB file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens (or creates) the BoltDB cache file at path
+func OpenBoltStore(path string) (*BoltStore, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache file: %w", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(storeBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize cache file: %w", err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Load implements Store. Entries that can't be decoded are skipped
+func (s *BoltStore) Load() (map[string]StoredEntry, error) {
+	entries := make(map[string]StoredEntry)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(storeBucket).ForEach(func(k, v []byte) error {
+			var entry StoredEntry
+			if err := json.Unmarshal(v, &entry); err == nil {
+				entries[string(k)] = entry
+			}
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// Save implements Store
+func (s *BoltStore) Save(entries map[string]StoredEntry, removed []string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(storeBucket)
+		for hash, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(hash), data); err != nil {
+				return err
+			}
+		}
+		for _, hash := range removed {
+			if err := b.Delete([]byte(hash)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close implements Store
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// SetStore loads the entries persisted in store and persists later changes to it (see
+// Flush). Expired entries are dropped, and only the most recent cache_max_size are kept
+// Returns the number of entries loaded
+func (c *Cache) SetStore(store Store) (int, error) {
+	entries, err := store.Load()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load cache: %w", err)
+	}
+
+	now := time.Now()
+	hashes := make([]string, 0, len(entries))
+	var removed []string
+	for hash, entry := range entries {
+		if len(entry.Servers) == 0 || (c.ttl > 0 && now.Sub(entry.CreatedAt) > c.ttl) {
+			removed = append(removed, hash)
+			continue
+		}
+		hashes = append(hashes, hash)
+	}
+	// Newest first, so the oldest are the ones left out when the file holds too many
+	sort.Slice(hashes, func(i, j int) bool {
+		return entries[hashes[i]].CreatedAt.After(entries[hashes[j]].CreatedAt)
+	})
+	if len(hashes) > c.maxSize {
+		removed = append(removed, hashes[c.maxSize:]...)
+		hashes = hashes[:c.maxSize]
+	}
+
+	c.mu.Lock()
+	c.store = store
+	c.dirty = make(map[string]bool)
+	_, c.shared = store.(SharedStore)
+	for _, hash := range hashes {
+		if _, exists := c.items[hash]; exists || c.blockedLocked(hash) {
+			continue
+		}
+		entry := entries[hash]
+		c.items[hash] = &cacheEntry{
+			servers:    entry.Servers,
+			createdAt:  entry.CreatedAt,
+			lastAccess: entry.CreatedAt,
+		}
+	}
+	c.mu.Unlock()
+
+	if len(removed) > 0 {
+		if err := store.Save(nil, removed); err != nil {
+			logging.Warnf(context.Background(), "Cache: failed to drop %d expired entries from the cache file: %v", len(removed), err)
+		}
+	}
+	return len(hashes), nil
+}
+
+// markDirtyLocked records that an entry changed and must be persisted by the next Flush
+// (must be called with lock held)
+func (c *Cache) markDirtyLocked(hash string) {
+	if c.store != nil {
+		c.dirty[hash] = true
+	}
+}
+
+// markDroppedLocked records that an entry expired or was evicted locally: a private store
+// deletes it on the next Flush, a shared one keeps it for the other instances (must be
+// called with lock held)
+func (c *Cache) markDroppedLocked(hash string) {
+	if c.store != nil && !c.shared && !c.dirty[hash] {
+		c.dirty[hash] = false
+	}
+}
+
+const (
+	// sharedFetchWait is how long a lookup waits for the shared store before it is
+	// answered as a miss; the entry is still taken over locally once the store answers
+	sharedFetchWait = 50 * time.Millisecond
+	// maxSharedFetches bounds the lookups in the shared store in flight at once, so a
+	// slow store doesn't pile up goroutines; lookups past it are misses
+	maxSharedFetches = 256
+)
+
+// waitShared starts looking up an entry missing from the local cache in the shared store,
+// unless that lookup is already in flight, and waits for it up to sharedFetchWait
+// (must be called with lock held, which it releases)
+func (c *Cache) waitShared(store SharedStore, hash string) ([]string, bool) {
+	done, inFlight := c.fetching[hash]
+	if !inFlight {
+		if len(c.fetching) >= maxSharedFetches {
+			c.misses++
+			c.mu.Unlock()
+			return nil, false
+		}
+		if c.fetching == nil {
+			c.fetching = make(map[string]chan struct{})
+		}
+		done = make(chan struct{})
+		c.fetching[hash] = done
+		go c.fetchShared(store, hash, done)
+	}
+	c.mu.Unlock()
+
+	timer := time.NewTimer(sharedFetchWait)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, exists := c.items[hash]; exists && (c.ttl <= 0 || time.Since(entry.createdAt) <= c.ttl) {
+		entry.lastAccess = time.Now()
+		c.hits++
+		return entry.servers, true
+	}
+	c.misses++
+	return nil, false
+}
+
+// fetchShared looks up an entry missing from the local cache in the shared store, caches
+// it locally if found, then closes done. Runs in its own goroutine (see waitShared)
+func (c *Cache) fetchShared(store SharedStore, hash string, done chan struct{}) {
+	defer recovery.Recover("shared cache lookup")
+	defer func() {
+		c.mu.Lock()
+		delete(c.fetching, hash)
+		c.mu.Unlock()
+		close(done)
+	}()
+	stored, found, err := store.Fetch(hash)
+	if err != nil {
+		logging.Warnf(context.Background(), "Cache: failed to look up %s in the shared cache: %v", hash, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if entry, exists := c.items[hash]; exists && (c.ttl <= 0 || now.Sub(entry.createdAt) <= c.ttl) {
+		return // Added while the store was queried
+	}
+	if !found || len(stored.Servers) == 0 || (c.ttl > 0 && now.Sub(stored.CreatedAt) > c.ttl) || c.blockedLocked(hash) {
+		return
+	}
+	if _, exists := c.items[hash]; !exists && len(c.items) >= c.maxSize {
+		c.evictOldest()
+	}
+	c.items[hash] = &cacheEntry{
+		servers:    stored.Servers,
+		createdAt:  stored.CreatedAt,
+		lastAccess: now,
+	}
+}
+
+// Flush persists the entries changed since the previous flush. Safe to call without a store
+func (c *Cache) Flush() error {
+	c.mu.Lock()
+	if c.store == nil || len(c.dirty) == 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	dirty := c.dirty
+	c.dirty = make(map[string]bool)
+	now := time.Now()
+	entries := make(map[string]StoredEntry, len(dirty))
+	var removed []string
+	for hash := range dirty {
+		entry, exists := c.items[hash]
+		expired := exists && c.ttl > 0 && now.Sub(entry.createdAt) > c.ttl
+		if expired && c.shared {
+			// Expires in the shared store on its own; another instance may have renewed it
+			continue
+		}
+		if !exists || expired {
+			removed = append(removed, hash)
+			continue
+		}
+		servers := make([]string, len(entry.servers))
+		copy(servers, entry.servers)
+		entries[hash] = StoredEntry{Servers: servers, CreatedAt: entry.createdAt}
+	}
+	store := c.store
+	c.mu.Unlock()
+
+	if err := store.Save(entries, removed); err != nil {
+		// Try again on the next flush
+		c.mu.Lock()
+		for hash, changed := range dirty {
+			c.dirty[hash] = c.dirty[hash] || changed
+		}
+		c.mu.Unlock()
+		return fmt.Errorf("failed to save cache: %w", err)
+	}
+	return nil
+}
+
+// StartFlushing persists changed entries every interval until ctx is cancelled
+func (c *Cache) StartFlushing(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.flushLogged()
+			}
+		}
+	}()
+}
+
+// flushLogged flushes, logging failures; a panic skips this flush
+func (c *Cache) flushLogged() {
+	defer recovery.Recover("cache flush")
+	if err := c.Flush(); err != nil {
+		logging.Warnf(context.Background(), "Cache: %v", err)
+	}
+}
+
+// Close persists the pending changes and closes the store. Safe to call without a store
+func (c *Cache) Close() error {
+	err := c.Flush()
+	c.mu.Lock()
+	store := c.store
+	c.store = nil
+	c.mu.Unlock()
+	if store == nil {
+		return err
+	}
+	if closeErr := store.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowStore is a SharedStore whose lookups wait for release
+type slowStore struct {
+	entries map[string]StoredEntry
+	release chan struct{}
+	fetches atomic.Int64
+}
+
+func (s *slowStore) Load() (map[string]StoredEntry, error)       { return nil, nil }
+func (s *slowStore) Save(map[string]StoredEntry, []string) error { return nil }
+func (s *slowStore) Close() error                                { return nil }
+func (s *slowStore) Fetch(hash string) (StoredEntry, bool, error) {
+	s.fetches.Add(1)
+	<-s.release
+	entry, ok := s.entries[hash]
+	return entry, ok, nil
+}
+
+func TestGetDoesNotWaitForSlowSharedStore(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	store := &slowStore{
+		entries: map[string]StoredEntry{hash: {Servers: []string{"https://a.example.com"}, CreatedAt: time.Now()}},
+		release: make(chan struct{}),
+	}
+	c := New(time.Hour, 100)
+	if _, err := c.SetStore(store); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent lookups of the same hash share one fetch, and give up after sharedFetchWait
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, found := c.Get(hash); found {
+				t.Error("Get found the entry before the shared store answered")
+			}
+		}()
+	}
+	wg.Wait()
+	if waited := time.Since(start); waited > 10*sharedFetchWait {
+		t.Errorf("Get waited %v for the shared store, want about %v", waited, sharedFetchWait)
+	}
+	if fetches := store.fetches.Load(); fetches != 1 {
+		t.Errorf("%d fetches from the shared store, want 1", fetches)
+	}
+
+	// The entry is taken over once the store answers
+	close(store.release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if servers, found := c.Peek(hash); found {
+			if servers[0] != "https://a.example.com" {
+				t.Errorf("servers = %v, want the shared entry", servers)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entry from the shared store never taken over")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, found := c.Get(hash); !found {
+		t.Error("Get missed the entry taken over from the shared store")
+	}
+	if counters := c.Counters(); counters.Hits != 1 || counters.Misses != 5 {
+		t.Errorf("counters = %+v, want 1 hit and 5 misses", counters)
+	}
+}
+
+func TestBlockedHashesAreNotCached(t *testing.T) {
+	blocked, allowed := strings.Repeat("cd", 32), strings.Repeat("ef", 32)
+	entry := StoredEntry{Servers: []string{"https://a.example.com"}, CreatedAt: time.Now()}
+	store := &slowStore{
+		entries: map[string]StoredEntry{blocked: entry, allowed: entry},
+		release: make(chan struct{}),
+	}
+	close(store.release)
+	c := New(time.Hour, 100)
+	c.SetBlocked(func(hash string) bool { return hash == blocked })
+	if _, err := c.SetStore(store); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Add(blocked, []string{"https://a.example.com"})
+	c.AddServer(blocked, "https://b.example.com")
+	c.Apply(blocked, []string{"https://c.example.com"})
+	if _, found := c.Get(blocked); found {
+		t.Error("blocked hash was cached")
+	}
+	if _, found := c.Peek(blocked); found {
+		t.Error("blocked hash was taken over from the shared store")
+	}
+
+	if _, found := c.Get(allowed); !found {
+		t.Error("entry of another hash not taken over from the shared store")
+	}
+	c.Add(strings.Repeat("01", 32), []string{"https://a.example.com"})
+	if _, found := c.Peek(strings.Repeat("01", 32)); !found {
+		t.Error("entry of another hash not cached")
+	}
+}
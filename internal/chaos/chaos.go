@@ -0,0 +1,145 @@
+package chaos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// ErrInjected is returned for injected connection failures
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Transport is an http.RoundTripper that adds artificial latency and failures
+// to requests before handing them to the wrapped transport
+// It is meant for staging only, to exercise failover, quorum and health tracking
+type Transport struct {
+	base       http.RoundTripper
+	serverURL  string
+	cfg        config.ChaosConfig
+	operations map[string]bool // nil means all operations
+	verbose    *logging.Flag
+}
+
+// Wrap returns a Transport injecting the faults described by cfg into requests sent through base
+// If base is nil, http.DefaultTransport is used
+func Wrap(base http.RoundTripper, serverURL string, cfg config.ChaosConfig, verbose *logging.Flag) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	var operations map[string]bool
+	if len(cfg.Operations) > 0 {
+		operations = make(map[string]bool, len(cfg.Operations))
+		for _, opType := range cfg.Operations {
+			operations[opType] = true
+		}
+	}
+
+	return &Transport{
+		base:       base,
+		serverURL:  serverURL,
+		cfg:        cfg,
+		operations: operations,
+		verbose:    verbose,
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	opType := operationFor(req)
+	if t.operations != nil && !t.operations[opType] {
+		return t.base.RoundTrip(req)
+	}
+
+	// Inject latency first, so injected failures can also be slow (like a real timeout)
+	if delay := t.delay(); delay > 0 {
+		t.verbose.Debugf(req.Context(), "chaos: delaying %s %s on %s by %v", req.Method, req.URL.Path, t.serverURL, delay)
+		if err := sleep(req.Context(), delay); err != nil {
+			closeBody(req)
+			return nil, err
+		}
+	}
+
+	if t.cfg.FailureRate > 0 && rand.Float64() < t.cfg.FailureRate {
+		// The transport must always close the request body, even on error
+		closeBody(req)
+
+		if t.cfg.FailureStatus == 0 {
+			t.verbose.Debugf(req.Context(), "chaos: injecting connection failure for %s %s on %s", req.Method, req.URL.Path, t.serverURL)
+			return nil, ErrInjected
+		}
+
+		t.verbose.Debugf(req.Context(), "chaos: injecting status %d for %s %s on %s", t.cfg.FailureStatus, req.Method, req.URL.Path, t.serverURL)
+		body := fmt.Sprintf("chaos: injected %d", t.cfg.FailureStatus)
+		header := make(http.Header)
+		header.Set("Content-Type", "text/plain")
+		header.Set("X-Reason", body)
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", t.cfg.FailureStatus, http.StatusText(t.cfg.FailureStatus)),
+			StatusCode:    t.cfg.FailureStatus,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewBufferString(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// delay returns the latency to inject for a single request
+func (t *Transport) delay() time.Duration {
+	delay := t.cfg.Latency
+	if t.cfg.LatencyJitter > 0 {
+		delay += rand.N(t.cfg.LatencyJitter)
+	}
+	return delay
+}
+
+// operationFor maps a request to the operation type used for health tracking
+func operationFor(req *http.Request) string {
+	path := req.URL.Path
+	switch {
+	case strings.HasSuffix(path, "/upload"):
+		return "upload"
+	case strings.HasSuffix(path, "/mirror"):
+		return "mirror"
+	case strings.Contains(path, "/list/"):
+		return "list"
+	case req.Method == http.MethodDelete:
+		return "delete"
+	default:
+		return "download"
+	}
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closeBody closes the request body, if any
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
@@ -1,491 +0,0 @@
-package client
-
-import (
-	"bytes"
-	"context"
-	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"net/url"
-	"strconv"
-	"strings"
-	"time"
-)
-
-// Client is an HTTP client for communicating with Blossom servers
-type Client struct {
-	httpClient   *http.Client
-	baseURL      string // Used for building URLs in responses
-	connectURL   string // Used for actual HTTP connections (if set, otherwise uses baseURL)
-	verbose      bool
-}
-
-// New creates a new Blossom client
-// baseURL is the official URL used for building URLs in responses
-// connectURL is an optional alternative address for actual connections (if empty, uses baseURL)
-func New(baseURL string, connectURL string, timeout time.Duration, verbose bool) *Client {
-	client := &Client{
-		httpClient: &http.Client{
-			Timeout: timeout,
-		},
-		baseURL: baseURL,
-		verbose: verbose,
-	}
-	
-	// If connectURL is provided, use it; otherwise use baseURL for connections
-	if connectURL != "" {
-		client.connectURL = connectURL
-	} else {
-		client.connectURL = baseURL
-	}
-	
-	return client
-}
-
-// getConnectURL returns the URL to use for making HTTP connections
-// It replaces the hostname in baseURL with the hostname from connectURL.
-// Trims trailing slashes from the base and ensures path has one leading slash to avoid duplication.
-func (c *Client) getConnectURL(path string) (string, error) {
-	path = "/" + strings.TrimLeft(path, "/")
-	if c.connectURL == c.baseURL {
-		base := strings.TrimRight(c.baseURL, "/")
-		return base + path, nil
-	}
-
-	// Parse the connect URL
-	connectParsed, err := url.Parse(c.connectURL)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse connectURL: %w", err)
-	}
-
-	// Build the connection URL using the connectURL's scheme and host, with the provided path
-	base := strings.TrimRight(connectParsed.Host, "/")
-	connectURL := fmt.Sprintf("%s://%s%s", connectParsed.Scheme, base, path)
-
-	return connectURL, nil
-}
-
-// Upload uploads a blob to the Blossom server
-// The request should include the file data and Nostr event in the body
-// contentLength should be set if known (>= 0), otherwise -1 to use chunked encoding
-// Returns the response body on success
-func (c *Client) Upload(ctx context.Context, body io.Reader, contentType string, contentLength int64, headers map[string]string) ([]byte, error) {
-	connectURL, err := c.getConnectURL("/upload")
-	if err != nil {
-		return nil, err
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Upload: %s (connect via %s) - method=PUT, content-type=%s, content-length=%d", c.baseURL, connectURL, contentType, contentLength)
-		log.Printf("[DEBUG] Client.Upload: headers=%v", headers)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "PUT", connectURL, body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Set Content-Length explicitly if provided
-	// This prevents Go from using chunked transfer encoding, which some upstream servers reject
-	if contentLength >= 0 {
-		req.ContentLength = contentLength
-		req.Header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
-		if c.verbose {
-			log.Printf("[DEBUG] Client.Upload: set Content-Length to %d", contentLength)
-		}
-	} else if c.verbose {
-		log.Printf("[DEBUG] Client.Upload: Content-Length not provided (value=%d), will use chunked encoding", contentLength)
-	}
-
-	if contentType != "" {
-		req.Header.Set("Content-Type", contentType)
-	}
-
-	// Copy additional headers (e.g., Nostr event headers)
-	// Skip Accept-Encoding to let Go's HTTP client handle it automatically
-	for k, v := range headers {
-		if strings.ToLower(k) != "accept-encoding" {
-			req.Header.Set(k, v)
-		}
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Upload: sending request to %s", connectURL)
-	}
-
-	startTime := time.Now()
-	resp, err := c.httpClient.Do(req)
-	duration := time.Since(startTime)
-
-	if err != nil {
-		if c.verbose {
-			log.Printf("[DEBUG] Client.Upload: request failed after %v: %v", duration, err)
-		}
-		return nil, fmt.Errorf("upload request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Upload: response received after %v - status=%d, headers=%v", duration, resp.StatusCode, resp.Header)
-	}
-
-	// Read response body (gzip will be automatically decompressed by Go's http client)
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		if c.verbose {
-			log.Printf("[DEBUG] Client.Upload: failed to read response body: %v", err)
-		}
-		bodyBytes = nil
-	}
-
-	// Accept 200, 201, and 202 as success status codes
-	// 200 = OK, 201 = Created, 202 = Accepted (queued for processing)
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusAccepted {
-		bodyStr := string(bodyBytes)
-		if bodyStr == "" {
-			bodyStr = "(empty response body)"
-		}
-		if c.verbose {
-			log.Printf("[DEBUG] Client.Upload: upload failed - status=%d, body=%s", resp.StatusCode, bodyStr)
-		}
-		return nil, NewHTTPError(resp.StatusCode, bodyStr)
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Upload: upload successful, response body: %s", string(bodyBytes))
-	}
-
-	return bodyBytes, nil
-}
-
-// Download checks if a blob exists at the server (returns the URL)
-// Returns the official baseURL, not the connection URL
-func (c *Client) Download(ctx context.Context, hash string) (string, error) {
-	connectURL, err := c.getConnectURL(fmt.Sprintf("/%s", hash))
-	if err != nil {
-		return "", err
-	}
-	
-	// Return the official URL, not the connection URL
-	officialURL := fmt.Sprintf("%s/%s", c.baseURL, hash)
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Download: checking %s (connect via %s) for hash %s", c.baseURL, connectURL, hash)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "HEAD", connectURL, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		if c.verbose {
-			log.Printf("[DEBUG] Client.Download: request failed: %v", err)
-		}
-		return "", fmt.Errorf("download check failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Download: response status=%d", resp.StatusCode)
-	}
-
-	if resp.StatusCode == http.StatusOK {
-		return officialURL, nil
-	}
-
-	return "", fmt.Errorf("blob not found: status %d", resp.StatusCode)
-}
-
-// List retrieves the list of blobs for a given pubkey
-func (c *Client) List(ctx context.Context, pubkey string) ([]byte, error) {
-	connectURL, err := c.getConnectURL(fmt.Sprintf("/list/%s", pubkey))
-	if err != nil {
-		return nil, err
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.List: listing blobs for pubkey %s on %s (connect via %s)", pubkey, c.baseURL, connectURL)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "GET", connectURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		if c.verbose {
-			log.Printf("[DEBUG] Client.List: request failed: %v", err)
-		}
-		return nil, fmt.Errorf("list request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.List: response status=%d", resp.StatusCode)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("list failed with status %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.List: received %d bytes", len(body))
-	}
-
-	return body, nil
-}
-
-// Delete deletes a blob from the server
-func (c *Client) Delete(ctx context.Context, hash string, headers map[string]string) error {
-	connectURL, err := c.getConnectURL(fmt.Sprintf("/%s", hash))
-	if err != nil {
-		return err
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Delete: deleting hash %s from %s (connect via %s)", hash, c.baseURL, connectURL)
-		log.Printf("[DEBUG] Client.Delete: headers=%v", headers)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "DELETE", connectURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Copy headers (e.g., authentication headers)
-	// Skip Accept-Encoding to let Go's HTTP client handle it automatically
-	for k, v := range headers {
-		if strings.ToLower(k) != "accept-encoding" {
-			req.Header.Set(k, v)
-		}
-	}
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		if c.verbose {
-			log.Printf("[DEBUG] Client.Delete: request failed: %v", err)
-		}
-		return fmt.Errorf("delete request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Delete: response status=%d", resp.StatusCode)
-	}
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		if c.verbose {
-			log.Printf("[DEBUG] Client.Delete: delete failed - status=%d, body=%s", resp.StatusCode, string(bodyBytes))
-		}
-		return fmt.Errorf("delete failed with status %d: %s", resp.StatusCode, string(bodyBytes))
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Delete: delete successful")
-	}
-
-	return nil
-}
-
-// CheckHealth checks if the server is reachable
-func (c *Client) CheckHealth(ctx context.Context) error {
-	// Try to access a non-existent blob to check if server responds
-	connectURL, err := c.getConnectURL("/0000000000000000000000000000000000000000000000000000000000000000")
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "HEAD", connectURL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("health check failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Any response (even 404) means the server is reachable
-	return nil
-}
-
-// GetBaseURL returns the base URL of the client
-func (c *Client) GetBaseURL() string {
-	return c.baseURL
-}
-
-// Head performs a HEAD request to check if a blob exists at the given path and returns the response
-// The path may include an extension (e.g., "hash.mp4")
-func (c *Client) Head(ctx context.Context, path string) (*http.Response, error) {
-	connectURL, err := c.getConnectURL(fmt.Sprintf("/%s", path))
-	if err != nil {
-		return nil, err
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Head: checking %s (connect via %s) for path %s", c.baseURL, connectURL, path)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "HEAD", connectURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		if c.verbose {
-			log.Printf("[DEBUG] Client.Head: request failed: %v", err)
-		}
-		return nil, fmt.Errorf("head request failed: %w", err)
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Head: response status=%d, headers=%v", resp.StatusCode, resp.Header)
-	}
-
-	return resp, nil
-}
-
-// HeadUpload performs a HEAD request to /upload to check upload requirements (BUD-06)
-// The request should include headers: X-SHA-256, X-Content-Length, X-Content-Type
-// Returns the HTTP response with headers including X-Reason if rejected
-func (c *Client) HeadUpload(ctx context.Context, headers map[string]string) (*http.Response, error) {
-	connectURL, err := c.getConnectURL("/upload")
-	if err != nil {
-		return nil, err
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.HeadUpload: checking %s (connect via %s) with headers: %v", c.baseURL, connectURL, headers)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "HEAD", connectURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Copy headers (X-SHA-256, X-Content-Length, X-Content-Type, etc.)
-	// Skip Accept-Encoding to let Go's HTTP client handle it automatically
-	for k, v := range headers {
-		if strings.ToLower(k) != "accept-encoding" {
-			req.Header.Set(k, v)
-		}
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.HeadUpload: sending HEAD request to %s", connectURL)
-	}
-
-	startTime := time.Now()
-	resp, err := c.httpClient.Do(req)
-	duration := time.Since(startTime)
-
-	if err != nil {
-		if c.verbose {
-			log.Printf("[DEBUG] Client.HeadUpload: request failed after %v: %v", duration, err)
-		}
-		return nil, fmt.Errorf("head upload request failed: %w", err)
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.HeadUpload: response received after %v - status=%d, headers=%v", duration, resp.StatusCode, resp.Header)
-	}
-
-	return resp, nil
-}
-
-// Mirror sends a PUT /mirror request to request mirroring of a blob (BUD-04)
-// The request body should contain blob metadata/hash
-// Headers should include authentication (Nostr event)
-// Returns the response body on success
-func (c *Client) Mirror(ctx context.Context, body io.Reader, contentType string, headers map[string]string) ([]byte, error) {
-	connectURL, err := c.getConnectURL("/mirror")
-	if err != nil {
-		return nil, err
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Mirror: %s (connect via %s) - method=PUT, content-type=%s", c.baseURL, connectURL, contentType)
-		log.Printf("[DEBUG] Client.Mirror: headers=%v", headers)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "PUT", connectURL, body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	if contentType != "" {
-		req.Header.Set("Content-Type", contentType)
-	}
-
-	// Copy additional headers (e.g., Nostr event headers)
-	// Skip Accept-Encoding to let Go's HTTP client handle it automatically
-	for k, v := range headers {
-		if strings.ToLower(k) != "accept-encoding" {
-			req.Header.Set(k, v)
-		}
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Mirror: sending request to %s", connectURL)
-	}
-
-	startTime := time.Now()
-	resp, err := c.httpClient.Do(req)
-	duration := time.Since(startTime)
-
-	if err != nil {
-		if c.verbose {
-			log.Printf("[DEBUG] Client.Mirror: request failed after %v: %v", duration, err)
-		}
-		return nil, fmt.Errorf("mirror request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Mirror: response received after %v - status=%d, headers=%v", duration, resp.StatusCode, resp.Header)
-	}
-
-	// Read response body (gzip will be automatically decompressed by Go's http client)
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		if c.verbose {
-			log.Printf("[DEBUG] Client.Mirror: failed to read response body: %v", err)
-		}
-		bodyBytes = nil
-	}
-
-	// Accept 200, 201, and 202 as success status codes
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusAccepted {
-		bodyStr := string(bodyBytes)
-		if bodyStr == "" {
-			bodyStr = "(empty response body)"
-		}
-		if c.verbose {
-			log.Printf("[DEBUG] Client.Mirror: mirror request failed - status=%d, body=%s", resp.StatusCode, bodyStr)
-		}
-		return nil, NewHTTPError(resp.StatusCode, bodyStr)
-	}
-
-	if c.verbose {
-		log.Printf("[DEBUG] Client.Mirror: mirror request successful, response body: %s", string(bodyBytes))
-	}
-
-	return bodyBytes, nil
-}
-
-// UploadWithBody uploads using a byte slice body
-func (c *Client) UploadWithBody(ctx context.Context, body []byte, contentType string, headers map[string]string) ([]byte, error) {
-	return c.Upload(ctx, bytes.NewReader(body), contentType, int64(len(body)), headers)
-}
@@ -0,0 +1,489 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/cache"
+	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/journal"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/recovery"
+	"github.com/girino/blossom_espelhator/internal/stats"
+)
+
+// SyncPath is the endpoint peers exchange state on
+const SyncPath = "/cluster/sync"
+
+// maxPendingPerPeer bounds the cache changes queued for a peer that can't be reached;
+// further changes are dropped and the peer rediscovers those blobs on its own
+const maxPendingPerPeer = 100000
+
+// maxUpdatesPerSync bounds the cache changes sent in one exchange (the rest follow in the next ones)
+const maxUpdatesPerSync = 5000
+
+// maxSyncBodyBytes bounds the size of an exchanged message
+const maxSyncBodyBytes = 64 * 1024 * 1024
+
+// nodeTimeoutIntervals is how many sync intervals a node may stay silent before it is
+// considered gone (its counters are then no longer aggregated and it can't be leader)
+const nodeTimeoutIntervals = 3
+
+// syncMessage is the state an instance sends to a peer; the peer answers with its own
+// (without cache changes, which every instance pushes to every peer itself)
+type syncMessage struct {
+	NodeID  string                        `json:"node_id"`
+	SentAt  time.Time                     `json:"sent_at"`
+	Servers map[string]*stats.ServerStats `json:"servers"`         // Counters and per-operation health, by upstream URL
+	Cache   []cacheUpdate                 `json:"cache,omitempty"` // Cache entries changed since the last exchange
+
+	AcceptsJournal  bool            `json:"accepts_journal,omitempty"`  // Has a journal, so it can take over retries when leader
+	Journal         []journal.Entry `json:"journal,omitempty"`          // Retries handed over to the leader
+	JournalAccepted []uint64        `json:"journal_accepted,omitempty"` // IDs of handed over retries the leader took over
+}
+
+// cacheUpdate is the current server list of a cache entry (empty when it was removed)
+type cacheUpdate struct {
+	Hash    string   `json:"hash"`
+	Servers []string `json:"servers"`
+}
+
+// peer is a configured instance this one pushes its state to
+type peer struct {
+	url       string
+	nodeID    string            // Learned from its answers
+	self      bool              // The URL leads back to this instance (or one with the same node_id)
+	pending   map[string]uint64 // Hashes changed since the last successful exchange, with the change's sequence number
+	dropped   int64             // Changes not queued because pending was full
+	lastError string
+}
+
+// node is the latest state received from another instance
+type node struct {
+	lastSeen       time.Time
+	servers        map[string]*stats.ServerStats
+	acceptsJournal bool
+}
+
+// Cluster shares cache entries, health determinations and operation counters with the
+// other instances serving the same upstreams
+// Health is merged per server and operation: the most recent flip wins, so an upstream
+// found failing by one instance is avoided by all of them (and brought back the same way)
+type Cluster struct {
+	nodeID       string
+	secret       string
+	syncInterval time.Duration
+	httpClient   *http.Client
+	cache        *cache.Cache
+	stats        *stats.Stats
+	verbose      *logging.Flag
+
+	journal *journal.Journal // Retry queue handed over to (or taken over from) the leader (nil if disabled)
+
+	mu           sync.Mutex
+	knownServers map[string]bool // Upstream URLs served here; state about others is ignored (replaced, never modified)
+	peers        []*peer
+	nodes        map[string]*node // keyed by node ID
+	changes      uint64           // Sequence number of the latest local cache change
+	ready        bool             // A first sync round completed, so the live nodes are known
+	lastLeader   string           // Leader after the latest sync round, to log changes
+	handedOff    int64            // Retries handed over to the leader
+	takenOver    int64            // Retries taken over from other instances
+}
+
+// New creates the cluster layer and starts tracking local cache changes
+func New(cfg *config.ClusterConfig, c *cache.Cache, statsTracker *stats.Stats, serverURLs []string, verbose *logging.Flag) *Cluster {
+	cl := &Cluster{
+		nodeID:       cfg.NodeID,
+		secret:       cfg.Secret,
+		syncInterval: cfg.SyncInterval,
+		httpClient:   &http.Client{Timeout: cfg.SyncTimeout},
+		cache:        c,
+		stats:        statsTracker,
+		knownServers: make(map[string]bool, len(serverURLs)),
+		verbose:      verbose,
+		nodes:        make(map[string]*node),
+	}
+	for _, serverURL := range serverURLs {
+		cl.knownServers[serverURL] = true
+	}
+	for _, peerURL := range cfg.Peers {
+		cl.peers = append(cl.peers, &peer{
+			url:     strings.TrimRight(peerURL, "/"),
+			pending: make(map[string]uint64),
+		})
+	}
+	c.SetObserver(cl.cacheChanged)
+	return cl
+}
+
+// cacheChanged queues a locally changed cache entry for every peer (called with the cache locked)
+func (cl *Cluster) cacheChanged(hash string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.changes++
+	for _, p := range cl.peers {
+		if p.self {
+			continue
+		}
+		if _, queued := p.pending[hash]; !queued && len(p.pending) >= maxPendingPerPeer {
+			p.dropped++
+			continue
+		}
+		p.pending[hash] = cl.changes
+	}
+}
+
+// Start exchanges state with every peer each sync interval until ctx is cancelled
+func (cl *Cluster) Start(ctx context.Context) {
+	log.Printf("Cluster started (node_id=%s, peers=%d, interval=%v)", cl.nodeID, len(cl.peers), cl.syncInterval)
+
+	go func() {
+		ticker := time.NewTicker(cl.syncInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				cl.syncAll(ctx)
+				cl.noteLeader()
+			}
+		}
+	}()
+}
+
+// syncAll exchanges state with all peers in parallel
+func (cl *Cluster) syncAll(ctx context.Context) {
+	defer recovery.Recover("Cluster sync")
+
+	cl.mu.Lock()
+	peers := make([]*peer, 0, len(cl.peers))
+	for _, p := range cl.peers {
+		if !p.self {
+			peers = append(peers, p)
+		}
+	}
+	cl.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p *peer) {
+			defer wg.Done()
+			defer recovery.Recover("Cluster sync")
+			cl.syncPeer(ctx, p)
+		}(p)
+	}
+	wg.Wait()
+}
+
+// syncPeer pushes the local state and pending cache changes to a peer and applies its answer
+// Changes that were sent stay pending until the peer acknowledged them (or if they changed
+// again in the meantime)
+func (cl *Cluster) syncPeer(ctx context.Context, p *peer) {
+	cl.mu.Lock()
+	sent := make(map[string]uint64, min(len(p.pending), maxUpdatesPerSync))
+	for hash, seq := range p.pending {
+		if len(sent) >= maxUpdatesPerSync {
+			break
+		}
+		sent[hash] = seq
+	}
+	if p.dropped > 0 {
+		logging.Warnf(ctx, "Cluster: %d cache changes for %s were dropped (peer unreachable for too long)", p.dropped, p.url)
+		p.dropped = 0
+	}
+	cl.mu.Unlock()
+
+	msg := cl.localState()
+	for hash := range sent {
+		servers, _ := cl.cache.Peek(hash)
+		msg.Cache = append(msg.Cache, cacheUpdate{Hash: hash, Servers: servers})
+	}
+	msg.Journal = cl.handoffEntries(p)
+
+	answer, err := cl.post(ctx, p.url, msg)
+	if !cl.recordAnswer(p, sent, answer, err) {
+		return
+	}
+	cl.handedOver(answer.JournalAccepted)
+	cl.apply(answer)
+
+	cl.verbose.Debugf(ctx, "Cluster: synced with %s (node %s), sent %d cache changes", p.url, answer.NodeID, len(sent))
+}
+
+// recordAnswer updates a peer's state after an exchange and reports whether its answer
+// should be applied
+func (cl *Cluster) recordAnswer(p *peer, sent map[string]uint64, answer *syncMessage, err error) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if err != nil {
+		if p.lastError == "" {
+			logging.Warnf(context.Background(), "Cluster: sync with %s failed: %v", p.url, err)
+		} else if cl.verbose.Enabled() {
+			cl.verbose.Debugf(context.Background(), "Cluster: sync with %s failed: %v", p.url, err)
+		}
+		p.lastError = err.Error()
+		return false
+	}
+	if p.lastError != "" {
+		log.Printf("Cluster: sync with %s recovered", p.url)
+	}
+	p.lastError = ""
+
+	if answer.NodeID == cl.nodeID {
+		log.Printf("Cluster: peer %s reports our node_id %q, not syncing with it (it is this instance, or node_ids are not unique)", p.url, cl.nodeID)
+		p.self = true
+		p.pending = nil
+		return false
+	}
+	p.nodeID = answer.NodeID
+	for hash, seq := range sent {
+		if p.pending[hash] == seq {
+			delete(p.pending, hash)
+		}
+	}
+	return true
+}
+
+// post sends a message to a peer's sync endpoint and decodes its answer
+func (cl *Cluster) post(ctx context.Context, peerURL string, msg *syncMessage) (*syncMessage, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peerURL+SyncPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+cl.secret)
+
+	resp, err := cl.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var answer syncMessage
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxSyncBodyBytes)).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("invalid answer: %w", err)
+	}
+	if answer.NodeID == "" {
+		return nil, fmt.Errorf("answer without node_id")
+	}
+	return &answer, nil
+}
+
+// HandleSync handles POST /cluster/sync: applies a peer's state and answers with the local one
+func (cl *Cluster) HandleSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cl.secret)) != 1 {
+		logging.Warnf(r.Context(), "Cluster: rejected sync request from %s (bad secret)", r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var msg syncMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSyncBodyBytes)).Decode(&msg); err != nil || msg.NodeID == "" {
+		http.Error(w, "Invalid sync message", http.StatusBadRequest)
+		return
+	}
+
+	// A request from this very instance (its own URL listed as a peer) is only answered,
+	// so the sender learns it is talking to itself
+	answer := cl.localState()
+	if msg.NodeID != cl.nodeID {
+		cl.apply(&msg)
+		answer.JournalAccepted = cl.takeOver(msg.NodeID, msg.Journal)
+		cl.verbose.Debugf(r.Context(), "Cluster: received state from node %s with %d cache changes and %d retries", msg.NodeID, len(msg.Cache), len(msg.Journal))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(answer)
+}
+
+// localState returns the local counters and health to send to a peer
+func (cl *Cluster) localState() *syncMessage {
+	return &syncMessage{
+		NodeID:         cl.nodeID,
+		SentAt:         time.Now(),
+		Servers:        cl.stats.GetAll(),
+		AcceptsJournal: cl.journal != nil,
+	}
+}
+
+// SetKnownServer adds an upstream server added at runtime to the servers whose state is
+// shared (known=true), or drops one that was removed
+func (cl *Cluster) SetKnownServer(serverURL string, known bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	knownServers := maps.Clone(cl.knownServers)
+	if known {
+		knownServers[serverURL] = true
+	} else {
+		delete(knownServers, serverURL)
+	}
+	cl.knownServers = knownServers
+}
+
+// known returns the upstream servers whose state is shared (must not be modified)
+func (cl *Cluster) known() map[string]bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.knownServers
+}
+
+// apply stores a peer's state, merges its health determinations and applies its cache
+// changes, ignoring upstream servers not configured here and hashes blocked here (the
+// cache refuses them, see cache.SetBlocked)
+// The cluster lock is released before touching the cache, whose observer takes it
+func (cl *Cluster) apply(msg *syncMessage) {
+	cl.mu.Lock()
+	cl.nodes[msg.NodeID] = &node{
+		lastSeen:       time.Now(),
+		servers:        msg.Servers,
+		acceptsJournal: msg.AcceptsJournal,
+	}
+	knownServers := cl.knownServers
+	cl.mu.Unlock()
+
+	for serverURL, serverStats := range msg.Servers {
+		if !knownServers[serverURL] || serverStats == nil {
+			continue
+		}
+		for opType, op := range serverStats.Operations {
+			if op != nil && cl.stats.MergeHealth(serverURL, opType, *op) {
+				log.Printf("Cluster: node %s reports %s %s for %s", msg.NodeID, serverURL, healthWord(op.IsHealthy), opType)
+			}
+		}
+	}
+
+	for _, update := range msg.Cache {
+		servers := make([]string, 0, len(update.Servers))
+		for _, serverURL := range update.Servers {
+			if knownServers[serverURL] {
+				servers = append(servers, serverURL)
+			}
+		}
+		cl.cache.Apply(update.Hash, servers)
+	}
+}
+
+// healthWord describes a health state in log messages
+func healthWord(healthy bool) string {
+	if healthy {
+		return "healthy"
+	}
+	return "unhealthy"
+}
+
+// liveNodesLocked returns the nodes heard from recently (must be called with lock held)
+func (cl *Cluster) liveNodesLocked() map[string]*node {
+	live := make(map[string]*node, len(cl.nodes))
+	deadline := time.Now().Add(-nodeTimeoutIntervals * cl.syncInterval)
+	for nodeID, n := range cl.nodes {
+		if n.lastSeen.After(deadline) {
+			live[nodeID] = n
+		}
+	}
+	return live
+}
+
+// PeerStatus describes a configured peer in /stats
+type PeerStatus struct {
+	URL       string     `json:"url,omitempty"` // Omitted when upstreams are redacted
+	NodeID    string     `json:"node_id,omitempty"`
+	Self      bool       `json:"self,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	Pending   int        `json:"pending_cache_changes"`
+}
+
+// Status is the cluster section of /stats
+type Status struct {
+	NodeID           string                        `json:"node_id"`
+	Leader           bool                          `json:"leader"`
+	LeaderNode       string                        `json:"leader_node,omitempty"` // Empty until the first sync round
+	LiveNodes        int                           `json:"live_nodes"`            // Including this one
+	JournalHandedOff int64                         `json:"journal_handed_off"`    // Retries handed over to the leader
+	JournalTakenOver int64                         `json:"journal_taken_over"`    // Retries taken over from other instances
+	Peers            []PeerStatus                  `json:"peers"`
+	Servers          map[string]*stats.ServerStats `json:"servers"` // Operation counters summed over the live nodes, with the merged health
+}
+
+// Status returns the state of the cluster, with the counters of all live nodes summed
+// per upstream server (local is this instance's own statistics)
+func (cl *Cluster) Status(local map[string]*stats.ServerStats) Status {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	live := cl.liveNodesLocked()
+	status := Status{
+		NodeID:           cl.nodeID,
+		Leader:           cl.isLeaderLocked(),
+		LeaderNode:       cl.leaderLocked(),
+		LiveNodes:        len(live) + 1,
+		JournalHandedOff: cl.handedOff,
+		JournalTakenOver: cl.takenOver,
+		Peers:            make([]PeerStatus, 0, len(cl.peers)),
+		Servers:          make(map[string]*stats.ServerStats, len(local)),
+	}
+	for serverURL, serverStats := range local {
+		total := &stats.ServerStats{
+			URL:                 serverURL,
+			ConsecutiveFailures: serverStats.ConsecutiveFailures,
+			IsHealthy:           serverStats.IsHealthy,
+			HealthySince:        serverStats.HealthySince,
+			UnhealthySince:      serverStats.UnhealthySince,
+			Operations:          serverStats.Operations,
+		}
+		total.AddCounts(serverStats)
+		status.Servers[serverURL] = total
+	}
+	for _, n := range live {
+		for serverURL, serverStats := range n.servers {
+			if total, ok := status.Servers[serverURL]; ok && serverStats != nil {
+				total.AddCounts(serverStats)
+			}
+		}
+	}
+
+	for _, p := range cl.peers {
+		ps := PeerStatus{
+			URL:       p.url,
+			NodeID:    p.nodeID,
+			Self:      p.self,
+			LastError: p.lastError,
+			Pending:   len(p.pending),
+		}
+		if n, ok := cl.nodes[p.nodeID]; ok && !p.self {
+			lastSeen := n.lastSeen
+			ps.LastSeen = &lastSeen
+		}
+		status.Peers = append(status.Peers, ps)
+	}
+	return status
+}
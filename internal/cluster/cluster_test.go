@@ -0,0 +1,208 @@
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/cache"
+	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/stats"
+)
+
+const testSecret = "cluster-secret"
+
+// testNode is an instance of a test cluster, reachable at url
+type testNode struct {
+	*Cluster
+	url   string
+	cache *cache.Cache
+	stats *stats.Stats
+}
+
+// newTestNode starts an instance serving /cluster/sync, with the given upstream servers
+// and peers (peers may be added before the first sync through addPeer)
+func newTestNode(t *testing.T, nodeID string, servers []string, peers ...string) *testNode {
+	t.Helper()
+	n := &testNode{
+		cache: cache.New(time.Hour, 1000),
+		stats: stats.New(2),
+	}
+	n.stats.InitializeServers(servers)
+	cfg := &config.ClusterConfig{
+		NodeID:       nodeID,
+		Peers:        peers,
+		Secret:       testSecret,
+		SyncInterval: time.Minute,
+		SyncTimeout:  5 * time.Second,
+	}
+	n.Cluster = New(cfg, n.cache, n.stats, servers, logging.NewLevels(nil).Flag(logging.Cache))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(SyncPath, func(w http.ResponseWriter, r *http.Request) { n.HandleSync(w, r) })
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	n.url = srv.URL
+	return n
+}
+
+// addPeer adds a peer before the node's first sync
+func (n *testNode) addPeer(peerURL string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.peers = append(n.peers, &peer{url: peerURL, pending: make(map[string]uint64)})
+}
+
+func TestSyncSharesCacheHealthAndCounters(t *testing.T) {
+	shared, extra := "https://shared.example.com", "https://extra.example.com"
+	a := newTestNode(t, "a", []string{shared})
+	b := newTestNode(t, "b", []string{shared, extra}, a.url)
+
+	hash := strings.Repeat("ab", 32)
+	b.cache.Add(hash, []string{shared, extra})
+	for i := 0; i < 2; i++ {
+		b.stats.RecordFailure(shared, "upload")
+	}
+	if b.stats.IsHealthyFor(shared, "upload") {
+		t.Fatal("shared server still healthy for uploads on b")
+	}
+	b.syncAll(context.Background())
+
+	// Only the servers a knows are taken over
+	if servers, ok := a.cache.Peek(hash); !ok || !reflect.DeepEqual(servers, []string{shared}) {
+		t.Errorf("a's cache entry = %v (found %v), want [%s]", servers, ok, shared)
+	}
+	if a.stats.IsHealthyFor(shared, "upload") {
+		t.Error("a did not adopt b's health determination")
+	}
+	status := a.Status(a.stats.GetAll())
+	if status.LiveNodes != 2 {
+		t.Errorf("a sees %d live nodes, want 2", status.LiveNodes)
+	}
+	if got := status.Servers[shared].UploadsFailure; got != 2 {
+		t.Errorf("a's summed upload failures for %s = %d, want b's 2", shared, got)
+	}
+	if _, ok := status.Servers[extra]; ok {
+		t.Errorf("a reports %s, which it doesn't serve", extra)
+	}
+	if pending := b.Status(b.stats.GetAll()).Peers[0].Pending; pending != 0 {
+		t.Errorf("b has %d pending cache changes after a successful sync, want 0", pending)
+	}
+
+	// Changes applied from a peer aren't echoed back to it
+	a.addPeer(b.url)
+	if pending := a.Status(a.stats.GetAll()).Peers[0].Pending; pending != 0 {
+		t.Errorf("a queued %d cache changes received from b, want 0", pending)
+	}
+}
+
+func TestSyncKeepsChangesPendingWhilePeerUnreachable(t *testing.T) {
+	a := newTestNode(t, "a", nil, "http://127.0.0.1:1")
+	a.cache.Add(strings.Repeat("cd", 32), []string{"https://upstream.example.com"})
+
+	a.syncAll(context.Background())
+	peerStatus := a.Status(nil).Peers[0]
+	if peerStatus.LastError == "" {
+		t.Error("unreachable peer has no last_error")
+	}
+	if peerStatus.Pending != 1 {
+		t.Errorf("pending cache changes = %d, want the change kept for the next exchange", peerStatus.Pending)
+	}
+}
+
+func TestHandleSyncRejectsBadSecret(t *testing.T) {
+	a := newTestNode(t, "a", nil)
+	for _, authorization := range []string{"", "Bearer wrong", testSecret} {
+		req := httptest.NewRequest(http.MethodPost, SyncPath, strings.NewReader(`{"node_id":"intruder"}`))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		a.HandleSync(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want 401", authorization, rec.Code)
+		}
+	}
+	if live := a.Status(nil).LiveNodes; live != 1 {
+		t.Errorf("live nodes = %d, want the rejected sender ignored", live)
+	}
+}
+
+func TestSyncDetectsSelf(t *testing.T) {
+	a := newTestNode(t, "a", nil)
+	a.addPeer(a.url)
+
+	a.syncAll(context.Background())
+	peerStatus := a.Status(nil).Peers[0]
+	if !peerStatus.Self {
+		t.Fatal("peer pointing at this instance not detected")
+	}
+	a.cache.Add(strings.Repeat("ef", 32), []string{"https://upstream.example.com"})
+	if pending := a.Status(nil).Peers[0].Pending; pending != 0 {
+		t.Errorf("%d cache changes queued for this instance itself, want 0", pending)
+	}
+}
+
+func TestLeaderIsLowestLiveNode(t *testing.T) {
+	a := newTestNode(t, "a", nil)
+	b := newTestNode(t, "b", nil, a.url)
+	a.addPeer(b.url)
+
+	if a.IsLeader() || b.IsLeader() {
+		t.Fatal("an instance considers itself leader before its first sync round")
+	}
+	b.syncAll(context.Background())
+	b.noteLeader()
+	a.syncAll(context.Background())
+	a.noteLeader()
+
+	if !a.IsLeader() {
+		t.Error("a is not leader, want the lowest node ID to lead")
+	}
+	if b.IsLeader() {
+		t.Error("b is leader next to a")
+	}
+	if leader := b.Status(nil).LeaderNode; leader != "a" {
+		t.Errorf("b's leader = %q, want a", leader)
+	}
+
+	// A silent leader is no longer live, so b takes over
+	b.mu.Lock()
+	b.nodes["a"].lastSeen = time.Now().Add(-nodeTimeoutIntervals * 2 * b.syncInterval)
+	b.mu.Unlock()
+	if !b.IsLeader() {
+		t.Error("b did not take over from a silent leader")
+	}
+}
+
+func TestSetKnownServerFollowsRuntimeChanges(t *testing.T) {
+	added := "https://added.example.com"
+	a := newTestNode(t, "a", nil)
+	b := newTestNode(t, "b", []string{added}, a.url)
+	hash := strings.Repeat("12", 32)
+
+	b.cache.Add(hash, []string{added})
+	b.syncAll(context.Background())
+	if _, ok := a.cache.Peek(hash); ok {
+		t.Fatal("a took over a cache entry for a server it doesn't serve")
+	}
+
+	a.SetKnownServer(added, true)
+	b.cache.Add(strings.Repeat("34", 32), []string{added})
+	b.syncAll(context.Background())
+	if servers, ok := a.cache.Peek(strings.Repeat("34", 32)); !ok || !reflect.DeepEqual(servers, []string{added}) {
+		t.Errorf("a's cache entry = %v (found %v), want the server added at runtime", servers, ok)
+	}
+
+	a.SetKnownServer(added, false)
+	b.cache.Add(strings.Repeat("56", 32), []string{added})
+	b.syncAll(context.Background())
+	if _, ok := a.cache.Peek(strings.Repeat("56", 32)); ok {
+		t.Error("a took over a cache entry for a removed server")
+	}
+}
@@ -0,0 +1,185 @@
+package cluster
+
+import (
+	"context"
+	"log"
+
+	"github.com/girino/blossom_espelhator/internal/journal"
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// maxHandoffPerSync bounds the retries handed over to the leader in one exchange
+const maxHandoffPerSync = 500
+
+// The leader is the live instance with the lowest node ID. Background jobs that would
+// otherwise send the same traffic to the mirrors from every instance run only there:
+// integrity spot checks (gated with IsLeader) and journal retries, which the other
+// instances hand over to the leader on their next exchange with it
+// An instance doesn't consider itself leader before its first sync round, so a restarted
+// instance doesn't briefly run the jobs next to the current leader
+
+// SetJournal enables handing journal retries over to the leader (and taking them over
+// when this instance is the leader). Must be called before Start
+func (cl *Cluster) SetJournal(j *journal.Journal) {
+	cl.journal = j
+	j.SetDeferred(cl.deferJournalEntry)
+}
+
+// leaderLocked returns the node ID of the leader, or "" before the first sync round
+// (must be called with lock held)
+func (cl *Cluster) leaderLocked() string {
+	if !cl.ready {
+		return ""
+	}
+	leader := cl.nodeID
+	for nodeID := range cl.liveNodesLocked() {
+		if nodeID < leader {
+			leader = nodeID
+		}
+	}
+	return leader
+}
+
+// isLeaderLocked reports whether this instance is the leader (must be called with lock held)
+func (cl *Cluster) isLeaderLocked() bool {
+	return cl.leaderLocked() == cl.nodeID
+}
+
+// IsLeader reports whether this instance runs the cluster's background jobs
+func (cl *Cluster) IsLeader() bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.isLeaderLocked()
+}
+
+// noteLeader marks the live nodes as known after a sync round and logs leadership changes
+func (cl *Cluster) noteLeader() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.ready = true
+	leader := cl.leaderLocked()
+	if leader == cl.lastLeader {
+		return
+	}
+	switch {
+	case leader == cl.nodeID:
+		log.Printf("Cluster: this instance (%s) is now the leader and runs the background jobs", cl.nodeID)
+	case cl.lastLeader == cl.nodeID:
+		logging.Warnf(context.Background(), "Cluster: node %s took over as leader, handing background jobs over to it", leader)
+	default:
+		log.Printf("Cluster: node %s is the leader", leader)
+	}
+	cl.lastLeader = leader
+}
+
+// handingOffLocked reports whether retries go to another instance: this one isn't the
+// leader, and the leader is live and has a journal (must be called with lock held)
+func (cl *Cluster) handingOffLocked() (string, bool) {
+	leader := cl.leaderLocked()
+	if leader == "" || leader == cl.nodeID {
+		return "", false
+	}
+	n, ok := cl.liveNodesLocked()[leader]
+	return leader, ok && n.acceptsJournal
+}
+
+// handsOff reports whether an entry is handed over to the leader rather than run here
+// Uploads of locally queued blobs always run here, since the blob is on this instance's disk
+func handsOff(entry journal.Entry) bool {
+	return entry.Kind != journal.KindUpload
+}
+
+// deferJournalEntry tells the journal to leave an entry to the leader (or to wait until
+// the first sync round tells who the leader is)
+func (cl *Cluster) deferJournalEntry(entry journal.Entry) bool {
+	if !handsOff(entry) {
+		return false
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if !cl.ready {
+		return true
+	}
+	_, handingOff := cl.handingOffLocked()
+	return handingOff
+}
+
+// handoffEntries returns the retries to hand over to a peer, if it is the leader
+func (cl *Cluster) handoffEntries(p *peer) []journal.Entry {
+	if cl.journal == nil {
+		return nil
+	}
+	cl.mu.Lock()
+	leader, handingOff := cl.handingOffLocked()
+	toLeader := handingOff && p.nodeID == leader
+	cl.mu.Unlock()
+	if !toLeader {
+		return nil
+	}
+
+	pending, err := cl.journal.Pending()
+	if err != nil {
+		logging.Warnf(context.Background(), "Cluster: failed to read journal for handoff: %v", err)
+		return nil
+	}
+	knownServers := cl.known()
+	entries := make([]journal.Entry, 0)
+	for _, entry := range pending {
+		if len(entries) >= maxHandoffPerSync {
+			break
+		}
+		if handsOff(entry) && knownServers[entry.ServerURL] {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// handedOver removes the retries the leader took over from the local journal
+func (cl *Cluster) handedOver(ids []uint64) {
+	if cl.journal == nil || len(ids) == 0 {
+		return
+	}
+	for _, id := range ids {
+		cl.journal.Remove(id)
+	}
+	cl.mu.Lock()
+	cl.handedOff += int64(len(ids))
+	cl.mu.Unlock()
+	log.Printf("Cluster: handed %d pending retries over to the leader", len(ids))
+}
+
+// takeOver imports retries handed over by another instance, if this instance is the
+// leader, and returns the IDs (in the sender's journal) it took over
+// Retries for the same work already pending here are taken over without being duplicated
+func (cl *Cluster) takeOver(from string, entries []journal.Entry) []uint64 {
+	if cl.journal == nil || len(entries) == 0 || !cl.IsLeader() {
+		return nil
+	}
+
+	knownServers := cl.known()
+	accepted := make([]uint64, 0, len(entries))
+	imported := 0
+	for _, entry := range entries {
+		if !handsOff(entry) || !knownServers[entry.ServerURL] {
+			continue
+		}
+		senderID := entry.ID
+		added, err := cl.journal.Import(entry)
+		if err != nil {
+			logging.Warnf(context.Background(), "Cluster: failed to take over %s of %s on %s from node %s: %v", entry.Kind, entry.Hash, entry.ServerURL, from, err)
+			continue
+		}
+		if added {
+			imported++
+		}
+		accepted = append(accepted, senderID)
+	}
+
+	cl.mu.Lock()
+	cl.takenOver += int64(len(accepted))
+	cl.mu.Unlock()
+	log.Printf("Cluster: took over %d pending retries from node %s (%d already pending here)", len(accepted), from, len(accepted)-imported)
+	return accepted
+}
@@ -2,22 +2,57 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/girino/blossom_espelhator/internal/version"
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the application configuration
 type Config struct {
-	UpstreamServers []UpstreamServer `yaml:"upstream_servers"`
-	Server          ServerConfig     `yaml:"server"`
+	UpstreamServers   []UpstreamServer `yaml:"upstream_servers"`
+	UpstreamDiscovery *DiscoveryConfig `yaml:"upstream_discovery,omitempty"` // Upstream set published in a Nostr event (optional)
+	Cluster           *ClusterConfig   `yaml:"cluster,omitempty"`            // State shared with other instances (optional)
+	Server            ServerConfig     `yaml:"server"`
+}
+
+// ClusterConfig makes several instances behind a load balancer share their view of the
+// upstreams: cache entries, health determinations and operation counters are exchanged
+// with the peers over HTTP, so instances don't each rediscover the same blobs and failures
+// Every instance should list the others (listing itself is harmless) with the same secret
+type ClusterConfig struct {
+	NodeID       string        `yaml:"node_id"`       // Unique name of this instance (default: hostname)
+	Peers        []string      `yaml:"peers"`         // Base URLs of the other instances (e.g. "http://10.0.0.2:8080")
+	Secret       string        `yaml:"secret"`        // Shared secret authenticating /cluster/sync requests (required)
+	SyncInterval time.Duration `yaml:"sync_interval"` // How often state is exchanged with each peer (default: 5s)
+	SyncTimeout  time.Duration `yaml:"sync_timeout"`  // Timeout of one exchange (default: 10s)
+}
+
+// DiscoveryConfig sources the upstream set from a Nostr list event published by the
+// operator (a BUD-03 kind 10063 server list, or a NIP-51 set), refreshed periodically,
+// so a fleet of proxies can be retargeted by publishing one event
+// Entries in upstream_servers with the same URL provide the options of discovered servers
+type DiscoveryConfig struct {
+	Pubkey          string        `yaml:"pubkey"`           // Author of the event (npub or hex)
+	Relays          []string      `yaml:"relays"`           // Relays to fetch the event from
+	Kind            int           `yaml:"kind"`             // Event kind (default: 10063)
+	DTag            string        `yaml:"d_tag"`            // "d" tag of the list, required for addressable kinds (30000-39999)
+	Mode            string        `yaml:"mode"`             // "replace" (default): the event is the upstream set; "merge": added to upstream_servers
+	RefreshInterval time.Duration `yaml:"refresh_interval"` // How often to look for a new event (default: 10m; negative disables)
+	FetchTimeout    time.Duration `yaml:"fetch_timeout"`    // Timeout for fetching the event from the relays (default: 15s)
+	CacheFile       string        `yaml:"cache_file"`       // Last fetched event, used when no relay answers at startup (default: none)
 }
 
 // UpstreamServer represents an upstream Blossom server configuration
 type UpstreamServer struct {
 	URL      string `yaml:"url"`
 	Priority int    `yaml:"priority"`
+	Weight   int    `yaml:"weight,omitempty"` // Share of selections among servers with the same priority, or among all servers with the weighted strategy (default: 1)
 
 	// Alternative address for direct connections (bypasses Cloudflare/proxy)
 	// If set, this address will be used for actual HTTP connections
@@ -25,50 +60,447 @@ type UpstreamServer struct {
 	// Example: "https://1.2.3.4" or "https://direct.example.com"
 	AlternativeAddress string `yaml:"alternative_address,omitempty"`
 
+	// Force HTTP/1.1 for connections to this server (for servers with a broken HTTP/2 implementation)
+	ForceHTTP1 bool `yaml:"force_http1,omitempty"`
+
+	// The server rejects chunked uploads: uploads of unknown length (chunked client requests)
+	// are spooled to disk before being sent to it, so it always gets a Content-Length, while
+	// the other servers keep receiving the upload streamed. Spool files go to
+	// server.upload_buffer_dir
+	RequiresContentLength bool `yaml:"requires_content_length,omitempty"`
+
+	// Response compression policy: "auto" (default, Go's transparent gzip), "off" (ask for
+	// uncompressed responses) or "force" (always ask for gzip/deflate and decode it)
+	// Compressed responses are decoded in every mode, even when the server sends them unasked
+	Compression string `yaml:"compression,omitempty"`
+
 	// Capabilities - which endpoints this server supports
 	// If not specified in config, defaults are:
 	// - supports_mirror: false (not all servers support BUD-04 mirror)
 	// - supports_upload_head: false (not all servers support BUD-06 HEAD /upload)
+	// - supports_list/supports_delete: auto-detect (assumed supported until the server answers
+	//   405/501, or 404 for list, after which it is no longer queried for that operation)
 	SupportsMirror     *bool `yaml:"supports_mirror,omitempty"`      // BUD-04: Mirroring
 	SupportsUploadHead *bool `yaml:"supports_upload_head,omitempty"` // BUD-06: Upload preflight
+	SupportsList       *bool `yaml:"supports_list,omitempty"`        // BUD-02: List blobs (unset = auto-detect)
+	SupportsDelete     *bool `yaml:"supports_delete,omitempty"`      // BUD-02: Delete blobs (unset = auto-detect)
+	SupportsMedia      *bool `yaml:"supports_media,omitempty"`       // BUD-05: Media optimization (unset = unknown; informational only)
+
+	// Health thresholds - override server.max_failures for this server, overall or per
+	// operation type (upload, download, mirror, delete, list)
+	MaxFailures            int            `yaml:"max_failures,omitempty"`
+	MaxFailuresByOperation map[string]int `yaml:"max_failures_by_operation,omitempty"`
+
+	// Request budget - override server.upstream_max_concurrent_requests and
+	// server.upstream_max_requests_per_second for this server (negative: unlimited)
+	MaxConcurrentRequests int     `yaml:"max_concurrent_requests,omitempty"`
+	MaxRequestsPerSecond  float64 `yaml:"max_requests_per_second,omitempty"`
+
+	// Operator contact metadata - shown in /stats, on the dashboard and in health warnings,
+	// so whoever runs the proxy knows whom to contact when the server goes down
+	Operator      string `yaml:"operator,omitempty"`        // Person or organization running the server
+	Contact       string `yaml:"contact,omitempty"`         // How to reach them (e.g., an email address, npub or URL)
+	StatusPageURL string `yaml:"status_page_url,omitempty"` // Public status page of the server
+
+	// Maintenance windows - recurring periods (e.g., a nightly backup) during which
+	// the server is excluded from download redirects and skipped by uploads while the
+	// other servers are enough for min_upload_servers
+	MaintenanceWindows []TimeWindow `yaml:"maintenance_windows,omitempty"`
+
+	// Chaos testing - artificial latency and failures injected into requests to this server
+	// Only applied when the proxy is started with -enable-chaos; ignored otherwise
+	Chaos *ChaosConfig `yaml:"chaos,omitempty"`
+}
+
+// ChaosConfig describes faults injected into requests to an upstream server
+// Used in staging to exercise failover, quorum and health tracking without breaking real mirrors
+type ChaosConfig struct {
+	Latency       time.Duration `yaml:"latency"`              // Added to every request
+	LatencyJitter time.Duration `yaml:"latency_jitter"`       // Random extra latency between 0 and this value
+	FailureRate   float64       `yaml:"failure_rate"`         // Fraction of requests that fail (0.0-1.0)
+	FailureStatus int           `yaml:"failure_status"`       // HTTP status returned for injected failures (0 = connection error)
+	Operations    []string      `yaml:"operations,omitempty"` // Operations affected (upload, download, mirror, delete, list). If empty, all
+}
+
+// TimeWindow represents a recurring daily time window
+// If End is earlier than Start, the window wraps past midnight (e.g., 23:00-01:00)
+type TimeWindow struct {
+	Start    string   `yaml:"start"`              // Start time in HH:MM (24h format)
+	End      string   `yaml:"end"`                // End time in HH:MM (24h format)
+	Days     []string `yaml:"days,omitempty"`     // Optional weekdays (mon, tue, ...). If empty, applies every day
+	Timezone string   `yaml:"timezone,omitempty"` // Optional IANA timezone (e.g., "America/Sao_Paulo"). Defaults to local time
+
+	// Parsed values (populated by Parse())
+	startMinutes int
+	endMinutes   int
+	days         map[time.Weekday]bool
+	location     *time.Location
 }
 
 // ServerConfig represents the proxy server configuration
 type ServerConfig struct {
 	ListenAddr               string        `yaml:"listen_addr"`
 	MinUploadServers         int           `yaml:"min_upload_servers"`
+	MinListServers           int           `yaml:"min_list_servers"`   // Minimum list-capable servers that must answer a /list request (default: 1)
+	ReplicationFactor        int           `yaml:"replication_factor"` // Long-term target number of replicas per blob (default: number of upstream servers)
 	RedirectStrategy         string        `yaml:"redirect_strategy"`
 	DownloadRedirectStrategy string        `yaml:"download_redirect_strategy"` // Fallback redirect strategy for GET requests (defaults to redirect_strategy)
+	DownloadRedirectSticky   bool          `yaml:"download_redirect_sticky"`   // Redirect a client to the same server for a given blob (hash of client address and blob) instead of using the strategy
+	DownloadMode             string        `yaml:"download_mode"`              // GET /<sha256>: "redirect" (307 to the selected upstream, default) or "proxy" (stream the blob through the proxy)
 	BaseURL                  string        `yaml:"base_url"`                   // Base URL for local strategy (overrides request-derived URL)
+	ResponseURLMode          string        `yaml:"response_url_mode"`          // Primary url in upload/mirror/list responses: "upstream" (default) or "prefer_base_url"
+	MaxAltLocations          int           `yaml:"max_alt_locations"`          // Maximum alternate replica URLs advertised on download redirects (default: 3)
+	DisableAltLocations      bool          `yaml:"disable_alt_locations"`      // Disable Link/X-Alt-Locations headers on download redirects
+	ServerTiming             bool          `yaml:"server_timing"`              // Add a Server-Timing header (hashing, fan-out, slowest upstream, selection) to uploads and downloads
+	URLTagsMode              string        `yaml:"url_tags_mode"`              // BUD-08 url tags in upload/mirror responses: "upstream" (default), "proxy" or "none"
+	URLTagsOrder             string        `yaml:"url_tags_order"`             // Order of upstream url tags: "selected_first" (default) or "upstream_order"
+	URLTagsHealthyOnly       bool          `yaml:"url_tags_healthy_only"`      // Only emit url tags for healthy servers
+	MaxURLTags               int           `yaml:"max_url_tags"`               // Maximum number of url tags (0 = unlimited)
 	Timeout                  time.Duration `yaml:"timeout"`                    // Timeout for download/HEAD/DELETE requests
 	MinUploadTimeout         time.Duration `yaml:"min_upload_timeout"`         // Minimum timeout for upload requests (default: 5 minutes)
 	MaxUploadTimeout         time.Duration `yaml:"max_upload_timeout"`         // Maximum timeout for upload requests (default: 30 minutes)
+	MaxRequestTimeout        time.Duration `yaml:"max_request_timeout"`        // Maximum upload/mirror timeout a client can request with X-Request-Timeout (default: max_upload_timeout)
+	MaxUploadBytes           int64         `yaml:"max_upload_bytes"`           // Largest blob accepted by uploads and mirrors, rejected with 413 (0 = unlimited, default: unlimited)
 	MaxRetries               int           `yaml:"max_retries"`
 
+	// Per-phase upstream timeouts - detect servers that accept connections but never answer
+	// without shortening the overall deadlines above (long body transfers are unaffected)
+	ConnectTimeout        time.Duration `yaml:"connect_timeout"`         // TCP connect timeout (default: 10s)
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout"`   // TLS handshake timeout (default: 10s)
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"` // Wait for response headers after sending the request (default: 60s; not applied to mirror)
+	UploadResponseTimeout time.Duration `yaml:"upload_response_timeout"` // Wait for a streamed upload's full response once the body was sent (default: 90s; negative disables)
+
+	// Circuit breaker - skip a server that keeps timing out instead of including it in every
+	// fan-out: after the threshold of timeouts or connection errors in a row, its requests
+	// fail immediately for the cool-down, then a single probe decides whether it recovered
+	CircuitBreakerThreshold int           `yaml:"circuit_breaker_threshold"` // Failures in a row opening the breaker (default: 5; negative disables)
+	CircuitBreakerCooldown  time.Duration `yaml:"circuit_breaker_cooldown"`  // Time the server is skipped before a probe (default: 30s)
+
+	// Outbound request budget per upstream server - so traffic spikes or repair runs can't
+	// overwhelm a small mirror. Requests beyond it wait up to the queue timeout for room,
+	// then fail without being sent. Upstream servers can override the ceilings
+	UpstreamMaxConcurrentRequests int           `yaml:"upstream_max_concurrent_requests"` // Requests in flight per server (0 = unlimited, default)
+	UpstreamMaxRequestsPerSecond  float64       `yaml:"upstream_max_requests_per_second"` // Requests started per second per server (0 = unlimited, default)
+	UpstreamBudgetQueueTimeout    time.Duration `yaml:"upstream_budget_queue_timeout"`    // How long a request waits for room (default: 5s; negative: fail fast)
+
+	// Staggering of parallel fan-outs (lookups, uploads, mirrors, preflights, lists): each
+	// request to an upstream starts after a random delay below the jitter, so bursts don't
+	// hit every server at the same instant
+	FanoutJitter time.Duration `yaml:"fanout_jitter"` // Maximum start delay of each fan-out request (0 = disabled, default)
+
+	// Identification of upstream requests, so mirror operators can recognize this deployment
+	UserAgent              string `yaml:"user_agent"`                // User-Agent sent upstream (default: blossom_espelhator/<version>)
+	ForwardedBy            string `yaml:"forwarded_by"`              // Value of an X-Forwarded-By header on all upstream requests (default: not sent)
+	ForwardClientUserAgent bool   `yaml:"forward_client_user_agent"` // Send the client's User-Agent on upload/mirror/delete requests instead
+
+	// Append the client's IP address to X-Forwarded-For on upload/mirror/delete requests
+	// (default: false - client IP headers are dropped and upstreams only see the proxy)
+	ForwardClientIP bool `yaml:"forward_client_ip"`
+
+	// Reverse proxies in front of the proxy (addresses or CIDR ranges): for requests coming
+	// from them, the client address used by rate limiting, scan detection and sticky
+	// redirects is taken from X-Forwarded-For or X-Real-IP (default: none, the address of
+	// the connection is used)
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// Largest upstream response body read for upload, mirror, list and delete requests
+	// (after decompression); larger responses count as failures (default: 16 MB; negative disables)
+	MaxUpstreamResponseBytes int64 `yaml:"max_upstream_response_bytes"`
+
 	// Health check configuration
 	MaxFailures    int   `yaml:"max_failures"`     // Maximum consecutive failures before marking server unhealthy
 	MaxGoroutines  int   `yaml:"max_goroutines"`   // Maximum number of goroutines before marking system unhealthy
 	MaxMemoryBytes int64 `yaml:"max_memory_bytes"` // Maximum memory usage in bytes before marking system unhealthy
 
+	// Load shedding - while memory or goroutines exceed the thresholds above, new uploads and
+	// mirrors are rejected with 503 + Retry-After (downloads are still served)
+	DisableLoadShedding    bool          `yaml:"disable_load_shedding"`     // Only report unhealthy, keep accepting uploads
+	LoadSheddingRetryAfter time.Duration `yaml:"load_shedding_retry_after"` // Retry-After sent with shed requests (default: 30s)
+
+	// Slow request logging - uploads, mirrors, downloads and HEAD requests exceeding either
+	// threshold are logged with their per-upstream breakdown and counted in /stats, to catch
+	// chronically slow mirrors without verbose logging
+	SlowRequestThreshold  time.Duration `yaml:"slow_request_threshold"`  // Total duration of a client request (0 = disabled)
+	SlowUpstreamThreshold time.Duration `yaml:"slow_upstream_threshold"` // Duration of a single upstream request (0 = disabled)
+
+	// Active health checks - probe every upstream in the background, so unreachable servers
+	// are marked unhealthy and unhealthy ones recover without waiting for live traffic
+	HealthCheckInterval          time.Duration `yaml:"health_check_interval"`           // Interval between probes (0 disables, default: disabled)
+	HealthCheckTimeout           time.Duration `yaml:"health_check_timeout"`            // Timeout of one probe (default: 10s)
+	HealthCheckFailureThreshold  int           `yaml:"health_check_failure_threshold"`  // Failed probes in a row before a server is marked unhealthy (default: 3)
+	HealthCheckRecoveryThreshold int           `yaml:"health_check_recovery_threshold"` // Successful probes in a row before an unhealthy server is marked healthy (default: 2)
+
+	// statsd export - push the /metrics statistics over UDP with DogStatsD tags, for
+	// environments without a Prometheus scraper
+	StatsdAddr     string        `yaml:"statsd_addr"`     // host:port of the statsd server (empty disables, default: disabled)
+	StatsdInterval time.Duration `yaml:"statsd_interval"` // Interval between pushes (default: 10s)
+	StatsdPrefix   string        `yaml:"statsd_prefix"`   // Prefix of the metric names (default: "espelhator.")
+
+	// OpenTelemetry tracing - one span per client request, with a child span per upstream
+	// request made for it, exported over OTLP/HTTP (e.g. to an OpenTelemetry Collector or Jaeger)
+	TracingEndpoint    string            `yaml:"tracing_endpoint"`     // OTLP/HTTP URL, e.g. http://otel-collector:4318 (empty disables, default: disabled)
+	TracingHeaders     map[string]string `yaml:"tracing_headers"`      // Headers sent with every export, e.g. an API key of a hosted backend
+	TracingServiceName string            `yaml:"tracing_service_name"` // service.name of the spans (default: blossom_espelhator)
+	TracingSampleRatio float64           `yaml:"tracing_sample_ratio"` // Share of client requests traced unless their traceparent decides (default: 1)
+
+	// Per-operation overrides of max_failures (upload, download, mirror, delete, list)
+	// Upstream servers can override both again with their own max_failures settings
+	MaxFailuresByOperation map[string]int `yaml:"max_failures_by_operation"`
+
 	// Cache configuration
-	CacheTTL    time.Duration `yaml:"cache_ttl"`     // Time-to-live for cache entries (default: 5 minutes)
-	CacheMaxSize int          `yaml:"cache_max_size"` // Maximum number of entries in cache (default: 1000)
+	CacheTTL     time.Duration `yaml:"cache_ttl"`      // Time-to-live for cache entries (default: 5 minutes)
+	CacheMaxSize int           `yaml:"cache_max_size"` // Maximum number of entries in cache (default: 1000)
+	CachePath    string        `yaml:"cache_path"`     // BoltDB file persisting the cache across restarts (empty disables, default: disabled)
+	// Negative caching - hashes found on no upstream are answered 404 without asking them
+	// again for this long (uploads and mirrors of the hash through the proxy end it early)
+	NegativeCacheTTL time.Duration `yaml:"negative_cache_ttl"` // (default: 30s; negative disables)
+
+	// Cache backend shared by several instances - "memory" keeps the cache per instance,
+	// "redis" also stores it in Redis, where the other instances find it
+	CacheBackend        string `yaml:"cache_backend"`          // memory or redis (default: memory)
+	CacheRedisAddr      string `yaml:"cache_redis_addr"`       // Redis server as host:port (required by the redis backend)
+	CacheRedisUsername  string `yaml:"cache_redis_username"`   // Redis ACL user (default: none, the password alone authenticates)
+	CacheRedisPassword  string `yaml:"cache_redis_password"`   // Redis password (default: none)
+	CacheRedisDB        int    `yaml:"cache_redis_db"`         // Redis database number (default: 0)
+	CacheRedisKeyPrefix string `yaml:"cache_redis_key_prefix"` // Prefix of the keys, followed by the hash (default: "espelhator:cache:")
+	CacheRedisTLS       bool   `yaml:"cache_redis_tls"`        // Connect to Redis over TLS, verified against the system CAs (default: false)
+	CacheRedisPoolSize  int    `yaml:"cache_redis_pool_size"`  // Connections to Redis open at once (default: 8)
+
+	// Hash scanning detection - a client whose lookups miss on every upstream for many
+	// distinct hashes is answered 404 for hashes not in the cache, without asking upstreams
+	ScanMissThreshold int           `yaml:"scan_miss_threshold"` // Distinct missing hashes within scan_window flagging a client (0 disables, default: disabled)
+	ScanWindow        time.Duration `yaml:"scan_window"`         // Window over which missing hashes are counted (default: 1m)
+	ScanBlockDuration time.Duration `yaml:"scan_block_duration"` // How long a flagged client's lookups are short-circuited (default: 10m)
+
+	// Rate limiting - token buckets checked before the handlers, per client address (or per
+	// pubkey for the pubkeys of rate_limits), separately for uploads and downloads
+	RateLimitUploadRPS     float64           `yaml:"rate_limit_upload_rps"`     // Uploads, mirrors and deletes per second per client (0 disables, default: disabled)
+	RateLimitUploadBurst   int               `yaml:"rate_limit_upload_burst"`   // Uploads allowed at once (default: rate_limit_upload_rps rounded up)
+	RateLimitDownloadRPS   float64           `yaml:"rate_limit_download_rps"`   // Downloads, HEADs and lists per second per client (0 disables, default: disabled)
+	RateLimitDownloadBurst int               `yaml:"rate_limit_download_burst"` // Downloads allowed at once (default: rate_limit_download_rps rounded up)
+	RateLimits             []RateLimitConfig `yaml:"rate_limits"`               // Per-pubkey limits replacing the ones above
+
+	// Integrity spot checks - periodically download a random cached blob from each
+	// server holding it and verify its SHA-256
+	IntegrityCheckInterval time.Duration `yaml:"integrity_check_interval"`  // Interval between checks (0 disables, default: disabled)
+	IntegrityCheckMaxBytes int64         `yaml:"integrity_check_max_bytes"` // Skip blobs larger than this (default: 50 MB)
+	IntegrityCheckTimeout  time.Duration `yaml:"integrity_check_timeout"`   // Time allowed for downloading a blob from each server (default: 2 minutes)
+
+	// Replication repair - periodically walks the cache for blobs found on fewer than
+	// min_upload_servers servers and mirrors them from a server that has them
+	RepairInterval  time.Duration `yaml:"repair_interval"`   // Interval between rounds (0 disables, default: disabled)
+	RepairBatchSize int           `yaml:"repair_batch_size"` // Under-replicated blobs checked per round (default: 20)
+	RepairSecretKey string        `yaml:"repair_secret_key"` // Nostr secret key (hex or nsec) signing the mirror requests, for servers requiring auth (default: unsigned)
+
+	// Settling period - after an upload, 404s from upstreams for that hash are retried and
+	// then ignored, and servers confirmed in the upload response are preferred for redirects
+	SettlingPeriod     time.Duration `yaml:"settling_period"`      // Length of the settling period (default: 30s)
+	SettlingRetries    int           `yaml:"settling_retries"`     // Lookup retries while settling (default: 2)
+	SettlingRetryDelay time.Duration `yaml:"settling_retry_delay"` // Delay between lookup retries (default: 500ms)
+	DisableSettling    bool          `yaml:"disable_settling"`     // Disable settling period handling
+
+	// 202 Accepted handling - servers that queue a blob for asynchronous processing are polled
+	// with HEAD until the blob is available before they count as replicas
+	AcceptedPollInterval time.Duration `yaml:"accepted_poll_interval"` // Interval between availability checks (default: 2s)
+	AcceptedPollTimeout  time.Duration `yaml:"accepted_poll_timeout"`  // Stop polling after this long (default: 2m)
+
+	// Conditional mirroring - HEAD each server before mirroring a blob to it and skip the
+	// transfer (counting it as a success) if the server already has the blob
+	DisableConditionalMirror bool `yaml:"disable_conditional_mirror"`
+
+	// Partial list results - when some list-capable servers fail or time out, /list returns
+	// the merged results of the others with X-Partial: true, unless this is set, in which
+	// case the request fails with 502 instead
+	ListFailOnPartial bool `yaml:"list_fail_on_partial"`
+
+	// Upload spool - keeps a local copy of uploads so GET/HEAD for a blob can be served
+	// while upstream servers are still processing it
+	UploadSpoolRetention time.Duration `yaml:"upload_spool_retention"` // How long to keep completed uploads (0 disables, default: disabled)
+	UploadSpoolDir       string        `yaml:"upload_spool_dir"`       // Directory for spool files (default: system temp directory)
+	UploadSpoolMaxBytes  int64         `yaml:"upload_spool_max_bytes"` // Don't spool uploads larger than this (default: 100 MB)
+
+	// Blob cache - keeps downloaded blobs on local disk, evicting the least recently used, so
+	// repeat downloads of hot blobs are served by the proxy without contacting upstreams
+	BlobCacheMaxBytes      int64         `yaml:"blob_cache_max_bytes"`      // Total size of the cached blobs (0 disables, default: disabled)
+	BlobCacheDir           string        `yaml:"blob_cache_dir"`            // Directory of the cached blobs (default: espelhator-blobs in the system temp directory)
+	BlobCacheMaxBlobBytes  int64         `yaml:"blob_cache_max_blob_bytes"` // Don't cache blobs larger than this (default: 100 MB)
+	BlobCacheFillTimeout   time.Duration `yaml:"blob_cache_fill_timeout"`   // Timeout of the background download filling the cache after a redirect (default: 5m)
+	BlobCacheMinPopularity float64       `yaml:"blob_cache_min_popularity"` // Popularity score a blob needs to be cached (default: 0, cached on its first download)
+
+	// Blob popularity - download requests per blob, as a score halving every
+	// popularity_half_life, reported by /admin/popular; the blob cache never evicts blobs
+	// for less popular ones
+	PopularityHalfLife  time.Duration `yaml:"popularity_half_life"`  // Time for a blob's score to halve without downloads (default: 1h)
+	PopularityMaxHashes int           `yaml:"popularity_max_hashes"` // Blobs tracked, the least popular being forgotten (-1 disables, default: 10000)
+
+	// Trending prefetch - a blob whose popularity score crosses prefetch_min_popularity while
+	// it is on a single upstream is mirrored to more servers, so its redirects are spread
+	PrefetchMinPopularity float64 `yaml:"prefetch_min_popularity"` // Popularity score triggering the prefetch (0 disables, default: disabled)
+	PrefetchReplicas      int     `yaml:"prefetch_replicas"`       // Servers a trending blob is mirrored to (default: 2)
+
+	// Hash blocklist - banned blobs are never uploaded, mirrored or served; managed through
+	// /admin/blocklist
+	BlocklistPath string `yaml:"blocklist_path"` // JSON file persisting the blocked hashes (empty: kept in memory until restart)
+
+	// Per-server upload buffers - streamed uploads are fed to each upstream from its own
+	// buffer, so a slow server doesn't throttle the others; a full buffer spills to disk
+	UploadBufferBytes         int    `yaml:"upload_buffer_bytes"`           // Memory buffer per upstream and upload (default: 1 MB)
+	UploadBufferDir           string `yaml:"upload_buffer_dir"`             // Directory for spill files (default: system temp directory)
+	UploadBufferMaxSpillBytes int64  `yaml:"upload_buffer_max_spill_bytes"` // Largest spill file per upstream and upload; a server needing more is dropped from the upload (default: 1 GB; negative: unlimited)
+	DisableUploadBufferSpill  bool   `yaml:"disable_upload_buffer_spill"`   // Wait for slow servers instead of spilling to disk
+
+	// Slow-upstream detach - a server that falls too far behind the others during a streamed
+	// upload is dropped from it (counted as failed, retried through the journal if enabled)
+	SlowUpstreamDetachBytes int64         `yaml:"slow_upstream_detach_bytes"` // Bytes behind the fastest server (0 disables, default: disabled)
+	SlowUpstreamDetachAfter time.Duration `yaml:"slow_upstream_detach_after"` // Time since the fastest server completed its upload (0 disables, default: disabled)
+
+	// Idempotent uploads - a retried PUT of a blob the same pubkey uploaded within this window
+	// is answered with the previous response instead of being fanned out again
+	IdempotentUploadWindow   time.Duration `yaml:"idempotent_upload_window"`   // How long responses are remembered (default: 5m)
+	DisableIdempotentUploads bool          `yaml:"disable_idempotent_uploads"` // Always fan out retried uploads
+
+	// Pending-operation journal - persists background work (retried uploads, mirrors and
+	// deletes that failed on some servers) so it survives restarts
+	JournalPath          string        `yaml:"journal_path"`           // BoltDB file for the journal (empty disables, default: disabled)
+	JournalRetryInterval time.Duration `yaml:"journal_retry_interval"` // Base delay between attempts, doubled after each failure (default: 30s)
+	JournalMaxAttempts   int           `yaml:"journal_max_attempts"`   // Attempts before an operation is dropped (default: 10)
+	JournalMaxAge        time.Duration `yaml:"journal_max_age"`        // Operations older than this are dropped (default: 24h)
+
+	// Degraded mode - behavior when no upstream server is healthy for an operation
+	DegradedRetryAfter       time.Duration `yaml:"degraded_retry_after"`        // Retry-After sent with degraded-mode 503s (default: 30s)
+	QueueUploadsWhenDegraded bool          `yaml:"queue_uploads_when_degraded"` // Store uploads locally and replay them via the journal (requires journal_path)
+	DegradedUploadDir        string        `yaml:"degraded_upload_dir"`         // Directory for queued uploads (default: "queued-uploads" next to journal_path)
+	DegradedUploadMaxBytes   int64         `yaml:"degraded_upload_max_bytes"`   // Total size of the queued uploads (default: 1 GB; negative: unlimited)
 
 	// Authentication configuration
-	AllowedPubkeys []string `yaml:"allowed_pubkeys"` // List of allowed pubkeys (hex format or npub bech32 format). If empty, auth is disabled
+	AllowedPubkeys []string `yaml:"allowed_pubkeys"` // List of allowed pubkeys (hex format or npub bech32 format). If empty, any signed event is accepted where auth is required
+
+	// Endpoints requiring a valid Nostr authorization event (kind 24242): upload, mirror,
+	// delete, list, get (downloads and HEAD). Default: upload, mirror, delete and list when
+	// allowed_pubkeys is set, otherwise none
+	AuthEndpoints []string `yaml:"auth_endpoints"`
+
+	// Who may list a pubkey's blobs with GET /list/<pubkey>: "public" (default, authenticated
+	// only if list is in auth_endpoints), "auth" (always requires a list authorization event)
+	// or "owner" (requires one signed by the listed pubkey itself or one of status_pubkeys)
+	ListAccess string `yaml:"list_access"`
+
+	// Upload quotas per authenticated pubkey (requires upload in auth_endpoints), counted
+	// over a window starting with the pubkey's first upload
+	UploadQuotaBytes  int64               `yaml:"upload_quota_bytes"`  // Bytes each pubkey may upload per window (default: 0 = unlimited)
+	UploadQuotaBlobs  int                 `yaml:"upload_quota_blobs"`  // Blobs each pubkey may upload per window (default: 0 = unlimited)
+	UploadQuotaWindow time.Duration       `yaml:"upload_quota_window"` // Length of the window (default: 24h)
+	UploadQuotas      []UploadQuotaConfig `yaml:"upload_quotas"`       // Per-pubkey limits replacing the ones above
+	// Status page access - who sees full details on /, /stats and /health
+	StatusAccess   string   `yaml:"status_access"`   // "public" (default), "minimal" (anonymous visitors get minimal output) or "auth" (anonymous visitors are rejected, except the minimal /health)
+	StatusUsername string   `yaml:"status_username"` // HTTP basic auth credentials for the status pages
+	StatusPassword string   `yaml:"status_password"`
+	StatusPubkeys  []string `yaml:"status_pubkeys"` // Pubkeys (hex or npub) allowed to see the status pages with Nostr auth
+
+	// Admin API credentials - if either is set, only they may change the proxy's state through
+	// /admin/* (status credentials no longer do); otherwise the status credentials are used
+	AdminToken   string   `yaml:"admin_token"`   // Static token sent as "Authorization: Bearer <token>" (at least 16 characters)
+	AdminPubkeys []string `yaml:"admin_pubkeys"` // Pubkeys (hex or npub) whose Nostr authorization events are accepted
+
+	// Replace upstream URLs and hostnames with opaque labels (server-1, server-2, ...) in
+	// /stats, /health, the home page and error messages; logs keep the real URLs
+	RedactUpstreams bool `yaml:"redact_upstreams"`
+
+	// Recent uploads, mirrors and deletes shown on the home page (kept in memory)
+	ActivityFeedSize int `yaml:"activity_feed_size"` // Number of events kept (default: 20; negative disables)
+
+	// Sharding configuration - assigns blobs to upstream subsets by hash prefix
+	// If empty, every blob is replicated to all upstream servers
+	Shards []ShardConfig `yaml:"shards"`
+
+	// Upload routing by content type - sends uploads of some MIME types only to a subset
+	// of upstream servers (e.g. videos only to the servers with large disks)
+	// The first matching route wins; uploads matching no route go to all servers (or their shard)
+	ContentRoutes []ContentRouteConfig `yaml:"content_routes"`
+
+	// Upload routing and quorum by blob size - ordered rules; the first one matching the size
+	// announced by the client applies (e.g. blobs over 100 MB only need 1 replica, on the
+	// archive server). Uploads of unknown size match no rule
+	SizeRoutes []SizeRouteConfig `yaml:"size_routes"`
+}
+
+// UploadQuotaConfig overrides the upload quota of some pubkeys
+// Limits left at 0 keep the global value; -1 means unlimited
+type UploadQuotaConfig struct {
+	Pubkeys  []string `yaml:"pubkeys"`   // Pubkeys (hex or npub) the limits apply to
+	MaxBytes int64    `yaml:"max_bytes"` // Bytes per window
+	MaxBlobs int      `yaml:"max_blobs"` // Blobs per window
+}
+
+// RateLimitConfig overrides the rate limits of some pubkeys, whose authenticated requests
+// are then limited per pubkey instead of per client address
+// Rates left at 0 keep the global value; -1 means unlimited
+type RateLimitConfig struct {
+	Pubkeys       []string `yaml:"pubkeys"`        // Pubkeys (hex or npub) the limits apply to
+	UploadRPS     float64  `yaml:"upload_rps"`     // Uploads, mirrors and deletes per second
+	UploadBurst   int      `yaml:"upload_burst"`   // Uploads allowed at once (default: upload_rps rounded up)
+	DownloadRPS   float64  `yaml:"download_rps"`   // Downloads, HEADs and lists per second
+	DownloadBurst int      `yaml:"download_burst"` // Downloads allowed at once (default: download_rps rounded up)
+}
+
+// SizeRouteConfig changes the upstream servers and/or the quorum of uploads in a size range
+type SizeRouteConfig struct {
+	Name             string   `yaml:"name"`               // Optional name used in logs and the X-Upload-Route header (default: size-<n>)
+	MinBytes         int64    `yaml:"min_bytes"`          // Smallest matching size (inclusive)
+	MaxBytes         int64    `yaml:"max_bytes"`          // Largest matching size (exclusive, 0 = no limit)
+	Servers          []string `yaml:"servers"`            // Upstream server URLs receiving these uploads (empty = unchanged)
+	MinUploadServers int      `yaml:"min_upload_servers"` // Quorum for these uploads, overriding the others (default: unchanged, or min(min_upload_servers, len(servers)))
+}
+
+// ContentRouteConfig assigns uploads of some content types to a subset of upstream servers
+type ContentRouteConfig struct {
+	Name             string   `yaml:"name"`               // Optional name used in logs and the X-Upload-Route header
+	Types            []string `yaml:"types"`              // MIME types ("image/png") or classes ("video/*")
+	Servers          []string `yaml:"servers"`            // Upstream server URLs receiving these uploads
+	MinUploadServers int      `yaml:"min_upload_servers"` // Per-route quorum (default: min(min_upload_servers, len(servers)))
+}
+
+// ShardConfig assigns a range of hash prefixes to a subset of upstream servers
+type ShardConfig struct {
+	Name             string   `yaml:"name"`               // Optional name used in logs
+	Prefixes         []string `yaml:"prefixes"`           // Hex prefix ranges (e.g., "0-7", "a", "00-3f")
+	Servers          []string `yaml:"servers"`            // Upstream server URLs that store this shard
+	MinUploadServers int      `yaml:"min_upload_servers"` // Per-shard quorum (default: min(min_upload_servers, len(servers)))
+
+	// Parsed prefix ranges (populated by parse())
+	ranges [][2]string
 }
 
 // Load reads and parses the configuration file
 func Load(path string) (*Config, error) {
+	return LoadWith(path, nil)
+}
+
+// LoadWith is like Load, but calls prepare (if not nil) on the parsed configuration before
+// defaults are set and it is validated, e.g. to fill in upstream servers discovered at runtime
+func LoadWith(path string, prepare func(*Config) error) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	// The document is kept to locate problems found during validation
+	var root yaml.Node
 	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if err := root.Decode(&config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	if prepare != nil {
+		if err := prepare(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	// Every problem is collected and reported at once, with its line in the file
+	v := &validator{root: &root}
 
 	// Set defaults
 	if config.Server.ListenAddr == "" {
@@ -77,9 +509,36 @@ func Load(path string) (*Config, error) {
 	if config.Server.MinUploadServers == 0 {
 		config.Server.MinUploadServers = 2
 	}
+	if config.Server.MinListServers == 0 {
+		config.Server.MinListServers = 1
+	}
+	if config.Server.StatusAccess == "" {
+		config.Server.StatusAccess = "public"
+	}
+	if config.Server.ListAccess == "" {
+		config.Server.ListAccess = "public"
+	}
+	if config.Server.ActivityFeedSize == 0 {
+		config.Server.ActivityFeedSize = 20 // Default: 20 events
+	}
+	if config.Server.URLTagsMode == "" {
+		config.Server.URLTagsMode = "upstream"
+	}
+	if config.Server.ResponseURLMode == "" {
+		config.Server.ResponseURLMode = "upstream"
+	}
+	if config.Server.URLTagsOrder == "" {
+		config.Server.URLTagsOrder = "selected_first"
+	}
 	if config.Server.RedirectStrategy == "" {
 		config.Server.RedirectStrategy = "round_robin"
 	}
+	if config.Server.DownloadMode == "" {
+		config.Server.DownloadMode = "redirect"
+	}
+	if config.Server.MaxAltLocations == 0 {
+		config.Server.MaxAltLocations = 3
+	}
 	if config.Server.Timeout == 0 {
 		config.Server.Timeout = 30 * time.Second
 	}
@@ -89,12 +548,90 @@ func Load(path string) (*Config, error) {
 	if config.Server.MaxUploadTimeout == 0 {
 		config.Server.MaxUploadTimeout = 30 * time.Minute // Default 30 minutes maximum for uploads
 	}
+	if config.Server.MaxRequestTimeout == 0 {
+		config.Server.MaxRequestTimeout = config.Server.MaxUploadTimeout
+	}
 	if config.Server.MaxRetries == 0 {
 		config.Server.MaxRetries = 3
 	}
+	if config.Server.ConnectTimeout == 0 {
+		config.Server.ConnectTimeout = 10 * time.Second
+	}
+	if config.Server.TLSHandshakeTimeout == 0 {
+		config.Server.TLSHandshakeTimeout = 10 * time.Second
+	}
+	if config.Server.ResponseHeaderTimeout == 0 {
+		config.Server.ResponseHeaderTimeout = 60 * time.Second
+	}
+	if config.Server.CircuitBreakerThreshold == 0 {
+		config.Server.CircuitBreakerThreshold = 5 // Default: 5 failures in a row
+	}
+	if config.Server.UpstreamBudgetQueueTimeout == 0 {
+		config.Server.UpstreamBudgetQueueTimeout = 5 * time.Second // Default: 5 seconds
+	}
+	if config.Server.UpstreamMaxConcurrentRequests < 0 {
+		v.addf("server.upstream_max_concurrent_requests", "must not be negative")
+	}
+	if config.Server.UpstreamMaxRequestsPerSecond < 0 {
+		v.addf("server.upstream_max_requests_per_second", "must not be negative")
+	}
+	if config.Server.CircuitBreakerCooldown == 0 {
+		config.Server.CircuitBreakerCooldown = 30 * time.Second // Default: 30 seconds
+	}
+	if config.Server.UserAgent == "" {
+		config.Server.UserAgent = version.UserAgent()
+	}
+	if config.Server.MaxUpstreamResponseBytes == 0 {
+		config.Server.MaxUpstreamResponseBytes = 16 * 1024 * 1024 // Default: 16 MB
+	}
+	if config.Server.UploadResponseTimeout == 0 {
+		config.Server.UploadResponseTimeout = 90 * time.Second
+	}
 	if config.Server.MaxFailures == 0 {
 		config.Server.MaxFailures = 5 // Default: 5 consecutive failures before unhealthy
 	}
+	if config.Server.HealthCheckTimeout == 0 {
+		config.Server.HealthCheckTimeout = 10 * time.Second // Default: 10 seconds
+	}
+	if config.Server.HealthCheckFailureThreshold == 0 {
+		config.Server.HealthCheckFailureThreshold = 3 // Default: 3 failed probes
+	}
+	if config.Server.HealthCheckRecoveryThreshold == 0 {
+		config.Server.HealthCheckRecoveryThreshold = 2 // Default: 2 successful probes
+	}
+	for field, value := range map[string]int64{
+		"health_check_interval":           int64(config.Server.HealthCheckInterval),
+		"health_check_timeout":            int64(config.Server.HealthCheckTimeout),
+		"health_check_failure_threshold":  int64(config.Server.HealthCheckFailureThreshold),
+		"health_check_recovery_threshold": int64(config.Server.HealthCheckRecoveryThreshold),
+	} {
+		if value < 0 {
+			v.addf("server."+field, "must not be negative")
+		}
+	}
+	if config.Server.StatsdInterval == 0 {
+		config.Server.StatsdInterval = 10 * time.Second // Default: 10 seconds
+	}
+	if config.Server.StatsdInterval < 0 {
+		v.addf("server.statsd_interval", "must be positive")
+	}
+	if config.Server.StatsdPrefix == "" {
+		config.Server.StatsdPrefix = "espelhator." // Default: espelhator.
+	}
+	if config.Server.TracingEndpoint != "" {
+		if u, err := url.Parse(config.Server.TracingEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.addf("server.tracing_endpoint", "must be an http:// or https:// URL")
+		}
+	}
+	if config.Server.TracingServiceName == "" {
+		config.Server.TracingServiceName = "blossom_espelhator"
+	}
+	if config.Server.TracingSampleRatio == 0 {
+		config.Server.TracingSampleRatio = 1 // Default: trace every request
+	}
+	if config.Server.TracingSampleRatio < 0 || config.Server.TracingSampleRatio > 1 {
+		v.addf("server.tracing_sample_ratio", "must be between 0 and 1")
+	}
 	if config.Server.MaxGoroutines == 0 {
 		config.Server.MaxGoroutines = 1000 // Default: 1000 goroutines max
 	}
@@ -104,12 +641,134 @@ func Load(path string) (*Config, error) {
 	if config.Server.CacheTTL == 0 {
 		config.Server.CacheTTL = 5 * time.Minute // Default: 5 minutes
 	}
+	if config.Server.IntegrityCheckMaxBytes == 0 {
+		config.Server.IntegrityCheckMaxBytes = 50 * 1024 * 1024 // Default: 50 MB
+	}
+	if config.Server.IntegrityCheckTimeout == 0 {
+		config.Server.IntegrityCheckTimeout = 2 * time.Minute // Default: 2 minutes
+	}
+	if config.Server.RepairBatchSize == 0 {
+		config.Server.RepairBatchSize = 20 // Default: 20 blobs per round
+	}
+	if config.Server.SettlingPeriod == 0 {
+		config.Server.SettlingPeriod = 30 * time.Second // Default: 30 seconds
+	}
+	if config.Server.SettlingRetries == 0 {
+		config.Server.SettlingRetries = 2 // Default: 2 retries
+	}
+	if config.Server.SettlingRetryDelay == 0 {
+		config.Server.SettlingRetryDelay = 500 * time.Millisecond // Default: 500ms
+	}
+	if config.Server.AcceptedPollInterval == 0 {
+		config.Server.AcceptedPollInterval = 2 * time.Second // Default: 2 seconds
+	}
+	if config.Server.AcceptedPollTimeout == 0 {
+		config.Server.AcceptedPollTimeout = 2 * time.Minute // Default: 2 minutes
+	}
+	if config.Server.IdempotentUploadWindow == 0 {
+		config.Server.IdempotentUploadWindow = 5 * time.Minute // Default: 5 minutes
+	}
+	if config.Server.LoadSheddingRetryAfter == 0 {
+		config.Server.LoadSheddingRetryAfter = 30 * time.Second // Default: 30 seconds
+	}
+	if config.Server.DegradedRetryAfter == 0 {
+		config.Server.DegradedRetryAfter = 30 * time.Second // Default: 30 seconds
+	}
+	if config.Server.SlowRequestThreshold < 0 {
+		v.addf("server.slow_request_threshold", "must not be negative")
+	}
+	if config.Server.SlowUpstreamThreshold < 0 {
+		v.addf("server.slow_upstream_threshold", "must not be negative")
+	}
+	if config.Server.QueueUploadsWhenDegraded {
+		if config.Server.JournalPath == "" {
+			v.addf("server.queue_uploads_when_degraded", "requires journal_path")
+		} else if config.Server.DegradedUploadDir == "" {
+			config.Server.DegradedUploadDir = filepath.Join(filepath.Dir(config.Server.JournalPath), "queued-uploads")
+		}
+	}
+	if config.Server.DegradedUploadMaxBytes == 0 {
+		config.Server.DegradedUploadMaxBytes = 1024 * 1024 * 1024 // Default: 1 GB
+	}
+	if config.Server.JournalRetryInterval == 0 {
+		config.Server.JournalRetryInterval = 30 * time.Second // Default: 30 seconds
+	}
+	if config.Server.JournalMaxAttempts == 0 {
+		config.Server.JournalMaxAttempts = 10 // Default: 10 attempts
+	}
+	if config.Server.JournalMaxAge == 0 {
+		config.Server.JournalMaxAge = 24 * time.Hour // Default: 24 hours
+	}
+	if config.Server.UploadBufferBytes <= 0 {
+		config.Server.UploadBufferBytes = 1024 * 1024 // Default: 1 MB
+	}
+	if config.Server.UploadBufferMaxSpillBytes == 0 {
+		config.Server.UploadBufferMaxSpillBytes = 1024 * 1024 * 1024 // Default: 1 GB
+	}
+	if config.Server.BlobCacheDir == "" {
+		config.Server.BlobCacheDir = filepath.Join(os.TempDir(), "espelhator-blobs")
+	}
+	if config.Server.BlobCacheMaxBlobBytes == 0 {
+		config.Server.BlobCacheMaxBlobBytes = 100 * 1024 * 1024 // Default: 100 MB
+	}
+	if config.Server.BlobCacheFillTimeout == 0 {
+		config.Server.BlobCacheFillTimeout = 5 * time.Minute // Default: 5 minutes
+	}
+	if config.Server.BlobCacheMinPopularity < 0 {
+		v.addf("server.blob_cache_min_popularity", "must not be negative")
+	}
+	if config.Server.PopularityHalfLife == 0 {
+		config.Server.PopularityHalfLife = time.Hour // Default: 1 hour
+	} else if config.Server.PopularityHalfLife < 0 {
+		v.addf("server.popularity_half_life", "must not be negative")
+	}
+	if config.Server.PopularityMaxHashes == 0 {
+		config.Server.PopularityMaxHashes = 10000 // Default: 10000 blobs
+	} else if config.Server.PopularityMaxHashes < -1 {
+		v.addf("server.popularity_max_hashes", "must be -1 (disabled) or positive")
+	}
+	if config.Server.PrefetchMinPopularity < 0 {
+		v.addf("server.prefetch_min_popularity", "must not be negative")
+	} else if config.Server.PrefetchMinPopularity > 0 && config.Server.PopularityMaxHashes == -1 {
+		v.addf("server.prefetch_min_popularity", "requires popularity tracking (popularity_max_hashes is -1)")
+	}
+	if config.Server.PrefetchReplicas == 0 {
+		config.Server.PrefetchReplicas = 2 // Default: 2 servers
+	} else if config.Server.PrefetchReplicas < 2 {
+		v.addf("server.prefetch_replicas", "must be at least 2")
+	}
+	for field, value := range map[string]int64{
+		"blob_cache_max_bytes":      config.Server.BlobCacheMaxBytes,
+		"blob_cache_max_blob_bytes": config.Server.BlobCacheMaxBlobBytes,
+		"blob_cache_fill_timeout":   int64(config.Server.BlobCacheFillTimeout),
+	} {
+		if value < 0 {
+			v.addf("server."+field, "must not be negative")
+		}
+	}
+	if config.Server.UploadSpoolMaxBytes == 0 {
+		config.Server.UploadSpoolMaxBytes = 100 * 1024 * 1024 // Default: 100 MB
+	}
 	if config.Server.CacheMaxSize == 0 {
 		config.Server.CacheMaxSize = 1000 // Default: 1000 entries
 	}
 
 	// Set default capabilities for upstream servers (default to false for optional endpoints)
 	for i := range config.UpstreamServers {
+		field := fmt.Sprintf("upstream_servers[%d]", i)
+		if config.UpstreamServers[i].Weight < 0 {
+			v.addf(field+".weight", "must not be negative")
+		}
+		if config.UpstreamServers[i].Weight == 0 {
+			config.UpstreamServers[i].Weight = 1
+		}
+		switch config.UpstreamServers[i].Compression {
+		case "":
+			config.UpstreamServers[i].Compression = "auto"
+		case "auto", "off", "force":
+		default:
+			v.addf(field+".compression", "invalid value %q (expected auto, off or force)", config.UpstreamServers[i].Compression)
+		}
 		if config.UpstreamServers[i].SupportsMirror == nil {
 			defaultMirror := false
 			config.UpstreamServers[i].SupportsMirror = &defaultMirror
@@ -120,11 +779,776 @@ func Load(path string) (*Config, error) {
 		}
 	}
 
+	// Validate upstream addresses and priorities
+	seenServers := make(map[string]int, len(config.UpstreamServers))
+	for i, server := range config.UpstreamServers {
+		field := fmt.Sprintf("upstream_servers[%d]", i)
+		v.validateServerURL(field+".url", server.URL)
+		if first, exists := seenServers[server.URL]; exists {
+			v.addf(field+".url", "%s is already listed as upstream server %d", server.URL, first+1)
+		} else {
+			seenServers[server.URL] = i
+		}
+		if server.AlternativeAddress != "" {
+			v.validateServerURL(field+".alternative_address", server.AlternativeAddress)
+		}
+		if server.StatusPageURL != "" {
+			v.validateServerURL(field+".status_page_url", server.StatusPageURL)
+		}
+		if server.Priority < 0 {
+			v.addf(field+".priority", "must not be negative (lower values are preferred)")
+		}
+	}
+
+	// Validate failure threshold overrides
+	if err := ValidateMaxFailuresByOperation(config.Server.MaxFailuresByOperation); err != nil {
+		v.addf("server.max_failures_by_operation", "%v", err)
+	}
+	for i, server := range config.UpstreamServers {
+		field := fmt.Sprintf("upstream_servers[%d]", i)
+		if server.MaxFailures < 0 {
+			v.addf(field+".max_failures", "must not be negative")
+		}
+		if err := ValidateMaxFailuresByOperation(server.MaxFailuresByOperation); err != nil {
+			v.addf(field+".max_failures_by_operation", "%v", err)
+		}
+	}
+
+	// Validate chaos settings
+	for i, server := range config.UpstreamServers {
+		if server.Chaos == nil {
+			continue
+		}
+		if err := server.Chaos.Validate(); err != nil {
+			v.addf(fmt.Sprintf("upstream_servers[%d].chaos", i), "%v", err)
+		}
+	}
+
+	// Parse maintenance windows
+	for i := range config.UpstreamServers {
+		for j := range config.UpstreamServers[i].MaintenanceWindows {
+			if err := config.UpstreamServers[i].MaintenanceWindows[j].Parse(); err != nil {
+				v.addf(fmt.Sprintf("upstream_servers[%d].maintenance_windows[%d]", i, j), "%v", err)
+			}
+		}
+	}
+
+	// Parse and validate shards
+	knownServers := make(map[string]bool, len(config.UpstreamServers))
+	for _, server := range config.UpstreamServers {
+		knownServers[server.URL] = true
+	}
+	for i := range config.Server.Shards {
+		if err := config.Server.Shards[i].parse(knownServers, config.Server.MinUploadServers); err != nil {
+			v.addf(fmt.Sprintf("server.shards[%d]", i), "%v", err)
+		}
+	}
+
+	for i := range config.Server.ContentRoutes {
+		if err := config.Server.ContentRoutes[i].parse(knownServers, config.Server.MinUploadServers); err != nil {
+			v.addf(fmt.Sprintf("server.content_routes[%d]", i), "%v", err)
+		}
+	}
+
+	for i := range config.Server.SizeRoutes {
+		if err := config.Server.SizeRoutes[i].parse(knownServers, config.Server.MinUploadServers, i); err != nil {
+			v.addf(fmt.Sprintf("server.size_routes[%d]", i), "%v", err)
+		}
+	}
+
 	// Validate configuration
 	if len(config.UpstreamServers) < config.Server.MinUploadServers {
-		return nil, fmt.Errorf("not enough upstream servers: need at least %d, got %d",
+		v.addf("upstream_servers", "not enough upstream servers: need at least %d (min_upload_servers), got %d",
 			config.Server.MinUploadServers, len(config.UpstreamServers))
 	}
 
+	if !redirectStrategies[config.Server.RedirectStrategy] {
+		v.addf("server.redirect_strategy", "invalid value %q (expected round_robin, random, priority, weighted, health_based, throughput_aware or local)",
+			config.Server.RedirectStrategy)
+	}
+	if config.Server.DownloadRedirectStrategy != "" && !redirectStrategies[config.Server.DownloadRedirectStrategy] {
+		v.addf("server.download_redirect_strategy", "invalid value %q (expected round_robin, random, priority, weighted, health_based, throughput_aware or local)",
+			config.Server.DownloadRedirectStrategy)
+	}
+	switch config.Server.DownloadMode {
+	case "redirect", "proxy":
+	default:
+		v.addf("server.download_mode", "invalid value %q (expected redirect or proxy)", config.Server.DownloadMode)
+	}
+	switch config.Server.URLTagsMode {
+	case "upstream", "proxy", "none":
+	default:
+		v.addf("server.url_tags_mode", "invalid value %q (expected upstream, proxy or none)", config.Server.URLTagsMode)
+	}
+	switch config.Server.ResponseURLMode {
+	case "upstream", "prefer_base_url":
+	default:
+		v.addf("server.response_url_mode", "invalid value %q (expected upstream or prefer_base_url)", config.Server.ResponseURLMode)
+	}
+	switch config.Server.URLTagsOrder {
+	case "selected_first", "upstream_order":
+	default:
+		v.addf("server.url_tags_order", "invalid value %q (expected selected_first or upstream_order)", config.Server.URLTagsOrder)
+	}
+	if config.Server.BaseURL != "" {
+		v.validateServerURL("server.base_url", config.Server.BaseURL)
+	}
+
+	// Durations that must not be negative (a few others disable their feature when negative)
+	for field, value := range map[string]time.Duration{
+		"timeout":                   config.Server.Timeout,
+		"min_upload_timeout":        config.Server.MinUploadTimeout,
+		"max_upload_timeout":        config.Server.MaxUploadTimeout,
+		"max_request_timeout":       config.Server.MaxRequestTimeout,
+		"connect_timeout":           config.Server.ConnectTimeout,
+		"tls_handshake_timeout":     config.Server.TLSHandshakeTimeout,
+		"response_header_timeout":   config.Server.ResponseHeaderTimeout,
+		"circuit_breaker_cooldown":  config.Server.CircuitBreakerCooldown,
+		"cache_ttl":                 config.Server.CacheTTL,
+		"integrity_check_interval":  config.Server.IntegrityCheckInterval,
+		"integrity_check_timeout":   config.Server.IntegrityCheckTimeout,
+		"repair_interval":           config.Server.RepairInterval,
+		"settling_period":           config.Server.SettlingPeriod,
+		"settling_retry_delay":      config.Server.SettlingRetryDelay,
+		"accepted_poll_interval":    config.Server.AcceptedPollInterval,
+		"accepted_poll_timeout":     config.Server.AcceptedPollTimeout,
+		"load_shedding_retry_after": config.Server.LoadSheddingRetryAfter,
+		"journal_retry_interval":    config.Server.JournalRetryInterval,
+		"journal_max_age":           config.Server.JournalMaxAge,
+		"upload_spool_retention":    config.Server.UploadSpoolRetention,
+		"scan_window":               config.Server.ScanWindow,
+		"scan_block_duration":       config.Server.ScanBlockDuration,
+		"fanout_jitter":             config.Server.FanoutJitter,
+	} {
+		if value < 0 {
+			v.addf("server."+field, "must not be negative")
+		}
+	}
+	if config.Server.FanoutJitter > 0 && config.Server.FanoutJitter >= config.Server.Timeout {
+		v.addf("server.fanout_jitter", "%v must be shorter than timeout (%v)", config.Server.FanoutJitter, config.Server.Timeout)
+	}
+	if config.Server.MaxUploadBytes < 0 {
+		v.addf("server.max_upload_bytes", "must not be negative")
+	}
+	if config.Server.MinUploadTimeout > config.Server.MaxUploadTimeout {
+		v.addf("server.min_upload_timeout", "%v exceeds max_upload_timeout (%v)", config.Server.MinUploadTimeout, config.Server.MaxUploadTimeout)
+	}
+
+	if config.Server.AuthEndpoints == nil && len(config.Server.AllowedPubkeys) > 0 {
+		config.Server.AuthEndpoints = []string{"upload", "mirror", "delete", "list"}
+	}
+	uploadAuth := false
+	for i, endpoint := range config.Server.AuthEndpoints {
+		if !authEndpoints[endpoint] {
+			v.addf(fmt.Sprintf("server.auth_endpoints[%d]", i), "invalid value %q (expected upload, mirror, delete, list or get)", endpoint)
+		}
+		uploadAuth = uploadAuth || endpoint == "upload"
+	}
+
+	if config.Server.NegativeCacheTTL == 0 {
+		config.Server.NegativeCacheTTL = 30 * time.Second // Default: 30 seconds
+	}
+	if config.Server.CacheBackend == "" {
+		config.Server.CacheBackend = "memory" // Default: memory
+	}
+	if config.Server.CacheRedisKeyPrefix == "" {
+		config.Server.CacheRedisKeyPrefix = "espelhator:cache:" // Default: espelhator:cache:
+	}
+	switch config.Server.CacheBackend {
+	case "memory":
+	case "redis":
+		if config.Server.CacheRedisAddr == "" {
+			v.addf("server.cache_redis_addr", "required by cache_backend redis")
+		}
+		if config.Server.CachePath != "" {
+			v.addf("server.cache_path", "not used with cache_backend redis (Redis persists the cache)")
+		}
+	default:
+		v.addf("server.cache_backend", "invalid value %q (expected memory or redis)", config.Server.CacheBackend)
+	}
+	if config.Server.CacheRedisDB < 0 {
+		v.addf("server.cache_redis_db", "must not be negative")
+	}
+	if config.Server.CacheRedisPoolSize == 0 {
+		config.Server.CacheRedisPoolSize = 8 // Default: 8 connections
+	}
+	if config.Server.CacheRedisPoolSize < 0 {
+		v.addf("server.cache_redis_pool_size", "must be positive")
+	}
+	if config.Server.CacheRedisUsername != "" && config.Server.CacheRedisPassword == "" {
+		v.addf("server.cache_redis_password", "required with cache_redis_username")
+	}
+
+	if config.Server.ScanWindow == 0 {
+		config.Server.ScanWindow = time.Minute // Default: 1 minute
+	}
+	if config.Server.ScanBlockDuration == 0 {
+		config.Server.ScanBlockDuration = 10 * time.Minute // Default: 10 minutes
+	}
+	if config.Server.ScanMissThreshold < 0 {
+		v.addf("server.scan_miss_threshold", "must not be negative")
+	}
+
+	for field, value := range map[string]float64{
+		"rate_limit_upload_rps":     config.Server.RateLimitUploadRPS,
+		"rate_limit_upload_burst":   float64(config.Server.RateLimitUploadBurst),
+		"rate_limit_download_rps":   config.Server.RateLimitDownloadRPS,
+		"rate_limit_download_burst": float64(config.Server.RateLimitDownloadBurst),
+	} {
+		if value < 0 {
+			v.addf("server."+field, "must not be negative")
+		}
+	}
+	for i, limit := range config.Server.RateLimits {
+		field := fmt.Sprintf("server.rate_limits[%d]", i)
+		if len(limit.Pubkeys) == 0 {
+			v.addf(field+".pubkeys", "is required")
+		}
+		for j, pubkey := range limit.Pubkeys {
+			if !validPubkey(pubkey) {
+				v.addf(fmt.Sprintf("%s.pubkeys[%d]", field, j), "invalid pubkey %q (expected 64 hex characters or an npub)", pubkey)
+			}
+		}
+		if limit.UploadRPS < 0 && limit.UploadRPS != -1 {
+			v.addf(field+".upload_rps", "must be -1 (unlimited), 0 (global value) or positive")
+		}
+		if limit.DownloadRPS < 0 && limit.DownloadRPS != -1 {
+			v.addf(field+".download_rps", "must be -1 (unlimited), 0 (global value) or positive")
+		}
+		if limit.UploadBurst < 0 {
+			v.addf(field+".upload_burst", "must not be negative")
+		}
+		if limit.DownloadBurst < 0 {
+			v.addf(field+".download_burst", "must not be negative")
+		}
+	}
+
+	if config.Server.UploadQuotaWindow == 0 {
+		config.Server.UploadQuotaWindow = 24 * time.Hour // Default: 24 hours
+	}
+	if config.Server.UploadQuotaWindow < 0 {
+		v.addf("server.upload_quota_window", "must be positive")
+	}
+	if config.Server.UploadQuotaBytes < 0 {
+		v.addf("server.upload_quota_bytes", "must not be negative")
+	}
+	if config.Server.UploadQuotaBlobs < 0 {
+		v.addf("server.upload_quota_blobs", "must not be negative")
+	}
+	for i, quota := range config.Server.UploadQuotas {
+		field := fmt.Sprintf("server.upload_quotas[%d]", i)
+		if len(quota.Pubkeys) == 0 {
+			v.addf(field+".pubkeys", "is required")
+		}
+		for j, pubkey := range quota.Pubkeys {
+			if !validPubkey(pubkey) {
+				v.addf(fmt.Sprintf("%s.pubkeys[%d]", field, j), "invalid pubkey %q (expected 64 hex characters or an npub)", pubkey)
+			}
+		}
+		if quota.MaxBytes < -1 {
+			v.addf(field+".max_bytes", "must be -1 (unlimited), 0 (global value) or positive")
+		}
+		if quota.MaxBlobs < -1 {
+			v.addf(field+".max_blobs", "must be -1 (unlimited), 0 (global value) or positive")
+		}
+	}
+	switch config.Server.ListAccess {
+	case "public", "auth", "owner":
+	default:
+		v.addf("server.list_access", "invalid value %q (expected public, auth or owner)", config.Server.ListAccess)
+	}
+
+	if (config.Server.UploadQuotaBytes > 0 || config.Server.UploadQuotaBlobs > 0 || len(config.Server.UploadQuotas) > 0) && !uploadAuth {
+		v.addf("server.auth_endpoints", "must include upload for upload quotas (quotas are counted per authenticated pubkey)")
+	}
+	for i, pubkey := range config.Server.AllowedPubkeys {
+		if !validPubkey(pubkey) {
+			v.addf(fmt.Sprintf("server.allowed_pubkeys[%d]", i), "invalid pubkey %q (expected 64 hex characters or an npub)", pubkey)
+		}
+	}
+	if config.Server.RepairBatchSize < 0 {
+		v.addf("server.repair_batch_size", "must not be negative")
+	}
+	if config.Server.RepairSecretKey != "" && !validSecretKey(config.Server.RepairSecretKey) {
+		v.addf("server.repair_secret_key", "invalid secret key (expected 64 hex characters or an nsec)")
+	}
+	for i, entry := range config.Server.TrustedProxies {
+		if _, err := ParseTrustedProxies([]string{entry}); err != nil {
+			v.addf(fmt.Sprintf("server.trusted_proxies[%d]", i), "%v", err)
+		}
+	}
+	for i, pubkey := range config.Server.StatusPubkeys {
+		if !validPubkey(pubkey) {
+			v.addf(fmt.Sprintf("server.status_pubkeys[%d]", i), "invalid pubkey %q (expected 64 hex characters or an npub)", pubkey)
+		}
+	}
+
+	if config.Server.AdminToken != "" && len(config.Server.AdminToken) < 16 {
+		v.addf("server.admin_token", "must be at least 16 characters")
+	}
+	for i, pubkey := range config.Server.AdminPubkeys {
+		if !validPubkey(pubkey) {
+			v.addf(fmt.Sprintf("server.admin_pubkeys[%d]", i), "invalid pubkey %q (expected 64 hex characters or an npub)", pubkey)
+		}
+	}
+
+	switch config.Server.StatusAccess {
+	case "public", "minimal":
+	case "auth":
+		if config.Server.StatusUsername == "" && len(config.Server.StatusPubkeys) == 0 {
+			v.addf("server.status_access", "\"auth\" requires status_username/status_password or status_pubkeys")
+		}
+	default:
+		v.addf("server.status_access", "invalid value %q (expected public, minimal or auth)", config.Server.StatusAccess)
+	}
+	if config.Server.StatusUsername != "" && config.Server.StatusPassword == "" {
+		v.addf("server.status_username", "requires status_password")
+	}
+
+	if config.Cluster != nil {
+		if config.Cluster.NodeID == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return nil, fmt.Errorf("cluster.node_id is not set and the hostname is unavailable: %w", err)
+			}
+			config.Cluster.NodeID = hostname
+		}
+		if config.Cluster.SyncInterval == 0 {
+			config.Cluster.SyncInterval = 5 * time.Second
+		}
+		if config.Cluster.SyncTimeout == 0 {
+			config.Cluster.SyncTimeout = 10 * time.Second
+		}
+		if config.Cluster.Secret == "" {
+			v.addf("cluster.secret", "is required")
+		}
+		if len(config.Cluster.Peers) == 0 {
+			v.addf("cluster.peers", "at least one peer is required")
+		}
+		if config.Cluster.SyncInterval < 0 {
+			v.addf("cluster.sync_interval", "must be positive")
+		}
+		if config.Cluster.SyncTimeout < 0 {
+			v.addf("cluster.sync_timeout", "must be positive")
+		}
+		for i, peer := range config.Cluster.Peers {
+			v.validateServerURL(fmt.Sprintf("cluster.peers[%d]", i), peer)
+		}
+	}
+
+	// Replication factor defaults to full replication across all upstream servers
+	if config.Server.ReplicationFactor == 0 {
+		config.Server.ReplicationFactor = len(config.UpstreamServers)
+	}
+	if config.Server.ReplicationFactor < config.Server.MinUploadServers {
+		v.addf("server.replication_factor", "%d must be at least min_upload_servers (%d)",
+			config.Server.ReplicationFactor, config.Server.MinUploadServers)
+	}
+	if config.Server.ReplicationFactor > len(config.UpstreamServers) {
+		v.addf("server.replication_factor", "%d exceeds number of upstream servers (%d)",
+			config.Server.ReplicationFactor, len(config.UpstreamServers))
+	}
+
+	if err := v.err(path); err != nil {
+		return nil, err
+	}
 	return &config, nil
 }
+
+// weekdayNames maps short and long weekday names to time.Weekday
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// healthOperations are the operation types health is tracked for
+var healthOperations = map[string]bool{
+	"upload":   true,
+	"download": true,
+	"mirror":   true,
+	"delete":   true,
+	"list":     true,
+}
+
+// authEndpoints are the endpoints that can require client authentication
+var authEndpoints = map[string]bool{
+	"upload": true,
+	"mirror": true,
+	"delete": true,
+	"list":   true,
+	"get":    true,
+}
+
+// ParseTrustedProxies parses trusted_proxies entries: IP addresses or CIDR ranges
+func ParseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR range %q", entry)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q (expected an IP address or a CIDR range)", entry)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// ValidateMaxFailuresByOperation checks that overrides name known operations and are positive
+func ValidateMaxFailuresByOperation(overrides map[string]int) error {
+	for opType, maxFailures := range overrides {
+		if !healthOperations[opType] {
+			return fmt.Errorf("unknown operation %q (expected upload, download, mirror, delete or list)", opType)
+		}
+		if maxFailures <= 0 {
+			return fmt.Errorf("%s: must be greater than zero", opType)
+		}
+	}
+	return nil
+}
+
+// Validate checks that chaos settings are within range and name known operations
+func (c *ChaosConfig) Validate() error {
+	if c.Latency < 0 || c.LatencyJitter < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+	if c.FailureRate < 0 || c.FailureRate > 1 {
+		return fmt.Errorf("failure_rate must be between 0 and 1")
+	}
+	if c.FailureStatus != 0 && (c.FailureStatus < 400 || c.FailureStatus > 599) {
+		return fmt.Errorf("failure_status must be a 4xx or 5xx status code (or 0 for a connection error)")
+	}
+	for _, opType := range c.Operations {
+		if !healthOperations[opType] {
+			return fmt.Errorf("unknown operation %q (expected upload, download, mirror, delete or list)", opType)
+		}
+	}
+	return nil
+}
+
+// RequestBudgetFor returns the request ceilings of a server: its own settings, or the
+// global ones where it has none (0 = unlimited)
+func (s *ServerConfig) RequestBudgetFor(server UpstreamServer) (maxConcurrent int, requestsPerSecond float64) {
+	maxConcurrent = s.UpstreamMaxConcurrentRequests
+	if server.MaxConcurrentRequests != 0 {
+		maxConcurrent = max(server.MaxConcurrentRequests, 0)
+	}
+	requestsPerSecond = s.UpstreamMaxRequestsPerSecond
+	if server.MaxRequestsPerSecond != 0 {
+		requestsPerSecond = max(server.MaxRequestsPerSecond, 0)
+	}
+	return maxConcurrent, requestsPerSecond
+}
+
+// MaxFailuresFor returns the consecutive failure threshold for an operation on a server
+// The most specific setting wins: the server's per-operation override, the server's
+// max_failures, the global per-operation override, then the global max_failures
+func (c *Config) MaxFailuresFor(serverURL string, opType string) int {
+	for i := range c.UpstreamServers {
+		if c.UpstreamServers[i].URL == serverURL {
+			return c.Server.MaxFailuresFor(&c.UpstreamServers[i], opType)
+		}
+	}
+	return c.Server.MaxFailuresFor(nil, opType)
+}
+
+// MaxFailuresFor returns the consecutive failure threshold for an operation on server
+// (nil for the global threshold), with the precedence of Config.MaxFailuresFor
+func (s *ServerConfig) MaxFailuresFor(server *UpstreamServer, opType string) int {
+	if server != nil {
+		if maxFailures, ok := server.MaxFailuresByOperation[opType]; ok {
+			return maxFailures
+		}
+		if server.MaxFailures > 0 {
+			return server.MaxFailures
+		}
+	}
+	if maxFailures, ok := s.MaxFailuresByOperation[opType]; ok {
+		return maxFailures
+	}
+	return s.MaxFailures
+}
+
+// parseClock parses a HH:MM string into minutes since midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM): %w", value, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Parse validates the time window and populates its parsed fields
+// Windows are parsed when the config is loaded; one that was never parsed matches nothing
+func (tw *TimeWindow) Parse() error {
+	start, err := parseClock(tw.Start)
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	end, err := parseClock(tw.End)
+	if err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	if start == end {
+		return fmt.Errorf("start and end must differ")
+	}
+	tw.startMinutes = start
+	tw.endMinutes = end
+
+	tw.days = nil
+	if len(tw.Days) > 0 {
+		tw.days = make(map[time.Weekday]bool, len(tw.Days))
+		for _, day := range tw.Days {
+			weekday, ok := weekdayNames[strings.ToLower(strings.TrimSpace(day))]
+			if !ok {
+				return fmt.Errorf("invalid day %q", day)
+			}
+			tw.days[weekday] = true
+		}
+	}
+
+	tw.location = time.Local
+	if tw.Timezone != "" {
+		loc, err := time.LoadLocation(tw.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone %q: %w", tw.Timezone, err)
+		}
+		tw.location = loc
+	}
+
+	return nil
+}
+
+// Contains reports whether the given instant falls inside the time window
+// Read-only, so it is safe for concurrent use once the window was parsed at load
+// For windows that wrap past midnight, the day filter applies to the day the window started
+func (tw *TimeWindow) Contains(now time.Time) bool {
+	if tw.location == nil {
+		// Window was never parsed (see Parse)
+		return false
+	}
+
+	local := now.In(tw.location)
+	minutes := local.Hour()*60 + local.Minute()
+	day := local.Weekday()
+
+	if tw.startMinutes < tw.endMinutes {
+		return minutes >= tw.startMinutes && minutes < tw.endMinutes && tw.matchesDay(day)
+	}
+
+	// Window wraps past midnight
+	if minutes >= tw.startMinutes {
+		return tw.matchesDay(day)
+	}
+	if minutes < tw.endMinutes {
+		return tw.matchesDay((day + 6) % 7) // Window started the previous day
+	}
+	return false
+}
+
+// matchesDay reports whether the window applies on the given weekday
+func (tw *TimeWindow) matchesDay(day time.Weekday) bool {
+	return len(tw.days) == 0 || tw.days[day]
+}
+
+// parse validates the shard configuration and populates its parsed prefix ranges
+// knownServers is the set of configured upstream URLs; defaultMin is the global min_upload_servers
+func (sc *ShardConfig) parse(knownServers map[string]bool, defaultMin int) error {
+	if len(sc.Prefixes) == 0 {
+		return fmt.Errorf("at least one prefix range is required")
+	}
+	if len(sc.Servers) == 0 {
+		return fmt.Errorf("at least one server is required")
+	}
+	for _, server := range sc.Servers {
+		if !knownServers[server] {
+			return fmt.Errorf("server %s is not listed in upstream_servers", server)
+		}
+	}
+
+	sc.ranges = make([][2]string, 0, len(sc.Prefixes))
+	for _, prefix := range sc.Prefixes {
+		lo, hi, found := strings.Cut(strings.ToLower(strings.TrimSpace(prefix)), "-")
+		if !found {
+			hi = lo
+		}
+		if lo == "" || len(lo) != len(hi) || strings.Trim(lo+hi, "0123456789abcdef") != "" {
+			return fmt.Errorf("invalid prefix range %q (expected hex like \"0-7\" or \"a\")", prefix)
+		}
+		if lo > hi {
+			return fmt.Errorf("invalid prefix range %q: start is greater than end", prefix)
+		}
+		sc.ranges = append(sc.ranges, [2]string{lo, hi})
+	}
+
+	if sc.MinUploadServers == 0 {
+		sc.MinUploadServers = defaultMin
+		if sc.MinUploadServers > len(sc.Servers) {
+			sc.MinUploadServers = len(sc.Servers)
+		}
+	}
+	if sc.MinUploadServers > len(sc.Servers) {
+		return fmt.Errorf("min_upload_servers (%d) exceeds number of shard servers (%d)", sc.MinUploadServers, len(sc.Servers))
+	}
+
+	return nil
+}
+
+// Matches reports whether the given hash falls within one of the shard's prefix ranges
+func (sc *ShardConfig) Matches(hash string) bool {
+	hash = strings.ToLower(hash)
+	for _, r := range sc.ranges {
+		if len(hash) < len(r[0]) {
+			continue
+		}
+		prefix := hash[:len(r[0])]
+		if prefix >= r[0] && prefix <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// parse validates the content route and normalizes its types
+// knownServers is the set of configured upstream URLs; defaultMin is the global min_upload_servers
+func (rc *ContentRouteConfig) parse(knownServers map[string]bool, defaultMin int) error {
+	if len(rc.Types) == 0 {
+		return fmt.Errorf("at least one type is required")
+	}
+	if len(rc.Servers) == 0 {
+		return fmt.Errorf("at least one server is required")
+	}
+	for _, server := range rc.Servers {
+		if !knownServers[server] {
+			return fmt.Errorf("server %s is not listed in upstream_servers", server)
+		}
+	}
+	for i, t := range rc.Types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		major, minor, found := strings.Cut(t, "/")
+		if !found || major == "" || minor == "" || major == "*" {
+			return fmt.Errorf("invalid type %q (expected a MIME type like \"image/png\" or a class like \"video/*\")", rc.Types[i])
+		}
+		rc.Types[i] = t
+	}
+
+	if rc.MinUploadServers == 0 {
+		rc.MinUploadServers = defaultMin
+		if rc.MinUploadServers > len(rc.Servers) {
+			rc.MinUploadServers = len(rc.Servers)
+		}
+	}
+	if rc.MinUploadServers > len(rc.Servers) {
+		return fmt.Errorf("min_upload_servers (%d) exceeds number of route servers (%d)", rc.MinUploadServers, len(rc.Servers))
+	}
+	return nil
+}
+
+// Matches reports whether a content type (parameters such as charset are ignored) is
+// one of the route's types or belongs to one of its classes
+func (rc *ContentRouteConfig) Matches(contentType string) bool {
+	contentType, _, _ = strings.Cut(contentType, ";")
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	major, _, found := strings.Cut(contentType, "/")
+	if !found {
+		return false
+	}
+	for _, t := range rc.Types {
+		if t == contentType || t == major+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// parse validates the size route and fills in its defaults
+// knownServers is the set of configured upstream URLs; defaultMin is the global min_upload_servers
+// and index the position of the route (for its default name)
+func (sr *SizeRouteConfig) parse(knownServers map[string]bool, defaultMin int, index int) error {
+	if sr.Name == "" {
+		sr.Name = fmt.Sprintf("size-%d", index+1)
+	}
+	if sr.MinBytes < 0 || sr.MaxBytes < 0 {
+		return fmt.Errorf("min_bytes and max_bytes must not be negative")
+	}
+	if sr.MaxBytes > 0 && sr.MaxBytes <= sr.MinBytes {
+		return fmt.Errorf("max_bytes (%d) must be greater than min_bytes (%d)", sr.MaxBytes, sr.MinBytes)
+	}
+	if len(sr.Servers) == 0 && sr.MinUploadServers == 0 {
+		return fmt.Errorf("servers or min_upload_servers is required")
+	}
+	for _, server := range sr.Servers {
+		if !knownServers[server] {
+			return fmt.Errorf("server %s is not listed in upstream_servers", server)
+		}
+	}
+	if sr.MinUploadServers < 0 {
+		return fmt.Errorf("min_upload_servers must not be negative")
+	}
+
+	if len(sr.Servers) > 0 {
+		if sr.MinUploadServers == 0 {
+			sr.MinUploadServers = min(defaultMin, len(sr.Servers))
+		}
+		if sr.MinUploadServers > len(sr.Servers) {
+			return fmt.Errorf("min_upload_servers (%d) exceeds number of route servers (%d)", sr.MinUploadServers, len(sr.Servers))
+		}
+	} else if sr.MinUploadServers > len(knownServers) {
+		return fmt.Errorf("min_upload_servers (%d) exceeds number of upstream servers (%d)", sr.MinUploadServers, len(knownServers))
+	}
+	return nil
+}
+
+// Matches reports whether a blob size falls within the route's range (negative sizes are unknown)
+func (sr *SizeRouteConfig) Matches(size int64) bool {
+	return size >= 0 && size >= sr.MinBytes && (sr.MaxBytes == 0 || size < sr.MaxBytes)
+}
+
+// redactedValue replaces secrets in Redacted copies
+const redactedValue = "[redacted]"
+
+// Redacted returns a copy of the configuration with its secrets (status_password,
+// repair_secret_key, the cluster secret) replaced, safe to show to operators. Slices are shared with c
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	if redacted.Server.StatusPassword != "" {
+		redacted.Server.StatusPassword = redactedValue
+	}
+	if redacted.Server.AdminToken != "" {
+		redacted.Server.AdminToken = redactedValue
+	}
+	if redacted.Server.CacheRedisPassword != "" {
+		redacted.Server.CacheRedisPassword = redactedValue
+	}
+	if redacted.Server.RepairSecretKey != "" {
+		redacted.Server.RepairSecretKey = redactedValue
+	}
+	if len(redacted.Server.TracingHeaders) > 0 {
+		headers := make(map[string]string, len(redacted.Server.TracingHeaders))
+		for name := range redacted.Server.TracingHeaders {
+			headers[name] = redactedValue
+		}
+		redacted.Server.TracingHeaders = headers
+	}
+	if c.Cluster != nil {
+		cluster := *c.Cluster
+		if cluster.Secret != "" {
+			cluster.Secret = redactedValue
+		}
+		redacted.Cluster = &cluster
+	}
+	return &redacted
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is one invalid setting in a configuration file
+type Problem struct {
+	Field   string // Path of the setting (e.g. "server.download_mode", "upstream_servers[1].url")
+	Line    int    // Line of the setting, or of its closest parent if it isn't set (0 if unknown)
+	Message string
+}
+
+// ValidationError reports every problem found in a configuration file at once
+type ValidationError struct {
+	File     string
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration %s (%d problem", e.File, len(e.Problems))
+	if len(e.Problems) != 1 {
+		b.WriteByte('s')
+	}
+	b.WriteString("):")
+	for _, p := range e.Problems {
+		b.WriteString("\n  ")
+		if p.Line > 0 {
+			fmt.Fprintf(&b, "line %d: ", p.Line)
+		}
+		fmt.Fprintf(&b, "%s: %s", p.Field, p.Message)
+	}
+	return b.String()
+}
+
+// validator collects the problems of a configuration, locating them in the YAML document
+type validator struct {
+	root     *yaml.Node
+	problems []Problem
+}
+
+// addf records a problem with a setting
+func (v *validator) addf(field string, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{
+		Field:   field,
+		Line:    v.line(field),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// err returns the problems found as a *ValidationError in file order (problems without a
+// line last), or nil if there are none
+func (v *validator) err(file string) error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		li, lj := v.problems[i].Line, v.problems[j].Line
+		if li == 0 || lj == 0 {
+			return lj == 0 && li != 0
+		}
+		if li != lj {
+			return li < lj
+		}
+		return v.problems[i].Field < v.problems[j].Field
+	})
+	return &ValidationError{File: file, Problems: v.problems}
+}
+
+// line returns the line of a setting in the document, or of its deepest parent present
+// field is a dotted path where sequence items are indexed: "upstream_servers[1].url"
+func (v *validator) line(field string) int {
+	if v.root == nil || len(v.root.Content) == 0 {
+		return 0
+	}
+	node := v.root.Content[0]
+	line := 0
+	for _, segment := range strings.Split(field, ".") {
+		name, indexes, _ := strings.Cut(segment, "[")
+		if name != "" {
+			value, keyLine := mappingValue(node, name)
+			if value == nil {
+				return line
+			}
+			node, line = value, keyLine
+		}
+		for indexes != "" {
+			index, rest, _ := strings.Cut(indexes, "]")
+			indexes = strings.TrimPrefix(rest, "[")
+			i, err := strconv.Atoi(index)
+			if err != nil || node.Kind != yaml.SequenceNode || i < 0 || i >= len(node.Content) {
+				return line
+			}
+			node = node.Content[i]
+			line = node.Line
+		}
+	}
+	return line
+}
+
+// mappingValue returns the value of a key in a mapping node and the line of the key
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, int) {
+	if node.Kind != yaml.MappingNode {
+		return nil, 0
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1], node.Content[i].Line
+		}
+	}
+	return nil, 0
+}
+
+// redirectStrategies are the valid values of redirect_strategy and download_redirect_strategy
+var redirectStrategies = map[string]bool{
+	"round_robin":      true,
+	"random":           true,
+	"priority":         true,
+	"weighted":         true,
+	"health_based":     true,
+	"throughput_aware": true,
+	"local":            true,
+}
+
+// validateServerURL checks that a setting is an absolute http or https URL
+func (v *validator) validateServerURL(field string, value string) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.addf(field, "invalid URL %q (expected an http or https URL)", value)
+	}
+}
+
+// validPubkey reports whether a pubkey is 64 hex characters or an npub
+func validPubkey(pubkey string) bool {
+	pubkey = strings.TrimSpace(pubkey)
+	if strings.HasPrefix(strings.ToLower(pubkey), "npub") {
+		prefix, _, err := nip19.Decode(pubkey)
+		return err == nil && prefix == "npub"
+	}
+	decoded, err := hex.DecodeString(pubkey)
+	return err == nil && len(decoded) == 32
+}
+
+// validSecretKey reports whether a secret key is 64 hex characters or an nsec
+func validSecretKey(secretKey string) bool {
+	secretKey = strings.TrimSpace(secretKey)
+	if strings.HasPrefix(strings.ToLower(secretKey), "nsec") {
+		prefix, _, err := nip19.Decode(secretKey)
+		return err == nil && prefix == "nsec"
+	}
+	decoded, err := hex.DecodeString(secretKey)
+	return err == nil && len(decoded) == 32
+}
@@ -0,0 +1,361 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/auth"
+	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/recovery"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindServerList is the BUD-03 user server list kind, the default discovery event
+const KindServerList = 10063
+
+// Discoverer sources the upstream set from a Nostr list event published by the operator
+// At startup, Prepare fills in the upstream servers from the newest event found on the
+// relays (or the cached copy); Start then polls the relays and reports a changed set,
+// which the caller applies by restarting with the new configuration
+type Discoverer struct {
+	pubkey          string // Hex pubkey of the event author
+	relays          []string
+	kind            int
+	dTag            string
+	mode            string
+	refreshInterval time.Duration
+	fetchTimeout    time.Duration
+	cacheFile       string
+	verbose         *logging.Flag
+
+	mu      sync.Mutex
+	current *nostr.Event // Event the running upstream set was built from (nil = configured servers)
+	servers []string     // Upstream URLs of the running configuration
+}
+
+// New creates a discoverer from the upstream_discovery section, applying its defaults
+func New(cfg *config.DiscoveryConfig, verbose *logging.Flag) (*Discoverer, error) {
+	pubkey, err := auth.NormalizePubkey(cfg.Pubkey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream_discovery pubkey: %w", err)
+	}
+	if len(cfg.Relays) == 0 {
+		return nil, fmt.Errorf("upstream_discovery requires at least one relay")
+	}
+
+	d := &Discoverer{
+		pubkey:          pubkey,
+		relays:          cfg.Relays,
+		kind:            cfg.Kind,
+		dTag:            cfg.DTag,
+		mode:            cfg.Mode,
+		refreshInterval: cfg.RefreshInterval,
+		fetchTimeout:    cfg.FetchTimeout,
+		cacheFile:       cfg.CacheFile,
+		verbose:         verbose,
+	}
+	if d.kind == 0 {
+		d.kind = KindServerList
+	}
+	if d.kind >= 30000 && d.kind < 40000 && d.dTag == "" {
+		return nil, fmt.Errorf("upstream_discovery kind %d is addressable and requires d_tag", d.kind)
+	}
+	switch d.mode {
+	case "":
+		d.mode = "replace"
+	case "replace", "merge":
+	default:
+		return nil, fmt.Errorf("invalid upstream_discovery mode %q (expected replace or merge)", d.mode)
+	}
+	if d.refreshInterval == 0 {
+		d.refreshInterval = 10 * time.Minute
+	}
+	if d.fetchTimeout <= 0 {
+		d.fetchTimeout = 15 * time.Second
+	}
+	return d, nil
+}
+
+// Prepare fills in cfg's upstream servers from the newest discovery event on the relays,
+// falling back to the cached event; with neither, the configured upstream_servers are used
+// Meant to be passed to config.LoadWith
+func (d *Discoverer) Prepare(cfg *config.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.fetchTimeout)
+	defer cancel()
+
+	event, err := d.fetch(ctx)
+	fetched := err == nil
+	if err != nil {
+		logging.Warnf(ctx, "Upstream discovery: %v", err)
+		event, err = d.loadCache()
+		if err != nil {
+			if len(cfg.UpstreamServers) == 0 {
+				return fmt.Errorf("upstream discovery failed and no upstream_servers are configured: %w", err)
+			}
+			logging.Warnf(ctx, "Upstream discovery: no usable cached event (%v), using the configured upstream_servers", err)
+			d.setCurrent(nil, cfg.UpstreamServers)
+			return nil
+		}
+		logging.Warnf(ctx, "Upstream discovery: using cached event %s from %s", event.ID, event.CreatedAt.Time().UTC().Format(time.RFC3339))
+	}
+
+	if err := d.apply(cfg, event); err != nil {
+		return err
+	}
+	if fetched {
+		d.saveCache(event)
+	}
+	d.setCurrent(event, cfg.UpstreamServers)
+	log.Printf("Upstream discovery: %d upstream servers from event %s (%s mode)", len(cfg.UpstreamServers), event.ID, d.mode)
+	return nil
+}
+
+// Start polls the relays every refresh interval until ctx is cancelled; when a newer event
+// changes the upstream set and validate accepts it, onChange is called once and polling stops
+// validate receives a function applying the new event to a freshly parsed configuration
+func (d *Discoverer) Start(ctx context.Context, validate func(prepare func(*config.Config) error) error, onChange func()) {
+	if d.refreshInterval < 0 {
+		return
+	}
+
+	log.Printf("Upstream discovery started (interval=%v, relays=%d)", d.refreshInterval, len(d.relays))
+
+	go func() {
+		ticker := time.NewTicker(d.refreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if d.refresh(ctx, validate) {
+					onChange()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// refresh fetches the newest event and reports whether it changes the upstream set into a
+// valid configuration; a panic skips the round instead of stopping the polling
+func (d *Discoverer) refresh(ctx context.Context, validate func(prepare func(*config.Config) error) error) bool {
+	defer recovery.Recover("Upstream discovery")
+
+	fetchCtx, cancel := context.WithTimeout(ctx, d.fetchTimeout)
+	defer cancel()
+	event, err := d.fetch(fetchCtx)
+	if err != nil {
+		logging.Warnf(ctx, "Upstream discovery: %v", err)
+		return false
+	}
+
+	d.mu.Lock()
+	current, running := d.current, d.servers
+	d.mu.Unlock()
+	if current != nil && (event.ID == current.ID || event.CreatedAt <= current.CreatedAt) {
+		d.verbose.Debugf(ctx, "Upstream discovery: no newer event (current %s)", current.ID)
+		return false
+	}
+
+	var next []config.UpstreamServer
+	err = validate(func(cfg *config.Config) error {
+		if err := d.apply(cfg, event); err != nil {
+			return err
+		}
+		next = cfg.UpstreamServers
+		return nil
+	})
+	if err != nil {
+		logging.Warnf(ctx, "Upstream discovery: ignoring event %s, the resulting configuration is invalid: %v", event.ID, err)
+		return false
+	}
+	d.saveCache(event)
+
+	nextURLs := serverURLs(next)
+	if slices.Equal(nextURLs, running) {
+		d.verbose.Debugf(ctx, "Upstream discovery: event %s keeps the upstream set unchanged", event.ID)
+		d.setCurrent(event, next)
+		return false
+	}
+
+	logging.Warnf(ctx, "Upstream discovery: event %s changes the upstream set from %v to %v", event.ID, running, nextURLs)
+	return true
+}
+
+// fetch returns the newest valid discovery event found on the relays
+func (d *Discoverer) fetch(ctx context.Context) (*nostr.Event, error) {
+	filter := nostr.Filter{
+		Kinds:   []int{d.kind},
+		Authors: []string{d.pubkey},
+	}
+	if d.dTag != "" {
+		filter.Tags = nostr.TagMap{"d": []string{d.dTag}}
+	}
+
+	pool := nostr.NewSimplePool(ctx)
+	defer pool.Close("upstream discovery done")
+
+	var newest *nostr.Event
+	for relayEvent := range pool.FetchMany(ctx, d.relays, filter) {
+		if err := d.check(relayEvent.Event); err != nil {
+			logging.Warnf(ctx, "Upstream discovery: ignoring event from %s: %v", relayEvent.Relay.URL, err)
+			continue
+		}
+		if newest == nil || relayEvent.CreatedAt > newest.CreatedAt {
+			newest = relayEvent.Event
+		}
+	}
+	if newest == nil {
+		return nil, fmt.Errorf("no kind %d event by %s found on %v", d.kind, d.pubkey, d.relays)
+	}
+	d.verbose.Debugf(ctx, "Upstream discovery: newest event %s from %s", newest.ID, newest.CreatedAt.Time().UTC().Format(time.RFC3339))
+	return newest, nil
+}
+
+// check verifies that an event is a correctly signed discovery event by the configured author
+func (d *Discoverer) check(event *nostr.Event) error {
+	if event.PubKey != d.pubkey || event.Kind != d.kind {
+		return fmt.Errorf("unexpected author or kind")
+	}
+	if d.dTag != "" {
+		if tag := event.Tags.GetD(); tag != d.dTag {
+			return fmt.Errorf("unexpected d tag %q", tag)
+		}
+	}
+	if ok, err := event.CheckSignature(); !ok {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	return nil
+}
+
+// apply sets cfg's upstream servers from the event's server list
+// Configured servers with the same URL keep their options; in "replace" mode other
+// configured servers are dropped, in "merge" mode discovered servers are added after them
+// Discovered servers without configured options get priorities in event order
+func (d *Discoverer) apply(cfg *config.Config, event *nostr.Event) error {
+	urls := ServersFromEvent(event)
+	if len(urls) == 0 {
+		return fmt.Errorf("discovery event %s lists no upstream servers", event.ID)
+	}
+
+	configured := make(map[string]config.UpstreamServer, len(cfg.UpstreamServers))
+	lowestPriority := 0
+	for _, server := range cfg.UpstreamServers {
+		configured[normalizeURL(server.URL)] = server
+		lowestPriority = max(lowestPriority, server.Priority)
+	}
+
+	var servers []config.UpstreamServer
+	if d.mode == "merge" {
+		servers = append(servers, cfg.UpstreamServers...)
+	}
+	for i, serverURL := range urls {
+		server, ok := configured[serverURL]
+		if ok && d.mode == "merge" {
+			continue // Already included
+		}
+		if !ok {
+			server = config.UpstreamServer{URL: serverURL, Priority: i + 1}
+			if d.mode == "merge" {
+				server.Priority = lowestPriority + i + 1
+			}
+		}
+		servers = append(servers, server)
+	}
+	cfg.UpstreamServers = servers
+	return nil
+}
+
+// ServersFromEvent returns the upstream URLs listed in a discovery event, in order and without
+// duplicates: "server" tags (BUD-03 server lists) and "r" tags (NIP-51 sets) with http(s) URLs
+func ServersFromEvent(event *nostr.Event) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || (tag[0] != "server" && tag[0] != "r") {
+			continue
+		}
+		serverURL := normalizeURL(tag[1])
+		parsed, err := url.Parse(serverURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			continue
+		}
+		if !seen[serverURL] {
+			seen[serverURL] = true
+			urls = append(urls, serverURL)
+		}
+	}
+	return urls
+}
+
+// normalizeURL trims whitespace and trailing slashes, so list entries match configured URLs
+func normalizeURL(serverURL string) string {
+	return strings.TrimRight(strings.TrimSpace(serverURL), "/")
+}
+
+// serverURLs returns the normalized URLs of servers
+func serverURLs(servers []config.UpstreamServer) []string {
+	urls := make([]string, len(servers))
+	for i, server := range servers {
+		urls[i] = normalizeURL(server.URL)
+	}
+	return urls
+}
+
+// setCurrent records the event and upstream set of the running configuration
+func (d *Discoverer) setCurrent(event *nostr.Event, servers []config.UpstreamServer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.current = event
+	d.servers = serverURLs(servers)
+}
+
+// saveCache writes the event to the cache file (if configured)
+func (d *Discoverer) saveCache(event *nostr.Event) {
+	if d.cacheFile == "" {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		logging.Warnf(context.Background(), "Upstream discovery: failed to encode event for the cache: %v", err)
+		return
+	}
+	tmp := d.cacheFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		logging.Warnf(context.Background(), "Upstream discovery: failed to write cache file: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, d.cacheFile); err != nil {
+		logging.Warnf(context.Background(), "Upstream discovery: failed to write cache file: %v", err)
+	}
+}
+
+// loadCache reads and verifies the cached event
+func (d *Discoverer) loadCache() (*nostr.Event, error) {
+	if d.cacheFile == "" {
+		return nil, fmt.Errorf("no cache_file configured")
+	}
+	data, err := os.ReadFile(d.cacheFile)
+	if err != nil {
+		return nil, err
+	}
+	var event nostr.Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, fmt.Errorf("failed to decode cache file: %w", err)
+	}
+	if err := d.check(&event); err != nil {
+		return nil, fmt.Errorf("cached event: %w", err)
+	}
+	return &event, nil
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/activity"
+	"github.com/girino/blossom_espelhator/internal/stats"
+)
+
+// latencyOperations is the order operations are shown in on the dashboard
+var latencyOperations = []struct{ opType, label string }{
+	{"upload", "upload"},
+	{"mirror", "mirror"},
+	{"head", "HEAD"},
+	{"delete", "delete"},
+	{"list", "list"},
+}
+
+// recordActivity adds a finished upload, mirror or delete to the activity feed
+// The outcome is partial when the operation succeeded but some targeted servers failed
+func (h *BlossomHandler) recordActivity(operation string, hash string, size int64, servers int, targets int, failed bool) {
+	outcome := activity.OutcomeSuccess
+	switch {
+	case failed:
+		outcome = activity.OutcomeFailed
+	case servers < targets:
+		outcome = activity.OutcomePartial
+	}
+	h.activity.Record(activity.Event{
+		Operation: operation,
+		Hash:      hash,
+		Size:      size,
+		Outcome:   outcome,
+		Servers:   servers,
+		Targets:   targets,
+	})
+}
+
+// activityRows formats the activity feed for the dashboard
+func (h *BlossomHandler) activityRows() []ActivityRow {
+	events := h.activity.Recent()
+	rows := make([]ActivityRow, 0, len(events))
+	for _, event := range events {
+		hashPrefix := "-"
+		if len(event.Hash) >= 12 {
+			hashPrefix = event.Hash[:12] + "…"
+		}
+		size := "-"
+		if event.Size >= 0 {
+			size = formatSize(event.Size)
+		}
+		rows = append(rows, ActivityRow{
+			Time:       event.Time.Format(time.DateTime),
+			Operation:  event.Operation,
+			HashPrefix: hashPrefix,
+			Size:       size,
+			Outcome:    event.Outcome,
+			Servers:    fmt.Sprintf("%d/%d", event.Servers, event.Targets),
+		})
+	}
+	return rows
+}
+
+// latencyBadges formats a server's recent latency for the dashboard
+func latencyBadges(latency map[string]*stats.Latency) []LatencyBadge {
+	badges := make([]LatencyBadge, 0, len(latency))
+	for _, op := range latencyOperations {
+		summary, ok := latency[op.opType]
+		if !ok || summary.Samples == 0 {
+			continue
+		}
+		badges = append(badges, LatencyBadge{
+			Operation: op.label,
+			P95:       formatLatency(summary.P95Ms),
+			P50:       formatLatency(summary.P50Ms),
+			Samples:   summary.Samples,
+		})
+	}
+	return badges
+}
+
+// formatLatency formats milliseconds as "850 ms" or "2.4 s"
+func formatLatency(ms float64) string {
+	if ms < 1000 {
+		return fmt.Sprintf("%.0f ms", ms)
+	}
+	return fmt.Sprintf("%.1f s", ms/1000)
+}
+
+// formatSize formats a byte count as "512 B", "300.0 KB", "1.2 MB", ...
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value := float64(bytes) / unit
+	for _, suffix := range []string{"KB", "MB", "GB"} {
+		if value < unit {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+		value /= unit
+	}
+	return fmt.Sprintf("%.1f TB", value)
+}
+
+// formatUptime formats a duration coarsely as "45s", "12m", "3h 20m" or "5d 4h"
+func formatUptime(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%dd %dh", int(d.Hours())/24, int(d.Hours())%24)
+}
+
+// bandwidthSummary formats the bandwidth of one period for the dashboard as
+// "<in> in, <out> out, ~<redirected> redirected"
+func bandwidthSummary(periods []*stats.BandwidthPeriod, key string) string {
+	var totals stats.BandwidthTotals
+	for _, period := range periods {
+		if period.Period == key {
+			totals = period.BandwidthTotals
+			break
+		}
+	}
+	return fmt.Sprintf("%s in, %s out, ~%s redirected", formatSize(totals.ReceivedBytes),
+		formatSize(totals.SentUpstreamBytes+totals.ServedBytes), formatSize(totals.RedirectedBytes))
+}
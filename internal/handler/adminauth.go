@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/girino/blossom_espelhator/internal/auth"
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// adminCredentialsConfigured reports whether dedicated admin credentials (admin_token or
+// admin_pubkeys) are set, in which case status credentials no longer grant admin access
+func (h *BlossomHandler) adminCredentialsConfigured() bool {
+	return h.config.Server.AdminToken != "" || len(h.adminPubkeys) > 0
+}
+
+// adminAuthenticated reports whether the request carries admin credentials: the admin
+// token as a bearer token, or an admin event (see adminEventPubkey) signed by one of
+// admin_pubkeys. Also returns who authenticated, for the audit log
+func (h *BlossomHandler) adminAuthenticated(r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	scheme, credentials, _ := strings.Cut(authHeader, " ")
+
+	switch {
+	case strings.EqualFold(scheme, "bearer") && h.config.Server.AdminToken != "":
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(credentials)), []byte(h.config.Server.AdminToken)) == 1 {
+			return "admin token", true
+		}
+	case strings.EqualFold(scheme, "nostr") && len(h.adminPubkeys) > 0:
+		if pubkey, ok := h.adminEventPubkey(r, h.adminPubkeys); ok {
+			return "pubkey " + pubkey, true
+		}
+	}
+	return "", false
+}
+
+// adminEventPubkey validates a Nostr authorization event for the admin API: kind 24242
+// signed by one of pubkeys, with the "admin" verb and bound to the request's method and
+// URL, so the upload, delete, list and get events forwarded to upstreams can't be replayed
+// here. Returns the pubkey that signed it
+func (h *BlossomHandler) adminEventPubkey(r *http.Request, pubkeys map[string]bool) (string, bool) {
+	event, err := auth.Authenticate(r, auth.VerbAdmin, pubkeys, h.authVerbose.Enabled())
+	if err == nil {
+		err = auth.ValidateRequestBinding(event, r)
+	}
+	if err != nil {
+		h.authVerbose.Debugf(r.Context(), "adminEventPubkey: Nostr authentication failed: %v", err)
+		return "", false
+	}
+	return strings.ToLower(event.PubKey), true
+}
+
+// requireAdminAuth rejects with 401 requests to admin endpoints that change the proxy's
+// state or reveal its configuration unless they carry admin credentials (admin_token or
+// admin_pubkeys), or status credentials when no admin credentials are configured, whatever
+// status_access is. Without any of them configured these endpoints are disabled (403)
+// Accepted and rejected requests are written to the audit log
+// Returns true if the request may proceed
+func (h *BlossomHandler) requireAdminAuth(w http.ResponseWriter, r *http.Request) bool {
+	if !h.adminCredentialsConfigured() && h.config.Server.StatusUsername == "" && len(h.statusPubkeys) == 0 {
+		http.Error(w, "Admin API disabled (set admin_token, admin_pubkeys, status_username or status_pubkeys)", http.StatusForbidden)
+		return false
+	}
+
+	// Without admin credentials, status credentials are accepted: basic auth, or an admin
+	// event signed by one of status_pubkeys (their status events only open the status pages)
+	who, ok := h.adminAuthenticated(r)
+	if !ok && !h.adminCredentialsConfigured() {
+		if h.statusBasicAuthenticated(r) {
+			username, _, _ := r.BasicAuth()
+			who, ok = "user "+username, true
+		} else if len(h.statusPubkeys) > 0 {
+			if pubkey, valid := h.adminEventPubkey(r, h.statusPubkeys); valid {
+				who, ok = "pubkey "+pubkey, true
+			}
+		}
+	}
+	if !ok {
+		logging.Warnf(r.Context(), "Admin audit: rejected %s %s from %s", r.Method, r.URL.RequestURI(), r.RemoteAddr)
+		if h.adminCredentialsConfigured() {
+			if h.config.Server.AdminToken != "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="blossom_espelhator admin"`)
+			}
+			w.Header().Set("X-Reason", "Admin authentication required")
+			http.Error(w, "Admin authentication required", http.StatusUnauthorized)
+			return false
+		}
+		h.rejectStatusRequest(w)
+		return false
+	}
+
+	log.Printf("Admin audit: %s %s by %s from %s", r.Method, r.URL.RequestURI(), who, r.RemoteAddr)
+	return true
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const testAdminToken = "0123456789abcdef-admin"
+
+// testAdminUpstream is never contacted: admin authentication is checked locally
+const testAdminUpstream = "http://127.0.0.1:1"
+
+// adminEvent returns an Authorization header with an admin event bound to method and target
+func adminEvent(t *testing.T, secretKey string, method string, target string) string {
+	t.Helper()
+	return authHeaderWithTags(t, secretKey, nostr.Tag{"t", "admin"}, nostr.Tag{"method", method}, nostr.Tag{"u", "https://proxy.example.com" + target})
+}
+
+func TestRequireAdminAuth(t *testing.T) {
+	adminKey := nostr.GeneratePrivateKey()
+	admin, _ := nostr.GetPublicKey(adminKey)
+	statusKey := nostr.GeneratePrivateKey()
+	status, _ := nostr.GetPublicKey(statusKey)
+	const target = "/admin/blocklist"
+
+	adminCredentials := "  admin_token: " + testAdminToken + "\n  admin_pubkeys: [" + admin + "]\n"
+	statusCredentials := "  status_username: ops\n  status_password: secret\n  status_pubkeys: [" + status + "]\n"
+
+	tests := []struct {
+		name          string
+		extra         string // Server settings
+		authorization string
+		basicAuth     bool // Send the status credentials with basic auth
+		code          int  // 0: allowed
+	}{
+		{"disabled", "", "Bearer " + testAdminToken, false, http.StatusForbidden},
+		{"admin token", adminCredentials, "Bearer " + testAdminToken, false, 0},
+		{"wrong token", adminCredentials, "Bearer " + testAdminToken + "x", false, http.StatusUnauthorized},
+		{"no credentials", adminCredentials, "", false, http.StatusUnauthorized},
+		{"admin event", adminCredentials, adminEvent(t, adminKey, http.MethodPost, target), false, 0},
+		{"admin event for another request", adminCredentials, adminEvent(t, adminKey, http.MethodDelete, target), false, http.StatusUnauthorized},
+		{"upload event of an admin", adminCredentials, authHeader(t, adminKey, "upload", ""), false, http.StatusUnauthorized},
+		{"admin event of another pubkey", adminCredentials, adminEvent(t, statusKey, http.MethodPost, target), false, http.StatusUnauthorized},
+		{"status credentials without admin credentials", statusCredentials, "", true, 0},
+		{"status event without admin credentials", statusCredentials, adminEvent(t, statusKey, http.MethodPost, target), false, 0},
+		{"status credentials next to admin credentials", statusCredentials + adminCredentials, "", true, http.StatusUnauthorized},
+		{"status event next to admin credentials", statusCredentials + adminCredentials, adminEvent(t, statusKey, http.MethodPost, target), false, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, tt.extra, testAdminUpstream)
+			req := httptest.NewRequest(http.MethodPost, target, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.basicAuth {
+				req.SetBasicAuth("ops", "secret")
+			}
+			rec := httptest.NewRecorder()
+			allowed := h.requireAdminAuth(rec, req)
+
+			if tt.code == 0 {
+				if !allowed {
+					t.Errorf("rejected with %d (%s), want allowed", rec.Code, rec.Body.String())
+				}
+				return
+			}
+			if allowed || rec.Code != tt.code {
+				t.Errorf("allowed = %v, status = %d, want rejected with %d", allowed, rec.Code, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"gopkg.in/yaml.v3"
+)
+
+// runtimeOverrides lists how the upstream set differs from the configuration file after
+// changes made through /admin/upstreams
+type runtimeOverrides struct {
+	Added   []string `yaml:"added"`
+	Removed []string `yaml:"removed"`
+	Paused  []string `yaml:"paused"`
+}
+
+// effectiveConfig is the body of GET /admin/config
+type effectiveConfig struct {
+	RuntimeOverrides runtimeOverrides `yaml:"runtime_overrides"`
+	config.Config    `yaml:",inline"`
+}
+
+// HandleConfig handles GET /admin/config: the configuration this instance runs with, with
+// defaults applied and secrets redacted. upstream_servers is the current upstream set
+// (including changes made through /admin/upstreams, listed in runtime_overrides)
+// The response is YAML in the configuration file format, or JSON with ?format=json
+// It reveals the whole configuration, so it always needs admin credentials (see requireAdminAuth)
+func (h *BlossomHandler) HandleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdminAuth(w, r) {
+		return
+	}
+
+	effective := effectiveConfig{Config: *h.config.Redacted()}
+	effective.UpstreamServers = h.upstreamManager.ServerConfigs()
+
+	configured := make(map[string]bool, len(h.config.UpstreamServers))
+	for _, server := range h.config.UpstreamServers {
+		configured[server.URL] = true
+	}
+	current := make(map[string]bool, len(effective.UpstreamServers))
+	for _, server := range h.upstreamManager.Servers() {
+		current[server.URL] = true
+		if !configured[server.URL] {
+			effective.RuntimeOverrides.Added = append(effective.RuntimeOverrides.Added, server.URL)
+		}
+		if server.Paused {
+			effective.RuntimeOverrides.Paused = append(effective.RuntimeOverrides.Paused, server.URL)
+		}
+	}
+	for _, server := range h.config.UpstreamServers {
+		if !current[server.URL] {
+			effective.RuntimeOverrides.Removed = append(effective.RuntimeOverrides.Removed, server.URL)
+		}
+	}
+
+	body, err := yaml.Marshal(&effective)
+	if err != nil {
+		logging.Errorf(r.Context(), "HandleConfig: failed to encode configuration: %v", err)
+		http.Error(w, "Failed to encode configuration", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		// Going through YAML keeps the configuration file's key names
+		var generic interface{}
+		if err := yaml.Unmarshal(body, &generic); err != nil {
+			logging.Errorf(r.Context(), "HandleConfig: failed to convert configuration: %v", err)
+			http.Error(w, "Failed to encode configuration", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(generic)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+	w.Write([]byte("# Effective configuration (defaults applied, secrets redacted)\n"))
+	w.Write(body)
+}
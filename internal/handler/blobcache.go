@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/recovery"
+	"github.com/girino/blossom_espelhator/internal/upstream"
+	"github.com/girino/blossom_espelhator/pkg/blossomclient"
+)
+
+// countingResponseWriter counts the body bytes written to the client
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (cw *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func (cw *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// serveFromBlobStore answers a GET/HEAD request from the local blob cache, honoring Range
+// and conditional headers. Returns true if the request was served
+func (h *BlossomHandler) serveFromBlobStore(w http.ResponseWriter, r *http.Request, path string, logPrefix string) bool {
+	hash := path[:64]
+	file, info, ok := h.blobStore.Open(hash)
+	if !ok {
+		return false
+	}
+	defer file.Close()
+
+	h.cacheVerbose.Debugf(r.Context(), "%s: serving %s from blob cache (%d bytes)", logPrefix, hash, info.Size)
+
+	setCORSHeaders(w, r)
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hash+`"`)
+
+	cw := &countingResponseWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, "", info.ModTime, file)
+	h.stats.RecordServed(cw.n)
+	if r.Context().Err() != nil {
+		markClientAbort(w)
+	}
+	return true
+}
+
+// fillBlobStore downloads a blob into the local blob cache in the background after a
+// download was redirected to serverURL, so later downloads are served by the proxy
+// Blobs already cached or being cached, or known to be too large, are skipped
+func (h *BlossomHandler) fillBlobStore(path string, serverURL string, headMetadata map[string]http.Header) {
+	hash := path[:64]
+	contentType := ""
+	if headers, ok := headMetadata[serverURL]; ok {
+		contentType = headers.Get("Content-Type")
+	}
+	writer := h.blobStore.Create(hash, contentType, upstream.BlobSize(headMetadata, serverURL))
+	if writer == nil {
+		return
+	}
+
+	go func() {
+		defer recovery.Recover("Blob cache fill")
+
+		cl, err := h.upstreamManager.GetClient(serverURL)
+		if err != nil {
+			writer.Abort()
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), h.config.Server.BlobCacheFillTimeout)
+		defer cancel()
+
+		resp, err := cl.GetWithHeaders(ctx, path, map[string]string{"Accept-Encoding": "identity"})
+		if err != nil {
+			writer.Abort()
+			h.cacheVerbose.Debugf(ctx, "Blob cache: failed to fetch %s from %s: %v", hash, serverURL, err)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			writer.Abort()
+			h.cacheVerbose.Debugf(ctx, "Blob cache: fetching %s from %s returned status %d", hash, serverURL, resp.StatusCode)
+			return
+		}
+
+		// A server compressing the blob anyway gets it decoded, so it can match its hash
+		body, err := blossomclient.DecodedBody(resp)
+		if err != nil {
+			writer.Abort()
+			h.cacheVerbose.Debugf(ctx, "Blob cache: failed to fetch %s from %s: %v", hash, serverURL, err)
+			return
+		}
+		defer body.Close()
+
+		// Larger blobs are read one byte past the limit, which makes Commit reject them
+		if _, err := io.Copy(writer, io.LimitReader(body, h.config.Server.BlobCacheMaxBlobBytes+1)); err != nil {
+			writer.Abort()
+			h.cacheVerbose.Debugf(ctx, "Blob cache: failed to fetch %s from %s: %v", hash, serverURL, err)
+			return
+		}
+		if err := writer.Commit(); err != nil {
+			logging.Warnf(ctx, "%v (from %s)", err, serverURL)
+		}
+	}()
+}
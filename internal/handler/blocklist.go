@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/girino/blossom_espelhator/internal/blocklist"
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// maxBlocklistRequestBytes bounds the body of POST /admin/blocklist
+const maxBlocklistRequestBytes = 1 << 20
+
+// blocklistRequest is the body of POST /admin/blocklist
+type blocklistRequest struct {
+	Hash   string   `json:"hash"`
+	Hashes []string `json:"hashes"` // Several hashes blocked at once, with the same reason
+	Reason string   `json:"reason"`
+}
+
+// blocklistResponse is the body of the /admin/blocklist responses
+type blocklistResponse struct {
+	Blocked int               `json:"blocked"`
+	Blobs   []blocklist.Entry `json:"blobs"` // Most recently blocked first
+}
+
+// HandleBlocklist handles /admin/blocklist: GET lists the blocked hashes, POST blocks one
+// or more, DELETE ?hash=<sha256> unblocks one. Blocked hashes are persisted in
+// blocklist_path if set. The list itself points at unwanted content, so reading it needs
+// admin credentials like changing it
+func (h *BlossomHandler) HandleBlocklist(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodPost, http.MethodDelete:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdminAuth(w, r) {
+		return
+	}
+	if h.blocklist == nil {
+		http.Error(w, "Blocklist not available", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		hashes, reason, err := parseBlocklistRequest(r)
+		if err != nil {
+			logging.Warnf(r.Context(), "HandleBlocklist: POST rejected: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.blockHashes(hashes, reason); err != nil {
+			logging.Errorf(r.Context(), "HandleBlocklist: %v", err)
+			http.Error(w, "Failed to save blocklist", http.StatusInternalServerError)
+			return
+		}
+	case http.MethodDelete:
+		hash := r.URL.Query().Get("hash")
+		if hash == "" {
+			http.Error(w, "missing hash parameter", http.StatusBadRequest)
+			return
+		}
+		removed, err := h.blocklist.Remove(hash)
+		if err != nil {
+			logging.Errorf(r.Context(), "HandleBlocklist: %v", err)
+			http.Error(w, "Failed to save blocklist", http.StatusInternalServerError)
+			return
+		}
+		if !removed {
+			http.Error(w, "Hash is not blocked", http.StatusNotFound)
+			return
+		}
+		log.Printf("Blocklist: unblocked %s", strings.ToLower(hash))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blocklistResponse{
+		Blocked: h.blocklist.Len(),
+		Blobs:   h.blocklist.Entries(),
+	})
+}
+
+// parseBlocklistRequest returns the hashes and reason of a POST to /admin/blocklist
+func parseBlocklistRequest(r *http.Request) ([]string, string, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBlocklistRequestBytes))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read request body: %w", err)
+	}
+	var req blocklistRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, "", fmt.Errorf("invalid request body: %w", err)
+	}
+	hashes := req.Hashes
+	if req.Hash != "" {
+		hashes = append(hashes, req.Hash)
+	}
+	if len(hashes) == 0 {
+		return nil, "", fmt.Errorf("missing hash")
+	}
+	for i, hash := range hashes {
+		hashes[i] = strings.ToLower(strings.TrimSpace(hash))
+		if !isValidHash(hashes[i]) {
+			return nil, "", fmt.Errorf("invalid hash %q (expected 64 hex characters)", hash)
+		}
+	}
+	return hashes, req.Reason, nil
+}
+
+// blockHashes adds hashes to the blocklist, dropping them from the location cache, the
+// blob cache, the recent uploads and the queued uploads so the proxy never serves or
+// replicates them. The caches check the blocklist again when entries are added (see
+// SetBlocklist), so lookups and downloads running meanwhile can't add them back
+func (h *BlossomHandler) blockHashes(hashes []string, reason string) error {
+	for _, hash := range hashes {
+		added, err := h.blocklist.Add(hash, reason)
+		if err != nil {
+			return err
+		}
+		h.cache.Remove(hash)
+		h.blobStore.Remove(hash)
+		h.recentUploads.Forget(hash)
+		if h.queued != nil {
+			h.queued.remove(hash)
+		}
+		if added {
+			log.Printf("Blocklist: blocked %s (reason: %q)", hash, reason)
+		}
+	}
+	return nil
+}
+
+// rejectBlocked answers 403 to a request for a blocked hash
+// Returns true if the request was rejected
+func (h *BlossomHandler) rejectBlocked(w http.ResponseWriter, r *http.Request, hash string, logPrefix string) bool {
+	if !h.blocklist.Contains(hash) {
+		return false
+	}
+	logging.Warnf(r.Context(), "%s: refusing %s %s from %s: blob %s is blocked", logPrefix, r.Method, r.URL.Path, r.RemoteAddr, strings.ToLower(hash))
+	setCORSHeaders(w, r)
+	w.Header().Set("X-Reason", "Blob is blocked")
+	http.Error(w, "Blob is blocked", http.StatusForbidden)
+	return true
+}
+
+// blockedHashes returns the function checking upload bodies against the blocklist, or nil
+// while it is empty (see checksumReader)
+func (h *BlossomHandler) blockedHashes() func(hash string) bool {
+	if h.blocklist.Len() == 0 {
+		return nil
+	}
+	return h.blocklist.Contains
+}
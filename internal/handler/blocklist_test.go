@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/girino/blossom_espelhator/internal/blocklist"
+	"github.com/girino/blossom_espelhator/internal/journal"
+)
+
+// adminBlocklistRequest sends a request to /admin/blocklist with the admin token
+func adminBlocklistRequest(t *testing.T, h *BlossomHandler, method string, target string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rec := httptest.NewRecorder()
+	h.HandleBlocklist(rec, req)
+	return rec
+}
+
+func TestBlocklist(t *testing.T) {
+	srv, requests := countingUpstream(t)
+	h := newTestHandler(t, "  admin_token: "+testAdminToken+"\n", srv.URL)
+	b, err := blocklist.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetBlocklist(b)
+	h.cache.SetBlocked(b.Contains) // As wired in main
+
+	blob := "blocked blob"
+	hash := sha256Hex(blob)
+	h.cache.Add(hash, []string{srv.URL})
+
+	rec := adminBlocklistRequest(t, h, http.MethodPost, "/admin/blocklist", `{"hash":"`+strings.ToUpper(hash)+`","reason":"abuse report"}`)
+	var listed blocklistResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("POST status = %d (%s), want the blocklist", rec.Code, rec.Body.String())
+	}
+	if listed.Blocked != 1 || listed.Blobs[0].Hash != hash || listed.Blobs[0].Reason != "abuse report" {
+		t.Errorf("blocklist = %+v, want the blocked hash with its reason", listed)
+	}
+	if rec := adminBlocklistRequest(t, h, http.MethodPost, "/admin/blocklist", `{"hash":"1234"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST of an invalid hash: status = %d, want 400", rec.Code)
+	}
+
+	// Blocking drops the cache entry, which lookups still running can't add back
+	if _, found := h.cache.Peek(hash); found {
+		t.Error("blocked hash still in the location cache")
+	}
+	h.cache.Add(hash, []string{srv.URL})
+	if _, found := h.cache.Peek(hash); found {
+		t.Error("blocked hash added back to the location cache")
+	}
+
+	refused := []struct {
+		name   string
+		serve  func(w http.ResponseWriter, r *http.Request)
+		req    *http.Request
+		header string // X-SHA-256
+	}{
+		{"download", h.HandleDownload, httptest.NewRequest(http.MethodGet, "/"+hash, nil), ""},
+		{"head", h.HandleHead, httptest.NewRequest(http.MethodHead, "/"+hash+".png", nil), ""},
+		{"announced upload", h.HandleUpload, httptest.NewRequest(http.MethodPut, "/upload", strings.NewReader(blob)), hash},
+		{"mirror", h.HandleMirror, httptest.NewRequest(http.MethodPut, "/mirror", strings.NewReader(`{"url":"https://cdn.example.com/`+hash+`"}`)), ""},
+	}
+	for _, tt := range refused {
+		t.Run(tt.name, func(t *testing.T) {
+			before := requests.Load()
+			if tt.header != "" {
+				tt.req.Header.Set(checksumHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			tt.serve(rec, tt.req)
+			if rec.Code != http.StatusForbidden || rec.Header().Get("X-Reason") != "Blob is blocked" {
+				t.Errorf("status = %d, X-Reason %q; want 403 Blob is blocked", rec.Code, rec.Header().Get("X-Reason"))
+			}
+			if requests.Load() != before {
+				t.Error("blocked request reached the upstream")
+			}
+		})
+	}
+
+	// An upload that didn't announce its hash is refused once the body is read
+	rec = httptest.NewRecorder()
+	h.HandleUpload(rec, httptest.NewRequest(http.MethodPut, "/upload", strings.NewReader(blob)))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("upload of a blocked body: status = %d (%s), want 403", rec.Code, rec.Body.String())
+	}
+
+	// Journaled retries of a blob blocked since are dropped
+	before := requests.Load()
+	if err := h.replayMirror(context.Background(), journal.Entry{Kind: journal.KindMirror, Hash: hash, ServerURL: srv.URL, Body: []byte(`{}`)}); err != nil {
+		t.Errorf("replayMirror of a blocked blob = %v, want dropped", err)
+	}
+	if requests.Load() != before {
+		t.Error("journaled mirror of a blocked blob reached the upstream")
+	}
+
+	// Unblocked blobs are served again
+	if rec := adminBlocklistRequest(t, h, http.MethodDelete, "/admin/blocklist?hash="+hash, ""); rec.Code != http.StatusOK {
+		t.Fatalf("DELETE status = %d (%s), want 200", rec.Code, rec.Body.String())
+	}
+	if rec := adminBlocklistRequest(t, h, http.MethodDelete, "/admin/blocklist?hash="+hash, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second DELETE status = %d, want 404", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.HandleDownload(rec, httptest.NewRequest(http.MethodGet, "/"+hash, nil))
+	if rec.Code == http.StatusForbidden {
+		t.Error("unblocked blob still refused")
+	}
+}
@@ -6,66 +6,108 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"mime"
 	"net/http"
+	"net/netip"
+	"path"
 	"runtime"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/girino/blossom_espelhator/internal/activity"
 	"github.com/girino/blossom_espelhator/internal/auth"
+	"github.com/girino/blossom_espelhator/internal/blobstore"
+	"github.com/girino/blossom_espelhator/internal/blocklist"
 	"github.com/girino/blossom_espelhator/internal/cache"
+	"github.com/girino/blossom_espelhator/internal/cluster"
 	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/journal"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/popularity"
+	"github.com/girino/blossom_espelhator/internal/quarantine"
+	"github.com/girino/blossom_espelhator/internal/quota"
+	"github.com/girino/blossom_espelhator/internal/recovery"
+	"github.com/girino/blossom_espelhator/internal/repair"
+	"github.com/girino/blossom_espelhator/internal/spool"
 	"github.com/girino/blossom_espelhator/internal/stats"
 	"github.com/girino/blossom_espelhator/internal/upstream"
 	"github.com/nbd-wtf/go-nostr"
 )
 
+// mimeExtensions maps common mime types to file extensions
+var mimeExtensions = map[string]string{
+	"image/png":                ".png",
+	"image/jpeg":               ".jpg",
+	"image/jpg":                ".jpg",
+	"image/gif":                ".gif",
+	"image/webp":               ".webp",
+	"image/svg+xml":            ".svg",
+	"image/bmp":                ".bmp",
+	"image/x-icon":             ".ico",
+	"image/vnd.microsoft.icon": ".ico",
+	"application/pdf":          ".pdf",
+	"application/json":         ".json",
+	"text/plain":               ".txt",
+	"text/html":                ".html",
+	"text/css":                 ".css",
+	"text/javascript":          ".js",
+	"application/javascript":   ".js",
+	"application/xml":          ".xml",
+	"text/xml":                 ".xml",
+	"video/mp4":                ".mp4",
+	"video/webm":               ".webm",
+	"video/ogg":                ".ogv",
+	"audio/mpeg":               ".mp3",
+	"audio/ogg":                ".oga",
+	"audio/wav":                ".wav",
+	"audio/webm":               ".weba",
+	"application/zip":          ".zip",
+	"application/x-tar":        ".tar",
+	"application/gzip":         ".gz",
+}
+
 // mimeTypeToExtension maps common mime types to file extensions
 func mimeTypeToExtension(mimeType string) string {
 	// Remove charset/parameters if present (e.g., "text/html; charset=utf-8")
 	parts := strings.Split(mimeType, ";")
 	mimeType = strings.TrimSpace(parts[0])
 
-	mimeMap := map[string]string{
-		"image/png":                ".png",
-		"image/jpeg":               ".jpg",
-		"image/jpg":                ".jpg",
-		"image/gif":                ".gif",
-		"image/webp":               ".webp",
-		"image/svg+xml":            ".svg",
-		"image/bmp":                ".bmp",
-		"image/x-icon":             ".ico",
-		"image/vnd.microsoft.icon": ".ico",
-		"application/pdf":          ".pdf",
-		"application/json":         ".json",
-		"text/plain":               ".txt",
-		"text/html":                ".html",
-		"text/css":                 ".css",
-		"text/javascript":          ".js",
-		"application/javascript":   ".js",
-		"application/xml":          ".xml",
-		"text/xml":                 ".xml",
-		"video/mp4":                ".mp4",
-		"video/webm":               ".webm",
-		"video/ogg":                ".ogv",
-		"audio/mpeg":               ".mp3",
-		"audio/ogg":                ".oga",
-		"audio/wav":                ".wav",
-		"audio/webm":               ".weba",
-		"application/zip":          ".zip",
-		"application/x-tar":        ".tar",
-		"application/gzip":         ".gz",
-	}
-
-	if ext, ok := mimeMap[strings.ToLower(mimeType)]; ok {
+	if ext, ok := mimeExtensions[strings.ToLower(mimeType)]; ok {
 		return ext
 	}
 	return ""
 }
 
+// extensionToMimeType maps a file extension to a mime type, using the standard library's
+// table and then the types the proxy knows extensions for (e.g. videos, which the standard
+// table lacks without a system mime database). Returns "" for unknown extensions
+func extensionToMimeType(ext string) string {
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		return mimeType
+	}
+	ext = strings.ToLower(ext)
+	for mimeType, known := range mimeExtensions {
+		if known == ext {
+			return mimeType
+		}
+	}
+	return ""
+}
+
+// useLocalResponseURL reports whether upload/mirror/list responses carry proxy-rooted URLs
+// in the primary url field: either redirect_strategy is "local", or response_url_mode is
+// "prefer_base_url" and base_url is set. Upstream URLs stay available as url tags
+func (h *BlossomHandler) useLocalResponseURL() bool {
+	if h.config.Server.RedirectStrategy == "local" {
+		return true
+	}
+	return h.config.Server.ResponseURLMode == "prefer_base_url" && h.config.Server.BaseURL != ""
+}
+
 // constructLocalURL constructs a local URL in the format baseurl/sha256.ext
 // Base URL is from config if set, otherwise derived from the request (scheme + host)
 // Extracts extension from: 1) URL path if available, 2) mime type, 3) none if neither available
@@ -135,35 +177,217 @@ func validatePath(path string) error {
 	return nil
 }
 
+// isValidHash reports whether s is a 64-character hex SHA-256 hash
+func isValidHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// declaredHash returns the blob hash the client announced for an upload, if any
+// It checks the X-SHA-256 header first, then a single "x" tag in the authorization event
+// The hash is not verified here - it is only a hint until the body has been hashed
+func declaredHash(r *http.Request) string {
+	if xHash := strings.ToLower(strings.TrimSpace(r.Header.Get("X-SHA-256"))); isValidHash(xHash) {
+		return xHash
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return ""
+	}
+	event, err := auth.ParseAuthorizationHeader(authHeader)
+	if err != nil {
+		return ""
+	}
+
+	var found string
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == "x" {
+			if found != "" {
+				// Multiple x tags - the hash is ambiguous
+				return ""
+			}
+			found = strings.ToLower(tag[1])
+		}
+	}
+	if isValidHash(found) {
+		return found
+	}
+	return ""
+}
+
+// hashFromMirrorBody extracts the blob hash from a BUD-04 mirror request body ({"url": "..."})
+// Returns an empty string if the URL does not end with a sha256 hash
+func hashFromMirrorBody(body []byte) string {
+	return lastPathSegment(body, func(segment string) string {
+		if len(segment) < 64 {
+			return ""
+		}
+		if candidate := strings.ToLower(segment[:64]); isValidHash(candidate) {
+			return candidate
+		}
+		return ""
+	})
+}
+
+// contentTypeFromMirrorBody guesses the content type of a BUD-04 mirror request's blob from
+// the extension of its URL. Returns "" if the URL has no known extension
+func contentTypeFromMirrorBody(body []byte) string {
+	return lastPathSegment(body, func(segment string) string {
+		if ext := path.Ext(segment); ext != "" {
+			return extensionToMimeType(ext)
+		}
+		return ""
+	})
+}
+
+// lastPathSegment applies extract to the last path segment of a mirror request's URL
+func lastPathSegment(body []byte, extract func(segment string) string) string {
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.URL == "" {
+		return ""
+	}
+
+	segment := req.URL
+	if idx := strings.IndexAny(segment, "?#"); idx >= 0 {
+		segment = segment[:idx]
+	}
+	return extract(segment[strings.LastIndex(segment, "/")+1:])
+}
+
 // BlossomHandler handles Blossom protocol requests
 type BlossomHandler struct {
 	upstreamManager *upstream.Manager
 	cache           *cache.Cache
+	quarantine      *quarantine.Quarantine // Replicas that failed verification (never offered to clients)
+	spool           *spool.Spool           // In-flight and recently completed uploads (nil if disabled)
+	journal         *journal.Journal       // Persistent queue of retried background operations (nil if disabled)
+	queued          *queuedUploads         // Uploads stored locally while no upstream is healthy (nil if disabled)
 	stats           *stats.Stats
 	config          *config.Config
-	verbose         bool
-	allowedPubkeys  map[string]bool // Map of allowed pubkeys for authentication
+	debug           *logging.Levels      // Debug logging of every component, switched by /admin/log-level
+	verbose         *logging.Flag        // Debug logging of request handling
+	authVerbose     *logging.Flag        // Debug logging of Nostr authorization
+	cacheVerbose    *logging.Flag        // Debug logging of the location cache and blob cache
+	allowedPubkeys  map[string]bool      // Map of allowed pubkeys for authentication
+	authEndpoints   map[string]bool      // Endpoints requiring authentication (see auth_endpoints)
+	statusPubkeys   map[string]bool      // Pubkeys allowed to see full status details
+	adminPubkeys    map[string]bool      // Pubkeys allowed to use the admin API (see admin_pubkeys)
+	preflightSizes  *preflightSizes      // Sizes announced in BUD-06 preflight requests, by hash
+	loadShedder     loadShedder          // Rejects uploads while memory/goroutines exceed thresholds
+	slowLog         slowRequestLog       // Requests that exceeded the slow request thresholds
+	requestMetrics  requestMetrics       // Responses by status class and client aborts, per endpoint
+	activity        *activity.Feed       // Recent uploads, mirrors and deletes for the home page (nil if disabled)
+	recentUploads   *recentUploads       // Responses of recently completed uploads, for retried PUTs
+	cluster         *cluster.Cluster     // State shared with other instances (nil if not clustered)
+	blobStore       *blobstore.Store     // Downloaded blobs cached on local disk (nil if disabled)
+	popularity      *popularity.Tracker  // Download requests per blob (nil if disabled)
+	quotas          *quota.Tracker       // Upload quotas per authenticated pubkey (nil if disabled)
+	repairer        *repair.Repairer     // Mirrors under-replicated blobs in the background (nil if disabled)
+	scans           *scanDetector        // Clients enumerating unknown hashes (nil if disabled)
+	trustedProxies  []netip.Prefix       // Reverse proxies whose client address headers are trusted
+	rateLimits      *rateLimits          // Request rate limits per client address or pubkey (nil if disabled)
+	prefetches      prefetchAttempts     // Trending blobs recently mirrored to more servers
+	blocklist       *blocklist.Blocklist // Banned blob hashes, never uploaded, mirrored or served (nil blocks nothing)
+	lifecycle       context.Context      // Cancelled at shutdown, bounds background work started by requests
 }
 
 // New creates a new Blossom handler
-func New(upstreamManager *upstream.Manager, cache *cache.Cache, statsTracker *stats.Stats, cfg *config.Config, verbose bool) *BlossomHandler {
+func New(upstreamManager *upstream.Manager, cache *cache.Cache, q *quarantine.Quarantine, uploadSpool *spool.Spool, pendingOps *journal.Journal, statsTracker *stats.Stats, cfg *config.Config, debug *logging.Levels) *BlossomHandler {
+	verbose := debug.Flag(logging.Handler)
 	allowedPubkeys := auth.BuildAllowedPubkeysMap(cfg.Server.AllowedPubkeys)
-	if verbose && len(allowedPubkeys) > 0 {
-		log.Printf("[DEBUG] BlossomHandler: authentication enabled with %d allowed pubkeys", len(allowedPubkeys))
-	} else if verbose {
-		log.Printf("[DEBUG] BlossomHandler: authentication disabled (no allowed_pubkeys configured)")
+	authEndpoints := make(map[string]bool, len(cfg.Server.AuthEndpoints))
+	for _, endpoint := range cfg.Server.AuthEndpoints {
+		authEndpoints[endpoint] = true
+	}
+	if verbose.Enabled() && len(authEndpoints) > 0 {
+		verbose.Debugf(context.Background(), "BlossomHandler: authentication required for %v (%d allowed pubkeys, 0 = any)", cfg.Server.AuthEndpoints, len(allowedPubkeys))
+	} else if verbose.Enabled() {
+		verbose.Debugf(context.Background(), "BlossomHandler: authentication disabled (no allowed_pubkeys or auth_endpoints configured)")
 	}
 
-	return &BlossomHandler{
+	// Retried uploads are only short-circuited when enabled
+	idempotentWindow := cfg.Server.IdempotentUploadWindow
+	if cfg.Server.DisableIdempotentUploads {
+		idempotentWindow = 0
+	}
+
+	h := &BlossomHandler{
 		upstreamManager: upstreamManager,
 		cache:           cache,
+		quarantine:      q,
+		spool:           uploadSpool,
+		journal:         pendingOps,
 		stats:           statsTracker,
 		config:          cfg,
+		debug:           debug,
 		verbose:         verbose,
+		authVerbose:     debug.Flag(logging.Auth),
+		cacheVerbose:    debug.Flag(logging.Cache),
 		allowedPubkeys:  allowedPubkeys,
+		authEndpoints:   authEndpoints,
+		statusPubkeys:   auth.BuildAllowedPubkeysMap(cfg.Server.StatusPubkeys),
+		adminPubkeys:    auth.BuildAllowedPubkeysMap(cfg.Server.AdminPubkeys),
+		activity:        activity.New(cfg.Server.ActivityFeedSize),
+		popularity:      popularity.New(cfg.Server.PopularityHalfLife, cfg.Server.PopularityMaxHashes),
+		preflightSizes:  newPreflightSizes(),
+		recentUploads:   newRecentUploads(idempotentWindow),
+		quotas:          newUploadQuotas(&cfg.Server),
+		scans:           newScanDetector(cfg.Server.ScanMissThreshold, cfg.Server.ScanWindow, cfg.Server.ScanBlockDuration),
+		trustedProxies:  newTrustedProxies(cfg.Server.TrustedProxies),
+		rateLimits:      newRateLimits(&cfg.Server),
+		lifecycle:       context.Background(),
+	}
+	if cfg.Server.QueueUploadsWhenDegraded && pendingOps != nil {
+		queued, err := newQueuedUploads(cfg.Server.DegradedUploadDir, cfg.Server.DegradedUploadMaxBytes)
+		if err != nil {
+			logging.Warnf(context.Background(), "BlossomHandler: uploads will not be queued while degraded: %v", err)
+		} else {
+			h.queued = queued
+		}
+	}
+	h.registerJournalHandlers()
+	return h
+}
+
+// SetCluster reports the state shared with other instances in /stats
+func (h *BlossomHandler) SetCluster(c *cluster.Cluster) {
+	h.cluster = c
+}
+
+// SetBlobStore serves repeat downloads from blobs cached on local disk, admitting blobs by
+// their popularity when it is tracked
+func (h *BlossomHandler) SetBlobStore(store *blobstore.Store) {
+	h.blobStore = store
+	if h.popularity != nil {
+		store.SetAdmission(h.popularity.Score, h.config.Server.BlobCacheMinPopularity)
 	}
 }
 
+// SetBlocklist refuses uploads, mirrors and downloads of the banned hashes of b
+// Uploads completing after their hash was blocked aren't remembered for retries either
+func (h *BlossomHandler) SetBlocklist(b *blocklist.Blocklist) {
+	h.blocklist = b
+	h.recentUploads.setBlocked(b.Contains)
+}
+
+// SetLifecycle ties background work started by requests (e.g., polling servers that
+// replied 202 Accepted) to ctx, so it stops at shutdown
+func (h *BlossomHandler) SetLifecycle(ctx context.Context) {
+	h.lifecycle = ctx
+}
+
+// SetRepairer reports the replication repair rounds in /stats and mirrors trending blobs
+// held by a single server (see prefetch_min_popularity)
+func (h *BlossomHandler) SetRepairer(r *repair.Repairer) {
+	h.repairer = r
+}
+
 // setCORSHeaders sets CORS headers on the response
 func setCORSHeaders(w http.ResponseWriter, r *http.Request) {
 	origin := r.Header.Get("Origin")
@@ -173,12 +397,48 @@ func setCORSHeaders(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 	}
 	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, PUT, DELETE, OPTIONS, POST")
-	w.Header().Set("Access-Control-Allow-Headers", "authorization, x-content-length, x-content-type, x-sha-256, content-type")
+	w.Header().Set("Access-Control-Allow-Headers", "authorization, x-content-length, x-content-type, x-sha-256, x-dry-run, x-request-timeout, request-timeout, content-type")
+	w.Header().Set("Access-Control-Expose-Headers", "link, x-alt-locations, x-replicas, x-replication-target, x-reason, x-dry-run, x-upload-replayed, x-upstream-errors, x-partial, x-upload-route, server-timing")
+}
+
+// setReplicationHeaders reports how many upstream replicas of a blob are known
+// alongside the configured long-term replication target for that blob
+func (h *BlossomHandler) setReplicationHeaders(w http.ResponseWriter, hash string, replicas int) {
+	w.Header().Set("X-Replicas", strconv.Itoa(replicas))
+	w.Header().Set("X-Replication-Target", strconv.Itoa(h.upstreamManager.ReplicationTarget(hash)))
+}
+
+// setAltLocationHeaders advertises other replicas of a blob on a download redirect
+// so capable clients can fail over to another mirror without asking the proxy again
+// Each replica is sent as a Link header (rel="duplicate", RFC 6249) and the full list
+// is repeated in X-Alt-Locations as a comma-separated list
+func (h *BlossomHandler) setAltLocationHeaders(ctx context.Context, w http.ResponseWriter, servers []string, selectedServer string, path string) {
+	if h.config.Server.DisableAltLocations || h.config.Server.MaxAltLocations <= 0 {
+		return
+	}
+
+	altURLs := make([]string, 0, h.config.Server.MaxAltLocations)
+	for _, serverURL := range servers {
+		if len(altURLs) >= h.config.Server.MaxAltLocations {
+			break
+		}
+		if serverURL == selectedServer || h.upstreamManager.IsInMaintenance(serverURL) {
+			continue
+		}
+		altURL := fmt.Sprintf("%s/%s", strings.TrimRight(serverURL, "/"), path)
+		altURLs = append(altURLs, altURL)
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"duplicate\"", altURL))
+	}
+
+	if len(altURLs) > 0 {
+		w.Header().Set("X-Alt-Locations", strings.Join(altURLs, ", "))
+		h.verbose.Debugf(ctx, "setAltLocationHeaders: advertising %d alternate locations: %v", len(altURLs), altURLs)
+	}
 }
 
 // calculateTimeout calculates the upload/mirror timeout based on the expiration timestamp
 // in the authorization event. It clamps the timeout between min and max config values.
-func (h *BlossomHandler) calculateTimeout(authEvent *nostr.Event, logPrefix string) time.Duration {
+func (h *BlossomHandler) calculateTimeout(ctx context.Context, authEvent *nostr.Event, logPrefix string) time.Duration {
 	minTimeout := h.config.Server.MinUploadTimeout
 	maxTimeout := h.config.Server.MaxUploadTimeout
 	timeout := minTimeout // Default timeout (also used as minimum)
@@ -198,28 +458,20 @@ func (h *BlossomHandler) calculateTimeout(authEvent *nostr.Event, logPrefix stri
 					if calculatedTimeout > 0 {
 						if calculatedTimeout < minTimeout {
 							timeout = minTimeout
-							if h.verbose {
-								log.Printf("[DEBUG] %s: calculated timeout %v is below minimum %v, using minimum", logPrefix, calculatedTimeout, minTimeout)
-							}
+							h.verbose.Debugf(ctx, "%s: calculated timeout %v is below minimum %v, using minimum", logPrefix, calculatedTimeout, minTimeout)
 						} else if calculatedTimeout > maxTimeout {
 							timeout = maxTimeout
-							if h.verbose {
-								log.Printf("[DEBUG] %s: calculated timeout %v exceeds maximum %v, capped at maximum (expires at %v)", logPrefix, calculatedTimeout, maxTimeout, expirationTime)
-							}
+							h.verbose.Debugf(ctx, "%s: calculated timeout %v exceeds maximum %v, capped at maximum (expires at %v)", logPrefix, calculatedTimeout, maxTimeout, expirationTime)
 						} else {
 							timeout = calculatedTimeout
-							if h.verbose {
-								log.Printf("[DEBUG] %s: using calculated timeout from expiration: %v (expires at %v, clamped between %v and %v)", logPrefix, timeout, expirationTime, minTimeout, maxTimeout)
-							}
+							h.verbose.Debugf(ctx, "%s: using calculated timeout from expiration: %v (expires at %v, clamped between %v and %v)", logPrefix, timeout, expirationTime, minTimeout, maxTimeout)
 						}
 					} else {
-						if h.verbose {
-							log.Printf("[DEBUG] %s: expiration timestamp %v is in the past or too soon, using minimum timeout: %v", logPrefix, expirationTime, timeout)
-						}
+						h.verbose.Debugf(ctx, "%s: expiration timestamp %v is in the past or too soon, using minimum timeout: %v", logPrefix, expirationTime, timeout)
 					}
 					break
-				} else if h.verbose {
-					log.Printf("[DEBUG] %s: failed to parse expiration timestamp '%s': %v", logPrefix, tag[1], err)
+				} else if h.verbose.Enabled() {
+					h.verbose.Debugf(ctx, "%s: failed to parse expiration timestamp '%s': %v", logPrefix, tag[1], err)
 				}
 			}
 		}
@@ -230,11 +482,14 @@ func (h *BlossomHandler) calculateTimeout(authEvent *nostr.Event, logPrefix stri
 // HandleUpload handles PUT /upload and HEAD /upload requests
 // HEAD /upload implements BUD-06: Upload requirements (preflight check)
 func (h *BlossomHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
-	if h.verbose {
-		log.Printf("[DEBUG] HandleUpload: received %s request from %s", r.Method, r.RemoteAddr)
-		log.Printf("[DEBUG] HandleUpload: path=%s, content-type=%s, content-length=%s", r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Content-Length"))
-		log.Printf("[DEBUG] HandleUpload: headers=%v", r.Header)
-	}
+	h.verbose.Debugf(r.Context(), "HandleUpload: received %s request from %s", r.Method, r.RemoteAddr)
+	h.verbose.Debugf(r.Context(), "HandleUpload: path=%s, content-type=%s, content-length=%s", r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Content-Length"))
+	h.verbose.Debugf(r.Context(), "HandleUpload: headers=%v", r.Header)
+	w, finishMetrics := h.trackRequest(w, r, "upload")
+	defer finishMetrics()
+	r, finishSlowTrace := h.traceSlowRequests(r)
+	defer finishSlowTrace("HandleUpload")
+	w, timing := h.startServerTiming(w)
 
 	// Handle HEAD /upload (BUD-06: Upload requirements preflight check)
 	if r.Method == http.MethodHead {
@@ -251,44 +506,42 @@ func (h *BlossomHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method != http.MethodPut {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleUpload: method not allowed: %s", r.Method)
-		}
+		h.verbose.Debugf(r.Context(), "HandleUpload: method not allowed: %s", r.Method)
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Validate authentication if pubkeys are configured
-	// Also parse the event to extract expiration timestamp for timeout calculation
-	var authEvent *nostr.Event = nil
-	if len(h.allowedPubkeys) > 0 {
-		_, err := auth.ValidateAuth(r, "upload", h.allowedPubkeys, h.verbose)
-		if err != nil {
-			if authErr, ok := err.(*auth.AuthError); ok {
-				if h.verbose {
-					log.Printf("[DEBUG] HandleUpload: authentication failed: %s", authErr.Reason)
-				}
-				w.Header().Set("X-Reason", authErr.Reason)
-				http.Error(w, authErr.Reason, authErr.Code)
-				return
-			}
-			if h.verbose {
-				log.Printf("[DEBUG] HandleUpload: authentication error: %v", err)
-			}
-			http.Error(w, fmt.Sprintf("Authentication error: %v", err), http.StatusUnauthorized)
-			return
-		}
-		// Parse the event to extract expiration timestamp for timeout calculation
-		authHeader := r.Header.Get("Authorization")
-		if authHeader != "" {
-			parsedEvent, err := auth.ParseAuthorizationHeader(authHeader)
-			if err == nil {
-				authEvent = parsedEvent
-				if h.verbose && authEvent != nil {
-					log.Printf("[DEBUG] HandleUpload: parsed authorization event with %d tags", len(authEvent.Tags))
-				}
-			}
-		}
+	// Reject new uploads while overloaded; downloads keep being served
+	if h.shedLoad(w, r, "HandleUpload") {
+		return
+	}
+
+	// Reject blobs over max_upload_bytes up front, and stop reading bodies that grow past it
+	uploadSize := r.ContentLength
+	if uploadSize < 0 {
+		uploadSize = announcedSize(r)
+	}
+	if h.rejectOversizedUpload(w, r, max(r.ContentLength, announcedSize(r)), "HandleUpload") {
+		return
+	}
+	h.limitUploadBody(w, r)
+
+	// Validate authentication if required for this endpoint (see auth_endpoints)
+	// The event's expiration timestamp is also used for the timeout calculation
+	authEvent, ok := h.authorize(w, r, "upload", "HandleUpload")
+	if !ok {
+		return
+	}
+
+	// Enforce the pubkey's upload quota (upload_quota_bytes/upload_quota_blobs)
+	if authEvent != nil && h.checkUploadQuota(w, r, authEvent.PubKey, uploadSize, "HandleUpload") {
+		return
+	}
+
+	// Refuse blocked blobs announced up front; the body is checked against the blocklist
+	// as it ends, before upstreams receive all of it
+	if h.rejectBlocked(w, r, declaredHash(r), "HandleUpload") {
+		return
 	}
 
 	// Copy headers from original request (for Nostr event, etc.)
@@ -305,6 +558,33 @@ func (h *BlossomHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 			headers[k] = v[0]
 		}
 	}
+	h.applyClientIPHeaders(headers, r)
+
+	// Dry run: validate and preflight only, without transferring the body
+	if isDryRun(r) {
+		h.handleDryRunUpload(w, r, headers)
+		return
+	}
+
+	// The body is verified against an X-SHA-256 header or trailer as it ends, and against
+	// the authorization event's x tags if it has any (an announced hash is checked now)
+	if !validChecksumHeader(w, r, "HandleUpload") {
+		return
+	}
+	allowedHashes := authorizedHashes(authEvent)
+	if !authorizedChecksumHeader(w, r, allowedHashes, "HandleUpload") {
+		return
+	}
+
+	// A retry of an upload that just completed gets the same response without re-sending the blob
+	if hash := declaredHash(r); hash != "" && h.replayRecentUpload(w, r, authEvent, hash) {
+		return
+	}
+	// The body must also have the size announced in X-Content-Length or in the preflight
+	expectedSize, ok := h.expectedUploadSize(w, r, declaredHash(r), "HandleUpload")
+	if !ok {
+		return
+	}
 
 	// Extract Content-Length from original request
 	// This is needed because when using io.Reader with http.NewRequest,
@@ -314,29 +594,47 @@ func (h *BlossomHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	if clStr := r.Header.Get("Content-Length"); clStr != "" {
 		if cl, err := strconv.ParseInt(clStr, 10, 64); err == nil {
 			contentLength = cl
-			if h.verbose {
-				log.Printf("[DEBUG] HandleUpload: extracted Content-Length from request: %d", contentLength)
-			}
-		} else if h.verbose {
-			log.Printf("[DEBUG] HandleUpload: failed to parse Content-Length '%s': %v", clStr, err)
+			h.verbose.Debugf(r.Context(), "HandleUpload: extracted Content-Length from request: %d", contentLength)
+		} else if h.verbose.Enabled() {
+			h.verbose.Debugf(r.Context(), "HandleUpload: failed to parse Content-Length '%s': %v", clStr, err)
 		}
 	}
 
 	// Calculate upload timeout from expiration timestamp in authorization event
 	// This ensures uploads complete before the auth header expires
 	// Timeout is clamped between min_upload_timeout (minimum) and max_upload_timeout (maximum)
-	uploadTimeout := h.calculateTimeout(authEvent, "HandleUpload")
+	uploadTimeout := h.calculateTimeout(r.Context(), authEvent, "HandleUpload")
+	uploadTimeout = h.requestedTimeout(r, uploadTimeout, "HandleUpload")
+
+	h.verbose.Debugf(r.Context(), "HandleUpload: forwarding headers: %v", headers)
+	h.verbose.Debugf(r.Context(), "HandleUpload: using upload timeout: %v", uploadTimeout)
+
+	// Route the upload to a shard if sharding is configured and the client announced the hash,
+	// and by content type and size if content or size routes are configured
+	expectedHash := declaredHash(r)
+	targets := h.uploadTargets(w, expectedHash, uploadContentType(r), uploadSize)
+	targetURLs := h.upstreamManager.TargetServerURLs(targets)
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleUpload: forwarding headers: %v", headers)
-		log.Printf("[DEBUG] HandleUpload: using upload timeout: %v", uploadTimeout)
+	// With no healthy upstream, keep the blob locally and upload it once they recover
+	if h.queued != nil && h.noHealthyUpstreams("upload") {
+		h.queueUpload(w, r, targetURLs, headers, expectedHash, allowedHashes, expectedSize)
+		return
 	}
 
 	// Stream upload to upstream servers while calculating hash in parallel
 	// This avoids reading the entire file into memory and starting uploads earlier
 	// to prevent auth header expiration on large files
-	hashWriter := sha256.New()
-	teeReader := io.TeeReader(r.Body, hashWriter)
+	hashWriter := &timedHash{Hash: sha256.New()}
+	uploadedBytes := &byteCounter{}
+	teeReader := io.TeeReader(r.Body, io.MultiWriter(hashWriter, uploadedBytes))
+
+	// Keep a local copy of the body so GET/HEAD for this blob can be answered
+	// while upstream servers are still processing it
+	spoolWriter := h.spool.Begin(r.Header.Get("Content-Type"), contentLength)
+	if spoolWriter != nil {
+		teeReader = io.TeeReader(teeReader, spoolWriter)
+	}
+	checksum := newChecksumReader(r, teeReader, hashWriter, allowedHashes, h.blockedHashes(), expectedSize)
 
 	// Ensure body is closed after streaming completes
 	defer func() {
@@ -352,7 +650,12 @@ func (h *BlossomHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	// IMPORTANT: teeReader writes to hashWriter as it reads from r.Body,
 	// so the hash is calculated during the streaming process
 	// Pass the calculated timeout based on expiration timestamp
-	successfulServers, err := h.upstreamManager.UploadParallelStreaming(r.Context(), teeReader, r.Header.Get("Content-Type"), contentLength, headers, uploadTimeout)
+	fanoutStart := time.Now()
+	successfulServers, err := h.upstreamManager.UploadParallelStreamingTo(r.Context(), targets, checksum, r.Header.Get("Content-Type"), contentLength, headers, uploadTimeout)
+	timing.since("fanout", fmt.Sprintf("%d servers", len(targetURLs)), fanoutStart)
+	timing.add("hash", "", hashWriter.spent)
+	h.addSlowestUpstream(timing, r, successfulServers)
+	h.stats.RecordReceived(uploadedBytes.n)
 
 	// IMPORTANT: Do NOT drain r.Body again here!
 	// teeReader has already consumed r.Body completely when UploadParallelStreaming returns.
@@ -366,78 +669,122 @@ func (h *BlossomHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	hash := hashWriter.Sum(nil)
 	hashStr := hex.EncodeToString(hash)
 
-	if h.verbose {
+	if h.verbose.Enabled() {
 		// Debug: log the hash length to verify it's complete
-		log.Printf("[DEBUG] HandleUpload: hash length: %d bytes, hex string length: %d chars", len(hash), len(hashStr))
+		h.verbose.Debugf(r.Context(), "HandleUpload: hash length: %d bytes, hex string length: %d chars", len(hash), len(hashStr))
 	}
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleUpload: calculated hash: %s", hashStr)
+	h.verbose.Debugf(r.Context(), "HandleUpload: calculated hash: %s", hashStr)
+	// The upstream uploads were aborted before receiving the whole body
+	if mismatch := checksum.mismatch(); mismatch != nil {
+		spoolWriter.Finish(hashStr, false)
+		logging.Warnf(r.Context(), "HandleUpload: rejecting upload from %s: %v", r.RemoteAddr, mismatch)
+		rejectMismatch(w, r, mismatch)
+		return
+	}
+	if expectedHash != "" && expectedHash != hashStr {
+		logging.Warnf(r.Context(), "HandleUpload: declared hash %s does not match calculated hash %s", expectedHash, hashStr)
 	}
 
+	// Cross-check the received byte count against announced sizes and against the size
+	// each upstream reports; servers reporting a different size are not counted
+	if err == nil {
+		successfulServers = h.excludeSizeMismatches(r.Context(), successfulServers, uploadedBytes.n, "HandleUpload")
+		if minServers := h.upstreamManager.MinServersFor(targets); len(successfulServers) < minServers {
+			err = &upstream.UploadError{
+				StatusCode: http.StatusBadGateway,
+				Message:    fmt.Sprintf("only %d servers stored the blob with the correct size, need at least %d", len(successfulServers), minServers),
+			}
+		}
+	}
+	spoolWriter.Finish(hashStr, err == nil)
+
 	// Track stats for all attempted servers (successful and failed)
-	// Get all upstream server URLs to track failures
-	allServerURLs := h.upstreamManager.GetServerURLs()
 	successfulURLs := make(map[string]bool)
 	for _, srv := range successfulServers {
 		successfulURLs[srv.ServerURL] = true
 		h.stats.RecordSuccess(srv.ServerURL, "upload")
 	}
-	// Track failures for servers that didn't succeed
-	for _, serverURL := range allServerURLs {
-		if !successfulURLs[serverURL] {
-			h.stats.RecordFailure(serverURL, "upload")
+	// Track failures for targeted servers that didn't succeed
+	// If the client aborted the upload or sent more than max_upload_bytes, upstreams are not
+	// at fault and nothing is recorded
+	var tooLarge *http.MaxBytesError
+	oversized := errors.As(err, &tooLarge)
+	clientAborted := err != nil && !oversized && (errors.Is(err, upstream.ErrBodyAborted) || r.Context().Err() != nil)
+	if oversized {
+		logging.Warnf(r.Context(), "HandleUpload: rejecting upload from %s: body exceeds max_upload_bytes (%d)", r.RemoteAddr, tooLarge.Limit)
+	} else if clientAborted {
+		markClientAbort(w)
+		h.activity.Record(activity.Event{Operation: "upload", Size: uploadedBytes.n, Outcome: activity.OutcomeAborted, Targets: len(targetURLs)})
+		logging.Warnf(r.Context(), "HandleUpload: upload aborted by client %s: %v", r.RemoteAddr, err)
+	} else {
+		for _, serverURL := range targetURLs {
+			if !successfulURLs[serverURL] {
+				h.stats.RecordFailure(serverURL, "upload")
+			}
 		}
 	}
 
 	if err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleUpload: upload failed: %v", err)
+		h.verbose.Debugf(r.Context(), "HandleUpload: upload failed: %v", err)
+		if !clientAborted {
+			h.recordActivity("upload", hashStr, uploadedBytes.n, len(successfulServers), len(targetURLs), true)
+		}
+
+		if oversized {
+			h.writeTooLarge(w, r)
+			return
+		}
+
+		// Every upstream is down: say so clearly instead of passing through an arbitrary error
+		if !clientAborted && !isClientError(err) && h.noHealthyUpstreams("upload") {
+			h.writeDegraded(w, r, "upload", "HandleUpload")
+			return
 		}
 
 		// Check if error has an HTTP status code to pass through
 		if uploadErr, ok := err.(*upstream.UploadError); ok {
-			if h.verbose {
-				log.Printf("[DEBUG] HandleUpload: passing through upstream status code %d", uploadErr.StatusCode)
-			}
+			h.verbose.Debugf(r.Context(), "HandleUpload: passing through upstream status code %d", uploadErr.StatusCode)
 			w.Header().Set("Content-Type", "text/plain")
-			http.Error(w, uploadErr.Error(), uploadErr.StatusCode)
+			http.Error(w, h.publicError(r.Context(), uploadErr, "HandleUpload"), uploadErr.StatusCode)
 			return
 		}
 
 		// Default to 500 for other errors
 		w.Header().Set("Content-Type", "text/plain")
-		http.Error(w, fmt.Sprintf("Upload failed: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Upload failed: %s", h.publicError(r.Context(), err, "HandleUpload")), http.StatusInternalServerError)
 		return
 	}
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleUpload: upload successful to %d servers", len(successfulServers))
+	h.verbose.Debugf(r.Context(), "HandleUpload: upload successful to %d servers", len(successfulServers))
+	h.recordActivity("upload", hashStr, uploadedBytes.n, len(successfulServers), len(targetURLs), false)
+	if authEvent != nil {
+		h.quotas.Record(authEvent.PubKey, uploadedBytes.n)
 	}
 
 	// Do not cache successful upload targets for GET/HEAD: some upstreams accept PUT before the blob is readable.
+	h.trackNewReplicas(r.Context(), hashStr, successfulServers, "HandleUpload")
+
+	// Retry targets that missed the blob in the background, mirroring from a server that has it
+	h.journalUploadRetries(r.Context(), hashStr, targetURLs, successfulServers, headers, "HandleUpload")
 
 	// Select a server to return in the response
+	selectStart := time.Now()
 	selectedServer, err := h.upstreamManager.SelectServer(successfulServers)
+	timing.since("select", "", selectStart)
 	if err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleUpload: failed to select server: %v", err)
-		}
+		h.verbose.Debugf(r.Context(), "HandleUpload: failed to select server: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to select server: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleUpload: selected server for response: %s", selectedServer.ServerURL)
-		log.Printf("[DEBUG] HandleUpload: using response body from upstream: %s", string(selectedServer.ResponseBody))
-	}
+	h.verbose.Debugf(r.Context(), "HandleUpload: selected server for response: %s", selectedServer.ServerURL)
+	h.verbose.Debugf(r.Context(), "HandleUpload: using response body from upstream: %s", string(selectedServer.ResponseBody))
 
 	// Parse the selected server's response
 	var responseData map[string]interface{}
 	if err := json.Unmarshal(selectedServer.ResponseBody, &responseData); err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleUpload: failed to parse selected server response: %v", err)
-		}
+		h.verbose.Debugf(r.Context(), "HandleUpload: failed to parse selected server response: %v", err)
 		// If parsing fails, return original response
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -445,6 +792,19 @@ func (h *BlossomHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Populate size, type and uploaded from the most complete upstream answers
+	h.normalizeResponseDescriptor(r.Context(), responseData, successfulServers, selectedServer.ServerURL, "HandleUpload")
+	// Fall back to what the proxy itself observed for fields no upstream reported
+	if _, ok := responseData["size"]; !ok {
+		responseData["size"] = uploadedBytes.n
+	}
+	if _, ok := responseData["type"]; !ok && r.Header.Get("Content-Type") != "" {
+		responseData["type"] = r.Header.Get("Content-Type")
+	}
+	if _, ok := responseData["uploaded"]; !ok {
+		responseData["uploaded"] = time.Now().Unix()
+	}
+
 	// Collect all URLs from all successful servers and add as BUD-08 tags
 	// Also add NIP-94 tags: ["x", "<hash>"] and ["m", "<mime-type>"]
 
@@ -504,36 +864,24 @@ func (h *BlossomHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 		tags = append(tags, []interface{}{"m", contentType})
 	}
 
-	// Collect URLs from all successful servers
-	for _, srv := range successfulServers {
-		var srvData map[string]interface{}
-		if err := json.Unmarshal(srv.ResponseBody, &srvData); err != nil {
-			if h.verbose {
-				log.Printf("[DEBUG] HandleUpload: failed to parse server response from %s: %v", srv.ServerURL, err)
-			}
-			continue
-		}
-		if urlVal, ok := srvData["url"].(string); ok && urlVal != "" {
-			// Add URL tag if not already present (check exact duplicate)
-			if !hasTag("url", urlVal) {
-				tags = append(tags, []interface{}{"url", urlVal})
-			}
-		}
-	}
+	// Collect URLs from all successful servers as BUD-08 url tags (limited, ordered and
+	// filtered according to the url_tags_* configuration)
+	tags = h.applyURLTags(tags, responseData, successfulServers, selectedServer.ServerURL, hashStr, contentType, r, "HandleUpload")
+
+	// Add NIP-94 size tag ["size", "<bytes>"] if not present
+	tags = upstream.AddSizeTag(tags, responseData)
 
 	// Update nip94 in response
 	responseData["nip94"] = tags
 
-	// If redirect strategy is "local", set the response URL to local URL
-	if h.config.Server.RedirectStrategy == "local" {
+	// With the "local" strategy or prefer_base_url mode, set the response URL to local URL
+	if h.useLocalResponseURL() {
 		localURL := h.constructLocalURL(hashStr, contentType, r)
 		responseData["url"] = localURL
-		if h.verbose {
-			log.Printf("[DEBUG] HandleUpload: using local URL for response: %s", localURL)
-		}
+		h.verbose.Debugf(r.Context(), "HandleUpload: using local URL for response: %s", localURL)
 	}
 
-	if h.verbose {
+	if h.verbose.Enabled() {
 		// Count url tags for logging
 		urlTagCount := 0
 		for _, tag := range tags {
@@ -543,15 +891,13 @@ func (h *BlossomHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
-		log.Printf("[DEBUG] HandleUpload: added tags - %d url tags (BUD-08), NIP-94 tags for hash and mime type", urlTagCount)
+		h.verbose.Debugf(r.Context(), "HandleUpload: added tags - %d url tags (BUD-08), NIP-94 tags for hash and mime type", urlTagCount)
 	}
 
 	// Marshal and return the modified response
 	responseJSON, err := json.Marshal(responseData)
 	if err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleUpload: failed to marshal response: %v", err)
-		}
+		h.verbose.Debugf(r.Context(), "HandleUpload: failed to marshal response: %v", err)
 		// Fallback to original response
 		setCORSHeaders(w, r)
 		w.Header().Set("Content-Type", "application/json")
@@ -560,7 +906,12 @@ func (h *BlossomHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if pubkey, bound := h.uploaderPubkey(r, authEvent, hashStr); bound {
+		h.recentUploads.Record(hashStr, pubkey, responseJSON, len(successfulServers))
+	}
+
 	setCORSHeaders(w, r)
+	h.setReplicationHeaders(w, hashStr, len(successfulServers))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(responseJSON)
@@ -568,67 +919,52 @@ func (h *BlossomHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 
 // HandleMirror handles PUT /mirror requests (BUD-04: Mirroring blobs)
 func (h *BlossomHandler) HandleMirror(w http.ResponseWriter, r *http.Request) {
-	if h.verbose {
-		log.Printf("[DEBUG] HandleMirror: received %s request from %s", r.Method, r.RemoteAddr)
-		log.Printf("[DEBUG] HandleMirror: path=%s, content-type=%s, content-length=%s", r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Content-Length"))
-		log.Printf("[DEBUG] HandleMirror: headers=%v", r.Header)
-	}
+	h.verbose.Debugf(r.Context(), "HandleMirror: received %s request from %s", r.Method, r.RemoteAddr)
+	h.verbose.Debugf(r.Context(), "HandleMirror: path=%s, content-type=%s, content-length=%s", r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Content-Length"))
+	h.verbose.Debugf(r.Context(), "HandleMirror: headers=%v", r.Header)
+	w, finishMetrics := h.trackRequest(w, r, "mirror")
+	defer finishMetrics()
+	r, finishSlowTrace := h.traceSlowRequests(r)
+	defer finishSlowTrace("HandleMirror")
 
 	if r.Method != http.MethodPut {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleMirror: method not allowed: %s", r.Method)
-		}
+		h.verbose.Debugf(r.Context(), "HandleMirror: method not allowed: %s", r.Method)
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Validate authentication if pubkeys are configured
-	// Also parse the event to extract expiration timestamp for timeout calculation
-	var authEvent *nostr.Event = nil
-	if len(h.allowedPubkeys) > 0 {
-		_, err := auth.ValidateAuth(r, "upload", h.allowedPubkeys, h.verbose)
-		if err != nil {
-			if authErr, ok := err.(*auth.AuthError); ok {
-				if h.verbose {
-					log.Printf("[DEBUG] HandleMirror: authentication failed: %s", authErr.Reason)
-				}
-				w.Header().Set("X-Reason", authErr.Reason)
-				http.Error(w, authErr.Reason, authErr.Code)
-				return
-			}
-			if h.verbose {
-				log.Printf("[DEBUG] HandleMirror: authentication error: %v", err)
-			}
-			http.Error(w, fmt.Sprintf("Authentication error: %v", err), http.StatusUnauthorized)
-			return
-		}
-		// Parse the event to extract expiration timestamp for timeout calculation
-		authHeader := r.Header.Get("Authorization")
-		if authHeader != "" {
-			parsedEvent, err := auth.ParseAuthorizationHeader(authHeader)
-			if err == nil {
-				authEvent = parsedEvent
-				if h.verbose && authEvent != nil {
-					log.Printf("[DEBUG] HandleMirror: parsed authorization event with %d tags", len(authEvent.Tags))
-				}
-			}
-		}
+	if h.shedLoad(w, r, "HandleMirror") {
+		return
 	}
 
-	// Read the request body
-	bodyBytes, err := io.ReadAll(r.Body)
+	// Reject blobs announced over max_upload_bytes (X-Content-Length) up front; the size
+	// reported by the source is checked once the mirror request is read
+	if h.rejectOversizedUpload(w, r, announcedSize(r), "HandleMirror") {
+		return
+	}
+
+	// Validate authentication if required for this endpoint (see auth_endpoints)
+	// The event's expiration timestamp is also used for the timeout calculation
+	authEvent, ok := h.authorize(w, r, "mirror", "HandleMirror")
+	if !ok {
+		return
+	}
+
+	// Read the request body (a small JSON document with the URL of the blob)
+	bodyBytes, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMirrorBodyBytes))
 	if err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleMirror: failed to read body: %v", err)
+		h.verbose.Debugf(r.Context(), "HandleMirror: failed to read body: %v", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Mirror request body too large", http.StatusRequestEntityTooLarge)
+			return
 		}
 		http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
 		return
 	}
 	defer r.Body.Close()
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleMirror: read %d bytes from request body", len(bodyBytes))
-	}
+	h.verbose.Debugf(r.Context(), "HandleMirror: read %d bytes from request body", len(bodyBytes))
 
 	// Copy headers from original request (for Nostr event, etc.)
 	headers := make(map[string]string)
@@ -643,20 +979,47 @@ func (h *BlossomHandler) HandleMirror(w http.ResponseWriter, r *http.Request) {
 			headers[k] = v[0]
 		}
 	}
+	h.applyClientIPHeaders(headers, r)
 
 	// Calculate mirror timeout from expiration timestamp in authorization event
 	// This ensures mirrors complete before the auth header expires
 	// Timeout is clamped between min_upload_timeout (minimum) and max_upload_timeout (maximum)
-	mirrorTimeout := h.calculateTimeout(authEvent, "HandleMirror")
+	mirrorTimeout := h.calculateTimeout(r.Context(), authEvent, "HandleMirror")
+	mirrorTimeout = h.requestedTimeout(r, mirrorTimeout, "HandleMirror")
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleMirror: forwarding headers: %v", headers)
-		log.Printf("[DEBUG] HandleMirror: using mirror timeout: %v", mirrorTimeout)
+	h.verbose.Debugf(r.Context(), "HandleMirror: forwarding headers: %v", headers)
+	h.verbose.Debugf(r.Context(), "HandleMirror: using mirror timeout: %v", mirrorTimeout)
+
+	// Route the mirror to a shard if sharding is configured and the blob hash is known, by
+	// content type (announced in X-Content-Type, or guessed from the URL's extension), and by
+	// size (reported by the source with max_upload_bytes, or announced in X-Content-Length)
+	mirrorHash := hashFromMirrorBody(bodyBytes)
+	if mirrorHash == "" {
+		mirrorHash = declaredHash(r)
+	}
+	if h.rejectBlocked(w, r, mirrorHash, "HandleMirror") {
+		return
+	}
+	mirrorSize, rejected := h.rejectOversizedMirror(w, r, bodyBytes, "HandleMirror")
+	if rejected {
+		return
+	}
+	if mirrorSize < 0 {
+		mirrorSize = announcedSize(r)
+	}
+	mirrorType := r.Header.Get("X-Content-Type")
+	if mirrorType == "" {
+		mirrorType = contentTypeFromMirrorBody(bodyBytes)
+	}
+	targets := h.uploadTargets(w, mirrorHash, mirrorType, mirrorSize)
+	targetURLs := make(map[string]bool)
+	for _, serverURL := range h.upstreamManager.TargetServerURLs(targets) {
+		targetURLs[serverURL] = true
 	}
 
 	// Forward mirror request to upstream servers
 	bodyReader := bytes.NewReader(bodyBytes)
-	successfulServers, err := h.upstreamManager.MirrorParallel(r.Context(), bodyReader, r.Header.Get("Content-Type"), headers, mirrorTimeout)
+	successfulServers, err := h.upstreamManager.MirrorParallelTo(r.Context(), targets, mirrorHash, bodyReader, r.Header.Get("Content-Type"), headers, mirrorTimeout)
 
 	// Track stats for mirror operations
 	// Get all mirror-capable servers (only these are attempted by MirrorParallel)
@@ -668,58 +1031,70 @@ func (h *BlossomHandler) HandleMirror(w http.ResponseWriter, r *http.Request) {
 	}
 	// Track failures for mirror-capable servers that didn't succeed
 	// Only track failures for servers that actually attempted the mirror
+	attempted := 0
 	for _, serverURL := range mirrorCapableServers {
+		if !targetURLs[serverURL] {
+			continue
+		}
+		attempted++
 		if !successfulURLs[serverURL] {
 			h.stats.RecordFailure(serverURL, "mirror")
 		}
 	}
+	h.recordActivity("mirror", mirrorHash, -1, len(successfulServers), attempted, err != nil)
 
 	if err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleMirror: mirror request failed: %v", err)
+		h.verbose.Debugf(r.Context(), "HandleMirror: mirror request failed: %v", err)
+
+		if !isClientError(err) && h.noHealthyUpstreams("mirror") {
+			h.writeDegraded(w, r, "mirror", "HandleMirror")
+			return
 		}
 
 		// Check if error has an HTTP status code to pass through
 		if uploadErr, ok := err.(*upstream.UploadError); ok {
-			if h.verbose {
-				log.Printf("[DEBUG] HandleMirror: passing through upstream status code %d", uploadErr.StatusCode)
-			}
+			h.verbose.Debugf(r.Context(), "HandleMirror: passing through upstream status code %d", uploadErr.StatusCode)
 			w.Header().Set("Content-Type", "text/plain")
-			http.Error(w, uploadErr.Error(), uploadErr.StatusCode)
+			http.Error(w, h.publicError(r.Context(), uploadErr, "HandleMirror"), uploadErr.StatusCode)
 			return
 		}
 
 		// Default to 500 for other errors
 		w.Header().Set("Content-Type", "text/plain")
-		http.Error(w, fmt.Sprintf("Mirror request failed: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Mirror request failed: %s", h.publicError(r.Context(), err, "HandleMirror")), http.StatusInternalServerError)
 		return
 	}
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleMirror: mirror request successful to %d servers", len(successfulServers))
+	h.verbose.Debugf(r.Context(), "HandleMirror: mirror request successful to %d servers", len(successfulServers))
+
+	if mirrorHash != "" {
+		h.trackNewReplicas(r.Context(), mirrorHash, successfulServers, "HandleMirror")
+
+		// Retry the mirror in the background on targets that failed
+		missing := make([]string, 0)
+		for _, serverURL := range mirrorCapableServers {
+			if !successfulURLs[serverURL] && targetURLs[serverURL] {
+				missing = append(missing, serverURL)
+			}
+		}
+		h.journalMirrors(r.Context(), mirrorHash, missing, bodyBytes, headers, "HandleMirror")
 	}
 
 	// Select a server to return in the response
 	selectedServer, err := h.upstreamManager.SelectServer(successfulServers)
 	if err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleMirror: failed to select server: %v", err)
-		}
+		h.verbose.Debugf(r.Context(), "HandleMirror: failed to select server: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to select server: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleMirror: selected server for response: %s", selectedServer.ServerURL)
-		log.Printf("[DEBUG] HandleMirror: using response body from upstream: %s", string(selectedServer.ResponseBody))
-	}
+	h.verbose.Debugf(r.Context(), "HandleMirror: selected server for response: %s", selectedServer.ServerURL)
+	h.verbose.Debugf(r.Context(), "HandleMirror: using response body from upstream: %s", string(selectedServer.ResponseBody))
 
 	// Parse the selected server's response
 	var responseData map[string]interface{}
 	if err := json.Unmarshal(selectedServer.ResponseBody, &responseData); err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleMirror: failed to parse selected server response: %v", err)
-		}
+		h.verbose.Debugf(r.Context(), "HandleMirror: failed to parse selected server response: %v", err)
 		// If parsing fails, return original response
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -727,6 +1102,9 @@ func (h *BlossomHandler) HandleMirror(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Populate size, type and uploaded from the most complete upstream answers
+	h.normalizeResponseDescriptor(r.Context(), responseData, successfulServers, selectedServer.ServerURL, "HandleMirror")
+
 	// Collect all URLs from all successful servers and add as BUD-08 tags
 	// Also add NIP-94 tags: ["x", "<hash>"] and ["m", "<mime-type>"]
 
@@ -790,28 +1168,18 @@ func (h *BlossomHandler) HandleMirror(w http.ResponseWriter, r *http.Request) {
 		tags = append(tags, []interface{}{"m", mimeType})
 	}
 
-	// Collect URLs from all successful servers
-	for _, srv := range successfulServers {
-		var srvData map[string]interface{}
-		if err := json.Unmarshal(srv.ResponseBody, &srvData); err != nil {
-			if h.verbose {
-				log.Printf("[DEBUG] HandleMirror: failed to parse server response from %s: %v", srv.ServerURL, err)
-			}
-			continue
-		}
-		if urlVal, ok := srvData["url"].(string); ok && urlVal != "" {
-			// Add URL tag if not already present (check exact duplicate)
-			if !hasTag("url", urlVal) {
-				tags = append(tags, []interface{}{"url", urlVal})
-			}
-		}
-	}
+	// Collect URLs from all successful servers as BUD-08 url tags (limited, ordered and
+	// filtered according to the url_tags_* configuration)
+	tags = h.applyURLTags(tags, responseData, successfulServers, selectedServer.ServerURL, hashVal, mimeType, r, "HandleMirror")
+
+	// Add NIP-94 size tag ["size", "<bytes>"] if not present
+	tags = upstream.AddSizeTag(tags, responseData)
 
 	// Update nip94 in response
 	responseData["nip94"] = tags
 
-	// If redirect strategy is "local", set the response URL to local URL
-	if h.config.Server.RedirectStrategy == "local" {
+	// With the "local" strategy or prefer_base_url mode, set the response URL to local URL
+	if h.useLocalResponseURL() {
 		// Get hash from response
 		var hashVal string
 		if hashStr, ok := responseData["hash"].(string); ok && hashStr != "" {
@@ -823,13 +1191,11 @@ func (h *BlossomHandler) HandleMirror(w http.ResponseWriter, r *http.Request) {
 		if hashVal != "" {
 			localURL := h.constructLocalURL(hashVal, mimeType, r)
 			responseData["url"] = localURL
-			if h.verbose {
-				log.Printf("[DEBUG] HandleMirror: using local URL for response: %s", localURL)
-			}
+			h.verbose.Debugf(r.Context(), "HandleMirror: using local URL for response: %s", localURL)
 		}
 	}
 
-	if h.verbose {
+	if h.verbose.Enabled() {
 		// Count url tags for logging
 		urlTagCount := 0
 		for _, tag := range tags {
@@ -839,15 +1205,13 @@ func (h *BlossomHandler) HandleMirror(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
-		log.Printf("[DEBUG] HandleMirror: added tags - %d url tags (BUD-08), NIP-94 tags for hash and mime type", urlTagCount)
+		h.verbose.Debugf(r.Context(), "HandleMirror: added tags - %d url tags (BUD-08), NIP-94 tags for hash and mime type", urlTagCount)
 	}
 
 	// Marshal and return the modified response
 	responseJSON, err := json.Marshal(responseData)
 	if err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleMirror: failed to marshal response: %v", err)
-		}
+		h.verbose.Debugf(r.Context(), "HandleMirror: failed to marshal response: %v", err)
 		// Fallback to original response
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -855,6 +1219,9 @@ func (h *BlossomHandler) HandleMirror(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if hashVal != "" {
+		h.setReplicationHeaders(w, hashVal, len(successfulServers))
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(responseJSON)
@@ -864,9 +1231,18 @@ func (h *BlossomHandler) HandleMirror(w http.ResponseWriter, r *http.Request) {
 // The request should include headers: X-SHA-256, X-Content-Length, X-Content-Type
 // Returns 200 OK if acceptable, or 4xx with X-Reason header if not
 func (h *BlossomHandler) handleUploadPreflight(w http.ResponseWriter, r *http.Request) {
-	if h.verbose {
-		log.Printf("[DEBUG] handleUploadPreflight: received HEAD /upload request from %s", r.RemoteAddr)
-		log.Printf("[DEBUG] handleUploadPreflight: headers=%v", r.Header)
+	h.verbose.Debugf(r.Context(), "handleUploadPreflight: received HEAD /upload request from %s", r.RemoteAddr)
+	h.verbose.Debugf(r.Context(), "handleUploadPreflight: headers=%v", r.Header)
+
+	// Tell clients up front that an upload would be rejected
+	if h.shedLoad(w, r, "handleUploadPreflight") {
+		return
+	}
+	if h.rejectOversizedUpload(w, r, announcedSize(r), "handleUploadPreflight") {
+		return
+	}
+	if h.rejectBlocked(w, r, declaredHash(r), "handleUploadPreflight") {
+		return
 	}
 
 	// Extract preflight headers (X-SHA-256, X-Content-Length, X-Content-Type)
@@ -880,23 +1256,22 @@ func (h *BlossomHandler) handleUploadPreflight(w http.ResponseWriter, r *http.Re
 			preflightHeaders[k] = v[0]
 		}
 	}
+	h.applyClientIPHeaders(preflightHeaders, r)
 
-	if h.verbose {
-		log.Printf("[DEBUG] handleUploadPreflight: forwarding preflight headers: %v", preflightHeaders)
-	}
+	h.verbose.Debugf(r.Context(), "handleUploadPreflight: forwarding preflight headers: %v", preflightHeaders)
+
+	// Route the preflight like the upload it announces: to a shard if sharding is configured,
+	// and by the announced content type and size if content or size routes are configured
+	targets := h.uploadTargets(w, declaredHash(r), r.Header.Get("X-Content-Type"), announcedSize(r))
 
-	// Check upload requirements on all upstream servers
-	results, err := h.upstreamManager.UploadPreflightParallel(r.Context(), preflightHeaders, h.config.Server.Timeout)
+	// Check upload requirements on the targeted upstream servers
+	results, err := h.upstreamManager.UploadPreflightParallelTo(r.Context(), targets, preflightHeaders, h.config.Server.Timeout)
 	if err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] handleUploadPreflight: preflight check failed: %v", err)
-		}
+		h.verbose.Debugf(r.Context(), "handleUploadPreflight: preflight check failed: %v", err)
 
 		// Check if error has an HTTP status code to pass through
 		if uploadErr, ok := err.(*upstream.UploadError); ok {
-			if h.verbose {
-				log.Printf("[DEBUG] handleUploadPreflight: passing through upstream status code %d", uploadErr.StatusCode)
-			}
+			h.verbose.Debugf(r.Context(), "handleUploadPreflight: passing through upstream status code %d", uploadErr.StatusCode)
 
 			// Collect X-Reason headers from rejected servers
 			reasons := make([]string, 0)
@@ -913,7 +1288,7 @@ func (h *BlossomHandler) handleUploadPreflight(w http.ResponseWriter, r *http.Re
 			}
 
 			setCORSHeaders(w, r)
-			w.Header().Set("X-Reason", reason)
+			w.Header().Set("X-Reason", h.publicMessage(reason))
 			w.WriteHeader(uploadErr.StatusCode)
 			return
 		}
@@ -932,8 +1307,13 @@ func (h *BlossomHandler) handleUploadPreflight(w http.ResponseWriter, r *http.Re
 		}
 	}
 
-	if h.verbose {
-		log.Printf("[DEBUG] handleUploadPreflight: preflight check passed - %d/%d servers would accept", acceptedCount, len(results))
+	h.verbose.Debugf(r.Context(), "handleUploadPreflight: preflight check passed - %d/%d servers would accept", acceptedCount, len(results))
+
+	// Remember the announced size so the upload can be checked for truncation
+	if expectedHash := declaredHash(r); expectedHash != "" {
+		if size, err := strconv.ParseInt(r.Header.Get("X-Content-Length"), 10, 64); err == nil && size >= 0 {
+			h.preflightSizes.Record(expectedHash, size)
+		}
 	}
 
 	// Return 200 OK if at least minUploadServers would accept
@@ -941,12 +1321,124 @@ func (h *BlossomHandler) handleUploadPreflight(w http.ResponseWriter, r *http.Re
 	w.WriteHeader(http.StatusOK)
 }
 
+// byteCounter counts the bytes written to it
+type byteCounter struct {
+	n int64
+}
+
+func (bc *byteCounter) Write(p []byte) (int, error) {
+	bc.n += int64(len(p))
+	return len(p), nil
+}
+
+// normalizeResponseDescriptor fills size, type and uploaded in the selected server's upload/mirror
+// descriptor from the descriptors returned by the other successful servers
+func (h *BlossomHandler) normalizeResponseDescriptor(ctx context.Context, responseData map[string]interface{}, successfulServers []upstream.UploadResultWithResponse, selectedServer string, logPrefix string) {
+	alternatives := make([]map[string]interface{}, 0, len(successfulServers))
+	for _, srv := range successfulServers {
+		if srv.ServerURL == selectedServer {
+			continue
+		}
+		var srvData map[string]interface{}
+		if err := json.Unmarshal(srv.ResponseBody, &srvData); err != nil {
+			continue
+		}
+		alternatives = append(alternatives, srvData)
+	}
+	upstream.NormalizeDescriptor(responseData, alternatives)
+	h.verbose.Debugf(ctx, "%s: normalized descriptor - size=%v, type=%v, uploaded=%v", logPrefix, responseData["size"], responseData["type"], responseData["uploaded"])
+}
+
+// trackNewReplicas starts the settling period for a freshly uploaded or mirrored blob so
+// lookups tolerate 404s from servers that are still indexing it and prefer the servers that
+// confirmed it. Servers that replied 202 Accepted are not trusted yet: they are polled in the
+// background and only join the replica set (settling servers and cache) once the blob is available
+func (h *BlossomHandler) trackNewReplicas(ctx context.Context, hash string, successfulServers []upstream.UploadResultWithResponse, logPrefix string) {
+	h.cache.RemoveNegative(hash)
+	confirmedURLs := make([]string, 0, len(successfulServers))
+	acceptedURLs := make([]string, 0)
+	for _, srv := range successfulServers {
+		if srv.Accepted {
+			acceptedURLs = append(acceptedURLs, srv.ServerURL)
+		} else {
+			confirmedURLs = append(confirmedURLs, srv.ServerURL)
+		}
+	}
+	h.upstreamManager.MarkUploaded(hash, confirmedURLs)
+
+	if len(acceptedURLs) == 0 {
+		return
+	}
+	h.verbose.Debugf(ctx, "%s: %d servers replied 202 Accepted for %s, polling until available: %v", logPrefix, len(acceptedURLs), hash, acceptedURLs)
+	h.upstreamManager.PollAccepted(h.lifecycle, hash, acceptedURLs,
+		func(serverURL string) {
+			// Only extend existing cache entries; missing entries are filled by the next lookup
+			if _, exists := h.cache.Get(hash); exists {
+				h.cache.AddServer(hash, serverURL)
+			}
+		},
+		func(serverURL string) {
+			h.stats.RecordFailure(serverURL, "upload")
+		})
+}
+
+// cacheHeadMetadata stores the HEAD metadata of the servers holding path in its cache
+// entry, recording the servers that disagree on the blob size once, when it is cached,
+// rather than on every HEAD answered from it
+func (h *BlossomHandler) cacheHeadMetadata(ctx context.Context, path string, headers map[string]http.Header) {
+	h.cache.SetHeaders(path, headers)
+	aggregated := h.upstreamManager.AggregateHeadMetadata(headers)
+	if len(aggregated.MismatchedServers) == 0 {
+		return
+	}
+	logging.Warnf(ctx, "Size mismatch for %s - consensus %d bytes, mismatching servers: %v", path[:64], aggregated.ConsensusSize, aggregated.MismatchedServers)
+	for _, serverURL := range aggregated.MismatchedServers {
+		h.stats.RecordSizeMismatch(serverURL)
+	}
+}
+
+// serveFromSpool answers a GET/HEAD request from the spooled body of a recently completed
+// upload. Returns true if the request was served
+func (h *BlossomHandler) serveFromSpool(w http.ResponseWriter, r *http.Request, path string, logPrefix string) bool {
+	hash := path[:64]
+	body, info, ok := h.spool.Open(hash)
+	if !ok {
+		return false
+	}
+	defer body.Close()
+
+	h.verbose.Debugf(r.Context(), "%s: serving %s from upload spool", logPrefix, hash)
+
+	setCORSHeaders(w, r)
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return true
+	}
+	n, err := io.Copy(w, body)
+	h.stats.RecordServed(n)
+	if err != nil {
+		markClientAbort(w)
+		h.verbose.Debugf(r.Context(), "%s: error streaming %s from upload spool: %v", logPrefix, hash, err)
+	}
+	return true
+}
+
 // HandleDownload handles GET /<sha256> requests
 func (h *BlossomHandler) HandleDownload(w http.ResponseWriter, r *http.Request) {
-	if h.verbose {
-		log.Printf("[DEBUG] HandleDownload: received %s request from %s", r.Method, r.RemoteAddr)
-		log.Printf("[DEBUG] HandleDownload: path=%s", r.URL.Path)
-	}
+	h.verbose.Debugf(r.Context(), "HandleDownload: received %s request from %s", r.Method, r.RemoteAddr)
+	h.verbose.Debugf(r.Context(), "HandleDownload: path=%s", r.URL.Path)
+	w, finishMetrics := h.trackRequest(w, r, "download")
+	defer finishMetrics()
+	r, finishSlowTrace := h.traceSlowRequests(r)
+	defer finishSlowTrace("HandleDownload")
+	w, timing := h.startServerTiming(w)
 
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -958,60 +1450,145 @@ func (h *BlossomHandler) HandleDownload(w http.ResponseWriter, r *http.Request)
 
 	// Validate path format (must contain a valid hash in the first 64 characters)
 	if err := validatePath(path); err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleDownload: %v", err)
-		}
+		h.verbose.Debugf(r.Context(), "HandleDownload: %v", err)
 		http.Error(w, "Invalid hash format", http.StatusBadRequest)
 		return
 	}
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleDownload: path: %s", path)
+	// Blocked blobs are never served, from upstreams or from the local caches
+	if h.rejectBlocked(w, r, path[:64], "HandleDownload") {
+		return
+	}
+
+	h.verbose.Debugf(r.Context(), "HandleDownload: path: %s", path)
+
+	// Validate authentication if required for downloads (see auth_endpoints)
+	if _, ok := h.authorize(w, r, "get", "HandleDownload"); !ok {
+		return
+	}
+	h.popularity.Record(path[:64])
+
+	// Serve blobs that are still being uploaded (or were just uploaded) from the local spool
+	// so clients don't race upstream servers that haven't finished processing them
+	if h.serveFromSpool(w, r, path, "HandleDownload") {
+		return
+	}
+	if h.serveQueuedUpload(w, r, path, "HandleDownload") {
+		return
+	}
+	if h.serveFromBlobStore(w, r, path, "HandleDownload") {
+		return
 	}
 
 	// Look up path in cache
+	cacheStart := time.Now()
 	servers, exists := h.cache.Get(path)
+	headMetadata, _ := h.cache.GetHeaders(path)
 	if !exists || len(servers) == 0 {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleDownload: path %s not found in cache, checking upstream servers", path)
+		timing.since("cache", "miss", cacheStart)
+		h.cacheVerbose.Debugf(r.Context(), "HandleDownload: path %s not found in cache, checking upstream servers", path)
+		// Hashes recently found on no upstream aren't looked up again
+		if h.cache.IsNegative(path) {
+			h.cacheVerbose.Debugf(r.Context(), "HandleDownload: path %s recently found on no upstream server (negative cache)", path)
+			http.Error(w, "Blob not found", http.StatusNotFound)
+			return
+		}
+		// Clients scanning for hashes don't get their lookups fanned out to upstreams
+		if h.shortCircuitScan(w, r, "HandleDownload") {
+			return
 		}
 		// Path not in cache, check upstream servers using HEAD requests
-		result := h.upstreamManager.CheckPathOnServers(r.Context(), path, h.config.Server.Timeout)
+		lookupStart := time.Now()
+		result, settling := h.upstreamManager.CheckPathOnServersSettled(r.Context(), path, h.config.Server.Timeout)
+		timing.since("lookup", "", lookupStart)
 		servers = result.Servers
+		headMetadata = result.Headers
 		if len(servers) == 0 {
-			if h.verbose {
-				log.Printf("[DEBUG] HandleDownload: path %s not found on any upstream server", path)
+			h.verbose.Debugf(r.Context(), "HandleDownload: path %s not found on any upstream server", path)
+			// A miss means nothing when every upstream is down
+			if h.noHealthyUpstreams("download") {
+				h.writeDegraded(w, r, "download", "HandleDownload")
+				return
 			}
+			if !settling {
+				h.cache.AddNegative(path)
+			}
+			h.recordScanMiss(r, path, "HandleDownload")
 			http.Error(w, "Blob not found", http.StatusNotFound)
 			return
 		}
-		// Update cache with found servers
-		h.cache.Add(path, servers)
-		if h.verbose {
-			log.Printf("[DEBUG] HandleDownload: path %s found on %d upstream servers, added to cache", path, len(servers))
+		// Update cache with found servers and their HEAD metadata
+		// Lookups for recently uploaded blobs may be incomplete, so they are not cached
+		if !settling {
+			h.cache.Add(path, servers)
+			h.cacheHeadMetadata(r.Context(), path, result.Headers)
+			h.cacheVerbose.Debugf(r.Context(), "HandleDownload: path %s found on %d upstream servers, added to cache", path, len(servers))
+		} else if h.cacheVerbose.Enabled() {
+			h.cacheVerbose.Debugf(r.Context(), "HandleDownload: path %s is settling, found on %d upstream servers (not cached)", path, len(servers))
 		}
+	} else {
+		timing.since("cache", "hit", cacheStart)
 	}
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleDownload: path found in cache with %d servers: %v", len(servers), servers)
+	// Never offer replicas that failed verification
+	servers = h.quarantine.Filter(path, servers)
+	if len(servers) == 0 {
+		h.verbose.Debugf(r.Context(), "HandleDownload: all replicas of %s are quarantined", path)
+		http.Error(w, "Blob not found", http.StatusNotFound)
+		return
 	}
 
+	// Spread trending blobs held by a single server (see prefetch_min_popularity)
+	h.prefetchTrending(path[:64], servers)
+
+	// Right after an upload, prefer the servers that confirmed it
+	servers = h.upstreamManager.PreferSettled(path[:64], servers)
+
+	// Seeking (e.g., in large videos) needs a server that honors Range requests
+	rangeRequested := r.Header.Get("Range") != ""
+	if rangeRequested {
+		servers = h.upstreamManager.PreferRangeCapable(servers, headMetadata)
+	}
+
+	h.cacheVerbose.Debugf(r.Context(), "HandleDownload: path found in cache with %d servers: %v", len(servers), servers)
+
 	// Select a server for redirect using download_redirect_strategy if set, otherwise fall back to redirect_strategy
+	// With download_redirect_sticky, a client keeps getting the same server for a blob instead
 	downloadStrategy := h.config.Server.DownloadRedirectStrategy
 	if downloadStrategy == "" {
 		downloadStrategy = h.config.Server.RedirectStrategy
 	}
-	selectedServer, err := h.upstreamManager.SelectServerURLWithStrategy(servers, downloadStrategy)
+	selectStart := time.Now()
+	var selectedServer string
+	var err error
+	if h.config.Server.DownloadRedirectSticky {
+		selectedServer, err = h.upstreamManager.SelectServerURLSticky(servers, h.realClientAddress(r)+" "+path[:64])
+	} else {
+		selectedServer, err = h.upstreamManager.SelectServerURLWithStrategy(servers, downloadStrategy)
+	}
+	timing.since("select", "", selectStart)
 	if err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleDownload: failed to select server: %v", err)
-		}
+		h.verbose.Debugf(r.Context(), "HandleDownload: failed to select server: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to select server: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Track download success for the selected server
+	if rangeRequested && !h.upstreamManager.SupportsRanges(headMetadata, selectedServer) {
+		logging.Warnf(r.Context(), "HandleDownload: Range requested for %s but %s does not advertise range support", path, selectedServer)
+	}
+
+	// Stream the blob through the proxy instead of redirecting (download_mode: proxy)
+	if h.config.Server.DownloadMode == "proxy" {
+		h.proxyDownload(w, r, path, servers, selectedServer, "HandleDownload")
+		return
+	}
+
+	// Keep a local copy so the next downloads are served by the proxy (blob_cache_max_bytes)
+	h.fillBlobStore(path, selectedServer, headMetadata)
+
+	// Track download success for the selected server, and the egress it will serve (as far as known)
 	h.stats.RecordSuccess(selectedServer, "download")
+	h.stats.RecordRedirect(selectedServer, upstream.BlobSize(headMetadata, selectedServer))
 
 	// Always redirect to upstream server (not local)
 	// "local" strategy only affects response URLs in upload/mirror/list, not download redirects
@@ -1019,23 +1596,25 @@ func (h *BlossomHandler) HandleDownload(w http.ResponseWriter, r *http.Request)
 	// Use the full path as-is (including extension if present)
 	redirectURL := fmt.Sprintf("%s/%s", selectedServer, path)
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleDownload: selected server: %s", selectedServer)
-		log.Printf("[DEBUG] HandleDownload: redirecting to: %s", redirectURL)
-	}
+	h.verbose.Debugf(r.Context(), "HandleDownload: selected server: %s", selectedServer)
+	h.verbose.Debugf(r.Context(), "HandleDownload: redirecting to: %s", redirectURL)
 
 	// Set CORS headers on redirect response
 	setCORSHeaders(w, r)
+	h.setReplicationHeaders(w, path[:64], len(servers))
+	h.setAltLocationHeaders(r.Context(), w, servers, selectedServer, path)
 
 	http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
 }
 
 // HandleHead handles HEAD /<sha256> requests
 func (h *BlossomHandler) HandleHead(w http.ResponseWriter, r *http.Request) {
-	if h.verbose {
-		log.Printf("[DEBUG] HandleHead: received %s request from %s", r.Method, r.RemoteAddr)
-		log.Printf("[DEBUG] HandleHead: path=%s", r.URL.Path)
-	}
+	h.verbose.Debugf(r.Context(), "HandleHead: received %s request from %s", r.Method, r.RemoteAddr)
+	h.verbose.Debugf(r.Context(), "HandleHead: path=%s", r.URL.Path)
+	w, finishMetrics := h.trackRequest(w, r, "head")
+	defer finishMetrics()
+	r, finishSlowTrace := h.traceSlowRequests(r)
+	defer finishSlowTrace("HandleHead")
 
 	if r.Method != http.MethodHead {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -1047,65 +1626,130 @@ func (h *BlossomHandler) HandleHead(w http.ResponseWriter, r *http.Request) {
 
 	// Validate path format (must contain a valid hash in the first 64 characters)
 	if err := validatePath(path); err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleHead: %v", err)
-		}
+		h.verbose.Debugf(r.Context(), "HandleHead: %v", err)
 		http.Error(w, "Invalid hash format", http.StatusBadRequest)
 		return
 	}
+	if h.rejectBlocked(w, r, path[:64], "HandleHead") {
+		return
+	}
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleHead: path: %s", path)
+	h.verbose.Debugf(r.Context(), "HandleHead: path: %s", path)
+
+	// Validate authentication if required for downloads (see auth_endpoints)
+	if _, ok := h.authorize(w, r, "get", "HandleHead"); !ok {
+		return
+	}
+
+	// Serve blobs that are still being uploaded (or were just uploaded) from the local spool
+	// so clients don't race upstream servers that haven't finished processing them
+	if h.serveFromSpool(w, r, path, "HandleHead") {
+		return
+	}
+	if h.serveQueuedUpload(w, r, path, "HandleHead") {
+		return
+	}
+	if h.serveFromBlobStore(w, r, path, "HandleHead") {
+		return
 	}
 
 	// Look up path in cache
 	servers, exists := h.cache.Get(path)
 	if !exists || len(servers) == 0 {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleHead: path %s not found in cache, checking upstream servers", path)
+		h.cacheVerbose.Debugf(r.Context(), "HandleHead: path %s not found in cache, checking upstream servers", path)
+		// Hashes recently found on no upstream aren't looked up again
+		if h.cache.IsNegative(path) {
+			h.cacheVerbose.Debugf(r.Context(), "HandleHead: path %s recently found on no upstream server (negative cache)", path)
+			http.Error(w, "Blob not found", http.StatusNotFound)
+			return
+		}
+		// Clients scanning for hashes don't get their lookups fanned out to upstreams
+		if h.shortCircuitScan(w, r, "HandleHead") {
+			return
 		}
 		// Path not in cache, check upstream servers using HEAD requests
-		result := h.upstreamManager.CheckPathOnServers(r.Context(), path, h.config.Server.Timeout)
+		result, settling := h.upstreamManager.CheckPathOnServersSettled(r.Context(), path, h.config.Server.Timeout)
 		servers = result.Servers
 		if len(servers) == 0 {
-			if h.verbose {
-				log.Printf("[DEBUG] HandleHead: path %s not found on any upstream server", path)
+			h.verbose.Debugf(r.Context(), "HandleHead: path %s not found on any upstream server", path)
+			// A miss means nothing when every upstream is down
+			if h.noHealthyUpstreams("download") {
+				h.writeDegraded(w, r, "download", "HandleHead")
+				return
+			}
+			if !settling {
+				h.cache.AddNegative(path)
 			}
+			h.recordScanMiss(r, path, "HandleHead")
 			http.Error(w, "Blob not found", http.StatusNotFound)
 			return
 		}
-		// Update cache with found servers
-		h.cache.Add(path, servers)
-		if h.verbose {
-			log.Printf("[DEBUG] HandleHead: path %s found on %d upstream servers, added to cache", path, len(servers))
+		// Update cache with found servers and their HEAD metadata
+		// Lookups for recently uploaded blobs may be incomplete, so they are not cached
+		if !settling {
+			h.cache.Add(path, servers)
+			h.cacheHeadMetadata(r.Context(), path, result.Headers)
+			h.cacheVerbose.Debugf(r.Context(), "HandleHead: path %s found on %d upstream servers, added to cache", path, len(servers))
+		} else if h.cacheVerbose.Enabled() {
+			h.cacheVerbose.Debugf(r.Context(), "HandleHead: path %s is settling, found on %d upstream servers (not cached)", path, len(servers))
 		}
 	}
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleHead: path found with %d servers: %v", len(servers), servers)
+	// Never offer replicas that failed verification
+	servers = h.quarantine.Filter(path, servers)
+	if len(servers) == 0 {
+		h.verbose.Debugf(r.Context(), "HandleHead: all replicas of %s are quarantined", path)
+		http.Error(w, "Blob not found", http.StatusNotFound)
+		return
+	}
+
+	// Right after an upload, prefer the servers that confirmed it
+	servers = h.upstreamManager.PreferSettled(path[:64], servers)
+
+	// Range and conditional requests are forwarded to a server, preferably one that honors
+	// Range requests, instead of being answered from cached metadata
+	forwardHeaders := hasRangeOrConditional(r)
+	if r.Header.Get("Range") != "" {
+		headMetadata, _ := h.cache.GetHeaders(path)
+		servers = h.upstreamManager.PreferRangeCapable(servers, headMetadata)
+	}
+
+	h.verbose.Debugf(r.Context(), "HandleHead: path found with %d servers: %v", len(servers), servers)
+
+	// Serve from aggregated metadata if we have HEAD headers for the replicas
+	// This prefers the server with the most complete metadata among those agreeing on the size
+	if headers, ok := h.cache.GetHeaders(path); ok && !forwardHeaders {
+		for serverURL := range headers {
+			if h.quarantine.IsQuarantined(path, serverURL) {
+				delete(headers, serverURL)
+			}
+		}
+		aggregated := h.upstreamManager.AggregateHeadMetadata(headers)
+		if aggregated.BestServer != "" {
+			setCORSHeaders(w, r)
+			copyProxiedHeaders(w, headers[aggregated.BestServer])
+			h.setReplicationHeaders(w, path[:64], len(servers))
+			w.WriteHeader(http.StatusOK)
+			h.verbose.Debugf(r.Context(), "HandleHead: served HEAD from aggregated metadata of %s", aggregated.BestServer)
+			return
+		}
 	}
 
 	// Select the first server that has the blob
 	selectedServer, err := h.upstreamManager.SelectServerURL(servers)
 	if err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleHead: failed to select server: %v", err)
-		}
+		h.verbose.Debugf(r.Context(), "HandleHead: failed to select server: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to select server: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleHead: selected server: %s", selectedServer)
-	}
+	h.verbose.Debugf(r.Context(), "HandleHead: selected server: %s", selectedServer)
 
 	// Make HEAD request to the first upstream server that has the blob
 	cl, err := h.upstreamManager.GetClient(selectedServer)
 	if err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleHead: failed to get client for %s: %v", selectedServer, err)
-		}
-		http.Error(w, fmt.Sprintf("Failed to get client: %v", err), http.StatusInternalServerError)
+		h.verbose.Debugf(r.Context(), "HandleHead: failed to get client for %s: %v", selectedServer, err)
+		http.Error(w, fmt.Sprintf("Failed to get client: %s", h.publicError(r.Context(), err, "HandleHead")), http.StatusInternalServerError)
 		return
 	}
 
@@ -1113,37 +1757,36 @@ func (h *BlossomHandler) HandleHead(w http.ResponseWriter, r *http.Request) {
 	// Use the full path (including extension if present) to preserve it in the request
 	headCtx, cancel := context.WithTimeout(r.Context(), h.config.Server.Timeout)
 	defer cancel()
-	resp, err := cl.Head(headCtx, path)
+	resp, err := cl.HeadWithHeaders(headCtx, path, upstreamRequestHeaders(r))
 	if err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleHead: HEAD request failed: %v", err)
-		}
-		http.Error(w, fmt.Sprintf("Request failed: %v", err), http.StatusInternalServerError)
+		h.stats.RecordFailure(selectedServer, "download")
+		h.verbose.Debugf(r.Context(), "HandleHead: HEAD request failed: %v", err)
+		http.Error(w, fmt.Sprintf("Request failed: %s", h.publicError(r.Context(), err, "HandleHead")), http.StatusInternalServerError)
 		return
 	}
 	defer resp.Body.Close()
 
-	// Copy headers from upstream response
-	for k, v := range resp.Header {
-		for _, val := range v {
-			w.Header().Add(k, val)
-		}
+	// Copy the blob's headers from the upstream response, and the reason of an error
+	setCORSHeaders(w, r)
+	copyProxiedHeaders(w, resp.Header)
+	if reason := resp.Header.Get("X-Reason"); reason != "" {
+		w.Header().Set("X-Reason", reason)
 	}
 
+	h.setReplicationHeaders(w, path[:64], len(servers))
+
 	// Return the status code from upstream
 	w.WriteHeader(resp.StatusCode)
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleHead: proxied HEAD response with status %d from %s", resp.StatusCode, selectedServer)
-	}
+	h.verbose.Debugf(r.Context(), "HandleHead: proxied HEAD response with status %d from %s", resp.StatusCode, selectedServer)
 }
 
 // HandleList handles GET /list/<pubkey> requests
 func (h *BlossomHandler) HandleList(w http.ResponseWriter, r *http.Request) {
-	if h.verbose {
-		log.Printf("[DEBUG] HandleList: received %s request from %s", r.Method, r.RemoteAddr)
-		log.Printf("[DEBUG] HandleList: path=%s", r.URL.Path)
-	}
+	h.verbose.Debugf(r.Context(), "HandleList: received %s request from %s", r.Method, r.RemoteAddr)
+	h.verbose.Debugf(r.Context(), "HandleList: path=%s", r.URL.Path)
+	w, finishMetrics := h.trackRequest(w, r, "list")
+	defer finishMetrics()
 
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -1153,57 +1796,60 @@ func (h *BlossomHandler) HandleList(w http.ResponseWriter, r *http.Request) {
 	// Extract pubkey from path (format: /list/<pubkey>)
 	path := strings.TrimPrefix(r.URL.Path, "/list/")
 	if path == "" {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleList: pubkey missing from path")
-		}
+		h.verbose.Debugf(r.Context(), "HandleList: pubkey missing from path")
 		http.Error(w, "Pubkey required", http.StatusBadRequest)
 		return
 	}
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleList: extracted pubkey: %s", path)
+	// Accept npubs as well as hex pubkeys: upstreams only understand hex
+	pubkey, err := auth.NormalizePubkey(path)
+	if err != nil {
+		h.verbose.Debugf(r.Context(), "HandleList: invalid pubkey %q: %v", path, err)
+		http.Error(w, "Invalid pubkey (expected 64 hex characters or an npub)", http.StatusBadRequest)
+		return
 	}
+	path = pubkey
 
-	// Validate authentication if pubkeys are configured
-	if len(h.allowedPubkeys) > 0 {
-		_, err := auth.ValidateAuth(r, "list", h.allowedPubkeys, h.verbose)
-		if err != nil {
-			if authErr, ok := err.(*auth.AuthError); ok {
-				if h.verbose {
-					log.Printf("[DEBUG] HandleList: authentication failed: %s", authErr.Reason)
-				}
-				w.Header().Set("X-Reason", authErr.Reason)
-				http.Error(w, authErr.Reason, authErr.Code)
-				return
-			}
-			if h.verbose {
-				log.Printf("[DEBUG] HandleList: authentication error: %v", err)
-			}
-			http.Error(w, fmt.Sprintf("Authentication error: %v", err), http.StatusUnauthorized)
-			return
-		}
+	h.verbose.Debugf(r.Context(), "HandleList: extracted pubkey: %s", path)
+
+	// Validate authentication if required for this endpoint (see auth_endpoints and list_access)
+	if !h.authorizeList(w, r, path, "HandleList") {
+		return
 	}
 
 	// Query all upstream servers in parallel and merge results
 	mergedResults, listResults, err := h.upstreamManager.ListParallelWithResults(r.Context(), path, h.config.Server.Timeout)
 	if err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleList: list request failed: %v", err)
-		}
+		h.verbose.Debugf(r.Context(), "HandleList: list request failed: %v", err)
 		// Track failures for all servers if operation failed completely
 		for _, result := range listResults {
+			if result.Unsupported {
+				continue
+			}
 			if result.Error != nil {
 				h.stats.RecordFailure(result.ServerURL, "list")
 			} else {
 				h.stats.RecordSuccess(result.ServerURL, "list")
 			}
 		}
-		http.Error(w, fmt.Sprintf("List request failed: %v", err), http.StatusInternalServerError)
+		if h.noHealthyUpstreams("list") {
+			h.writeDegraded(w, r, "list", "HandleList")
+			return
+		}
+		if listErr, ok := err.(*upstream.ListError); ok {
+			h.writeListError(w, r, listErr)
+			return
+		}
+		http.Error(w, fmt.Sprintf("List request failed: %s", h.publicError(r.Context(), err, "HandleList")), http.StatusInternalServerError)
 		return
 	}
 
 	// Track stats for all servers based on their individual results
+	// Servers that turned out not to implement list are not counted as failures
 	for _, result := range listResults {
+		if result.Unsupported {
+			continue
+		}
 		if result.Error != nil {
 			h.stats.RecordFailure(result.ServerURL, "list")
 		} else {
@@ -1211,12 +1857,21 @@ func (h *BlossomHandler) HandleList(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleList: merged %d items from all servers", len(mergedResults))
+	h.verbose.Debugf(r.Context(), "HandleList: merged %d items from all servers", len(mergedResults))
+
+	// Some servers failed or timed out: the merged list may be missing their blobs
+	if failures := upstream.ListFailures(listResults); len(failures) > 0 {
+		if h.config.Server.ListFailOnPartial {
+			h.writeListError(w, r, upstream.NewPartialListError(listResults))
+			return
+		}
+		h.verbose.Debugf(r.Context(), "HandleList: returning partial results, %d servers failed", len(failures))
+		w.Header().Set("X-Partial", "true")
+		w.Header().Set("X-Upstream-Errors", h.publicMessage(upstream.FormatListFailures(failures)))
 	}
 
-	// If redirect strategy is "local", replace URLs with local URLs
-	if h.config.Server.RedirectStrategy == "local" {
+	// With the "local" strategy or prefer_base_url mode, replace URLs with local URLs
+	if h.useLocalResponseURL() {
 		for _, item := range mergedResults {
 			// Get hash from item
 			var hashVal string
@@ -1237,9 +1892,7 @@ func (h *BlossomHandler) HandleList(w http.ResponseWriter, r *http.Request) {
 				localURL := h.constructLocalURL(hashVal, mimeType, r)
 				item["url"] = localURL
 
-				if h.verbose {
-					log.Printf("[DEBUG] HandleList: replaced URL with local URL for hash %s: %s", hashVal, localURL)
-				}
+				h.verbose.Debugf(r.Context(), "HandleList: replaced URL with local URL for hash %s: %s", hashVal, localURL)
 			}
 		}
 	}
@@ -1247,13 +1900,12 @@ func (h *BlossomHandler) HandleList(w http.ResponseWriter, r *http.Request) {
 	// Marshal the merged results to JSON
 	responseJSON, err := json.Marshal(mergedResults)
 	if err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleList: failed to marshal merged results: %v", err)
-		}
+		h.verbose.Debugf(r.Context(), "HandleList: failed to marshal merged results: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to marshal response: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	setCORSHeaders(w, r)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(responseJSON)
@@ -1261,44 +1913,22 @@ func (h *BlossomHandler) HandleList(w http.ResponseWriter, r *http.Request) {
 
 // HandleDelete handles DELETE /<sha256> requests
 func (h *BlossomHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
-	if h.verbose {
-		log.Printf("[DEBUG] HandleDelete: received %s request from %s", r.Method, r.RemoteAddr)
-		log.Printf("[DEBUG] HandleDelete: path=%s", r.URL.Path)
-	}
+	h.verbose.Debugf(r.Context(), "HandleDelete: received %s request from %s", r.Method, r.RemoteAddr)
+	h.verbose.Debugf(r.Context(), "HandleDelete: path=%s", r.URL.Path)
+	w, finishMetrics := h.trackRequest(w, r, "delete")
+	defer finishMetrics()
 
 	if r.Method != http.MethodDelete {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Validate authentication if pubkeys are configured
-	if len(h.allowedPubkeys) > 0 {
-		_, err := auth.ValidateAuth(r, "delete", h.allowedPubkeys, h.verbose)
-		if err != nil {
-			if authErr, ok := err.(*auth.AuthError); ok {
-				if h.verbose {
-					log.Printf("[DEBUG] HandleDelete: authentication failed: %s", authErr.Reason)
-				}
-				w.Header().Set("X-Reason", authErr.Reason)
-				http.Error(w, authErr.Reason, authErr.Code)
-				return
-			}
-			if h.verbose {
-				log.Printf("[DEBUG] HandleDelete: authentication error: %v", err)
-			}
-			http.Error(w, fmt.Sprintf("Authentication error: %v", err), http.StatusUnauthorized)
-			return
-		}
-	}
-
 	// Extract path (remove leading slash)
 	path := strings.TrimPrefix(r.URL.Path, "/")
 
 	// Validate path format (must contain a valid hash in the first 64 characters)
 	if err := validatePath(path); err != nil {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleDelete: %v", err)
-		}
+		h.verbose.Debugf(r.Context(), "HandleDelete: %v", err)
 		http.Error(w, "Invalid hash format", http.StatusBadRequest)
 		return
 	}
@@ -1307,22 +1937,22 @@ func (h *BlossomHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	// The path may include an extension, but Delete expects just the hash
 	hash := path[:64]
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleDelete: path: %s", path)
+	// Validate the authorization event (see auth_endpoints) and that it covers this blob,
+	// which every upstream would check anyway
+	if !h.authorizeDelete(w, r, hash, "HandleDelete") {
+		return
 	}
 
+	h.verbose.Debugf(r.Context(), "HandleDelete: path: %s", path)
+
 	// Get servers that have this blob
 	servers, exists := h.cache.Get(path)
 	if !exists {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleDelete: path not in cache, using all upstream servers")
-		}
+		h.cacheVerbose.Debugf(r.Context(), "HandleDelete: path not in cache, using all upstream servers")
 		// If not in cache, try all upstream servers
 		servers = h.upstreamManager.GetServerURLs()
 	} else {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleDelete: path found in cache with %d servers: %v", len(servers), servers)
-		}
+		h.cacheVerbose.Debugf(r.Context(), "HandleDelete: path found in cache with %d servers: %v", len(servers), servers)
 	}
 
 	// Copy headers for authentication
@@ -1335,10 +1965,9 @@ func (h *BlossomHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
 			headers[k] = v[0]
 		}
 	}
+	h.applyClientIPHeaders(headers, r)
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleDelete: forwarding delete to %d servers", len(servers))
-	}
+	h.verbose.Debugf(r.Context(), "HandleDelete: forwarding delete to %d servers", len(servers))
 
 	// Forward delete to all servers that have the blob
 	// Create a timeout context for delete operations
@@ -1346,44 +1975,55 @@ func (h *BlossomHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	successCount := 0
+	attempted := 0
+	failedServers := make([]string, 0)
 	for _, serverURL := range servers {
+		if !h.upstreamManager.SupportsDelete(serverURL) {
+			h.verbose.Debugf(r.Context(), "HandleDelete: skipping %s, delete not supported", serverURL)
+			continue
+		}
+		attempted++
 		cl, err := h.upstreamManager.GetClient(serverURL)
 		if err != nil {
-			if h.verbose {
-				log.Printf("[DEBUG] HandleDelete: failed to get client for %s: %v", serverURL, err)
-			}
+			h.verbose.Debugf(r.Context(), "HandleDelete: failed to get client for %s: %v", serverURL, err)
 			continue
 		}
 
+		deleteStart := time.Now()
 		err = cl.Delete(deleteCtx, hash, headers)
 		if err == nil {
 			successCount++
 			h.stats.RecordSuccess(serverURL, "delete")
-			if h.verbose {
-				log.Printf("[DEBUG] HandleDelete: successfully deleted from %s", serverURL)
-			}
+			h.stats.RecordLatency(serverURL, "delete", time.Since(deleteStart))
+			h.verbose.Debugf(r.Context(), "HandleDelete: successfully deleted from %s", serverURL)
+		} else if h.upstreamManager.DetectUnsupported(serverURL, "delete", err) {
+			// Server doesn't implement delete: not a failure
+			h.verbose.Debugf(r.Context(), "HandleDelete: %s does not support delete: %v", serverURL, err)
 		} else {
 			h.stats.RecordFailure(serverURL, "delete")
-			if h.verbose {
-				log.Printf("[DEBUG] HandleDelete: failed to delete from %s: %v", serverURL, err)
-			}
+			failedServers = append(failedServers, serverURL)
+			h.verbose.Debugf(r.Context(), "HandleDelete: failed to delete from %s: %v", serverURL, err)
 		}
 	}
 
-	if h.verbose {
-		log.Printf("[DEBUG] HandleDelete: deleted from %d/%d servers", successCount, len(servers))
-	}
+	h.verbose.Debugf(r.Context(), "HandleDelete: deleted from %d/%d servers", successCount, attempted)
+
+	h.recordActivity("delete", hash, -1, successCount, attempted, successCount == 0)
 
 	// Remove from cache if at least one delete succeeded
 	if successCount > 0 {
 		h.cache.Remove(path)
-		if h.verbose {
-			log.Printf("[DEBUG] HandleDelete: removed path %s from cache", path)
-		}
+		h.blobStore.Remove(hash)
+		h.recentUploads.Forget(hash)
+		h.cacheVerbose.Debugf(r.Context(), "HandleDelete: removed path %s from cache", path)
+		// Keep deleting from the servers that failed in the background
+		h.journalDeletes(r.Context(), hash, failedServers, headers, "HandleDelete")
 		w.WriteHeader(http.StatusNoContent)
 	} else {
-		if h.verbose {
-			log.Printf("[DEBUG] HandleDelete: delete failed on all servers")
+		h.verbose.Debugf(r.Context(), "HandleDelete: delete failed on all servers")
+		if attempted > 0 && h.noHealthyUpstreams("delete") {
+			h.writeDegraded(w, r, "delete", "HandleDelete")
+			return
 		}
 		http.Error(w, "Delete failed on all servers", http.StatusInternalServerError)
 	}
@@ -1399,6 +2039,9 @@ func (h *BlossomHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 
 	healthyCount := h.stats.GetHealthyCount()
 	minUploadServers := h.config.Server.MinUploadServers
+	// The upload quorum depends on servers whose upload endpoint is healthy,
+	// regardless of how they are doing for other operations
+	uploadHealthyCount := h.stats.GetHealthyCountFor("upload")
 
 	allStats := h.stats.GetAll()
 
@@ -1411,15 +2054,17 @@ func (h *BlossomHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	// Check if system metrics are healthy
 	memoryHealthy := memoryBytes < h.config.Server.MaxMemoryBytes
 	goroutinesHealthy := goroutines < h.config.Server.MaxGoroutines
-	serversHealthy := healthyCount >= minUploadServers
+	serversHealthy := uploadHealthyCount >= minUploadServers
 
 	// System is healthy if all checks pass
 	systemHealthy := memoryHealthy && goroutinesHealthy && serversHealthy
 
 	response := map[string]interface{}{
-		"healthy":            systemHealthy,
-		"healthy_count":      healthyCount,
-		"min_upload_servers": minUploadServers,
+		"healthy":              systemHealthy,
+		"healthy_count":        healthyCount,
+		"upload_healthy_count": uploadHealthyCount,
+		"min_upload_servers":   minUploadServers,
+		"degraded":             uploadHealthyCount == 0,
 		"memory": map[string]interface{}{
 			"bytes":   memoryBytes,
 			"max":     h.config.Server.MaxMemoryBytes,
@@ -1435,10 +2080,25 @@ func (h *BlossomHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 
 	// Add server health details
 	serversMap := response["servers"].(map[string]interface{})
+	redact := h.redactUpstreams(r)
 	for url, stats := range allStats {
-		serversMap[url] = map[string]interface{}{
+		key := url
+		if redact {
+			key = h.upstreamManager.ServerLabel(url)
+		}
+		serversMap[key] = map[string]interface{}{
 			"healthy":              stats.IsHealthy,
 			"consecutive_failures": stats.ConsecutiveFailures,
+			"operations":           stats.Operations,
+			"in_maintenance":       h.upstreamManager.IsInMaintenance(url),
+		}
+	}
+
+	// Anonymous visitors of non-public instances only learn the overall status
+	if !h.statusDetailsAllowed(r) {
+		response = map[string]interface{}{
+			"healthy":  systemHealthy,
+			"degraded": uploadHealthyCount == 0,
 		}
 	}
 
@@ -1459,8 +2119,15 @@ func (h *BlossomHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !h.requireStatusAuth(w, r) {
+		return
+	}
 
 	allStats := h.stats.GetAll()
+	redact := h.redactUpstreams(r)
+	if redact {
+		allStats = h.redactServerStats(allStats)
+	}
 
 	// Get system metrics
 	var m runtime.MemStats
@@ -1469,7 +2136,9 @@ func (h *BlossomHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
 	goroutines := runtime.NumGoroutine()
 
 	response := map[string]interface{}{
-		"servers": allStats,
+		"servers":        allStats,
+		"started_at":     h.stats.StartedAt().UTC().Format(time.RFC3339),
+		"uptime_seconds": int64(h.stats.Uptime().Seconds()),
 		"memory": map[string]interface{}{
 			"bytes": memoryBytes,
 			"max":   h.config.Server.MaxMemoryBytes,
@@ -1515,6 +2184,57 @@ func (h *BlossomHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
 	response["healthy_count"] = healthyCount
 	response["total_servers"] = len(allStats)
 
+	// Replication summary for blobs currently known to the cache
+	response["replication"] = h.upstreamManager.SummarizeReplication(h.cache.Snapshot())
+	response["quarantined_replicas"] = h.quarantine.Count()
+	response["blocked_hashes"] = h.blocklist.Len()
+	response["cache"] = h.cache.Counters()
+	if h.blobStore != nil {
+		response["blob_cache"] = h.blobStore.Counters()
+	}
+	if h.quotas != nil {
+		response["upload_quotas"] = map[string]int{"tracked_pubkeys": h.quotas.Tracked()}
+	}
+	if h.repairer != nil {
+		response["replication_repair"] = h.repairer.Counters()
+	}
+	response["journal"] = h.journalStats()
+	response["load_shedding"] = h.loadSheddingStats()
+	response["scan_detection"] = h.scanDetectionStats()
+	response["rate_limiting"] = h.rateLimitingStats()
+	response["slow_requests"] = h.slowRequestStats()
+	response["requests"] = h.requestMetrics.snapshot()
+	response["panics_total"] = recovery.Total()
+	response["upload_pipelines"] = h.upstreamManager.PipelineStats()
+	if redact {
+		response["bandwidth"] = h.redactBandwidth(h.stats.GetBandwidth())
+	} else {
+		response["bandwidth"] = h.stats.GetBandwidth()
+	}
+	if h.cluster != nil {
+		response["cluster"] = h.clusterStatus(redact)
+	}
+	if redact {
+		response["connections"] = h.redactConnectionStats(h.upstreamManager.ConnectionStats())
+		response["circuit_breakers"] = h.redactBreakerStatus(h.upstreamManager.BreakerStatus())
+		response["request_budgets"] = h.redactBudgetStatus(h.upstreamManager.BudgetStatus())
+	} else {
+		response["connections"] = h.upstreamManager.ConnectionStats()
+		response["circuit_breakers"] = h.upstreamManager.BreakerStatus()
+		response["request_budgets"] = h.upstreamManager.BudgetStatus()
+		// Operator contacts would reveal the hidden hosts, so they are only shown unredacted
+		response["upstream_operators"] = h.upstreamManager.Operators()
+	}
+
+	// Anonymous visitors of non-public instances only get the aggregated totals
+	if !h.statusDetailsAllowed(r) {
+		response = map[string]interface{}{
+			"totals":        response["totals"],
+			"healthy_count": healthyCount,
+			"total_servers": len(allStats),
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
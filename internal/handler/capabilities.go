@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/girino/blossom_espelhator/internal/upstream"
+)
+
+// HandleCapabilities handles GET /admin/capabilities: the matrix of upstream servers and
+// the capabilities currently known for each (configured, auto-detected or observed)
+// It reveals the upstream setup, so it needs full status access (see status_access)
+func (h *BlossomHandler) HandleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireStatusDetails(w, r) {
+		return
+	}
+
+	matrix := h.upstreamManager.CapabilityMatrix()
+	if h.redactUpstreams(r) {
+		for i := range matrix {
+			matrix[i].Server = h.upstreamManager.ServerLabel(matrix[i].Server)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]upstream.ServerCapabilities{"servers": matrix})
+}
+
+// capabilityBadges returns a server's capabilities for the dashboard, keyed by server URL
+func (h *BlossomHandler) capabilityBadges() map[string][]CapabilityBadge {
+	badges := make(map[string][]CapabilityBadge)
+	for _, row := range h.upstreamManager.CapabilityMatrix() {
+		badges[row.Server] = []CapabilityBadge{
+			{Name: "mirror", State: row.Mirror},
+			{Name: "upload HEAD", State: row.UploadHead},
+			{Name: "media", State: row.Media},
+			{Name: "list", State: row.List},
+			{Name: "delete", State: row.Delete},
+			{Name: "range", State: row.Range},
+		}
+	}
+	return badges
+}
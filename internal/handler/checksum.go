@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// checksumHeader carries the SHA-256 of an upload body, as a request header or as a
+// trailer announced with "Trailer: X-SHA-256" and sent after a chunked body
+const checksumHeader = "X-SHA-256"
+
+// errChecksumMismatch is returned by checksumReader when the body doesn't match the
+// announced checksum
+var errChecksumMismatch = errors.New("body does not match " + checksumHeader)
+
+// errHashNotAuthorized is returned by checksumReader when the authorization event has x
+// tags and the body's hash isn't one of them (BUD-02)
+var errHashNotAuthorized = errors.New("blob hash does not match the authorization event's x tags")
+
+// errHashBlocked is returned by checksumReader when the body's hash is on the blocklist
+var errHashBlocked = errors.New("blob is blocked")
+
+// errSizeMismatch is returned by checksumReader when the body's size differs from the size
+// announced in X-Content-Length or in the upload's preflight
+var errSizeMismatch = errors.New("body size does not match the announced size")
+
+// checksumReader verifies an upload body against its X-SHA-256 header or trailer, against
+// the x tags of its authorization event and against the blocklist, as the body ends.
+// The last byte read is held back until the checksum matches, so upstreams
+// never receive a complete blob that doesn't: on mismatch, reading fails instead of
+// ending, which aborts the upstream uploads like a client disconnect
+type checksumReader struct {
+	body    io.Reader
+	sum     hash.Hash // Hash of everything read from body, updated by the caller
+	request *http.Request
+	header  string                 // Checksum from the request header ("" to use the trailer)
+	allowed map[string]bool        // Hashes allowed by the authorization event's x tags (empty: any)
+	blocked func(hash string) bool // Reports blocked hashes (nil: none)
+	size    int64                  // Size the body must have (-1: any)
+	enabled bool                   // A checksum or size was announced or the hash is restricted
+
+	read    int64 // Bytes read from body
+	last    byte  // Byte held back from the previous read
+	holding bool
+	err     error
+}
+
+// newChecksumReader wraps body, whose bytes are written to sum as they are read
+// allowed restricts the body's hash to the authorization event's x tags (see authorizedHashes),
+// blocked rejects the hashes on the blocklist (see blockedHashes) and size is the size the
+// body must have (see expectedUploadSize)
+func newChecksumReader(r *http.Request, body io.Reader, sum hash.Hash, allowed map[string]bool, blocked func(hash string) bool, size int64) *checksumReader {
+	header := strings.ToLower(strings.TrimSpace(r.Header.Get(checksumHeader)))
+	_, announced := r.Trailer[http.CanonicalHeaderKey(checksumHeader)]
+	return &checksumReader{
+		body:    body,
+		sum:     sum,
+		request: r,
+		header:  header,
+		allowed: allowed,
+		blocked: blocked,
+		size:    size,
+		enabled: header != "" || announced || len(allowed) > 0 || blocked != nil || size >= 0,
+	}
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	if !c.enabled {
+		return c.body.Read(p)
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	n := 0
+	if c.holding {
+		p[0] = c.last
+		n = 1
+		c.holding = false
+	}
+	m, err := c.body.Read(p[n:])
+	n += m
+	c.read += int64(m)
+	if c.size >= 0 && c.read > c.size {
+		c.err = fmt.Errorf("%w: body is longer than %d bytes", errSizeMismatch, c.size)
+		return 0, c.err
+	}
+
+	switch {
+	case err == io.EOF:
+		if verifyErr := c.verify(); verifyErr != nil {
+			c.err = verifyErr
+			return 0, verifyErr
+		}
+		return n, io.EOF
+	case err != nil:
+		return n, err
+	case n > 0:
+		c.last = p[n-1]
+		c.holding = true
+		return n - 1, nil
+	}
+	return 0, nil
+}
+
+// verify compares the body's size with the announced one, then its hash with the header,
+// or the trailer that arrived with the end of the body (no trailer sent: nothing to
+// compare), then with the allowed hashes and the blocklist
+func (c *checksumReader) verify() error {
+	if c.size >= 0 && c.read != c.size {
+		return fmt.Errorf("%w: body is %d bytes, %d were announced", errSizeMismatch, c.read, c.size)
+	}
+	calculated := hex.EncodeToString(c.sum.Sum(nil))
+	expected := c.header
+	if expected == "" {
+		expected = strings.ToLower(strings.TrimSpace(c.request.Trailer.Get(checksumHeader)))
+	}
+	if expected != "" && expected != calculated {
+		return fmt.Errorf("%w: expected %s, body hashes to %s", errChecksumMismatch, expected, calculated)
+	}
+	if len(c.allowed) > 0 && !c.allowed[calculated] {
+		return fmt.Errorf("%w: body hashes to %s", errHashNotAuthorized, calculated)
+	}
+	if c.blocked != nil && c.blocked(calculated) {
+		return fmt.Errorf("%w: %s", errHashBlocked, calculated)
+	}
+	return nil
+}
+
+// mismatch returns the verification error if the body didn't match its checksum, its
+// announced size or the authorization event's x tags, or is blocked
+func (c *checksumReader) mismatch() error {
+	if errors.Is(c.err, errChecksumMismatch) || errors.Is(c.err, errHashNotAuthorized) || errors.Is(c.err, errHashBlocked) ||
+		errors.Is(c.err, errSizeMismatch) {
+		return c.err
+	}
+	return nil
+}
+
+// authorizedHashes returns the hashes an authorization event's x tags allow (nil if the
+// event has none, or no event is required)
+func authorizedHashes(event *nostr.Event) map[string]bool {
+	if event == nil {
+		return nil
+	}
+	var allowed map[string]bool
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == "x" {
+			if allowed == nil {
+				allowed = make(map[string]bool)
+			}
+			allowed[strings.ToLower(strings.TrimSpace(tag[1]))] = true
+		}
+	}
+	return allowed
+}
+
+// authorizedChecksumHeader rejects with 403 an upload whose X-SHA-256 header isn't one of
+// the authorization event's x tags, before any of the body is sent upstream
+// Returns true if the upload may proceed
+func authorizedChecksumHeader(w http.ResponseWriter, r *http.Request, allowed map[string]bool, logPrefix string) bool {
+	header := strings.ToLower(strings.TrimSpace(r.Header.Get(checksumHeader)))
+	if header == "" || len(allowed) == 0 || allowed[header] {
+		return true
+	}
+	logging.Warnf(r.Context(), "%s: rejecting upload of %s from %s: not in the authorization event's x tags", logPrefix, header, r.RemoteAddr)
+	rejectMismatch(w, r, fmt.Errorf("%w: %s", errHashNotAuthorized, header))
+	return false
+}
+
+// validChecksumHeader rejects with 400 an upload whose X-SHA-256 header isn't a SHA-256
+// hex digest. Returns true if the upload may proceed
+func validChecksumHeader(w http.ResponseWriter, r *http.Request, logPrefix string) bool {
+	header := strings.TrimSpace(r.Header.Get(checksumHeader))
+	if header == "" || isValidHash(header) {
+		return true
+	}
+	logging.Warnf(r.Context(), "%s: rejecting upload from %s with invalid %s header %q", logPrefix, r.RemoteAddr, checksumHeader, header)
+	rejectChecksum(w, r, "Invalid "+checksumHeader+" header")
+	return false
+}
+
+// rejectChecksum answers 400 to an upload whose checksum is invalid or doesn't match
+func rejectChecksum(w http.ResponseWriter, r *http.Request, reason string) {
+	setCORSHeaders(w, r)
+	w.Header().Set("X-Reason", reason)
+	http.Error(w, reason, http.StatusBadRequest)
+}
+
+// rejectMismatch answers an upload whose body failed verification: 403 if the
+// authorization event doesn't cover its hash or it is blocked, 400 if it doesn't match its
+// checksum or announced size
+func rejectMismatch(w http.ResponseWriter, r *http.Request, err error) {
+	if !errors.Is(err, errHashNotAuthorized) && !errors.Is(err, errHashBlocked) {
+		rejectChecksum(w, r, err.Error())
+		return
+	}
+	setCORSHeaders(w, r)
+	w.Header().Set("X-Reason", err.Error())
+	http.Error(w, err.Error(), http.StatusForbidden)
+}
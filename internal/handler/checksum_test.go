@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/testutil"
+)
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// trailerBody sets the request's X-SHA-256 trailer once the body was read, like net/http
+// does for a chunked body
+type trailerBody struct {
+	io.Reader
+	r     *http.Request
+	value string
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		b.r.Trailer.Set(checksumHeader, b.value)
+	}
+	return n, err
+}
+
+// readChecksummed reads body through a checksumReader and returns what was read and the
+// verification error
+func readChecksummed(r *http.Request, body io.Reader, size int64) (string, error) {
+	sum := sha256.New()
+	c := newChecksumReader(r, io.TeeReader(body, sum), sum, nil, nil, size)
+	data, err := io.ReadAll(c)
+	if err == nil {
+		err = c.mismatch()
+	}
+	return string(data), err
+}
+
+func TestChecksumReader(t *testing.T) {
+	const blob = "checksummed body"
+
+	tests := []struct {
+		name    string
+		header  string
+		trailer string
+		size    int64
+		err     error
+	}{
+		{"no checksum", "", "", -1, nil},
+		{"matching header", sha256Hex(blob), "", -1, nil},
+		{"uppercase header", strings.ToUpper(sha256Hex(blob)), "", -1, nil},
+		{"mismatching header", sha256Hex("other"), "", -1, errChecksumMismatch},
+		{"matching trailer", "", sha256Hex(blob), -1, nil},
+		{"mismatching trailer", "", sha256Hex("other"), -1, errChecksumMismatch},
+		{"announced size", "", "", int64(len(blob)), nil},
+		{"truncated body", "", "", int64(len(blob)) + 1, errSizeMismatch},
+		{"body too long", "", "", int64(len(blob)) - 1, errSizeMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/upload", nil)
+			if tt.header != "" {
+				r.Header.Set(checksumHeader, tt.header)
+			}
+			var body io.Reader = strings.NewReader(blob)
+			if tt.trailer != "" {
+				r.Trailer = http.Header{http.CanonicalHeaderKey(checksumHeader): nil}
+				body = &trailerBody{Reader: body, r: r, value: tt.trailer}
+			}
+			data, err := readChecksummed(r, body, tt.size)
+			if !errors.Is(err, tt.err) || (tt.err == nil) != (err == nil) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			// A body failing verification never reaches its end
+			if tt.err != nil && data == blob {
+				t.Error("the whole body was read before the mismatch was reported")
+			}
+			if tt.err == nil && data != blob {
+				t.Errorf("read %q, want %q", data, blob)
+			}
+		})
+	}
+}
+
+func TestChecksumReaderRejectsHashes(t *testing.T) {
+	const blob = "restricted body"
+	r := httptest.NewRequest(http.MethodPut, "/upload", nil)
+
+	for _, tt := range []struct {
+		name    string
+		allowed map[string]bool
+		blocked func(string) bool
+		err     error
+	}{
+		{"allowed", map[string]bool{sha256Hex(blob): true}, nil, nil},
+		{"not in x tags", map[string]bool{sha256Hex("other"): true}, nil, errHashNotAuthorized},
+		{"blocked", nil, func(hash string) bool { return hash == sha256Hex(blob) }, errHashBlocked},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sum := sha256.New()
+			c := newChecksumReader(r, io.TeeReader(strings.NewReader(blob), sum), sum, tt.allowed, tt.blocked, -1)
+			io.ReadAll(c)
+			if err := c.mismatch(); !errors.Is(err, tt.err) || (tt.err == nil) != (err == nil) {
+				t.Errorf("mismatch = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestUploadChecksumMismatch(t *testing.T) {
+	started := make(chan struct{}, 4)
+	srv, uploads := testutil.UploadingUpstream(t, started)
+	h := newTestHandler(t, "", srv.URL)
+
+	t.Run("invalid header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/upload", strings.NewReader("body"))
+		req.Header.Set(checksumHeader, "not-a-hash")
+		rec := httptest.NewRecorder()
+		h.HandleUpload(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/upload", strings.NewReader(strings.Repeat("truncated? ", 1000)))
+		req.Header.Set(checksumHeader, sha256Hex("the real blob"))
+		rec := httptest.NewRecorder()
+		h.HandleUpload(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d (%s), want 400", rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Header().Get("X-Reason"), checksumHeader) {
+			t.Errorf("X-Reason = %q, want the checksum mismatch", rec.Header().Get("X-Reason"))
+		}
+		// The upload may have been aborted before reaching the upstream at all
+		select {
+		case upload := <-uploads:
+			if upload.Complete {
+				t.Error("upstream received the complete mismatching body")
+			}
+		case <-time.After(time.Second):
+		}
+	})
+}
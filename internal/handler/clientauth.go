@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/girino/blossom_espelhator/internal/auth"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// authVerbs maps the endpoints that can require authentication (see auth_endpoints) to
+// the BUD-01 verb their authorization event must carry in its "t" tag
+// Mirror requests use upload events (BUD-04)
+var authVerbs = map[string]string{
+	"upload": "upload",
+	"mirror": "upload",
+	"delete": "delete",
+	"list":   "list",
+	"get":    "get",
+}
+
+// authorize validates the Nostr authorization event of a request if the endpoint requires
+// authentication, answering 401/403 with an X-Reason header if it is invalid
+// Returns the validated event (nil if the endpoint doesn't require authentication) and
+// whether the request may proceed
+func (h *BlossomHandler) authorize(w http.ResponseWriter, r *http.Request, endpoint string, logPrefix string) (*nostr.Event, bool) {
+	if !h.authEndpoints[endpoint] {
+		return nil, true
+	}
+
+	event, err := auth.Authenticate(r, authVerbs[endpoint], h.allowedPubkeys, h.authVerbose.Enabled())
+	if err != nil {
+		h.rejectAuth(w, r, err, logPrefix)
+		return nil, false
+	}
+
+	h.authVerbose.Debugf(r.Context(), "%s: authenticated pubkey %s (event with %d tags)", logPrefix, event.PubKey, len(event.Tags))
+	return event, true
+}
+
+// authorizeDelete checks the authorization event of a delete before it is forwarded
+// Every BUD-02 server requires one with a "delete" verb and an x tag matching the hash, so
+// even when delete isn't in auth_endpoints a missing or invalid event (of any pubkey) is
+// rejected here rather than by each upstream: 401, or 403 if it doesn't cover the hash
+// Returns whether the delete may be forwarded
+func (h *BlossomHandler) authorizeDelete(w http.ResponseWriter, r *http.Request, hash string, logPrefix string) bool {
+	event, ok := h.authorize(w, r, "delete", logPrefix)
+	if !ok {
+		return false
+	}
+	if event == nil {
+		var err error
+		event, err = auth.Authenticate(r, authVerbs["delete"], nil, h.authVerbose.Enabled())
+		if err != nil {
+			h.rejectAuth(w, r, err, logPrefix)
+			return false
+		}
+	}
+
+	if !authorizedHashes(event)[hash] {
+		h.rejectAuth(w, r, &auth.AuthError{Reason: "Authorization event must have an \"x\" tag with the blob hash", Code: http.StatusForbidden}, logPrefix)
+		return false
+	}
+	return true
+}
+
+// rejectAuth answers a request whose authorization event is invalid with the error's status
+// code (401 by default) and an X-Reason header
+func (h *BlossomHandler) rejectAuth(w http.ResponseWriter, r *http.Request, err error, logPrefix string) {
+	reason, code := err.Error(), http.StatusUnauthorized
+	if authErr, ok := err.(*auth.AuthError); ok {
+		code = authErr.Code
+	}
+	h.authVerbose.Debugf(r.Context(), "%s: authentication failed: %s", logPrefix, reason)
+	setCORSHeaders(w, r)
+	w.Header().Set("X-Reason", reason)
+	http.Error(w, reason, code)
+}
+
+// List access modes (server.list_access)
+const (
+	listAccessPublic = "public" // Authenticated only if list is in auth_endpoints
+	listAccessAuth   = "auth"   // Always requires a list authorization event
+	listAccessOwner  = "owner"  // Requires one from the listed pubkey or an admin (status_pubkeys, admin_pubkeys)
+)
+
+// authorizeList validates the authorization event of a list request according to
+// list_access, answering 401/403 with an X-Reason header if the request may not proceed
+// With "auth" and "owner" an event is required even if list isn't in auth_endpoints, and
+// admins (status_pubkeys and admin_pubkeys) are accepted whether or not they are in allowed_pubkeys
+// Returns whether the list may be forwarded
+// pubkey is the listed pubkey, normalized to lowercase hex
+func (h *BlossomHandler) authorizeList(w http.ResponseWriter, r *http.Request, pubkey string, logPrefix string) bool {
+	if h.config.Server.ListAccess != listAccessAuth && h.config.Server.ListAccess != listAccessOwner {
+		_, ok := h.authorize(w, r, "list", logPrefix)
+		return ok
+	}
+
+	event, err := auth.Authenticate(r, authVerbs["list"], nil, h.authVerbose.Enabled())
+	if err != nil {
+		h.rejectAuth(w, r, err, logPrefix)
+		return false
+	}
+	signer := strings.ToLower(event.PubKey)
+	if h.statusPubkeys[signer] || h.adminPubkeys[signer] {
+		return true
+	}
+
+	if h.config.Server.ListAccess == listAccessOwner {
+		if pubkey != signer {
+			h.rejectAuth(w, r, &auth.AuthError{Reason: "Only the owner of the list or an admin may list its blobs", Code: http.StatusForbidden}, logPrefix)
+			return false
+		}
+		return true
+	}
+	if len(h.allowedPubkeys) > 0 && !h.allowedPubkeys[signer] {
+		h.rejectAuth(w, r, &auth.AuthError{Reason: "Pubkey not in allowed list", Code: http.StatusForbidden}, logPrefix)
+		return false
+	}
+	return true
+}
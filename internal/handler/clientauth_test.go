@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/girino/blossom_espelhator/internal/auth"
+	"github.com/girino/blossom_espelhator/internal/testutil"
+)
+
+// authHeader returns an Authorization header for verb and hash signed with secretKey
+func authHeader(t *testing.T, secretKey string, verb string, hash string) string {
+	t.Helper()
+	header, err := auth.SignAuthorization(secretKey, verb, hash, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+// authHeaderWithTags returns an Authorization header for an event with the given tags
+// (besides an expiration) signed with secretKey
+func authHeaderWithTags(t *testing.T, secretKey string, tags ...nostr.Tag) string {
+	t.Helper()
+	event := nostr.Event{
+		Kind:      24242,
+		CreatedAt: nostr.Now(),
+		Tags:      append(nostr.Tags{{"expiration", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)}}, tags...),
+	}
+	if err := event.Sign(secretKey); err != nil {
+		t.Fatal(err)
+	}
+	eventJSON, _ := json.Marshal(event)
+	return "Nostr " + base64.StdEncoding.EncodeToString(eventJSON)
+}
+
+// countingUpstream starts a fake upstream answering like testutil.AnsweringUpstream and
+// counting the requests it received
+func countingUpstream(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	answering := testutil.AnsweringUpstream(t)
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		answering.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestUploadAuthEndpoint(t *testing.T) {
+	srv, requests := countingUpstream(t)
+	secretKey := nostr.GeneratePrivateKey()
+	otherKey := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(secretKey)
+	h := newTestHandler(t, "  auth_endpoints: [upload]\n  allowed_pubkeys: ["+pubkey+"]\n", srv.URL)
+
+	blob := []byte("authenticated upload")
+	sum := sha256.Sum256(blob)
+	hash := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name          string
+		authorization string
+		code          int
+	}{
+		{"no authorization", "", http.StatusUnauthorized},
+		{"wrong verb", authHeader(t, secretKey, "delete", hash), http.StatusUnauthorized},
+		{"pubkey not allowed", authHeader(t, otherKey, "upload", hash), http.StatusForbidden},
+		{"valid", authHeader(t, secretKey, "upload", hash), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := requests.Load()
+			req := httptest.NewRequest(http.MethodPut, "/upload", strings.NewReader(string(blob)))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			h.HandleUpload(rec, req)
+
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d (%s), want %d", rec.Code, rec.Body.String(), tt.code)
+			}
+			if tt.code == http.StatusOK {
+				return
+			}
+			if requests.Load() > before {
+				t.Error("rejected upload was forwarded to the upstream")
+			}
+			if rec.Header().Get("X-Reason") == "" {
+				t.Error("rejection without X-Reason")
+			}
+		})
+	}
+}
+
+func TestUploadWithoutAuthEndpoint(t *testing.T) {
+	srv := testutil.AnsweringUpstream(t)
+	h := newTestHandler(t, "", srv.URL)
+
+	req := httptest.NewRequest(http.MethodPut, "/upload", strings.NewReader("anonymous upload"))
+	rec := httptest.NewRecorder()
+	h.HandleUpload(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d (%s), want anonymous uploads accepted when upload isn't in auth_endpoints", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUploadXTags(t *testing.T) {
+	started := make(chan struct{}, 8)
+	srv, uploads := testutil.UploadingUpstream(t, started)
+	secretKey := nostr.GeneratePrivateKey()
+	h := newTestHandler(t, "  auth_endpoints: [upload]\n", srv.URL)
+
+	blob := "blob covered by x tags"
+	hash := sha256Hex(blob)
+	other := sha256Hex("another blob")
+
+	tests := []struct {
+		name          string
+		authorization string
+		checksum      string // X-SHA-256 header
+		code          int
+		reachesServer bool // Some of the body may be sent before the hash is known
+	}{
+		{"matching x tag", authHeader(t, secretKey, "upload", hash), "", http.StatusOK, true},
+		{"one of several x tags", authHeaderWithTags(t, secretKey, nostr.Tag{"t", "upload"}, nostr.Tag{"x", other}, nostr.Tag{"x", hash}), "", http.StatusOK, true},
+		{"no x tag", authHeaderWithTags(t, secretKey, nostr.Tag{"t", "upload"}), "", http.StatusOK, true},
+		{"body not in x tags", authHeader(t, secretKey, "upload", other), "", http.StatusForbidden, true},
+		{"announced hash not in x tags", authHeader(t, secretKey, "upload", other), hash, http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/upload", strings.NewReader(blob))
+			req.Header.Set("Authorization", tt.authorization)
+			if tt.checksum != "" {
+				req.Header.Set(checksumHeader, tt.checksum)
+			}
+			rec := httptest.NewRecorder()
+			h.HandleUpload(rec, req)
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d (%s), want %d", rec.Code, rec.Body.String(), tt.code)
+			}
+
+			select {
+			case upload := <-uploads:
+				if !tt.reachesServer {
+					t.Error("upload rejected up front reached the upstream")
+				}
+				if tt.code != http.StatusOK && upload.Complete {
+					t.Error("upstream received the complete body of a rejected upload")
+				}
+			case <-time.After(time.Second):
+				if tt.code == http.StatusOK {
+					t.Error("accepted upload never reached the upstream")
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteAuthorization(t *testing.T) {
+	hash := sha256Hex("blob to delete")
+	secretKey := nostr.GeneratePrivateKey()
+	otherKey := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(secretKey)
+
+	tests := []struct {
+		name          string
+		extra         string // Server settings
+		authorization string
+		code          int
+	}{
+		{"no authorization", "", "", http.StatusUnauthorized},
+		{"wrong verb", "", authHeader(t, secretKey, "upload", hash), http.StatusUnauthorized},
+		{"other hash", "", authHeader(t, secretKey, "delete", sha256Hex("another blob")), http.StatusForbidden},
+		{"no x tag", "", authHeaderWithTags(t, secretKey, nostr.Tag{"t", "delete"}), http.StatusForbidden},
+		{"valid", "", authHeader(t, secretKey, "delete", hash), http.StatusNoContent},
+		{"any pubkey when delete isn't in auth_endpoints", "  auth_endpoints: [upload]\n  allowed_pubkeys: [" + pubkey + "]\n", authHeader(t, otherKey, "delete", hash), http.StatusNoContent},
+		{"pubkey not allowed", "  auth_endpoints: [delete]\n  allowed_pubkeys: [" + pubkey + "]\n", authHeader(t, otherKey, "delete", hash), http.StatusForbidden},
+		{"allowed pubkey", "  auth_endpoints: [delete]\n  allowed_pubkeys: [" + pubkey + "]\n", authHeader(t, secretKey, "delete", hash), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := countingUpstream(t)
+			h := newTestHandler(t, tt.extra, srv.URL)
+
+			req := httptest.NewRequest(http.MethodDelete, "/"+hash, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			h.HandleDelete(rec, req)
+
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d (%s), want %d", rec.Code, rec.Body.String(), tt.code)
+			}
+			if tt.code == http.StatusNoContent {
+				if requests.Load() == 0 {
+					t.Error("valid delete was not forwarded")
+				}
+				return
+			}
+			if requests.Load() != 0 {
+				t.Error("rejected delete was forwarded to the upstream")
+			}
+			if rec.Header().Get("X-Reason") == "" {
+				t.Error("rejection without X-Reason")
+			}
+		})
+	}
+}
+
+func TestListAccess(t *testing.T) {
+	ownerKey := nostr.GeneratePrivateKey()
+	owner, _ := nostr.GetPublicKey(ownerKey)
+	adminKey := nostr.GeneratePrivateKey()
+	admin, _ := nostr.GetPublicKey(adminKey)
+	allowedKey := nostr.GeneratePrivateKey()
+	allowed, _ := nostr.GetPublicKey(allowedKey)
+	strangerKey := nostr.GeneratePrivateKey()
+
+	tests := []struct {
+		name          string
+		extra         string // Server settings
+		authorization string
+		code          int // 0: forwarded to the upstream
+	}{
+		{"public", "", "", 0},
+		{"public with list in auth_endpoints", "  auth_endpoints: [list]\n", "", http.StatusUnauthorized},
+		{"auth without event", "  list_access: auth\n", "", http.StatusUnauthorized},
+		{"auth with wrong verb", "  list_access: auth\n", authHeader(t, strangerKey, "upload", ""), http.StatusUnauthorized},
+		{"auth with any pubkey", "  list_access: auth\n", authHeader(t, strangerKey, "list", ""), 0},
+		{"auth with pubkey not allowed", "  list_access: auth\n  auth_endpoints: []\n  allowed_pubkeys: [" + allowed + "]\n", authHeader(t, strangerKey, "list", ""), http.StatusForbidden},
+		{"auth with allowed pubkey", "  list_access: auth\n  auth_endpoints: []\n  allowed_pubkeys: [" + allowed + "]\n", authHeader(t, allowedKey, "list", ""), 0},
+		{"owner", "  list_access: owner\n", authHeader(t, ownerKey, "list", ""), 0},
+		{"owner with another pubkey", "  list_access: owner\n", authHeader(t, strangerKey, "list", ""), http.StatusForbidden},
+		{"owner with admin", "  list_access: owner\n  admin_pubkeys: [" + admin + "]\n", authHeader(t, adminKey, "list", ""), 0},
+		{"owner with status pubkey", "  list_access: owner\n  status_pubkeys: [" + admin + "]\n", authHeader(t, adminKey, "list", ""), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := countingUpstream(t)
+			h := newTestHandler(t, tt.extra, srv.URL)
+
+			req := httptest.NewRequest(http.MethodGet, "/list/"+owner, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			h.HandleList(rec, req)
+
+			if tt.code == 0 {
+				if requests.Load() == 0 {
+					t.Errorf("list was not forwarded (status %d: %s)", rec.Code, rec.Body.String())
+				}
+				return
+			}
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d (%s), want %d", rec.Code, rec.Body.String(), tt.code)
+			}
+			if requests.Load() != 0 {
+				t.Error("rejected list was forwarded to the upstream")
+			}
+			if rec.Header().Get("X-Reason") == "" {
+				t.Error("rejection without X-Reason")
+			}
+		})
+	}
+}
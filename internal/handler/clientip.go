@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// clientIPHeaders are request headers carrying the client's (or a proxy chain's) IP address
+// Keys are in canonical form, as in http.Header
+var clientIPHeaders = []string{
+	"X-Forwarded-For",
+	"X-Real-Ip",
+	"Forwarded",
+	"Cf-Connecting-Ip",
+	"True-Client-Ip",
+	"X-Client-Ip",
+}
+
+// applyClientIPHeaders decides what upstream servers learn about the client's IP address
+// Headers copied from the client request that carry IP addresses are always dropped; with
+// forward_client_ip, X-Forwarded-For is rebuilt as the incoming chain plus the address of
+// the connection (the standard append), otherwise upstreams only see the proxy's address
+func (h *BlossomHandler) applyClientIPHeaders(headers map[string]string, r *http.Request) {
+	for _, key := range clientIPHeaders {
+		delete(headers, key)
+	}
+	if !h.config.Server.ForwardClientIP {
+		return
+	}
+
+	clientIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		clientIP = host
+	}
+	chain := r.Header.Values("X-Forwarded-For")
+	headers["X-Forwarded-For"] = strings.Join(append(chain, clientIP), ", ")
+	h.verbose.Debugf(r.Context(), "applyClientIPHeaders: forwarding X-Forwarded-For: %s", headers["X-Forwarded-For"])
+}
+
+// newTrustedProxies returns the parsed trusted_proxies ranges (nil if none are valid)
+func newTrustedProxies(entries []string) []netip.Prefix {
+	prefixes, err := config.ParseTrustedProxies(entries)
+	if err != nil {
+		logging.Warnf(context.Background(), "BlossomHandler: ignoring trusted_proxies: %v", err)
+		return nil
+	}
+	return prefixes
+}
+
+// isTrustedProxy reports whether addr belongs to a reverse proxy listed in trusted_proxies
+func (h *BlossomHandler) isTrustedProxy(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range h.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// realClientAddress returns the IP address of the client behind the request: the address
+// of the connection, unless it is a trusted proxy (see trusted_proxies). Then it is the
+// last X-Forwarded-For entry that isn't a trusted proxy itself (entries left of it may be
+// forged by the client), or X-Real-IP if the chain has none
+func (h *BlossomHandler) realClientAddress(r *http.Request) string {
+	addr := clientAddress(r)
+	if len(h.trustedProxies) == 0 || !h.isTrustedProxy(addr) {
+		return addr
+	}
+
+	var chain []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		chain = append(chain, strings.Split(value, ",")...)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(chain[i]))
+		if err != nil {
+			break
+		}
+		if !h.isTrustedProxy(ip.String()) {
+			return ip.Unmap().String()
+		}
+	}
+	if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-Ip"))); err == nil {
+		return ip.Unmap().String()
+	}
+	return addr
+}
@@ -0,0 +1,18 @@
+package handler
+
+import (
+	"github.com/girino/blossom_espelhator/internal/cluster"
+)
+
+// clusterStatus returns the cluster section of /stats; with redaction, peer addresses are
+// omitted and the summed counters are keyed by server label
+func (h *BlossomHandler) clusterStatus(redact bool) cluster.Status {
+	status := h.cluster.Status(h.stats.GetAll())
+	if redact {
+		for i := range status.Peers {
+			status.Peers[i].URL = ""
+		}
+		status.Servers = h.redactServerStats(status.Servers)
+	}
+	return status
+}
@@ -0,0 +1,358 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/activity"
+	"github.com/girino/blossom_espelhator/internal/journal"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/upstream"
+)
+
+// noHealthyUpstreams reports whether every upstream server is unhealthy for an operation
+func (h *BlossomHandler) noHealthyUpstreams(opType string) bool {
+	return h.stats.GetHealthyCountFor(opType) == 0
+}
+
+// writeDegraded answers with a 503 JSON error explaining that no upstream is available
+func (h *BlossomHandler) writeDegraded(w http.ResponseWriter, r *http.Request, opType string, logPrefix string) {
+	logging.Warnf(r.Context(), "%s: no healthy upstream servers for %s, returning 503", logPrefix, opType)
+
+	retryAfter := int(h.config.Server.DegradedRetryAfter.Seconds())
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	setCORSHeaders(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.Header().Set("X-Reason", "No healthy upstream servers")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":           "no healthy upstream servers",
+		"operation":       opType,
+		"degraded":        true,
+		"healthy_servers": 0,
+		"total_servers":   len(h.upstreamManager.GetServerURLs()),
+		"retry_after":     retryAfter,
+	})
+}
+
+// isClientError reports whether an upload error is the client's fault (4xx from upstream),
+// in which case it is passed through even when upstreams are unhealthy
+func isClientError(err error) bool {
+	uploadErr, ok := err.(*upstream.UploadError)
+	return ok && uploadErr.StatusCode >= 400 && uploadErr.StatusCode < 500
+}
+
+// errQueueFull is returned by queuedUploads.store when the queue holds its maximum size
+var errQueueFull = errors.New("upload queue is full")
+
+// queuedUploads stores blobs uploaded while no upstream was healthy until the
+// journal has replayed them to the upstream servers
+type queuedUploads struct {
+	dir      string
+	maxBytes int64 // Total size of the queued blobs (negative: unlimited)
+
+	mu   sync.Mutex
+	used int64 // Bytes of the queued blobs and of those being received
+}
+
+// newQueuedUploads creates the queue directory, counting the blobs left in it by a previous run
+func newQueuedUploads(dir string, maxBytes int64) (*queuedUploads, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create queued upload directory: %w", err)
+	}
+	q := &queuedUploads{dir: dir, maxBytes: maxBytes}
+	for _, hash := range q.staleHashes(0) {
+		if info, err := os.Stat(q.blobPath(hash)); err == nil {
+			q.used += info.Size()
+		}
+	}
+	return q, nil
+}
+
+// reserve counts n more bytes as queued, unless that would exceed the maximum size
+func (q *queuedUploads) reserve(n int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.maxBytes >= 0 && q.used+n > q.maxBytes {
+		return false
+	}
+	q.used += n
+	return true
+}
+
+// release stops counting n bytes as queued
+func (q *queuedUploads) release(n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.used -= n
+}
+
+// fits reports whether a blob of size bytes (-1 if unknown) fits in the queue right now
+func (q *queuedUploads) fits(size int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.maxBytes < 0 || q.used+max(size, 0) <= q.maxBytes
+}
+
+// reservingWriter reserves queue space for the bytes written through it
+type reservingWriter struct {
+	queue    *queuedUploads
+	w        io.Writer
+	reserved int64
+}
+
+func (rw *reservingWriter) Write(p []byte) (int, error) {
+	if !rw.queue.reserve(int64(len(p))) {
+		return 0, errQueueFull
+	}
+	rw.reserved += int64(len(p))
+	return rw.w.Write(p)
+}
+
+// blobPath returns the file holding a queued blob
+func (q *queuedUploads) blobPath(hash string) string {
+	return filepath.Join(q.dir, hash)
+}
+
+// typePath returns the sidecar file holding a queued blob's content type
+func (q *queuedUploads) typePath(hash string) string {
+	return filepath.Join(q.dir, hash+".type")
+}
+
+// store writes body to the queue under its SHA-256 and returns the hash and size
+func (q *queuedUploads) store(body io.Reader, contentType string) (string, int64, error) {
+	tmp, err := os.CreateTemp(q.dir, "incoming-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	reserving := &reservingWriter{queue: q, w: io.MultiWriter(tmp, hasher)}
+	size, err := io.Copy(reserving, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		q.release(reserving.reserved)
+		return "", 0, err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if _, err := os.Stat(q.blobPath(hash)); err == nil {
+		// Already queued: the same blob replaces it
+		q.release(reserving.reserved)
+	}
+	if err := os.WriteFile(q.typePath(hash), []byte(contentType), 0o644); err != nil {
+		q.release(reserving.reserved)
+		return "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), q.blobPath(hash)); err != nil {
+		q.release(reserving.reserved)
+		return "", 0, err
+	}
+	return hash, size, nil
+}
+
+// open returns a queued blob, its size and content type
+func (q *queuedUploads) open(hash string) (*os.File, int64, string, error) {
+	f, err := os.Open(q.blobPath(hash))
+	if err != nil {
+		return nil, 0, "", err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, "", err
+	}
+	contentType, _ := os.ReadFile(q.typePath(hash))
+	return f, info.Size(), string(contentType), nil
+}
+
+// remove deletes a queued blob
+func (q *queuedUploads) remove(hash string) {
+	if info, err := os.Stat(q.blobPath(hash)); err == nil && os.Remove(q.blobPath(hash)) == nil {
+		q.release(info.Size())
+	}
+	os.Remove(q.typePath(hash))
+}
+
+// staleHashes lists queued blobs stored more than minAge ago
+// Recent blobs are skipped since their journal entries may not be recorded yet
+func (q *queuedUploads) staleHashes(minAge time.Duration) []string {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil
+	}
+	hashes := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !isValidHash(entry.Name()) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) >= minAge {
+			hashes = append(hashes, entry.Name())
+		}
+	}
+	return hashes
+}
+
+// queueUpload accepts an upload while no upstream is healthy: the blob is stored locally,
+// served from there, and uploaded to the targets by the journal once they recover
+func (h *BlossomHandler) queueUpload(w http.ResponseWriter, r *http.Request, targetURLs []string, headers map[string]string, expectedHash string, allowedHashes map[string]bool, expectedSize int64) {
+	if size := max(expectedSize, r.ContentLength); !h.queued.fits(size) {
+		logging.Warnf(r.Context(), "HandleUpload: upload queue is full (degraded_upload_max_bytes), rejecting %d bytes from %s", size, r.RemoteAddr)
+		h.writeDegraded(w, r, "upload", "HandleUpload")
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	hasher := sha256.New()
+	checksum := newChecksumReader(r, io.TeeReader(r.Body, hasher), hasher, allowedHashes, h.blockedHashes(), expectedSize)
+	hash, size, err := h.queued.store(checksum, contentType)
+	if mismatch := checksum.mismatch(); mismatch != nil {
+		logging.Warnf(r.Context(), "HandleUpload: rejecting upload from %s: %v", r.RemoteAddr, mismatch)
+		rejectMismatch(w, r, mismatch)
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		logging.Warnf(r.Context(), "HandleUpload: rejecting upload from %s: body exceeds max_upload_bytes (%d)", r.RemoteAddr, tooLarge.Limit)
+		h.writeTooLarge(w, r)
+		return
+	}
+	if errors.Is(err, errQueueFull) {
+		logging.Warnf(r.Context(), "HandleUpload: upload queue is full (degraded_upload_max_bytes), rejecting upload from %s", r.RemoteAddr)
+		h.writeDegraded(w, r, "upload", "HandleUpload")
+		return
+	}
+	if err != nil {
+		logging.Warnf(r.Context(), "HandleUpload: failed to queue upload from %s: %v", r.RemoteAddr, err)
+		h.writeDegraded(w, r, "upload", "HandleUpload")
+		return
+	}
+	if expectedHash != "" && expectedHash != hash {
+		logging.Warnf(r.Context(), "HandleUpload: declared hash %s does not match calculated hash %s", expectedHash, hash)
+	}
+
+	h.journalOperations(r.Context(), journal.KindUpload, hash, targetURLs, nil, headers, "HandleUpload")
+	h.stats.RecordReceived(size)
+	h.activity.Record(activity.Event{Operation: "upload", Hash: hash, Size: size, Outcome: activity.OutcomeQueued, Targets: len(targetURLs)})
+	logging.Warnf(r.Context(), "HandleUpload: no healthy upstream servers, queued %s (%d bytes) for %d servers", hash, size, len(targetURLs))
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	descriptor := map[string]interface{}{
+		"url":      h.constructLocalURL(hash, contentType, r),
+		"sha256":   hash,
+		"size":     size,
+		"type":     contentType,
+		"uploaded": time.Now().Unix(),
+	}
+
+	setCORSHeaders(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Reason", "Queued until upstream servers recover")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(descriptor)
+}
+
+// serveQueuedUpload answers a GET/HEAD request from a queued upload. Returns true if served
+func (h *BlossomHandler) serveQueuedUpload(w http.ResponseWriter, r *http.Request, path string, logPrefix string) bool {
+	if h.queued == nil {
+		return false
+	}
+	hash := strings.ToLower(path[:64])
+	f, size, contentType, err := h.queued.open(hash)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	h.verbose.Debugf(r.Context(), "%s: serving %s from queued uploads (%d bytes)", logPrefix, hash, size)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	setCORSHeaders(w, r)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hash+`"`)
+
+	// Range and conditional requests are honored like for the blob cache
+	cw := &countingResponseWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, "", time.Time{}, f)
+	h.stats.RecordServed(cw.n)
+	if r.Context().Err() != nil {
+		markClientAbort(w)
+	}
+	return true
+}
+
+// replayUpload uploads a queued blob to a server
+func (h *BlossomHandler) replayUpload(ctx context.Context, entry journal.Entry) error {
+	if h.queued == nil {
+		return fmt.Errorf("queued uploads are disabled")
+	}
+	if h.dropBlockedEntry(ctx, entry, "replayUpload") {
+		h.queued.remove(entry.Hash)
+		return nil
+	}
+	cl, err := h.upstreamManager.GetClient(entry.ServerURL)
+	if err != nil {
+		return err
+	}
+	defer h.cleanupQueuedUploads()
+
+	f, size, contentType, err := h.queued.open(entry.Hash)
+	if err != nil {
+		return fmt.Errorf("queued blob missing: %w", err)
+	}
+	defer f.Close()
+
+	headers := make(map[string]string, len(entry.Headers)+1)
+	for k, v := range entry.Headers {
+		headers[k] = v
+	}
+	headers["X-SHA-256"] = entry.Hash
+	if _, err := cl.Upload(ctx, f, contentType, size, headers); err != nil {
+		return err
+	}
+
+	h.stats.RecordSuccess(entry.ServerURL, "upload")
+	h.stats.RecordSentUpstream(entry.ServerURL, size)
+	h.upstreamManager.MarkUploaded(entry.Hash, []string{entry.ServerURL})
+
+	// The current entry is still pending until this returns
+	if h.journal.CountPending(journal.KindUpload, entry.Hash) <= 1 {
+		h.queued.remove(entry.Hash)
+		h.verbose.Debugf(ctx, "replayUpload: all queued uploads of %s done, removed local copy", entry.Hash)
+	}
+	return nil
+}
+
+// cleanupQueuedUploads removes queued blobs whose journal entries are gone
+// (completed elsewhere, expired or given up)
+func (h *BlossomHandler) cleanupQueuedUploads() {
+	if h.queued == nil {
+		return
+	}
+	for _, hash := range h.queued.staleHashes(time.Minute) {
+		if h.journal.CountPending(journal.KindUpload, hash) == 0 {
+			logging.Warnf(context.Background(), "Queued upload %s has no pending operations left, removing local copy", hash)
+			h.queued.remove(hash)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/journal"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/testutil"
+)
+
+func TestQueuedUploadsBounded(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newQueuedUploads(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, size, err := q.store(bytes.NewReader([]byte("123456")), "text/plain")
+	if err != nil || size != 6 {
+		t.Fatalf("store = %d bytes, %v, want 6 bytes queued", size, err)
+	}
+	// Unknown size: cut off once the queue is full
+	if _, _, err := q.store(io.MultiReader(bytes.NewReader([]byte("abcdef"))), ""); !errors.Is(err, errQueueFull) {
+		t.Fatalf("store past the limit = %v, want errQueueFull", err)
+	}
+	if q.fits(5) {
+		t.Error("5 more bytes fit in a queue of 10 holding 6")
+	}
+	if !q.fits(4) {
+		t.Error("4 more bytes don't fit in a queue of 10 holding 6, want the rejected upload's space released")
+	}
+
+	// Blobs left by a previous run count after a restart
+	restarted, err := newQueuedUploads(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restarted.fits(5) {
+		t.Error("queued blob not counted after a restart")
+	}
+
+	q.remove(hash)
+	if !q.fits(10) {
+		t.Error("removed blob still counted")
+	}
+}
+
+func TestQueueUploadFullQueue(t *testing.T) {
+	srv := testutil.AnsweringUpstream(t)
+	dir := t.TempDir()
+	cfg := testutil.LoadConfig(t, fmt.Sprintf("  min_upload_servers: 1\n  max_failures: 1\n  journal_path: %q\n"+
+		"  queue_uploads_when_degraded: true\n  degraded_upload_max_bytes: 10\n", filepath.Join(dir, "journal.db")), srv.URL)
+	pendingOps, err := journal.Open(cfg.Server.JournalPath, time.Minute, time.Minute, 1, time.Hour, logging.NewLevels(nil).Flag(logging.Handler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pendingOps.Close() })
+	h := newTestHandlerFor(t, cfg, pendingOps)
+	h.stats.RecordFailure(srv.URL, "upload")
+
+	for _, tc := range []struct {
+		body []byte
+		want int
+	}{
+		{[]byte("too large for the queue"), http.StatusServiceUnavailable},
+		{[]byte("fits"), http.StatusAccepted},
+		{[]byte("no room"), http.StatusServiceUnavailable},
+	} {
+		rec := httptest.NewRecorder()
+		h.HandleUpload(rec, httptest.NewRequest(http.MethodPut, "/upload", bytes.NewReader(tc.body)))
+		if rec.Code != tc.want {
+			t.Errorf("upload of %d bytes: status = %d, want %d", len(tc.body), rec.Code, tc.want)
+		}
+		if tc.want == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("upload of %d bytes: 503 without Retry-After", len(tc.body))
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/upstream"
+)
+
+// isDryRun reports whether an upload request asks for a dry run
+// (X-Dry-Run: true header or ?dry_run=1 query parameter)
+func isDryRun(r *http.Request) bool {
+	for _, value := range []string{r.Header.Get("X-Dry-Run"), r.URL.Query().Get("dry_run")} {
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "1", "true", "yes":
+			return true
+		}
+	}
+	return false
+}
+
+// handleDryRunUpload answers a dry-run PUT /upload without transferring the body
+// The blob is described by X-SHA-256, X-Content-Length (or Content-Length) and X-Content-Type
+// (or Content-Type). The targeted upstreams are asked with a BUD-06 preflight whether they
+// would accept it; servers without HEAD /upload support are assumed to accept. If enough
+// servers would accept, the descriptor the upload would produce is returned
+func (h *BlossomHandler) handleDryRunUpload(w http.ResponseWriter, r *http.Request, headers map[string]string) {
+	setCORSHeaders(w, r)
+	w.Header().Set("X-Dry-Run", "true")
+
+	hash := declaredHash(r)
+	if hash == "" {
+		w.Header().Set("X-Reason", "dry run requires X-SHA-256")
+		http.Error(w, "dry run requires X-SHA-256", http.StatusBadRequest)
+		return
+	}
+
+	var size int64 = -1
+	if xcl := r.Header.Get("X-Content-Length"); xcl != "" {
+		if parsed, err := strconv.ParseInt(xcl, 10, 64); err == nil && parsed >= 0 {
+			size = parsed
+		}
+	} else if r.ContentLength > 0 {
+		size = r.ContentLength
+	}
+	if size < 0 {
+		w.Header().Set("X-Reason", "dry run requires X-Content-Length")
+		http.Error(w, "dry run requires X-Content-Length", http.StatusBadRequest)
+		return
+	}
+
+	contentType := r.Header.Get("X-Content-Type")
+	if contentType == "" {
+		contentType = r.Header.Get("Content-Type")
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// Route like the upload would be: to a shard if sharding is configured, by content type and by size
+	targets := h.uploadTargets(w, hash, contentType, size)
+	targetURLs := h.upstreamManager.TargetServerURLs(targets)
+	minServers := h.upstreamManager.MinServersFor(targets)
+
+	// Forward the request headers as preflight headers, describing the blob explicitly
+	preflightHeaders := make(map[string]string, len(headers)+3)
+	for k, v := range headers {
+		if strings.EqualFold(k, "X-Dry-Run") {
+			continue
+		}
+		preflightHeaders[k] = v
+	}
+	preflightHeaders["X-SHA-256"] = hash
+	preflightHeaders["X-Content-Length"] = strconv.FormatInt(size, 10)
+	preflightHeaders["X-Content-Type"] = contentType
+
+	// Servers without HEAD /upload support can't be checked and are assumed to accept
+	wouldAccept := make([]string, 0, len(targetURLs))
+	checkable := 0
+	for _, serverURL := range targetURLs {
+		if h.upstreamManager.SupportsUploadHead(serverURL) {
+			checkable++
+		} else {
+			wouldAccept = append(wouldAccept, serverURL)
+		}
+	}
+
+	rejectStatus := 0
+	rejectReason := ""
+	if checkable > 0 {
+		// The error is ignored: acceptance is tallied below together with unchecked servers
+		results, _ := h.upstreamManager.UploadPreflightParallelTo(r.Context(), targets, preflightHeaders, h.config.Server.Timeout)
+		for _, result := range results {
+			if result.Accepted {
+				wouldAccept = append(wouldAccept, result.ServerURL)
+			} else if result.StatusCode > 0 && (rejectStatus == 0 || result.StatusCode < rejectStatus) {
+				rejectStatus = result.StatusCode
+				rejectReason = result.XReason
+			}
+		}
+	}
+
+	h.verbose.Debugf(r.Context(), "handleDryRunUpload: %s (%d bytes, %s) would be accepted by %d/%d servers (%d checked via preflight, min=%d)",
+		hash, size, contentType, len(wouldAccept), len(targetURLs), checkable, minServers)
+
+	if len(wouldAccept) < minServers {
+		if rejectStatus == 0 {
+			rejectStatus = http.StatusBadRequest
+		}
+		if rejectReason == "" {
+			rejectReason = fmt.Sprintf("only %d servers would accept the upload, need at least %d", len(wouldAccept), minServers)
+		}
+		w.Header().Set("X-Reason", rejectReason)
+		http.Error(w, rejectReason, rejectStatus)
+		return
+	}
+
+	// Build the descriptor the upload would return
+	selectedServer, err := h.upstreamManager.SelectServerURL(wouldAccept)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to select server: %v", err), http.StatusInternalServerError)
+		return
+	}
+	extension := mimeTypeToExtension(contentType)
+	wouldSucceed := make([]upstream.UploadResultWithResponse, 0, len(wouldAccept))
+	for _, serverURL := range wouldAccept {
+		body, _ := json.Marshal(map[string]string{"url": fmt.Sprintf("%s/%s%s", serverURL, hash, extension)})
+		wouldSucceed = append(wouldSucceed, upstream.UploadResultWithResponse{ServerURL: serverURL, ResponseBody: body})
+	}
+
+	descriptor := map[string]interface{}{
+		"url":      fmt.Sprintf("%s/%s%s", selectedServer, hash, extension),
+		"sha256":   hash,
+		"size":     size,
+		"type":     contentType,
+		"uploaded": time.Now().Unix(),
+	}
+	tags := []interface{}{
+		[]interface{}{"x", hash},
+		[]interface{}{"m", contentType},
+	}
+	tags = upstream.AddSizeTag(tags, descriptor)
+	tags = h.applyURLTags(tags, descriptor, wouldSucceed, selectedServer, hash, contentType, r, "handleDryRunUpload")
+	descriptor["nip94"] = tags
+
+	if h.useLocalResponseURL() {
+		descriptor["url"] = h.constructLocalURL(hash, contentType, r)
+	}
+
+	h.setReplicationHeaders(w, hash, len(wouldAccept))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(descriptor)
+}
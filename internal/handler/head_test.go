@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// headUpstream starts a fake upstream answering HEAD requests for any blob with size
+// bytes, along with headers that must not reach the client
+func headUpstream(t *testing.T, size int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("Set-Cookie", "session=upstream")
+		w.Header().Set("Access-Control-Allow-Origin", "https://upstream.example")
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHandleHeadFromAggregatedMetadata(t *testing.T) {
+	srv1, srv2 := headUpstream(t, 10), headUpstream(t, 10)
+	mismatched := headUpstream(t, 11)
+	h := newTestHandler(t, "", srv1.URL, srv2.URL, mismatched.URL)
+	path := "/" + strings.Repeat("ab", 32)
+
+	// The first HEAD looks the blob up and caches the metadata, the others are answered from it
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		h.HandleHead(rec, httptest.NewRequest(http.MethodHead, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("HEAD %d: status = %d, want 200", i+1, rec.Code)
+		}
+		header := rec.Header()
+		if got := header.Get("Content-Length"); got != "10" {
+			t.Errorf("HEAD %d: Content-Length = %q, want the consensus size 10", i+1, got)
+		}
+		if got := header.Get("Content-Type"); got != "image/png" {
+			t.Errorf("HEAD %d: Content-Type = %q, want image/png", i+1, got)
+		}
+		if got := header.Get("Access-Control-Allow-Origin"); got != "*" {
+			t.Errorf("HEAD %d: Access-Control-Allow-Origin = %q, want the proxy's *", i+1, got)
+		}
+		for _, name := range []string{"Keep-Alive", "Set-Cookie"} {
+			if got := header.Get(name); got != "" {
+				t.Errorf("HEAD %d: upstream header %s: %s passed through", i+1, name, got)
+			}
+		}
+	}
+
+	for serverURL, stats := range h.stats.GetAll() {
+		want := int64(0)
+		if serverURL == mismatched.URL {
+			want = 1
+		}
+		if stats.SizeMismatches != want {
+			t.Errorf("%s: %d size mismatches recorded, want %d", serverURL, stats.SizeMismatches, want)
+		}
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"html/template"
 	"net/http"
 	"runtime"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/upstream"
 )
 
 // HomePageData holds data for the home page
@@ -26,12 +29,20 @@ type HomePageData struct {
 	Goroutines        int
 	MaxGoroutines     int
 	GoroutinesHealthy bool
+	Uptime            string
+	BandwidthToday    string // Bytes moved for clients today and this month (UTC), see bandwidthSummary
+	BandwidthMonth    string
+	StartedAt         string
+	Minimal           bool // Hide resource usage and upstream details (anonymous visitor, see status_access)
+	ActivityEnabled   bool
+	Activity          []ActivityRow // Recent uploads, mirrors and deletes, newest first
 }
 
 // ServerStat holds statistics for a single server
 type ServerStat struct {
 	URL                 string
 	Healthy             bool
+	HealthFor           string // How long the server has been in its current health state
 	ConsecutiveFailures int
 	UploadsSuccess      int64
 	UploadsFailure      int64
@@ -42,6 +53,33 @@ type ServerStat struct {
 	DeletesFailure      int64
 	ListsSuccess        int64
 	ListsFailure        int64
+	Capabilities        []CapabilityBadge
+	Latency             []LatencyBadge
+	Operator            *upstream.Operator // Whom to contact about the server (nil if not configured or redacted)
+}
+
+// CapabilityBadge is one capability of a server on the dashboard
+type CapabilityBadge struct {
+	Name  string
+	State string // upstream.CapabilitySupported, CapabilityUnsupported or CapabilityUnknown
+}
+
+// LatencyBadge is the recent p95 latency of one operation on a server on the dashboard
+type LatencyBadge struct {
+	Operation string
+	P95       string
+	P50       string
+	Samples   int
+}
+
+// ActivityRow is one event of the activity feed on the dashboard
+type ActivityRow struct {
+	Time       string
+	Operation  string
+	HashPrefix string
+	Size       string
+	Outcome    string
+	Servers    string
 }
 
 const homepageHTML = `<!DOCTYPE html>
@@ -166,6 +204,88 @@ const homepageHTML = `<!DOCTYPE html>
             gap: 15px;
             margin-top: 15px;
         }
+        .server-operator {
+            margin-top: 8px;
+            display: flex;
+            flex-wrap: wrap;
+            gap: 12px;
+            font-size: 0.9em;
+            color: #666;
+        }
+        .server-capabilities {
+            margin-top: 12px;
+            display: flex;
+            flex-wrap: wrap;
+            gap: 6px;
+        }
+        .capability {
+            font-size: 12px;
+            padding: 2px 8px;
+            border-radius: 10px;
+        }
+        .capability-supported {
+            background: #d1fae5;
+            color: #065f46;
+        }
+        .capability-unsupported {
+            background: #fee2e2;
+            color: #991b1b;
+            text-decoration: line-through;
+        }
+        .capability-unknown {
+            background: #f3f4f6;
+            color: #6b7280;
+        }
+        .server-latency {
+            margin-top: 8px;
+            display: flex;
+            flex-wrap: wrap;
+            gap: 6px;
+        }
+        .latency {
+            font-size: 12px;
+            padding: 2px 8px;
+            border-radius: 10px;
+            background: #ede9fe;
+            color: #5b21b6;
+        }
+        .activity-section {
+            background: white;
+            border-radius: 10px;
+            padding: 30px;
+            margin-top: 20px;
+            box-shadow: 0 4px 6px rgba(0, 0, 0, 0.1);
+        }
+        .activity-section h2 {
+            color: #667eea;
+            margin-bottom: 20px;
+        }
+        .activity-table {
+            width: 100%;
+            border-collapse: collapse;
+            font-size: 14px;
+        }
+        .activity-table th, .activity-table td {
+            text-align: left;
+            padding: 8px;
+            border-bottom: 1px solid #e5e7eb;
+        }
+        .activity-table th {
+            color: #6b7280;
+            font-weight: 600;
+        }
+        .activity-table code {
+            font-family: 'Courier New', monospace;
+        }
+        .outcome-success {
+            color: #10b981;
+        }
+        .outcome-partial, .outcome-queued {
+            color: #d97706;
+        }
+        .outcome-failed, .outcome-aborted {
+            color: #ef4444;
+        }
         .server-stat-item {
             text-align: center;
         }
@@ -255,6 +375,10 @@ const homepageHTML = `<!DOCTYPE html>
             <p style="margin-top: 15px; color: #6b7280;">
                 {{.HealthyCount}} / {{.TotalServers}} servers healthy (minimum {{.MinUploadServers}} required)
             </p>
+            <p style="margin-top: 5px; color: #6b7280;" title="Started {{.StartedAt}}">
+                Up {{.Uptime}}
+            </p>
+            {{if not .Minimal}}
             <div style="margin-top: 20px; padding-top: 20px; border-top: 1px solid #e5e7eb;">
                 <div style="display: flex; gap: 20px; flex-wrap: wrap;">
                     <div>
@@ -271,8 +395,15 @@ const homepageHTML = `<!DOCTYPE html>
                             {{if .GoroutinesHealthy}}✓{{else}}✗{{end}}
                         </span>
                     </div>
+                    <div>
+                        <strong>Bandwidth today:</strong> {{.BandwidthToday}}
+                    </div>
+                    <div>
+                        <strong>This month:</strong> {{.BandwidthMonth}}
+                    </div>
                 </div>
             </div>
+            {{end}}
         </div>
 
         <div class="stats-grid">
@@ -298,6 +429,7 @@ const homepageHTML = `<!DOCTYPE html>
             </div>
         </div>
 
+        {{if not .Minimal}}
         <div class="servers-section">
             <h2>Upstream Server Status</h2>
             {{range .ServerStats}}
@@ -305,9 +437,16 @@ const homepageHTML = `<!DOCTYPE html>
                 <div class="server-header">
                     <div class="server-url">{{.URL}}</div>
                     <span class="server-health {{if .Healthy}}health-healthy{{else}}health-unhealthy{{end}}">
-                        {{if .Healthy}}✓ Healthy{{else}}✗ Unhealthy ({{.ConsecutiveFailures}} failures){{end}}
+                        {{if .Healthy}}✓ Healthy{{else}}✗ Unhealthy ({{.ConsecutiveFailures}} failures){{end}} for {{.HealthFor}}
                     </span>
                 </div>
+                {{with .Operator}}
+                <div class="server-operator">
+                    {{if .Name}}<span>Operator: {{.Name}}</span>{{end}}
+                    {{if .Contact}}<span>Contact: {{.Contact}}</span>{{end}}
+                    {{if .StatusPageURL}}<a href="{{.StatusPageURL}}" rel="noopener noreferrer">Status page</a>{{end}}
+                </div>
+                {{end}}
                 <div class="server-stats">
                     <div class="server-stat-item">
                         <div class="server-stat-label">Uploads</div>
@@ -342,10 +481,42 @@ const homepageHTML = `<!DOCTYPE html>
                         </div>
                     </div>
                 </div>
+                <div class="server-capabilities">
+                    {{range .Capabilities}}<span class="capability capability-{{.State}}" title="{{.State}}">{{.Name}}</span>{{end}}
+                </div>
+                {{if .Latency}}
+                <div class="server-latency">
+                    {{range .Latency}}<span class="latency" title="p50 {{.P50}}, {{.Samples}} samples">{{.Operation}} p95 {{.P95}}</span>{{end}}
+                </div>
+                {{end}}
             </div>
             {{end}}
         </div>
 
+        {{if .ActivityEnabled}}
+        <div class="activity-section">
+            <h2>Recent Activity</h2>
+            {{if .Activity}}
+            <table class="activity-table">
+                <tr><th>Time</th><th>Operation</th><th>Blob</th><th>Size</th><th>Servers</th><th>Outcome</th></tr>
+                {{range .Activity}}
+                <tr>
+                    <td>{{.Time}}</td>
+                    <td>{{.Operation}}</td>
+                    <td><code>{{.HashPrefix}}</code></td>
+                    <td>{{.Size}}</td>
+                    <td>{{.Servers}}</td>
+                    <td class="outcome-{{.Outcome}}">{{.Outcome}}</td>
+                </tr>
+                {{end}}
+            </table>
+            {{else}}
+            <p>No uploads, mirrors or deletes yet.</p>
+            {{end}}
+        </div>
+        {{end}}
+        {{end}}
+
         <div class="docs-section">
             <h2>📖 Usage</h2>
             
@@ -378,6 +549,7 @@ const homepageHTML = `<!DOCTYPE html>
                 <li><strong>GET /</strong> - This home page</li>
                 <li><strong>GET /health</strong> - Health check endpoint (returns JSON)</li>
                 <li><strong>GET /stats</strong> - Statistics endpoint (returns JSON with detailed stats)</li>
+                <li><strong>GET /admin/capabilities</strong> - Capability matrix of the upstream servers (needs status access)</li>
                 <li><strong>PUT /upload</strong> - Upload a file (Blossom protocol - forwards to upstream servers)</li>
                 <li><strong>PUT /mirror</strong> - Mirror a blob (BUD-04 - forwards to upstream servers)</li>
                 <li><strong>HEAD /upload</strong> - Upload preflight check (BUD-06 - checks upstream servers)</li>
@@ -399,7 +571,7 @@ const homepageHTML = `<!DOCTYPE html>
         </div>
 
         <div class="footer">
-            <p>Blossom Espelhator Tabajara | <a href="/health" style="color: white;">Health API</a> | <a href="/stats" style="color: white;">Stats API</a></p>
+            <p>Blossom Espelhator Tabajara | <a href="/health" style="color: white;">Health API</a> | <a href="/stats" style="color: white;">Stats API</a> | <a href="/metrics" style="color: white;">Metrics</a></p>
         </div>
     </div>
 </body>
@@ -412,6 +584,11 @@ func (h *BlossomHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !h.requireStatusAuth(w, r) {
+		return
+	}
+	minimal := !h.statusDetailsAllowed(r)
+	redact := h.redactUpstreams(r)
 
 	// Get health status
 	healthyCount := h.stats.GetHealthyCount()
@@ -428,7 +605,7 @@ func (h *BlossomHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
 	// Check if system metrics are healthy
 	memoryHealthy := memoryBytes < h.config.Server.MaxMemoryBytes
 	goroutinesHealthy := goroutines < h.config.Server.MaxGoroutines
-	serversHealthy := healthyCount >= minUploadServers
+	serversHealthy := h.stats.GetHealthyCountFor("upload") >= minUploadServers
 
 	// System is healthy if all checks pass
 	isHealthy := memoryHealthy && goroutinesHealthy && serversHealthy
@@ -444,6 +621,7 @@ func (h *BlossomHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
 	// Calculate totals
 	var totalUploads, totalDownloads, totalMirrors, totalDeletes, totalLists int64
 	serverStats := make([]ServerStat, 0, len(allStats))
+	capabilities := h.capabilityBadges()
 
 	for url, stats := range allStats {
 		totalUploads += stats.UploadsSuccess + stats.UploadsFailure
@@ -452,9 +630,17 @@ func (h *BlossomHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
 		totalDeletes += stats.DeletesSuccess + stats.DeletesFailure
 		totalLists += stats.ListsSuccess + stats.ListsFailure
 
+		badges := capabilities[url]
+		var operator *upstream.Operator
+		if redact {
+			url = h.upstreamManager.ServerLabel(url)
+		} else {
+			operator = h.upstreamManager.Operator(url)
+		}
 		serverStats = append(serverStats, ServerStat{
 			URL:                 url,
 			Healthy:             stats.IsHealthy,
+			HealthFor:           formatUptime(stats.HealthDuration()),
 			ConsecutiveFailures: stats.ConsecutiveFailures,
 			UploadsSuccess:      stats.UploadsSuccess,
 			UploadsFailure:      stats.UploadsFailure,
@@ -465,6 +651,9 @@ func (h *BlossomHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
 			DeletesFailure:      stats.DeletesFailure,
 			ListsSuccess:        stats.ListsSuccess,
 			ListsFailure:        stats.ListsFailure,
+			Capabilities:        badges,
+			Latency:             latencyBadges(stats.Latency),
+			Operator:            operator,
 		})
 	}
 
@@ -486,6 +675,18 @@ func (h *BlossomHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
 		Goroutines:        goroutines,
 		MaxGoroutines:     h.config.Server.MaxGoroutines,
 		GoroutinesHealthy: goroutinesHealthy,
+		Uptime:            formatUptime(h.stats.Uptime()),
+		StartedAt:         h.stats.StartedAt().UTC().Format(time.RFC3339),
+		Minimal:           minimal,
+		ActivityEnabled:   h.activity != nil,
+	}
+	if minimal {
+		data.ServerStats = nil
+	} else {
+		data.Activity = h.activityRows()
+		bandwidth := h.stats.GetBandwidth()
+		data.BandwidthToday = bandwidthSummary(bandwidth.Days, time.Now().UTC().Format("2006-01-02"))
+		data.BandwidthMonth = bandwidthSummary(bandwidth.Months, time.Now().UTC().Format("2006-01"))
 	}
 
 	tmpl, err := template.New("homepage").Parse(homepageHTML)
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/girino/blossom_espelhator/internal/auth"
+)
+
+// recentUpload is the response sent for a completed upload
+type recentUpload struct {
+	response  []byte
+	replicas  int
+	expiresAt time.Time
+}
+
+// recentUploads remembers the responses of recently completed uploads by hash and uploader
+// pubkey, so a client retrying the same PUT (e.g., after a mobile network blip) gets the
+// descriptor back immediately instead of the whole blob being fanned out again
+type recentUploads struct {
+	mu      sync.Mutex
+	window  time.Duration
+	uploads map[string]recentUpload // "hash:pubkey" -> response
+	blocked func(hash string) bool  // Reports hashes never remembered (nil: none)
+}
+
+func newRecentUploads(window time.Duration) *recentUploads {
+	return &recentUploads{
+		window:  window,
+		uploads: make(map[string]recentUpload),
+	}
+}
+
+func recentUploadKey(hash string, pubkey string) string {
+	return hash + ":" + pubkey
+}
+
+// setBlocked makes Record skip the hashes blocked reports (see SetBlocklist)
+func (ru *recentUploads) setBlocked(blocked func(hash string) bool) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	ru.blocked = blocked
+}
+
+// Record stores the response of a completed upload
+func (ru *recentUploads) Record(hash string, pubkey string, response []byte, replicas int) {
+	if ru.window <= 0 {
+		return
+	}
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	if ru.blocked != nil && ru.blocked(hash) {
+		return
+	}
+
+	now := time.Now()
+	for key, upload := range ru.uploads {
+		if now.After(upload.expiresAt) {
+			delete(ru.uploads, key)
+		}
+	}
+	ru.uploads[recentUploadKey(hash, pubkey)] = recentUpload{
+		response:  response,
+		replicas:  replicas,
+		expiresAt: now.Add(ru.window),
+	}
+}
+
+// Lookup returns the response of a recent upload of hash by pubkey
+func (ru *recentUploads) Lookup(hash string, pubkey string) (recentUpload, bool) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+
+	upload, exists := ru.uploads[recentUploadKey(hash, pubkey)]
+	if !exists || time.Now().After(upload.expiresAt) {
+		return recentUpload{}, false
+	}
+	return upload, true
+}
+
+// Forget drops all remembered uploads of hash (e.g., after it is deleted)
+func (ru *recentUploads) Forget(hash string) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+
+	prefix := hash + ":"
+	for key := range ru.uploads {
+		if strings.HasPrefix(key, prefix) {
+			delete(ru.uploads, key)
+		}
+	}
+}
+
+// uploaderPubkey returns the pubkey of the upload's authorization event if it is valid and
+// its x tags cover hash, binding the upload to that blob. authEvent is the event already
+// validated for auth_endpoints (nil if upload isn't in them)
+func (h *BlossomHandler) uploaderPubkey(r *http.Request, authEvent *nostr.Event, hash string) (string, bool) {
+	event := authEvent
+	if event == nil {
+		if r.Header.Get("Authorization") == "" {
+			return "", false
+		}
+		var err error
+		if event, err = auth.Authenticate(r, authVerbs["upload"], nil, h.authVerbose.Enabled()); err != nil {
+			return "", false
+		}
+	}
+	if !authorizedHashes(event)[hash] {
+		return "", false
+	}
+	return event.PubKey, true
+}
+
+// replayRecentUpload answers a retried upload with the response of the upload that
+// already completed, if its authorization event is bound to hash by an x tag
+// Returns true if the request was answered
+func (h *BlossomHandler) replayRecentUpload(w http.ResponseWriter, r *http.Request, authEvent *nostr.Event, hash string) bool {
+	pubkey, bound := h.uploaderPubkey(r, authEvent, hash)
+	if !bound {
+		return false
+	}
+	upload, exists := h.recentUploads.Lookup(hash, pubkey)
+	if !exists {
+		return false
+	}
+
+	h.verbose.Debugf(r.Context(), "HandleUpload: %s was uploaded moments ago by the same client, returning previous response", hash)
+
+	setCORSHeaders(w, r)
+	h.setReplicationHeaders(w, hash, upload.replicas)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Upload-Replayed", "true")
+	w.WriteHeader(http.StatusOK)
+	w.Write(upload.response)
+	return true
+}
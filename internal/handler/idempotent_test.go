@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/girino/blossom_espelhator/internal/auth"
+	"github.com/girino/blossom_espelhator/internal/testutil"
+)
+
+// unsignedAuthorization returns an Authorization header claiming pubkey with an x tag for
+// hash, but without a valid signature
+func unsignedAuthorization(t *testing.T, pubkey string, hash string) string {
+	t.Helper()
+	event := nostr.Event{
+		PubKey:    pubkey,
+		Kind:      24242,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"t", "upload"},
+			{"x", hash},
+			{"expiration", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)},
+		},
+	}
+	event.ID = event.GetID()
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(eventJSON)
+}
+
+func TestReplayRecentUploadRequiresBoundAuthorization(t *testing.T) {
+	srv := testutil.AnsweringUpstream(t)
+	h := newTestHandler(t, "", srv.URL)
+
+	blob := []byte("retried upload")
+	sum := sha256.Sum256(blob)
+	hash := hex.EncodeToString(sum[:])
+	otherSum := sha256.Sum256([]byte("another blob"))
+
+	secretKey := nostr.GeneratePrivateKey()
+	pubkey, err := nostr.GetPublicKey(secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(hash string) string {
+		header, err := auth.SignAuthorization(secretKey, "upload", hash, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return header
+	}
+	upload := func(authorization string, announce bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/upload", strings.NewReader(string(blob)))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		if announce {
+			req.Header.Set("X-SHA-256", hash)
+		}
+		rec := httptest.NewRecorder()
+		h.HandleUpload(rec, req)
+		return rec
+	}
+
+	if rec := upload(sign(hash), true); rec.Code != http.StatusOK {
+		t.Fatalf("first upload: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	if _, exists := h.recentUploads.Lookup(hash, pubkey); !exists {
+		t.Fatal("upload with a bound authorization event was not remembered")
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		announce      bool
+		replayed      bool
+	}{
+		{"bound event", sign(hash), true, true},
+		{"bound event, hash from x tag", sign(hash), false, true},
+		{"no authorization", "", true, false},
+		{"unsigned event", unsignedAuthorization(t, pubkey, hash), true, false},
+		{"event for another hash", sign(hex.EncodeToString(otherSum[:])), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := upload(tt.authorization, tt.announce)
+			if replayed := rec.Header().Get("X-Upload-Replayed") == "true"; replayed != tt.replayed {
+				t.Errorf("replayed = %v, want %v (status %d)", replayed, tt.replayed, rec.Code)
+			}
+		})
+	}
+}
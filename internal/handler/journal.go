@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/auth"
+	"github.com/girino/blossom_espelhator/internal/journal"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/upstream"
+)
+
+// registerJournalHandlers registers the handlers that replay journaled operations
+func (h *BlossomHandler) registerJournalHandlers() {
+	if h.journal == nil {
+		return
+	}
+	h.journal.Handle(journal.KindMirror, h.replayMirror)
+	h.journal.Handle(journal.KindDelete, h.replayDelete)
+	if h.queued != nil {
+		h.journal.Handle(journal.KindUpload, h.replayUpload)
+		h.cleanupQueuedUploads()
+	}
+}
+
+// replayMirror pushes a blob to a server that missed it using BUD-04 mirror
+func (h *BlossomHandler) replayMirror(ctx context.Context, entry journal.Entry) error {
+	if h.dropBlockedEntry(ctx, entry, "replayMirror") {
+		return nil
+	}
+	cl, err := h.upstreamManager.GetClient(entry.ServerURL)
+	if err != nil {
+		return err
+	}
+	// The server may have received the blob some other way in the meantime
+	alreadyStored := false
+	if !h.config.Server.DisableConditionalMirror {
+		_, alreadyStored = h.upstreamManager.ExistingBlob(ctx, entry.ServerURL, entry.Hash)
+	}
+	if alreadyStored {
+		h.verbose.Debugf(ctx, "replayMirror: %s already has %s, nothing to transfer", entry.ServerURL, entry.Hash)
+	} else if _, err := cl.Mirror(ctx, bytes.NewReader(entry.Body), "application/json", entry.Headers); err != nil {
+		return err
+	}
+
+	h.stats.RecordSuccess(entry.ServerURL, "mirror")
+	// Only extend existing cache entries; missing entries are filled by the next lookup
+	if _, exists := h.cache.Get(entry.Hash); exists {
+		h.cache.AddServer(entry.Hash, entry.ServerURL)
+	}
+	return nil
+}
+
+// replayDelete deletes a blob from a server that failed the original delete
+func (h *BlossomHandler) replayDelete(ctx context.Context, entry journal.Entry) error {
+	cl, err := h.upstreamManager.GetClient(entry.ServerURL)
+	if err != nil {
+		return err
+	}
+	if err := cl.Delete(ctx, entry.Hash, entry.Headers); err != nil {
+		if h.upstreamManager.DetectUnsupported(entry.ServerURL, "delete", err) {
+			// Nothing left to do on a server that can't delete
+			return nil
+		}
+		return err
+	}
+
+	h.stats.RecordSuccess(entry.ServerURL, "delete")
+	h.cache.RemoveServer(entry.Hash, entry.ServerURL)
+	return nil
+}
+
+// dropBlockedEntry reports whether a journaled mirror or upload is for a blob blocked since
+// it was recorded, which is dropped rather than replicated
+func (h *BlossomHandler) dropBlockedEntry(ctx context.Context, entry journal.Entry, logPrefix string) bool {
+	if !h.blocklist.Contains(entry.Hash) {
+		return false
+	}
+	logging.Warnf(ctx, "%s: dropping %s of %s to %s: blob is blocked", logPrefix, entry.Kind, entry.Hash, entry.ServerURL)
+	return true
+}
+
+// journalMirrors records mirror operations for servers that missed a blob
+// body is the BUD-04 mirror request body to replay
+func (h *BlossomHandler) journalMirrors(ctx context.Context, hash string, serverURLs []string, body []byte, headers map[string]string, logPrefix string) {
+	h.journalOperations(ctx, journal.KindMirror, hash, serverURLs, body, headers, logPrefix)
+}
+
+// journalUploadRetries records mirror operations that copy a freshly uploaded blob
+// from a server that stored it to the mirror-capable targets that failed
+func (h *BlossomHandler) journalUploadRetries(ctx context.Context, hash string, targetURLs []string, successfulServers []upstream.UploadResultWithResponse, headers map[string]string, logPrefix string) {
+	if h.journal == nil || len(successfulServers) == 0 {
+		return
+	}
+
+	succeeded := make(map[string]bool, len(successfulServers))
+	for _, srv := range successfulServers {
+		succeeded[srv.ServerURL] = true
+	}
+	// Mirror from a server that confirmed the blob rather than one that only queued it (202)
+	source := successfulServers[0].ServerURL
+	for _, srv := range successfulServers {
+		if !srv.Accepted {
+			source = srv.ServerURL
+			break
+		}
+	}
+
+	mirrorCapable := make(map[string]bool)
+	for _, serverURL := range h.upstreamManager.GetMirrorCapableServers() {
+		mirrorCapable[serverURL] = true
+	}
+
+	missing := make([]string, 0)
+	for _, serverURL := range targetURLs {
+		if succeeded[serverURL] {
+			continue
+		}
+		if !mirrorCapable[serverURL] {
+			h.verbose.Debugf(ctx, "%s: not retrying upload of %s to %s, server doesn't support mirror", logPrefix, hash, serverURL)
+			continue
+		}
+		missing = append(missing, serverURL)
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(map[string]string{"url": strings.TrimSuffix(source, "/") + "/" + hash})
+	if err != nil {
+		return
+	}
+	h.journalOperations(ctx, journal.KindMirror, hash, missing, body, headers, logPrefix)
+}
+
+// journalDeletes records delete operations for servers that failed to delete a blob
+func (h *BlossomHandler) journalDeletes(ctx context.Context, hash string, serverURLs []string, headers map[string]string, logPrefix string) {
+	h.journalOperations(ctx, journal.KindDelete, hash, serverURLs, nil, headers, logPrefix)
+}
+
+// journalOperations records one journal entry per server
+// Only the Authorization header is replayed, and entries expire with the auth event
+func (h *BlossomHandler) journalOperations(ctx context.Context, kind string, hash string, serverURLs []string, body []byte, headers map[string]string, logPrefix string) {
+	if h.journal == nil || len(serverURLs) == 0 {
+		return
+	}
+
+	replay := make(map[string]string)
+	authHeader := ""
+	for k, v := range headers {
+		if strings.EqualFold(k, "Authorization") {
+			replay["Authorization"] = v
+			authHeader = v
+		}
+	}
+	expiresAt := authExpiration(authHeader)
+
+	for _, serverURL := range serverURLs {
+		err := h.journal.Add(journal.Entry{
+			Kind:      kind,
+			Hash:      hash,
+			ServerURL: serverURL,
+			Headers:   replay,
+			Body:      body,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			logging.Warnf(ctx, "%s: failed to journal %s of %s on %s: %v", logPrefix, kind, hash, serverURL, err)
+		}
+	}
+	h.verbose.Debugf(ctx, "%s: journaled %s of %s for %d servers: %v", logPrefix, kind, hash, len(serverURLs), serverURLs)
+}
+
+// authExpiration returns the expiration of a BUD-01 authorization header, or the zero
+// time if there is none (the journal then applies its maximum age)
+func authExpiration(authHeader string) time.Time {
+	if authHeader == "" {
+		return time.Time{}
+	}
+	event, err := auth.ParseAuthorizationHeader(authHeader)
+	if err != nil || event == nil {
+		return time.Time{}
+	}
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && strings.ToLower(tag[0]) == "expiration" {
+			if ts, err := strconv.ParseInt(tag[1], 10, 64); err == nil {
+				return time.Unix(ts, 0)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// journalStats summarizes the journal for /stats
+func (h *BlossomHandler) journalStats() map[string]interface{} {
+	return map[string]interface{}{
+		"enabled": h.journal != nil,
+		"pending": h.journal.Len(),
+	}
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/upstream"
+)
+
+// writeListError answers a list request that too few upstream servers answered (or, with
+// list_fail_on_partial, not all of them) with a 502 JSON envelope listing each failed server,
+// so clients don't mistake it for "no blobs"
+func (h *BlossomHandler) writeListError(w http.ResponseWriter, r *http.Request, listErr *upstream.ListError) {
+	failures := upstream.ListFailures(listErr.Results)
+	logging.Warnf(r.Context(), "HandleList: %v (%d servers failed)", listErr, len(failures))
+	for i := range failures {
+		failures[i].ServerURL = h.publicMessage(failures[i].ServerURL)
+		failures[i].Error = h.publicMessage(sanitizeError(failures[i].Err))
+	}
+
+	setCORSHeaders(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Reason", listErr.Error())
+	if len(failures) > 0 {
+		w.Header().Set("X-Upstream-Errors", upstream.FormatListFailures(failures))
+	}
+	w.WriteHeader(http.StatusBadGateway)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":     listErr.Error(),
+		"succeeded": listErr.Succeeded,
+		"required":  listErr.Required,
+		"servers":   failures,
+	})
+}
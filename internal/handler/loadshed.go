@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// loadSampleInterval bounds how often runtime.ReadMemStats is called for load shedding
+const loadSampleInterval = time.Second
+
+// loadShedder decides whether new upload work should be rejected because the process
+// is over its memory or goroutine thresholds
+type loadShedder struct {
+	mu         sync.Mutex
+	sampledAt  time.Time
+	memory     int64
+	goroutines int
+
+	shed atomic.Int64 // Requests rejected so far
+}
+
+// sample returns the current memory usage and goroutine count, re-reading them at most
+// once per loadSampleInterval since ReadMemStats briefly stops the world
+func (ls *loadShedder) sample() (int64, int) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if time.Since(ls.sampledAt) >= loadSampleInterval {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		ls.memory = int64(m.Alloc)
+		ls.goroutines = runtime.NumGoroutine()
+		ls.sampledAt = time.Now()
+	}
+	return ls.memory, ls.goroutines
+}
+
+// overloaded returns a reason if memory or goroutines exceed the configured health thresholds
+func (h *BlossomHandler) overloaded() (string, bool) {
+	memoryBytes, goroutines := h.loadShedder.sample()
+	if memoryBytes >= h.config.Server.MaxMemoryBytes {
+		return fmt.Sprintf("memory usage %d bytes exceeds %d", memoryBytes, h.config.Server.MaxMemoryBytes), true
+	}
+	if goroutines >= h.config.Server.MaxGoroutines {
+		return fmt.Sprintf("goroutine count %d exceeds %d", goroutines, h.config.Server.MaxGoroutines), true
+	}
+	return "", false
+}
+
+// shedLoad rejects the request with 503 + Retry-After when the proxy is overloaded
+// Returns true if the request was rejected
+func (h *BlossomHandler) shedLoad(w http.ResponseWriter, r *http.Request, logPrefix string) bool {
+	if h.config.Server.DisableLoadShedding {
+		return false
+	}
+	reason, overloaded := h.overloaded()
+	if !overloaded {
+		return false
+	}
+
+	h.loadShedder.shed.Add(1)
+	logging.Warnf(r.Context(), "%s: shedding %s request from %s: %s", logPrefix, r.Method, r.RemoteAddr, reason)
+
+	retryAfter := int(h.config.Server.LoadSheddingRetryAfter.Seconds())
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	setCORSHeaders(w, r)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.Header().Set("X-Reason", "Server overloaded, retry later")
+	http.Error(w, "Server overloaded, retry later", http.StatusServiceUnavailable)
+	return true
+}
+
+// loadSheddingStats summarizes load shedding for /stats
+func (h *BlossomHandler) loadSheddingStats() map[string]interface{} {
+	_, overloaded := h.overloaded()
+	return map[string]interface{}{
+		"enabled":       !h.config.Server.DisableLoadShedding,
+		"overloaded":    overloaded,
+		"shed_requests": h.loadShedder.shed.Load(),
+	}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+)
+
+// logLevelRequest is the body of POST /admin/log-level
+type logLevelRequest struct {
+	Verbose    *bool           `json:"verbose"`    // Every component
+	Components map[string]bool `json:"components"` // Component -> on/off, applied after verbose
+}
+
+// logLevelResponse is the body of every /admin/log-level response
+type logLevelResponse struct {
+	Verbose    bool            `json:"verbose"` // Whether any component has debug logging on
+	Components map[string]bool `json:"components"`
+}
+
+// HandleLogLevel handles /admin/log-level: GET reports which components have debug logging
+// on, POST switches it without a restart, for every component ({"verbose": true|false},
+// like SIGUSR1) or some of them ({"components": {"upstream": true}})
+// Reading needs full status access, changing it always needs admin credentials
+func (h *BlossomHandler) HandleLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !h.requireStatusDetails(w, r) {
+			return
+		}
+	case http.MethodPost:
+		if !h.requireAdminAuth(w, r) {
+			return
+		}
+		var req logLevelRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil || (req.Verbose == nil && len(req.Components) == 0) {
+			http.Error(w, `Expected a JSON body like {"verbose": true} or {"components": {"upstream": true}}`, http.StatusBadRequest)
+			return
+		}
+		for component := range req.Components {
+			if h.debug.Flag(component) == nil {
+				http.Error(w, "Unknown component "+component, http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Verbose != nil {
+			h.debug.SetAll(*req.Verbose)
+		}
+		for component, on := range req.Components {
+			h.debug.Set(component, on)
+		}
+		log.Printf("HandleLogLevel: debug logging enabled for %v", h.debug.Enabled())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logLevelResponse{
+		Verbose:    len(h.debug.Enabled()) > 0,
+		Components: h.debug.State(),
+	})
+}
@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/girino/blossom_espelhator/internal/recovery"
+	"github.com/girino/blossom_espelhator/internal/stats"
+)
+
+// metricsPrefix is the prefix of every metric name exposed on /metrics
+const metricsPrefix = "espelhator_"
+
+// metricsWriter receives the metrics collected by writeMetrics, in the /metrics format or
+// pushed to statsd (see statsd.go)
+type metricsWriter interface {
+	// family starts a metric family (metricType is "counter" or "gauge")
+	family(name, metricType, help string)
+	// sample writes one sample of the current family; labels are name/value pairs
+	sample(name string, value float64, labels ...string)
+}
+
+// promWriter writes metrics in the Prometheus text exposition format (version 0.0.4)
+type promWriter struct {
+	b strings.Builder
+}
+
+// family starts a metric family with its HELP and TYPE lines
+func (pw *promWriter) family(name, metricType, help string) {
+	fmt.Fprintf(&pw.b, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, metricType)
+}
+
+// sample writes one sample; labels are name/value pairs
+func (pw *promWriter) sample(name string, value float64, labels ...string) {
+	pw.b.WriteString(metricsPrefix + name)
+	if len(labels) > 0 {
+		pw.b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				pw.b.WriteByte(',')
+			}
+			fmt.Fprintf(&pw.b, "%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1]))
+		}
+		pw.b.WriteByte('}')
+	}
+	pw.b.WriteByte(' ')
+	pw.b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	pw.b.WriteByte('\n')
+}
+
+// escapeLabelValue escapes backslashes, double quotes and newlines in a label value
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// boolValue converts a boolean to a 0/1 gauge value
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// operationCounts are the success and failure counters of one operation type on a server
+type operationCounts struct {
+	operation string
+	success   int64
+	failure   int64
+}
+
+// upstreamOperationCounts returns the counters of each operation type on a server
+// Downloads count the lookups answered by the server (successes) and failed ones
+func upstreamOperationCounts(s *stats.ServerStats) []operationCounts {
+	return []operationCounts{
+		{"upload", s.UploadsSuccess, s.UploadsFailure},
+		{"download", s.Downloads, s.DownloadsFailure},
+		{"mirror", s.MirrorsSuccess, s.MirrorsFailure},
+		{"delete", s.DeletesSuccess, s.DeletesFailure},
+		{"list", s.ListsSuccess, s.ListsFailure},
+	}
+}
+
+// HandleMetrics handles GET /metrics requests
+// Exposes the statistics of /stats in the Prometheus text format. Per-server metrics are
+// labelled with the upstream URL (or its label with redact_upstreams)
+func (h *BlossomHandler) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// There is no minimal variant: anonymous requests are rejected unless status_access is "public"
+	if !h.requireStatusDetails(w, r) {
+		return
+	}
+
+	pw := &promWriter{}
+	h.writeMetrics(pw, h.redactUpstreams(r))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(pw.b.String()))
+}
+
+// writeMetrics collects the statistics of /stats into pw, with per-server metrics labelled
+// by server label instead of URL if redact is set
+func (h *BlossomHandler) writeMetrics(pw metricsWriter, redact bool) {
+	allStats := h.stats.GetAll()
+	if redact {
+		allStats = h.redactServerStats(allStats)
+	}
+	servers := make([]string, 0, len(allStats))
+	for server := range allStats {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+
+	// Per-upstream operation counters and health
+	pw.family("upstream_requests_total", "counter", "Requests to upstream servers by operation and result.")
+	for _, server := range servers {
+		for _, op := range upstreamOperationCounts(allStats[server]) {
+			pw.sample("upstream_requests_total", float64(op.success), "server", server, "operation", op.operation, "result", "success")
+			pw.sample("upstream_requests_total", float64(op.failure), "server", server, "operation", op.operation, "result", "failure")
+		}
+	}
+	pw.family("upstream_healthy", "gauge", "Whether the upstream server is healthy for uploads and downloads (1) or not (0).")
+	for _, server := range servers {
+		pw.sample("upstream_healthy", boolValue(allStats[server].IsHealthy), "server", server)
+	}
+	pw.family("upstream_operation_healthy", "gauge", "Whether the upstream server is healthy for an operation (1) or not (0).")
+	for _, server := range servers {
+		operations := make([]string, 0, len(allStats[server].Operations))
+		for opType := range allStats[server].Operations {
+			operations = append(operations, opType)
+		}
+		sort.Strings(operations)
+		for _, opType := range operations {
+			pw.sample("upstream_operation_healthy", boolValue(allStats[server].Operations[opType].IsHealthy), "server", server, "operation", opType)
+		}
+	}
+	pw.family("upstream_consecutive_failures", "gauge", "Consecutive failed requests to the upstream server.")
+	for _, server := range servers {
+		pw.sample("upstream_consecutive_failures", float64(allStats[server].ConsecutiveFailures), "server", server)
+	}
+	pw.family("upstream_size_mismatches_total", "counter", "Blob sizes reported by the upstream server that disagreed with other replicas.")
+	for _, server := range servers {
+		pw.sample("upstream_size_mismatches_total", float64(allStats[server].SizeMismatches), "server", server)
+	}
+	pw.family("upstream_integrity_checks_total", "counter", "Background integrity checks against the upstream server by result.")
+	for _, server := range servers {
+		s := allStats[server]
+		pw.sample("upstream_integrity_checks_total", float64(s.IntegrityChecks-s.CorruptReplicas), "server", server, "result", "ok")
+		pw.sample("upstream_integrity_checks_total", float64(s.CorruptReplicas), "server", server, "result", "corrupt")
+	}
+	pw.family("upstream_slow_requests_total", "counter", "Requests to the upstream server slower than slow_upstream_threshold.")
+	for _, server := range servers {
+		pw.sample("upstream_slow_requests_total", float64(allStats[server].SlowRequests), "server", server)
+	}
+	pw.family("upstream_latency_seconds", "gauge", "Latency of recent successful requests to the upstream server (last 200 per operation).")
+	for _, server := range servers {
+		for _, op := range latencyOperations {
+			latency, ok := allStats[server].Latency[op.opType]
+			if !ok {
+				continue
+			}
+			pw.sample("upstream_latency_seconds", latency.P50Ms/1000, "server", server, "operation", op.opType, "quantile", "0.5")
+			pw.sample("upstream_latency_seconds", latency.P95Ms/1000, "server", server, "operation", op.opType, "quantile", "0.95")
+		}
+	}
+	pw.family("upstream_throughput_bytes_per_second", "gauge", "Rolling average throughput of successful uploads and mirrors to the upstream server.")
+	for _, server := range servers {
+		for _, opType := range []string{"upload", "mirror"} {
+			if tp, ok := allStats[server].Throughput[opType]; ok {
+				pw.sample("upstream_throughput_bytes_per_second", tp.BytesPerSecond, "server", server, "operation", opType)
+			}
+		}
+	}
+	pw.family("upstream_healthy_servers", "gauge", "Upstream servers healthy for uploads.")
+	pw.sample("upstream_healthy_servers", float64(h.stats.GetHealthyCountFor("upload")))
+	pw.family("upstream_min_upload_servers", "gauge", "Minimum number of servers an upload must reach (min_upload_servers).")
+	pw.sample("upstream_min_upload_servers", float64(h.config.Server.MinUploadServers))
+
+	// Cache
+	counters := h.cache.Counters()
+	pw.family("cache_entries", "gauge", "Blobs whose upstream locations are cached.")
+	pw.sample("cache_entries", float64(counters.Entries))
+	pw.family("cache_lookups_total", "counter", "Cache lookups by result.")
+	pw.sample("cache_lookups_total", float64(counters.Hits), "result", "hit")
+	pw.sample("cache_lookups_total", float64(counters.Misses), "result", "miss")
+	pw.family("cache_negative_entries", "gauge", "Blobs remembered as found on no upstream.")
+	pw.sample("cache_negative_entries", float64(counters.NegativeEntries))
+	pw.family("cache_negative_hits_total", "counter", "Lookups answered as missing from the negative cache.")
+	pw.sample("cache_negative_hits_total", float64(counters.NegativeHits))
+	if h.blobStore != nil {
+		blobCounters := h.blobStore.Counters()
+		pw.family("blob_cache_entries", "gauge", "Blobs stored in the local blob cache.")
+		pw.sample("blob_cache_entries", float64(blobCounters.Entries))
+		pw.family("blob_cache_bytes", "gauge", "Size of the blobs stored in the local blob cache.")
+		pw.sample("blob_cache_bytes", float64(blobCounters.Bytes))
+		pw.family("blob_cache_lookups_total", "counter", "Local blob cache lookups by result.")
+		pw.sample("blob_cache_lookups_total", float64(blobCounters.Hits), "result", "hit")
+		pw.sample("blob_cache_lookups_total", float64(blobCounters.Misses), "result", "miss")
+		pw.family("blob_cache_rejected_total", "counter", "Blobs not stored in the local blob cache because they were not popular enough.")
+		pw.sample("blob_cache_rejected_total", float64(blobCounters.Rejected))
+	}
+
+	// Requests served to clients
+	endpointMetrics := h.requestMetrics.snapshot()
+	endpoints := make([]string, 0, len(endpointMetrics))
+	for endpoint := range endpointMetrics {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	pw.family("http_responses_total", "counter", "Responses sent to clients by endpoint and status class (aborted requests excluded).")
+	for _, endpoint := range endpoints {
+		classes := make([]string, 0, len(endpointMetrics[endpoint].Status))
+		for class := range endpointMetrics[endpoint].Status {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			pw.sample("http_responses_total", float64(endpointMetrics[endpoint].Status[class]), "endpoint", endpoint, "class", class)
+		}
+	}
+	pw.family("http_client_aborts_total", "counter", "Requests whose client went away mid-transfer, by endpoint.")
+	for _, endpoint := range endpoints {
+		pw.sample("http_client_aborts_total", float64(endpointMetrics[endpoint].ClientAborts), "endpoint", endpoint)
+	}
+	pw.family("slow_requests_total", "counter", "Client requests slower than slow_request_threshold.")
+	pw.sample("slow_requests_total", float64(h.slowLog.requests.Load()))
+	pw.family("load_shed_requests_total", "counter", "Uploads and mirrors rejected while overloaded.")
+	pw.sample("load_shed_requests_total", float64(h.loadShedder.shed.Load()))
+	if h.rateLimits != nil {
+		pw.family("rate_limited_requests_total", "counter", "Requests rejected by the rate limits.")
+		pw.sample("rate_limited_requests_total", float64(h.rateLimits.rejected.Load()))
+	}
+
+	// Background work and process
+	pw.family("journal_pending", "gauge", "Background operations waiting in the journal.")
+	pw.sample("journal_pending", float64(h.journal.Len()))
+	pw.family("quarantined_replicas", "gauge", "Replicas excluded after failing verification.")
+	pw.sample("quarantined_replicas", float64(h.quarantine.Count()))
+	pw.family("blocked_hashes", "gauge", "Blob hashes on the blocklist.")
+	pw.sample("blocked_hashes", float64(h.blocklist.Len()))
+	pw.family("panics_total", "counter", "Panics recovered since startup.")
+	pw.sample("panics_total", float64(recovery.Total()))
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	pw.family("memory_bytes", "gauge", "Allocated heap memory.")
+	pw.sample("memory_bytes", float64(m.Alloc))
+	pw.family("goroutines", "gauge", "Current number of goroutines.")
+	pw.sample("goroutines", float64(runtime.NumGoroutine()))
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/girino/blossom_espelhator/internal/popularity"
+)
+
+// defaultPopularLimit is the number of blobs GET /admin/popular returns without ?limit
+const defaultPopularLimit = 100
+
+// popularResponse is the body of GET /admin/popular
+type popularResponse struct {
+	HalfLifeSeconds int64              `json:"half_life_seconds"` // Time for a score to halve without downloads
+	Tracked         int                `json:"tracked"`           // Blobs whose popularity is tracked
+	Blobs           []popularity.Entry `json:"blobs"`             // Most popular first
+}
+
+// HandlePopular handles GET /admin/popular: the most downloaded blobs, ranked by their
+// download count decayed over popularity_half_life (?limit=N, default 100)
+// Hashes tell what is downloaded, so it needs full status access (see status_access)
+func (h *BlossomHandler) HandlePopular(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireStatusDetails(w, r) {
+		return
+	}
+	if h.popularity == nil {
+		http.Error(w, "Popularity tracking disabled (popularity_max_hashes is -1)", http.StatusNotFound)
+		return
+	}
+
+	limit := defaultPopularLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit (expected a positive number)", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	blobs := h.popularity.Top(limit)
+	if blobs == nil {
+		blobs = []popularity.Entry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(popularResponse{
+		HalfLifeSeconds: int64(h.popularity.HalfLife().Seconds()),
+		Tracked:         h.popularity.Len(),
+		Blobs:           blobs,
+	})
+}
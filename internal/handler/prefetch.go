@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/recovery"
+)
+
+// prefetchCooldown is how long a trending blob isn't prefetched again after an attempt,
+// so its downloads don't start a mirror each while the first one is running or failed
+const prefetchCooldown = 10 * time.Minute
+
+// prefetchAttempts remembers when trending blobs were last prefetched
+type prefetchAttempts struct {
+	mu        sync.Mutex
+	attempted map[string]time.Time // Hash -> time of the last attempt
+}
+
+// start reports whether hash may be prefetched now, recording the attempt if so
+func (p *prefetchAttempts) start(hash string) bool {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if last, exists := p.attempted[hash]; exists && now.Sub(last) < prefetchCooldown {
+		return false
+	}
+	if p.attempted == nil {
+		p.attempted = make(map[string]time.Time)
+	}
+	for h, last := range p.attempted {
+		if now.Sub(last) >= prefetchCooldown {
+			delete(p.attempted, h)
+		}
+	}
+	p.attempted[hash] = now
+	return true
+}
+
+// prefetchTrending mirrors a blob to prefetch_replicas servers in the background when its
+// popularity score reached prefetch_min_popularity while servers (its replicas) has only
+// one entry, so its downloads are spread before that server becomes a bottleneck
+// Blobs still settling after an upload are left alone, their replicas are not all known
+// yet, and so are blocked blobs
+func (h *BlossomHandler) prefetchTrending(hash string, servers []string) {
+	threshold := h.config.Server.PrefetchMinPopularity
+	if h.repairer == nil || threshold <= 0 || len(servers) != 1 {
+		return
+	}
+	if h.popularity.Score(hash) < threshold || len(h.upstreamManager.SettlingServers(hash)) > 0 || h.blocklist.Contains(hash) {
+		return
+	}
+	if !h.prefetches.start(hash) {
+		return
+	}
+
+	go func() {
+		defer recovery.Recover("Prefetch")
+		h.repairer.SpreadHash(context.Background(), hash, h.config.Server.PrefetchReplicas)
+	}()
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/girino/blossom_espelhator/internal/blobstore"
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// proxiedResponseHeaders are the upstream response headers passed through to the client
+// when a download is streamed through the proxy (download_mode: proxy)
+var proxiedResponseHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Range",
+	"Accept-Ranges",
+	"ETag",
+	"Last-Modified",
+	"Cache-Control",
+}
+
+// copyProxiedHeaders copies the proxiedResponseHeaders of an upstream response to the
+// client's response, leaving out hop-by-hop headers and the server's own (CORS, cookies...)
+func copyProxiedHeaders(w http.ResponseWriter, header http.Header) {
+	for _, name := range proxiedResponseHeaders {
+		if value := header.Get(name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+}
+
+// proxiedRequestHeaders are the client request headers passed on to the upstream server
+var proxiedRequestHeaders = []string{
+	"Range",
+	"If-Range",
+	"If-None-Match",
+	"If-Modified-Since",
+}
+
+// upstreamRequestHeaders returns the headers of a GET or HEAD request passed on to the
+// upstream server: Range and conditional headers, and no compression
+func upstreamRequestHeaders(r *http.Request) map[string]string {
+	headers := map[string]string{
+		// The blob is passed through as is, so the transport must not decompress it
+		"Accept-Encoding": "identity",
+	}
+	for _, name := range proxiedRequestHeaders {
+		if value := r.Header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+	return headers
+}
+
+// hasRangeOrConditional reports whether a request carries Range or conditional headers,
+// whose answer (206, 304, 412, 416) depends on the upstream server
+func hasRangeOrConditional(r *http.Request) bool {
+	for _, name := range proxiedRequestHeaders {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyDownload streams a blob from an upstream server to the client instead of redirecting
+// (download_mode: proxy), for clients that can't reach the upstream hosts. selectedServer
+// is tried first, then the other servers holding the blob if it fails before answering
+// The body is copied as it arrives, never buffered in full
+func (h *BlossomHandler) proxyDownload(w http.ResponseWriter, r *http.Request, path string, servers []string, selectedServer string, logPrefix string) {
+	requestHeaders := upstreamRequestHeaders(r)
+
+	candidates := append([]string{selectedServer}, servers...)
+	tried := make(map[string]bool, len(candidates))
+	var resp *http.Response
+	for _, serverURL := range candidates {
+		if tried[serverURL] {
+			continue
+		}
+		tried[serverURL] = true
+
+		cl, err := h.upstreamManager.GetClient(serverURL)
+		if err != nil {
+			continue
+		}
+		// No overall deadline: the transfer lasts as long as the client keeps reading, while
+		// the client's response header timeout still catches unresponsive servers
+		serverResp, err := cl.GetWithHeaders(r.Context(), path, requestHeaders)
+		if err != nil {
+			if r.Context().Err() != nil {
+				markClientAbort(w)
+				return
+			}
+			h.stats.RecordFailure(serverURL, "download")
+			logging.Warnf(r.Context(), "%s: proxied download of %s from %s failed: %v", logPrefix, path, serverURL, err)
+			continue
+		}
+		// Anything but a server error or a missing blob is the blob's answer (including 304
+		// and 416 for conditional and range requests)
+		if serverResp.StatusCode >= 500 || serverResp.StatusCode == http.StatusNotFound {
+			serverResp.Body.Close()
+			h.stats.RecordFailure(serverURL, "download")
+			logging.Warnf(r.Context(), "%s: proxied download of %s from %s failed with status %d", logPrefix, path, serverURL, serverResp.StatusCode)
+			continue
+		}
+		selectedServer, resp = serverURL, serverResp
+		break
+	}
+	if resp == nil {
+		http.Error(w, "Failed to download blob from upstream servers", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	h.stats.RecordSuccess(selectedServer, "download")
+	h.verbose.Debugf(r.Context(), "%s: streaming %s from %s (status %d, content-length=%d)", logPrefix, path, selectedServer, resp.StatusCode, resp.ContentLength)
+
+	setCORSHeaders(w, r)
+	copyProxiedHeaders(w, resp.Header)
+	h.setReplicationHeaders(w, path[:64], len(servers))
+	w.WriteHeader(resp.StatusCode)
+
+	// Complete bodies are kept in the local blob cache (blob_cache_max_bytes)
+	body := io.Reader(resp.Body)
+	var cacheWriter *blobstore.Writer
+	if resp.StatusCode == http.StatusOK {
+		cacheWriter = h.blobStore.Create(path[:64], resp.Header.Get("Content-Type"), resp.ContentLength)
+		if cacheWriter != nil {
+			body = io.TeeReader(resp.Body, cacheWriter)
+		}
+	}
+
+	n, err := io.Copy(w, body)
+	h.stats.RecordServed(n)
+	if err == nil {
+		if err := cacheWriter.Commit(); err != nil {
+			logging.Warnf(r.Context(), "%s: %v (from %s)", logPrefix, err, selectedServer)
+		}
+	} else {
+		cacheWriter.Abort()
+		// The status was already sent, so the client only sees a truncated body
+		if r.Context().Err() != nil {
+			markClientAbort(w)
+			h.verbose.Debugf(r.Context(), "%s: client went away streaming %s after %d bytes", logPrefix, path, n)
+			return
+		}
+		logging.Warnf(r.Context(), "%s: streaming %s from %s failed after %d bytes: %v", logPrefix, path, selectedServer, n, err)
+	}
+}
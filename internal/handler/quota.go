@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/girino/blossom_espelhator/internal/auth"
+	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/quota"
+)
+
+// newUploadQuotas builds the per-pubkey upload quotas of the configuration (nil if none)
+func newUploadQuotas(cfg *config.ServerConfig) *quota.Tracker {
+	global := quota.Limits{MaxBytes: cfg.UploadQuotaBytes, MaxBlobs: cfg.UploadQuotaBlobs}
+
+	// Per-pubkey limits: 0 keeps the global value, -1 is unlimited
+	perPubkey := make(map[string]quota.Limits)
+	for _, override := range cfg.UploadQuotas {
+		limits := global
+		if override.MaxBytes != 0 {
+			limits.MaxBytes = max(override.MaxBytes, 0)
+		}
+		if override.MaxBlobs != 0 {
+			limits.MaxBlobs = max(override.MaxBlobs, 0)
+		}
+		for _, pubkey := range override.Pubkeys {
+			hexPubkey, err := auth.NormalizePubkey(pubkey)
+			if err != nil {
+				logging.Warnf(context.Background(), "Upload quotas: ignoring invalid pubkey %q: %v", pubkey, err)
+				continue
+			}
+			perPubkey[hexPubkey] = limits
+		}
+	}
+	return quota.New(global, perPubkey, cfg.UploadQuotaWindow)
+}
+
+// checkUploadQuota rejects an upload that would exceed the quota of the authenticated
+// pubkey with 413 (blob larger than the quota left) or 429 (quota used up), with
+// Retry-After and X-Reason headers. size is -1 if unknown
+// Returns true if the upload was rejected
+func (h *BlossomHandler) checkUploadQuota(w http.ResponseWriter, r *http.Request, pubkey string, size int64, logPrefix string) bool {
+	err := h.quotas.Check(pubkey, size)
+	if err == nil {
+		return false
+	}
+	exceeded := err.(*quota.ExceededError)
+	logging.Warnf(r.Context(), "%s: rejecting upload from %s: %s", logPrefix, pubkey, exceeded.Reason)
+
+	setCORSHeaders(w, r)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(exceeded.RetryAfter.Seconds()))))
+	w.Header().Set("X-Reason", exceeded.Reason)
+	http.Error(w, exceeded.Reason, exceeded.StatusCode)
+	return true
+}
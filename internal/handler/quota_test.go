@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestUploadQuota(t *testing.T) {
+	srv, requests := countingUpstream(t)
+	secretKey := nostr.GeneratePrivateKey()
+	vipKey := nostr.GeneratePrivateKey()
+	vipPubkey, _ := nostr.GetPublicKey(vipKey)
+	h := newTestHandler(t, "  auth_endpoints: [upload]\n  upload_quota_bytes: 20\n  upload_quota_blobs: 2\n"+
+		"  upload_quotas:\n    - pubkeys: ["+vipPubkey+"]\n      max_blobs: -1\n", srv.URL)
+
+	upload := func(sk string, blob string) *httptest.ResponseRecorder {
+		sum := sha256.Sum256([]byte(blob))
+		req := httptest.NewRequest(http.MethodPut, "/upload", strings.NewReader(blob))
+		req.Header.Set("Authorization", authHeader(t, sk, "upload", hex.EncodeToString(sum[:])))
+		rec := httptest.NewRecorder()
+		h.HandleUpload(rec, req)
+		return rec
+	}
+
+	if rec := upload(secretKey, strings.Repeat("x", 21)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("blob over the byte quota: status = %d, want 413", rec.Code)
+	}
+	for i := 0; i < 2; i++ {
+		if rec := upload(secretKey, fmt.Sprintf("blob %d", i)); rec.Code != http.StatusOK {
+			t.Fatalf("upload %d: status = %d (%s), want 200", i+1, rec.Code, rec.Body.String())
+		}
+	}
+	before := requests.Load()
+	rec := upload(secretKey, "blob 3")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("upload past the blob quota: status = %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("X-Reason") == "" || rec.Header().Get("Retry-After") == "" {
+		t.Error("quota rejection without X-Reason or Retry-After")
+	}
+	if requests.Load() > before {
+		t.Error("upload past the quota was forwarded to the upstream")
+	}
+
+	// The per-pubkey override lifts the blob limit only
+	for i := 0; i < 3; i++ {
+		if rec := upload(vipKey, fmt.Sprintf("vip %d", i)); rec.Code != http.StatusOK {
+			t.Fatalf("vip upload %d: status = %d (%s), want 200", i+1, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := upload(vipKey, strings.Repeat("v", 21)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("vip blob over the byte quota: status = %d, want 413", rec.Code)
+	}
+}
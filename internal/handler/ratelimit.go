@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/girino/blossom_espelhator/internal/auth"
+	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/ratelimit"
+)
+
+// Request classes with their own rate limits
+const (
+	rateClassUpload   = "upload"   // PUT /upload and /mirror, BUD-06 preflights and deletes
+	rateClassDownload = "download" // GET/HEAD of blobs and lists
+)
+
+// classLimits are the rate limits of each request class
+type classLimits map[string]ratelimit.Limit
+
+// rateLimits holds the rate limits of the configuration and the buckets of the clients
+type rateLimits struct {
+	limiter   *ratelimit.Limiter
+	global    classLimits
+	perPubkey map[string]classLimits // Hex pubkey -> limits replacing the global ones
+
+	rejected atomic.Int64 // Requests answered 429
+}
+
+// rateLimit returns the token bucket limit of rps requests per second, burst defaulting
+// to rps rounded up
+func rateLimit(rps float64, burst int) ratelimit.Limit {
+	if burst == 0 {
+		burst = int(math.Ceil(rps))
+	}
+	return ratelimit.Limit{Rate: rps, Burst: burst}
+}
+
+// newRateLimits builds the rate limits of the configuration (nil if none)
+func newRateLimits(cfg *config.ServerConfig) *rateLimits {
+	global := classLimits{
+		rateClassUpload:   rateLimit(cfg.RateLimitUploadRPS, cfg.RateLimitUploadBurst),
+		rateClassDownload: rateLimit(cfg.RateLimitDownloadRPS, cfg.RateLimitDownloadBurst),
+	}
+
+	// Per-pubkey limits: 0 keeps the global value, -1 is unlimited
+	perPubkey := make(map[string]classLimits)
+	for _, override := range cfg.RateLimits {
+		limits := classLimits{rateClassUpload: global[rateClassUpload], rateClassDownload: global[rateClassDownload]}
+		if override.UploadRPS != 0 {
+			limits[rateClassUpload] = rateLimit(max(override.UploadRPS, 0), override.UploadBurst)
+		}
+		if override.DownloadRPS != 0 {
+			limits[rateClassDownload] = rateLimit(max(override.DownloadRPS, 0), override.DownloadBurst)
+		}
+		for _, pubkey := range override.Pubkeys {
+			hexPubkey, err := auth.NormalizePubkey(pubkey)
+			if err != nil {
+				logging.Warnf(context.Background(), "Rate limits: ignoring invalid pubkey %q: %v", pubkey, err)
+				continue
+			}
+			perPubkey[hexPubkey] = limits
+		}
+	}
+
+	if global[rateClassUpload].Unlimited() && global[rateClassDownload].Unlimited() && len(perPubkey) == 0 {
+		return nil
+	}
+	return &rateLimits{
+		limiter:   ratelimit.New(),
+		global:    global,
+		perPubkey: perPubkey,
+	}
+}
+
+// rateClass returns the rate limit class of a request, or "" if it isn't rate limited
+// (status pages, the admin API, the home page and CORS preflights)
+func rateClass(r *http.Request) string {
+	path := r.URL.Path
+	switch r.Method {
+	case http.MethodPut, http.MethodDelete:
+		return rateClassUpload
+	case http.MethodHead:
+		if path == "/upload" {
+			return rateClassUpload
+		}
+		fallthrough
+	case http.MethodGet:
+		if strings.HasPrefix(path, "/list/") || (len(path) > 64 && validatePath(path[1:]) == nil) {
+			return rateClassDownload
+		}
+	}
+	return ""
+}
+
+// rateEndpoint returns the endpoint (a key of authVerbs) of a rate limited request
+func rateEndpoint(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case path == "/upload" && (r.Method == http.MethodPut || r.Method == http.MethodHead):
+		return "upload"
+	case path == "/mirror" && r.Method == http.MethodPut:
+		return "mirror"
+	case r.Method == http.MethodDelete:
+		return "delete"
+	case strings.HasPrefix(path, "/list/"):
+		return "list"
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return "get"
+	}
+	return ""
+}
+
+// rateLimitedPubkey returns the pubkey of a request's valid authorization event if it has
+// limits of its own, or "" (requests of other pubkeys are limited per client address, so
+// signing with fresh keys doesn't get around the limits)
+// The event must carry the endpoint's verb and be bound to the request's method and URL,
+// so events forwarded to upstreams for other requests can't be replayed to borrow a
+// pubkey's limits
+func (rl *rateLimits) rateLimitedPubkey(r *http.Request) string {
+	if len(rl.perPubkey) == 0 || !strings.HasPrefix(strings.ToLower(r.Header.Get("Authorization")), "nostr ") {
+		return ""
+	}
+	verb, ok := authVerbs[rateEndpoint(r)]
+	if !ok {
+		return ""
+	}
+	event, err := auth.ParseAuthorizationHeader(r.Header.Get("Authorization"))
+	if err != nil || auth.ValidateEvent(event, verb, nil, false) != nil || auth.ValidateRequestBinding(event, r) != nil {
+		return ""
+	}
+	pubkey := strings.ToLower(event.PubKey)
+	if _, ok := rl.perPubkey[pubkey]; !ok {
+		return ""
+	}
+	return pubkey
+}
+
+// RateLimit answers 429 with Retry-After to the requests of clients over their rate
+// limit (see rate_limit_upload_rps and rate_limit_download_rps), before they reach the
+// handlers and the upstream servers
+func (h *BlossomHandler) RateLimit(next http.Handler) http.Handler {
+	if h.rateLimits == nil {
+		return next
+	}
+	rl := h.rateLimits
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := rateClass(r)
+		if class == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client, limit := h.realClientAddress(r), rl.global[class]
+		if pubkey := rl.rateLimitedPubkey(r); pubkey != "" {
+			client, limit = "pubkey "+pubkey, rl.perPubkey[pubkey][class]
+		}
+		allowed, wait := rl.limiter.Allow(class+" "+client, limit)
+		if allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rl.rejected.Add(1)
+		h.verbose.Debugf(r.Context(), "RateLimit: %s over its %s rate limit (%g/s, burst %d), rejecting %s %s",
+			client, class, limit.Rate, limit.Burst, r.Method, r.URL.Path)
+		setCORSHeaders(w, r)
+		w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(wait.Seconds())), 1)))
+		w.Header().Set("X-Reason", "Rate limit exceeded, retry later")
+		http.Error(w, "Rate limit exceeded, retry later", http.StatusTooManyRequests)
+	})
+}
+
+// rateLimitingStats summarizes rate limiting for /stats
+func (h *BlossomHandler) rateLimitingStats() map[string]interface{} {
+	if h.rateLimits == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+	return map[string]interface{}{
+		"enabled":          true,
+		"tracked_clients":  h.rateLimits.limiter.Len(),
+		"limited_requests": h.rateLimits.rejected.Load(),
+	}
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRateLimit(t *testing.T) {
+	secretKey := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(secretKey)
+	h := newTestHandler(t, `  rate_limit_download_rps: 0.001
+  rate_limit_download_burst: 1
+  rate_limits:
+    - pubkeys: [`+pubkey+`]
+      download_rps: -1
+`, testAdminUpstream)
+	limited := h.RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	hash := sha256Hex("rate limited blob")
+	target := "/" + hash
+	bound := func(verb, method, target string) string {
+		return authHeaderWithTags(t, secretKey, nostr.Tag{"t", verb}, nostr.Tag{"method", method}, nostr.Tag{"u", "https://proxy.example.com" + target})
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		unlimited     bool // Counted against the pubkey's unlimited downloads
+	}{
+		{"anonymous", "", false},
+		{"bound event of the pubkey", bound("get", http.MethodGet, target), true},
+		{"unbound event of the pubkey", authHeaderWithTags(t, secretKey, nostr.Tag{"t", "get"}), false},
+		{"event for another request", bound("get", http.MethodGet, "/"+sha256Hex("another blob")), false},
+		{"event with another verb", bound("upload", http.MethodGet, target), false},
+		{"event of another pubkey", authHeaderWithTags(t, nostr.GeneratePrivateKey(), nostr.Tag{"t", "get"}, nostr.Tag{"method", http.MethodGet}, nostr.Tag{"u", "https://proxy.example.com" + target}), false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remoteAddr := fmt.Sprintf("192.0.2.%d:1234", i+1) // A fresh bucket per case
+			for n := 1; n <= 3; n++ {
+				req := httptest.NewRequest(http.MethodGet, target, nil)
+				req.RemoteAddr = remoteAddr
+				if tt.authorization != "" {
+					req.Header.Set("Authorization", tt.authorization)
+				}
+				rec := httptest.NewRecorder()
+				limited.ServeHTTP(rec, req)
+
+				if n == 1 || tt.unlimited {
+					if rec.Code != http.StatusOK {
+						t.Fatalf("request %d: status = %d, want allowed", n, rec.Code)
+					}
+					continue
+				}
+				if rec.Code != http.StatusTooManyRequests {
+					t.Fatalf("request %d: status = %d, want 429 past the burst of 1", n, rec.Code)
+				}
+				if rec.Header().Get("Retry-After") == "" || rec.Header().Get("X-Reason") == "" {
+					t.Error("429 without Retry-After and X-Reason")
+				}
+			}
+		})
+	}
+
+	// Status pages aren't limited
+	for n := 0; n < 3; n++ {
+		req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		rec := httptest.NewRecorder()
+		limited.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("/stats status = %d, want never limited", rec.Code)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/girino/blossom_espelhator/internal/stats"
+	"github.com/girino/blossom_espelhator/pkg/blossomclient"
+)
+
+// redactUpstreams reports whether upstream hosts must be hidden from a status page request
+// Requests authenticated for the status pages (see status_access) still see real URLs
+func (h *BlossomHandler) redactUpstreams(r *http.Request) bool {
+	return h.config.Server.RedactUpstreams && !h.statusAuthenticated(r)
+}
+
+// publicMessage hides upstream hosts in a message sent to a client (e.g., an upstream error)
+// Logs keep the original message
+func (h *BlossomHandler) publicMessage(msg string) string {
+	if !h.config.Server.RedactUpstreams {
+		return msg
+	}
+	return h.upstreamManager.Redact(msg)
+}
+
+// redactServerStats re-keys per-server statistics by server label
+func (h *BlossomHandler) redactServerStats(allStats map[string]*stats.ServerStats) map[string]*stats.ServerStats {
+	redacted := make(map[string]*stats.ServerStats, len(allStats))
+	for serverURL, serverStats := range allStats {
+		label := h.upstreamManager.ServerLabel(serverURL)
+		serverStats.URL = label
+		redacted[label] = serverStats
+	}
+	return redacted
+}
+
+// redactConnectionStats re-keys per-server connection statistics by server label
+func (h *BlossomHandler) redactConnectionStats(conns map[string]blossomclient.ConnStats) map[string]blossomclient.ConnStats {
+	redacted := make(map[string]blossomclient.ConnStats, len(conns))
+	for serverURL, connStats := range conns {
+		redacted[h.upstreamManager.ServerLabel(serverURL)] = connStats
+	}
+	return redacted
+}
+
+// redactBreakerStatus re-keys per-server circuit breaker states by server label
+func (h *BlossomHandler) redactBreakerStatus(breakers map[string]*blossomclient.BreakerStatus) map[string]*blossomclient.BreakerStatus {
+	redacted := make(map[string]*blossomclient.BreakerStatus, len(breakers))
+	for serverURL, status := range breakers {
+		redacted[h.upstreamManager.ServerLabel(serverURL)] = status
+	}
+	return redacted
+}
+
+// redactBudgetStatus re-keys request budgets by server label
+func (h *BlossomHandler) redactBudgetStatus(budgets map[string]*blossomclient.BudgetStatus) map[string]*blossomclient.BudgetStatus {
+	redacted := make(map[string]*blossomclient.BudgetStatus, len(budgets))
+	for serverURL, status := range budgets {
+		redacted[h.upstreamManager.ServerLabel(serverURL)] = status
+	}
+	return redacted
+}
+
+// redactBandwidth re-keys the per-server bandwidth of every period by server label
+func (h *BlossomHandler) redactBandwidth(report stats.BandwidthReport) stats.BandwidthReport {
+	for _, periods := range [][]*stats.BandwidthPeriod{report.Days, report.Months} {
+		for _, period := range periods {
+			redacted := make(map[string]*stats.BandwidthTotals, len(period.Servers))
+			for serverURL, totals := range period.Servers {
+				redacted[h.upstreamManager.ServerLabel(serverURL)] = totals
+			}
+			period.Servers = redacted
+		}
+	}
+	return report
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+)
+
+// requestMetrics counts the responses of each endpoint by status class, and the requests
+// whose client went away before the exchange completed, to tell upstream problems apart
+// from client-side flakiness
+type requestMetrics struct {
+	mu        sync.Mutex
+	endpoints map[string]*EndpointMetrics
+}
+
+// EndpointMetrics are the request counters of one endpoint (upload, mirror, download, ...)
+type EndpointMetrics struct {
+	Requests        int64            `json:"requests"`
+	Status          map[string]int64 `json:"status"`        // Responses by status class (2xx, 3xx, 4xx, 5xx), aborted requests excluded
+	ClientAborts    int64            `json:"client_aborts"` // Client disconnected mid-upload/mid-download
+	ClientAbortRate float64          `json:"client_abort_rate"`
+	ServerErrorRate float64          `json:"server_error_rate"` // Share of 5xx responses
+}
+
+// record counts one finished request
+func (rm *requestMetrics) record(endpoint string, statusCode int, aborted bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if rm.endpoints == nil {
+		rm.endpoints = make(map[string]*EndpointMetrics)
+	}
+	em, exists := rm.endpoints[endpoint]
+	if !exists {
+		em = &EndpointMetrics{Status: make(map[string]int64)}
+		rm.endpoints[endpoint] = em
+	}
+	em.Requests++
+	// The client of an aborted request never saw its status (typically an error caused by
+	// the abort itself), so it doesn't count against the server error rate
+	if aborted {
+		em.ClientAborts++
+		return
+	}
+	em.Status[statusClass(statusCode)]++
+}
+
+// snapshot returns a copy of the counters with the rates filled in
+func (rm *requestMetrics) snapshot() map[string]EndpointMetrics {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	snapshot := make(map[string]EndpointMetrics, len(rm.endpoints))
+	for endpoint, em := range rm.endpoints {
+		copied := *em
+		copied.Status = make(map[string]int64, len(em.Status))
+		for class, count := range em.Status {
+			copied.Status[class] = count
+		}
+		copied.ClientAbortRate = ratio(em.ClientAborts, em.Requests)
+		copied.ServerErrorRate = ratio(em.Status["5xx"], em.Requests)
+		snapshot[endpoint] = copied
+	}
+	return snapshot
+}
+
+// ratio returns part/total rounded to 4 decimals (0 if total is 0)
+func ratio(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(total)*10000) / 10000
+}
+
+// statusClass returns the class of a status code ("2xx", "4xx", ...)
+func statusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "other"
+	}
+	return fmt.Sprintf("%dxx", statusCode/100)
+}
+
+// trackRequest counts the request in the endpoint's metrics once it is done
+// Returns a writer capturing the response status, and the function to call at the end
+// of the handler; a request counts as aborted if it was marked with markClientAbort or
+// its client disconnected before the handler finished
+func (h *BlossomHandler) trackRequest(w http.ResponseWriter, r *http.Request, endpoint string) (http.ResponseWriter, func()) {
+	mw := &metricsResponseWriter{ResponseWriter: w}
+	return mw, func() {
+		statusCode := mw.statusCode
+		if statusCode == 0 {
+			statusCode = http.StatusOK
+		}
+		h.requestMetrics.record(endpoint, statusCode, mw.aborted || r.Context().Err() != nil)
+	}
+}
+
+// markClientAbort records that the client of the request written to by w went away
+// mid-transfer (the upload body ended early or writing the response failed)
+func markClientAbort(w http.ResponseWriter) {
+	for w != nil {
+		if mw, ok := w.(*metricsResponseWriter); ok {
+			mw.aborted = true
+			return
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// metricsResponseWriter captures the response status for the request metrics
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	aborted    bool
+}
+
+func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
+	// Informational responses (e.g., 100 Continue) are followed by the actual status
+	if mw.statusCode == 0 && statusCode >= 200 {
+		mw.statusCode = statusCode
+	}
+	mw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+	if mw.statusCode == 0 {
+		mw.statusCode = http.StatusOK
+	}
+	return mw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/girino/blossom_espelhator/internal/upstream"
+)
+
+// uploadContentType returns the content type an upload announces: the request's Content-Type,
+// or X-Content-Type when the body is sent as application/octet-stream (or untyped)
+func uploadContentType(r *http.Request) string {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" || contentType == "application/octet-stream" {
+		if announced := r.Header.Get("X-Content-Type"); announced != "" {
+			return announced
+		}
+	}
+	return contentType
+}
+
+// announcedSize returns the blob size announced in X-Content-Length, or -1 if absent or invalid
+func announcedSize(r *http.Request) int64 {
+	if size, err := strconv.ParseInt(r.Header.Get("X-Content-Length"), 10, 64); err == nil && size >= 0 {
+		return size
+	}
+	return -1
+}
+
+// uploadTargets returns the servers an upload, mirror or preflight goes to from the blob's
+// hash (sharding), content type (content routes) and size (size routes); the hash and
+// content type may be empty and the size -1 when unknown
+// The matching content and size routes are reported in the X-Upload-Route response header
+func (h *BlossomHandler) uploadTargets(w http.ResponseWriter, hash string, contentType string, size int64) upstream.UploadTargets {
+	targets, route := h.upstreamManager.UploadTargetsFor(hash, contentType, size)
+	if route != "" {
+		w.Header().Set("X-Upload-Route", route)
+	}
+	return targets
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/upstream"
+	"github.com/girino/blossom_espelhator/pkg/blossomclient"
+)
+
+// Stable public messages for internal errors
+const (
+	msgUpstreamTimeout     = "upstream request timed out"
+	msgUpstreamUnreachable = "upstream server unreachable"
+	msgBodyAborted         = "request body was not received completely"
+	msgUpstreamFailed      = "upstream request failed"
+)
+
+// publicError logs err in full and returns a stable message that is safe to send to clients:
+// raw upstream errors can contain internal hosts (e.g., alternative_address), upstream
+// response bodies and Go error chains
+func (h *BlossomHandler) publicError(ctx context.Context, err error, logPrefix string) string {
+	msg := sanitizeError(err)
+	if msg != err.Error() {
+		logging.Warnf(ctx, "%s: %v (sent to client as %q)", logPrefix, err, msg)
+	}
+	return h.publicMessage(msg)
+}
+
+// sanitizeError maps an internal error to a stable public message
+func sanitizeError(err error) string {
+	var uploadErr *upstream.UploadError
+	var quorumErr *upstream.QuorumError
+	var httpErr *blossomclient.HTTPError
+	var netErr net.Error
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+
+	switch {
+	case errors.As(err, &uploadErr):
+		return uploadErr.PublicMessage()
+	case errors.As(err, &quorumErr):
+		return quorumErr.Summary
+	case errors.Is(err, upstream.ErrBodyAborted):
+		return msgBodyAborted
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return msgUpstreamTimeout
+	case errors.As(err, &httpErr):
+		return fmt.Sprintf("upstream server returned HTTP %d", httpErr.StatusCode)
+	case errors.As(err, &opErr), errors.As(err, &dnsErr):
+		return msgUpstreamUnreachable
+	default:
+		return msgUpstreamFailed
+	}
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// scanClient tracks the lookups of one client address that no upstream could answer
+type scanClient struct {
+	misses       map[string]time.Time // Missing hash -> when it was last looked up
+	flaggedUntil time.Time            // Lookups of uncached hashes are short-circuited until then
+}
+
+// scanDetector spots clients enumerating hashes (many distinct hashes that no upstream
+// has, from one address) so their lookups stop being fanned out to every upstream
+type scanDetector struct {
+	threshold int
+	window    time.Duration
+	blockFor  time.Duration
+
+	mu       sync.Mutex
+	clients  map[string]*scanClient
+	prunedAt time.Time
+
+	flagged        atomic.Int64 // Times a client was flagged
+	shortCircuited atomic.Int64 // Lookups answered 404 without asking upstreams
+}
+
+// newScanDetector returns a detector flagging a client after threshold distinct missing
+// hashes within window, for blockFor (nil if threshold is 0)
+func newScanDetector(threshold int, window, blockFor time.Duration) *scanDetector {
+	if threshold <= 0 {
+		return nil
+	}
+	return &scanDetector{
+		threshold: threshold,
+		window:    window,
+		blockFor:  blockFor,
+		clients:   make(map[string]*scanClient),
+	}
+}
+
+// clientAddress returns the IP address of the connection the request came from (see
+// realClientAddress for the client behind a trusted proxy)
+func clientAddress(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// recordMiss counts a lookup of hash that no upstream could answer
+// Returns true if it got the client flagged
+func (d *scanDetector) recordMiss(addr string, hash string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.pruneLocked(now)
+	client, exists := d.clients[addr]
+	if !exists {
+		client = &scanClient{misses: make(map[string]time.Time)}
+		d.clients[addr] = client
+	}
+	client.misses[hash] = now
+	for h, at := range client.misses {
+		if now.Sub(at) > d.window {
+			delete(client.misses, h)
+		}
+	}
+	if len(client.misses) < d.threshold || now.Before(client.flaggedUntil) {
+		return false
+	}
+	client.flaggedUntil = now.Add(d.blockFor)
+	client.misses = make(map[string]time.Time)
+	d.flagged.Add(1)
+	return true
+}
+
+// pruneLocked drops clients with neither recent misses nor an active flag, at most once
+// per window (must be called with lock held)
+func (d *scanDetector) pruneLocked(now time.Time) {
+	if now.Sub(d.prunedAt) < d.window {
+		return
+	}
+	d.prunedAt = now
+	for addr, client := range d.clients {
+		if now.Before(client.flaggedUntil) {
+			continue
+		}
+		recent := false
+		for _, at := range client.misses {
+			if now.Sub(at) <= d.window {
+				recent = true
+				break
+			}
+		}
+		if !recent {
+			delete(d.clients, addr)
+		}
+	}
+}
+
+// isFlagged reports whether a client's lookups of uncached hashes are short-circuited
+func (d *scanDetector) isFlagged(addr string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	client, exists := d.clients[addr]
+	return exists && time.Now().Before(client.flaggedUntil)
+}
+
+// activeFlags returns the number of clients currently flagged
+func (d *scanDetector) activeFlags() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	count := 0
+	for _, client := range d.clients {
+		if now.Before(client.flaggedUntil) {
+			count++
+		}
+	}
+	return count
+}
+
+// shortCircuitScan answers 404 to a lookup of an uncached hash from a client flagged as
+// scanning, without asking upstreams. Returns true if the request was answered
+func (h *BlossomHandler) shortCircuitScan(w http.ResponseWriter, r *http.Request, logPrefix string) bool {
+	if h.scans == nil {
+		return false
+	}
+	addr := h.realClientAddress(r)
+	if !h.scans.isFlagged(addr) {
+		return false
+	}
+	h.scans.shortCircuited.Add(1)
+	h.verbose.Debugf(r.Context(), "%s: %s is flagged as scanning hashes, answering 404 without upstream lookup", logPrefix, addr)
+	setCORSHeaders(w, r)
+	w.Header().Set("X-Reason", "Blob not found")
+	http.Error(w, "Blob not found", http.StatusNotFound)
+	return true
+}
+
+// recordScanMiss counts a lookup that no upstream could answer towards hash scanning detection
+func (h *BlossomHandler) recordScanMiss(r *http.Request, path string, logPrefix string) {
+	if h.scans == nil {
+		return
+	}
+	addr := h.realClientAddress(r)
+	if h.scans.recordMiss(addr, path[:64]) {
+		logging.Warnf(r.Context(), "%s: %s looked up %d missing hashes within %v, skipping upstream lookups of uncached hashes for %v",
+			logPrefix, addr, h.scans.threshold, h.scans.window, h.scans.blockFor)
+	}
+}
+
+// scanDetectionStats summarizes hash scanning detection for /stats
+func (h *BlossomHandler) scanDetectionStats() map[string]interface{} {
+	if h.scans == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+	return map[string]interface{}{
+		"enabled":         true,
+		"flagged_clients": h.scans.activeFlags(),
+		"flagged_total":   h.scans.flagged.Load(),
+		"short_circuited": h.scans.shortCircuited.Load(),
+	}
+}
@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/upstream"
+)
+
+// preflightSizeTTL is how long a size announced in a BUD-06 preflight is remembered
+const preflightSizeTTL = 30 * time.Minute
+
+// maxPreflightSizes bounds the sizes remembered from preflights; beyond it, the ones
+// expiring first are forgotten
+const maxPreflightSizes = 10000
+
+// maxMirrorBodyBytes bounds the body of PUT /mirror, a JSON document with the blob's URL
+const maxMirrorBodyBytes = 64 << 10
+
+// mirrorSourceTimeout bounds the HEAD request asking a mirror's source for the blob size
+const mirrorSourceTimeout = 10 * time.Second
+
+// sizeDeclaration is a blob size announced by a client before uploading
+type sizeDeclaration struct {
+	size      int64
+	expiresAt time.Time
+}
+
+// preflightSizes remembers X-Content-Length values from BUD-06 preflight requests by hash,
+// so the following upload can be checked for truncation
+type preflightSizes struct {
+	mu    sync.Mutex
+	sizes map[string]sizeDeclaration
+}
+
+func newPreflightSizes() *preflightSizes {
+	return &preflightSizes{sizes: make(map[string]sizeDeclaration)}
+}
+
+// Record stores the announced size for hash
+func (p *preflightSizes) Record(hash string, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for h, decl := range p.sizes {
+		if now.After(decl.expiresAt) {
+			delete(p.sizes, h)
+		}
+	}
+	if _, exists := p.sizes[hash]; !exists && len(p.sizes) >= maxPreflightSizes {
+		oldest := ""
+		for h, decl := range p.sizes {
+			if oldest == "" || decl.expiresAt.Before(p.sizes[oldest].expiresAt) {
+				oldest = h
+			}
+		}
+		delete(p.sizes, oldest)
+	}
+	p.sizes[hash] = sizeDeclaration{size: size, expiresAt: now.Add(preflightSizeTTL)}
+}
+
+// Take returns and forgets the announced size for hash
+func (p *preflightSizes) Take(hash string) (int64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	decl, exists := p.sizes[hash]
+	if !exists {
+		return 0, false
+	}
+	delete(p.sizes, hash)
+	if time.Now().After(decl.expiresAt) {
+		return 0, false
+	}
+	return decl.size, true
+}
+
+// expectedUploadSize returns the size an upload body must have, announced in the upload's
+// X-Content-Length or in its preflight's (-1 if neither did), answering 400 if the sizes
+// announced disagree with each other or with Content-Length
+// Returns false if the upload was rejected
+func (h *BlossomHandler) expectedUploadSize(w http.ResponseWriter, r *http.Request, hash string, logPrefix string) (int64, bool) {
+	expected := announcedSize(r)
+	if hash != "" {
+		if preflight, exists := h.preflightSizes.Take(hash); exists {
+			if expected >= 0 && expected != preflight {
+				return -1, h.rejectSize(w, r, fmt.Errorf("%w: X-Content-Length is %d but the preflight announced %d", errSizeMismatch, expected, preflight), logPrefix)
+			}
+			expected = preflight
+		}
+	}
+	if expected >= 0 && r.ContentLength >= 0 && r.ContentLength != expected {
+		return -1, h.rejectSize(w, r, fmt.Errorf("%w: Content-Length is %d but %d bytes were announced", errSizeMismatch, r.ContentLength, expected), logPrefix)
+	}
+	return expected, true
+}
+
+// rejectSize answers 400 to an upload announcing inconsistent sizes. Always returns false
+func (h *BlossomHandler) rejectSize(w http.ResponseWriter, r *http.Request, err error, logPrefix string) bool {
+	logging.Warnf(r.Context(), "%s: rejecting upload from %s: %v", logPrefix, r.RemoteAddr, err)
+	rejectMismatch(w, r, err)
+	return false
+}
+
+// excludeSizeMismatches drops servers whose upload descriptor reports a size different from
+// the number of bytes actually streamed to them, which indicates truncation or corruption.
+// Mismatches are counted in the size_mismatches stat. Servers that don't report a size are kept
+func (h *BlossomHandler) excludeSizeMismatches(ctx context.Context, successfulServers []upstream.UploadResultWithResponse, received int64, logPrefix string) []upstream.UploadResultWithResponse {
+	kept := make([]upstream.UploadResultWithResponse, 0, len(successfulServers))
+	for _, srv := range successfulServers {
+		var descriptor map[string]interface{}
+		if err := json.Unmarshal(srv.ResponseBody, &descriptor); err == nil {
+			if size, ok := upstream.DescriptorSize(descriptor); ok && size != received {
+				logging.Warnf(ctx, "%s: %s reported size %d but %d bytes were uploaded, excluding it", logPrefix, srv.ServerURL, size, received)
+				h.stats.RecordSizeMismatch(srv.ServerURL)
+				continue
+			}
+		}
+		kept = append(kept, srv)
+	}
+	return kept
+}
+
+// rejectOversizedUpload answers 413 with an X-Reason header to an upload, mirror or
+// preflight announcing a blob larger than max_upload_bytes (size is -1 if not announced),
+// before anything is forwarded upstream
+// Returns true if the request was rejected
+func (h *BlossomHandler) rejectOversizedUpload(w http.ResponseWriter, r *http.Request, size int64, logPrefix string) bool {
+	maxBytes := h.config.Server.MaxUploadBytes
+	if maxBytes <= 0 || size <= maxBytes {
+		return false
+	}
+	logging.Warnf(r.Context(), "%s: rejecting %s of %d bytes from %s (max_upload_bytes is %d)", logPrefix, r.Method, size, r.RemoteAddr, maxBytes)
+	h.writeTooLarge(w, r)
+	return true
+}
+
+// limitUploadBody makes reading an upload body fail past max_upload_bytes, for clients
+// that didn't announce the size (or announced it wrong)
+func (h *BlossomHandler) limitUploadBody(w http.ResponseWriter, r *http.Request) {
+	if h.config.Server.MaxUploadBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.config.Server.MaxUploadBytes)
+	}
+}
+
+// writeTooLarge answers 413 for a blob larger than max_upload_bytes
+func (h *BlossomHandler) writeTooLarge(w http.ResponseWriter, r *http.Request) {
+	reason := fmt.Sprintf("Blob too large (maximum %d bytes)", h.config.Server.MaxUploadBytes)
+	setCORSHeaders(w, r)
+	w.Header().Set("X-Reason", reason)
+	http.Error(w, reason, http.StatusRequestEntityTooLarge)
+}
+
+// mirrorSourceSize asks the source of a BUD-04 mirror request for the blob's size with a
+// HEAD request, since upstream servers fetch the blob themselves and the X-Content-Length
+// announced by the client can't be trusted
+// Returns an error if the URL isn't http(s) or the source doesn't report the size
+func mirrorSourceSize(ctx context.Context, body []byte) (int64, error) {
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return -1, fmt.Errorf("invalid mirror request: %w", err)
+	}
+	source, err := url.Parse(req.URL)
+	if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Host == "" {
+		return -1, fmt.Errorf("mirror URL %q is not an http(s) URL", req.URL)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mirrorSourceTimeout)
+	defer cancel()
+	headReq, err := http.NewRequestWithContext(ctx, http.MethodHead, source.String(), nil)
+	if err != nil {
+		return -1, err
+	}
+	resp, err := http.DefaultClient.Do(headReq)
+	if err != nil {
+		return -1, fmt.Errorf("failed to get the blob size from the mirror source: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return -1, fmt.Errorf("mirror source answered %d to HEAD", resp.StatusCode)
+	}
+	if resp.ContentLength < 0 {
+		return -1, fmt.Errorf("mirror source doesn't report the blob size")
+	}
+	return resp.ContentLength, nil
+}
+
+// rejectOversizedMirror answers 413 to a mirror whose source reports a blob larger than
+// max_upload_bytes, or 400 if the source can't tell its size
+// Returns the blob size (-1 without max_upload_bytes) and true if the request was rejected
+func (h *BlossomHandler) rejectOversizedMirror(w http.ResponseWriter, r *http.Request, body []byte, logPrefix string) (int64, bool) {
+	if h.config.Server.MaxUploadBytes <= 0 {
+		return -1, false
+	}
+	size, err := mirrorSourceSize(r.Context(), body)
+	if err != nil {
+		logging.Warnf(r.Context(), "%s: rejecting mirror from %s: %v (max_upload_bytes is %d)", logPrefix, r.RemoteAddr, err, h.config.Server.MaxUploadBytes)
+		reason := fmt.Sprintf("Can't verify the blob size: %v", err)
+		setCORSHeaders(w, r)
+		w.Header().Set("X-Reason", reason)
+		http.Error(w, reason, http.StatusBadRequest)
+		return -1, true
+	}
+	return size, h.rejectOversizedUpload(w, r, size, logPrefix)
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/girino/blossom_espelhator/internal/testutil"
+)
+
+func TestMirrorSizeLimit(t *testing.T) {
+	// The source reports the size of /small and /large, and nothing else
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Length", "100")
+		case "/large":
+			w.Header().Set("Content-Length", strconv.Itoa(10<<20))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(source.Close)
+
+	tests := []struct {
+		name     string
+		url      string
+		announce string // X-Content-Length
+		code     int    // 0: forwarded to the upstream
+	}{
+		{"within the limit", source.URL + "/small", "", 0},
+		{"over the limit", source.URL + "/large", "", http.StatusRequestEntityTooLarge},
+		{"over the limit with a smaller announced size", source.URL + "/large", "100", http.StatusRequestEntityTooLarge},
+		{"announced over the limit", source.URL + "/small", strconv.Itoa(10 << 20), http.StatusRequestEntityTooLarge},
+		{"source without the size", source.URL + "/missing", "", http.StatusBadRequest},
+		{"not an http URL", "ftp://example.com/blob", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := countingUpstream(t)
+			cfg := testutil.LoadConfigYAML(t, "upstream_servers:\n  - url: "+srv.URL+"\n    supports_mirror: true\nserver:\n  min_upload_servers: 1\n  max_upload_bytes: 1048576\n")
+			h := newTestHandlerFor(t, cfg, nil)
+
+			req := httptest.NewRequest(http.MethodPut, "/mirror", strings.NewReader(`{"url":"`+tt.url+`"}`))
+			if tt.announce != "" {
+				req.Header.Set("X-Content-Length", tt.announce)
+			}
+			rec := httptest.NewRecorder()
+			h.HandleMirror(rec, req)
+
+			if tt.code == 0 {
+				if requests.Load() == 0 {
+					t.Errorf("mirror was not forwarded (status %d: %s)", rec.Code, rec.Body.String())
+				}
+				return
+			}
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d (%s), want %d", rec.Code, rec.Body.String(), tt.code)
+			}
+			if requests.Load() != 0 {
+				t.Error("rejected mirror was forwarded to the upstream")
+			}
+			if rec.Header().Get("X-Reason") == "" {
+				t.Error("rejection without X-Reason")
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/upstream"
+)
+
+// slowRequestLog counts requests that exceeded the slow request thresholds
+type slowRequestLog struct {
+	requests  atomic.Int64 // Client requests slower than slow_request_threshold
+	upstreams atomic.Int64 // Upstream requests slower than slow_upstream_threshold
+}
+
+// traceSlowRequests records the upstream requests made while handling r, so the request
+// can be logged with a per-upstream breakdown if it turns out to be slow
+// Returns r with the trace attached and a function to call when the request is done;
+// with slow request logging disabled, r is returned unchanged and the function does nothing
+func (h *BlossomHandler) traceSlowRequests(r *http.Request) (*http.Request, func(logPrefix string)) {
+	requestThreshold := h.config.Server.SlowRequestThreshold
+	upstreamThreshold := h.config.Server.SlowUpstreamThreshold
+	if requestThreshold <= 0 && upstreamThreshold <= 0 {
+		return r, func(string) {}
+	}
+
+	start := time.Now()
+	trace := &upstream.RequestTrace{}
+	r = r.WithContext(upstream.WithRequestTrace(r.Context(), trace))
+	return r, func(logPrefix string) {
+		total := time.Since(start)
+		upstreams := trace.Upstreams()
+
+		slowTotal := requestThreshold > 0 && total >= requestThreshold
+		slowUpstreams := 0
+		for _, u := range upstreams {
+			if upstreamThreshold > 0 && u.Duration >= upstreamThreshold {
+				slowUpstreams++
+				h.stats.RecordSlowRequest(u.ServerURL)
+			}
+		}
+		if !slowTotal && slowUpstreams == 0 {
+			return
+		}
+		if slowTotal {
+			h.slowLog.requests.Add(1)
+		}
+		h.slowLog.upstreams.Add(int64(slowUpstreams))
+
+		logging.Warnf(r.Context(), "%s: slow %s %s from %s took %v (%d slow upstream requests); upstreams: %s",
+			logPrefix, r.Method, r.URL.Path, r.RemoteAddr, total.Round(time.Millisecond), slowUpstreams, formatUpstreamTimings(upstreams, upstreamThreshold))
+	}
+}
+
+// formatUpstreamTimings describes each upstream request, slowest first, marking the
+// ones over threshold
+func formatUpstreamTimings(upstreams []upstream.UpstreamTiming, threshold time.Duration) string {
+	if len(upstreams) == 0 {
+		return "none"
+	}
+	sorted := append([]upstream.UpstreamTiming(nil), upstreams...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Duration > sorted[j].Duration })
+
+	parts := make([]string, 0, len(sorted))
+	for _, u := range sorted {
+		part := fmt.Sprintf("%s %s %v", u.ServerURL, u.Operation, u.Duration.Round(time.Millisecond))
+		if threshold > 0 && u.Duration >= threshold {
+			part += " (slow)"
+		}
+		if u.Err != nil {
+			part += fmt.Sprintf(" (failed: %v)", u.Err)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// slowRequestStats summarizes slow request logging for /stats
+func (h *BlossomHandler) slowRequestStats() map[string]interface{} {
+	return map[string]interface{}{
+		"request_threshold":  h.config.Server.SlowRequestThreshold.String(),
+		"upstream_threshold": h.config.Server.SlowUpstreamThreshold.String(),
+		"slow_requests":      h.slowLog.requests.Load(),
+		"slow_upstreams":     h.slowLog.upstreams.Load(),
+	}
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/cache"
+	"github.com/girino/blossom_espelhator/internal/stats"
+	"github.com/girino/blossom_espelhator/internal/version"
+)
+
+// maxSnapshotBytes bounds the body of POST /admin/snapshot
+const maxSnapshotBytes = 256 << 20
+
+// snapshot is the body of GET /admin/snapshot and POST /admin/snapshot
+type snapshot struct {
+	ExportedAt time.Time                    `json:"exported_at"`
+	Version    string                       `json:"version"`
+	Cache      map[string]cache.StoredEntry `json:"cache"` // Hash -> servers holding the blob
+	Stats      *stats.Snapshot              `json:"stats,omitempty"`
+}
+
+// snapshotImportResult is the body of the POST /admin/snapshot response
+type snapshotImportResult struct {
+	CacheEntries int `json:"cache_entries"` // Cache entries imported
+	Servers      int `json:"servers"`       // Upstream servers whose counters were imported
+}
+
+// HandleSnapshot handles /admin/snapshot: GET exports the location cache and statistics
+// as JSON, POST imports such an export, so a new instance (e.g. in a blue/green deploy)
+// doesn't start with a cold cache and empty counters. Only upstream servers this instance
+// has are imported, and cache entries keep the age they had
+// Both always need admin credentials (see requireAdminAuth)
+func (h *BlossomHandler) HandleSnapshot(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !h.requireAdminAuth(w, r) {
+			return
+		}
+		statsSnapshot := h.stats.Export()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="blossom_espelhator-snapshot.json"`)
+		json.NewEncoder(w).Encode(snapshot{
+			ExportedAt: time.Now().UTC(),
+			Version:    version.Version,
+			Cache:      h.cache.Export(),
+			Stats:      &statsSnapshot,
+		})
+	case http.MethodPost:
+		if !h.requireAdminAuth(w, r) {
+			return
+		}
+		var imported snapshot
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSnapshotBytes)).Decode(&imported); err != nil {
+			http.Error(w, "Invalid snapshot: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Paused servers are kept, they come back into rotation when resumed
+		known := make(map[string]bool)
+		for _, server := range h.upstreamManager.Servers() {
+			known[server.URL] = true
+		}
+		keep := func(serverURL string) bool { return known[serverURL] }
+
+		var result snapshotImportResult
+		result.CacheEntries = h.cache.Import(imported.Cache, keep)
+		if imported.Stats != nil {
+			result.Servers = h.stats.Import(*imported.Stats, keep)
+		}
+		log.Printf("HandleSnapshot: imported %d of %d cache entries and the counters of %d servers from a snapshot exported at %s",
+			result.CacheEntries, len(imported.Cache), result.Servers, imported.ExportedAt.Format(time.RFC3339))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
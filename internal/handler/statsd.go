@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/recovery"
+)
+
+// statsdMaxPacket keeps statsd datagrams below a typical path MTU (1500 bytes minus headers)
+const statsdMaxPacket = 1432
+
+// statsdWriter turns the /metrics samples into statsd lines with DogStatsD tags
+// (name:value|type|#label:value,...). Prometheus counters are cumulative while statsd
+// counters are increments, so counters are sent as the difference with the last push
+type statsdWriter struct {
+	prefix     string
+	metricType string
+	previous   map[string]float64 // Last value of each counter series
+	lines      []string
+}
+
+// family remembers the type of the samples that follow
+func (sw *statsdWriter) family(name, metricType, help string) {
+	sw.metricType = metricType
+}
+
+// sample adds the statsd line of one sample
+func (sw *statsdWriter) sample(name string, value float64, labels ...string) {
+	var tags strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			tags.WriteByte(',')
+		}
+		tags.WriteString(labels[i] + ":" + escapeStatsdTag(labels[i+1]))
+	}
+	series := name + "|" + tags.String()
+
+	statsdType := "g"
+	if sw.metricType == "counter" {
+		statsdType = "c"
+		delta := value - sw.previous[series]
+		if delta < 0 {
+			// The counter went down (statistics were reset) - count it from zero again
+			delta = value
+		}
+		sw.previous[series] = value
+		if delta == 0 {
+			return
+		}
+		value = delta
+	}
+
+	line := sw.prefix + name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + statsdType
+	if tags.Len() > 0 {
+		line += "|#" + tags.String()
+	}
+	sw.lines = append(sw.lines, line)
+}
+
+// escapeStatsdTag replaces the characters delimiting statsd fields and tags in a tag value
+func escapeStatsdTag(value string) string {
+	return strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_").Replace(value)
+}
+
+// StartStatsd pushes the /metrics statistics to statsd_addr every statsd_interval until
+// ctx is cancelled, for environments without a Prometheus scraper (disabled unless
+// statsd_addr is set). Upstream URLs are sent as is, even with redact_upstreams
+func (h *BlossomHandler) StartStatsd(ctx context.Context) {
+	addr := h.config.Server.StatsdAddr
+	if addr == "" {
+		return
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		logging.Warnf(ctx, "statsd export disabled: %v", err)
+		return
+	}
+
+	log.Printf("statsd export started (addr=%s, interval=%v, prefix=%q)", addr, h.config.Server.StatsdInterval, h.config.Server.StatsdPrefix)
+
+	sw := &statsdWriter{
+		prefix:   h.config.Server.StatsdPrefix,
+		previous: make(map[string]float64),
+	}
+	go func() {
+		defer conn.Close()
+		ticker := time.NewTicker(h.config.Server.StatsdInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				h.pushStatsd(conn, sw)
+			}
+		}
+	}()
+}
+
+// pushStatsd sends one round of metrics, packing as many lines per datagram as fit
+// A panic skips the round instead of stopping the export
+func (h *BlossomHandler) pushStatsd(conn net.Conn, sw *statsdWriter) {
+	defer recovery.Recover("statsd export")
+
+	sw.lines = sw.lines[:0]
+	h.writeMetrics(sw, false)
+
+	var packet strings.Builder
+	var sent, failed int
+	flush := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := conn.Write([]byte(packet.String())); err != nil {
+			failed++
+			h.verbose.Debugf(context.Background(), "statsd export: write failed: %v", err)
+		} else {
+			sent++
+		}
+		packet.Reset()
+	}
+	for _, line := range sw.lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			flush()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	flush()
+
+	h.verbose.Debugf(context.Background(), "statsd export: %d lines in %d packets (%d failed)", len(sw.lines), sent, failed)
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/girino/blossom_espelhator/internal/version"
+)
+
+// StatusResponse is the compact status served on /status.json for uptime monitors and
+// crawlers, which don't need the per-server details of /health and /stats
+type StatusResponse struct {
+	Healthy        bool   `json:"healthy"`
+	HealthyServers int    `json:"healthy_servers"` // Servers healthy for uploads
+	TotalServers   int    `json:"total_servers"`
+	Version        string `json:"version"`
+	UptimeSeconds  int64  `json:"uptime_seconds"`
+}
+
+// HandleStatusJSON handles GET /status.json requests
+// Always public (like the minimal /health): it reveals no upstream hostnames. Returns 200
+// when healthy and 503 otherwise, with the same checks as /health; memory and goroutines
+// are sampled at most once per second, so frequent polling stays cheap
+func (h *BlossomHandler) HandleStatusJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		setCORSHeaders(w, r)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	healthyServers := h.stats.GetHealthyCountFor("upload")
+	memoryBytes, goroutines := h.loadShedder.sample()
+	healthy := memoryBytes < h.config.Server.MaxMemoryBytes &&
+		goroutines < h.config.Server.MaxGoroutines &&
+		healthyServers >= h.config.Server.MinUploadServers
+
+	response := StatusResponse{
+		Healthy:        healthy,
+		HealthyServers: healthyServers,
+		TotalServers:   len(h.upstreamManager.GetServerURLs()),
+		Version:        version.Version,
+		UptimeSeconds:  int64(h.stats.Uptime().Seconds()),
+	}
+
+	setCORSHeaders(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(response)
+	}
+}
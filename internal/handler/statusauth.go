@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/girino/blossom_espelhator/internal/auth"
+)
+
+// Status page access modes (server.status_access)
+const (
+	statusAccessPublic  = "public"  // Everyone sees full details
+	statusAccessMinimal = "minimal" // Anonymous visitors see minimal output
+	statusAccessAuth    = "auth"    // Anonymous visitors are rejected (except the minimal /health)
+)
+
+// statusAuthenticated reports whether the request carries valid status page credentials:
+// HTTP basic auth matching status_username/status_password, or a Nostr authorization
+// event (kind 24242) with the "status" verb signed by one of status_pubkeys
+func (h *BlossomHandler) statusAuthenticated(r *http.Request) bool {
+	if h.statusBasicAuthenticated(r) {
+		return true
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if len(h.statusPubkeys) == 0 || !strings.HasPrefix(strings.ToLower(authHeader), "nostr ") {
+		return false
+	}
+	// A dedicated verb: the upload and delete events forwarded to upstreams don't open the
+	// status pages
+	if _, err := auth.ValidateAuth(r, auth.VerbStatus, h.statusPubkeys, h.authVerbose.Enabled()); err != nil {
+		h.authVerbose.Debugf(r.Context(), "statusAuthenticated: Nostr authentication failed: %v", err)
+		return false
+	}
+	return true
+}
+
+// statusBasicAuthenticated reports whether the request carries HTTP basic auth matching
+// status_username/status_password
+func (h *BlossomHandler) statusBasicAuthenticated(r *http.Request) bool {
+	if h.config.Server.StatusUsername == "" {
+		return false
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(h.config.Server.StatusUsername)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(password), []byte(h.config.Server.StatusPassword)) == 1
+	return userOK && passOK
+}
+
+// statusDetailsAllowed reports whether the request may see full status details
+// (upstream hostnames, per-server failures, resource usage)
+func (h *BlossomHandler) statusDetailsAllowed(r *http.Request) bool {
+	if h.config.Server.StatusAccess == statusAccessPublic {
+		return true
+	}
+	if _, ok := h.adminAuthenticated(r); ok {
+		return true
+	}
+	return h.statusAuthenticated(r)
+}
+
+// requireStatusAuth rejects anonymous requests with 401 when status_access is "auth"
+// Returns true if the request may proceed
+func (h *BlossomHandler) requireStatusAuth(w http.ResponseWriter, r *http.Request) bool {
+	if h.config.Server.StatusAccess != statusAccessAuth || h.statusDetailsAllowed(r) {
+		return true
+	}
+	h.rejectStatusRequest(w)
+	return false
+}
+
+// requireStatusDetails rejects with 401 requests that may not see full status details
+// (anonymous requests unless status_access is "public"), for admin endpoints that have no
+// minimal variant. Returns true if the request may proceed
+func (h *BlossomHandler) requireStatusDetails(w http.ResponseWriter, r *http.Request) bool {
+	if h.statusDetailsAllowed(r) {
+		return true
+	}
+	h.rejectStatusRequest(w)
+	return false
+}
+
+// rejectStatusRequest answers 401, asking for basic auth credentials when they're configured
+func (h *BlossomHandler) rejectStatusRequest(w http.ResponseWriter) {
+	if h.config.Server.StatusUsername != "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="blossom_espelhator status"`)
+	}
+	w.Header().Set("X-Reason", "Authentication required")
+	http.Error(w, "Authentication required", http.StatusUnauthorized)
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestTimeoutHeaders are the headers a client can use to ask for an upload/mirror deadline,
+// in order of precedence
+var requestTimeoutHeaders = []string{"X-Request-Timeout", "Request-Timeout"}
+
+// requestedTimeout returns the deadline the client asked for with X-Request-Timeout or
+// Request-Timeout, capped at max_request_timeout, or timeout if it didn't ask for one
+// Values are seconds ("600") or Go durations ("10m"); invalid values are ignored
+func (h *BlossomHandler) requestedTimeout(r *http.Request, timeout time.Duration, logPrefix string) time.Duration {
+	for _, name := range requestTimeoutHeaders {
+		value := strings.TrimSpace(r.Header.Get(name))
+		if value == "" {
+			continue
+		}
+
+		requested, ok := parseRequestTimeout(value)
+		if !ok {
+			h.verbose.Debugf(r.Context(), "%s: ignoring invalid %s header '%s'", logPrefix, name, value)
+			return timeout
+		}
+		if maxTimeout := h.config.Server.MaxRequestTimeout; requested > maxTimeout {
+			h.verbose.Debugf(r.Context(), "%s: requested timeout %v exceeds max_request_timeout %v, capped at maximum", logPrefix, requested, maxTimeout)
+			requested = maxTimeout
+		}
+		h.verbose.Debugf(r.Context(), "%s: using timeout %v requested with %s (instead of %v)", logPrefix, requested, name, timeout)
+		return requested
+	}
+	return timeout
+}
+
+// parseRequestTimeout parses a timeout given in seconds or as a Go duration
+func parseRequestTimeout(value string) (time.Duration, bool) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 || seconds > float64(1<<31) {
+			return 0, false
+		}
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return d, true
+}
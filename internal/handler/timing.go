@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/upstream"
+)
+
+// serverTiming collects the Server-Timing metrics of one request
+// A nil *serverTiming (server_timing disabled) ignores everything, so handlers can
+// record metrics unconditionally
+type serverTiming struct {
+	start   time.Time
+	metrics []string
+}
+
+// add records a metric; desc is optional
+func (st *serverTiming) add(name, desc string, d time.Duration) {
+	if st == nil {
+		return
+	}
+	metric := name
+	if desc != "" {
+		metric += fmt.Sprintf(";desc=%q", strings.ReplaceAll(desc, `"`, "'"))
+	}
+	metric += fmt.Sprintf(";dur=%.1f", float64(d)/float64(time.Millisecond))
+	st.metrics = append(st.metrics, metric)
+}
+
+// since records a metric covering the time elapsed since start
+func (st *serverTiming) since(name, desc string, start time.Time) {
+	st.add(name, desc, time.Since(start))
+}
+
+// header returns the Server-Timing header value, ending with the total time so far
+func (st *serverTiming) header() string {
+	metrics := append(append([]string(nil), st.metrics...), fmt.Sprintf("total;dur=%.1f", float64(time.Since(st.start))/float64(time.Millisecond)))
+	return strings.Join(metrics, ", ")
+}
+
+// startServerTiming returns a writer that adds a Server-Timing header when the response
+// starts, and the metrics to fill in, or w unchanged and nil when server_timing is off
+func (h *BlossomHandler) startServerTiming(w http.ResponseWriter) (http.ResponseWriter, *serverTiming) {
+	if !h.config.Server.ServerTiming {
+		return w, nil
+	}
+	st := &serverTiming{start: time.Now()}
+	return &timingResponseWriter{ResponseWriter: w, timing: st}, st
+}
+
+// addSlowestUpstream records how long the slowest successful upstream took
+func (h *BlossomHandler) addSlowestUpstream(st *serverTiming, r *http.Request, results []upstream.UploadResultWithResponse) {
+	if st == nil || len(results) == 0 {
+		return
+	}
+	slowest := results[0]
+	for _, result := range results[1:] {
+		if result.Duration > slowest.Duration {
+			slowest = result
+		}
+	}
+	desc := slowest.ServerURL
+	if h.redactUpstreams(r) {
+		desc = h.upstreamManager.ServerLabel(slowest.ServerURL)
+	}
+	st.add("upstream", desc, slowest.Duration)
+}
+
+// timingResponseWriter sets the Server-Timing header right before the response starts
+type timingResponseWriter struct {
+	http.ResponseWriter
+	timing      *serverTiming
+	wroteHeader bool
+}
+
+func (tw *timingResponseWriter) setHeader() {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	tw.Header().Set("Server-Timing", tw.timing.header())
+}
+
+func (tw *timingResponseWriter) WriteHeader(statusCode int) {
+	tw.setHeader()
+	tw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (tw *timingResponseWriter) Write(b []byte) (int, error) {
+	tw.setHeader()
+	return tw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (tw *timingResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// timedHash accumulates the time spent hashing a streamed body
+type timedHash struct {
+	hash.Hash
+	spent time.Duration
+}
+
+func (th *timedHash) Write(b []byte) (int, error) {
+	start := time.Now()
+	n, err := th.Hash.Write(b)
+	th.spent += time.Since(start)
+	return n, err
+}
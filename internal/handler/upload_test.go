@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/cache"
+	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/journal"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/quarantine"
+	"github.com/girino/blossom_espelhator/internal/stats"
+	"github.com/girino/blossom_espelhator/internal/testutil"
+	"github.com/girino/blossom_espelhator/internal/upstream"
+)
+
+// newTestHandler returns a handler for the given upstream URLs, with the server settings
+// of extra (YAML lines indented under server:)
+func newTestHandler(t *testing.T, extra string, urls ...string) *BlossomHandler {
+	t.Helper()
+	cfg := testutil.LoadConfig(t, fmt.Sprintf("  min_upload_servers: %d\n%s", len(urls), extra), urls...)
+	return newTestHandlerFor(t, cfg, nil)
+}
+
+// newTestHandlerFor returns a handler for cfg, recording background work in pendingOps (may be nil)
+func newTestHandlerFor(t *testing.T, cfg *config.Config, pendingOps *journal.Journal) *BlossomHandler {
+	t.Helper()
+	debug := logging.NewLevels(nil)
+	manager, err := upstream.New(cfg, debug)
+	if err != nil {
+		t.Fatalf("upstream manager: %v", err)
+	}
+	statsTracker := stats.New(cfg.Server.MaxFailures)
+	statsTracker.InitializeServers(manager.GetServerURLs())
+	return New(manager, cache.New(cfg.Server.CacheTTL, cfg.Server.CacheMaxSize), quarantine.New(), nil, pendingOps, statsTracker, cfg, debug)
+}
+
+func TestHandleUploadClientDisconnect(t *testing.T) {
+	started := make(chan struct{}, 2)
+	srv1, uploads1 := testutil.UploadingUpstream(t, started)
+	srv2, uploads2 := testutil.UploadingUpstream(t, started)
+	h := newTestHandler(t, "", srv1.URL, srv2.URL)
+
+	blob := bytes.Repeat([]byte("blossom"), 64<<10)
+	sent := blob[:len(blob)/4]
+	body := &testutil.DisconnectingBody{Data: bytes.NewReader(sent), Started: started, Upstreams: 2}
+	req := httptest.NewRequest(http.MethodPut, "/upload", body)
+	req.Header.Set("Content-Length", strconv.Itoa(len(blob)))
+	req.ContentLength = int64(len(blob))
+	rec := httptest.NewRecorder()
+
+	h.HandleUpload(rec, req)
+
+	if rec.Code == http.StatusOK {
+		t.Fatalf("status = %d, want the aborted upload to fail", rec.Code)
+	}
+	if got := rec.Body.String(); !strings.Contains(got, msgBodyAborted) {
+		t.Errorf("body = %q, want %q", got, msgBodyAborted)
+	}
+	for i, uploads := range []<-chan testutil.Upload{uploads1, uploads2} {
+		select {
+		case upload := <-uploads:
+			if upload.Complete {
+				t.Errorf("upstream %d received a complete body, want the upload aborted", i+1)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("upstream %d never saw the upload", i+1)
+		}
+	}
+
+	// Neither the whole blob nor the part received may be recorded anywhere
+	for _, data := range [][]byte{blob, sent} {
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		if servers := h.upstreamManager.SettlingServers(hash); len(servers) > 0 {
+			t.Errorf("replicas recorded for %s: %v", hash, servers)
+		}
+		if servers, exists := h.cache.Get(hash); exists {
+			t.Errorf("cached locations for %s: %v", hash, servers)
+		}
+		if _, exists := h.recentUploads.Lookup(hash, ""); exists {
+			t.Errorf("upload of %s recorded as completed", hash)
+		}
+	}
+	// The client is at fault, not the upstreams
+	for _, url := range []string{srv1.URL, srv2.URL} {
+		if failures := h.stats.GetFailuresFor(url, "upload"); failures != 0 {
+			t.Errorf("%d upload failures recorded for %s, want 0", failures, url)
+		}
+	}
+}
+
+func TestHandleUploadRejectsSizeMismatch(t *testing.T) {
+	blob := []byte("twelve bytes")
+	sum := sha256.Sum256(blob)
+	hash := hex.EncodeToString(sum[:])
+
+	for _, tc := range []struct {
+		name          string
+		preflight     int64
+		contentLength bool // Send the body with a Content-Length instead of chunked
+		forwarded     bool // Part of the body may reach the upstream before the mismatch shows
+	}{
+		{"longer than the preflight", 10, false, true},
+		{"shorter than the preflight", 20, false, true},
+		{"content length differs from the preflight", 10, true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			started := make(chan struct{}, 1)
+			srv, uploads := testutil.UploadingUpstream(t, started)
+			h := newTestHandler(t, "", srv.URL)
+			h.preflightSizes.Record(hash, tc.preflight)
+
+			var body io.Reader = bytes.NewReader(blob)
+			if !tc.contentLength {
+				body = io.MultiReader(body) // Hides the length, so the request is chunked
+			}
+			req := httptest.NewRequest(http.MethodPut, "/upload", body)
+			req.Header.Set(checksumHeader, hash)
+			rec := httptest.NewRecorder()
+
+			h.HandleUpload(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", rec.Code)
+			}
+			if got := rec.Header().Get("X-Reason"); !strings.Contains(got, errSizeMismatch.Error()) {
+				t.Errorf("X-Reason = %q, want a size mismatch", got)
+			}
+			select {
+			case upload := <-uploads:
+				if !tc.forwarded {
+					t.Error("upload forwarded although the sizes announced disagree")
+				} else if upload.Complete {
+					t.Errorf("upstream received a complete body of %d bytes, want the upload aborted", upload.Received)
+				}
+			case <-time.After(200 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestPreflightSizesBounded(t *testing.T) {
+	p := newPreflightSizes()
+	for i := 0; i <= maxPreflightSizes; i++ {
+		p.Record(fmt.Sprintf("%064x", i), int64(i))
+	}
+	if n := len(p.sizes); n != maxPreflightSizes {
+		t.Errorf("%d sizes remembered, want at most %d", n, maxPreflightSizes)
+	}
+	if _, exists := p.Take(fmt.Sprintf("%064x", 0)); exists {
+		t.Error("oldest size still remembered past the limit")
+	}
+	if size, exists := p.Take(fmt.Sprintf("%064x", maxPreflightSizes)); !exists || size != maxPreflightSizes {
+		t.Errorf("newest size = %d (%t), want %d", size, exists, maxPreflightSizes)
+	}
+}
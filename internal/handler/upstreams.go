@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/upstream"
+)
+
+// maxUpstreamRequestBytes bounds the body of POST /admin/upstreams
+const maxUpstreamRequestBytes = 64 << 10
+
+// upstreamRequest is the body of POST /admin/upstreams
+type upstreamRequest struct {
+	URL    string `json:"url"`
+	Action string `json:"action"` // "add" (default), "pause" or "resume"
+
+	// Settings of an added server, as in upstream_servers
+	Priority           int    `json:"priority"`
+	Weight             int    `json:"weight"`
+	AlternativeAddress string `json:"alternative_address"`
+	ForceHTTP1         bool   `json:"force_http1"`
+	Compression        string `json:"compression"`
+	SupportsMirror     *bool  `json:"supports_mirror"`
+	SupportsUploadHead *bool  `json:"supports_upload_head"`
+	SupportsList       *bool  `json:"supports_list"`
+	SupportsDelete     *bool  `json:"supports_delete"`
+	Operator           string `json:"operator"`
+	Contact            string `json:"contact"`
+	StatusPageURL      string `json:"status_page_url"`
+
+	MaxFailures            int                 `json:"max_failures"`
+	MaxFailuresByOperation map[string]int      `json:"max_failures_by_operation"`
+	MaintenanceWindows     []config.TimeWindow `json:"maintenance_windows"` // Fields named as in the configuration file
+
+	Chaos *chaosRequest `json:"chaos"` // Only injected when the proxy runs with -enable-chaos
+}
+
+// chaosRequest is the chaos section of an added server, with durations as in the
+// configuration file (e.g., "200ms")
+type chaosRequest struct {
+	Latency       string   `json:"latency"`
+	LatencyJitter string   `json:"latency_jitter"`
+	FailureRate   float64  `json:"failure_rate"`
+	FailureStatus int      `json:"failure_status"`
+	Operations    []string `json:"operations"`
+}
+
+// config converts the request to the chaos settings of upstream_servers
+func (c *chaosRequest) config() (*config.ChaosConfig, error) {
+	if c == nil {
+		return nil, nil
+	}
+	cfg := &config.ChaosConfig{
+		FailureRate:   c.FailureRate,
+		FailureStatus: c.FailureStatus,
+		Operations:    c.Operations,
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"latency", c.Latency, &cfg.Latency},
+		{"latency_jitter", c.LatencyJitter, &cfg.LatencyJitter},
+	} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chaos %s %q: %w", d.name, d.value, err)
+		}
+		*d.dst = parsed
+	}
+	return cfg, nil
+}
+
+// HandleUpstreams handles /admin/upstreams: GET lists the upstream servers, POST adds one
+// or pauses/resumes one, DELETE ?url=<server URL> removes one. Changes apply to this
+// instance until it restarts; the configuration file is not modified
+// Listing needs full status access, changes always need admin credentials
+func (h *BlossomHandler) HandleUpstreams(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !h.requireStatusDetails(w, r) {
+			return
+		}
+	case http.MethodPost, http.MethodDelete:
+		if !h.requireAdminAuth(w, r) {
+			return
+		}
+		if err := h.changeUpstreams(r); err != nil {
+			status := http.StatusBadRequest
+			switch {
+			case errors.Is(err, upstream.ErrServerNotFound):
+				status = http.StatusNotFound
+			case errors.Is(err, upstream.ErrServerExists), errors.Is(err, upstream.ErrTooFewServers):
+				status = http.StatusConflict
+			}
+			logging.Warnf(r.Context(), "HandleUpstreams: %s rejected: %v", r.Method, err)
+			http.Error(w, err.Error(), status)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	servers := h.upstreamManager.Servers()
+	if h.redactUpstreams(r) {
+		for i := range servers {
+			servers[i].URL = h.upstreamManager.ServerLabel(servers[i].URL)
+			servers[i].Operator = nil
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]upstream.ServerInfo{"servers": servers})
+}
+
+// changeUpstreams applies a POST or DELETE to /admin/upstreams
+// Stats, the location cache and the cluster follow added and removed servers through the
+// manager's server observer
+func (h *BlossomHandler) changeUpstreams(r *http.Request) error {
+	if r.Method == http.MethodDelete {
+		serverURL := r.URL.Query().Get("url")
+		if serverURL == "" {
+			return fmt.Errorf("missing url parameter")
+		}
+		return h.upstreamManager.RemoveServer(serverURL)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxUpstreamRequestBytes))
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+	var req upstreamRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	if req.URL == "" {
+		return fmt.Errorf("missing url")
+	}
+
+	switch req.Action {
+	case "", "add":
+		chaosCfg, err := req.Chaos.config()
+		if err != nil {
+			return err
+		}
+		err = h.upstreamManager.AddServer(config.UpstreamServer{
+			URL:                req.URL,
+			Priority:           req.Priority,
+			Weight:             req.Weight,
+			AlternativeAddress: req.AlternativeAddress,
+			ForceHTTP1:         req.ForceHTTP1,
+			Compression:        req.Compression,
+			SupportsMirror:     req.SupportsMirror,
+			SupportsUploadHead: req.SupportsUploadHead,
+			SupportsList:       req.SupportsList,
+			SupportsDelete:     req.SupportsDelete,
+			Operator:           req.Operator,
+			Contact:            req.Contact,
+			StatusPageURL:      req.StatusPageURL,
+			Chaos:              chaosCfg,
+
+			MaxFailures:            req.MaxFailures,
+			MaxFailuresByOperation: req.MaxFailuresByOperation,
+			MaintenanceWindows:     req.MaintenanceWindows,
+		})
+		if err != nil {
+			return err
+		}
+	case "pause":
+		if err := h.upstreamManager.SetPaused(req.URL, true); err != nil {
+			return err
+		}
+		// Blobs are looked up again on the servers in rotation rather than redirected to it
+		h.cache.ForgetServer(req.URL)
+	case "resume":
+		if err := h.upstreamManager.SetPaused(req.URL, false); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid action %q (expected add, pause or resume)", req.Action)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/girino/blossom_espelhator/internal/upstream"
+)
+
+// urlTagCandidate is a BUD-08 url tag value and the upstream server it points to
+type urlTagCandidate struct {
+	url       string
+	serverURL string
+}
+
+// applyURLTags replaces the url tags in tags according to the url_tags_* configuration
+// Candidates are the selected server's descriptor URL and nip94 url tags, followed by the
+// descriptor URLs of the other successful servers. Depending on configuration they are
+// reordered, restricted to healthy servers, limited in number, replaced with a single
+// proxy URL, or dropped altogether
+func (h *BlossomHandler) applyURLTags(tags []interface{}, selectedResponse map[string]interface{}, successfulServers []upstream.UploadResultWithResponse,
+	selectedServer string, hash string, mimeType string, r *http.Request, logPrefix string) []interface{} {
+	// Split existing url tags (from the selected server's nip94) from other tags
+	result := make([]interface{}, 0, len(tags))
+	candidates := make([]urlTagCandidate, 0, len(successfulServers)+1)
+	if urlVal, ok := selectedResponse["url"].(string); ok && urlVal != "" {
+		candidates = append(candidates, urlTagCandidate{url: urlVal, serverURL: selectedServer})
+	}
+	for _, tag := range tags {
+		if tagArray, ok := tag.([]interface{}); ok && len(tagArray) >= 2 {
+			if typeVal, ok := tagArray[0].(string); ok && typeVal == "url" {
+				if urlVal, ok := tagArray[1].(string); ok && urlVal != "" {
+					candidates = append(candidates, urlTagCandidate{url: urlVal, serverURL: selectedServer})
+				}
+				continue
+			}
+		}
+		result = append(result, tag)
+	}
+
+	switch h.config.Server.URLTagsMode {
+	case "none":
+		return result
+	case "proxy":
+		if hash != "" {
+			result = append(result, []interface{}{"url", h.constructLocalURL(hash, mimeType, r)})
+		}
+		return result
+	}
+
+	// Collect URLs from all other successful servers
+	for _, srv := range successfulServers {
+		if srv.ServerURL == selectedServer {
+			continue
+		}
+		var srvData map[string]interface{}
+		if err := json.Unmarshal(srv.ResponseBody, &srvData); err != nil {
+			h.verbose.Debugf(r.Context(), "%s: failed to parse server response from %s: %v", logPrefix, srv.ServerURL, err)
+			continue
+		}
+		if urlVal, ok := srvData["url"].(string); ok && urlVal != "" {
+			candidates = append(candidates, urlTagCandidate{url: urlVal, serverURL: srv.ServerURL})
+		}
+	}
+
+	// upstream_order lists URLs in the order servers appear in upstream_servers
+	if h.config.Server.URLTagsOrder == "upstream_order" {
+		order := make(map[string]int)
+		for i, serverURL := range h.upstreamManager.GetServerURLs() {
+			order[serverURL] = i
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return order[candidates[i].serverURL] < order[candidates[j].serverURL]
+		})
+	}
+
+	var health map[string]bool
+	if h.config.Server.URLTagsHealthyOnly {
+		health = h.stats.GetHealthStatusFor("download")
+	}
+
+	seen := make(map[string]bool)
+	added := 0
+	for _, candidate := range candidates {
+		if seen[candidate.url] {
+			continue
+		}
+		if health != nil {
+			if healthy, known := health[candidate.serverURL]; known && !healthy {
+				h.verbose.Debugf(r.Context(), "%s: skipping url tag %s from unhealthy server %s", logPrefix, candidate.url, candidate.serverURL)
+				continue
+			}
+		}
+		if h.config.Server.MaxURLTags > 0 && added >= h.config.Server.MaxURLTags {
+			break
+		}
+		seen[candidate.url] = true
+		result = append(result, []interface{}{"url", candidate.url})
+		added++
+	}
+
+	return result
+}
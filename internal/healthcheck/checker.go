@@ -0,0 +1,135 @@
+package healthcheck
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/recovery"
+	"github.com/girino/blossom_espelhator/internal/stats"
+	"github.com/girino/blossom_espelhator/internal/upstream"
+)
+
+// Checker periodically probes every upstream server with Client.CheckHealth
+// A server failing failureThreshold probes in a row is marked unhealthy, and an unhealthy
+// server answering recoveryThreshold probes in a row is marked healthy again, so servers
+// recover without live traffic being sent to them first
+type Checker struct {
+	upstreamManager   *upstream.Manager
+	stats             *stats.Stats
+	interval          time.Duration
+	timeout           time.Duration
+	failureThreshold  int
+	recoveryThreshold int
+	verbose           *logging.Flag
+
+	// Probe results in a row, by server URL (only touched by the checker goroutine)
+	successes map[string]int
+	failures  map[string]int
+}
+
+// New creates a new health checker
+// interval is the time between probe rounds and timeout bounds each probe
+func New(upstreamManager *upstream.Manager, statsTracker *stats.Stats, interval, timeout time.Duration, failureThreshold, recoveryThreshold int, verbose *logging.Flag) *Checker {
+	return &Checker{
+		upstreamManager:   upstreamManager,
+		stats:             statsTracker,
+		interval:          interval,
+		timeout:           timeout,
+		failureThreshold:  failureThreshold,
+		recoveryThreshold: recoveryThreshold,
+		verbose:           verbose,
+		successes:         make(map[string]int),
+		failures:          make(map[string]int),
+	}
+}
+
+// Start runs the checker in the background until ctx is cancelled
+func (c *Checker) Start(ctx context.Context) {
+	if c.interval <= 0 {
+		return
+	}
+
+	log.Printf("Health checker started (interval=%v, failure_threshold=%d, recovery_threshold=%d)",
+		c.interval, c.failureThreshold, c.recoveryThreshold)
+
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.runChecks(ctx)
+			}
+		}
+	}()
+}
+
+// runChecks runs one round of probes; a panic skips the round instead of stopping the checker
+func (c *Checker) runChecks(ctx context.Context) {
+	defer recovery.Recover("Health checker")
+	c.CheckAll(ctx)
+}
+
+// CheckAll probes every upstream server in parallel and updates their health
+func (c *Checker) CheckAll(ctx context.Context) {
+	serverURLs := c.upstreamManager.GetServerURLs()
+	results := make([]error, len(serverURLs))
+
+	var wg sync.WaitGroup
+	for i, serverURL := range serverURLs {
+		wg.Add(1)
+		go func(i int, serverURL string) {
+			defer wg.Done()
+			defer recovery.Recover("Health check of " + serverURL)
+			results[i] = c.probe(ctx, serverURL)
+		}(i, serverURL)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		// Shutting down - cancelled probes say nothing about the servers
+		return
+	}
+
+	for i, serverURL := range serverURLs {
+		c.record(serverURL, results[i])
+	}
+}
+
+// probe checks whether a server is reachable
+func (c *Checker) probe(ctx context.Context, serverURL string) error {
+	cl, err := c.upstreamManager.GetClient(serverURL)
+	if err != nil {
+		return err
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return cl.CheckHealth(probeCtx)
+}
+
+// record counts a probe result and flips the server's health once a threshold is reached
+func (c *Checker) record(serverURL string, err error) {
+	if err != nil {
+		c.successes[serverURL] = 0
+		c.failures[serverURL]++
+		c.verbose.Debugf(context.Background(), "Health check: %s failed (%d in a row): %v", serverURL, c.failures[serverURL], err)
+		if c.failures[serverURL] >= c.failureThreshold && c.stats.MarkUnhealthy(serverURL) {
+			logging.Warnf(context.Background(), "Health check: %s failed %d probes in a row, marked unhealthy: %v%s",
+				serverURL, c.failures[serverURL], err, c.upstreamManager.ContactHint(serverURL))
+		}
+		return
+	}
+
+	c.failures[serverURL] = 0
+	c.successes[serverURL]++
+	if c.successes[serverURL] >= c.recoveryThreshold && c.stats.MarkHealthy(serverURL) {
+		log.Printf("Health check: %s answered %d probes in a row, marked healthy", serverURL, c.successes[serverURL])
+	}
+}
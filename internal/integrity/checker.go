@@ -0,0 +1,197 @@
+package integrity
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/cache"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/quarantine"
+	"github.com/girino/blossom_espelhator/internal/recovery"
+	"github.com/girino/blossom_espelhator/internal/stats"
+	"github.com/girino/blossom_espelhator/internal/upstream"
+	"github.com/girino/blossom_espelhator/pkg/blossomclient"
+)
+
+// Checker periodically samples a cached blob and verifies its SHA-256 on every server holding it
+// Servers returning corrupt data are removed from that blob's replica set in the cache and quarantined
+// Each run also re-verifies one quarantined replica so repaired servers can be released
+type Checker struct {
+	upstreamManager *upstream.Manager
+	cache           *cache.Cache
+	quarantine      *quarantine.Quarantine
+	stats           *stats.Stats
+	interval        time.Duration
+	timeout         time.Duration
+	maxBytes        int64
+	verbose         *logging.Flag
+	shouldRun       func() bool // Whether this instance runs the checks (optional, e.g. only the cluster leader)
+}
+
+// New creates a new integrity checker
+// interval is the time between checks, timeout bounds each download, and blobs larger than maxBytes are skipped
+func New(upstreamManager *upstream.Manager, c *cache.Cache, q *quarantine.Quarantine, statsTracker *stats.Stats, interval, timeout time.Duration, maxBytes int64, verbose *logging.Flag) *Checker {
+	return &Checker{
+		upstreamManager: upstreamManager,
+		cache:           c,
+		quarantine:      q,
+		stats:           statsTracker,
+		interval:        interval,
+		timeout:         timeout,
+		maxBytes:        maxBytes,
+		verbose:         verbose,
+	}
+}
+
+// SetGate sets a function deciding before each round whether this instance runs it, so
+// instances sharing state in a cluster don't all download the same blobs
+func (c *Checker) SetGate(shouldRun func() bool) {
+	c.shouldRun = shouldRun
+}
+
+// Start runs the checker in the background until ctx is cancelled
+func (c *Checker) Start(ctx context.Context) {
+	if c.interval <= 0 {
+		return
+	}
+
+	log.Printf("Integrity checker started (interval=%v, max_bytes=%d)", c.interval, c.maxBytes)
+
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.runChecks(ctx)
+			}
+		}
+	}()
+}
+
+// runChecks runs one round of checks; a panic skips the round instead of stopping the checker
+func (c *Checker) runChecks(ctx context.Context) {
+	defer recovery.Recover("Integrity checker")
+	if c.shouldRun != nil && !c.shouldRun() {
+		c.verbose.Debugf(ctx, "Integrity: skipping round, another cluster instance runs the checks")
+		return
+	}
+	c.CheckRandom(ctx)
+	c.RecheckQuarantined(ctx)
+}
+
+// CheckRandom picks a random cached hash and verifies it on every server holding it
+func (c *Checker) CheckRandom(ctx context.Context) {
+	entries := c.cache.Snapshot()
+	if len(entries) == 0 {
+		c.verbose.Debugf(ctx, "Integrity: cache is empty, nothing to check")
+		return
+	}
+
+	// Pick a random entry
+	pick := rand.Intn(len(entries))
+	for hash, servers := range entries {
+		if pick == 0 {
+			c.CheckHash(ctx, hash, servers)
+			return
+		}
+		pick--
+	}
+}
+
+// CheckHash downloads the blob from each given server and verifies its SHA-256
+// Returns the list of servers that served corrupt data
+func (c *Checker) CheckHash(ctx context.Context, hash string, servers []string) []string {
+	corrupt := make([]string, 0)
+	for _, serverURL := range servers {
+		ok, err := c.verify(ctx, hash, serverURL)
+		if err != nil {
+			// Transient errors (timeouts, 404s, oversized blobs) are not evidence of corruption
+			c.verbose.Debugf(ctx, "Integrity: could not verify %s on %s: %v", hash, serverURL, err)
+			continue
+		}
+
+		c.stats.RecordIntegrityCheck(serverURL, !ok)
+		if !ok {
+			logging.Warnf(ctx, "Integrity: %s returned corrupt data for %s, quarantining replica", serverURL, hash)
+			c.cache.RemoveServer(hash, serverURL)
+			c.quarantine.Add(hash, serverURL, "sha256 mismatch")
+			corrupt = append(corrupt, serverURL)
+		} else {
+			c.quarantine.Release(hash, serverURL)
+			c.verbose.Debugf(ctx, "Integrity: %s verified on %s", hash, serverURL)
+		}
+	}
+	return corrupt
+}
+
+// RecheckQuarantined re-verifies one random quarantined replica
+// If the server now serves the correct data, the replica is released from quarantine
+func (c *Checker) RecheckQuarantined(ctx context.Context) {
+	entries := c.quarantine.List()
+	if len(entries) == 0 {
+		return
+	}
+
+	entry := entries[rand.Intn(len(entries))]
+	c.verbose.Debugf(ctx, "Integrity: re-verifying quarantined replica %s on %s", entry.Hash, entry.ServerURL)
+	if corrupt := c.CheckHash(ctx, entry.Hash, []string{entry.ServerURL}); len(corrupt) == 0 && !c.quarantine.IsQuarantined(entry.Hash, entry.ServerURL) {
+		log.Printf("Integrity: replica %s on %s re-verified, released from quarantine", entry.Hash, entry.ServerURL)
+	}
+}
+
+// verify downloads a blob from a server and reports whether it matches its hash
+func (c *Checker) verify(ctx context.Context, hash string, serverURL string) (bool, error) {
+	cl, err := c.upstreamManager.GetClient(serverURL)
+	if err != nil {
+		return false, err
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	resp, err := cl.Get(checkCtx, hash)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if c.maxBytes > 0 && resp.ContentLength > c.maxBytes {
+		return false, fmt.Errorf("blob too large to check (%d > %d bytes)", resp.ContentLength, c.maxBytes)
+	}
+
+	// A server compressing the blob unasked is checked against the decoded bytes
+	body, err := blossomclient.DecodedBody(resp)
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+
+	hasher := sha256.New()
+	reader := io.Reader(body)
+	if c.maxBytes > 0 {
+		reader = io.LimitReader(body, c.maxBytes+1)
+	}
+	n, err := io.Copy(hasher, reader)
+	if err != nil {
+		return false, fmt.Errorf("failed to read blob: %w", err)
+	}
+	if c.maxBytes > 0 && n > c.maxBytes {
+		return false, fmt.Errorf("blob too large to check (> %d bytes)", c.maxBytes)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)) == hash, nil
+}
@@ -0,0 +1,372 @@
+package journal
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/recovery"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Kinds of background work recorded in the journal
+const (
+	KindMirror = "mirror" // Push a blob to a server with BUD-04 mirror (retried uploads and mirrors)
+	KindDelete = "delete" // Delete a blob from a server
+	KindUpload = "upload" // Upload a locally queued blob to a server
+)
+
+// maxBackoff caps the delay between attempts of a single entry
+const maxBackoff = time.Hour
+
+var bucketName = []byte("pending")
+
+// Entry is a unit of background work against a single upstream server
+type Entry struct {
+	ID          uint64            `json:"id"`
+	Kind        string            `json:"kind"`
+	Hash        string            `json:"hash"`
+	ServerURL   string            `json:"server_url"`
+	Headers     map[string]string `json:"headers,omitempty"` // Headers to replay (e.g., Authorization)
+	Body        []byte            `json:"body,omitempty"`    // Request body to replay (e.g., mirror JSON)
+	Attempts    int               `json:"attempts"`
+	LastError   string            `json:"last_error,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	NextAttempt time.Time         `json:"next_attempt"`
+	ExpiresAt   time.Time         `json:"expires_at"` // Dropped after this time (e.g., when its auth event expires)
+}
+
+// Handler performs the work for an entry. A nil error completes the entry
+type Handler func(ctx context.Context, entry Entry) error
+
+// Journal is a persistent queue of pending background operations backed by BoltDB
+// Entries survive restarts and crashes; they are retried with exponential backoff
+// until they succeed, expire, or run out of attempts
+type Journal struct {
+	db          *bolt.DB
+	interval    time.Duration
+	timeout     time.Duration
+	maxAttempts int
+	maxAge      time.Duration
+	verbose     *logging.Flag
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	deferred func(entry Entry) bool // Entries left for another instance to run (optional, see SetDeferred)
+}
+
+// Open opens (or creates) the journal database at path
+// interval is how often due entries are processed (and the base retry delay), timeout
+// bounds each attempt, and entries without an expiration are dropped after maxAge
+func Open(path string, interval time.Duration, timeout time.Duration, maxAttempts int, maxAge time.Duration, verbose *logging.Flag) (*Journal, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create journal directory: %w", err)
+		}
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize journal: %w", err)
+	}
+
+	return &Journal{
+		db:          db,
+		interval:    interval,
+		timeout:     timeout,
+		maxAttempts: maxAttempts,
+		maxAge:      maxAge,
+		verbose:     verbose,
+		handlers:    make(map[string]Handler),
+	}, nil
+}
+
+// Handle registers the handler for entries of the given kind
+func (j *Journal) Handle(kind string, handler Handler) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.handlers[kind] = handler
+}
+
+// SetDeferred sets a function deciding whether a due entry is left alone because another
+// instance (the cluster leader) takes care of it; deferred entries stay pending and still expire
+func (j *Journal) SetDeferred(deferred func(entry Entry) bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.deferred = deferred
+}
+
+// Add records a new entry. Safe to call on a nil journal (no-op)
+func (j *Journal) Add(entry Entry) error {
+	if j == nil {
+		return nil
+	}
+
+	now := time.Now()
+	entry.CreatedAt = now
+	entry.NextAttempt = now.Add(j.interval)
+	if entry.ExpiresAt.IsZero() || entry.ExpiresAt.Sub(now) > j.maxAge {
+		entry.ExpiresAt = now.Add(j.maxAge)
+	}
+
+	err := j.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = id
+		return putEntry(b, entry)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add journal entry: %w", err)
+	}
+
+	j.verbose.Debugf(context.Background(), "journal: recorded %s of %s on %s (id=%d, expires %v)", entry.Kind, entry.Hash, entry.ServerURL, entry.ID, entry.ExpiresAt)
+	return nil
+}
+
+// Import records an entry handed over by another instance, keeping its attempts, next
+// attempt and expiration. An entry for the same work (kind, hash and server) already
+// pending is kept instead. Returns false if the entry was a duplicate
+func (j *Journal) Import(entry Entry) (bool, error) {
+	duplicate := false
+	err := j.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		if err := b.ForEach(func(_, v []byte) error {
+			var pending Entry
+			if json.Unmarshal(v, &pending) == nil && pending.Kind == entry.Kind && pending.Hash == entry.Hash && pending.ServerURL == entry.ServerURL {
+				duplicate = true
+			}
+			return nil
+		}); err != nil || duplicate {
+			return err
+		}
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = id
+		return putEntry(b, entry)
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to import journal entry: %w", err)
+	}
+
+	if j.verbose.Enabled() {
+		if duplicate {
+			j.verbose.Debugf(context.Background(), "journal: handed over %s of %s on %s is already pending", entry.Kind, entry.Hash, entry.ServerURL)
+		} else {
+			j.verbose.Debugf(context.Background(), "journal: took over %s of %s on %s (id=%d, %d attempts so far)", entry.Kind, entry.Hash, entry.ServerURL, entry.ID, entry.Attempts)
+		}
+	}
+	return !duplicate, nil
+}
+
+// Remove deletes an entry, e.g. once another instance took it over
+func (j *Journal) Remove(id uint64) {
+	j.remove(id)
+}
+
+// Pending returns all entries still in the journal. Safe to call on a nil journal
+func (j *Journal) Pending() ([]Entry, error) {
+	if j == nil {
+		return nil, nil
+	}
+
+	var entries []Entry
+	err := j.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).ForEach(func(_, v []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				// Skip corrupt entries rather than blocking the whole queue
+				logging.Warnf(context.Background(), "journal: skipping unreadable entry: %v", err)
+				return nil
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// CountPending returns the number of pending entries of a kind for a hash. Safe to call on a nil journal
+func (j *Journal) CountPending(kind string, hash string) int {
+	entries, err := j.Pending()
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, entry := range entries {
+		if entry.Kind == kind && entry.Hash == hash {
+			n++
+		}
+	}
+	return n
+}
+
+// Len returns the number of pending entries. Safe to call on a nil journal
+func (j *Journal) Len() int {
+	if j == nil {
+		return 0
+	}
+	n := 0
+	j.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(bucketName).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// Start processes due entries every interval until ctx is cancelled
+// Entries left over from a previous run are picked up on the first pass
+func (j *Journal) Start(ctx context.Context) {
+	if pending := j.Len(); pending > 0 {
+		log.Printf("Journal: resuming %d pending operations", pending)
+	}
+
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		j.processDue(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				j.processDue(ctx)
+			}
+		}
+	}()
+}
+
+// Close closes the journal database
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.db.Close()
+}
+
+// processDue runs every entry whose next attempt is due
+// A panic ends the pass; remaining entries are picked up on the next tick
+func (j *Journal) processDue(ctx context.Context) {
+	defer recovery.Recover("journal")
+	entries, err := j.Pending()
+	if err != nil {
+		logging.Warnf(ctx, "journal: failed to read pending entries: %v", err)
+		return
+	}
+
+	j.mu.RLock()
+	deferred := j.deferred
+	j.mu.RUnlock()
+
+	now := time.Now()
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if now.After(entry.ExpiresAt) {
+			logging.Warnf(ctx, "journal: dropping expired %s of %s on %s after %d attempts (last error: %s)",
+				entry.Kind, entry.Hash, entry.ServerURL, entry.Attempts, entry.LastError)
+			j.remove(entry.ID)
+			continue
+		}
+		if now.Before(entry.NextAttempt) {
+			continue
+		}
+		if deferred != nil && deferred(entry) {
+			continue
+		}
+		j.run(ctx, entry)
+	}
+}
+
+// run attempts a single entry and records the outcome
+func (j *Journal) run(ctx context.Context, entry Entry) {
+	j.mu.RLock()
+	handler := j.handlers[entry.Kind]
+	j.mu.RUnlock()
+	if handler == nil {
+		j.verbose.Debugf(ctx, "journal: no handler for %s entries, leaving id=%d pending", entry.Kind, entry.ID)
+		return
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, j.timeout)
+	err := handler(attemptCtx, entry)
+	cancel()
+
+	if err == nil {
+		j.verbose.Debugf(ctx, "journal: completed %s of %s on %s after %d attempts", entry.Kind, entry.Hash, entry.ServerURL, entry.Attempts+1)
+		j.remove(entry.ID)
+		return
+	}
+	if ctx.Err() != nil {
+		// Shutting down: the attempt doesn't count
+		return
+	}
+
+	entry.Attempts++
+	entry.LastError = err.Error()
+	if entry.Attempts >= j.maxAttempts {
+		logging.Warnf(ctx, "journal: giving up on %s of %s on %s after %d attempts: %v",
+			entry.Kind, entry.Hash, entry.ServerURL, entry.Attempts, err)
+		j.remove(entry.ID)
+		return
+	}
+
+	backoff := j.interval << (entry.Attempts - 1)
+	if backoff <= 0 || backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	entry.NextAttempt = time.Now().Add(backoff)
+	j.verbose.Debugf(ctx, "journal: %s of %s on %s failed (attempt %d/%d), retrying in %v: %v",
+		entry.Kind, entry.Hash, entry.ServerURL, entry.Attempts, j.maxAttempts, backoff, err)
+
+	if err := j.db.Update(func(tx *bolt.Tx) error {
+		return putEntry(tx.Bucket(bucketName), entry)
+	}); err != nil {
+		logging.Warnf(ctx, "journal: failed to update entry %d: %v", entry.ID, err)
+	}
+}
+
+// remove deletes an entry from the journal
+func (j *Journal) remove(id uint64) {
+	if err := j.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Delete(idKey(id))
+	}); err != nil {
+		logging.Warnf(context.Background(), "journal: failed to remove entry %d: %v", id, err)
+	}
+}
+
+// putEntry stores an entry under its ID
+func putEntry(b *bolt.Bucket, entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return b.Put(idKey(entry.ID), data)
+}
+
+// idKey encodes an entry ID as a big-endian key so entries iterate in insertion order
+func idKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
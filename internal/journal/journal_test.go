@@ -0,0 +1,131 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// openTestJournal opens a journal at path retrying every millisecond, up to maxAttempts times
+func openTestJournal(t *testing.T, path string, maxAttempts int) *Journal {
+	t.Helper()
+	j, err := Open(path, time.Millisecond, time.Second, maxAttempts, time.Hour, logging.NewLevels(nil).Flag(logging.Handler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+// pending returns the entries left in j
+func pending(t *testing.T, j *Journal) []Entry {
+	t.Helper()
+	entries, err := j.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// processWhenDue waits until the entries' next attempts are due, then processes them
+func processWhenDue(j *Journal) {
+	time.Sleep(5 * time.Millisecond)
+	j.processDue(context.Background())
+}
+
+func TestJournalSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.db")
+	j := openTestJournal(t, path, 3)
+	if err := j.Add(Entry{Kind: KindMirror, Hash: "abc", ServerURL: "https://a.example", Body: []byte(`{"url":"x"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	entries := pending(t, openTestJournal(t, path, 3))
+	if len(entries) != 1 || entries[0].Kind != KindMirror || entries[0].Hash != "abc" || string(entries[0].Body) != `{"url":"x"}` {
+		t.Errorf("entries after reopening = %+v, want the mirror of abc", entries)
+	}
+}
+
+func TestJournalRetriesUntilSuccess(t *testing.T) {
+	j := openTestJournal(t, filepath.Join(t.TempDir(), "journal.db"), 3)
+	fail := true
+	calls := 0
+	j.Handle(KindDelete, func(ctx context.Context, entry Entry) error {
+		calls++
+		if fail {
+			return errors.New("server down")
+		}
+		return nil
+	})
+	j.Add(Entry{Kind: KindDelete, Hash: "abc", ServerURL: "https://a.example"})
+	scheduled := pending(t, j)[0].NextAttempt
+
+	processWhenDue(j)
+	entries := pending(t, j)
+	if len(entries) != 1 || entries[0].Attempts != 1 || entries[0].LastError != "server down" {
+		t.Fatalf("entries after a failed attempt = %+v, want one with 1 attempt", entries)
+	}
+	if !entries[0].NextAttempt.After(scheduled) {
+		t.Errorf("next attempt = %v, want it rescheduled after %v", entries[0].NextAttempt, scheduled)
+	}
+
+	fail = false
+	processWhenDue(j)
+	if entries := pending(t, j); len(entries) != 0 {
+		t.Errorf("entries after a successful attempt = %+v, want none", entries)
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+}
+
+func TestJournalGivesUp(t *testing.T) {
+	j := openTestJournal(t, filepath.Join(t.TempDir(), "journal.db"), 2)
+	j.Handle(KindDelete, func(ctx context.Context, entry Entry) error {
+		return errors.New("server down")
+	})
+	j.Add(Entry{Kind: KindDelete, Hash: "abc", ServerURL: "https://a.example"})
+	j.Add(Entry{Kind: KindDelete, Hash: "def", ServerURL: "https://a.example", ExpiresAt: time.Now().Add(time.Millisecond)})
+
+	processWhenDue(j) // abc fails once, def expired
+	if entries := pending(t, j); len(entries) != 1 || entries[0].Hash != "abc" {
+		t.Fatalf("entries = %+v, want only abc left", entries)
+	}
+	time.Sleep(5 * time.Millisecond) // Backoff of the first retry
+	processWhenDue(j)
+	if entries := pending(t, j); len(entries) != 0 {
+		t.Errorf("entries after max attempts = %+v, want none", entries)
+	}
+}
+
+func TestJournalDefersAndImports(t *testing.T) {
+	j := openTestJournal(t, filepath.Join(t.TempDir(), "journal.db"), 3)
+	calls := 0
+	j.Handle(KindMirror, func(ctx context.Context, entry Entry) error {
+		calls++
+		return nil
+	})
+	j.SetDeferred(func(entry Entry) bool { return true })
+
+	entry := Entry{Kind: KindMirror, Hash: "abc", ServerURL: "https://a.example", Attempts: 2, ExpiresAt: time.Now().Add(time.Hour)}
+	if imported, err := j.Import(entry); err != nil || !imported {
+		t.Fatalf("Import = %t, %v, want the entry imported", imported, err)
+	}
+	if imported, _ := j.Import(entry); imported {
+		t.Error("duplicate entry imported")
+	}
+
+	processWhenDue(j)
+	entries := pending(t, j)
+	if calls != 0 || len(entries) != 1 {
+		t.Fatalf("deferred entry ran (%d calls, %d entries left)", calls, len(entries))
+	}
+	if entries[0].Attempts != 2 {
+		t.Errorf("attempts = %d, want the 2 made by the other instance kept", entries[0].Attempts)
+	}
+}
@@ -0,0 +1,170 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Components whose debug logging is switched separately
+const (
+	Handler  = "handler"  // Client requests, upload spool, pending-operation journal
+	Upstream = "upstream" // Fan-out to the upstream servers, health checks, integrity checks, discovery
+	Client   = "client"   // HTTP requests to each upstream server (every HEAD check, upload, mirror...)
+	Cache    = "cache"    // Location cache, blob cache, cluster sync
+	Auth     = "auth"     // Nostr authorization events
+)
+
+// Components lists every component, in the order they are reported
+var Components = []string{Handler, Upstream, Client, Cache, Auth}
+
+// Flag switches debug logging of one component on or off while the proxy is running.
+// Components share the flag they were created with and check it before every debug line,
+// so a change applies immediately
+// All methods are safe to call on a nil *Flag (debug logging always off)
+type Flag struct {
+	on        atomic.Bool
+	component string
+}
+
+// newComponentFlag creates the flag of a component in the given state
+func newComponentFlag(component string, on bool) *Flag {
+	f := &Flag{component: component}
+	f.on.Store(on)
+	return f
+}
+
+// Enabled reports whether debug logging is on
+func (f *Flag) Enabled() bool {
+	return f != nil && f.on.Load()
+}
+
+// Set switches debug logging on or off
+func (f *Flag) Set(on bool) {
+	if f != nil {
+		f.on.Store(on)
+	}
+}
+
+// Debugf logs a debug line of the flag's component if debug logging is on, with the
+// request ID carried by ctx so the lines of one request's goroutines can be correlated
+func (f *Flag) Debugf(ctx context.Context, format string, args ...any) {
+	if f.Enabled() {
+		Debugf(ctx, f.component, format, args...)
+	}
+}
+
+// Levels holds the debug logging flag of every component (-v, -debug, SIGUSR1 or
+// /admin/log-level)
+type Levels struct {
+	flags map[string]*Flag
+
+	mu      sync.Mutex
+	toggled []string // Components switched off by the last Toggle, turned back on by the next
+}
+
+// NewLevels creates the flags of every component, on for the given ones
+func NewLevels(enabled []string) *Levels {
+	l := &Levels{flags: make(map[string]*Flag, len(Components))}
+	for _, component := range Components {
+		l.flags[component] = newComponentFlag(component, false)
+	}
+	for _, component := range enabled {
+		l.flags[component].Set(true)
+	}
+	return l
+}
+
+// ParseComponents parses a comma-separated list of components ("all" for every one)
+func ParseComponents(list string) ([]string, error) {
+	var components []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case name == "":
+		case name == "all":
+			return Components, nil
+		case isComponent(name):
+			components = append(components, name)
+		default:
+			return nil, fmt.Errorf("unknown component %q (expected %s or all)", name, strings.Join(Components, ", "))
+		}
+	}
+	return components, nil
+}
+
+// isComponent reports whether name is one of Components
+func isComponent(name string) bool {
+	for _, component := range Components {
+		if component == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Flag returns the flag of a component, shared by everything logging for it
+func (l *Levels) Flag(component string) *Flag {
+	return l.flags[component]
+}
+
+// Set switches debug logging of one component on or off
+func (l *Levels) Set(component string, on bool) error {
+	flag, exists := l.flags[component]
+	if !exists {
+		return fmt.Errorf("unknown component %q (expected %s)", component, strings.Join(Components, ", "))
+	}
+	flag.Set(on)
+	return nil
+}
+
+// SetAll switches debug logging of every component on or off
+func (l *Levels) SetAll(on bool) {
+	for _, flag := range l.flags {
+		flag.Set(on)
+	}
+}
+
+// Enabled returns the components with debug logging on
+func (l *Levels) Enabled() []string {
+	var enabled []string
+	for _, component := range Components {
+		if l.flags[component].Enabled() {
+			enabled = append(enabled, component)
+		}
+	}
+	return enabled
+}
+
+// State returns whether debug logging is on, by component
+func (l *Levels) State() map[string]bool {
+	state := make(map[string]bool, len(l.flags))
+	for component, flag := range l.flags {
+		state[component] = flag.Enabled()
+	}
+	return state
+}
+
+// Toggle switches debug logging off if any component has it on, remembering which ones
+// did; otherwise it turns those back on (every component the first time)
+// Returns the components left on
+func (l *Levels) Toggle() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if enabled := l.Enabled(); len(enabled) > 0 {
+		l.toggled = enabled
+		l.SetAll(false)
+		return nil
+	}
+	restore := l.toggled
+	if len(restore) == 0 {
+		restore = Components
+	}
+	for _, component := range restore {
+		l.flags[component].Set(true)
+	}
+	return restore
+}
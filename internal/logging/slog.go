@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Output formats of Setup
+const (
+	FormatText = "text" // key=value lines
+	FormatJSON = "json" // One JSON object per line
+)
+
+// Setup sends every log line through log/slog, written to w as text or JSON, and drops
+// lines below level (debug lines are controlled per component by their Flag instead)
+// Lines still written with the standard log package are logged at the level of their
+// [WARN] or [ERROR] prefix, or INFO
+func Setup(w io.Writer, format string, level slog.Level) error {
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var base slog.Handler
+	switch format {
+	case FormatText, "":
+		base = slog.NewTextHandler(w, options)
+	case FormatJSON:
+		base = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("unknown log format %q (expected %s or %s)", format, FormatText, FormatJSON)
+	}
+	logger := slog.New(&contextHandler{Handler: base, min: level})
+	slog.SetDefault(logger)
+
+	// slog.SetDefault points the log package at the handler, at INFO; replace that with
+	// a writer that keeps the level of the [WARN]/[ERROR] lines
+	log.SetFlags(0)
+	log.SetOutput(&stdlogWriter{logger: logger})
+	return nil
+}
+
+// ParseLevel parses a -log-level value (debug, info, warn or error)
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", name)
+	}
+	return level, nil
+}
+
+// Debugf logs a debug line of a component, with the request ID carried by ctx
+// Callers check the component's flag first; Flag.Debugf does both
+func Debugf(ctx context.Context, component string, format string, args ...any) {
+	slog.Default().DebugContext(ctx, fmt.Sprintf(format, args...), slog.String("component", component))
+}
+
+// Warnf logs a line at WARN level, with the request ID carried by ctx
+// Request handlers use it instead of log.Printf("[WARN] ..."), which has no context
+func Warnf(ctx context.Context, format string, args ...any) {
+	slog.Default().WarnContext(ctx, fmt.Sprintf(format, args...))
+}
+
+// Errorf logs a line at ERROR level, with the request ID carried by ctx
+func Errorf(ctx context.Context, format string, args ...any) {
+	slog.Default().ErrorContext(ctx, fmt.Sprintf(format, args...))
+}
+
+// Fatalf logs a line at ERROR level and exits, like log.Fatalf
+func Fatalf(format string, args ...any) {
+	slog.Default().Log(context.Background(), slog.LevelError, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// contextHandler adds the request ID carried by the context to every record and drops
+// records below min, except debug lines (already filtered by their component's flag)
+type contextHandler struct {
+	slog.Handler
+	min slog.Level
+}
+
+func (h *contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return (level == slog.LevelDebug || level >= h.min) && h.Handler.Enabled(ctx, level)
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs), min: h.min}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name), min: h.min}
+}
+
+// stdlogWriter turns the lines of the standard log package into slog records
+type stdlogWriter struct {
+	logger *slog.Logger
+}
+
+// stdlogPrefixes maps the level prefixes used with log.Printf to slog levels
+var stdlogPrefixes = []struct {
+	prefix string
+	level  slog.Level
+}{
+	{"[DEBUG] ", slog.LevelDebug},
+	{"[WARN] ", slog.LevelWarn},
+	{"[ERROR] ", slog.LevelError},
+}
+
+func (w *stdlogWriter) Write(p []byte) (int, error) {
+	msg := string(bytes.TrimSuffix(p, []byte("\n")))
+	level := slog.LevelInfo
+	for _, prefix := range stdlogPrefixes {
+		if strings.HasPrefix(msg, prefix.prefix) {
+			msg, level = msg[len(prefix.prefix):], prefix.level
+			break
+		}
+	}
+	w.logger.Log(context.Background(), level, msg)
+	return len(p), nil
+}
@@ -0,0 +1,165 @@
+package popularity
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Tracker counts the download requests of every blob, as a score that halves every
+// halfLife without requests, so recently hot blobs rank above blobs that were popular
+// long ago. At most maxHashes hashes are tracked, the least popular being forgotten first
+// A nil *Tracker (popularity tracking disabled) ignores everything
+type Tracker struct {
+	mu        sync.Mutex
+	halfLife  time.Duration
+	maxHashes int
+	hashes    map[string]*counter
+}
+
+// counter is the popularity of one blob
+type counter struct {
+	score         float64   // Decayed request count as of updated
+	updated       time.Time // When score was last decayed
+	requests      int64     // Requests since the blob is tracked
+	lastRequested time.Time
+}
+
+// Entry is a blob ranked by popularity
+type Entry struct {
+	Hash          string    `json:"hash"`
+	Score         float64   `json:"score"`    // Decayed request count
+	Requests      int64     `json:"requests"` // Requests since the blob is tracked
+	LastRequested time.Time `json:"last_requested"`
+}
+
+// New creates a tracker whose scores halve every halfLife, tracking at most maxHashes
+// hashes, or returns nil if either is not positive
+func New(halfLife time.Duration, maxHashes int) *Tracker {
+	if maxHashes <= 0 || halfLife <= 0 {
+		return nil
+	}
+	return &Tracker{
+		halfLife:  halfLife,
+		maxHashes: maxHashes,
+		hashes:    make(map[string]*counter),
+	}
+}
+
+// decay returns the score of c at now
+func (t *Tracker) decay(c *counter, now time.Time) float64 {
+	elapsed := now.Sub(c.updated)
+	if elapsed <= 0 {
+		return c.score
+	}
+	return c.score * math.Exp2(-float64(elapsed)/float64(t.halfLife))
+}
+
+// Record counts a download request of hash
+func (t *Tracker) Record(hash string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, exists := t.hashes[hash]
+	if !exists {
+		if len(t.hashes) >= t.maxHashes {
+			t.pruneLocked(now)
+		}
+		c = &counter{}
+		t.hashes[hash] = c
+	}
+	c.score = t.decay(c, now) + 1
+	c.updated = now
+	c.requests++
+	c.lastRequested = now
+}
+
+// pruneLocked forgets the least popular tenth of the tracked hashes, so pruning doesn't
+// happen on every new hash once the tracker is full (must be called with lock held)
+func (t *Tracker) pruneLocked(now time.Time) {
+	type scored struct {
+		hash  string
+		score float64
+	}
+	all := make([]scored, 0, len(t.hashes))
+	for hash, c := range t.hashes {
+		all = append(all, scored{hash: hash, score: t.decay(c, now)})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].score < all[j].score })
+
+	drop := len(all) - t.maxHashes + 1
+	if minDrop := t.maxHashes / 10; drop < minDrop {
+		drop = minDrop
+	}
+	for _, s := range all[:min(drop, len(all))] {
+		delete(t.hashes, s.hash)
+	}
+}
+
+// Score returns the current popularity score of hash (0 if not tracked)
+func (t *Tracker) Score(hash string) float64 {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, exists := t.hashes[hash]
+	if !exists {
+		return 0
+	}
+	return t.decay(c, time.Now())
+}
+
+// Top returns the n most popular blobs, most popular first
+func (t *Tracker) Top(n int) []Entry {
+	if t == nil || n <= 0 {
+		return nil
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	entries := make([]Entry, 0, len(t.hashes))
+	for hash, c := range t.hashes {
+		entries = append(entries, Entry{
+			Hash:          hash,
+			Score:         t.decay(c, now),
+			Requests:      c.requests,
+			LastRequested: c.lastRequested,
+		})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		return entries[i].Hash < entries[j].Hash
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// Len returns the number of tracked hashes
+func (t *Tracker) Len() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.hashes)
+}
+
+// HalfLife returns the time it takes a score to halve without requests
+func (t *Tracker) HalfLife() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return t.halfLife
+}
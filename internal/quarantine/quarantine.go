@@ -0,0 +1,125 @@
+package quarantine
+
+import (
+	"sync"
+	"time"
+)
+
+// Entry describes a quarantined replica
+type Entry struct {
+	Hash          string    `json:"hash"`
+	ServerURL     string    `json:"server_url"`
+	Reason        string    `json:"reason"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// Quarantine remembers (server, hash) pairs whose replica failed verification
+// Quarantined replicas are never offered to clients until they are re-verified
+type Quarantine struct {
+	mu      sync.RWMutex
+	entries map[string]map[string]*Entry // hash -> server URL -> entry
+}
+
+// New creates an empty quarantine
+func New() *Quarantine {
+	return &Quarantine{
+		entries: make(map[string]map[string]*Entry),
+	}
+}
+
+// extractHash extracts the hash (first 64 characters) from a path
+func extractHash(path string) string {
+	if len(path) >= 64 {
+		return path[:64]
+	}
+	return path
+}
+
+// Add quarantines the replica of a blob on a server
+func (q *Quarantine) Add(path string, serverURL string, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	hash := extractHash(path)
+	servers, exists := q.entries[hash]
+	if !exists {
+		servers = make(map[string]*Entry)
+		q.entries[hash] = servers
+	}
+	servers[serverURL] = &Entry{
+		Hash:          hash,
+		ServerURL:     serverURL,
+		Reason:        reason,
+		QuarantinedAt: time.Now(),
+	}
+}
+
+// Release removes a replica from quarantine (e.g., after it has been re-verified)
+func (q *Quarantine) Release(path string, serverURL string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	hash := extractHash(path)
+	servers, exists := q.entries[hash]
+	if !exists {
+		return
+	}
+	delete(servers, serverURL)
+	if len(servers) == 0 {
+		delete(q.entries, hash)
+	}
+}
+
+// IsQuarantined reports whether the replica of a blob on a server is quarantined
+func (q *Quarantine) IsQuarantined(path string, serverURL string) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	_, exists := q.entries[extractHash(path)][serverURL]
+	return exists
+}
+
+// Filter returns the given servers minus those whose replica of the blob is quarantined
+func (q *Quarantine) Filter(path string, servers []string) []string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	quarantined := q.entries[extractHash(path)]
+	if len(quarantined) == 0 {
+		return servers
+	}
+
+	filtered := make([]string, 0, len(servers))
+	for _, serverURL := range servers {
+		if _, exists := quarantined[serverURL]; !exists {
+			filtered = append(filtered, serverURL)
+		}
+	}
+	return filtered
+}
+
+// List returns a copy of all quarantined replicas
+func (q *Quarantine) List() []Entry {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	result := make([]Entry, 0)
+	for _, servers := range q.entries {
+		for _, entry := range servers {
+			result = append(result, *entry)
+		}
+	}
+	return result
+}
+
+// Count returns the number of quarantined replicas
+func (q *Quarantine) Count() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	count := 0
+	for _, servers := range q.entries {
+		count += len(servers)
+	}
+	return count
+}
@@ -0,0 +1,172 @@
+package quota
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limits are the uploads allowed to a pubkey per window (0 = unlimited)
+type Limits struct {
+	MaxBytes int64
+	MaxBlobs int
+}
+
+// unlimited reports whether the limits don't restrict anything
+func (l Limits) unlimited() bool {
+	return l.MaxBytes <= 0 && l.MaxBlobs <= 0
+}
+
+// usage is what a pubkey uploaded in its current window
+type usage struct {
+	start time.Time
+	bytes int64
+	blobs int
+}
+
+// ExceededError is returned by Check when an upload would exceed the pubkey's quota
+type ExceededError struct {
+	StatusCode int           // 413 if the blob doesn't fit in the bytes left, 429 if the quota is used up
+	Reason     string        // Suitable for the X-Reason header
+	RetryAfter time.Duration // Until the window resets
+}
+
+func (e *ExceededError) Error() string {
+	return e.Reason
+}
+
+// Tracker counts the bytes and blobs uploaded by each pubkey over a fixed window starting
+// with the pubkey's first upload, and rejects uploads past its limits
+// All methods are safe to call on a nil *Tracker (quotas disabled)
+type Tracker struct {
+	mu        sync.Mutex
+	global    Limits
+	perPubkey map[string]Limits // Hex pubkey -> limits replacing the global ones
+	window    time.Duration
+	usage     map[string]*usage // Hex pubkey -> current window
+	lastPrune time.Time
+}
+
+// New creates a tracker, or returns nil if no limit is set
+// perPubkey is keyed by hex pubkey
+func New(global Limits, perPubkey map[string]Limits, window time.Duration) *Tracker {
+	if global.unlimited() && len(perPubkey) == 0 {
+		return nil
+	}
+	return &Tracker{
+		global:    global,
+		perPubkey: perPubkey,
+		window:    window,
+		usage:     make(map[string]*usage),
+		lastPrune: time.Now(),
+	}
+}
+
+// limitsFor returns the limits of a pubkey
+func (t *Tracker) limitsFor(pubkey string) Limits {
+	if limits, ok := t.perPubkey[pubkey]; ok {
+		return limits
+	}
+	return t.global
+}
+
+// currentLocked returns the usage of a pubkey in its current window, or nil if it has
+// none (must be called with lock held)
+func (t *Tracker) currentLocked(pubkey string, now time.Time) *usage {
+	u, exists := t.usage[pubkey]
+	if !exists {
+		return nil
+	}
+	if now.Sub(u.start) >= t.window {
+		delete(t.usage, pubkey)
+		return nil
+	}
+	return u
+}
+
+// Check returns an *ExceededError if the pubkey may not upload a blob of the given size
+// (-1 if unknown: only the quota left is checked). Nothing is counted until Record
+func (t *Tracker) Check(pubkey string, size int64) error {
+	if t == nil {
+		return nil
+	}
+	limits := t.limitsFor(pubkey)
+	if limits.unlimited() {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	u := t.currentLocked(pubkey, now)
+	if u == nil {
+		u = &usage{start: now}
+	}
+	retryAfter := u.start.Add(t.window).Sub(now)
+
+	switch {
+	case limits.MaxBlobs > 0 && u.blobs >= limits.MaxBlobs:
+		return &ExceededError{
+			StatusCode: http.StatusTooManyRequests,
+			Reason:     fmt.Sprintf("upload quota exceeded: %d blobs per %v", limits.MaxBlobs, t.window),
+			RetryAfter: retryAfter,
+		}
+	case limits.MaxBytes > 0 && u.bytes >= limits.MaxBytes:
+		return &ExceededError{
+			StatusCode: http.StatusTooManyRequests,
+			Reason:     fmt.Sprintf("upload quota exceeded: %d bytes per %v", limits.MaxBytes, t.window),
+			RetryAfter: retryAfter,
+		}
+	case limits.MaxBytes > 0 && size > limits.MaxBytes-u.bytes:
+		return &ExceededError{
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Reason:     fmt.Sprintf("upload quota exceeded: blob of %d bytes, %d bytes left of %d per %v", size, limits.MaxBytes-u.bytes, limits.MaxBytes, t.window),
+			RetryAfter: retryAfter,
+		}
+	}
+	return nil
+}
+
+// Record counts a completed upload against the pubkey's quota
+func (t *Tracker) Record(pubkey string, size int64) {
+	if t == nil || t.limitsFor(pubkey).unlimited() {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	u := t.currentLocked(pubkey, now)
+	if u == nil {
+		u = &usage{start: now}
+		t.usage[pubkey] = u
+	}
+	u.bytes += size
+	u.blobs++
+
+	// Forget the pubkeys whose window ended, once per window
+	if now.Sub(t.lastPrune) >= t.window {
+		for key := range t.usage {
+			t.currentLocked(key, now)
+		}
+		t.lastPrune = now
+	}
+}
+
+// Tracked returns the number of pubkeys with uploads in their current window
+func (t *Tracker) Tracked() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	count := 0
+	for _, u := range t.usage {
+		if now.Sub(u.start) < t.window {
+			count++
+		}
+	}
+	return count
+}
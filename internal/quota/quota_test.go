@@ -0,0 +1,91 @@
+package quota
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// exceeded returns the status code of a Check error, or 0 if the upload is allowed
+func exceeded(t *testing.T, err error) int {
+	t.Helper()
+	if err == nil {
+		return 0
+	}
+	var exceededErr *ExceededError
+	if !errors.As(err, &exceededErr) {
+		t.Fatalf("Check error = %v, want an *ExceededError", err)
+	}
+	if exceededErr.Reason == "" || exceededErr.RetryAfter <= 0 {
+		t.Errorf("ExceededError = %+v, want a reason and a retry delay", exceededErr)
+	}
+	return exceededErr.StatusCode
+}
+
+func TestTrackerLimits(t *testing.T) {
+	tracker := New(Limits{MaxBytes: 100, MaxBlobs: 3}, map[string]Limits{"vip": {}}, time.Hour)
+
+	if code := exceeded(t, tracker.Check("alice", 101)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("blob larger than the quota: status %d, want 413", code)
+	}
+	tracker.Record("alice", 60)
+	if code := exceeded(t, tracker.Check("alice", 41)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("blob larger than the bytes left: status %d, want 413", code)
+	}
+	if code := exceeded(t, tracker.Check("alice", -1)); code != 0 {
+		t.Errorf("blob of unknown size with bytes left: status %d, want allowed", code)
+	}
+	tracker.Record("alice", 40)
+	if code := exceeded(t, tracker.Check("alice", 1)); code != http.StatusTooManyRequests {
+		t.Errorf("bytes used up: status %d, want 429", code)
+	}
+
+	// Pubkeys are counted separately
+	for i := 0; i < 3; i++ {
+		if code := exceeded(t, tracker.Check("bob", 1)); code != 0 {
+			t.Fatalf("upload %d of bob: status %d, want allowed", i+1, code)
+		}
+		tracker.Record("bob", 1)
+	}
+	if code := exceeded(t, tracker.Check("bob", 1)); code != http.StatusTooManyRequests {
+		t.Errorf("blobs used up: status %d, want 429", code)
+	}
+
+	// Per-pubkey limits replace the global ones
+	for i := 0; i < 5; i++ {
+		tracker.Record("vip", 1000)
+	}
+	if err := tracker.Check("vip", 1000); err != nil {
+		t.Errorf("unlimited pubkey rejected: %v", err)
+	}
+	if tracked := tracker.Tracked(); tracked != 2 {
+		t.Errorf("tracked pubkeys = %d, want alice and bob", tracked)
+	}
+}
+
+func TestTrackerWindowResets(t *testing.T) {
+	tracker := New(Limits{MaxBlobs: 1}, nil, 50*time.Millisecond)
+	tracker.Record("alice", 1)
+	if tracker.Check("alice", 1) == nil {
+		t.Fatal("second blob allowed within the window")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := tracker.Check("alice", 1); err != nil {
+		t.Errorf("upload rejected after the window ended: %v", err)
+	}
+	if tracked := tracker.Tracked(); tracked != 0 {
+		t.Errorf("tracked pubkeys = %d after the window ended, want 0", tracked)
+	}
+}
+
+func TestDisabledTracker(t *testing.T) {
+	tracker := New(Limits{}, nil, time.Hour)
+	if tracker != nil {
+		t.Fatal("New returned a tracker without limits")
+	}
+	tracker.Record("alice", 1<<40)
+	if err := tracker.Check("alice", 1<<40); err != nil || tracker.Tracked() != 0 {
+		t.Errorf("nil tracker: Check = %v, Tracked = %d, want no limits", err, tracker.Tracked())
+	}
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often buckets back to full are forgotten
+const pruneInterval = time.Minute
+
+// Limit is a token bucket: requests are allowed at Rate per second on average, with up to
+// Burst of them at once
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited reports whether the limit doesn't restrict anything
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// bucket is the token bucket of one key
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// refill adds the tokens earned since the bucket was last updated
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed*b.limit.Rate, float64(b.limit.Burst))
+	}
+	b.updated = now
+}
+
+// Limiter keeps a token bucket per key (e.g. client address or pubkey)
+// Buckets are created full, and forgotten once they are full again
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+// New creates a limiter without buckets
+func New() *Limiter {
+	return &Limiter{
+		buckets:   make(map[string]*bucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of key, which refills according to limit
+// Returns false and how long until a token is available if the bucket is empty
+func (l *Limiter) Allow(key string, limit Limit) (bool, time.Duration) {
+	if limit.Unlimited() {
+		return true, 0
+	}
+	limit.Burst = max(limit.Burst, 1)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruneLocked(now)
+
+	b, exists := l.buckets[key]
+	if !exists || b.limit != limit {
+		// A changed limit starts over with a full bucket
+		b = &bucket{tokens: float64(limit.Burst), updated: now, limit: limit}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// pruneLocked forgets the buckets that refilled completely, at most once per
+// pruneInterval (must be called with lock held)
+func (l *Limiter) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPrune) < pruneInterval {
+		return
+	}
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of keys with a bucket
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	l := New()
+	limit := Limit{Rate: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("client", limit); !ok {
+			t.Fatalf("request %d rejected within the burst", i+1)
+		}
+	}
+	ok, wait := l.Allow("client", limit)
+	if ok {
+		t.Fatal("request past the burst allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("wait = %v, want up to one token's interval of 1s", wait)
+	}
+	if ok, _ := l.Allow("other client", limit); !ok {
+		t.Error("another key shares the bucket")
+	}
+
+	// A changed limit starts over with a full bucket
+	if ok, _ := l.Allow("client", Limit{Rate: 1, Burst: 3}); !ok {
+		t.Error("request rejected after the limit changed")
+	}
+	if l.Len() != 2 {
+		t.Errorf("Len = %d, want 2 tracked keys", l.Len())
+	}
+}
+
+func TestAllowRefills(t *testing.T) {
+	l := New()
+	limit := Limit{Rate: 50, Burst: 1}
+	if ok, _ := l.Allow("client", limit); !ok {
+		t.Fatal("first request rejected")
+	}
+	if ok, _ := l.Allow("client", limit); ok {
+		t.Fatal("second request allowed at once")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if ok, _ := l.Allow("client", limit); !ok {
+		t.Error("request rejected after the bucket refilled")
+	}
+}
+
+func TestAllowUnlimited(t *testing.T) {
+	l := New()
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow("client", Limit{}); !ok {
+			t.Fatal("unlimited request rejected")
+		}
+	}
+	if l.Len() != 0 {
+		t.Errorf("Len = %d, want no bucket for unlimited keys", l.Len())
+	}
+}
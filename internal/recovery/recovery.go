@@ -0,0 +1,84 @@
+// Package recovery keeps panics in handlers and background goroutines from taking down
+// the proxy: they are logged with a stack trace, counted, and turned into errors
+package recovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// panics counts every panic recovered since startup (reported as panics_total in /stats)
+var panics atomic.Int64
+
+// Total returns the number of panics recovered since startup
+func Total() int64 {
+	return panics.Load()
+}
+
+// Error records a recovered panic value, logging it with the current stack trace,
+// and returns it as an error so callers can report it like any other failure
+// Must be called from the deferred function that called recover()
+func Error(where string, v interface{}) error {
+	panics.Add(1)
+	logging.Warnf(context.Background(), "%s: recovered from panic: %v\n%s", where, v, debug.Stack())
+	return fmt.Errorf("%s: panic: %v", where, v)
+}
+
+// Recover logs and counts a panic in the calling goroutine instead of crashing the process
+// Use it directly as a deferred call: defer recovery.Recover("name")
+func Recover(where string) {
+	if v := recover(); v != nil {
+		Error(where, v)
+	}
+}
+
+// Middleware recovers panics in HTTP handlers, logging and counting them, and answers
+// 500 if nothing was written to the client yet
+// http.ErrAbortHandler is re-raised so net/http can abort the response as intended
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(v)
+			}
+			Error(fmt.Sprintf("%s %s", r.Method, r.URL.Path), v)
+			if !rw.wroteHeader {
+				http.Error(rw, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// responseWriter tracks whether the response has started, since a 500 can only be
+// sent before the status line has gone out
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (rw *responseWriter) WriteHeader(statusCode int) {
+	rw.wroteHeader = true
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,337 @@
+package repair
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/auth"
+	"github.com/girino/blossom_espelhator/internal/cache"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/quarantine"
+	"github.com/girino/blossom_espelhator/internal/recovery"
+	"github.com/girino/blossom_espelhator/internal/stats"
+	"github.com/girino/blossom_espelhator/internal/upstream"
+)
+
+// Counters summarizes the repair rounds for /stats
+type Counters struct {
+	Rounds          int64 `json:"rounds"`
+	Checked         int64 `json:"checked"`          // Under-replicated cache entries checked on every server
+	UnderReplicated int64 `json:"under_replicated"` // Blobs confirmed on fewer servers than their replication target
+	Repaired        int64 `json:"repaired"`         // Replicas added by mirroring
+	Failed          int64 `json:"failed"`           // Mirrors that failed
+	Unrepairable    int64 `json:"unrepairable"`     // Blobs with no server left to mirror them to
+	Prefetched      int64 `json:"prefetched"`       // Replicas added to trending blobs held by a single server
+}
+
+// Repairer periodically walks the location cache for blobs held by fewer than
+// replication_factor servers (capped at their shard's servers), checks them on
+// every server and mirrors them (BUD-04) from a server that has them to the servers missing them
+// Only the cache is walked: blobs nobody requested since they were evicted are not repaired
+type Repairer struct {
+	upstreamManager *upstream.Manager
+	cache           *cache.Cache
+	quarantine      *quarantine.Quarantine
+	stats           *stats.Stats
+	interval        time.Duration
+	batchSize       int
+	checkTimeout    time.Duration // Bounds the HEAD checks of a blob
+	mirrorTimeout   time.Duration // Bounds each mirror request
+	secretKey       string        // Hex secret key signing the mirror requests (empty: unsigned)
+	verbose         *logging.Flag
+	shouldRun       func() bool            // Whether this instance runs the rounds (optional, e.g. only the cluster leader)
+	blocked         func(hash string) bool // Reports hashes that are never mirrored (optional, e.g. the blocklist)
+
+	mu       sync.Mutex
+	cursor   string // Last hash checked, the next round starts after it
+	counters Counters
+}
+
+// New creates a new repairer
+// interval is the time between rounds and batchSize the number of under-replicated blobs
+// checked per round. secretKey (hex or nsec) signs the mirror requests if set
+func New(upstreamManager *upstream.Manager, c *cache.Cache, q *quarantine.Quarantine, statsTracker *stats.Stats, interval time.Duration, batchSize int, checkTimeout, mirrorTimeout time.Duration, secretKey string, verbose *logging.Flag) (*Repairer, error) {
+	if secretKey != "" {
+		normalized, err := auth.NormalizeSecretKey(secretKey)
+		if err != nil {
+			return nil, err
+		}
+		secretKey = normalized
+	}
+	return &Repairer{
+		upstreamManager: upstreamManager,
+		cache:           c,
+		quarantine:      q,
+		stats:           statsTracker,
+		interval:        interval,
+		batchSize:       batchSize,
+		checkTimeout:    checkTimeout,
+		mirrorTimeout:   mirrorTimeout,
+		secretKey:       secretKey,
+		verbose:         verbose,
+	}, nil
+}
+
+// SetGate sets a function deciding before each round whether this instance runs it, so
+// instances sharing state in a cluster don't all mirror the same blobs
+func (r *Repairer) SetGate(shouldRun func() bool) {
+	r.shouldRun = shouldRun
+}
+
+// SetBlocked sets a function reporting blocked hashes, which are neither repaired nor
+// spread to more servers
+func (r *Repairer) SetBlocked(blocked func(hash string) bool) {
+	r.blocked = blocked
+}
+
+// isBlocked reports whether hash must not be mirrored
+func (r *Repairer) isBlocked(hash string) bool {
+	return r.blocked != nil && r.blocked(hash)
+}
+
+// Start runs the repairer in the background until ctx is cancelled
+func (r *Repairer) Start(ctx context.Context) {
+	if r.interval <= 0 {
+		return
+	}
+
+	log.Printf("Replication repair started (interval=%v, batch_size=%d, signed=%t)", r.interval, r.batchSize, r.secretKey != "")
+
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.runRound(ctx)
+			}
+		}
+	}()
+}
+
+// Counters returns the totals since startup
+func (r *Repairer) Counters() Counters {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counters
+}
+
+// count updates the counters
+func (r *Repairer) count(update func(c *Counters)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	update(&r.counters)
+}
+
+// runRound runs one round; a panic skips the round instead of stopping the repairer
+func (r *Repairer) runRound(ctx context.Context) {
+	defer recovery.Recover("Replication repair")
+	if r.shouldRun != nil && !r.shouldRun() {
+		r.verbose.Debugf(ctx, "Repair: skipping round, another cluster instance runs the repairs")
+		return
+	}
+	r.count(func(c *Counters) { c.Rounds++ })
+
+	for _, hash := range r.candidates() {
+		if ctx.Err() != nil {
+			return
+		}
+		r.RepairHash(ctx, hash)
+	}
+}
+
+// candidates returns up to batchSize cached hashes held by fewer servers than required,
+// continuing from where the previous round stopped so every entry is eventually visited
+func (r *Repairer) candidates() []string {
+	entries := r.cache.Snapshot()
+	hashes := make([]string, 0, len(entries))
+	for hash := range entries {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	r.mu.Lock()
+	cursor := r.cursor
+	r.mu.Unlock()
+	start := sort.SearchStrings(hashes, cursor)
+	if start < len(hashes) && hashes[start] == cursor {
+		start++
+	}
+
+	picked := make([]string, 0, r.batchSize)
+	last := cursor
+	for i := 0; i < len(hashes) && len(picked) < r.batchSize; i++ {
+		hash := hashes[(start+i)%len(hashes)]
+		last = hash
+		// Recent uploads may not be visible everywhere yet, and blocked blobs aren't repaired
+		if len(r.upstreamManager.SettlingServers(hash)) > 0 || r.isBlocked(hash) {
+			continue
+		}
+		if len(entries[hash]) < r.upstreamManager.ReplicationTarget(hash) {
+			picked = append(picked, hash)
+		}
+	}
+
+	r.mu.Lock()
+	r.cursor = last
+	r.mu.Unlock()
+	return picked
+}
+
+// RepairHash checks which servers hold a blob and, if fewer than required, mirrors it to
+// enough of the others. Returns the number of replicas added
+func (r *Repairer) RepairHash(ctx context.Context, hash string) int {
+	if r.isBlocked(hash) {
+		r.verbose.Debugf(ctx, "Repair: %s is blocked, not repairing it", hash)
+		return 0
+	}
+	r.count(func(c *Counters) { c.Checked++ })
+
+	result := r.upstreamManager.CheckPathOnServers(ctx, hash, r.checkTimeout)
+	if ctx.Err() != nil {
+		return 0
+	}
+	holders := r.quarantine.Filter(hash, result.Servers)
+	if len(holders) == 0 {
+		// Not found anywhere (or only corrupt copies): nothing to mirror from
+		logging.Warnf(ctx, "Repair: %s not found on any server, cannot repair", hash)
+		r.count(func(c *Counters) { c.Unrepairable++ })
+		return 0
+	}
+	r.cache.Add(hash, holders)
+	r.cache.SetHeaders(hash, result.Headers)
+
+	required := r.upstreamManager.ReplicationTarget(hash)
+	if len(holders) >= required {
+		r.verbose.Debugf(ctx, "Repair: %s is on %d servers, no repair needed", hash, len(holders))
+		return 0
+	}
+	r.count(func(c *Counters) { c.UnderReplicated++ })
+
+	missing := r.mirrorTargets(hash, holders)
+	if len(missing) == 0 {
+		logging.Warnf(ctx, "Repair: %s is on %d of %d required servers and no other mirror-capable server is available", hash, len(holders), required)
+		r.count(func(c *Counters) { c.Unrepairable++ })
+		return 0
+	}
+
+	added := r.mirrorFrom(ctx, hash, holders, missing, required-len(holders))
+	r.count(func(c *Counters) { c.Repaired += int64(added) })
+
+	log.Printf("Repair: %s was on %d of %d required servers, mirrored to %d more", hash, len(holders), required, added)
+	return added
+}
+
+// SpreadHash mirrors a trending blob to more servers until it is on replicas servers, so
+// the downloads redirected to its only holder are spread before that server becomes a
+// bottleneck. Returns the number of replicas added
+func (r *Repairer) SpreadHash(ctx context.Context, hash string, replicas int) int {
+	if r.isBlocked(hash) {
+		r.verbose.Debugf(ctx, "Prefetch: %s is blocked, not spreading it", hash)
+		return 0
+	}
+	result := r.upstreamManager.CheckPathOnServers(ctx, hash, r.checkTimeout)
+	if ctx.Err() != nil {
+		return 0
+	}
+	holders := r.quarantine.Filter(hash, result.Servers)
+	if len(holders) == 0 {
+		return 0
+	}
+	r.cache.Add(hash, holders)
+	r.cache.SetHeaders(hash, result.Headers)
+	if len(holders) >= replicas {
+		r.verbose.Debugf(ctx, "Prefetch: %s is already on %d servers", hash, len(holders))
+		return 0
+	}
+
+	missing := r.mirrorTargets(hash, holders)
+	if len(missing) == 0 {
+		r.verbose.Debugf(ctx, "Prefetch: no other mirror-capable server available for %s", hash)
+		return 0
+	}
+	added := r.mirrorFrom(ctx, hash, holders, missing, replicas-len(holders))
+	r.count(func(c *Counters) { c.Prefetched += int64(added) })
+
+	log.Printf("Prefetch: %s is trending and was on %d servers, mirrored to %d more", hash, len(holders), added)
+	return added
+}
+
+// mirrorFrom mirrors a blob from its first holder to the missing servers in order, until
+// wanted replicas were added. Returns the number of replicas added
+func (r *Repairer) mirrorFrom(ctx context.Context, hash string, holders []string, missing []string, wanted int) int {
+	body, err := json.Marshal(map[string]string{"url": strings.TrimSuffix(holders[0], "/") + "/" + hash})
+	if err != nil {
+		return 0
+	}
+	headers := make(map[string]string)
+	if r.secretKey != "" {
+		authHeader, err := auth.SignAuthorization(r.secretKey, "upload", hash, r.mirrorTimeout+time.Minute)
+		if err != nil {
+			logging.Warnf(ctx, "Repair: %v", err)
+			return 0
+		}
+		headers["Authorization"] = authHeader
+	}
+
+	added := 0
+	for _, serverURL := range missing {
+		if added >= wanted || ctx.Err() != nil {
+			break
+		}
+		if err := r.mirror(ctx, serverURL, body, headers); err != nil {
+			logging.Warnf(ctx, "Repair: mirroring %s from %s to %s failed: %v", hash, holders[0], serverURL, err)
+			r.count(func(c *Counters) { c.Failed++ })
+			continue
+		}
+		r.stats.RecordSuccess(serverURL, "mirror")
+		r.cache.AddServer(hash, serverURL)
+		added++
+	}
+	return added
+}
+
+// mirrorTargets returns the servers a blob may be mirrored to: mirror-capable servers of
+// its shard that don't hold it, aren't in maintenance and have no quarantined copy
+func (r *Repairer) mirrorTargets(hash string, holders []string) []string {
+	held := make(map[string]bool, len(holders))
+	for _, serverURL := range holders {
+		held[serverURL] = true
+	}
+	mirrorCapable := make(map[string]bool)
+	for _, serverURL := range r.upstreamManager.GetMirrorCapableServers() {
+		mirrorCapable[serverURL] = true
+	}
+
+	targets, _ := r.upstreamManager.ShardTargets(hash)
+	missing := make([]string, 0)
+	for _, serverURL := range r.upstreamManager.TargetServerURLs(targets) {
+		if held[serverURL] || !mirrorCapable[serverURL] || r.upstreamManager.IsInMaintenance(serverURL) ||
+			r.quarantine.IsQuarantined(hash, serverURL) {
+			continue
+		}
+		missing = append(missing, serverURL)
+	}
+	return missing
+}
+
+// mirror asks a server to fetch a blob with a BUD-04 mirror request
+func (r *Repairer) mirror(ctx context.Context, serverURL string, body []byte, headers map[string]string) error {
+	cl, err := r.upstreamManager.GetClient(serverURL)
+	if err != nil {
+		return err
+	}
+	mirrorCtx, cancel := context.WithTimeout(ctx, r.mirrorTimeout)
+	defer cancel()
+	_, err = cl.Mirror(mirrorCtx, bytes.NewReader(body), "application/json", headers)
+	return err
+}
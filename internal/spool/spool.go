@@ -0,0 +1,210 @@
+package spool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/recovery"
+)
+
+// Spool keeps a copy of recently completed uploads on local disk so GET/HEAD requests for
+// their hash can be answered before every upstream has finished processing the blob
+type Spool struct {
+	mu        sync.Mutex
+	dir       string
+	retention time.Duration
+	maxBytes  int64
+	entries   map[string]*Entry // keyed by hash
+	verbose   *logging.Flag
+}
+
+// Entry is a spooled upload body whose hash was verified
+type Entry struct {
+	path        string
+	contentType string
+	size        int64
+	expiresAt   time.Time
+}
+
+// New creates a new spool storing files in dir (os.TempDir() if empty)
+// Completed uploads are kept for retention; uploads larger than maxBytes are not spooled
+func New(dir string, retention time.Duration, maxBytes int64, verbose *logging.Flag) (*Spool, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	return &Spool{
+		dir:       dir,
+		retention: retention,
+		maxBytes:  maxBytes,
+		entries:   make(map[string]*Entry),
+		verbose:   verbose,
+	}, nil
+}
+
+// Start runs the janitor that removes expired entries until ctx is cancelled
+func (s *Spool) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.removeExpired()
+			}
+		}
+	}()
+}
+
+// removeExpired deletes entries whose retention has elapsed
+func (s *Spool) removeExpired() {
+	defer recovery.Recover("spool janitor")
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, hash)
+			os.Remove(entry.path)
+			s.verbose.Debugf(context.Background(), "Spool: removed expired entry %s", hash)
+		}
+	}
+}
+
+// Writer spools an upload body while it streams to upstream servers
+// Write never fails so spooling problems cannot break the upload itself
+type Writer struct {
+	spool       *Spool
+	file        *os.File
+	contentType string
+	written     int64
+	disabled    bool
+}
+
+// Begin starts spooling an upload
+// The body is only served once Finish was given its calculated hash, so a body that
+// doesn't match the hash the client declared is never served under it
+// Returns nil if the upload should not be spooled (e.g., too large)
+func (s *Spool) Begin(contentType string, size int64) *Writer {
+	if s == nil || (s.maxBytes > 0 && size > s.maxBytes) {
+		return nil
+	}
+
+	file, err := os.CreateTemp(s.dir, "upload-*.spool")
+	if err != nil {
+		logging.Warnf(context.Background(), "Spool: failed to create spool file: %v", err)
+		return nil
+	}
+
+	s.verbose.Debugf(context.Background(), "Spool: spooling upload (size=%d) to %s", size, file.Name())
+
+	return &Writer{spool: s, file: file, contentType: contentType}
+}
+
+// Write appends data to the spool file
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.disabled {
+		return len(p), nil
+	}
+
+	if w.spool.maxBytes > 0 && w.written+int64(len(p)) > w.spool.maxBytes {
+		w.abort(fmt.Errorf("upload exceeds spool limit of %d bytes", w.spool.maxBytes))
+		return len(p), nil
+	}
+
+	n, err := w.file.Write(p)
+	w.written += int64(n)
+	if err != nil {
+		w.abort(err)
+	}
+	return len(p), nil
+}
+
+// abort stops spooling, the body will be discarded by Finish
+func (w *Writer) abort(err error) {
+	w.spool.verbose.Debugf(context.Background(), "Spool: aborting spool %s: %v", w.file.Name(), err)
+	w.disabled = true
+}
+
+// Finish completes the spool once the upload is done
+// hash is the calculated hash of the body; success reports whether the upload succeeded
+// On success the body is served under hash for the retention period; otherwise it is
+// discarded
+func (w *Writer) Finish(hash string, success bool) {
+	if w == nil {
+		return
+	}
+	w.file.Close()
+
+	s := w.spool
+	keep := success && !w.disabled && s.retention > 0
+	if keep {
+		entry := &Entry{
+			path:        w.file.Name(),
+			contentType: w.contentType,
+			size:        w.written,
+			expiresAt:   time.Now().Add(s.retention),
+		}
+		s.mu.Lock()
+		if _, exists := s.entries[hash]; exists {
+			// Another upload of the same blob is already spooled
+			keep = false
+		} else {
+			s.entries[hash] = entry
+		}
+		s.mu.Unlock()
+	}
+	if !keep {
+		os.Remove(w.file.Name())
+	}
+
+	s.verbose.Debugf(context.Background(), "Spool: finished spool for %s (success=%t, kept=%t, %d bytes)", hash, success, keep, w.written)
+}
+
+// Info describes a spooled blob
+type Info struct {
+	ContentType string
+	Size        int64
+}
+
+// Lookup returns information about a spooled blob
+func (s *Spool) Lookup(hash string) (Info, bool) {
+	if s == nil {
+		return Info{}, false
+	}
+	s.mu.Lock()
+	entry, exists := s.entries[hash]
+	s.mu.Unlock()
+	if !exists {
+		return Info{}, false
+	}
+	return Info{ContentType: entry.contentType, Size: entry.size}, true
+}
+
+// Open returns a reader for a spooled blob
+func (s *Spool) Open(hash string) (io.ReadCloser, Info, bool) {
+	if s == nil {
+		return nil, Info{}, false
+	}
+	s.mu.Lock()
+	entry, exists := s.entries[hash]
+	s.mu.Unlock()
+	if !exists {
+		return nil, Info{}, false
+	}
+
+	file, err := os.Open(entry.path)
+	if err != nil {
+		return nil, Info{}, false
+	}
+	return file, Info{ContentType: entry.contentType, Size: entry.size}, true
+}
@@ -0,0 +1,83 @@
+package spool
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// newTestSpool returns a spool in a temporary directory keeping blobs for a minute
+func newTestSpool(t *testing.T, maxBytes int64) *Spool {
+	t.Helper()
+	s, err := New(t.TempDir(), time.Minute, maxBytes, logging.NewLevels(nil).Flag(logging.Handler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// spoolFiles returns the number of files left in the spool directory
+func spoolFiles(t *testing.T, s *Spool) int {
+	t.Helper()
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestSpoolServesOnlyVerifiedHash(t *testing.T) {
+	s := newTestSpool(t, 0)
+	hash := strings.Repeat("ab", 32)
+
+	w := s.Begin("text/plain", 5)
+	w.Write([]byte("hello"))
+	if _, exists := s.Lookup(hash); exists {
+		t.Fatal("in-flight upload served before its hash was verified")
+	}
+	w.Finish(hash, true)
+
+	body, info, exists := s.Open(hash)
+	if !exists {
+		t.Fatal("completed upload not served")
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" || info.Size != 5 || info.ContentType != "text/plain" {
+		t.Errorf("got %q (%+v), want hello as text/plain", data, info)
+	}
+}
+
+func TestSpoolDiscardsFailedUploads(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		maxBytes int64
+		success  bool
+	}{
+		{"failed upload", 0, false},
+		{"over the spool limit", 3, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSpool(t, tc.maxBytes)
+			hash := strings.Repeat("cd", 32)
+
+			w := s.Begin("", -1)
+			w.Write([]byte("hello"))
+			w.Finish(hash, tc.success)
+
+			if _, _, exists := s.Open(hash); exists {
+				t.Error("discarded upload is served")
+			}
+			if n := spoolFiles(t, s); n != 0 {
+				t.Errorf("%d spool files left, want 0", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package stats
+
+import (
+	"sort"
+	"time"
+)
+
+// Bandwidth is accounted per UTC day and month; older periods are dropped
+const (
+	bandwidthDays   = 35
+	bandwidthMonths = 13
+)
+
+// BandwidthTotals counts the bytes moved on behalf of clients in one period
+// Downloads are redirected, so their bytes leave the upstream servers, not the proxy:
+// RedirectedBytes estimates that egress from the blob sizes known when redirecting
+type BandwidthTotals struct {
+	ReceivedBytes        int64 `json:"received_bytes"`         // Upload bodies received from clients
+	SentUpstreamBytes    int64 `json:"sent_upstream_bytes"`    // Upload bodies delivered to upstream servers
+	ServedBytes          int64 `json:"served_bytes"`           // Blobs served by the proxy itself (upload spool, queued uploads)
+	Redirects            int64 `json:"redirects"`              // Redirected downloads
+	RedirectedBytes      int64 `json:"redirected_bytes"`       // Estimated size of the redirected downloads
+	RedirectsUnknownSize int64 `json:"redirects_unknown_size"` // Redirects whose blob size wasn't cached (not in redirected_bytes)
+}
+
+// BandwidthPeriod is the bandwidth of one day ("2006-01-02") or month ("2006-01"), in
+// total and per upstream server (only sent_upstream_bytes and redirect fields apply there)
+type BandwidthPeriod struct {
+	Period string `json:"period"`
+	BandwidthTotals
+	Servers map[string]*BandwidthTotals `json:"servers,omitempty"`
+}
+
+// BandwidthReport lists the daily and monthly bandwidth, most recent period first
+type BandwidthReport struct {
+	Days   []*BandwidthPeriod `json:"days"`
+	Months []*BandwidthPeriod `json:"months"`
+}
+
+// bandwidthTracker holds the periods being accounted (guarded by Stats.mu)
+type bandwidthTracker struct {
+	days   map[string]*BandwidthPeriod
+	months map[string]*BandwidthPeriod
+}
+
+// RecordReceived records the body of an upload received from a client
+func (s *Stats) RecordReceived(bytes int64) {
+	s.recordBandwidth("", func(t *BandwidthTotals) { t.ReceivedBytes += bytes })
+}
+
+// RecordSentUpstream records upload bytes delivered to an upstream server
+func (s *Stats) RecordSentUpstream(serverURL string, bytes int64) {
+	s.recordBandwidth(serverURL, func(t *BandwidthTotals) { t.SentUpstreamBytes += bytes })
+}
+
+// RecordServed records a blob served to a client by the proxy itself
+func (s *Stats) RecordServed(bytes int64) {
+	s.recordBandwidth("", func(t *BandwidthTotals) { t.ServedBytes += bytes })
+}
+
+// RecordRedirect records a download redirected to a server; size is the blob size the
+// server advertised (from cached HEAD metadata), or negative if unknown
+func (s *Stats) RecordRedirect(serverURL string, size int64) {
+	s.recordBandwidth(serverURL, func(t *BandwidthTotals) {
+		t.Redirects++
+		if size >= 0 {
+			t.RedirectedBytes += size
+		} else {
+			t.RedirectsUnknownSize++
+		}
+	})
+}
+
+// recordBandwidth applies update to the current day and month, in total and for
+// serverURL (if not empty)
+func (s *Stats) recordBandwidth(serverURL string, update func(*BandwidthTotals)) {
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.bandwidth.days == nil {
+		s.bandwidth.days = make(map[string]*BandwidthPeriod)
+		s.bandwidth.months = make(map[string]*BandwidthPeriod)
+	}
+	for _, period := range []*BandwidthPeriod{
+		bandwidthPeriodLocked(s.bandwidth.days, now.Format("2006-01-02"), bandwidthDays),
+		bandwidthPeriodLocked(s.bandwidth.months, now.Format("2006-01"), bandwidthMonths),
+	} {
+		update(&period.BandwidthTotals)
+		if serverURL == "" {
+			continue
+		}
+		if period.Servers == nil {
+			period.Servers = make(map[string]*BandwidthTotals)
+		}
+		server, exists := period.Servers[serverURL]
+		if !exists {
+			server = &BandwidthTotals{}
+			period.Servers[serverURL] = server
+		}
+		update(server)
+	}
+}
+
+// bandwidthPeriodLocked returns the period with the given key, creating it and dropping
+// the oldest periods beyond keep when it is new (must be called with lock held)
+// Keys sort chronologically, so the oldest period is the smallest key
+func bandwidthPeriodLocked(periods map[string]*BandwidthPeriod, key string, keep int) *BandwidthPeriod {
+	if period, exists := periods[key]; exists {
+		return period
+	}
+	period := &BandwidthPeriod{Period: key}
+	periods[key] = period
+	for len(periods) > keep {
+		oldest := key
+		for k := range periods {
+			if k < oldest {
+				oldest = k
+			}
+		}
+		delete(periods, oldest)
+	}
+	return period
+}
+
+// GetBandwidth returns a copy of the daily and monthly bandwidth, most recent first
+func (s *Stats) GetBandwidth() BandwidthReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return BandwidthReport{
+		Days:   copyBandwidthPeriods(s.bandwidth.days),
+		Months: copyBandwidthPeriods(s.bandwidth.months),
+	}
+}
+
+// copyBandwidthPeriods copies periods into a slice sorted most recent first
+func copyBandwidthPeriods(periods map[string]*BandwidthPeriod) []*BandwidthPeriod {
+	result := make([]*BandwidthPeriod, 0, len(periods))
+	for _, period := range periods {
+		periodCopy := &BandwidthPeriod{Period: period.Period, BandwidthTotals: period.BandwidthTotals}
+		if period.Servers != nil {
+			periodCopy.Servers = make(map[string]*BandwidthTotals, len(period.Servers))
+			for serverURL, totals := range period.Servers {
+				totalsCopy := *totals
+				periodCopy.Servers[serverURL] = &totalsCopy
+			}
+		}
+		result = append(result, periodCopy)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Period > result[j].Period })
+	return result
+}
@@ -0,0 +1,97 @@
+package stats
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// latencyWindowSize is the number of recent samples percentiles are computed from
+const latencyWindowSize = 200
+
+// Latency summarizes the recent latency of successful requests of one operation on a server
+type Latency struct {
+	P50Ms   float64 `json:"p50_ms"`
+	P95Ms   float64 `json:"p95_ms"`
+	Samples int     `json:"samples"` // Samples in the window (at most 200)
+}
+
+// latencyWindow keeps the latest latency samples in a ring
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+func (lw *latencyWindow) add(d time.Duration) {
+	if len(lw.samples) < latencyWindowSize {
+		lw.samples = append(lw.samples, d)
+		return
+	}
+	lw.samples[lw.next] = d
+	lw.next = (lw.next + 1) % latencyWindowSize
+}
+
+// summary computes the percentiles of the samples in the window
+func (lw *latencyWindow) summary() *Latency {
+	sorted := append([]time.Duration(nil), lw.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return &Latency{
+		P50Ms:   durationMs(percentile(sorted, 0.50)),
+		P95Ms:   durationMs(percentile(sorted, 0.95)),
+		Samples: len(sorted),
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// RecordLatency records how long a successful request of an operation type (upload,
+// mirror, head, delete, list) to a server took
+func (s *Stats) RecordLatency(serverURL string, opType string, duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.latency == nil {
+		s.latency = make(map[string]map[string]*latencyWindow)
+	}
+	windows, exists := s.latency[serverURL]
+	if !exists {
+		windows = make(map[string]*latencyWindow)
+		s.latency[serverURL] = windows
+	}
+	lw, exists := windows[opType]
+	if !exists {
+		lw = &latencyWindow{}
+		windows[opType] = lw
+	}
+	lw.add(duration)
+}
+
+// latencyLocked summarizes the latency windows of a server (must be called with lock held)
+func (s *Stats) latencyLocked(serverURL string) map[string]*Latency {
+	windows := s.latency[serverURL]
+	if len(windows) == 0 {
+		return nil
+	}
+	summaries := make(map[string]*Latency, len(windows))
+	for opType, lw := range windows {
+		summaries[opType] = lw.summary()
+	}
+	return summaries
+}
@@ -0,0 +1,95 @@
+package stats
+
+// Snapshot holds the counters of a tracker, exported by one instance to be imported into
+// another (e.g. the new instance of a blue/green deploy)
+type Snapshot struct {
+	Servers   map[string]*ServerStats `json:"servers"`
+	Bandwidth BandwidthReport         `json:"bandwidth"`
+}
+
+// Export returns a copy of the per-server statistics and bandwidth
+func (s *Stats) Export() Snapshot {
+	return Snapshot{
+		Servers:   s.GetAll(),
+		Bandwidth: s.GetBandwidth(),
+	}
+}
+
+// Import adds the operation counters and bandwidth of a snapshot to the local ones, for
+// the servers accepted by keep. Throughput averages are taken for operations this
+// instance has not measured yet. Health is left for this instance to determine
+// Returns the number of servers whose counters were imported
+func (s *Stats) Import(snapshot Snapshot, keep func(serverURL string) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	imported := 0
+	for serverURL, other := range snapshot.Servers {
+		if other == nil || !keep(serverURL) {
+			continue
+		}
+		stats := s.GetOrCreateLocked(serverURL)
+		stats.AddCounts(other)
+		for opType, tp := range other.Throughput {
+			if tp == nil || tp.Samples == 0 {
+				continue
+			}
+			if stats.Throughput == nil {
+				stats.Throughput = make(map[string]*Throughput)
+			}
+			if _, exists := stats.Throughput[opType]; !exists {
+				tpCopy := *tp
+				stats.Throughput[opType] = &tpCopy
+			}
+		}
+		imported++
+	}
+
+	if s.bandwidth.days == nil {
+		s.bandwidth.days = make(map[string]*BandwidthPeriod)
+		s.bandwidth.months = make(map[string]*BandwidthPeriod)
+	}
+	importBandwidthLocked(s.bandwidth.days, snapshot.Bandwidth.Days, bandwidthDays, keep)
+	importBandwidthLocked(s.bandwidth.months, snapshot.Bandwidth.Months, bandwidthMonths, keep)
+	return imported
+}
+
+// importBandwidthLocked adds imported periods to the local ones, keeping the per-server
+// totals of the servers accepted by keep (must be called with lock held)
+func importBandwidthLocked(periods map[string]*BandwidthPeriod, imported []*BandwidthPeriod, keep int, keepServer func(string) bool) {
+	for _, other := range imported {
+		if other == nil || other.Period == "" {
+			continue
+		}
+		period := bandwidthPeriodLocked(periods, other.Period, keep)
+		if _, stillKept := periods[other.Period]; !stillKept {
+			// Older than every period kept
+			continue
+		}
+		period.add(&other.BandwidthTotals)
+		for serverURL, totals := range other.Servers {
+			if totals == nil || !keepServer(serverURL) {
+				continue
+			}
+			if period.Servers == nil {
+				period.Servers = make(map[string]*BandwidthTotals)
+			}
+			server, exists := period.Servers[serverURL]
+			if !exists {
+				server = &BandwidthTotals{}
+				period.Servers[serverURL] = server
+			}
+			server.add(totals)
+		}
+	}
+}
+
+// add adds other's counts to t
+func (t *BandwidthTotals) add(other *BandwidthTotals) {
+	t.ReceivedBytes += other.ReceivedBytes
+	t.SentUpstreamBytes += other.SentUpstreamBytes
+	t.ServedBytes += other.ServedBytes
+	t.Redirects += other.Redirects
+	t.RedirectedBytes += other.RedirectedBytes
+	t.RedirectsUnknownSize += other.RedirectsUnknownSize
+}
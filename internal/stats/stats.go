@@ -10,28 +10,147 @@ type ServerStats struct {
 	URL string `json:"url"`
 
 	// Operation counts
-	UploadsSuccess int64 `json:"uploads_success"`
-	UploadsFailure int64 `json:"uploads_failure"`
-	Downloads      int64 `json:"downloads"`
-	MirrorsSuccess int64 `json:"mirrors_success"`
-	MirrorsFailure int64 `json:"mirrors_failure"`
-	DeletesSuccess int64 `json:"deletes_success"`
-	DeletesFailure int64 `json:"deletes_failure"`
-	ListsSuccess   int64 `json:"lists_success"`
-	ListsFailure   int64 `json:"lists_failure"`
+	UploadsSuccess   int64 `json:"uploads_success"`
+	UploadsFailure   int64 `json:"uploads_failure"`
+	Downloads        int64 `json:"downloads"`
+	DownloadsFailure int64 `json:"downloads_failure"` // Failed lookups/HEAD requests while serving downloads
+	MirrorsSuccess   int64 `json:"mirrors_success"`
+	MirrorsFailure   int64 `json:"mirrors_failure"`
+	DeletesSuccess   int64 `json:"deletes_success"`
+	DeletesFailure   int64 `json:"deletes_failure"`
+	ListsSuccess     int64 `json:"lists_success"`
+	ListsFailure     int64 `json:"lists_failure"`
+
+	// Integrity tracking
+	SizeMismatches  int64 `json:"size_mismatches"`  // Times this server reported a blob size differing from other replicas
+	IntegrityChecks int64 `json:"integrity_checks"` // Background integrity checks performed against this server
+	CorruptReplicas int64 `json:"corrupt_replicas"` // Integrity checks where the blob did not match its SHA-256
+
+	// Upstream requests slower than slow_upstream_threshold (successful or not)
+	SlowRequests int64 `json:"slow_requests"`
 
 	// Health tracking
-	ConsecutiveFailures int `json:"consecutive_failures"`
-	IsHealthy           bool `json:"is_healthy"`
+	// ConsecutiveFailures counts failures across all operations; IsHealthy reflects the
+	// core operations (upload and download), see Operations for the per-operation state
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	IsHealthy           bool       `json:"is_healthy"`
 	LastFailureTime     *time.Time `json:"last_failure_time,omitempty"`
 	LastSuccessTime     *time.Time `json:"last_success_time,omitempty"`
+
+	// When IsHealthy took its current value (only the one matching IsHealthy is set)
+	// Servers start healthy, so HealthySince is their startup time until they first fail
+	HealthySince   *time.Time `json:"healthy_since,omitempty"`
+	UnhealthySince *time.Time `json:"unhealthy_since,omitempty"`
+
+	// Per-operation health (keyed by operation type: upload, download, mirror, delete, list)
+	// An upstream may serve downloads fine while its upload endpoint is rate limited
+	Operations map[string]*OperationHealth `json:"operations,omitempty"`
+
+	// Effective transfer rate of successful uploads and mirrors (keyed by operation type)
+	Throughput map[string]*Throughput `json:"throughput,omitempty"`
+
+	// Latency of recent successful requests (keyed by operation type: upload, mirror, head, delete, list)
+	Latency map[string]*Latency `json:"latency,omitempty"`
+}
+
+// throughputSmoothing is the weight of a new sample in the rolling throughput average
+const throughputSmoothing = 0.2
+
+// Throughput tracks the effective transfer rate (bytes / duration) of one operation on a server
+type Throughput struct {
+	BytesPerSecond     float64 `json:"bytes_per_second"`      // Exponentially weighted moving average
+	LastBytesPerSecond float64 `json:"last_bytes_per_second"` // Most recent sample
+	Samples            int64   `json:"samples"`
+	Bytes              int64   `json:"bytes"` // Total bytes measured
+}
+
+// OperationHealth tracks the health of a single operation type on a server
+type OperationHealth struct {
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	IsHealthy           bool       `json:"is_healthy"`
+	ChangedAt           *time.Time `json:"changed_at,omitempty"` // When IsHealthy last flipped (nil if it never did)
+}
+
+// coreOperations are the operations that determine a server's overall health
+var coreOperations = []string{"upload", "download"}
+
+// operationLocked returns the health state for an operation (must be called with lock held)
+func (stats *ServerStats) operationLocked(opType string) *OperationHealth {
+	if stats.Operations == nil {
+		stats.Operations = make(map[string]*OperationHealth)
+	}
+	op, exists := stats.Operations[opType]
+	if !exists {
+		op = &OperationHealth{IsHealthy: true}
+		stats.Operations[opType] = op
+	}
+	return op
+}
+
+// isHealthyForLocked reports whether the server is healthy for an operation (must be called with lock held)
+func (stats *ServerStats) isHealthyForLocked(opType string) bool {
+	if op, exists := stats.Operations[opType]; exists {
+		return op.IsHealthy
+	}
+	return true
+}
+
+// updateOverallHealthLocked recomputes IsHealthy from the core operations (must be called with lock held)
+// Returns true if IsHealthy changed
+func (stats *ServerStats) updateOverallHealthLocked() bool {
+	wasHealthy := stats.IsHealthy
+	stats.IsHealthy = true
+	for _, opType := range coreOperations {
+		if !stats.isHealthyForLocked(opType) {
+			stats.IsHealthy = false
+		}
+	}
+	if stats.IsHealthy == wasHealthy {
+		return false
+	}
+	now := time.Now()
+	if stats.IsHealthy {
+		stats.HealthySince = &now
+		stats.UnhealthySince = nil
+	} else {
+		stats.UnhealthySince = &now
+		stats.HealthySince = nil
+	}
+	return true
+}
+
+// HealthDuration returns how long the server has been in its current health state
+func (stats *ServerStats) HealthDuration() time.Duration {
+	since := stats.HealthySince
+	if !stats.IsHealthy {
+		since = stats.UnhealthySince
+	}
+	if since == nil {
+		return 0
+	}
+	return time.Since(*since)
+}
+
+// newServerStats creates the stats of a server, which starts out healthy
+func newServerStats(serverURL string) *ServerStats {
+	now := time.Now()
+	return &ServerStats{
+		URL:          serverURL,
+		IsHealthy:    true,
+		HealthySince: &now,
+	}
 }
 
 // Stats tracks all statistics
 type Stats struct {
-	mu          sync.RWMutex
-	serverStats map[string]*ServerStats // keyed by server URL
-	maxFailures int
+	mu              sync.RWMutex
+	serverStats     map[string]*ServerStats // keyed by server URL
+	maxFailures     int
+	maxFailuresFunc func(serverURL string, opType string) int // Per-server/per-operation thresholds (optional)
+	onHealthChange  func(serverURL string, healthy bool)      // Notified when live traffic flips a server's health (optional)
+	latency         map[string]map[string]*latencyWindow      // Recent latency samples, by server URL and operation type
+	startedAt       time.Time                                 // When the tracker was created, i.e. process startup
+	bandwidth       bandwidthTracker                          // Daily and monthly bytes moved for clients
 }
 
 // New creates a new Stats tracker
@@ -39,6 +158,44 @@ func New(maxFailures int) *Stats {
 	return &Stats{
 		serverStats: make(map[string]*ServerStats),
 		maxFailures: maxFailures,
+		startedAt:   time.Now(),
+	}
+}
+
+// StartedAt returns when the tracker was created (at process startup)
+func (s *Stats) StartedAt() time.Time {
+	return s.startedAt
+}
+
+// Uptime returns the time elapsed since the tracker was created
+func (s *Stats) Uptime() time.Duration {
+	return time.Since(s.startedAt)
+}
+
+// SetMaxFailuresFunc sets the function resolving the consecutive failure threshold for an
+// operation on a server. If not set, max_failures applies to every server and operation
+func (s *Stats) SetMaxFailuresFunc(getter func(serverURL string, opType string) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxFailuresFunc = getter
+}
+
+// SetHealthChangeFunc sets the function notified when recorded successes or failures flip
+// a server's overall health. It is called without the lock held. Health changes made by
+// MarkHealthy, MarkUnhealthy and MergeHealth are not notified; their callers report them
+func (s *Stats) SetHealthChangeFunc(notify func(serverURL string, healthy bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onHealthChange = notify
+}
+
+// notifyHealthChange calls the health change function, if any (must be called without lock held)
+func (s *Stats) notifyHealthChange(serverURL string, healthy bool) {
+	s.mu.RLock()
+	notify := s.onHealthChange
+	s.mu.RUnlock()
+	if notify != nil {
+		notify(serverURL, healthy)
 	}
 }
 
@@ -51,16 +208,19 @@ func (s *Stats) GetOrCreate(serverURL string) *ServerStats {
 		return stats
 	}
 
-	stats := &ServerStats{
-		URL:       serverURL,
-		IsHealthy: true,
-	}
+	stats := newServerStats(serverURL)
 	s.serverStats[serverURL] = stats
 	return stats
 }
 
 // RecordSuccess records a successful operation for a server
 func (s *Stats) RecordSuccess(serverURL string, opType string) {
+	recovered := false
+	defer func() {
+		if recovered {
+			s.notifyHealthChange(serverURL, true)
+		}
+	}()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -68,7 +228,13 @@ func (s *Stats) RecordSuccess(serverURL string, opType string) {
 	now := time.Now()
 	stats.LastSuccessTime = &now
 	stats.ConsecutiveFailures = 0 // Reset consecutive failures on success
-	stats.IsHealthy = true
+	op := stats.operationLocked(opType)
+	op.ConsecutiveFailures = 0
+	if !op.IsHealthy {
+		op.ChangedAt = &now
+	}
+	op.IsHealthy = true
+	recovered = stats.updateOverallHealthLocked()
 
 	switch opType {
 	case "upload":
@@ -86,6 +252,12 @@ func (s *Stats) RecordSuccess(serverURL string, opType string) {
 
 // RecordFailure records a failed operation for a server
 func (s *Stats) RecordFailure(serverURL string, opType string) {
+	failed := false
+	defer func() {
+		if failed {
+			s.notifyHealthChange(serverURL, false)
+		}
+	}()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -94,14 +266,24 @@ func (s *Stats) RecordFailure(serverURL string, opType string) {
 	stats.LastFailureTime = &now
 	stats.ConsecutiveFailures++
 
-	// Mark unhealthy if consecutive failures exceed threshold
-	if stats.ConsecutiveFailures >= s.maxFailures {
-		stats.IsHealthy = false
+	// Mark the operation unhealthy if its consecutive failures exceed threshold
+	op := stats.operationLocked(opType)
+	op.ConsecutiveFailures++
+	maxFailures := s.maxFailures
+	if s.maxFailuresFunc != nil {
+		maxFailures = s.maxFailuresFunc(serverURL, opType)
+	}
+	if op.ConsecutiveFailures >= maxFailures && op.IsHealthy {
+		op.IsHealthy = false
+		op.ChangedAt = &now
 	}
+	failed = stats.updateOverallHealthLocked()
 
 	switch opType {
 	case "upload":
 		stats.UploadsFailure++
+	case "download":
+		stats.DownloadsFailure++
 	case "mirror":
 		stats.MirrorsFailure++
 	case "delete":
@@ -111,16 +293,108 @@ func (s *Stats) RecordFailure(serverURL string, opType string) {
 	}
 }
 
+// MarkHealthy marks every unhealthy operation of a server healthy again and resets their
+// consecutive failures, without counting a success (used by active health checks)
+// Healthy operations keep their failure counts. Returns true if the server had unhealthy operations
+func (s *Stats) MarkHealthy(serverURL string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.GetOrCreateLocked(serverURL)
+	now := time.Now()
+	changed := false
+	for _, op := range stats.Operations {
+		if !op.IsHealthy {
+			op.IsHealthy = true
+			op.ConsecutiveFailures = 0
+			op.ChangedAt = &now
+			changed = true
+		}
+	}
+	if changed {
+		stats.ConsecutiveFailures = 0
+	}
+	stats.updateOverallHealthLocked()
+	return changed
+}
+
+// MarkUnhealthy marks the core operations (upload and download) of a server unhealthy,
+// without counting a failure (used by active health checks when a server is unreachable)
+// Returns true if the server was healthy
+func (s *Stats) MarkUnhealthy(serverURL string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.GetOrCreateLocked(serverURL)
+	now := time.Now()
+	changed := false
+	for _, opType := range coreOperations {
+		op := stats.operationLocked(opType)
+		if op.IsHealthy {
+			op.IsHealthy = false
+			op.ChangedAt = &now
+			changed = true
+		}
+	}
+	stats.updateOverallHealthLocked()
+	return changed
+}
+
+// MergeHealth adopts the health of an operation on a server as determined by another
+// instance, if it flipped more recently than the local state did
+// Servers without local stats are ignored. Returns true if the local health changed
+func (s *Stats) MergeHealth(serverURL string, opType string, remote OperationHealth) bool {
+	if remote.ChangedAt == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, exists := s.serverStats[serverURL]
+	if !exists {
+		return false
+	}
+	op := stats.operationLocked(opType)
+	if op.ChangedAt != nil && !remote.ChangedAt.After(*op.ChangedAt) {
+		return false
+	}
+
+	changed := op.IsHealthy != remote.IsHealthy
+	changedAt := *remote.ChangedAt
+	op.IsHealthy = remote.IsHealthy
+	op.ConsecutiveFailures = remote.ConsecutiveFailures
+	op.ChangedAt = &changedAt
+	stats.updateOverallHealthLocked()
+	return changed
+}
+
+// AddCounts adds the operation and integrity counters of other to stats
+// Health and throughput are left untouched
+func (stats *ServerStats) AddCounts(other *ServerStats) {
+	stats.UploadsSuccess += other.UploadsSuccess
+	stats.UploadsFailure += other.UploadsFailure
+	stats.Downloads += other.Downloads
+	stats.DownloadsFailure += other.DownloadsFailure
+	stats.MirrorsSuccess += other.MirrorsSuccess
+	stats.MirrorsFailure += other.MirrorsFailure
+	stats.DeletesSuccess += other.DeletesSuccess
+	stats.DeletesFailure += other.DeletesFailure
+	stats.ListsSuccess += other.ListsSuccess
+	stats.ListsFailure += other.ListsFailure
+	stats.SizeMismatches += other.SizeMismatches
+	stats.IntegrityChecks += other.IntegrityChecks
+	stats.CorruptReplicas += other.CorruptReplicas
+	stats.SlowRequests += other.SlowRequests
+}
+
 // GetOrCreateLocked gets or creates stats (must be called with lock held)
 func (s *Stats) GetOrCreateLocked(serverURL string) *ServerStats {
 	if stats, exists := s.serverStats[serverURL]; exists {
 		return stats
 	}
 
-	stats := &ServerStats{
-		URL:       serverURL,
-		IsHealthy: true,
-	}
+	stats := newServerStats(serverURL)
 	s.serverStats[serverURL] = stats
 	return stats
 }
@@ -134,6 +408,21 @@ func (s *Stats) GetAll() map[string]*ServerStats {
 	for url, stats := range s.serverStats {
 		// Create a copy to avoid race conditions
 		statsCopy := *stats
+		if stats.Operations != nil {
+			statsCopy.Operations = make(map[string]*OperationHealth, len(stats.Operations))
+			for opType, op := range stats.Operations {
+				opCopy := *op
+				statsCopy.Operations[opType] = &opCopy
+			}
+		}
+		if stats.Throughput != nil {
+			statsCopy.Throughput = make(map[string]*Throughput, len(stats.Throughput))
+			for opType, tp := range stats.Throughput {
+				tpCopy := *tp
+				statsCopy.Throughput[opType] = &tpCopy
+			}
+		}
+		statsCopy.Latency = s.latencyLocked(url)
 		result[url] = &statsCopy
 	}
 	return result
@@ -165,6 +454,44 @@ func (s *Stats) GetHealthStatus() map[string]bool {
 	return result
 }
 
+// IsHealthyFor reports whether a server is healthy for a given operation type
+func (s *Stats) IsHealthyFor(serverURL string, opType string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats, exists := s.serverStats[serverURL]
+	if !exists {
+		return true
+	}
+	return stats.isHealthyForLocked(opType)
+}
+
+// GetHealthyCountFor returns the number of servers healthy for a given operation type
+func (s *Stats) GetHealthyCountFor(opType string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, stats := range s.serverStats {
+		if stats.isHealthyForLocked(opType) {
+			count++
+		}
+	}
+	return count
+}
+
+// GetHealthStatusFor returns the health status of all servers for a given operation type
+func (s *Stats) GetHealthStatusFor(opType string) map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]bool, len(s.serverStats))
+	for url, stats := range s.serverStats {
+		result[url] = stats.isHealthyForLocked(opType)
+	}
+	return result
+}
+
 // InitializeServers initializes stats for all given server URLs, marking them as healthy
 // This should be called at startup to ensure all servers start as healthy
 func (s *Stats) InitializeServers(serverURLs []string) {
@@ -174,14 +501,20 @@ func (s *Stats) InitializeServers(serverURLs []string) {
 	for _, url := range serverURLs {
 		// Only initialize if not already present (don't overwrite existing stats)
 		if _, exists := s.serverStats[url]; !exists {
-			s.serverStats[url] = &ServerStats{
-				URL:       url,
-				IsHealthy: true,
-			}
+			s.serverStats[url] = newServerStats(url)
 		}
 	}
 }
 
+// RemoveServer forgets the statistics of a server removed from the upstream set
+func (s *Stats) RemoveServer(serverURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.serverStats, serverURL)
+	delete(s.latency, serverURL)
+}
+
 // GetTotalFailures returns the total number of failures for a server
 // Sums upload, mirror, delete, and list failures
 func (s *Stats) GetTotalFailures(serverURL string) int64 {
@@ -195,3 +528,106 @@ func (s *Stats) GetTotalFailures(serverURL string) int64 {
 
 	return stats.UploadsFailure + stats.MirrorsFailure + stats.DeletesFailure + stats.ListsFailure
 }
+
+// GetFailuresFor returns the total number of failures of a given operation type for a server
+// Used by the health_based strategy to rank servers by the operation being performed
+func (s *Stats) GetFailuresFor(serverURL string, opType string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats, exists := s.serverStats[serverURL]
+	if !exists {
+		return 0
+	}
+
+	switch opType {
+	case "upload":
+		return stats.UploadsFailure
+	case "download":
+		return stats.DownloadsFailure
+	case "mirror":
+		return stats.MirrorsFailure
+	case "delete":
+		return stats.DeletesFailure
+	case "list":
+		return stats.ListsFailure
+	}
+	return 0
+}
+
+// RecordSizeMismatch records that a server reported a blob size that disagrees with other replicas
+// This is tracked as a corruption suspect and does not affect health
+func (s *Stats) RecordSizeMismatch(serverURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.GetOrCreateLocked(serverURL)
+	stats.SizeMismatches++
+}
+
+// RecordIntegrityCheck records the outcome of a background integrity check for a server
+// A corrupt replica is tracked separately and does not affect health
+func (s *Stats) RecordIntegrityCheck(serverURL string, corrupt bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.GetOrCreateLocked(serverURL)
+	stats.IntegrityChecks++
+	if corrupt {
+		stats.CorruptReplicas++
+	}
+}
+
+// RecordSlowRequest records that a request to a server exceeded slow_upstream_threshold
+// Slowness alone does not affect health
+func (s *Stats) RecordSlowRequest(serverURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.GetOrCreateLocked(serverURL)
+	stats.SlowRequests++
+}
+
+// RecordThroughput records the effective throughput of a successful transfer to a server
+// The rolling average weighs recent samples more, so it follows changes in upstream speed
+func (s *Stats) RecordThroughput(serverURL string, opType string, bytes int64, duration time.Duration) {
+	if bytes <= 0 || duration <= 0 {
+		return
+	}
+	rate := float64(bytes) / duration.Seconds()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.GetOrCreateLocked(serverURL)
+	if stats.Throughput == nil {
+		stats.Throughput = make(map[string]*Throughput)
+	}
+	tp, exists := stats.Throughput[opType]
+	if !exists {
+		tp = &Throughput{BytesPerSecond: rate}
+		stats.Throughput[opType] = tp
+	} else {
+		tp.BytesPerSecond = throughputSmoothing*rate + (1-throughputSmoothing)*tp.BytesPerSecond
+	}
+	tp.LastBytesPerSecond = rate
+	tp.Samples++
+	tp.Bytes += bytes
+}
+
+// GetThroughputFor returns the rolling average throughput (bytes per second) of an operation
+// type on a server, or 0 if it has not been measured yet
+// Used by the throughput_aware strategy
+func (s *Stats) GetThroughputFor(serverURL string, opType string) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats, exists := s.serverStats[serverURL]
+	if !exists || stats.Throughput == nil {
+		return 0
+	}
+	if tp, exists := stats.Throughput[opType]; exists {
+		return tp.BytesPerSecond
+	}
+	return 0
+}
@@ -0,0 +1,131 @@
+// Package testutil holds the fixtures shared by the tests of several packages: configs,
+// fake upstreams and misbehaving request bodies
+package testutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/config"
+)
+
+// LoadConfig writes a config with the given upstream URLs and server settings (YAML lines
+// indented under server:) and loads it
+func LoadConfig(t testing.TB, server string, urls ...string) *config.Config {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("upstream_servers:\n")
+	for _, url := range urls {
+		fmt.Fprintf(&b, "  - url: %q\n", url)
+	}
+	fmt.Fprintf(&b, "server:\n%s", server)
+	return LoadConfigYAML(t, b.String())
+}
+
+// LoadConfigYAML writes the given config file and loads it
+func LoadConfigYAML(t testing.TB, yaml string) *config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	return cfg
+}
+
+// Upload is what a fake upstream saw of an upload body
+type Upload struct {
+	Received int
+	Complete bool // The body was read to a clean EOF
+}
+
+// UploadingUpstream starts a fake upstream reading upload bodies to the end, reporting
+// each upload on the returned channel and answering 200 to complete ones. started is
+// signalled once the first bytes of a body arrived
+func UploadingUpstream(t testing.TB, started chan<- struct{}) (*httptest.Server, <-chan Upload) {
+	t.Helper()
+	uploads := make(chan Upload, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.NotFound(w, r)
+			return
+		}
+		var body bytes.Buffer
+		n, err := io.CopyN(&body, r.Body, 1)
+		if n > 0 {
+			started <- struct{}{}
+		}
+		if err == nil {
+			_, err = io.Copy(&body, r.Body)
+		}
+		complete := err == nil || err == io.EOF
+		uploads <- Upload{Received: body.Len(), Complete: complete}
+		if !complete {
+			return
+		}
+		sum := sha256.Sum256(body.Bytes())
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"url":"http://%s/%x","sha256":"%x","size":%d}`, r.Host, sum, sum, body.Len())
+	}))
+	t.Cleanup(srv.Close)
+	return srv, uploads
+}
+
+// AnsweringUpstream starts a fake upstream storing uploads right away
+// Connections are not kept alive, so idle ones don't count as leaked goroutines
+func AnsweringUpstream(t testing.TB) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		sum := sha256.Sum256(body)
+		w.Header().Set("Connection", "close")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"url":"http://%s/%x","sha256":"%x","size":%d}`, r.Host, sum, sum, len(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// DisconnectingBody yields Data, then fails with Err (io.ErrUnexpectedEOF if nil) like a
+// client disconnecting mid-upload, once Upstreams upstreams have signalled Started
+type DisconnectingBody struct {
+	Data      *bytes.Reader
+	Started   <-chan struct{}
+	Upstreams int
+	Err       error
+}
+
+func (b *DisconnectingBody) Read(p []byte) (int, error) {
+	if b.Data.Len() > 0 {
+		return b.Data.Read(p)
+	}
+	for ; b.Upstreams > 0; b.Upstreams-- {
+		select {
+		case <-b.Started:
+		case <-time.After(5 * time.Second):
+			return 0, errors.New("upstreams never received the body")
+		}
+	}
+	if b.Err != nil {
+		return 0, b.Err
+	}
+	return 0, io.ErrUnexpectedEOF
+}
+
+func (b *DisconnectingBody) Close() error { return nil }
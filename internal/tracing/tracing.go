@@ -0,0 +1,147 @@
+// Package tracing exports OpenTelemetry spans: one per client request, with a child span
+// per upstream request made on its behalf, so the upstream slowing down a multi-server
+// upload shows up in the trace
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracesPath is where OTLP/HTTP receivers accept traces, used when tracing_endpoint has no path
+const tracesPath = "/v1/traces"
+
+// tracer creates every span; it does nothing until Setup installs an exporter
+var tracer = otel.Tracer("github.com/girino/blossom_espelhator")
+
+// Setup exports spans to the OTLP/HTTP endpoint configured in tracing_endpoint, sampling
+// tracing_sample_ratio of the client requests (a client's traceparent header decides
+// for its request). Does nothing if no endpoint is configured
+// Returns a function flushing the pending spans, to call before exiting
+func Setup(cfg *config.ServerConfig) (func(context.Context) error, error) {
+	if cfg.TracingEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	endpoint, err := url.Parse(cfg.TracingEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracing_endpoint: %w", err)
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = tracesPath
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(endpoint.String())}
+	if len(cfg.TracingHeaders) > 0 {
+		options = append(options, otlptracehttp.WithHeaders(cfg.TracingHeaders))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(cfg.TracingServiceName),
+			semconv.ServiceVersion(version.Version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a span for every client request, continuing the trace of a
+// traceparent header sent by the client. The span is named after the endpoint (the hash
+// and pubkey in paths are left out, so requests for different blobs group together)
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+route(r.URL.Path),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+			))
+		defer span.End()
+		if id := logging.RequestID(ctx); id != "" {
+			span.SetAttributes(attribute.String("request_id", id))
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+// route returns the endpoint of a request path, with the blob hash or pubkey replaced
+func route(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/list/"):
+		return "/list/{pubkey}"
+	case strings.HasPrefix(path, "/admin/"), strings.HasPrefix(path, "/cluster/"):
+		return path
+	case len(path) >= 65:
+		return "/{sha256}"
+	}
+	return path
+}
+
+// RecordUpstream records a finished upstream request as a child span of the client
+// request's span in ctx (nothing is recorded outside a traced request)
+func RecordUpstream(ctx context.Context, serverURL string, operation string, duration time.Duration, err error) {
+	if !trace.SpanFromContext(ctx).SpanContext().IsSampled() {
+		return
+	}
+	end := time.Now()
+	_, span := tracer.Start(ctx, "upstream "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(end.Add(-duration)),
+		trace.WithAttributes(
+			attribute.String("upstream.server", serverURL),
+			attribute.String("upstream.operation", operation),
+		))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// statusWriter remembers the status code of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	w.status = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,95 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/recovery"
+)
+
+// acceptedPolling configures follow-up availability checks for 202 Accepted replies
+type acceptedPolling struct {
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// PollAccepted follows up on servers that replied 202 Accepted to an upload or mirror
+// request for hash. Each server is polled with HEAD /<hash> until the blob becomes
+// available or the poll timeout elapses. onAvailable is called for each server once the
+// blob is confirmed (the server is then also added to the hash's settling replicas);
+// onTimeout is called for servers that never confirmed. Polling runs in the background
+// until ctx is cancelled (at shutdown, no callback is called)
+func (m *Manager) PollAccepted(parent context.Context, hash string, serverURLs []string, onAvailable func(serverURL string), onTimeout func(serverURL string)) {
+	if m.acceptedPoll.timeout <= 0 {
+		return
+	}
+
+	for _, serverURL := range serverURLs {
+		cl, err := m.GetClient(serverURL)
+		if err != nil {
+			continue
+		}
+
+		go func(serverURL string) {
+			defer recovery.Recover("PollAccepted")
+			ctx, cancel := context.WithTimeout(parent, m.acceptedPoll.timeout)
+			defer cancel()
+
+			ticker := time.NewTicker(m.acceptedPoll.interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					if parent.Err() != nil {
+						return
+					}
+					logging.Warnf(ctx, "PollAccepted: %s accepted %s but it did not become available within %v", serverURL, hash, m.acceptedPoll.timeout)
+					if onTimeout != nil {
+						onTimeout(serverURL)
+					}
+					return
+				case <-ticker.C:
+				}
+
+				resp, err := cl.Head(ctx, hash)
+				if err != nil {
+					continue
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					continue
+				}
+
+				m.verbose.Debugf(ctx, "PollAccepted: %s is now available on %s", hash, serverURL)
+				m.addSettlingServer(hash, serverURL)
+				if onAvailable != nil {
+					onAvailable(serverURL)
+				}
+				return
+			}
+		}(serverURL)
+	}
+}
+
+// addSettlingServer adds a server confirmed after the upload to the hash's settling replicas
+func (m *Manager) addSettlingServer(hash string, serverURL string) {
+	if m.settling.period <= 0 {
+		return
+	}
+
+	m.settling.mu.Lock()
+	upload, exists := m.settling.uploads[hash]
+	if exists && time.Now().Before(upload.until) {
+		upload.servers = append(upload.servers, serverURL)
+		m.settling.uploads[hash] = upload
+		m.settling.mu.Unlock()
+		return
+	}
+	m.settling.mu.Unlock()
+
+	// The upload had no confirmed servers yet (all replied 202), start settling now
+	m.MarkUploaded(hash, []string{serverURL})
+}
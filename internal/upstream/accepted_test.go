@@ -0,0 +1,45 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPollAcceptedStopsWithContext(t *testing.T) {
+	polled := make(chan struct{}, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polled <- struct{}{}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	m := newTestManager(t, "  min_upload_servers: 1\n  accepted_poll_interval: 10ms\n  accepted_poll_timeout: 1m\n", srv.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan string, 1)
+	m.PollAccepted(ctx, strings.Repeat("ab", 32), []string{srv.URL},
+		func(serverURL string) { done <- "available" },
+		func(serverURL string) { done <- "timeout" })
+
+	select {
+	case <-polled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server never polled")
+	}
+	cancel()
+	// Drain the polls in flight, then no more may come
+	time.Sleep(50 * time.Millisecond)
+	for len(polled) > 0 {
+		<-polled
+	}
+	select {
+	case <-polled:
+		t.Error("server still polled after the context was cancelled")
+	case outcome := <-done:
+		t.Errorf("%s callback called after the context was cancelled", outcome)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,15 @@
+package upstream
+
+// SetTransferRecorder sets the function receiving the number of upload bytes delivered to
+// each upstream server (bandwidth accounting), successful or not
+func (m *Manager) SetTransferRecorder(recorder func(serverURL string, bytes int64)) {
+	m.recordTransfer = recorder
+}
+
+// observeTransfer records upload bytes delivered to a server
+func (m *Manager) observeTransfer(serverURL string, bytes int64) {
+	if m.recordTransfer == nil || bytes <= 0 {
+		return
+	}
+	m.recordTransfer(serverURL, bytes)
+}
@@ -0,0 +1,257 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// bufferedPipeChunk is the largest piece handed to a pipe in one write
+const bufferedPipeChunk = 32 * 1024
+
+// bufferedPipe feeds one upstream's pipe from its own bounded buffer, so a slow upstream
+// doesn't throttle the upload to the fast ones: Write only appends to the buffer and a
+// drain goroutine pushes the data into the pipe at the upstream's own pace
+// When the memory buffer is full, data is spilled to a temporary file; without a spill
+// file (disabled or not creatable) Write waits for the drainer instead. An upstream whose
+// spill file would outgrow maxSpill is dropped from the upload with ErrSpillLimit
+// Write never returns an error, so io.MultiWriter keeps feeding the other pipes after
+// one upstream failed
+type bufferedPipe struct {
+	w        *io.PipeWriter
+	name     string // for debugging
+	limit    int    // Memory buffer size
+	spillDir string // Directory for the spill file ("" = spilling disabled)
+	maxSpill int64  // Largest spill file (<= 0 = unlimited)
+	verbose  *logging.Flag
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	mem     bytes.Buffer // Buffered data not yet written to the pipe
+	spill   *os.File     // Overflow data, always later in the stream than mem
+	spillR  int64        // Offset of the next byte to drain from spill
+	spillW  int64        // Offset of the next byte to append to spill
+	spilled int64        // Total bytes that went through the spill file
+	written int64        // Bytes written to the pipe (received by the upstream)
+	closed  bool         // No more writes; close the pipe once drained
+	err     error        // Pipe failed or was aborted; buffered data is discarded
+	done    chan struct{}
+
+	onDrained func() // Called once all data was written and the pipe closed cleanly
+}
+
+// newBufferedPipe returns a buffered pipe for w; the caller runs drain in its own goroutine
+// onDrained may be nil
+func newBufferedPipe(w *io.PipeWriter, name string, limit int, spillDir string, maxSpill int64, verbose *logging.Flag, onDrained func()) *bufferedPipe {
+	bp := &bufferedPipe{
+		w:         w,
+		name:      name,
+		limit:     limit,
+		spillDir:  spillDir,
+		maxSpill:  maxSpill,
+		verbose:   verbose,
+		done:      make(chan struct{}),
+		onDrained: onDrained,
+	}
+	bp.cond = sync.NewCond(&bp.mu)
+	return bp
+}
+
+// Write queues p for the pipe; it only blocks when the buffer is full and can't spill
+func (bp *bufferedPipe) Write(p []byte) (int, error) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	if bp.err != nil || bp.closed {
+		// Already failed or closed: skip writes but report success to keep MultiWriter going
+		return len(p), nil
+	}
+
+	// Data must stay in order: once something was spilled, everything goes to the
+	// spill file until the drainer has caught up with it
+	if bp.spillR == bp.spillW && bp.mem.Len()+len(p) <= bp.limit {
+		bp.mem.Write(p)
+		bp.cond.Broadcast()
+		return len(p), nil
+	}
+
+	if bp.spillDir != "" && bp.spill == nil {
+		f, err := os.CreateTemp(bp.spillDir, "upload-buffer-*")
+		if err != nil {
+			logging.Warnf(context.Background(), "bufferedPipe %s: can't create spill file, waiting for the upstream instead: %v", bp.name, err)
+			bp.spillDir = ""
+		} else {
+			bp.spill = f
+			bp.verbose.Debugf(context.Background(), "bufferedPipe %s: memory buffer full, spilling to %s", bp.name, f.Name())
+		}
+	}
+
+	if bp.spill != nil {
+		if bp.maxSpill > 0 && bp.spillW+int64(len(p)) > bp.maxSpill {
+			logging.Warnf(context.Background(), "bufferedPipe %s: spill file would exceed %d bytes, dropping the server from the upload", bp.name, bp.maxSpill)
+			bp.failLocked(ErrSpillLimit)
+			return len(p), nil
+		}
+		n, err := bp.spill.WriteAt(p, bp.spillW)
+		bp.spillW += int64(n)
+		bp.spilled += int64(n)
+		if err != nil {
+			// The stream has a hole now, so this upstream can't get a correct copy
+			bp.failLocked(fmt.Errorf("upload buffer spill failed: %w", err))
+		}
+		bp.cond.Broadcast()
+		return len(p), nil
+	}
+
+	// No spill file: wait until the drainer made room (an oversized write goes through
+	// once the buffer is empty)
+	for bp.err == nil && bp.mem.Len() > 0 && bp.mem.Len()+len(p) > bp.limit {
+		bp.cond.Wait()
+	}
+	if bp.err == nil {
+		bp.mem.Write(p)
+		bp.cond.Broadcast()
+	}
+	return len(p), nil
+}
+
+// Close closes the pipe cleanly once all buffered data has been written to it
+func (bp *bufferedPipe) Close() error {
+	bp.mu.Lock()
+	bp.closed = true
+	bp.cond.Broadcast()
+	bp.mu.Unlock()
+	return nil
+}
+
+// Abort fails the pipe with err right away, discarding buffered data, so the upstream
+// never sees a clean EOF on a truncated body
+func (bp *bufferedPipe) Abort(err error) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.failLocked(err)
+}
+
+// GetError returns the error that failed the pipe, if any
+func (bp *bufferedPipe) GetError() error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return bp.err
+}
+
+// Spilled returns the number of bytes that went through the spill file
+func (bp *bufferedPipe) Spilled() int64 {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return bp.spilled
+}
+
+// Delivered returns the number of bytes the upstream has read from the pipe so far
+func (bp *bufferedPipe) Delivered() int64 {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return bp.written
+}
+
+// Done is closed when the drain goroutine has exited
+func (bp *bufferedPipe) Done() <-chan struct{} {
+	return bp.done
+}
+
+// failLocked records err, closes the pipe with it and drops buffered data
+func (bp *bufferedPipe) failLocked(err error) {
+	if bp.err != nil {
+		return
+	}
+	bp.err = err
+	bp.w.CloseWithError(err)
+	bp.mem.Reset()
+	bp.closeSpillLocked()
+	bp.cond.Broadcast()
+}
+
+// closeSpillLocked removes the spill file
+func (bp *bufferedPipe) closeSpillLocked() {
+	if bp.spill == nil {
+		return
+	}
+	bp.spill.Close()
+	os.Remove(bp.spill.Name())
+	bp.spill = nil
+	bp.spillR, bp.spillW = 0, 0
+}
+
+// drain writes buffered data to the pipe until the pipe is closed or fails
+func (bp *bufferedPipe) drain() {
+	defer close(bp.done)
+	chunk := make([]byte, bufferedPipeChunk)
+
+	for {
+		bp.mu.Lock()
+		for bp.err == nil && !bp.closed && bp.mem.Len() == 0 && bp.spillR == bp.spillW {
+			bp.cond.Wait()
+		}
+		if bp.err != nil {
+			bp.mu.Unlock()
+			return
+		}
+
+		var n int
+		switch {
+		case bp.mem.Len() > 0:
+			n, _ = bp.mem.Read(chunk)
+			bp.cond.Broadcast() // Writers waiting for room
+			bp.mu.Unlock()
+		case bp.spillR < bp.spillW:
+			// The region being read is only appended to (never rewritten) while the
+			// drainer is behind, so it can be read without the lock
+			spill, offset := bp.spill, bp.spillR
+			n = int(min(int64(len(chunk)), bp.spillW-bp.spillR))
+			bp.mu.Unlock()
+			if _, err := spill.ReadAt(chunk[:n], offset); err != nil {
+				bp.mu.Lock()
+				bp.failLocked(fmt.Errorf("upload buffer spill failed: %w", err))
+				bp.mu.Unlock()
+				return
+			}
+			bp.mu.Lock()
+			if bp.err != nil {
+				// Aborted while reading; the spill file is gone
+				bp.mu.Unlock()
+				return
+			}
+			bp.spillR += int64(n)
+			if bp.spillR == bp.spillW {
+				// Caught up: reuse the file from the start
+				bp.spillR, bp.spillW = 0, 0
+				bp.spill.Truncate(0)
+			}
+			bp.mu.Unlock()
+		default:
+			// Closed and fully drained
+			bp.closeSpillLocked()
+			bp.mu.Unlock()
+			bp.w.Close()
+			if bp.onDrained != nil {
+				bp.onDrained()
+			}
+			return
+		}
+
+		written, err := bp.w.Write(chunk[:n])
+		bp.mu.Lock()
+		bp.written += int64(written)
+		if err != nil {
+			// The upstream stopped reading (failed or finished early)
+			bp.failLocked(err)
+			bp.mu.Unlock()
+			return
+		}
+		bp.mu.Unlock()
+	}
+}
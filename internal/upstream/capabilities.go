@@ -0,0 +1,210 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/pkg/blossomclient"
+)
+
+// endpointSupport is the configured support of an optional endpoint (list/delete)
+type endpointSupport int
+
+const (
+	endpointAuto        endpointSupport = iota // Not configured: assume supported until the server shows otherwise
+	endpointSupported                          // Explicitly enabled
+	endpointUnsupported                        // Explicitly disabled, or auto-detected as missing
+)
+
+// endpointSupportFromConfig converts a capability flag from the config (nil = auto-detect)
+func endpointSupportFromConfig(flag *bool) endpointSupport {
+	if flag == nil {
+		return endpointAuto
+	}
+	if *flag {
+		return endpointSupported
+	}
+	return endpointUnsupported
+}
+
+// SupportsList reports whether list requests should be sent to the server
+func (m *Manager) SupportsList(serverURL string) bool {
+	return m.endpointSupported(serverURL, "list")
+}
+
+// SupportsDelete reports whether delete requests should be sent to the server
+func (m *Manager) SupportsDelete(serverURL string) bool {
+	return m.endpointSupported(serverURL, "delete")
+}
+
+func (m *Manager) endpointSupported(serverURL string, op string) bool {
+	m.capabilityMu.RLock()
+	defer m.capabilityMu.RUnlock()
+	set := m.servers.Load()
+	for i, url := range set.serverURLs {
+		if url == serverURL {
+			if op == "list" {
+				return set.serverCapabilities[i].List != endpointUnsupported
+			}
+			return set.serverCapabilities[i].Delete != endpointUnsupported
+		}
+	}
+	return false
+}
+
+// DetectUnsupported checks whether err shows that the server lacks the endpoint for op
+// ("list" or "delete"), i.e. it answered 405 Method Not Allowed or 501 Not Implemented
+// (or 404 for list, which per BUD-02 returns an empty array for unknown pubkeys).
+// If the server's capability is auto-detected, it is marked unsupported so it won't be
+// queried again. Returns true if the error means "endpoint missing" rather than a failure
+func (m *Manager) DetectUnsupported(serverURL string, op string, err error) bool {
+	var httpErr *blossomclient.HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	switch httpErr.StatusCode {
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+	case http.StatusNotFound:
+		if op != "list" {
+			return false
+		}
+	default:
+		return false
+	}
+
+	m.capabilityMu.Lock()
+	defer m.capabilityMu.Unlock()
+	set := m.servers.Load()
+	for i, url := range set.serverURLs {
+		if url != serverURL {
+			continue
+		}
+		capability := &set.serverCapabilities[i].Delete
+		if op == "list" {
+			capability = &set.serverCapabilities[i].List
+		}
+		switch *capability {
+		case endpointSupported:
+			// Explicitly configured as supported: keep counting it as a failure
+			return false
+		case endpointAuto:
+			*capability = endpointUnsupported
+			logging.Warnf(context.Background(), "DetectUnsupported: %s answered %d to %s, no longer sending %s requests to it", serverURL, httpErr.StatusCode, op, op)
+		}
+		return true
+	}
+	return false
+}
+
+// SupportsUploadHead reports whether the server supports BUD-06 HEAD /upload preflight checks
+func (m *Manager) SupportsUploadHead(serverURL string) bool {
+	set := m.servers.Load()
+	for i, url := range set.serverURLs {
+		if url == serverURL {
+			return set.serverCapabilities[i].SupportsUploadHead
+		}
+	}
+	return false
+}
+
+// Capability states in the capability matrix
+const (
+	CapabilitySupported   = "supported"
+	CapabilityUnsupported = "unsupported"
+	CapabilityUnknown     = "unknown" // Not configured and not (yet) detected; list/delete are still used
+)
+
+// ServerCapabilities is one row of the capability matrix: what a server is known to support
+// Mirror, upload_head and media come from the configuration, list and delete are
+// auto-detected unless configured, range is observed from the server's HEAD responses
+type ServerCapabilities struct {
+	Server     string `json:"server"`
+	Mirror     string `json:"mirror"`
+	UploadHead string `json:"upload_head"`
+	Media      string `json:"media"`
+	List       string `json:"list"`
+	Delete     string `json:"delete"`
+	Range      string `json:"range"`
+}
+
+// capabilityState converts an endpointSupport to a capability matrix state
+func capabilityState(support endpointSupport) string {
+	switch support {
+	case endpointSupported:
+		return CapabilitySupported
+	case endpointUnsupported:
+		return CapabilityUnsupported
+	}
+	return CapabilityUnknown
+}
+
+// boolCapabilityState converts a configured boolean capability to a capability matrix state
+func boolCapabilityState(supported bool) string {
+	if supported {
+		return CapabilitySupported
+	}
+	return CapabilityUnsupported
+}
+
+// CapabilityMatrix returns the currently known capabilities of every upstream server,
+// in configuration order
+func (m *Manager) CapabilityMatrix() []ServerCapabilities {
+	m.capabilityMu.RLock()
+	defer m.capabilityMu.RUnlock()
+	set := m.servers.Load()
+
+	matrix := make([]ServerCapabilities, 0, len(set.serverURLs))
+	for i, url := range set.serverURLs {
+		cap := set.serverCapabilities[i]
+		matrix = append(matrix, ServerCapabilities{
+			Server:     url,
+			Mirror:     boolCapabilityState(cap.SupportsMirror),
+			UploadHead: boolCapabilityState(cap.SupportsUploadHead),
+			Media:      capabilityState(cap.Media),
+			List:       capabilityState(cap.List),
+			Delete:     capabilityState(cap.Delete),
+			Range:      capabilityState(cap.Ranges),
+		})
+	}
+	return matrix
+}
+
+// observeRangeSupport records whether a server advertised byte-range support in a HEAD
+// response for a blob it has; the latest observation wins
+func (m *Manager) observeRangeSupport(serverURL string, h http.Header) {
+	support := endpointUnsupported
+	if strings.Contains(strings.ToLower(h.Get("Accept-Ranges")), "bytes") {
+		support = endpointSupported
+	}
+
+	m.capabilityMu.Lock()
+	defer m.capabilityMu.Unlock()
+	set := m.servers.Load()
+	for i, url := range set.serverURLs {
+		if url == serverURL {
+			if m.verbose.Enabled() && set.serverCapabilities[i].Ranges != support {
+				m.verbose.Debugf(context.Background(), "observeRangeSupport: %s range support is now %s", serverURL, capabilityState(support))
+			}
+			set.serverCapabilities[i].Ranges = support
+			return
+		}
+	}
+}
+
+// observedRangeSupport returns the server's last observed byte-range support
+// Returns ok=false if the server hasn't been observed yet
+func (m *Manager) observedRangeSupport(serverURL string) (supported bool, ok bool) {
+	m.capabilityMu.RLock()
+	defer m.capabilityMu.RUnlock()
+	set := m.servers.Load()
+	for i, url := range set.serverURLs {
+		if url == serverURL {
+			ranges := set.serverCapabilities[i].Ranges
+			return ranges == endpointSupported, ranges != endpointAuto
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,46 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/girino/blossom_espelhator/internal/chaos"
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// EnableChaos turns on fault injection for servers that have a chaos section, both the
+// configured ones and those added later with AddServer
+// Only called when the proxy is explicitly started with chaos testing enabled, before it
+// serves requests. Returns the number of servers with faults injected so far
+func (m *Manager) EnableChaos() int {
+	m.serversMu.Lock()
+	defer m.serversMu.Unlock()
+
+	m.chaosEnabled = true
+	enabled := 0
+	for _, entry := range m.entries {
+		if m.injectChaosLocked(entry) {
+			enabled++
+		}
+	}
+	return enabled
+}
+
+// injectChaosLocked wraps the client of an entry with the faults of its chaos section, if
+// it has one and chaos testing is enabled. The client must not be in use yet
+// (must be called with serversMu held)
+func (m *Manager) injectChaosLocked(entry *upstreamEntry) bool {
+	if entry.config.Chaos == nil || !m.chaosEnabled {
+		return false
+	}
+
+	chaosCfg := *entry.config.Chaos
+	serverURL := entry.config.URL
+	entry.client.WrapTransport(func(base http.RoundTripper) http.RoundTripper {
+		return chaos.Wrap(base, serverURL, chaosCfg, m.verbose)
+	})
+
+	logging.Warnf(context.Background(), "Chaos testing enabled for %s: latency=%v (+%v jitter), failure_rate=%.2f, failure_status=%d, operations=%v",
+		serverURL, chaosCfg.Latency, chaosCfg.LatencyJitter, chaosCfg.FailureRate, chaosCfg.FailureStatus, chaosCfg.Operations)
+	return true
+}
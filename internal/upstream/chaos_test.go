@@ -0,0 +1,68 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/testutil"
+)
+
+// statusFrom downloads a blob from serverURL through the manager's client for it
+func statusFrom(t *testing.T, m *Manager, serverURL string) int {
+	t.Helper()
+	set := m.servers.Load()
+	i := set.index(serverURL)
+	if i < 0 {
+		t.Fatalf("%s is not in rotation", serverURL)
+	}
+	resp, err := set.clients[i].Get(context.Background(), strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatalf("download from %s: %v", serverURL, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAddServerInjectsChaos(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+		want    int
+	}{
+		{"enabled", true, http.StatusTeapot},
+		{"disabled", false, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newTestManager(t, "  min_upload_servers: 1\n", testutil.AnsweringUpstream(t).URL)
+			if tc.enabled {
+				m.EnableChaos()
+			}
+
+			added := testutil.AnsweringUpstream(t)
+			err := m.AddServer(config.UpstreamServer{
+				URL:   added.URL,
+				Chaos: &config.ChaosConfig{FailureRate: 1, FailureStatus: http.StatusTeapot},
+			})
+			if err != nil {
+				t.Fatalf("AddServer: %v", err)
+			}
+			if status := statusFrom(t, m, added.URL); status != tc.want {
+				t.Errorf("status = %d, want %d", status, tc.want)
+			}
+		})
+	}
+}
+
+func TestAddServerRejectsInvalidChaos(t *testing.T) {
+	m := newTestManager(t, "  min_upload_servers: 1\n", testutil.AnsweringUpstream(t).URL)
+	err := m.AddServer(config.UpstreamServer{
+		URL:   "http://127.0.0.1:1",
+		Chaos: &config.ChaosConfig{FailureRate: 2},
+	})
+	if err == nil {
+		t.Fatal("AddServer accepted a failure_rate of 2")
+	}
+}
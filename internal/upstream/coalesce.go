@@ -0,0 +1,61 @@
+package upstream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/recovery"
+)
+
+// inflightCheck is a shared CheckPathOnServers call that concurrent callers wait on
+type inflightCheck struct {
+	done   chan struct{}
+	result CheckPathOnServersResult
+}
+
+// checkCoalescer deduplicates concurrent lookups for the same path
+type checkCoalescer struct {
+	mu       sync.Mutex
+	inflight map[string]*inflightCheck
+}
+
+// CheckPathOnServersCoalesced behaves like CheckPathOnServers, but concurrent calls for the
+// same path share a single upstream fan-out instead of each issuing a HEAD to every server
+// The shared lookup is detached from the first caller's cancellation so a client disconnect
+// does not fail the lookup for the other waiting clients; each caller still stops waiting
+// when its own context is done
+func (m *Manager) CheckPathOnServersCoalesced(ctx context.Context, path string, timeout time.Duration) CheckPathOnServersResult {
+	key := path
+
+	m.coalescer.mu.Lock()
+	if m.coalescer.inflight == nil {
+		m.coalescer.inflight = make(map[string]*inflightCheck)
+	}
+	call, exists := m.coalescer.inflight[key]
+	if !exists {
+		call = &inflightCheck{done: make(chan struct{})}
+		m.coalescer.inflight[key] = call
+		go func() {
+			// Always release the waiters, even if the lookup panics (they then see "not found")
+			defer func() {
+				m.coalescer.mu.Lock()
+				delete(m.coalescer.inflight, key)
+				m.coalescer.mu.Unlock()
+				close(call.done)
+			}()
+			defer recovery.Recover("CheckPathOnServersCoalesced")
+			call.result = m.CheckPathOnServers(context.WithoutCancel(ctx), path, timeout)
+		}()
+	} else if m.verbose.Enabled() {
+		m.verbose.Debugf(ctx, "CheckPathOnServersCoalesced: joining in-flight lookup for %s", key)
+	}
+	m.coalescer.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.result
+	case <-ctx.Done():
+		return CheckPathOnServersResult{}
+	}
+}
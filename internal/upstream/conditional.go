@@ -0,0 +1,69 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ExistingBlob checks with HEAD whether serverURL already stores the blob with the given hash
+// If it does, a blob descriptor built from the HEAD response is returned, so a mirror to
+// that server can be skipped and counted as a success
+// A mismatching ETag (servers commonly use the hash as ETag) is not trusted
+func (m *Manager) ExistingBlob(ctx context.Context, serverURL string, hash string) ([]byte, bool) {
+	if !isHexHash(hash) {
+		return nil, false
+	}
+	c, err := m.GetClient(serverURL)
+	if err != nil {
+		return nil, false
+	}
+
+	resp, err := c.Head(ctx, hash)
+	if err != nil {
+		m.verbose.Debugf(ctx, "ExistingBlob: HEAD %s on %s failed: %v", hash, serverURL, err)
+		return nil, false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		etag = strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+		if !strings.EqualFold(etag, hash) && isHexHash(etag) {
+			m.verbose.Debugf(ctx, "ExistingBlob: %s has %s but its ETag is %s, not skipping", serverURL, hash, etag)
+			return nil, false
+		}
+	}
+
+	descriptor := map[string]interface{}{
+		"url":    strings.TrimSuffix(serverURL, "/") + "/" + hash,
+		"sha256": hash,
+	}
+	if resp.ContentLength >= 0 {
+		descriptor["size"] = resp.ContentLength
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		descriptor["type"] = contentType
+	}
+	body, err := json.Marshal(descriptor)
+	if err != nil {
+		return nil, false
+	}
+	return body, true
+}
+
+// isHexHash reports whether s looks like a SHA-256 hex digest
+func isHexHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')) {
+			return false
+		}
+	}
+	return true
+}
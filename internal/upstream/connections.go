@@ -0,0 +1,40 @@
+package upstream
+
+import "github.com/girino/blossom_espelhator/pkg/blossomclient"
+
+// ConnectionStats returns per-server connection statistics (new vs reused connections and
+// negotiated protocols), keyed by server URL
+func (m *Manager) ConnectionStats() map[string]blossomclient.ConnStats {
+	set := m.servers.Load()
+	stats := make(map[string]blossomclient.ConnStats, len(set.clients))
+	for i, c := range set.clients {
+		stats[set.serverURLs[i]] = c.ConnStats()
+	}
+	return stats
+}
+
+// BudgetStatus returns the request budget state of every server, keyed by server URL
+// (servers without ceilings are left out)
+func (m *Manager) BudgetStatus() map[string]*blossomclient.BudgetStatus {
+	set := m.servers.Load()
+	status := make(map[string]*blossomclient.BudgetStatus, len(set.clients))
+	for i, c := range set.clients {
+		if budget := c.BudgetStatus(); budget != nil {
+			status[set.serverURLs[i]] = budget
+		}
+	}
+	return status
+}
+
+// BreakerStatus returns the circuit breaker state of every server, keyed by server URL
+// (empty if the breaker is disabled)
+func (m *Manager) BreakerStatus() map[string]*blossomclient.BreakerStatus {
+	set := m.servers.Load()
+	status := make(map[string]*blossomclient.BreakerStatus, len(set.clients))
+	for i, c := range set.clients {
+		if breaker := c.BreakerStatus(); breaker != nil {
+			status[set.serverURLs[i]] = breaker
+		}
+	}
+	return status
+}
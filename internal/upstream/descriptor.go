@@ -0,0 +1,91 @@
+package upstream
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// descriptorInt extracts a non-negative integer field from a blob descriptor
+// Upstreams disagree on encoding, so JSON numbers and numeric strings are both accepted
+func descriptorInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case float64:
+		if v >= 0 {
+			return int64(v), true
+		}
+	case int64:
+		if v >= 0 {
+			return v, true
+		}
+	case int:
+		if v >= 0 {
+			return int64(v), true
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil && n >= 0 {
+			return n, true
+		}
+	case string:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// NormalizeDescriptor makes sure a merged blob descriptor (BUD-02) has size, type and uploaded
+// populated, filling gaps from the descriptors other upstreams returned for the same blob
+// The selected descriptor's values win when present; uploaded uses the earliest timestamp any
+// server reports. Numeric fields are normalized to integers
+func NormalizeDescriptor(descriptor map[string]interface{}, alternatives []map[string]interface{}) {
+	all := append([]map[string]interface{}{descriptor}, alternatives...)
+
+	// size: first valid value, selected descriptor first
+	for _, d := range all {
+		if size, ok := descriptorInt(d["size"]); ok {
+			descriptor["size"] = size
+			break
+		}
+	}
+
+	// type: first non-empty value, selected descriptor first
+	for _, d := range all {
+		if typeVal, ok := d["type"].(string); ok && typeVal != "" {
+			descriptor["type"] = typeVal
+			break
+		}
+	}
+
+	// uploaded: earliest timestamp reported by any server
+	var uploaded int64
+	for _, d := range all {
+		if ts, ok := descriptorInt(d["uploaded"]); ok && ts > 0 && (uploaded == 0 || ts < uploaded) {
+			uploaded = ts
+		}
+	}
+	if uploaded > 0 {
+		descriptor["uploaded"] = uploaded
+	}
+}
+
+// AddSizeTag appends a NIP-94 ["size", "<bytes>"] tag if the descriptor has a size and
+// the tags don't already include one
+func AddSizeTag(tags []interface{}, descriptor map[string]interface{}) []interface{} {
+	size, ok := descriptorInt(descriptor["size"])
+	if !ok {
+		return tags
+	}
+	for _, tag := range tags {
+		if tagArray, ok := tag.([]interface{}); ok && len(tagArray) > 0 {
+			if typeVal, ok := tagArray[0].(string); ok && typeVal == "size" {
+				return tags
+			}
+		}
+	}
+	return append(tags, []interface{}{"size", strconv.FormatInt(size, 10)})
+}
+
+// DescriptorSize returns the size reported in a blob descriptor, if any
+func DescriptorSize(descriptor map[string]interface{}) (int64, bool) {
+	return descriptorInt(descriptor["size"])
+}
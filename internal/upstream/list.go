@@ -0,0 +1,89 @@
+package upstream
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/girino/blossom_espelhator/pkg/blossomclient"
+)
+
+// ListError is returned by ListParallel when fewer list-capable servers answered than
+// min_list_servers, so an empty result can't be told apart from "all upstreams down"
+// Results holds the per-server outcomes, including the failures
+type ListError struct {
+	Succeeded int
+	Required  int
+	Results   []ListResult
+}
+
+func (e *ListError) Error() string {
+	return fmt.Sprintf("only %d servers answered the list request, need at least %d", e.Succeeded, e.Required)
+}
+
+// NewPartialListError returns a ListError for a list query that not every list-capable
+// server answered (used when partial results are not acceptable)
+func NewPartialListError(results []ListResult) *ListError {
+	queryable := countListQueryable(results)
+	return &ListError{
+		Succeeded: queryable - len(ListFailures(results)),
+		Required:  queryable,
+		Results:   results,
+	}
+}
+
+// ListFailure summarizes a server that failed a list query
+type ListFailure struct {
+	ServerURL  string `json:"server"`
+	StatusCode int    `json:"status,omitempty"` // HTTP status from the server (0 if no response was received)
+	Error      string `json:"error"`
+	Err        error  `json:"-"` // Original error (Error is its message)
+}
+
+// ListFailures returns a summary of the servers that failed a list query
+// Servers that don't implement list are not failures and are left out
+func ListFailures(results []ListResult) []ListFailure {
+	failures := make([]ListFailure, 0)
+	for _, result := range results {
+		if result.Error == nil || result.Unsupported {
+			continue
+		}
+		status, _ := blossomclient.ExtractStatusCode(result.Error)
+		failures = append(failures, ListFailure{
+			ServerURL:  result.ServerURL,
+			StatusCode: status,
+			Error:      result.Error.Error(),
+			Err:        result.Error,
+		})
+	}
+	return failures
+}
+
+// FormatListFailures formats failures for a single response header, e.g.
+// "https://a.example.com=HTTP 500, https://b.example.com=timeout"
+func FormatListFailures(failures []ListFailure) string {
+	parts := make([]string, 0, len(failures))
+	for _, failure := range failures {
+		reason := "error"
+		switch {
+		case failure.StatusCode != 0:
+			reason = fmt.Sprintf("HTTP %d", failure.StatusCode)
+		case strings.Contains(failure.Error, "deadline exceeded"):
+			reason = "timeout"
+		case strings.Contains(failure.Error, "parse JSON"):
+			reason = "invalid response"
+		}
+		parts = append(parts, failure.ServerURL+"="+reason)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// countListQueryable returns the number of servers in a list query that can list at all
+func countListQueryable(results []ListResult) int {
+	n := 0
+	for _, result := range results {
+		if !result.Unsupported {
+			n++
+		}
+	}
+	return n
+}
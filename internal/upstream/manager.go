@@ -4,92 +4,65 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math/rand"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/girino/blossom_espelhator/internal/client"
 	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/recovery"
+	"github.com/girino/blossom_espelhator/pkg/blossomclient"
 )
 
-// errorTolerantWriter wraps a pipe writer and continues writing even if errors occur
-// It tracks errors separately so we can detect which pipes failed
-type errorTolerantWriter struct {
-	w      *io.PipeWriter
-	err    error
-	mu     sync.Mutex
-	name   string // for debugging
-	closed bool
-}
-
-func (ew *errorTolerantWriter) Write(p []byte) (int, error) {
-	ew.mu.Lock()
-	defer ew.mu.Unlock()
-
-	if ew.err != nil || ew.closed {
-		// Already errored or closed, skip writes but return success to keep MultiWriter happy
-		// This ensures io.Copy continues even if one pipe fails
-		return len(p), nil
-	}
-
-	// Attempt to write to the pipe
-	n, err := ew.w.Write(p)
-	if err != nil {
-		// If write fails (e.g., pipe closed), mark as errored and close
-		ew.err = err
-		ew.closed = true
-		// Close the writer to signal EOF to the reader, but don't propagate the error
-		// to io.MultiWriter so it continues writing to other pipes
-		if ew.w != nil {
-			ew.w.CloseWithError(err)
-		}
-		// Return success with bytes written (even if it was partial) to keep MultiWriter happy
-		// This allows io.Copy to continue reading and writing to other pipes
-		return len(p), nil
-	}
-	// Success - return number of bytes written and no error
-	return n, nil
-}
-
-func (ew *errorTolerantWriter) GetError() error {
-	ew.mu.Lock()
-	defer ew.mu.Unlock()
-	return ew.err
-}
-
-func (ew *errorTolerantWriter) Close() error {
-	ew.mu.Lock()
-	defer ew.mu.Unlock()
-	if !ew.closed && ew.w != nil {
-		ew.closed = true
-		return ew.w.Close()
-	}
-	return nil
-}
-
 // Manager manages upstream Blossom servers
 type Manager struct {
-	clients            []*client.Client // HTTP clients with no timeout (timeouts controlled via context)
-	serverURLs         []string
-	serverPriorities   []int                // Priority for each server (indexed same as clients/serverURLs)
-	serverCapabilities []serverCapabilities // Capabilities for each server (indexed same as clients/serverURLs)
-	minUploadServers   int
-	redirectStrategy   string
-	roundRobinIndex    int
-	roundRobinMutex    sync.Mutex
-	verbose            bool
-	getTotalFailures   func(string) int64 // Function to get total failures for a server (for health_based strategy)
+	servers           atomic.Pointer[upstreamSet] // Servers in rotation, replaced as a whole when servers are added, removed, paused or resumed
+	serversMu         sync.Mutex                  // Serializes changes to entries
+	entries           []*upstreamEntry            // Every server including paused ones, in configuration order (protected by serversMu)
+	clientSettings    config.ServerConfig         // Connection settings for the clients of servers added at runtime
+	chaosEnabled      bool                        // Inject the faults of chaos sections (protected by serversMu, see EnableChaos)
+	priorityRotation  priorityRotation            // Rotation state for servers sharing a priority
+	weightedRotation  priorityRotation            // Rotation state of the weighted strategy
+	capabilityMu      sync.RWMutex                // Protects list/delete/range capabilities, which are detected at runtime
+	shards            []config.ShardConfig        // Hash-prefix shards (empty means full replication)
+	contentRoutes     []config.ContentRouteConfig // Upload routing by content type (empty means no routing)
+	sizeRoutes        []config.SizeRouteConfig    // Upload routing and quorum by blob size (empty means no routing)
+	minUploadServers  int
+	minListServers    int // Minimum list-capable servers that must answer a list query
+	replicationFactor int
+	redirectStrategy  string
+	roundRobinIndex   int
+	roundRobinMutex   sync.Mutex
+	verbose           *logging.Flag
+	clientVerbose     *logging.Flag                                                              // Debug logging of the blossomclient of each server
+	getFailures       func(serverURL string, opType string) int64                                // Function to get failures of an operation type for a server (for health_based strategy)
+	recordThroughput  func(serverURL string, opType string, bytes int64, duration time.Duration) // Receives throughput samples (optional)
+	getThroughput     func(serverURL string, opType string) float64                              // Rolling throughput of a server (for throughput_aware strategy)
+	recordLatency     func(serverURL string, opType string, duration time.Duration)              // Receives latency samples of successful requests (optional)
+	recordTransfer    func(serverURL string, bytes int64)                                        // Receives upload bytes delivered to each server (optional)
+	coalescer         checkCoalescer                                                             // Deduplicates concurrent lookups for the same path
+	settling          settlingTracker                                                            // Recent uploads whose 404s are not trusted yet
+	acceptedPoll      acceptedPolling                                                            // Follow-up checks for servers that replied 202 Accepted
+	conditionalMirror bool                                                                       // HEAD before mirroring and skip servers that already have the blob
+	pipelines         pipelineTracker                                                            // Live streaming upload pipelines and their watchdog
+	fanoutJitter      time.Duration                                                              // Maximum random start delay of each fan-out request (0 = none)
+	serverObserver    func(serverURL string, added bool)                                         // Told about servers added or removed at runtime (optional)
 }
 
 // serverCapabilities stores which endpoints a server supports
 type serverCapabilities struct {
 	SupportsMirror     bool
 	SupportsUploadHead bool
+	List               endpointSupport // GET /list/<pubkey> (may change at runtime when auto-detected)
+	Delete             endpointSupport // DELETE /<sha256> (may change at runtime when auto-detected)
+	Media              endpointSupport // BUD-05 /media (configured only, never probed)
+	Ranges             endpointSupport // Byte ranges, from the latest HEAD lookup (endpointAuto = not observed yet)
 }
 
 // UploadResult represents the result of an upload to a single server
@@ -97,102 +70,149 @@ type UploadResult struct {
 	ServerURL    string
 	Success      bool
 	Error        error
-	StatusCode   int    // HTTP status code if error occurred (0 if success)
-	ResponseBody []byte // Response body from upstream server (if success)
+	StatusCode   int           // HTTP status code if error occurred (0 if success)
+	ResponseBody []byte        // Response body from upstream server (if success)
+	Accepted     bool          // Server replied 202 Accepted (blob queued, may not be readable yet)
+	Skipped      bool          // Server already had the blob, nothing was transferred
+	Duration     time.Duration // How long the request to the server took
 }
 
 // UploadError represents an upload error with HTTP status code
 type UploadError struct {
 	StatusCode int
 	Message    string
+	Summary    string // Message without per-server details, safe to show to clients (empty if Message is)
 }
 
 func (e *UploadError) Error() string {
 	return e.Message
 }
 
-// New creates a new upstream manager
-func New(cfg *config.Config, verbose bool) (*Manager, error) {
+// PublicMessage returns the error message without per-server details
+func (e *UploadError) PublicMessage() string {
+	if e.Summary != "" {
+		return e.Summary
+	}
+	return e.Message
+}
+
+// QuorumError is returned when fewer servers than required succeeded and none of them
+// reported an HTTP status to pass through
+type QuorumError struct {
+	Summary string   // e.g., "only 1 servers succeeded, need at least 2"
+	Details []string // Per-server errors ("<server>: <error>")
+}
+
+func (e *QuorumError) Error() string {
+	if len(e.Details) == 0 {
+		return e.Summary
+	}
+	return e.Summary + fmt.Sprintf(". Errors: %v", e.Details)
+}
+
+// ErrBodyAborted is returned (wrapped) when the request body could not be read to the end,
+// typically because the client disconnected mid-upload. No server is counted as successful
+// in that case, since upstreams only received a partial blob
+var ErrBodyAborted = errors.New("upload body aborted")
+
+// New creates a new upstream manager, logging with the upstream and client debug flags
+func New(cfg *config.Config, debug *logging.Levels) (*Manager, error) {
 	if len(cfg.UpstreamServers) == 0 {
 		return nil, fmt.Errorf("no upstream servers configured")
 	}
 
-	clients := make([]*client.Client, 0, len(cfg.UpstreamServers))
-	serverURLs := make([]string, 0, len(cfg.UpstreamServers))
-	serverPriorities := make([]int, 0, len(cfg.UpstreamServers))
-	capabilities := make([]serverCapabilities, 0, len(cfg.UpstreamServers))
-
+	verbose := debug.Flag(logging.Upstream)
+	clientVerbose := debug.Flag(logging.Client)
+	entries := make([]*upstreamEntry, 0, len(cfg.UpstreamServers))
 	for _, server := range cfg.UpstreamServers {
-		// Create clients with no timeout - timeouts are controlled via context in each request
-		// This allows connection reuse and better performance
-		// Use alternative_address for connections if provided, otherwise use the official URL
-		cl := client.New(server.URL, server.AlternativeAddress, 0, verbose)
-		clients = append(clients, cl)
-
-		serverURLs = append(serverURLs, server.URL)
-		serverPriorities = append(serverPriorities, server.Priority)
-
-		// Store capabilities (pointers default to nil if not set, but we set defaults in config.Load())
-		cap := serverCapabilities{
-			SupportsMirror:     server.SupportsMirror != nil && *server.SupportsMirror,
-			SupportsUploadHead: server.SupportsUploadHead != nil && *server.SupportsUploadHead,
+		entry, err := newUpstreamEntry(server, cfg.Server, clientVerbose)
+		if err != nil {
+			return nil, err
 		}
-		capabilities = append(capabilities, cap)
+		entries = append(entries, entry)
 	}
 
-	if verbose {
-		log.Printf("[DEBUG] Upstream manager initialized with %d servers, min_upload_servers=%d, strategy=%s",
-			len(serverURLs), cfg.Server.MinUploadServers, cfg.Server.RedirectStrategy)
-		for i, url := range serverURLs {
-			altAddr := cfg.UpstreamServers[i].AlternativeAddress
+	if verbose.Enabled() {
+		verbose.Debugf(context.Background(), "Upstream manager initialized with %d servers, min_upload_servers=%d, strategy=%s",
+			len(entries), cfg.Server.MinUploadServers, cfg.Server.RedirectStrategy)
+		for i, entry := range entries {
+			altAddr := entry.config.AlternativeAddress
 			if altAddr != "" {
-				log.Printf("[DEBUG]   Upstream server %d: %s (connect via %s, priority=%d, mirror=%t, upload_head=%t)",
-					i+1, url, altAddr, serverPriorities[i], capabilities[i].SupportsMirror, capabilities[i].SupportsUploadHead)
+				verbose.Debugf(context.Background(), "  Upstream server %d: %s (connect via %s, priority=%d, mirror=%t, upload_head=%t)",
+					i+1, entry.config.URL, altAddr, entry.config.Priority, entry.capabilities.SupportsMirror, entry.capabilities.SupportsUploadHead)
 			} else {
-				log.Printf("[DEBUG]   Upstream server %d: %s (priority=%d, mirror=%t, upload_head=%t)",
-					i+1, url, serverPriorities[i], capabilities[i].SupportsMirror, capabilities[i].SupportsUploadHead)
+				verbose.Debugf(context.Background(), "  Upstream server %d: %s (priority=%d, mirror=%t, upload_head=%t)",
+					i+1, entry.config.URL, entry.config.Priority, entry.capabilities.SupportsMirror, entry.capabilities.SupportsUploadHead)
 			}
 		}
 	}
 
-	return &Manager{
-		clients:            clients,
-		serverURLs:         serverURLs,
-		serverPriorities:   serverPriorities,
-		serverCapabilities: capabilities,
-		minUploadServers:   cfg.Server.MinUploadServers,
-		redirectStrategy:   cfg.Server.RedirectStrategy,
-		verbose:            verbose,
-		getTotalFailures:   nil, // Will be set via SetFailureGetter if needed
-	}, nil
+	settlingPeriod := cfg.Server.SettlingPeriod
+	if cfg.Server.DisableSettling {
+		settlingPeriod = 0
+	}
+
+	m := &Manager{
+		entries:           entries,
+		clientSettings:    cfg.Server,
+		shards:            cfg.Server.Shards,
+		contentRoutes:     cfg.Server.ContentRoutes,
+		sizeRoutes:        cfg.Server.SizeRoutes,
+		minUploadServers:  cfg.Server.MinUploadServers,
+		minListServers:    cfg.Server.MinListServers,
+		replicationFactor: cfg.Server.ReplicationFactor,
+		redirectStrategy:  cfg.Server.RedirectStrategy,
+		verbose:           verbose,
+		clientVerbose:     clientVerbose,
+		getFailures:       nil, // Will be set via SetFailureGetter if needed
+		conditionalMirror: !cfg.Server.DisableConditionalMirror,
+		fanoutJitter:      cfg.Server.FanoutJitter,
+		pipelines: newPipelineTracker(cfg.Server.UploadResponseTimeout, cfg.Server.UploadBufferBytes,
+			cfg.Server.UploadBufferDir, cfg.Server.DisableUploadBufferSpill, cfg.Server.UploadBufferMaxSpillBytes,
+			cfg.Server.SlowUpstreamDetachBytes, cfg.Server.SlowUpstreamDetachAfter),
+		acceptedPoll: acceptedPolling{
+			interval: cfg.Server.AcceptedPollInterval,
+			timeout:  cfg.Server.AcceptedPollTimeout,
+		},
+		settling: settlingTracker{
+			period:     settlingPeriod,
+			retries:    cfg.Server.SettlingRetries,
+			retryDelay: cfg.Server.SettlingRetryDelay,
+		},
+	}
+	m.rebuildLocked()
+	return m, nil
 }
 
-// SetFailureGetter sets the function to get total failures for health_based strategy
-func (m *Manager) SetFailureGetter(getter func(string) int64) {
-	m.getTotalFailures = getter
+// SetFailureGetter sets the function to get per-operation failures for health_based strategy
+// Upload response selection ranks servers by upload failures; redirects rank them by download failures
+func (m *Manager) SetFailureGetter(getter func(serverURL string, opType string) int64) {
+	m.getFailures = getter
 }
 
 // UploadResultWithResponse contains a successful server URL and its response body
 type UploadResultWithResponse struct {
 	ServerURL    string
 	ResponseBody []byte
+	Accepted     bool          // Server replied 202 Accepted and is processing the blob asynchronously
+	Skipped      bool          // Server already had the blob, nothing was transferred
+	Duration     time.Duration // How long the upload (or mirror) to the server took
 }
 
 // UploadParallel uploads a blob to multiple upstream servers in parallel
 // timeout specifies the timeout for the upload context (typically calculated from expiration timestamp)
 // Returns the list of successful servers with their response bodies and an error if fewer than minUploadServers succeeded
 func (m *Manager) UploadParallel(ctx context.Context, body io.Reader, contentType string, headers map[string]string, timeout time.Duration) ([]UploadResultWithResponse, error) {
-	if m.verbose {
-		log.Printf("[DEBUG] UploadParallel: starting parallel upload to %d servers", len(m.clients))
-		log.Printf("[DEBUG] UploadParallel: content-type=%s, headers=%v, timeout=%v", contentType, headers, timeout)
-	}
+	set := m.servers.Load()
+	m.verbose.Debugf(ctx, "UploadParallel: starting parallel upload to %d servers", len(set.clients))
+	m.verbose.Debugf(ctx, "UploadParallel: content-type=%s, headers=%v, timeout=%v", contentType, headers, timeout)
 
 	// Create a context with upload timeout (calculated from expiration timestamp if available)
 	uploadCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	// Channel to collect results
-	resultChan := make(chan UploadResult, len(m.clients))
+	resultChan := make(chan UploadResult, len(set.clients))
 
 	// Read body into memory so we can reuse it for multiple uploads
 	bodyBytes, err := io.ReadAll(body)
@@ -200,31 +220,39 @@ func (m *Manager) UploadParallel(ctx context.Context, body io.Reader, contentTyp
 		return nil, fmt.Errorf("failed to read request body: %w", err)
 	}
 
-	if m.verbose {
-		log.Printf("[DEBUG] UploadParallel: read %d bytes from request body", len(bodyBytes))
-	}
+	m.verbose.Debugf(ctx, "UploadParallel: read %d bytes from request body", len(bodyBytes))
 
 	// Launch parallel uploads
 	var wg sync.WaitGroup
-	for i, cl := range m.clients {
+	for i, cl := range set.clients {
 		wg.Add(1)
-		go func(idx int, c *client.Client, url string) {
+		go func(idx int, c *blossomclient.Client, url string) {
 			defer wg.Done()
+			defer func() {
+				// A panic counts as a failed upload to this server
+				if v := recover(); v != nil {
+					resultChan <- UploadResult{ServerURL: url, Error: recovery.Error("UploadParallel", v)}
+				}
+			}()
 
-			if m.verbose {
-				log.Printf("[DEBUG] UploadParallel: starting upload to server %d: %s", idx+1, url)
-			}
+			m.verbose.Debugf(ctx, "UploadParallel: starting upload to server %d: %s", idx+1, url)
+			m.staggerStart(uploadCtx)
 
 			// Create a new reader for each upload
 			reader := bytes.NewReader(bodyBytes)
 
 			uploadStart := time.Now()
-			responseBody, err := c.Upload(uploadCtx, reader, contentType, int64(len(bodyBytes)), headers)
+			responseBody, uploadStatus, err := c.UploadWithStatus(uploadCtx, reader, contentType, int64(len(bodyBytes)), headers)
 			uploadDuration := time.Since(uploadStart)
+			m.observeRequest(ctx, url, "upload", uploadDuration, err)
+			if err == nil {
+				m.observeThroughput(url, "upload", int64(len(bodyBytes)), uploadDuration)
+				m.observeTransfer(url, int64(len(bodyBytes)))
+			}
 
 			statusCode := 0
 			if err != nil {
-				if httpErr, ok := err.(*client.HTTPError); ok {
+				if httpErr, ok := err.(*blossomclient.HTTPError); ok {
 					statusCode = httpErr.StatusCode
 				}
 			}
@@ -235,18 +263,20 @@ func (m *Manager) UploadParallel(ctx context.Context, body io.Reader, contentTyp
 				Error:        err,
 				StatusCode:   statusCode,
 				ResponseBody: responseBody,
+				Accepted:     err == nil && uploadStatus == http.StatusAccepted,
+				Duration:     uploadDuration,
 			}
 
-			if m.verbose {
+			if m.verbose.Enabled() {
 				if err == nil {
-					log.Printf("[DEBUG] UploadParallel: server %d (%s) succeeded in %v", idx+1, url, uploadDuration)
+					m.verbose.Debugf(ctx, "UploadParallel: server %d (%s) succeeded in %v", idx+1, url, uploadDuration)
 				} else {
-					log.Printf("[DEBUG] UploadParallel: server %d (%s) failed in %v: %v", idx+1, url, uploadDuration, err)
+					m.verbose.Debugf(ctx, "UploadParallel: server %d (%s) failed in %v: %v", idx+1, url, uploadDuration, err)
 				}
 			}
 
 			resultChan <- result
-		}(i, cl, m.serverURLs[i])
+		}(i, cl, set.serverURLs[i])
 	}
 
 	// Wait for all uploads to complete
@@ -263,6 +293,8 @@ func (m *Manager) UploadParallel(ctx context.Context, body io.Reader, contentTyp
 			successfulServers = append(successfulServers, UploadResultWithResponse{
 				ServerURL:    result.ServerURL,
 				ResponseBody: result.ResponseBody,
+				Accepted:     result.Accepted,
+				Duration:     result.Duration,
 			})
 		} else if result.Error != nil {
 			errorDetails = append(errorDetails, fmt.Sprintf("%s: %v", result.ServerURL, result.Error))
@@ -275,18 +307,19 @@ func (m *Manager) UploadParallel(ctx context.Context, body io.Reader, contentTyp
 	}
 
 	// Check if we have enough successful uploads
-	if m.verbose {
-		log.Printf("[DEBUG] UploadParallel: completed - %d succeeded, %d failed", len(successfulServers), len(errorDetails))
+	if m.verbose.Enabled() {
+		m.verbose.Debugf(ctx, "UploadParallel: completed - %d succeeded, %d failed", len(successfulServers), len(errorDetails))
 		if len(successfulServers) > 0 {
-			log.Printf("[DEBUG] UploadParallel: successful servers: %v", successfulServers)
+			m.verbose.Debugf(ctx, "UploadParallel: successful servers: %v", successfulServers)
 		}
 		if len(errorDetails) > 0 {
-			log.Printf("[DEBUG] UploadParallel: failed servers: %v", errorDetails)
+			m.verbose.Debugf(ctx, "UploadParallel: failed servers: %v", errorDetails)
 		}
 	}
 
 	if len(successfulServers) < m.minUploadServers {
-		errMsg := fmt.Sprintf("only %d servers succeeded, need at least %d", len(successfulServers), m.minUploadServers)
+		summary := fmt.Sprintf("only %d servers succeeded, need at least %d", len(successfulServers), m.minUploadServers)
+		errMsg := summary
 		if len(errorDetails) > 0 {
 			errMsg += fmt.Sprintf(". Errors: %v", errorDetails)
 		}
@@ -301,26 +334,55 @@ func (m *Manager) UploadParallel(ctx context.Context, body io.Reader, contentTyp
 				}
 			}
 
-			if m.verbose {
-				log.Printf("[DEBUG] UploadParallel: using lowest upstream status code %d (from %v)", minStatusCode, allStatusCodes)
-			}
+			m.verbose.Debugf(ctx, "UploadParallel: using lowest upstream status code %d (from %v)", minStatusCode, allStatusCodes)
 			return successfulServers, &UploadError{
 				StatusCode: minStatusCode,
 				Message:    errMsg,
+				Summary:    summary,
 			}
 		}
 
 		// No status codes available - return 500
-		return successfulServers, fmt.Errorf("%s", errMsg)
+		return successfulServers, &QuorumError{Summary: summary, Details: errorDetails}
 	}
 
-	if m.verbose {
-		log.Printf("[DEBUG] UploadParallel: upload successful, minimum requirement met (%d >= %d)", len(successfulServers), m.minUploadServers)
-	}
+	m.verbose.Debugf(ctx, "UploadParallel: upload successful, minimum requirement met (%d >= %d)", len(successfulServers), m.minUploadServers)
 
 	return successfulServers, nil
 }
 
+// UploadTargets selects which upstream servers an upload is fanned out to
+// and how many of them must succeed
+type UploadTargets struct {
+	ServerURLs []string // Servers to upload to (empty means all servers)
+	MinServers int      // Minimum number of successful uploads (0 means min_upload_servers)
+}
+
+// resolveTargets returns the client indices in set and the success quorum for the given targets
+func (m *Manager) resolveTargets(set *upstreamSet, targets UploadTargets) ([]int, int) {
+	indices := make([]int, 0, len(set.clients))
+	if len(targets.ServerURLs) == 0 {
+		for i := range set.clients {
+			indices = append(indices, i)
+		}
+	} else {
+		for _, target := range targets.ServerURLs {
+			for i, url := range set.serverURLs {
+				if url == target {
+					indices = append(indices, i)
+					break
+				}
+			}
+		}
+	}
+
+	minServers := targets.MinServers
+	if minServers <= 0 {
+		minServers = m.minUploadServers
+	}
+	return indices, minServers
+}
+
 // UploadParallelStreaming streams a blob to multiple upstream servers in parallel
 // Unlike UploadParallel, this method streams the body directly without buffering it first
 // This allows uploads to start immediately, preventing auth header expiration on large files
@@ -328,47 +390,144 @@ func (m *Manager) UploadParallel(ctx context.Context, body io.Reader, contentTyp
 // timeout specifies the timeout for the upload context (typically calculated from expiration timestamp)
 // Returns the list of successful servers with their response bodies and an error if fewer than minUploadServers succeeded
 func (m *Manager) UploadParallelStreaming(ctx context.Context, body io.Reader, contentType string, contentLength int64, headers map[string]string, timeout time.Duration) ([]UploadResultWithResponse, error) {
-	if m.verbose {
-		log.Printf("[DEBUG] UploadParallelStreaming: starting streaming parallel upload to %d servers", len(m.clients))
-		log.Printf("[DEBUG] UploadParallelStreaming: content-type=%s, headers=%v, timeout=%v", contentType, headers, timeout)
+	return m.UploadParallelStreamingTo(ctx, UploadTargets{}, body, contentType, contentLength, headers, timeout)
+}
+
+// UploadParallelStreamingTo streams a blob to the given subset of upstream servers in parallel
+// Returns the list of successful servers with their response bodies and an error if fewer than
+// targets.MinServers succeeded
+func (m *Manager) UploadParallelStreamingTo(ctx context.Context, targets UploadTargets, body io.Reader, contentType string, contentLength int64, headers map[string]string, timeout time.Duration) ([]UploadResultWithResponse, error) {
+	set := m.servers.Load()
+	indices, minUploadServers := m.resolveTargets(set, targets)
+	if len(indices) == 0 {
+		return nil, fmt.Errorf("no upstream servers selected for upload")
 	}
+	indices = m.skipInMaintenance(ctx, set, indices, minUploadServers)
+
+	m.verbose.Debugf(ctx, "UploadParallelStreaming: starting streaming parallel upload to %d/%d servers (min=%d)", len(indices), len(set.clients), minUploadServers)
+	m.verbose.Debugf(ctx, "UploadParallelStreaming: content-type=%s, headers=%v, timeout=%v", contentType, headers, timeout)
 
 	// Create a context with upload timeout (calculated from expiration timestamp if available)
 	uploadCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	// Create pipes for each upstream server
-	type pipeData struct {
-		reader *io.PipeReader
-		writer *io.PipeWriter
-	}
-	pipes := make([]pipeData, len(m.clients))
-	for i := range pipes {
-		pipes[i].reader, pipes[i].writer = io.Pipe()
-	}
+	m.pipelines.started.Add(1)
+	m.pipelines.active.Add(1)
+	defer m.pipelines.active.Add(-1)
+
+	// Create a pipe per upstream server, each fed from its own buffer so a slow server
+	// doesn't hold back the others (see bufferedPipe)
+	pipeReaders := make([]*io.PipeReader, len(indices))
+	buffers := make([]*bufferedPipe, len(indices))
 
 	// Channel to collect results
-	resultChan := make(chan UploadResult, len(m.clients))
+	resultChan := make(chan UploadResult, len(indices))
+
+	// Each upload gets its own context so the watchdog can cancel the ones still hanging
+	// after their body was written without touching those that already answered
+	workerCancels := make([]context.CancelCauseFunc, len(indices))
+	finished := make([]atomic.Bool, len(indices))
+
+	// Watchdog: once a server's pipe has delivered the whole body, it gets
+	// upload_response_timeout to answer; an upload still running after that is cancelled
+	// instead of lingering until the (much longer) upload timeout
+	var watchdogMu sync.Mutex
+	var watchdogs []*time.Timer
+	defer func() {
+		watchdogMu.Lock()
+		defer watchdogMu.Unlock()
+		for _, t := range watchdogs {
+			t.Stop()
+		}
+	}()
+	armWatchdog := func(i int) {
+		if m.pipelines.responseTimeout <= 0 {
+			return
+		}
+		watchdogMu.Lock()
+		defer watchdogMu.Unlock()
+		watchdogs = append(watchdogs, time.AfterFunc(m.pipelines.responseTimeout, func() {
+			if !finished[i].Load() {
+				m.pipelines.watchdogAborts.Add(1)
+				logging.Warnf(ctx, "UploadParallelStreaming: %s did not answer within %v after the body was sent, cancelling",
+					set.serverURLs[indices[i]], m.pipelines.responseTimeout)
+				workerCancels[i](ErrUploadResponseTimeout)
+			}
+		}))
+	}
+
+	// When the first server completed its upload (unix nanoseconds, 0 = not yet)
+	var firstSuccess atomic.Int64
+	succeeded := make([]atomic.Bool, len(indices))
+
+	for i, serverIdx := range indices {
+		var pipeWriter *io.PipeWriter
+		pipeReaders[i], pipeWriter = io.Pipe()
+		onDrained := func() { armWatchdog(i) }
+		if contentLength < 0 && set.clients[serverIdx].BuffersUnknownLength() {
+			// This server's request only starts once the whole body was spooled, so the
+			// watchdog would time the transfer too; the upload timeout bounds it instead
+			onDrained = nil
+		}
+		buffers[i] = newBufferedPipe(pipeWriter, set.serverURLs[serverIdx], m.pipelines.bufferBytes, m.pipelines.spillDir, m.pipelines.maxSpillBytes, m.verbose,
+			onDrained)
+		go func(bp *bufferedPipe) {
+			defer m.pipelines.goroutine()()
+			bp.drain()
+		}(buffers[i])
+	}
+
+	// Teardown: whatever happens, no drain goroutine or spill file outlives the upload
+	defer func() {
+		var spilled int64
+		for _, bp := range buffers {
+			bp.Abort(errPipelineClosed)
+			spilled += bp.Spilled()
+		}
+		m.pipelines.spilledBytes.Add(spilled)
+	}()
 
 	// Launch parallel uploads - each one reads from its pipe
 	var wg sync.WaitGroup
-	for i, cl := range m.clients {
+	for i, serverIdx := range indices {
+		cl := set.clients[serverIdx]
+		workerCtx, cancelWorker := context.WithCancelCause(uploadCtx)
+		workerCancels[i] = cancelWorker
 		wg.Add(1)
-		go func(idx int, c *client.Client, url string, pipeReader *io.PipeReader) {
+		go func(idx int, c *blossomclient.Client, url string, pipeReader *io.PipeReader) {
+			defer m.pipelines.goroutine()()
 			defer wg.Done()
+			defer cancelWorker(nil)
 			defer pipeReader.Close()
+			defer func() {
+				// A panic counts as a failed upload to this server
+				if v := recover(); v != nil {
+					pipeReader.CloseWithError(errors.New("upload aborted"))
+					resultChan <- UploadResult{ServerURL: url, Error: recovery.Error("UploadParallelStreaming", v)}
+				}
+			}()
 
-			if m.verbose {
-				log.Printf("[DEBUG] UploadParallelStreaming: starting upload to server %d: %s", idx+1, url)
-			}
+			m.verbose.Debugf(ctx, "UploadParallelStreaming: starting upload to server %d: %s", idx+1, url)
+			m.staggerStart(workerCtx)
 
 			uploadStart := time.Now()
-			responseBody, err := c.Upload(uploadCtx, pipeReader, contentType, contentLength, headers)
+			responseBody, uploadStatus, err := c.UploadWithStatus(workerCtx, pipeReader, contentType, contentLength, headers)
 			uploadDuration := time.Since(uploadStart)
+			m.observeRequest(ctx, url, "upload", uploadDuration, err)
+			if err == nil {
+				succeeded[idx].Store(true)
+				firstSuccess.CompareAndSwap(0, time.Now().UnixNano())
+				m.observeThroughput(url, "upload", buffers[idx].Delivered(), uploadDuration)
+			}
+			m.observeTransfer(url, buffers[idx].Delivered())
+			finished[idx].Store(true)
+			if cause := context.Cause(workerCtx); err != nil && (errors.Is(cause, ErrUploadResponseTimeout) || errors.Is(cause, ErrSlowUpstream)) {
+				err = cause
+			}
 
 			statusCode := 0
 			if err != nil {
-				if httpErr, ok := err.(*client.HTTPError); ok {
+				if httpErr, ok := err.(*blossomclient.HTTPError); ok {
 					statusCode = httpErr.StatusCode
 				}
 			}
@@ -379,81 +538,101 @@ func (m *Manager) UploadParallelStreaming(ctx context.Context, body io.Reader, c
 				Error:        err,
 				StatusCode:   statusCode,
 				ResponseBody: responseBody,
+				Accepted:     err == nil && uploadStatus == http.StatusAccepted,
+				Duration:     uploadDuration,
 			}
 
-			if m.verbose {
+			if m.verbose.Enabled() {
 				if err == nil {
-					log.Printf("[DEBUG] UploadParallelStreaming: server %d (%s) succeeded in %v", idx+1, url, uploadDuration)
+					m.verbose.Debugf(ctx, "UploadParallelStreaming: server %d (%s) succeeded in %v", idx+1, url, uploadDuration)
 				} else {
-					log.Printf("[DEBUG] UploadParallelStreaming: server %d (%s) failed in %v: %v", idx+1, url, uploadDuration, err)
+					m.verbose.Debugf(ctx, "UploadParallelStreaming: server %d (%s) failed in %v: %v", idx+1, url, uploadDuration, err)
 				}
 			}
 
 			resultChan <- result
-		}(i, cl, m.serverURLs[i], pipes[i].reader)
+		}(i, cl, set.serverURLs[serverIdx], pipeReaders[i])
+	}
+
+	// Detach servers that fall too far behind the others: they count as failed (and are
+	// retried in the background through the journal, if enabled) instead of dictating
+	// how long the upload takes
+	monitorDone := make(chan struct{})
+	defer close(monitorDone)
+	if m.pipelines.detachBytes > 0 || m.pipelines.detachAfter > 0 {
+		go func() {
+			defer m.pipelines.goroutine()()
+			ticker := time.NewTicker(detachCheckInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-monitorDone:
+					return
+				case <-ticker.C:
+				}
+				var successAt time.Time
+				if ns := firstSuccess.Load(); ns != 0 {
+					successAt = time.Unix(0, ns)
+				}
+				for _, i := range m.pipelines.lagging(buffers, finished, succeeded, successAt, minUploadServers) {
+					m.pipelines.detached.Add(1)
+					logging.Warnf(ctx, "UploadParallelStreaming: detaching slow server %s (%d bytes sent, fastest server: %d)",
+						set.serverURLs[indices[i]], buffers[i].Delivered(), maxDelivered(buffers))
+					workerCancels[i](ErrSlowUpstream)
+					buffers[i].Abort(ErrSlowUpstream)
+				}
+			}
+		}()
 	}
 
-	// Stream data from body to all pipes using MultiWriter with error-tolerant writers
-	// This allows writing to continue even if one pipe fails
+	// Stream data from body to every server's buffer; writes to a failed server's buffer
+	// are dropped, so the others keep receiving data
 	streamErr := make(chan error, 1)
-	errorTolerantWriters := make([]*errorTolerantWriter, len(pipes))
 	go func() {
+		defer m.pipelines.goroutine()()
 		defer func() {
-			// Note: Individual writers are closed by errorTolerantWriter.Close()
-			// Only close pipes that weren't handled by errorTolerantWriter
-			for i, p := range pipes {
-				if p.writer != nil && (errorTolerantWriters[i] == nil || errorTolerantWriters[i].GetError() == nil) {
-					// Close any pipes not already closed by errorTolerantWriter
-					if err := p.writer.Close(); err != nil {
-						if m.verbose {
-							log.Printf("[DEBUG] UploadParallelStreaming: error closing pipe writer %d: %v", i+1, err)
-						}
-					}
+			// Without this the caller would wait on streamErr forever; fail every pipe
+			// (never a clean EOF) and report the panic as a streaming error
+			if v := recover(); v != nil {
+				err := recovery.Error("UploadParallelStreaming", v)
+				for _, bp := range buffers {
+					bp.Abort(err)
 				}
+				cancel()
+				streamErr <- err
 			}
 		}()
 
-		// Create error-tolerant writers for each pipe
-		writers := make([]io.Writer, 0, len(pipes))
-		for i, p := range pipes {
-			if p.writer != nil {
-				etw := &errorTolerantWriter{
-					w:    p.writer,
-					name: fmt.Sprintf("pipe-%d", i+1),
-				}
-				errorTolerantWriters[i] = etw
-				writers = append(writers, etw)
-			}
+		writers := make([]io.Writer, len(buffers))
+		for i, bp := range buffers {
+			writers[i] = bp
 		}
 		multiWriter := io.MultiWriter(writers...)
 
-		// Copy from body to all pipes simultaneously
-		// Even if one pipe fails, we continue writing to others
+		// Copy from body to all buffers simultaneously
 		// IMPORTANT: io.Copy must read ALL data from body to ensure complete hash calculation
 		// The body is a teeReader that writes to hashWriter as it reads from r.Body
 		copied, err := io.Copy(multiWriter, body)
-		if m.verbose {
-			log.Printf("[DEBUG] UploadParallelStreaming: copied %d bytes from body to pipes (hash should be complete after this)", copied)
-		}
+		m.verbose.Debugf(ctx, "UploadParallelStreaming: copied %d bytes from body to pipes (hash should be complete after this)", copied)
 
-		// Close all writers after copying (even if some had errors)
-		for i, etw := range errorTolerantWriters {
-			if etw != nil {
-				pipeErr := etw.GetError()
-				if pipeErr != nil {
-					if m.verbose {
-						log.Printf("[DEBUG] UploadParallelStreaming: pipe writer %d (%s) had error during streaming: %v", i+1, etw.name, pipeErr)
-					}
-				} else {
-					// Only close if no error occurred (Close() will handle closed state)
-					etw.Close()
-				}
+		if err != nil {
+			// The body ended early (e.g., client disconnected). Fail every pipe with the error
+			// instead of closing it, so upstreams never see a clean EOF on a truncated blob
+			// (which a chunked upload would accept), and cancel the in-flight requests
+			for _, bp := range buffers {
+				bp.Abort(err)
 			}
+			cancel()
+			streamErr <- fmt.Errorf("%w: %w", ErrBodyAborted, err)
+			return
 		}
 
-		if err != nil {
-			streamErr <- fmt.Errorf("failed to stream body to pipes: %w", err)
-			return
+		// Close all buffers; each pipe is closed once its server has received everything
+		for i, bp := range buffers {
+			if pipeErr := bp.GetError(); pipeErr != nil && m.verbose.Enabled() {
+				m.verbose.Debugf(ctx, "UploadParallelStreaming: pipe %d (%s) had error during streaming: %v", i+1, bp.name, pipeErr)
+			}
+			bp.Close()
 		}
 
 		streamErr <- nil
@@ -463,17 +642,26 @@ func (m *Manager) UploadParallelStreaming(ctx context.Context, body io.Reader, c
 	wg.Wait()
 	close(resultChan)
 
-	// Check for streaming errors
+	// Wait for streaming to finish so the body has been fully consumed (or has failed)
+	// before results are trusted and the caller reads the hash
+	// If the client stopped sending, don't wait past the upload timeout: the copier stays
+	// blocked on the body read until the request body is closed after the handler returns
+	var err error
 	select {
-	case err := <-streamErr:
-		if err != nil {
-			if m.verbose {
-				log.Printf("[DEBUG] UploadParallelStreaming: streaming error: %v", err)
-			}
-			// Continue to process results even if streaming had errors
+	case err = <-streamErr:
+	case <-uploadCtx.Done():
+		select {
+		case err = <-streamErr:
+		default:
+			m.pipelines.bodyStalls.Add(1)
+			logging.Warnf(ctx, "UploadParallelStreaming: request body stalled, giving up after %v", timeout)
+			err = fmt.Errorf("%w: body not received within %v", ErrBodyAborted, timeout)
 		}
-	default:
-		// No error
+	}
+	if err != nil {
+		m.verbose.Debugf(ctx, "UploadParallelStreaming: streaming error, discarding all results: %v", err)
+		// Partial uploads must not count as successes
+		return nil, err
 	}
 
 	// Collect successful uploads and errors
@@ -486,6 +674,8 @@ func (m *Manager) UploadParallelStreaming(ctx context.Context, body io.Reader, c
 			successfulServers = append(successfulServers, UploadResultWithResponse{
 				ServerURL:    result.ServerURL,
 				ResponseBody: result.ResponseBody,
+				Accepted:     result.Accepted,
+				Duration:     result.Duration,
 			})
 		} else if result.Error != nil {
 			errorDetails = append(errorDetails, fmt.Sprintf("%s: %v", result.ServerURL, result.Error))
@@ -498,18 +688,19 @@ func (m *Manager) UploadParallelStreaming(ctx context.Context, body io.Reader, c
 	}
 
 	// Check if we have enough successful uploads
-	if m.verbose {
-		log.Printf("[DEBUG] UploadParallelStreaming: completed - %d succeeded, %d failed", len(successfulServers), len(errorDetails))
+	if m.verbose.Enabled() {
+		m.verbose.Debugf(ctx, "UploadParallelStreaming: completed - %d succeeded, %d failed", len(successfulServers), len(errorDetails))
 		if len(successfulServers) > 0 {
-			log.Printf("[DEBUG] UploadParallelStreaming: successful servers: %v", successfulServers)
+			m.verbose.Debugf(ctx, "UploadParallelStreaming: successful servers: %v", successfulServers)
 		}
 		if len(errorDetails) > 0 {
-			log.Printf("[DEBUG] UploadParallelStreaming: failed servers: %v", errorDetails)
+			m.verbose.Debugf(ctx, "UploadParallelStreaming: failed servers: %v", errorDetails)
 		}
 	}
 
-	if len(successfulServers) < m.minUploadServers {
-		errMsg := fmt.Sprintf("only %d servers succeeded, need at least %d", len(successfulServers), m.minUploadServers)
+	if len(successfulServers) < minUploadServers {
+		summary := fmt.Sprintf("only %d servers succeeded, need at least %d", len(successfulServers), minUploadServers)
+		errMsg := summary
 		if len(errorDetails) > 0 {
 			errMsg += fmt.Sprintf(". Errors: %v", errorDetails)
 		}
@@ -524,22 +715,19 @@ func (m *Manager) UploadParallelStreaming(ctx context.Context, body io.Reader, c
 				}
 			}
 
-			if m.verbose {
-				log.Printf("[DEBUG] UploadParallelStreaming: using lowest upstream status code %d (from %v)", minStatusCode, allStatusCodes)
-			}
+			m.verbose.Debugf(ctx, "UploadParallelStreaming: using lowest upstream status code %d (from %v)", minStatusCode, allStatusCodes)
 			return successfulServers, &UploadError{
 				StatusCode: minStatusCode,
 				Message:    errMsg,
+				Summary:    summary,
 			}
 		}
 
 		// No status codes available - return 500
-		return successfulServers, fmt.Errorf("%s", errMsg)
+		return successfulServers, &QuorumError{Summary: summary, Details: errorDetails}
 	}
 
-	if m.verbose {
-		log.Printf("[DEBUG] UploadParallelStreaming: upload successful, minimum requirement met (%d >= %d)", len(successfulServers), m.minUploadServers)
-	}
+	m.verbose.Debugf(ctx, "UploadParallelStreaming: upload successful, minimum requirement met (%d >= %d)", len(successfulServers), minUploadServers)
 
 	return successfulServers, nil
 }
@@ -549,10 +737,20 @@ func (m *Manager) UploadParallelStreaming(ctx context.Context, body io.Reader, c
 // timeout specifies the timeout for the mirror context
 // Returns the list of successful servers with their response bodies and an error if fewer than minUploadServers succeeded
 func (m *Manager) MirrorParallel(ctx context.Context, body io.Reader, contentType string, headers map[string]string, timeout time.Duration) ([]UploadResultWithResponse, error) {
+	return m.MirrorParallelTo(ctx, UploadTargets{}, "", body, contentType, headers, timeout)
+}
+
+// MirrorParallelTo sends mirror requests to the mirror-capable servers within the given targets (BUD-04)
+// If hash is known, servers that already have the blob are detected with HEAD and skipped
+// (counted as successful) unless conditional mirroring is disabled
+func (m *Manager) MirrorParallelTo(ctx context.Context, targets UploadTargets, hash string, body io.Reader, contentType string, headers map[string]string, timeout time.Duration) ([]UploadResultWithResponse, error) {
+	set := m.servers.Load()
+	targetIndices, minUploadServers := m.resolveTargets(set, targets)
+
 	// Filter servers by mirror capability
 	mirrorCapableIndices := make([]int, 0)
-	for i, cap := range m.serverCapabilities {
-		if cap.SupportsMirror {
+	for _, i := range targetIndices {
+		if set.serverCapabilities[i].SupportsMirror {
 			mirrorCapableIndices = append(mirrorCapableIndices, i)
 		}
 	}
@@ -560,12 +758,11 @@ func (m *Manager) MirrorParallel(ctx context.Context, body io.Reader, contentTyp
 	if len(mirrorCapableIndices) == 0 {
 		return nil, fmt.Errorf("no upstream servers support mirror endpoint")
 	}
+	mirrorCapableIndices = m.skipInMaintenance(ctx, set, mirrorCapableIndices, minUploadServers)
 
-	if m.verbose {
-		log.Printf("[DEBUG] MirrorParallel: starting parallel mirror requests to %d/%d servers (filtered by capability)",
-			len(mirrorCapableIndices), len(m.clients))
-		log.Printf("[DEBUG] MirrorParallel: content-type=%s, headers=%v, timeout=%v", contentType, headers, timeout)
-	}
+	m.verbose.Debugf(ctx, "MirrorParallel: starting parallel mirror requests to %d/%d servers (filtered by capability)",
+		len(mirrorCapableIndices), len(set.clients))
+	m.verbose.Debugf(ctx, "MirrorParallel: content-type=%s, headers=%v, timeout=%v", contentType, headers, timeout)
 
 	// Channel to collect results
 	resultChan := make(chan UploadResult, len(mirrorCapableIndices))
@@ -577,9 +774,7 @@ func (m *Manager) MirrorParallel(ctx context.Context, body io.Reader, contentTyp
 		return nil, fmt.Errorf("failed to read request body: %w", err)
 	}
 
-	if m.verbose {
-		log.Printf("[DEBUG] MirrorParallel: read %d bytes from request body", len(bodyBytes))
-	}
+	m.verbose.Debugf(ctx, "MirrorParallel: read %d bytes from request body", len(bodyBytes))
 
 	// Create a context with timeout AFTER reading the body
 	// This ensures the timeout only applies to the actual HTTP requests, not body reading
@@ -590,25 +785,54 @@ func (m *Manager) MirrorParallel(ctx context.Context, body io.Reader, contentTyp
 	var wg sync.WaitGroup
 	for _, idx := range mirrorCapableIndices {
 		wg.Add(1)
-		cl := m.clients[idx]
-		url := m.serverURLs[idx]
-		go func(serverIdx int, c *client.Client, serverURL string) {
+		cl := set.clients[idx]
+		url := set.serverURLs[idx]
+		go func(serverIdx int, c *blossomclient.Client, serverURL string) {
 			defer wg.Done()
-
-			if m.verbose {
-				log.Printf("[DEBUG] MirrorParallel: starting mirror request to server: %s", serverURL)
+			defer func() {
+				// A panic counts as a failed mirror to this server
+				if v := recover(); v != nil {
+					resultChan <- UploadResult{ServerURL: serverURL, Error: recovery.Error("MirrorParallel", v)}
+				}
+			}()
+
+			m.verbose.Debugf(ctx, "MirrorParallel: starting mirror request to server: %s", serverURL)
+			m.staggerStart(mirrorCtx)
+
+			// Skip the transfer if the server already has the blob
+			if hash != "" && m.conditionalMirror {
+				if descriptor, exists := m.ExistingBlob(mirrorCtx, serverURL, hash); exists {
+					m.verbose.Debugf(ctx, "MirrorParallel: server %s already has %s, skipping transfer", serverURL, hash)
+					resultChan <- UploadResult{
+						ServerURL:    url,
+						Success:      true,
+						ResponseBody: descriptor,
+						Skipped:      true,
+					}
+					return
+				}
 			}
 
 			// Create a new reader for each mirror request
 			reader := bytes.NewReader(bodyBytes)
 
 			mirrorStart := time.Now()
-			responseBody, err := c.Mirror(mirrorCtx, reader, contentType, headers)
+			responseBody, uploadStatus, err := c.MirrorWithStatus(mirrorCtx, reader, contentType, headers)
 			mirrorDuration := time.Since(mirrorStart)
+			m.observeRequest(ctx, url, "mirror", mirrorDuration, err)
+			if err == nil {
+				// The server fetched the blob itself; its size comes from the descriptor
+				var descriptor map[string]interface{}
+				if json.Unmarshal(responseBody, &descriptor) == nil {
+					if size, ok := DescriptorSize(descriptor); ok {
+						m.observeThroughput(serverURL, "mirror", size, mirrorDuration)
+					}
+				}
+			}
 
 			statusCode := 0
 			if err != nil {
-				if httpErr, ok := err.(*client.HTTPError); ok {
+				if httpErr, ok := err.(*blossomclient.HTTPError); ok {
 					statusCode = httpErr.StatusCode
 				}
 			}
@@ -619,13 +843,15 @@ func (m *Manager) MirrorParallel(ctx context.Context, body io.Reader, contentTyp
 				Error:        err,
 				StatusCode:   statusCode,
 				ResponseBody: responseBody,
+				Accepted:     err == nil && uploadStatus == http.StatusAccepted,
+				Duration:     mirrorDuration,
 			}
 
-			if m.verbose {
+			if m.verbose.Enabled() {
 				if err == nil {
-					log.Printf("[DEBUG] MirrorParallel: server %s succeeded in %v", serverURL, mirrorDuration)
+					m.verbose.Debugf(ctx, "MirrorParallel: server %s succeeded in %v", serverURL, mirrorDuration)
 				} else {
-					log.Printf("[DEBUG] MirrorParallel: server %s failed in %v: %v", serverURL, mirrorDuration, err)
+					m.verbose.Debugf(ctx, "MirrorParallel: server %s failed in %v: %v", serverURL, mirrorDuration, err)
 				}
 			}
 
@@ -647,6 +873,9 @@ func (m *Manager) MirrorParallel(ctx context.Context, body io.Reader, contentTyp
 			successfulServers = append(successfulServers, UploadResultWithResponse{
 				ServerURL:    result.ServerURL,
 				ResponseBody: result.ResponseBody,
+				Accepted:     result.Accepted,
+				Duration:     result.Duration,
+				Skipped:      result.Skipped,
 			})
 		} else {
 			errorDetails = append(errorDetails, fmt.Sprintf("%s: %v", result.ServerURL, result.Error))
@@ -656,25 +885,26 @@ func (m *Manager) MirrorParallel(ctx context.Context, body io.Reader, contentTyp
 		}
 	}
 
-	if m.verbose {
+	if m.verbose.Enabled() {
 		attemptedCount := len(mirrorCapableIndices)
-		log.Printf("[DEBUG] MirrorParallel: successful servers: %d/%d (attempted %d out of %d total)",
-			len(successfulServers), attemptedCount, attemptedCount, len(m.clients))
+		m.verbose.Debugf(ctx, "MirrorParallel: successful servers: %d/%d (attempted %d out of %d total)",
+			len(successfulServers), attemptedCount, attemptedCount, len(set.clients))
 		if len(errorDetails) > 0 {
-			log.Printf("[DEBUG] MirrorParallel: failed servers: %v", errorDetails)
+			m.verbose.Debugf(ctx, "MirrorParallel: failed servers: %v", errorDetails)
 		}
 		if len(successfulServers) > 0 {
 			serverURLs := make([]string, 0, len(successfulServers))
 			for _, srv := range successfulServers {
 				serverURLs = append(serverURLs, srv.ServerURL)
 			}
-			log.Printf("[DEBUG] MirrorParallel: succeeded on servers: %v", serverURLs)
+			m.verbose.Debugf(ctx, "MirrorParallel: succeeded on servers: %v", serverURLs)
 		}
 	}
 
 	// Check if we have enough successful servers
-	if len(successfulServers) < m.minUploadServers {
-		errMsg := fmt.Sprintf("only %d servers succeeded, need at least %d", len(successfulServers), m.minUploadServers)
+	if len(successfulServers) < minUploadServers {
+		summary := fmt.Sprintf("only %d servers succeeded, need at least %d", len(successfulServers), minUploadServers)
+		errMsg := summary
 		if len(errorDetails) > 0 {
 			errMsg += fmt.Sprintf(". Errors: %v", errorDetails)
 		}
@@ -688,17 +918,16 @@ func (m *Manager) MirrorParallel(ctx context.Context, body io.Reader, contentTyp
 				}
 			}
 
-			if m.verbose {
-				log.Printf("[DEBUG] MirrorParallel: using lowest upstream status code %d (from %v)", minStatusCode, allStatusCodes)
-			}
+			m.verbose.Debugf(ctx, "MirrorParallel: using lowest upstream status code %d (from %v)", minStatusCode, allStatusCodes)
 			return successfulServers, &UploadError{
 				StatusCode: minStatusCode,
 				Message:    errMsg,
+				Summary:    summary,
 			}
 		}
 
 		// No status codes available - return 500
-		return successfulServers, fmt.Errorf("%s", errMsg)
+		return successfulServers, &QuorumError{Summary: summary, Details: errorDetails}
 	}
 
 	return successfulServers, nil
@@ -710,6 +939,9 @@ func (m *Manager) SelectServer(availableServers []UploadResultWithResponse) (*Up
 		return nil, fmt.Errorf("no available servers")
 	}
 
+	// Deprioritize servers in a maintenance window (only if others are available)
+	availableServers = m.preferOutsideMaintenanceWithResponse(availableServers)
+
 	var selected *UploadResultWithResponse
 	switch m.redirectStrategy {
 	case "round_robin":
@@ -718,16 +950,18 @@ func (m *Manager) SelectServer(availableServers []UploadResultWithResponse) (*Up
 		selected = m.selectRandomWithResponse(availableServers)
 	case "priority":
 		selected = m.selectPriorityWithResponse(availableServers)
+	case "weighted":
+		selected = m.selectWeightedWithResponse(availableServers)
 	case "health_based":
 		selected = m.selectHealthBasedWithResponse(availableServers)
+	case "throughput_aware":
+		selected = m.selectThroughputAwareWithResponse(availableServers)
 	default:
 		// Default to round-robin
 		selected = m.selectRoundRobinWithResponse(availableServers)
 	}
 
-	if m.verbose {
-		log.Printf("[DEBUG] SelectServer: strategy=%s, available=%d servers, selected=%s", m.redirectStrategy, len(availableServers), selected.ServerURL)
-	}
+	m.verbose.Debugf(context.Background(), "SelectServer: strategy=%s, available=%d servers, selected=%s", m.redirectStrategy, len(availableServers), selected.ServerURL)
 
 	return selected, nil
 }
@@ -748,14 +982,14 @@ func (m *Manager) selectRandomWithResponse(availableServers []UploadResultWithRe
 	return selected
 }
 
-// selectHealthBasedWithResponse selects servers with the lowest total failures, then uses round-robin within that group
+// selectHealthBasedWithResponse selects servers with the lowest upload failures, then uses round-robin within that group
 func (m *Manager) selectHealthBasedWithResponse(availableServers []UploadResultWithResponse) *UploadResultWithResponse {
 	if len(availableServers) == 0 {
 		return nil
 	}
 
 	// If no failure getter is set, fall back to round-robin
-	if m.getTotalFailures == nil {
+	if m.getFailures == nil {
 		return m.selectRoundRobinWithResponse(availableServers)
 	}
 
@@ -765,7 +999,7 @@ func (m *Manager) selectHealthBasedWithResponse(availableServers []UploadResultW
 
 	for i := range availableServers {
 		serverURL := availableServers[i].ServerURL
-		totalFailures := m.getTotalFailures(serverURL)
+		totalFailures := m.getFailures(serverURL, "upload")
 		serversByFailures[totalFailures] = append(serversByFailures[totalFailures], &availableServers[i])
 
 		// Track minimum failures
@@ -781,12 +1015,12 @@ func (m *Manager) selectHealthBasedWithResponse(availableServers []UploadResultW
 		return &availableServers[0]
 	}
 
-	if m.verbose {
+	if m.verbose.Enabled() {
 		serverURLs := make([]string, len(bestServers))
 		for i, srv := range bestServers {
 			serverURLs[i] = srv.ServerURL
 		}
-		log.Printf("[DEBUG] selectHealthBasedWithResponse: %d servers with minimum failures (%d): %v", len(bestServers), minFailures, serverURLs)
+		m.verbose.Debugf(context.Background(), "selectHealthBasedWithResponse: %d servers with minimum failures (%d): %v", len(bestServers), minFailures, serverURLs)
 	}
 
 	// Use round-robin within the best servers group
@@ -798,37 +1032,23 @@ func (m *Manager) selectHealthBasedWithResponse(availableServers []UploadResultW
 	return m.selectRoundRobinWithResponse(bestServersSlice)
 }
 
-// selectPriorityWithResponse selects the server with the lowest priority number (lower is better)
-// If multiple servers have the same lowest priority, returns the first one found
+// selectPriorityWithResponse selects among the servers with the lowest priority number (lower is better)
+// Servers sharing that priority are rotated by weight
 func (m *Manager) selectPriorityWithResponse(availableServers []UploadResultWithResponse) *UploadResultWithResponse {
 	if len(availableServers) == 0 {
 		return nil
 	}
 
-	// Find the priority for each available server
-	var bestServer *UploadResultWithResponse
-	bestPriority := int(^uint(0) >> 1) // Max int value
-
-	for i := range availableServers {
-		serverURL := availableServers[i].ServerURL
-		// Find the priority for this URL
-		for j, url := range m.serverURLs {
-			if url == serverURL {
-				if m.serverPriorities[j] < bestPriority {
-					bestPriority = m.serverPriorities[j]
-					bestServer = &availableServers[i]
-				}
-				break
-			}
-		}
+	serverURLs := make([]string, len(availableServers))
+	for i, srv := range availableServers {
+		serverURLs[i] = srv.ServerURL
 	}
-
-	// If we didn't find a match (shouldn't happen), fall back to first available
-	if bestServer == nil {
-		bestServer = &availableServers[0]
+	if i := m.selectPriorityIndex(serverURLs); i >= 0 {
+		return &availableServers[i]
 	}
 
-	return bestServer
+	// If we didn't find a match (shouldn't happen), fall back to first available
+	return &availableServers[0]
 }
 
 // SelectServer selects a server URL for redirect based on the configured strategy (legacy method for download)
@@ -842,6 +1062,9 @@ func (m *Manager) SelectServerURLWithStrategy(availableServers []string, strateg
 		return "", fmt.Errorf("no available servers")
 	}
 
+	// Exclude servers in a maintenance window (unless all of them are)
+	availableServers = m.excludeInMaintenance(availableServers)
+
 	var selected string
 	switch strategy {
 	case "round_robin":
@@ -850,20 +1073,23 @@ func (m *Manager) SelectServerURLWithStrategy(availableServers []string, strateg
 		selected = m.selectRandom(availableServers)
 	case "priority":
 		selected = m.selectPriority(availableServers)
+	case "weighted":
+		selected = m.selectWeighted(availableServers)
 	case "local":
 		// For download redirects, "local" strategy uses round-robin to select upstream server
-		// "Local" only affects response URLs in upload/mirror/list endpoints
+		// "Local" only affects response URLs in upload/mirror/list endpoints (the same
+		// as response_url_mode "prefer_base_url", which keeps the configured strategy here)
 		selected = m.selectRoundRobin(availableServers)
 	case "health_based":
 		selected = m.selectHealthBased(availableServers)
+	case "throughput_aware":
+		selected = m.selectThroughputAware(availableServers)
 	default:
 		// Default to round-robin
 		selected = m.selectRoundRobin(availableServers)
 	}
 
-	if m.verbose {
-		log.Printf("[DEBUG] SelectServerURL: strategy=%s, available=%d servers, selected=%s", strategy, len(availableServers), selected)
-	}
+	m.verbose.Debugf(context.Background(), "SelectServerURL: strategy=%s, available=%d servers, selected=%s", strategy, len(availableServers), selected)
 
 	return selected, nil
 }
@@ -883,15 +1109,15 @@ func (m *Manager) selectRandom(availableServers []string) string {
 	return availableServers[rand.Intn(len(availableServers))]
 }
 
-// selectHealthBased selects servers with the lowest total failures, then uses round-robin within that group
-// Total failures = sum of upload, mirror, delete, and list failures
+// selectHealthBased selects servers with the lowest download failures, then uses round-robin within that group
+// Upload, list and other failures are ignored since they don't affect serving the blob
 func (m *Manager) selectHealthBased(availableServers []string) string {
 	if len(availableServers) == 0 {
 		return ""
 	}
 
 	// If no failure getter is set, fall back to round-robin
-	if m.getTotalFailures == nil {
+	if m.getFailures == nil {
 		return m.selectRoundRobin(availableServers)
 	}
 
@@ -900,7 +1126,7 @@ func (m *Manager) selectHealthBased(availableServers []string) string {
 	var minFailures int64 = -1
 
 	for _, serverURL := range availableServers {
-		totalFailures := m.getTotalFailures(serverURL)
+		totalFailures := m.getFailures(serverURL, "download")
 		serversByFailures[totalFailures] = append(serversByFailures[totalFailures], serverURL)
 
 		// Track minimum failures
@@ -916,80 +1142,77 @@ func (m *Manager) selectHealthBased(availableServers []string) string {
 		return availableServers[0]
 	}
 
-	if m.verbose {
-		log.Printf("[DEBUG] selectHealthBased: %d servers with minimum failures (%d): %v", len(bestServers), minFailures, bestServers)
-	}
+	m.verbose.Debugf(context.Background(), "selectHealthBased: %d servers with minimum failures (%d): %v", len(bestServers), minFailures, bestServers)
 
 	// Use round-robin within the best servers group
 	return m.selectRoundRobin(bestServers)
 }
 
-// selectPriority selects the server with the lowest priority number (lower is better)
-// If multiple servers have the same lowest priority, returns the first one found
+// selectPriority selects among the servers with the lowest priority number (legacy for downloads)
+// Servers sharing that priority are rotated by weight
 func (m *Manager) selectPriority(availableServers []string) string {
 	if len(availableServers) == 0 {
 		return ""
 	}
-
-	// Create a map of available server URLs to their priorities
-	availablePriorities := make(map[string]int)
-	for _, availableURL := range availableServers {
-		// Find the priority for this URL
-		for i, url := range m.serverURLs {
-			if url == availableURL {
-				availablePriorities[availableURL] = m.serverPriorities[i]
-				break
-			}
-		}
+	if i := m.selectPriorityIndex(availableServers); i >= 0 {
+		return availableServers[i]
 	}
 
-	// Find the server with the lowest priority
-	var bestServer string
-	bestPriority := int(^uint(0) >> 1) // Max int value
+	// If we didn't find a match (shouldn't happen), fall back to first available
+	return availableServers[0]
+}
 
-	for _, url := range availableServers {
-		if priority, ok := availablePriorities[url]; ok {
-			if priority < bestPriority {
-				bestPriority = priority
-				bestServer = url
-			}
-		}
+// selectWeightedWithResponse selects an upload/mirror response server in proportion to the
+// servers' weights, whatever their priority
+func (m *Manager) selectWeightedWithResponse(availableServers []UploadResultWithResponse) *UploadResultWithResponse {
+	serverURLs := make([]string, len(availableServers))
+	for i, srv := range availableServers {
+		serverURLs[i] = srv.ServerURL
 	}
-
-	// If we didn't find a match (shouldn't happen), fall back to first available
-	if bestServer == "" {
-		bestServer = availableServers[0]
+	if i := m.selectWeightedIndex(serverURLs); i >= 0 {
+		return &availableServers[i]
 	}
+	return nil
+}
 
-	return bestServer
+// selectWeighted selects a redirect server in proportion to the servers' weights, whatever
+// their priority
+func (m *Manager) selectWeighted(availableServers []string) string {
+	if i := m.selectWeightedIndex(availableServers); i >= 0 {
+		return availableServers[i]
+	}
+	return ""
 }
 
 // GetClient returns a client for a specific server URL
-func (m *Manager) GetClient(serverURL string) (*client.Client, error) {
-	for i, url := range m.serverURLs {
+func (m *Manager) GetClient(serverURL string) (*blossomclient.Client, error) {
+	set := m.servers.Load()
+	for i, url := range set.serverURLs {
 		if url == serverURL {
-			return m.clients[i], nil
+			return set.clients[i], nil
 		}
 	}
 	return nil, fmt.Errorf("server not found: %s", serverURL)
 }
 
-// GetAllClients returns all clients
-func (m *Manager) GetAllClients() []*client.Client {
-	return m.clients
+// GetAllClients returns the clients of all servers in rotation
+func (m *Manager) GetAllClients() []*blossomclient.Client {
+	return m.servers.Load().clients
 }
 
-// GetServerURLs returns all server URLs
+// GetServerURLs returns the URLs of all servers in rotation (paused servers are left out)
+// The slice is shared and must not be modified
 func (m *Manager) GetServerURLs() []string {
-	return m.serverURLs
+	return m.servers.Load().serverURLs
 }
 
 // GetMirrorCapableServers returns a list of server URLs that support mirroring
 func (m *Manager) GetMirrorCapableServers() []string {
+	set := m.servers.Load()
 	mirrorCapableServers := make([]string, 0)
-	for i, cap := range m.serverCapabilities {
+	for i, cap := range set.serverCapabilities {
 		if cap.SupportsMirror {
-			mirrorCapableServers = append(mirrorCapableServers, m.serverURLs[i])
+			mirrorCapableServers = append(mirrorCapableServers, set.serverURLs[i])
 		}
 	}
 	return mirrorCapableServers
@@ -1004,9 +1227,8 @@ type CheckPathOnServersResult struct {
 // CheckPathOnServers checks all upstream servers in parallel to see which ones have the blob at the given path
 // Returns list of server URLs that have the blob and their response headers
 func (m *Manager) CheckPathOnServers(ctx context.Context, path string, timeout time.Duration) CheckPathOnServersResult {
-	if m.verbose {
-		log.Printf("[DEBUG] CheckPathOnServers: checking path %s on %d servers, timeout=%v", path, len(m.clients), timeout)
-	}
+	set := m.servers.Load()
+	m.verbose.Debugf(ctx, "CheckPathOnServers: checking path %s on %d servers, timeout=%v", path, len(set.clients), timeout)
 
 	// Create a context with timeout
 	checkCtx, cancel := context.WithTimeout(ctx, timeout)
@@ -1017,30 +1239,36 @@ func (m *Manager) CheckPathOnServers(ctx context.Context, path string, timeout t
 		ServerURL string
 		HasBlob   bool
 		Headers   http.Header
-	}, len(m.clients))
+	}, len(set.clients))
 
 	// Launch parallel HEAD requests
 	var wg sync.WaitGroup
-	for i, cl := range m.clients {
+	for i, cl := range set.clients {
 		wg.Add(1)
-		go func(idx int, c *client.Client, url string) {
+		go func(idx int, c *blossomclient.Client, url string) {
 			defer wg.Done()
+			// A panic counts as the blob not being found on this server
+			defer recovery.Recover("CheckPathOnServers")
 
-			if m.verbose {
-				log.Printf("[DEBUG] CheckPathOnServers: checking server %d: %s", idx+1, url)
-			}
+			m.verbose.Debugf(ctx, "CheckPathOnServers: checking server %d: %s", idx+1, url)
+			m.staggerStart(checkCtx)
 
 			// Use Head() to get headers, passing the full path (may include extension)
+			headStart := time.Now()
 			headResp, err := c.Head(checkCtx, path)
+			m.observeRequest(ctx, url, "head", time.Since(headStart), err)
 			// Some servers (e.g. nostrcheck.me) return 200 with X-Reason: File not found instead of 404
 			hasBlob := err == nil && headResp != nil && headResp.StatusCode == http.StatusOK &&
 				!strings.EqualFold(strings.TrimSpace(headResp.Header.Get("X-Reason")), "File not found")
 
 			var headers http.Header
-			if hasBlob && headResp != nil {
-				headers = headResp.Header
+			if headResp != nil {
 				headResp.Body.Close()
 			}
+			if hasBlob {
+				headers = headResp.Header
+				m.observeRangeSupport(url, headers)
+			}
 
 			resultChan <- struct {
 				ServerURL string
@@ -1052,14 +1280,14 @@ func (m *Manager) CheckPathOnServers(ctx context.Context, path string, timeout t
 				Headers:   headers,
 			}
 
-			if m.verbose {
+			if m.verbose.Enabled() {
 				if hasBlob {
-					log.Printf("[DEBUG] CheckPathOnServers: server %d (%s) has the blob", idx+1, url)
+					m.verbose.Debugf(ctx, "CheckPathOnServers: server %d (%s) has the blob", idx+1, url)
 				} else {
-					log.Printf("[DEBUG] CheckPathOnServers: server %d (%s) does not have the blob", idx+1, url)
+					m.verbose.Debugf(ctx, "CheckPathOnServers: server %d (%s) does not have the blob", idx+1, url)
 				}
 			}
-		}(i, cl, m.serverURLs[i])
+		}(i, cl, set.serverURLs[i])
 	}
 
 	// Wait for all checks to complete
@@ -1078,9 +1306,7 @@ func (m *Manager) CheckPathOnServers(ctx context.Context, path string, timeout t
 		}
 	}
 
-	if m.verbose {
-		log.Printf("[DEBUG] CheckPathOnServers: path found on %d servers: %v", len(serversWithBlob), serversWithBlob)
-	}
+	m.verbose.Debugf(ctx, "CheckPathOnServers: path found on %d servers: %v", len(serversWithBlob), serversWithBlob)
 
 	return CheckPathOnServersResult{
 		Servers: serversWithBlob,
@@ -1102,10 +1328,18 @@ type UploadPreflightResult struct {
 // timeout specifies the timeout for the preflight context
 // Returns the list of servers that would accept the upload
 func (m *Manager) UploadPreflightParallel(ctx context.Context, headers map[string]string, timeout time.Duration) ([]UploadPreflightResult, error) {
+	return m.UploadPreflightParallelTo(ctx, UploadTargets{}, headers, timeout)
+}
+
+// UploadPreflightParallelTo performs HEAD /upload on the HEAD-capable servers within the given targets (BUD-06)
+func (m *Manager) UploadPreflightParallelTo(ctx context.Context, targets UploadTargets, headers map[string]string, timeout time.Duration) ([]UploadPreflightResult, error) {
+	set := m.servers.Load()
+	targetIndices, minUploadServers := m.resolveTargets(set, targets)
+
 	// Filter servers by upload_head capability
 	uploadHeadCapableIndices := make([]int, 0)
-	for i, cap := range m.serverCapabilities {
-		if cap.SupportsUploadHead {
+	for _, i := range targetIndices {
+		if set.serverCapabilities[i].SupportsUploadHead {
 			uploadHeadCapableIndices = append(uploadHeadCapableIndices, i)
 		}
 	}
@@ -1113,12 +1347,11 @@ func (m *Manager) UploadPreflightParallel(ctx context.Context, headers map[strin
 	if len(uploadHeadCapableIndices) == 0 {
 		return nil, fmt.Errorf("no upstream servers support HEAD /upload endpoint")
 	}
+	uploadHeadCapableIndices = m.skipInMaintenance(ctx, set, uploadHeadCapableIndices, minUploadServers)
 
-	if m.verbose {
-		log.Printf("[DEBUG] UploadPreflightParallel: checking upload requirements on %d/%d servers (filtered by capability)",
-			len(uploadHeadCapableIndices), len(m.clients))
-		log.Printf("[DEBUG] UploadPreflightParallel: headers=%v, timeout=%v", headers, timeout)
-	}
+	m.verbose.Debugf(ctx, "UploadPreflightParallel: checking upload requirements on %d/%d servers (filtered by capability)",
+		len(uploadHeadCapableIndices), len(set.clients))
+	m.verbose.Debugf(ctx, "UploadPreflightParallel: headers=%v, timeout=%v", headers, timeout)
 
 	// Create a context with timeout
 	preflightCtx, cancel := context.WithTimeout(ctx, timeout)
@@ -1131,20 +1364,23 @@ func (m *Manager) UploadPreflightParallel(ctx context.Context, headers map[strin
 	var wg sync.WaitGroup
 	for _, idx := range uploadHeadCapableIndices {
 		wg.Add(1)
-		cl := m.clients[idx]
-		url := m.serverURLs[idx]
-		go func(serverIdx int, c *client.Client, serverURL string) {
+		cl := set.clients[idx]
+		url := set.serverURLs[idx]
+		go func(serverIdx int, c *blossomclient.Client, serverURL string) {
 			defer wg.Done()
+			defer func() {
+				// A panic counts as a failed preflight on this server
+				if v := recover(); v != nil {
+					resultChan <- UploadPreflightResult{ServerURL: serverURL, Error: recovery.Error("UploadPreflightParallel", v)}
+				}
+			}()
 
-			if m.verbose {
-				log.Printf("[DEBUG] UploadPreflightParallel: checking server: %s", serverURL)
-			}
+			m.verbose.Debugf(ctx, "UploadPreflightParallel: checking server: %s", serverURL)
+			m.staggerStart(preflightCtx)
 
 			resp, err := c.HeadUpload(preflightCtx, headers)
 			if err != nil {
-				if m.verbose {
-					log.Printf("[DEBUG] UploadPreflightParallel: server %s failed: %v", serverURL, err)
-				}
+				m.verbose.Debugf(ctx, "UploadPreflightParallel: server %s failed: %v", serverURL, err)
 				resultChan <- UploadPreflightResult{
 					ServerURL:  serverURL,
 					Accepted:   false,
@@ -1158,11 +1394,11 @@ func (m *Manager) UploadPreflightParallel(ctx context.Context, headers map[strin
 			accepted := resp.StatusCode == http.StatusOK
 			xReason := resp.Header.Get("X-Reason")
 
-			if m.verbose {
+			if m.verbose.Enabled() {
 				if accepted {
-					log.Printf("[DEBUG] UploadPreflightParallel: server %s accepted (status=%d)", serverURL, resp.StatusCode)
+					m.verbose.Debugf(ctx, "UploadPreflightParallel: server %s accepted (status=%d)", serverURL, resp.StatusCode)
 				} else {
-					log.Printf("[DEBUG] UploadPreflightParallel: server %s rejected (status=%d, X-Reason=%s)", serverURL, resp.StatusCode, xReason)
+					m.verbose.Debugf(ctx, "UploadPreflightParallel: server %s rejected (status=%d, X-Reason=%s)", serverURL, resp.StatusCode, xReason)
 				}
 			}
 
@@ -1180,7 +1416,7 @@ func (m *Manager) UploadPreflightParallel(ctx context.Context, headers map[strin
 	close(resultChan)
 
 	// Collect all results
-	results := make([]UploadPreflightResult, 0, len(m.clients))
+	results := make([]UploadPreflightResult, 0, len(set.clients))
 	acceptedCount := 0
 	for result := range resultChan {
 		results = append(results, result)
@@ -1189,13 +1425,11 @@ func (m *Manager) UploadPreflightParallel(ctx context.Context, headers map[strin
 		}
 	}
 
-	if m.verbose {
-		log.Printf("[DEBUG] UploadPreflightParallel: %d/%d servers accepted the upload", acceptedCount, len(results))
-	}
+	m.verbose.Debugf(ctx, "UploadPreflightParallel: %d/%d servers accepted the upload", acceptedCount, len(results))
 
 	// Check if we have enough servers that would accept
-	if acceptedCount < m.minUploadServers {
-		errMsg := fmt.Sprintf("only %d servers would accept the upload, need at least %d", acceptedCount, m.minUploadServers)
+	if acceptedCount < minUploadServers {
+		errMsg := fmt.Sprintf("only %d servers would accept the upload, need at least %d", acceptedCount, minUploadServers)
 
 		// Find the lowest status code from rejected servers
 		lowestStatusCode := 0
@@ -1216,9 +1450,7 @@ func (m *Manager) UploadPreflightParallel(ctx context.Context, headers map[strin
 			lowestStatusCode = http.StatusBadRequest
 		}
 
-		if m.verbose {
-			log.Printf("[DEBUG] UploadPreflightParallel: upload would fail - using status code %d", lowestStatusCode)
-		}
+		m.verbose.Debugf(ctx, "UploadPreflightParallel: upload would fail - using status code %d", lowestStatusCode)
 
 		return results, &UploadError{
 			StatusCode: lowestStatusCode,
@@ -1232,9 +1464,8 @@ func (m *Manager) UploadPreflightParallel(ctx context.Context, headers map[strin
 // listParallelInternal is the internal implementation that queries all upstream servers
 // and returns both merged results and per-server results
 func (m *Manager) listParallelInternal(ctx context.Context, pubkey string, timeout time.Duration) ([]map[string]interface{}, []ListResult, error) {
-	if m.verbose {
-		log.Printf("[DEBUG] ListParallel: starting parallel list query to %d servers for pubkey %s, timeout=%v", len(m.clients), pubkey, timeout)
-	}
+	set := m.servers.Load()
+	m.verbose.Debugf(ctx, "ListParallel: starting parallel list query to %d servers for pubkey %s, timeout=%v", len(set.clients), pubkey, timeout)
 
 	// Create a context with timeout
 	listCtx, cancel := context.WithTimeout(ctx, timeout)
@@ -1245,24 +1476,37 @@ func (m *Manager) listParallelInternal(ctx context.Context, pubkey string, timeo
 		ServerURL string
 		Data      []map[string]interface{}
 		Error     error
-	}, len(m.clients))
+	}, len(set.clients))
 
-	// Launch parallel list queries
+	// Launch parallel list queries (skipping servers without list support)
 	var wg sync.WaitGroup
-	for i, cl := range m.clients {
+	for i, cl := range set.clients {
+		if !m.SupportsList(set.serverURLs[i]) {
+			m.verbose.Debugf(ctx, "ListParallel: skipping server %d (%s), list not supported", i+1, set.serverURLs[i])
+			continue
+		}
 		wg.Add(1)
-		go func(idx int, c *client.Client, url string) {
+		go func(idx int, c *blossomclient.Client, url string) {
 			defer wg.Done()
+			defer func() {
+				// A panic (e.g. on an unexpected response shape) counts as a failed query
+				if v := recover(); v != nil {
+					resultChan <- struct {
+						ServerURL string
+						Data      []map[string]interface{}
+						Error     error
+					}{ServerURL: url, Error: recovery.Error("ListParallel", v)}
+				}
+			}()
 
-			if m.verbose {
-				log.Printf("[DEBUG] ListParallel: querying server %d: %s", idx+1, url)
-			}
+			m.verbose.Debugf(ctx, "ListParallel: querying server %d: %s", idx+1, url)
+			m.staggerStart(listCtx)
 
+			listStart := time.Now()
 			response, err := c.List(listCtx, pubkey)
+			m.observeRequest(ctx, url, "list", time.Since(listStart), err)
 			if err != nil {
-				if m.verbose {
-					log.Printf("[DEBUG] ListParallel: server %d (%s) failed: %v", idx+1, url, err)
-				}
+				m.verbose.Debugf(ctx, "ListParallel: server %d (%s) failed: %v", idx+1, url, err)
 				resultChan <- struct {
 					ServerURL string
 					Data      []map[string]interface{}
@@ -1278,9 +1522,7 @@ func (m *Manager) listParallelInternal(ctx context.Context, pubkey string, timeo
 			// Parse JSON response
 			var data []map[string]interface{}
 			if err := json.Unmarshal(response, &data); err != nil {
-				if m.verbose {
-					log.Printf("[DEBUG] ListParallel: server %d (%s) failed to parse JSON: %v", idx+1, url, err)
-				}
+				m.verbose.Debugf(ctx, "ListParallel: server %d (%s) failed to parse JSON: %v", idx+1, url, err)
 				resultChan <- struct {
 					ServerURL string
 					Data      []map[string]interface{}
@@ -1293,9 +1535,7 @@ func (m *Manager) listParallelInternal(ctx context.Context, pubkey string, timeo
 				return
 			}
 
-			if m.verbose {
-				log.Printf("[DEBUG] ListParallel: server %d (%s) returned %d items", idx+1, url, len(data))
-			}
+			m.verbose.Debugf(ctx, "ListParallel: server %d (%s) returned %d items", idx+1, url, len(data))
 
 			resultChan <- struct {
 				ServerURL string
@@ -1306,7 +1546,7 @@ func (m *Manager) listParallelInternal(ctx context.Context, pubkey string, timeo
 				Data:      data,
 				Error:     nil,
 			}
-		}(i, cl, m.serverURLs[i])
+		}(i, cl, set.serverURLs[i])
 	}
 
 	// Wait for all queries to complete
@@ -1317,20 +1557,33 @@ func (m *Manager) listParallelInternal(ctx context.Context, pubkey string, timeo
 	allResults := make([]ListResult, 0)
 	for result := range resultChan {
 		allResults = append(allResults, ListResult{
-			ServerURL: result.ServerURL,
-			Data:      result.Data,
-			Error:     result.Error,
+			ServerURL:   result.ServerURL,
+			Data:        result.Data,
+			Error:       result.Error,
+			Unsupported: result.Error != nil && m.DetectUnsupported(result.ServerURL, "list", result.Error),
 		})
 	}
 
-	if m.verbose {
-		successCount := 0
-		for _, r := range allResults {
-			if r.Error == nil {
-				successCount++
-			}
+	successCount := 0
+	for _, r := range allResults {
+		if r.Error == nil {
+			successCount++
+		}
+	}
+	m.verbose.Debugf(ctx, "ListParallel: completed - %d succeeded, %d failed", successCount, len(allResults)-successCount)
+
+	// An empty merge only means "no blobs" if enough servers actually answered
+	// The threshold is capped at the number of servers that can list at all
+	required := m.minListServers
+	if queryable := countListQueryable(allResults); required > queryable {
+		required = queryable
+	}
+	if successCount < required {
+		return nil, allResults, &ListError{
+			Succeeded: successCount,
+			Required:  required,
+			Results:   allResults,
 		}
-		log.Printf("[DEBUG] ListParallel: completed - %d succeeded, %d failed", successCount, len(allResults)-successCount)
 	}
 
 	// Merge and deduplicate results based on sha256
@@ -1356,7 +1609,7 @@ func (m *Manager) listParallelInternal(ctx context.Context, pubkey string, timeo
 			}
 
 			itemsByHash[sha256Val] = append(itemsByHash[sha256Val], itemWithServer{
-				Item:     item,
+				Item:      item,
 				ServerURL: result.ServerURL,
 			})
 		}
@@ -1401,10 +1654,25 @@ func (m *Manager) listParallelInternal(ctx context.Context, pubkey string, timeo
 				}
 			}
 
-			if m.verbose && len(items) > 1 {
-				log.Printf("[DEBUG] ListParallel: sha256 %s found on %d servers, selected %s", sha256Val, len(items), selectedServerURL)
+			if m.verbose.Enabled() && len(items) > 1 {
+				m.verbose.Debugf(ctx, "ListParallel: sha256 %s found on %d servers, selected %s", sha256Val, len(items), selectedServerURL)
+			}
+		}
+
+		// Populate size, type and uploaded from the most complete answers
+		alternatives := make([]map[string]interface{}, 0, len(items))
+		for _, item := range items {
+			if item.ServerURL != selectedServerURL {
+				alternatives = append(alternatives, item.Item)
 			}
 		}
+		// Work on a copy of the selected item to avoid modifying the original
+		normalized := make(map[string]interface{}, len(selected))
+		for k, v := range selected {
+			normalized[k] = v
+		}
+		NormalizeDescriptor(normalized, alternatives)
+		selected = normalized
 
 		// Collect all URLs from all servers for this sha256 and add as BUD-08 tags
 		// Also add NIP-94 tags: ["x", "<hash>"] and ["m", "<mime-type>"]
@@ -1463,6 +1731,9 @@ func (m *Manager) listParallelInternal(ctx context.Context, pubkey string, timeo
 			tags = append(tags, []interface{}{"m", mimeType})
 		}
 
+		// Add NIP-94 size tag ["size", "<bytes>"] if not present
+		tags = AddSizeTag(tags, selected)
+
 		// Collect URLs from all servers for this sha256
 		for _, item := range items {
 			if urlVal, ok := item.Item["url"].(string); ok && urlVal != "" {
@@ -1482,7 +1753,7 @@ func (m *Manager) listParallelInternal(ctx context.Context, pubkey string, timeo
 		// Update nip94 in result item (BUD-08 + NIP-94)
 		resultItem["nip94"] = tags
 
-		if m.verbose {
+		if m.verbose.Enabled() {
 			// Count url tags for logging
 			urlTagCount := 0
 			for _, tag := range tags {
@@ -1492,15 +1763,13 @@ func (m *Manager) listParallelInternal(ctx context.Context, pubkey string, timeo
 					}
 				}
 			}
-			log.Printf("[DEBUG] ListParallel: sha256 %s - added tags - %d url tags (BUD-08), NIP-94 tags for hash and mime type", sha256Val, urlTagCount)
+			m.verbose.Debugf(ctx, "ListParallel: sha256 %s - added tags - %d url tags (BUD-08), NIP-94 tags for hash and mime type", sha256Val, urlTagCount)
 		}
 
 		merged = append(merged, resultItem)
 	}
 
-	if m.verbose {
-		log.Printf("[DEBUG] ListParallel: merged %d unique items from all servers", len(merged))
-	}
+	m.verbose.Debugf(ctx, "ListParallel: merged %d unique items from all servers", len(merged))
 
 	return merged, allResults, nil
 }
@@ -1514,9 +1783,10 @@ func (m *Manager) ListParallel(ctx context.Context, pubkey string, timeout time.
 
 // ListResult represents a single server's list query result
 type ListResult struct {
-	ServerURL string
-	Data      []map[string]interface{}
-	Error     error
+	ServerURL   string
+	Data        []map[string]interface{}
+	Error       error
+	Unsupported bool // Server doesn't implement list (the error is not a failure)
 }
 
 // ListParallelWithResults queries all upstream servers and returns both merged results and per-server results
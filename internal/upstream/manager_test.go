@@ -0,0 +1,66 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/testutil"
+)
+
+// newTestManager returns a manager for the given upstream URLs, with the server settings
+// of extra (YAML lines indented under server:)
+func newTestManager(t *testing.T, extra string, urls ...string) *Manager {
+	t.Helper()
+	m, err := New(testutil.LoadConfig(t, extra, urls...), logging.NewLevels(nil))
+	if err != nil {
+		t.Fatalf("upstream manager: %v", err)
+	}
+	return m
+}
+
+var errClientGone = errors.New("client disconnected")
+
+func TestUploadParallelStreamingAbortsOnClientDisconnect(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		contentLength int64
+	}{
+		{"content length", 1 << 20},
+		{"chunked", -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			started := make(chan struct{}, 2)
+			srv1, uploads1 := testutil.UploadingUpstream(t, started)
+			srv2, uploads2 := testutil.UploadingUpstream(t, started)
+			m := newTestManager(t, "", srv1.URL, srv2.URL)
+
+			body := &testutil.DisconnectingBody{Data: bytes.NewReader(bytes.Repeat([]byte("x"), 64<<10)), Started: started, Upstreams: 2, Err: errClientGone}
+			results, err := m.UploadParallelStreamingTo(context.Background(), UploadTargets{}, body,
+				"application/octet-stream", tc.contentLength, map[string]string{}, 10*time.Second)
+
+			if !errors.Is(err, ErrBodyAborted) {
+				t.Fatalf("err = %v, want ErrBodyAborted", err)
+			}
+			if !errors.Is(err, errClientGone) {
+				t.Errorf("err = %v, want it to wrap the body's error", err)
+			}
+			if len(results) != 0 {
+				t.Errorf("got %d successful servers, want none: %+v", len(results), results)
+			}
+			for i, uploads := range []<-chan testutil.Upload{uploads1, uploads2} {
+				select {
+				case upload := <-uploads:
+					if upload.Complete {
+						t.Errorf("upstream %d received a complete body of %d bytes, want the upload aborted", i+1, upload.Received)
+					}
+				case <-time.After(5 * time.Second):
+					t.Errorf("upstream %d never saw the upload", i+1)
+				}
+			}
+		})
+	}
+}
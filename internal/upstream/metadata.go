@@ -0,0 +1,165 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HeadMetadataResult is the outcome of aggregating HEAD metadata from several servers
+type HeadMetadataResult struct {
+	BestServer        string   // Server with the most complete metadata agreeing with the consensus size
+	ConsensusSize     int64    // Most common Content-Length (-1 if no server reported one)
+	MismatchedServers []string // Servers whose Content-Length differs from the consensus (corruption suspects)
+}
+
+// metadataScore rates how complete a server's HEAD metadata is
+func metadataScore(h http.Header) int {
+	score := 0
+	if h.Get("Content-Length") != "" {
+		score++
+	}
+	if h.Get("Content-Type") != "" {
+		score++
+	}
+	if h.Get("Accept-Ranges") != "" && h.Get("Accept-Ranges") != "none" {
+		score++
+	}
+	return score
+}
+
+// AggregateHeadMetadata picks the server with the most complete HEAD metadata and detects
+// servers that disagree on the blob size
+// Size consensus is the most common Content-Length (ties are broken by server URL order)
+func (m *Manager) AggregateHeadMetadata(headers map[string]http.Header) HeadMetadataResult {
+	result := HeadMetadataResult{ConsensusSize: -1}
+	if len(headers) == 0 {
+		return result
+	}
+
+	// Iterate servers in a stable order so selection is deterministic
+	servers := make([]string, 0, len(headers))
+	for serverURL := range headers {
+		servers = append(servers, serverURL)
+	}
+	sort.Strings(servers)
+
+	// Determine the consensus size
+	sizes := make(map[string]int64)
+	sizeVotes := make(map[int64]int)
+	for _, serverURL := range servers {
+		if cl := headers[serverURL].Get("Content-Length"); cl != "" {
+			if size, err := strconv.ParseInt(cl, 10, 64); err == nil && size >= 0 {
+				sizes[serverURL] = size
+				sizeVotes[size]++
+			}
+		}
+	}
+	bestVotes := 0
+	for _, serverURL := range servers {
+		size, ok := sizes[serverURL]
+		if ok && sizeVotes[size] > bestVotes {
+			bestVotes = sizeVotes[size]
+			result.ConsensusSize = size
+		}
+	}
+
+	// Flag servers disagreeing with the consensus and pick the most complete agreeing server
+	bestScore := -1
+	for _, serverURL := range servers {
+		if size, ok := sizes[serverURL]; ok && size != result.ConsensusSize {
+			result.MismatchedServers = append(result.MismatchedServers, serverURL)
+			continue
+		}
+		if score := metadataScore(headers[serverURL]); score > bestScore {
+			bestScore = score
+			result.BestServer = serverURL
+		}
+	}
+
+	m.verbose.Debugf(context.Background(), "AggregateHeadMetadata: %d servers, best=%s (score=%d), consensus size=%d", len(servers), result.BestServer, bestScore, result.ConsensusSize)
+
+	return result
+}
+
+// supportsRanges reports whether HEAD metadata advertises byte-range support
+// Returns ok=false if the server's metadata is unknown
+func supportsRanges(headers map[string]http.Header, serverURL string) (supported bool, ok bool) {
+	h, exists := headers[serverURL]
+	if !exists || h == nil {
+		return false, false
+	}
+	acceptRanges := strings.ToLower(h.Get("Accept-Ranges"))
+	return strings.Contains(acceptRanges, "bytes"), true
+}
+
+// rangeSupport reports whether a server supports byte ranges for a blob: from the blob's
+// cached HEAD metadata if known, otherwise from the server's last observed HEAD response
+// (see CapabilityMatrix). Returns ok=false if neither is known
+func (m *Manager) rangeSupport(headers map[string]http.Header, serverURL string) (supported bool, ok bool) {
+	if supported, ok := supportsRanges(headers, serverURL); ok {
+		return supported, true
+	}
+	return m.observedRangeSupport(serverURL)
+}
+
+// PreferRangeCapable narrows servers for a Range request to those whose cached HEAD metadata
+// (or, without it, their last observed HEAD response) advertises "Accept-Ranges: bytes",
+// falling back to servers with unknown support and finally to all servers, so seeking
+// works regardless of which mirror is picked
+func (m *Manager) PreferRangeCapable(servers []string, headers map[string]http.Header) []string {
+	capable := make([]string, 0, len(servers))
+	unknown := make([]string, 0, len(servers))
+	for _, serverURL := range servers {
+		supported, ok := m.rangeSupport(headers, serverURL)
+		switch {
+		case !ok:
+			unknown = append(unknown, serverURL)
+		case supported:
+			capable = append(capable, serverURL)
+		}
+	}
+
+	if len(capable) > 0 {
+		if m.verbose.Enabled() && len(capable) < len(servers) {
+			m.verbose.Debugf(context.Background(), "PreferRangeCapable: %d/%d servers advertise range support: %v", len(capable), len(servers), capable)
+		}
+		return capable
+	}
+	if len(unknown) > 0 {
+		return unknown
+	}
+	return servers
+}
+
+// SupportsRanges reports whether a server's HEAD metadata advertises byte-range support
+// Servers with unknown support are assumed to support ranges
+func (m *Manager) SupportsRanges(headers map[string]http.Header, serverURL string) bool {
+	supported, ok := m.rangeSupport(headers, serverURL)
+	return supported || !ok
+}
+
+// BlobSize returns the size of a blob on a server from its HEAD metadata, falling back to
+// the size reported by any other server, or -1 if no Content-Length is known
+func BlobSize(headers map[string]http.Header, serverURL string) int64 {
+	if size, ok := contentLength(headers[serverURL]); ok {
+		return size
+	}
+	for _, h := range headers {
+		if size, ok := contentLength(h); ok {
+			return size
+		}
+	}
+	return -1
+}
+
+// contentLength parses the Content-Length of HEAD metadata
+func contentLength(h http.Header) (int64, bool) {
+	if h == nil {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	return size, err == nil && size >= 0
+}
@@ -0,0 +1,77 @@
+package upstream
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/girino/blossom_espelhator/internal/config"
+)
+
+// Operator is the contact information configured for an upstream server, telling whom to
+// contact when it goes down
+type Operator struct {
+	Name          string `json:"name,omitempty"`
+	Contact       string `json:"contact,omitempty"`
+	StatusPageURL string `json:"status_page_url,omitempty"`
+}
+
+// operatorOf returns the operator information of a server's configuration (nil if none is set)
+func operatorOf(server config.UpstreamServer) *Operator {
+	if server.Operator == "" && server.Contact == "" && server.StatusPageURL == "" {
+		return nil
+	}
+	return &Operator{
+		Name:          server.Operator,
+		Contact:       server.Contact,
+		StatusPageURL: server.StatusPageURL,
+	}
+}
+
+// String formats the operator for log messages (e.g., "operator: Alice, contact: alice@example.com")
+func (o *Operator) String() string {
+	var parts []string
+	if o.Name != "" {
+		parts = append(parts, "operator: "+o.Name)
+	}
+	if o.Contact != "" {
+		parts = append(parts, "contact: "+o.Contact)
+	}
+	if o.StatusPageURL != "" {
+		parts = append(parts, "status page: "+o.StatusPageURL)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Operator returns the operator information configured for a server (nil if none)
+func (m *Manager) Operator(serverURL string) *Operator {
+	m.serversMu.Lock()
+	defer m.serversMu.Unlock()
+	i := m.entryLocked(serverURL)
+	if i < 0 {
+		return nil
+	}
+	return operatorOf(m.entries[i].config)
+}
+
+// Operators returns the operator information of every server that has some, by server URL
+func (m *Manager) Operators() map[string]*Operator {
+	m.serversMu.Lock()
+	defer m.serversMu.Unlock()
+	operators := make(map[string]*Operator)
+	for _, entry := range m.entries {
+		if operator := operatorOf(entry.config); operator != nil {
+			operators[entry.config.URL] = operator
+		}
+	}
+	return operators
+}
+
+// ContactHint returns the operator information of a server formatted to be appended to a
+// log message about it (e.g., " (operator: Alice, contact: alice@example.com)"), or ""
+func (m *Manager) ContactHint(serverURL string) string {
+	operator := m.Operator(serverURL)
+	if operator == nil {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", operator)
+}
@@ -0,0 +1,154 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ErrUploadResponseTimeout is returned for an upstream that received the whole upload body
+// but did not finish answering within upload_response_timeout
+var ErrUploadResponseTimeout = fmt.Errorf("no response after the upload body was sent: %w", context.DeadlineExceeded)
+
+// ErrSlowUpstream is returned for an upstream detached from a streamed upload because it
+// fell too far behind the others (slow_upstream_detach_bytes / slow_upstream_detach_after)
+var ErrSlowUpstream = errors.New("detached: too far behind the other servers")
+
+// ErrSpillLimit is returned for an upstream dropped from a streamed upload because its
+// buffer spill file would outgrow upload_buffer_max_spill_bytes
+var ErrSpillLimit = errors.New("detached: upload buffer spill limit exceeded")
+
+// errPipelineClosed fails whatever is left of a pipeline when the upload returns
+var errPipelineClosed = errors.New("upload pipeline closed")
+
+// pipelineTracker counts live streaming upload pipelines and their goroutines, so leaks
+// (e.g., an upstream that hangs after the body was written) show up in /stats
+type pipelineTracker struct {
+	responseTimeout time.Duration // Time upstreams get to answer once the body was fully written (0 = no watchdog)
+	bufferBytes     int           // Memory buffer per upstream and upload
+	spillDir        string        // Directory for buffer spill files ("" = no spilling)
+	maxSpillBytes   int64         // Largest spill file per upstream and upload (<= 0 = unlimited)
+	detachBytes     int64         // Detach an upstream this many bytes behind the fastest one (0 = disabled)
+	detachAfter     time.Duration // Detach an upstream still uploading this long after the fastest one succeeded (0 = disabled)
+
+	active         atomic.Int64 // Pipelines currently running
+	goroutines     atomic.Int64 // Pipeline goroutines currently running (uploaders + body copier)
+	started        atomic.Int64 // Pipelines started since startup
+	watchdogAborts atomic.Int64 // Upstream uploads cancelled by the watchdog
+	bodyStalls     atomic.Int64 // Pipelines abandoned because the client body stalled past the upload timeout
+	spilledBytes   atomic.Int64 // Bytes spilled to disk by finished pipelines
+	detached       atomic.Int64 // Upstream uploads detached for falling behind
+}
+
+// PipelineStats is a snapshot of the streaming upload pipelines
+type PipelineStats struct {
+	Active         int64 `json:"active"`
+	Goroutines     int64 `json:"goroutines"`
+	Started        int64 `json:"started"`
+	WatchdogAborts int64 `json:"watchdog_aborts"`
+	BodyStalls     int64 `json:"body_stalls"`
+	SpilledBytes   int64 `json:"spilled_bytes"`
+	Detached       int64 `json:"detached"`
+}
+
+// goroutine marks a pipeline goroutine as running; the returned function marks it as done
+func (t *pipelineTracker) goroutine() func() {
+	t.goroutines.Add(1)
+	return func() { t.goroutines.Add(-1) }
+}
+
+// newPipelineTracker configures the streaming upload pipelines from the server config
+func newPipelineTracker(responseTimeout time.Duration, bufferBytes int, bufferDir string, disableSpill bool,
+	maxSpillBytes int64, detachBytes int64, detachAfter time.Duration) pipelineTracker {
+	spillDir := ""
+	if !disableSpill {
+		spillDir = bufferDir
+		if spillDir == "" {
+			spillDir = os.TempDir()
+		}
+	}
+	return pipelineTracker{
+		responseTimeout: responseTimeout,
+		bufferBytes:     bufferBytes,
+		spillDir:        spillDir,
+		maxSpillBytes:   maxSpillBytes,
+		detachBytes:     detachBytes,
+		detachAfter:     detachAfter,
+	}
+}
+
+// detachCheckInterval is how often a streamed upload compares its servers' progress
+const detachCheckInterval = 250 * time.Millisecond
+
+// lagging returns the indices of the uploads that fell too far behind the fastest one
+// finished and succeeded are per-upload states; firstSuccess is when the fastest server
+// completed its upload (zero if none has yet)
+// Servers are only detached while at least minServers others are keeping up, so
+// detaching never costs the upload its quorum
+func (t *pipelineTracker) lagging(buffers []*bufferedPipe, finished, succeeded []atomic.Bool, firstSuccess time.Time, minServers int) []int {
+	var leader int64
+	positions := make([]int64, len(buffers))
+	healthy := make([]bool, len(buffers))
+	for i, bp := range buffers {
+		positions[i] = bp.Delivered()
+		healthy[i] = bp.GetError() == nil && (!finished[i].Load() || succeeded[i].Load())
+		if healthy[i] && positions[i] > leader {
+			leader = positions[i]
+		}
+	}
+
+	behind := make([]int, 0)
+	keepingUp := 0
+	for i, bp := range buffers {
+		if !healthy[i] {
+			continue
+		}
+		if succeeded[i].Load() {
+			keepingUp++
+			continue
+		}
+		// Bytes behind only applies while the body is still being sent; afterwards the
+		// server is only waited on for its response
+		drained := false
+		select {
+		case <-bp.Done():
+			drained = true
+		default:
+		}
+		if (t.detachBytes > 0 && !drained && leader-positions[i] > t.detachBytes) ||
+			(t.detachAfter > 0 && !firstSuccess.IsZero() && time.Since(firstSuccess) > t.detachAfter) {
+			behind = append(behind, i)
+		} else {
+			keepingUp++
+		}
+	}
+	if keepingUp < minServers {
+		return nil
+	}
+	return behind
+}
+
+// PipelineStats returns counters for the streaming upload pipelines
+func (m *Manager) PipelineStats() PipelineStats {
+	return PipelineStats{
+		Active:         m.pipelines.active.Load(),
+		Goroutines:     m.pipelines.goroutines.Load(),
+		Started:        m.pipelines.started.Load(),
+		WatchdogAborts: m.pipelines.watchdogAborts.Load(),
+		BodyStalls:     m.pipelines.bodyStalls.Load(),
+		SpilledBytes:   m.pipelines.spilledBytes.Load(),
+		Detached:       m.pipelines.detached.Load(),
+	}
+}
+
+// maxDelivered returns how many bytes the fastest server has received
+func maxDelivered(buffers []*bufferedPipe) int64 {
+	var leader int64
+	for _, bp := range buffers {
+		leader = max(leader, bp.Delivered())
+	}
+	return leader
+}
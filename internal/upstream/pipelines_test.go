@@ -0,0 +1,147 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/testutil"
+)
+
+// hangingUpstream starts a fake upstream that reads the whole upload body, then never
+// answers until the request is cancelled
+func hangingUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// slowUpstream starts a fake upstream reading upload bodies at about 50 KB/s until release
+// is closed, then draining what is left (so its handlers don't outlive the test)
+func slowUpstream(t *testing.T, release <-chan struct{}) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 1024)
+		for {
+			if _, err := r.Body.Read(buf); err != nil {
+				return
+			}
+			select {
+			case <-release:
+				io.Copy(io.Discard, r.Body)
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// waitForGoroutines waits until the pipeline goroutines are gone and the process is back
+// to baseline goroutines, failing the test if that takes too long
+func waitForGoroutines(t *testing.T, m *Manager, baseline int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pipeline, running := m.pipelines.goroutines.Load(), runtime.NumGoroutine()
+		if pipeline == 0 && running <= baseline {
+			return
+		}
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			buf = buf[:runtime.Stack(buf, true)]
+			t.Fatalf("goroutines leaked: %d pipeline goroutines, %d running (baseline %d)\n%s", pipeline, running, baseline, buf)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestUploadWatchdogCancelsHangingUpstream(t *testing.T) {
+	good := testutil.AnsweringUpstream(t)
+	hanging := hangingUpstream(t)
+	m := newTestManager(t, "  min_upload_servers: 1\n  upload_response_timeout: 200ms\n", good.URL, hanging.URL)
+	baseline := runtime.NumGoroutine()
+
+	start := time.Now()
+	results, err := m.UploadParallelStreamingTo(context.Background(), UploadTargets{}, bytes.NewReader(make([]byte, 64<<10)),
+		"application/octet-stream", 64<<10, map[string]string{}, 30*time.Second)
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("upload took %v, want the watchdog to cancel the hanging server after 200ms", elapsed)
+	}
+	if len(results) != 1 || results[0].ServerURL != good.URL {
+		t.Errorf("successful servers = %+v, want only %s", results, good.URL)
+	}
+	if aborts := m.pipelines.watchdogAborts.Load(); aborts != 1 {
+		t.Errorf("watchdog aborts = %d, want 1", aborts)
+	}
+	waitForGoroutines(t, m, baseline)
+}
+
+func TestUploadDetachesSlowUpstream(t *testing.T) {
+	good := testutil.AnsweringUpstream(t)
+	release := make(chan struct{})
+	slow := slowUpstream(t, release)
+	m := newTestManager(t, "  min_upload_servers: 1\n  slow_upstream_detach_after: 100ms\n", good.URL, slow.URL)
+	baseline := runtime.NumGoroutine()
+
+	start := time.Now()
+	results, err := m.UploadParallelStreamingTo(context.Background(), UploadTargets{}, bytes.NewReader(make([]byte, 1<<20)),
+		"application/octet-stream", 1<<20, map[string]string{}, 30*time.Second)
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("upload took %v, want the slow server detached", elapsed)
+	}
+	if len(results) != 1 || results[0].ServerURL != good.URL {
+		t.Errorf("successful servers = %+v, want only %s", results, good.URL)
+	}
+	if detached := m.pipelines.detached.Load(); detached != 1 {
+		t.Errorf("detached uploads = %d, want 1", detached)
+	}
+	close(release)
+	waitForGoroutines(t, m, baseline)
+}
+
+func TestBufferedPipeSpillLimit(t *testing.T) {
+	pipeReader, pipeWriter := io.Pipe()
+	spillDir := t.TempDir()
+	bp := newBufferedPipe(pipeWriter, "stalled", 1024, spillDir, 4096, logging.NewLevels(nil).Flag(logging.Upstream), nil)
+	go bp.drain()
+	defer bp.Abort(errPipelineClosed)
+
+	// Nobody reads the pipe: 1 KB fits in memory (or is held by the drainer), then the
+	// spill file grows until the next write would pass 4 KB
+	chunk := make([]byte, 512)
+	for i := 0; i < 20; i++ {
+		bp.Write(chunk)
+	}
+	if err := bp.GetError(); !errors.Is(err, ErrSpillLimit) {
+		t.Fatalf("pipe error = %v, want ErrSpillLimit", err)
+	}
+	if spilled := bp.Spilled(); spilled > 4096 {
+		t.Errorf("spilled %d bytes, want at most the 4096 byte limit", spilled)
+	}
+	if _, err := io.ReadAll(pipeReader); !errors.Is(err, ErrSpillLimit) {
+		t.Errorf("upstream read error = %v, want ErrSpillLimit", err)
+	}
+	if entries, _ := os.ReadDir(spillDir); len(entries) != 0 {
+		t.Errorf("spill directory holds %d files, want the spill file removed", len(entries))
+	}
+}
@@ -0,0 +1,107 @@
+package upstream
+
+import (
+	"context"
+	"sync"
+)
+
+// priorityRotation rotates selections among servers that share the lowest priority (or,
+// for the weighted strategy, among all of them) using smooth weighted round-robin: over time each server is picked in proportion to its
+// weight, and picks are interleaved rather than bunched
+type priorityRotation struct {
+	mu      sync.Mutex
+	current map[string]int // Accumulated weight per server URL
+}
+
+// selectPriorityIndex returns the index in serverURLs of the server to use: the group
+// with the lowest priority number is kept and rotated through by weight
+// Returns -1 if none of the URLs is a configured server
+func (m *Manager) selectPriorityIndex(serverURLs []string) int {
+	set := m.servers.Load()
+	bestPriority := int(^uint(0) >> 1) // Max int value
+	group := make([]int, 0, len(serverURLs))
+	weights := make([]int, 0, len(serverURLs))
+	for i, serverURL := range serverURLs {
+		idx := set.index(serverURL)
+		if idx < 0 {
+			continue
+		}
+		priority := set.serverPriorities[idx]
+		if priority < bestPriority {
+			bestPriority = priority
+			group = group[:0]
+			weights = weights[:0]
+		}
+		if priority == bestPriority {
+			group = append(group, i)
+			weights = append(weights, set.serverWeights[idx])
+		}
+	}
+	if len(group) == 0 {
+		return -1
+	}
+	if len(group) == 1 {
+		return group[0]
+	}
+
+	best := m.priorityRotation.pick(serverURLs, group, weights)
+	m.verbose.Debugf(context.Background(), "selectPriorityIndex: %d servers share priority %d, selected %s", len(group), bestPriority, serverURLs[best])
+	return best
+}
+
+// selectWeightedIndex returns the index in serverURLs of the server to use, rotating
+// through all of them by weight whatever their priority (the "weighted" strategy)
+// Servers not in the set (e.g. removed at runtime) get weight 1
+func (m *Manager) selectWeightedIndex(serverURLs []string) int {
+	if len(serverURLs) == 0 {
+		return -1
+	}
+	set := m.servers.Load()
+	group := make([]int, len(serverURLs))
+	weights := make([]int, len(serverURLs))
+	for i, serverURL := range serverURLs {
+		group[i] = i
+		weights[i] = 1
+		if idx := set.index(serverURL); idx >= 0 && set.serverWeights[idx] > 0 {
+			weights[i] = set.serverWeights[idx]
+		}
+	}
+	if len(group) == 1 {
+		return 0
+	}
+
+	best := m.weightedRotation.pick(serverURLs, group, weights)
+	m.verbose.Debugf(context.Background(), "selectWeightedIndex: selected %s (weight %d) among %d servers", serverURLs[best], weights[best], len(serverURLs))
+	return best
+}
+
+// pick returns the index (one of group) of the next server: each server's accumulated
+// weight grows by its weight, and the one with the most is picked and set back by the total
+func (r *priorityRotation) pick(serverURLs []string, group []int, weights []int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current == nil {
+		r.current = make(map[string]int)
+	}
+
+	best, total := -1, 0
+	for j, i := range group {
+		r.current[serverURLs[i]] += weights[j]
+		total += weights[j]
+		if best < 0 || r.current[serverURLs[i]] > r.current[serverURLs[best]] {
+			best = i
+		}
+	}
+	r.current[serverURLs[best]] -= total
+	return best
+}
+
+// index returns the index of a server URL in the set, or -1
+func (set *upstreamSet) index(serverURL string) int {
+	for i, url := range set.serverURLs {
+		if url == serverURL {
+			return i
+		}
+	}
+	return -1
+}
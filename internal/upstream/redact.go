@@ -0,0 +1,72 @@
+package upstream
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/girino/blossom_espelhator/internal/config"
+)
+
+// upstreamRedactor replaces upstream URLs, alternative addresses and hostnames with opaque
+// labels (server-1, server-2, ... in configuration order) for public output
+type upstreamRedactor struct {
+	labels   map[string]string // Server URL -> label
+	replacer *strings.Replacer
+}
+
+// newUpstreamRedactor builds the redactor for the configured upstream servers
+func newUpstreamRedactor(servers []config.UpstreamServer) *upstreamRedactor {
+	type replacement struct {
+		old, label string
+	}
+	labels := make(map[string]string, len(servers))
+	replacements := make([]replacement, 0, 4*len(servers))
+	for i, server := range servers {
+		label := fmt.Sprintf("server-%d", i+1)
+		labels[server.URL] = label
+		for _, address := range []string{server.URL, server.AlternativeAddress} {
+			if address == "" {
+				continue
+			}
+			replacements = append(replacements, replacement{strings.TrimRight(address, "/"), label})
+			if parsed, err := url.Parse(address); err == nil && parsed.Host != "" {
+				replacements = append(replacements, replacement{parsed.Host, label})
+				if hostname := parsed.Hostname(); hostname != parsed.Host {
+					replacements = append(replacements, replacement{hostname, label})
+				}
+			}
+		}
+	}
+
+	// Longest first, so full URLs win over hostnames and "host:port" over "host"
+	sort.SliceStable(replacements, func(i, j int) bool {
+		return len(replacements[i].old) > len(replacements[j].old)
+	})
+	oldnew := make([]string, 0, 2*len(replacements))
+	for _, r := range replacements {
+		oldnew = append(oldnew, r.old, r.label)
+	}
+	return &upstreamRedactor{
+		labels:   labels,
+		replacer: strings.NewReplacer(oldnew...),
+	}
+}
+
+// ServerLabel returns the opaque label of an upstream server (e.g., "server-2")
+// Unknown URLs are redacted like any other text
+func (m *Manager) ServerLabel(serverURL string) string {
+	set := m.servers.Load()
+	if label, ok := set.redactor.labels[serverURL]; ok {
+		return label
+	}
+	return m.Redact(serverURL)
+}
+
+// Redact replaces every upstream URL, alternative address and hostname in s with the
+// server's label, so output can be shown publicly without revealing upstream hosts
+func (m *Manager) Redact(s string) string {
+	set := m.servers.Load()
+	return set.redactor.replacer.Replace(s)
+}
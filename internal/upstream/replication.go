@@ -0,0 +1,53 @@
+package upstream
+
+// ReplicationTarget returns the desired number of replicas for a blob
+// This is the configured replication_factor, capped at the number of servers
+// the blob's shard is assigned to (if sharding is configured)
+func (m *Manager) ReplicationTarget(hash string) int {
+	set := m.servers.Load()
+	target := m.replicationFactor
+	if target <= 0 {
+		target = len(set.serverURLs)
+	}
+
+	if targets, ok := m.ShardTargets(hash); ok && len(targets.ServerURLs) < target {
+		target = len(targets.ServerURLs)
+	}
+
+	return target
+}
+
+// ReplicationSummary aggregates replica counts for a set of known blobs
+type ReplicationSummary struct {
+	ReplicationFactor int         `json:"replication_factor"`
+	TrackedBlobs      int         `json:"tracked_blobs"`
+	FullyReplicated   int         `json:"fully_replicated"`
+	UnderReplicated   int         `json:"under_replicated"`
+	BelowMinimum      int         `json:"below_min_upload_servers"`
+	ReplicaHistogram  map[int]int `json:"replica_histogram"` // replica count -> number of blobs
+}
+
+// SummarizeReplication computes replica statistics for the given hash -> servers mapping
+func (m *Manager) SummarizeReplication(entries map[string][]string) ReplicationSummary {
+	summary := ReplicationSummary{
+		ReplicationFactor: m.replicationFactor,
+		ReplicaHistogram:  make(map[int]int),
+	}
+
+	for hash, servers := range entries {
+		replicas := len(servers)
+		summary.TrackedBlobs++
+		summary.ReplicaHistogram[replicas]++
+
+		if replicas >= m.ReplicationTarget(hash) {
+			summary.FullyReplicated++
+		} else {
+			summary.UnderReplicated++
+		}
+		if replicas < m.minUploadServers {
+			summary.BelowMinimum++
+		}
+	}
+
+	return summary
+}
@@ -0,0 +1,119 @@
+package upstream
+
+import (
+	"context"
+	"strings"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+)
+
+// RouteTargets returns the upload targets for a content type when content routes are configured
+// Returns the name of the matching route (its first type if unnamed), or false if no route
+// matches, in which case the upload goes to all servers (or its shard)
+func (m *Manager) RouteTargets(contentType string) (UploadTargets, string, bool) {
+	if len(m.contentRoutes) == 0 || contentType == "" {
+		return UploadTargets{}, "", false
+	}
+
+	for i := range m.contentRoutes {
+		route := &m.contentRoutes[i]
+		if route.Matches(contentType) {
+			name := route.Name
+			if name == "" {
+				name = route.Types[0]
+			}
+			m.verbose.Debugf(context.Background(), "RouteTargets: content type %s routed by %q (%d servers, min=%d)",
+				contentType, name, len(route.Servers), route.MinUploadServers)
+			return UploadTargets{
+				ServerURLs: route.Servers,
+				MinServers: route.MinUploadServers,
+			}, name, true
+		}
+	}
+	return UploadTargets{}, "", false
+}
+
+// SizeTargets returns the size rule matching a blob size, if size rules are configured
+// The rule's servers may be empty (all servers) and its quorum 0 (unchanged)
+// Returns false for unknown (negative) sizes or when no rule matches
+func (m *Manager) SizeTargets(size int64) (UploadTargets, string, bool) {
+	for i := range m.sizeRoutes {
+		rule := &m.sizeRoutes[i]
+		if rule.Matches(size) {
+			m.verbose.Debugf(context.Background(), "SizeTargets: size %d matches size route %q (%d servers, min=%d)",
+				size, rule.Name, len(rule.Servers), rule.MinUploadServers)
+			return UploadTargets{
+				ServerURLs: rule.Servers,
+				MinServers: rule.MinUploadServers,
+			}, rule.Name, true
+		}
+	}
+	return UploadTargets{}, "", false
+}
+
+// UploadTargetsFor returns the upload targets for a blob from its hash (sharding), content
+// type (content routes) and size (size routes), any of which may be unknown ("" or -1)
+// Each rule that applies narrows the servers down to those it has in common with the
+// previous ones (if there are none in common, the later rule's servers are used)
+// Shards and content routes keep the stricter quorum, while a size route's quorum overrides
+// it, so large blobs can be stored with fewer replicas
+// The returned route names (comma-separated) are empty when no content or size route applies
+func (m *Manager) UploadTargetsFor(hash string, contentType string, size int64) (UploadTargets, string) {
+	set := m.servers.Load()
+	targets := UploadTargets{}
+	if shardTargets, ok := m.ShardTargets(hash); ok {
+		targets = shardTargets
+	}
+
+	routes := make([]string, 0, 2)
+	if routeTargets, route, ok := m.RouteTargets(contentType); ok {
+		minServers := max(targets.MinServers, routeTargets.MinServers)
+		targets = m.narrowTargets(targets, routeTargets.ServerURLs, route)
+		targets.MinServers = minServers
+		routes = append(routes, route)
+	}
+	if sizeTargets, rule, ok := m.SizeTargets(size); ok {
+		targets = m.narrowTargets(targets, sizeTargets.ServerURLs, rule)
+		if sizeTargets.MinServers > 0 {
+			targets.MinServers = sizeTargets.MinServers
+		}
+		routes = append(routes, rule)
+	}
+
+	// The quorum can't exceed the number of servers left
+	available := len(targets.ServerURLs)
+	if available == 0 {
+		available = len(set.serverURLs)
+	}
+	if targets.MinServers > available || (targets.MinServers == 0 && m.minUploadServers > available) {
+		targets.MinServers = available
+	}
+	return targets, strings.Join(routes, ",")
+}
+
+// narrowTargets restricts targets to the given servers (empty means no restriction)
+// If they have no server in common, the given servers are used
+func (m *Manager) narrowTargets(targets UploadTargets, servers []string, rule string) UploadTargets {
+	if len(servers) == 0 {
+		return targets
+	}
+	if len(targets.ServerURLs) == 0 {
+		return UploadTargets{ServerURLs: servers, MinServers: targets.MinServers}
+	}
+
+	current := make(map[string]bool, len(targets.ServerURLs))
+	for _, serverURL := range targets.ServerURLs {
+		current[serverURL] = true
+	}
+	common := make([]string, 0, len(servers))
+	for _, serverURL := range servers {
+		if current[serverURL] {
+			common = append(common, serverURL)
+		}
+	}
+	if len(common) == 0 {
+		logging.Warnf(context.Background(), "UploadTargets: route %q has no server in common with the previous rules, using its servers", rule)
+		return UploadTargets{ServerURLs: servers, MinServers: targets.MinServers}
+	}
+	return UploadTargets{ServerURLs: common, MinServers: targets.MinServers}
+}
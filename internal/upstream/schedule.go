@@ -0,0 +1,84 @@
+package upstream
+
+import (
+	"context"
+	"time"
+)
+
+// IsInMaintenance reports whether the given server is currently inside one of its maintenance windows
+func (m *Manager) IsInMaintenance(serverURL string) bool {
+	set := m.servers.Load()
+	i := set.index(serverURL)
+	return i >= 0 && set.inMaintenance(i, time.Now())
+}
+
+// inMaintenance reports whether the server at index i is inside one of its maintenance windows at now
+func (set *upstreamSet) inMaintenance(i int, now time.Time) bool {
+	for j := range set.serverWindows[i] {
+		if set.serverWindows[i][j].Contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipInMaintenance removes the servers inside a maintenance window from the targets
+// (indices in set) of an upload or mirror, as long as the others can still reach minServers
+func (m *Manager) skipInMaintenance(ctx context.Context, set *upstreamSet, indices []int, minServers int) []int {
+	now := time.Now()
+	filtered := make([]int, 0, len(indices))
+	for _, i := range indices {
+		if !set.inMaintenance(i, now) {
+			filtered = append(filtered, i)
+		}
+	}
+
+	if len(filtered) == len(indices) || len(filtered) < minServers {
+		return indices
+	}
+
+	m.verbose.Debugf(ctx, "skipInMaintenance: skipped %d servers in maintenance window", len(indices)-len(filtered))
+	return filtered
+}
+
+// excludeInMaintenance removes servers that are inside a maintenance window
+// If every server is in maintenance, the original list is returned so requests can still be served
+func (m *Manager) excludeInMaintenance(availableServers []string) []string {
+	filtered := make([]string, 0, len(availableServers))
+	for _, serverURL := range availableServers {
+		if !m.IsInMaintenance(serverURL) {
+			filtered = append(filtered, serverURL)
+		}
+	}
+
+	if len(filtered) == 0 {
+		return availableServers
+	}
+
+	if m.verbose.Enabled() && len(filtered) < len(availableServers) {
+		m.verbose.Debugf(context.Background(), "excludeInMaintenance: excluded %d servers in maintenance window", len(availableServers)-len(filtered))
+	}
+
+	return filtered
+}
+
+// preferOutsideMaintenanceWithResponse removes upload results from servers inside a maintenance window
+// If every server is in maintenance, the original list is returned
+func (m *Manager) preferOutsideMaintenanceWithResponse(availableServers []UploadResultWithResponse) []UploadResultWithResponse {
+	filtered := make([]UploadResultWithResponse, 0, len(availableServers))
+	for _, srv := range availableServers {
+		if !m.IsInMaintenance(srv.ServerURL) {
+			filtered = append(filtered, srv)
+		}
+	}
+
+	if len(filtered) == 0 {
+		return availableServers
+	}
+
+	if m.verbose.Enabled() && len(filtered) < len(availableServers) {
+		m.verbose.Debugf(context.Background(), "preferOutsideMaintenanceWithResponse: deprioritized %d servers in maintenance window", len(availableServers)-len(filtered))
+	}
+
+	return filtered
+}
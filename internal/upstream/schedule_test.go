@@ -0,0 +1,68 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/internal/testutil"
+)
+
+func TestUploadSkipsServersInMaintenance(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		minServers int
+		wantSkip   bool
+	}{
+		{"others reach the quorum", 2, true},
+		{"needed for the quorum", 3, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			started := make(chan struct{}, 3)
+			srv1, _ := testutil.UploadingUpstream(t, started)
+			srv2, _ := testutil.UploadingUpstream(t, started)
+			windowed, uploads := testutil.UploadingUpstream(t, started)
+			// Two windows covering the whole day
+			cfg := testutil.LoadConfigYAML(t, fmt.Sprintf(`upstream_servers:
+  - url: %q
+  - url: %q
+  - url: %q
+    maintenance_windows:
+      - start: "00:00"
+        end: "12:00"
+      - start: "12:00"
+        end: "00:00"
+server:
+  min_upload_servers: %d
+`, srv1.URL, srv2.URL, windowed.URL, tc.minServers))
+			m, err := New(cfg, logging.NewLevels(nil))
+			if err != nil {
+				t.Fatalf("upstream manager: %v", err)
+			}
+
+			results, err := m.UploadParallelStreamingTo(context.Background(), UploadTargets{}, bytes.NewReader(make([]byte, 1024)),
+				"application/octet-stream", 1024, map[string]string{}, 10*time.Second)
+			if err != nil {
+				t.Fatalf("upload failed: %v", err)
+			}
+			for _, result := range results {
+				if tc.wantSkip && result.ServerURL == windowed.URL {
+					t.Errorf("uploaded to %s during its maintenance window", windowed.URL)
+				}
+			}
+			select {
+			case <-uploads:
+				if tc.wantSkip {
+					t.Errorf("%s received the upload during its maintenance window", windowed.URL)
+				}
+			default:
+				if !tc.wantSkip {
+					t.Errorf("%s skipped although it is needed for min_upload_servers", windowed.URL)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,420 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+
+	"github.com/girino/blossom_espelhator/internal/config"
+	"github.com/girino/blossom_espelhator/internal/logging"
+	"github.com/girino/blossom_espelhator/pkg/blossomclient"
+)
+
+// Errors returned by the runtime server management methods
+var (
+	ErrServerNotFound = errors.New("upstream server not found")
+	ErrServerExists   = errors.New("upstream server already exists")
+	ErrTooFewServers  = errors.New("too few upstream servers left")
+)
+
+// upstreamSet is the set of servers in rotation. It is never modified once published:
+// every request works on the set loaded when it started, so servers added, removed or
+// paused meanwhile don't shift its indexes. Only capabilities detected at runtime are
+// updated in place, under capabilityMu
+type upstreamSet struct {
+	clients            []*blossomclient.Client // HTTP clients with no timeout (timeouts controlled via context)
+	serverURLs         []string
+	serverPriorities   []int                 // Priority for each server (indexed same as clients/serverURLs)
+	serverWeights      []int                 // Weight within the server's priority group (indexed same as clients/serverURLs)
+	serverCapabilities []serverCapabilities  // Capabilities for each server (indexed same as clients/serverURLs)
+	serverWindows      [][]config.TimeWindow // Maintenance windows for each server (indexed same as clients/serverURLs)
+	redactor           *upstreamRedactor     // Replaces upstream hosts with labels in public output
+}
+
+// upstreamEntry is a configured server, or one added at runtime
+type upstreamEntry struct {
+	config       config.UpstreamServer
+	client       *blossomclient.Client
+	capabilities serverCapabilities // Capabilities while the server is out of rotation (the set holds the live ones)
+	paused       bool
+	added        bool // Added at runtime, not part of the configuration file
+}
+
+// ServerInfo describes an upstream server for the admin API
+type ServerInfo struct {
+	URL      string `json:"url"`
+	Priority int    `json:"priority"`
+	Weight   int    `json:"weight"`
+	Paused   bool   `json:"paused"`
+	Added    bool   `json:"added"` // Added at runtime: gone after a restart unless added to the configuration
+
+	Operator *Operator `json:"operator,omitempty"` // Whom to contact about the server (nil if not configured)
+}
+
+// newUpstreamEntry creates the client of a server from its configuration
+func newUpstreamEntry(server config.UpstreamServer, settings config.ServerConfig, clientVerbose *logging.Flag) (*upstreamEntry, error) {
+	// Create clients with no timeout - timeouts are controlled via context in each request
+	// This allows connection reuse and better performance
+	// Use alternative_address for connections if provided, otherwise use the official URL
+	// Connect, TLS handshake and response header phases have their own shorter timeouts
+	// so a server that accepts connections but never answers is detected quickly
+	cl := blossomclient.New(server.URL, server.AlternativeAddress, 0, false)
+	cl.SetDebugLogger(clientVerbose)
+	cl.SetTimeouts(blossomclient.Timeouts{
+		Dial:           settings.ConnectTimeout,
+		TLSHandshake:   settings.TLSHandshakeTimeout,
+		ResponseHeader: settings.ResponseHeaderTimeout,
+	})
+	if server.ForceHTTP1 {
+		cl.ForceHTTP1()
+	}
+	cl.SetMaxResponseBytes(settings.MaxUpstreamResponseBytes)
+	cl.SetIdentity(blossomclient.Identity{
+		UserAgent:              settings.UserAgent,
+		ForwardedBy:            settings.ForwardedBy,
+		ForwardClientUserAgent: settings.ForwardClientUserAgent,
+	})
+	if err := cl.SetCompression(server.Compression); err != nil {
+		return nil, fmt.Errorf("upstream server %s: %w", server.URL, err)
+	}
+	if server.RequiresContentLength {
+		cl.BufferUnknownLength(settings.UploadBufferDir)
+	}
+	cl.SetCircuitBreaker(settings.CircuitBreakerThreshold, settings.CircuitBreakerCooldown)
+	maxConcurrent, requestsPerSecond := settings.RequestBudgetFor(server)
+	cl.SetRequestBudget(blossomclient.Budget{
+		MaxConcurrent:     maxConcurrent,
+		RequestsPerSecond: requestsPerSecond,
+		QueueTimeout:      max(settings.UpstreamBudgetQueueTimeout, 0),
+	})
+
+	return &upstreamEntry{
+		config: server,
+		client: cl,
+		// Pointers default to nil if not set, but config.Load() sets defaults for mirror/upload_head
+		capabilities: serverCapabilities{
+			SupportsMirror:     server.SupportsMirror != nil && *server.SupportsMirror,
+			SupportsUploadHead: server.SupportsUploadHead != nil && *server.SupportsUploadHead,
+			List:               endpointSupportFromConfig(server.SupportsList),
+			Delete:             endpointSupportFromConfig(server.SupportsDelete),
+			Media:              endpointSupportFromConfig(server.SupportsMedia),
+		},
+	}, nil
+}
+
+// rebuildLocked publishes a new set made of the entries that aren't paused, keeping the
+// capabilities detected so far (must be called with serversMu held)
+func (m *Manager) rebuildLocked() {
+	m.capabilityMu.Lock()
+	defer m.capabilityMu.Unlock()
+
+	if previous := m.servers.Load(); previous != nil {
+		for _, entry := range m.entries {
+			if i := previous.index(entry.config.URL); i >= 0 {
+				entry.capabilities = previous.serverCapabilities[i]
+			}
+		}
+	}
+
+	// Labels follow the order of all servers, so pausing one doesn't relabel the others
+	configs := make([]config.UpstreamServer, 0, len(m.entries))
+	set := &upstreamSet{}
+	for _, entry := range m.entries {
+		configs = append(configs, entry.config)
+		if entry.paused {
+			continue
+		}
+		set.clients = append(set.clients, entry.client)
+		set.serverURLs = append(set.serverURLs, entry.config.URL)
+		set.serverPriorities = append(set.serverPriorities, entry.config.Priority)
+		set.serverWeights = append(set.serverWeights, entry.config.Weight)
+		set.serverCapabilities = append(set.serverCapabilities, entry.capabilities)
+		set.serverWindows = append(set.serverWindows, entry.config.MaintenanceWindows)
+	}
+	set.redactor = newUpstreamRedactor(configs)
+	m.servers.Store(set)
+}
+
+// entryLocked returns the entry of a server URL, or -1 (must be called with serversMu held)
+func (m *Manager) entryLocked(serverURL string) int {
+	for i, entry := range m.entries {
+		if entry.config.URL == serverURL {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkRotationLocked returns an error if fewer than min_upload_servers servers would be
+// left in rotation after taking one out (must be called with serversMu held)
+func (m *Manager) checkRotationLocked(serverURL string) error {
+	set := m.servers.Load()
+	remaining := len(set.serverURLs)
+	if set.index(serverURL) >= 0 {
+		remaining--
+	}
+	if remaining < m.minUploadServers || remaining == 0 {
+		return fmt.Errorf("%w: %s can't be taken out of rotation, min_upload_servers=%d", ErrTooFewServers, serverURL, m.minUploadServers)
+	}
+	return nil
+}
+
+// checkRemovalLocked returns an error if removing a server would leave fewer servers than
+// min_upload_servers in rotation, fewer than replication_factor in total, or a shard or
+// upload route without its own min_upload_servers (must be called with serversMu held)
+func (m *Manager) checkRemovalLocked(serverURL string) error {
+	if err := m.checkRotationLocked(serverURL); err != nil {
+		return err
+	}
+	if remaining := len(m.entries) - 1; remaining < m.replicationFactor {
+		return fmt.Errorf("%w: %s can't be removed, replication_factor=%d", ErrTooFewServers, serverURL, m.replicationFactor)
+	}
+
+	type serverGroup struct {
+		kind       string
+		name       string
+		servers    []string
+		minServers int
+	}
+	groups := make([]serverGroup, 0, len(m.shards)+len(m.contentRoutes)+len(m.sizeRoutes))
+	for _, shard := range m.shards {
+		groups = append(groups, serverGroup{"shard", shard.Name, shard.Servers, shard.MinUploadServers})
+	}
+	for _, route := range m.contentRoutes {
+		groups = append(groups, serverGroup{"content route", route.Name, route.Servers, route.MinUploadServers})
+	}
+	for _, rule := range m.sizeRoutes {
+		groups = append(groups, serverGroup{"size route", rule.Name, rule.Servers, rule.MinUploadServers})
+	}
+	for _, group := range groups {
+		remaining := len(m.entries) - 1 // Size routes without servers upload to all of them
+		if len(group.servers) > 0 {
+			if !slices.Contains(group.servers, serverURL) {
+				continue
+			}
+			remaining = 0
+			for _, groupServer := range group.servers {
+				if groupServer != serverURL && m.entryLocked(groupServer) >= 0 {
+					remaining++
+				}
+			}
+		}
+		if remaining < group.minServers {
+			return fmt.Errorf("%w: %s can't be removed, %s %q needs min_upload_servers=%d", ErrTooFewServers, serverURL, group.kind, group.name, group.minServers)
+		}
+	}
+	return nil
+}
+
+// SetServerObserver registers a function called after a server was added (added=true) or
+// removed at runtime, so the components tracking servers by URL follow the change
+// Must be called before the proxy serves requests
+func (m *Manager) SetServerObserver(observer func(serverURL string, added bool)) {
+	m.serverObserver = observer
+}
+
+// notifyServerChange calls the server observer, if any (must be called without serversMu)
+func (m *Manager) notifyServerChange(serverURL string, added bool) {
+	if m.serverObserver != nil {
+		m.serverObserver(serverURL, added)
+	}
+}
+
+// MaxFailuresFor returns the consecutive failure threshold for an operation on a server,
+// including servers added at runtime (see config.Config.MaxFailuresFor)
+func (m *Manager) MaxFailuresFor(serverURL string, opType string) int {
+	m.serversMu.Lock()
+	defer m.serversMu.Unlock()
+
+	if i := m.entryLocked(serverURL); i >= 0 {
+		return m.clientSettings.MaxFailuresFor(&m.entries[i].config, opType)
+	}
+	return m.clientSettings.MaxFailuresFor(nil, opType)
+}
+
+// Servers returns every upstream server, including paused ones, in configuration order
+func (m *Manager) Servers() []ServerInfo {
+	m.serversMu.Lock()
+	defer m.serversMu.Unlock()
+
+	servers := make([]ServerInfo, 0, len(m.entries))
+	for _, entry := range m.entries {
+		servers = append(servers, ServerInfo{
+			URL:      entry.config.URL,
+			Priority: entry.config.Priority,
+			Weight:   entry.config.Weight,
+			Paused:   entry.paused,
+			Added:    entry.added,
+			Operator: operatorOf(entry.config),
+		})
+	}
+	return servers
+}
+
+// AddServer adds an upstream server at runtime, put in rotation right away
+// Unset settings get the configuration defaults: weight 1, mirror and upload HEAD
+// unsupported, list and delete auto-detected; chaos settings are injected if chaos testing
+// is enabled
+func (m *Manager) AddServer(server config.UpstreamServer) error {
+	parsed, err := url.Parse(server.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid URL %q (expected an http or https URL)", server.URL)
+	}
+	if server.AlternativeAddress != "" {
+		parsed, err := url.Parse(server.AlternativeAddress)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid alternative address %q (expected an http or https URL)", server.AlternativeAddress)
+		}
+	}
+	if server.StatusPageURL != "" {
+		parsed, err := url.Parse(server.StatusPageURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid status page URL %q (expected an http or https URL)", server.StatusPageURL)
+		}
+	}
+	if server.Priority < 0 {
+		return fmt.Errorf("invalid priority %d (must be 0 or greater)", server.Priority)
+	}
+	if server.Weight < 0 {
+		return fmt.Errorf("invalid weight %d (must be 1 or greater)", server.Weight)
+	}
+	if server.Weight == 0 {
+		server.Weight = 1
+	}
+	if server.Compression == "" {
+		server.Compression = "auto"
+	}
+	if server.SupportsMirror == nil {
+		server.SupportsMirror = new(bool)
+	}
+	if server.SupportsUploadHead == nil {
+		server.SupportsUploadHead = new(bool)
+	}
+	if server.Chaos != nil {
+		if err := server.Chaos.Validate(); err != nil {
+			return fmt.Errorf("invalid chaos settings: %w", err)
+		}
+	}
+	if server.MaxFailures < 0 {
+		return fmt.Errorf("invalid max_failures %d (must not be negative)", server.MaxFailures)
+	}
+	if err := config.ValidateMaxFailuresByOperation(server.MaxFailuresByOperation); err != nil {
+		return fmt.Errorf("invalid max_failures_by_operation: %w", err)
+	}
+	server.MaintenanceWindows = slices.Clone(server.MaintenanceWindows)
+	for i := range server.MaintenanceWindows {
+		if err := server.MaintenanceWindows[i].Parse(); err != nil {
+			return fmt.Errorf("invalid maintenance_windows[%d]: %w", i, err)
+		}
+	}
+
+	entry, err := newUpstreamEntry(server, m.clientSettings, m.clientVerbose)
+	if err != nil {
+		return err
+	}
+	entry.added = true
+
+	if err := m.addEntry(entry); err != nil {
+		return err
+	}
+	m.notifyServerChange(server.URL, true)
+
+	log.Printf("Upstream server %s added (priority=%d, weight=%d)", server.URL, server.Priority, server.Weight)
+	return nil
+}
+
+// addEntry puts a new server in rotation, unless one with the same URL exists
+func (m *Manager) addEntry(entry *upstreamEntry) error {
+	m.serversMu.Lock()
+	defer m.serversMu.Unlock()
+
+	if m.entryLocked(entry.config.URL) >= 0 {
+		return fmt.Errorf("%w: %s", ErrServerExists, entry.config.URL)
+	}
+	if !m.injectChaosLocked(entry) && entry.config.Chaos != nil {
+		logging.Warnf(context.Background(), "Ignoring chaos section for %s (start with -enable-chaos to inject faults)", entry.config.URL)
+	}
+	m.entries = append(m.entries, entry)
+	m.rebuildLocked()
+	return nil
+}
+
+// RemoveServer removes an upstream server, paused or not. Requests already started keep
+// using it until they finish. Refuses to leave fewer than min_upload_servers servers in
+// rotation, fewer than replication_factor servers, or a shard without its quorum
+func (m *Manager) RemoveServer(serverURL string) error {
+	if err := m.removeEntry(serverURL); err != nil {
+		return err
+	}
+	m.notifyServerChange(serverURL, false)
+
+	log.Printf("Upstream server %s removed", serverURL)
+	return nil
+}
+
+// removeEntry drops a server from the entries and the rotation
+func (m *Manager) removeEntry(serverURL string) error {
+	m.serversMu.Lock()
+	defer m.serversMu.Unlock()
+
+	i := m.entryLocked(serverURL)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrServerNotFound, serverURL)
+	}
+	if err := m.checkRemovalLocked(serverURL); err != nil {
+		return err
+	}
+	m.entries = append(m.entries[:i:i], m.entries[i+1:]...)
+	m.rebuildLocked()
+
+	m.priorityRotation.mu.Lock()
+	delete(m.priorityRotation.current, serverURL)
+	m.priorityRotation.mu.Unlock()
+	return nil
+}
+
+// SetPaused takes an upstream server out of rotation (paused) or puts it back. A paused
+// server gets no requests but keeps its settings and detected capabilities
+// Refuses to leave fewer than min_upload_servers servers in rotation
+func (m *Manager) SetPaused(serverURL string, paused bool) error {
+	m.serversMu.Lock()
+	defer m.serversMu.Unlock()
+
+	i := m.entryLocked(serverURL)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrServerNotFound, serverURL)
+	}
+	if m.entries[i].paused == paused {
+		return nil
+	}
+	if paused {
+		if err := m.checkRotationLocked(serverURL); err != nil {
+			return err
+		}
+	}
+	m.entries[i].paused = paused
+	m.rebuildLocked()
+
+	if paused {
+		log.Printf("Upstream server %s paused", serverURL)
+	} else {
+		log.Printf("Upstream server %s resumed", serverURL)
+	}
+	return nil
+}
+
+// ServerConfigs returns the settings of every upstream server, including paused ones and
+// those added at runtime, in configuration order
+func (m *Manager) ServerConfigs() []config.UpstreamServer {
+	m.serversMu.Lock()
+	defer m.serversMu.Unlock()
+
+	configs := make([]config.UpstreamServer, 0, len(m.entries))
+	for _, entry := range m.entries {
+		configs = append(configs, entry.config)
+	}
+	return configs
+}
@@ -0,0 +1,105 @@
+package upstream
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/girino/blossom_espelhator/internal/config"
+)
+
+func TestAddServerSettings(t *testing.T) {
+	m := newTestManager(t, "  min_upload_servers: 1\n", "https://one.example.com")
+	type change struct {
+		url   string
+		added bool
+	}
+	var changes []change
+	m.SetServerObserver(func(serverURL string, added bool) {
+		changes = append(changes, change{serverURL, added})
+	})
+
+	// Two windows covering the whole day
+	err := m.AddServer(config.UpstreamServer{
+		URL:                    "https://added.example.com",
+		MaxFailures:            7,
+		MaxFailuresByOperation: map[string]int{"upload": 2},
+		MaintenanceWindows:     []config.TimeWindow{{Start: "00:00", End: "12:00"}, {Start: "12:00", End: "00:00"}},
+	})
+	if err != nil {
+		t.Fatalf("AddServer: %v", err)
+	}
+	if !m.IsInMaintenance("https://added.example.com") {
+		t.Error("maintenance window of the added server ignored")
+	}
+	if got := m.MaxFailuresFor("https://added.example.com", "upload"); got != 2 {
+		t.Errorf("upload threshold = %d, want the added server's 2", got)
+	}
+	if got := m.MaxFailuresFor("https://added.example.com", "download"); got != 7 {
+		t.Errorf("download threshold = %d, want the added server's 7", got)
+	}
+	if len(changes) != 1 || changes[0] != (change{"https://added.example.com", true}) {
+		t.Errorf("observer calls = %v, want the added server", changes)
+	}
+
+	for _, bad := range []config.UpstreamServer{
+		{URL: "https://bad-window.example.com", MaintenanceWindows: []config.TimeWindow{{Start: "25:00", End: "02:00"}}},
+		{URL: "https://bad-timezone.example.com", MaintenanceWindows: []config.TimeWindow{{Start: "01:00", End: "02:00", Timezone: "Nowhere/Town"}}},
+		{URL: "https://bad-operation.example.com", MaxFailuresByOperation: map[string]int{"teleport": 1}},
+		{URL: "https://bad-threshold.example.com", MaxFailures: -1},
+	} {
+		if err := m.AddServer(bad); err == nil {
+			t.Errorf("AddServer(%s) accepted invalid settings", bad.URL)
+		}
+	}
+	if len(m.Servers()) != 2 || len(changes) != 1 {
+		t.Errorf("rejected servers were added (%d servers, %d observer calls)", len(m.Servers()), len(changes))
+	}
+}
+
+func TestRemoveServerKeepsTargetsSatisfiable(t *testing.T) {
+	a, b, c := "https://a.example.com", "https://b.example.com", "https://c.example.com"
+
+	t.Run("replication_factor", func(t *testing.T) {
+		m := newTestManager(t, "  min_upload_servers: 1\n  replication_factor: 3\n", a, b, c)
+		if err := m.RemoveServer(c); !errors.Is(err, ErrTooFewServers) || !strings.Contains(err.Error(), "replication_factor") {
+			t.Errorf("RemoveServer error = %v, want replication_factor unsatisfiable", err)
+		}
+	})
+
+	t.Run("shard", func(t *testing.T) {
+		m := newTestManager(t, `  min_upload_servers: 1
+  replication_factor: 1
+  shards:
+    - name: "low"
+      prefixes: ["0-7"]
+      servers: ["`+a+`", "`+b+`"]
+      min_upload_servers: 2
+    - name: "high"
+      prefixes: ["8-f"]
+      servers: ["`+c+`"]
+`, a, b, c)
+		if err := m.RemoveServer(a); !errors.Is(err, ErrTooFewServers) || !strings.Contains(err.Error(), `"low"`) {
+			t.Errorf("RemoveServer error = %v, want shard low's quorum unsatisfiable", err)
+		}
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		m := newTestManager(t, "  min_upload_servers: 1\n  replication_factor: 2\n", a, b, c)
+		var removed []string
+		m.SetServerObserver(func(serverURL string, added bool) {
+			if !added {
+				removed = append(removed, serverURL)
+			}
+		})
+		if err := m.RemoveServer(c); err != nil {
+			t.Fatalf("RemoveServer: %v", err)
+		}
+		if len(removed) != 1 || removed[0] != c {
+			t.Errorf("observer removals = %v, want [%s]", removed, c)
+		}
+		if err := m.RemoveServer(b); !errors.Is(err, ErrTooFewServers) {
+			t.Errorf("RemoveServer error = %v, want replication_factor=2 kept", err)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package upstream
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// settlingUpload records the servers that accepted a recent upload
+type settlingUpload struct {
+	servers []string
+	until   time.Time
+}
+
+// settlingTracker remembers recent uploads during their settling period
+// Some upstreams accept a PUT (or reply 202 Accepted) before the blob is readable, so for
+// a short while after an upload their 404s are not trusted
+type settlingTracker struct {
+	mu         sync.Mutex
+	period     time.Duration
+	retries    int
+	retryDelay time.Duration
+	uploads    map[string]settlingUpload // keyed by hash
+}
+
+// MarkUploaded starts the settling period for a hash that was just uploaded to servers
+func (m *Manager) MarkUploaded(hash string, servers []string) {
+	if m.settling.period <= 0 || len(servers) == 0 {
+		return
+	}
+
+	m.settling.mu.Lock()
+	defer m.settling.mu.Unlock()
+	if m.settling.uploads == nil {
+		m.settling.uploads = make(map[string]settlingUpload)
+	}
+
+	// Drop expired entries while we hold the lock
+	now := time.Now()
+	for h, upload := range m.settling.uploads {
+		if now.After(upload.until) {
+			delete(m.settling.uploads, h)
+		}
+	}
+
+	m.settling.uploads[hash] = settlingUpload{
+		servers: append([]string(nil), servers...),
+		until:   now.Add(m.settling.period),
+	}
+	m.verbose.Debugf(context.Background(), "MarkUploaded: %s settling on %d servers until %s", hash, len(servers), now.Add(m.settling.period).Format(time.RFC3339))
+}
+
+// SettlingServers returns the servers confirmed by the upload response if the hash is
+// still within its settling period, or nil otherwise
+func (m *Manager) SettlingServers(hash string) []string {
+	m.settling.mu.Lock()
+	defer m.settling.mu.Unlock()
+	upload, exists := m.settling.uploads[hash]
+	if !exists {
+		return nil
+	}
+	if time.Now().After(upload.until) {
+		delete(m.settling.uploads, hash)
+		return nil
+	}
+	return upload.servers
+}
+
+// PreferSettled narrows servers to those confirmed by a recent upload response, if any
+// of them are present; otherwise servers is returned unchanged
+func (m *Manager) PreferSettled(hash string, servers []string) []string {
+	confirmed := m.SettlingServers(hash)
+	if len(confirmed) == 0 {
+		return servers
+	}
+
+	confirmedSet := make(map[string]bool, len(confirmed))
+	for _, serverURL := range confirmed {
+		confirmedSet[serverURL] = true
+	}
+	preferred := make([]string, 0, len(servers))
+	for _, serverURL := range servers {
+		if confirmedSet[serverURL] {
+			preferred = append(preferred, serverURL)
+		}
+	}
+	if len(preferred) == 0 {
+		return servers
+	}
+	return preferred
+}
+
+// CheckPathOnServersSettled looks up path like CheckPathOnServersCoalesced, taking the settling
+// period of recent uploads into account. While the hash is settling, the lookup is retried if
+// none of the confirmed servers report the blob yet, and if they still don't, the confirmed
+// servers are returned anyway (their 404s are ignored)
+// settling reports whether the hash was settling; such results are incomplete and should not be cached
+func (m *Manager) CheckPathOnServersSettled(ctx context.Context, path string, timeout time.Duration) (result CheckPathOnServersResult, settling bool) {
+	result = m.CheckPathOnServersCoalesced(ctx, path, timeout)
+
+	confirmed := m.SettlingServers(path[:64])
+	if len(confirmed) == 0 {
+		return result, false
+	}
+
+	hasConfirmed := func(servers []string) bool {
+		for _, serverURL := range servers {
+			for _, confirmedURL := range confirmed {
+				if serverURL == confirmedURL {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	for attempt := 0; attempt < m.settling.retries && !hasConfirmed(result.Servers); attempt++ {
+		m.verbose.Debugf(ctx, "CheckPathOnServersSettled: %s is settling and not yet visible on confirmed servers, retrying (%d/%d)", path, attempt+1, m.settling.retries)
+		select {
+		case <-ctx.Done():
+			return result, true
+		case <-time.After(m.settling.retryDelay):
+		}
+		result = m.CheckPathOnServersCoalesced(ctx, path, timeout)
+	}
+
+	if len(result.Servers) == 0 {
+		m.verbose.Debugf(ctx, "CheckPathOnServersSettled: ignoring 404s for settling %s, using %d servers confirmed by upload", path, len(confirmed))
+		result.Servers = append([]string(nil), confirmed...)
+	}
+	return result, true
+}
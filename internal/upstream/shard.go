@@ -0,0 +1,47 @@
+package upstream
+
+import (
+	"context"
+)
+
+// ShardTargets returns the upload targets for a blob hash when sharding is configured
+// Returns false if sharding is disabled, the hash is unknown, or no shard matches it,
+// in which case the blob should be replicated to all servers
+func (m *Manager) ShardTargets(hash string) (UploadTargets, bool) {
+	if len(m.shards) == 0 || len(hash) != 64 {
+		return UploadTargets{}, false
+	}
+
+	for i := range m.shards {
+		shard := &m.shards[i]
+		if shard.Matches(hash) {
+			m.verbose.Debugf(context.Background(), "ShardTargets: hash %s assigned to shard %q (%d servers, min=%d)",
+				hash, shard.Name, len(shard.Servers), shard.MinUploadServers)
+			return UploadTargets{
+				ServerURLs: shard.Servers,
+				MinServers: shard.MinUploadServers,
+			}, true
+		}
+	}
+
+	m.verbose.Debugf(context.Background(), "ShardTargets: hash %s does not match any shard, replicating to all servers", hash)
+	return UploadTargets{}, false
+}
+
+// TargetServerURLs returns the server URLs covered by the given targets
+func (m *Manager) TargetServerURLs(targets UploadTargets) []string {
+	set := m.servers.Load()
+	indices, _ := m.resolveTargets(set, targets)
+	urls := make([]string, 0, len(indices))
+	for _, i := range indices {
+		urls = append(urls, set.serverURLs[i])
+	}
+	return urls
+}
+
+// MinServersFor returns the minimum number of successful uploads required for the given targets
+func (m *Manager) MinServersFor(targets UploadTargets) int {
+	set := m.servers.Load()
+	_, minServers := m.resolveTargets(set, targets)
+	return minServers
+}
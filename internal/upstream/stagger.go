@@ -0,0 +1,22 @@
+package upstream
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// staggerStart delays one request of a parallel fan-out by a random jitter below
+// fanoutJitter, so bursts of lookups and uploads don't reach every upstream at the same
+// instant. Returns early if ctx ends; the request then fails on its own context
+func (m *Manager) staggerStart(ctx context.Context) {
+	if m.fanoutJitter <= 0 {
+		return
+	}
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(m.fanoutJitter))))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,36 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+)
+
+// SelectServerURLSticky selects a server URL by rendezvous hashing of key (e.g. client
+// address and blob hash) with each server URL: the same key always gets the same server
+// while it is available, different keys are spread evenly across servers, and a server
+// going away only moves the keys it had
+func (m *Manager) SelectServerURLSticky(availableServers []string, key string) (string, error) {
+	if len(availableServers) == 0 {
+		return "", fmt.Errorf("no available servers")
+	}
+
+	// Exclude servers in a maintenance window (unless all of them are)
+	availableServers = m.excludeInMaintenance(availableServers)
+
+	var selected string
+	var best uint64
+	for _, serverURL := range availableServers {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(serverURL))
+		if score := h.Sum64(); selected == "" || score > best {
+			selected, best = serverURL, score
+		}
+	}
+
+	m.verbose.Debugf(context.Background(), "SelectServerURL: sticky, available=%d servers, selected=%s", len(availableServers), selected)
+
+	return selected, nil
+}
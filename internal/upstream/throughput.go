@@ -0,0 +1,92 @@
+package upstream
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// minThroughputSampleBytes is the smallest transfer recorded as a throughput sample; below
+// it the duration is dominated by latency and says little about bandwidth
+const minThroughputSampleBytes = 64 * 1024
+
+// SetThroughputRecorder sets the function receiving throughput samples of successful uploads and mirrors
+func (m *Manager) SetThroughputRecorder(recorder func(serverURL string, opType string, bytes int64, duration time.Duration)) {
+	m.recordThroughput = recorder
+}
+
+// SetThroughputGetter sets the function returning a server's rolling throughput for the
+// throughput_aware strategy
+func (m *Manager) SetThroughputGetter(getter func(serverURL string, opType string) float64) {
+	m.getThroughput = getter
+}
+
+// observeThroughput records a throughput sample for a successful transfer
+func (m *Manager) observeThroughput(serverURL string, opType string, bytes int64, duration time.Duration) {
+	if m.recordThroughput == nil || bytes < minThroughputSampleBytes {
+		return
+	}
+	m.recordThroughput(serverURL, opType, bytes, duration)
+}
+
+// selectThroughputIndex picks one of serverURLs at random, weighted by measured upload
+// throughput, so faster servers are chosen more often without sending everything to a
+// single one. Servers not measured yet get the average weight of the others so they are
+// still tried; returns -1 if no server has been measured (callers fall back to round-robin)
+// Upload throughput is used for downloads too: it's the only bandwidth the proxy measures
+func (m *Manager) selectThroughputIndex(serverURLs []string) int {
+	if m.getThroughput == nil {
+		return -1
+	}
+
+	weights := make([]float64, len(serverURLs))
+	var measured, total float64
+	for i, serverURL := range serverURLs {
+		weights[i] = m.getThroughput(serverURL, "upload")
+		if weights[i] > 0 {
+			measured++
+			total += weights[i]
+		}
+	}
+	if measured == 0 {
+		return -1
+	}
+
+	average := total / measured
+	for i := range weights {
+		if weights[i] <= 0 {
+			weights[i] = average
+			total += average
+		}
+	}
+
+	pick := rand.Float64() * total
+	for i, weight := range weights {
+		pick -= weight
+		if pick < 0 {
+			m.verbose.Debugf(context.Background(), "selectThroughputIndex: selected %s (%.0f of %.0f bytes/s total)", serverURLs[i], weight, total)
+			return i
+		}
+	}
+	return len(serverURLs) - 1
+}
+
+// selectThroughputAwareWithResponse selects an upload/mirror response server weighted by throughput
+func (m *Manager) selectThroughputAwareWithResponse(availableServers []UploadResultWithResponse) *UploadResultWithResponse {
+	serverURLs := make([]string, len(availableServers))
+	for i, srv := range availableServers {
+		serverURLs[i] = srv.ServerURL
+	}
+	if i := m.selectThroughputIndex(serverURLs); i >= 0 {
+		return &availableServers[i]
+	}
+	return m.selectRoundRobinWithResponse(availableServers)
+}
+
+// selectThroughputAware selects a redirect server weighted by throughput
+func (m *Manager) selectThroughputAware(availableServers []string) string {
+	if i := m.selectThroughputIndex(availableServers); i >= 0 {
+		return availableServers[i]
+	}
+	return m.selectRoundRobin(availableServers)
+}
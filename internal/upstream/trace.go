@@ -0,0 +1,71 @@
+package upstream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/girino/blossom_espelhator/internal/tracing"
+)
+
+// UpstreamTiming is how long one request to an upstream server took
+type UpstreamTiming struct {
+	ServerURL string
+	Operation string // upload, mirror, head or list
+	Duration  time.Duration
+	Err       error // nil if the request succeeded (a HEAD that didn't find the blob succeeded)
+}
+
+// RequestTrace collects the upstream requests made on behalf of one client request,
+// including failed and timed out ones, so slow requests can be broken down per upstream
+type RequestTrace struct {
+	mu        sync.Mutex
+	upstreams []UpstreamTiming
+}
+
+type traceKey struct{}
+
+// WithRequestTrace returns a context that makes the Manager record the upstream requests
+// made with it in trace
+func WithRequestTrace(ctx context.Context, trace *RequestTrace) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+// Upstreams returns the upstream requests recorded so far
+func (t *RequestTrace) Upstreams() []UpstreamTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]UpstreamTiming(nil), t.upstreams...)
+}
+
+// SetLatencyRecorder sets the function receiving the latency of successful upstream requests
+func (m *Manager) SetLatencyRecorder(recorder func(serverURL string, opType string, duration time.Duration)) {
+	m.recordLatency = recorder
+}
+
+// observeRequest records a finished upstream request in the context's trace, as a span
+// of the client request if it is traced with OpenTelemetry and, if it succeeded, as a
+// latency sample
+func (m *Manager) observeRequest(ctx context.Context, serverURL string, operation string, duration time.Duration, err error) {
+	traceUpstream(ctx, serverURL, operation, duration, err)
+	tracing.RecordUpstream(ctx, serverURL, operation, duration, err)
+	if err == nil && m.recordLatency != nil {
+		m.recordLatency(serverURL, operation, duration)
+	}
+}
+
+// traceUpstream records an upstream request in the context's trace, if any
+func traceUpstream(ctx context.Context, serverURL string, operation string, duration time.Duration, err error) {
+	trace, ok := ctx.Value(traceKey{}).(*RequestTrace)
+	if !ok || trace == nil {
+		return
+	}
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	trace.upstreams = append(trace.upstreams, UpstreamTiming{
+		ServerURL: serverURL,
+		Operation: operation,
+		Duration:  duration,
+		Err:       err,
+	})
+}
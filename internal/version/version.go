@@ -0,0 +1,11 @@
+// Package version holds the build version, set at build time with
+// -ldflags "-X github.com/girino/blossom_espelhator/internal/version.Version=v1.2.3"
+package version
+
+// Version is the build version ("dev" for builds without ldflags)
+var Version = "dev"
+
+// UserAgent returns the default User-Agent sent to upstream servers
+func UserAgent() string {
+	return "blossom_espelhator/" + Version
+}
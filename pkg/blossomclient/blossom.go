@@ -0,0 +1,559 @@
+package blossomclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client is an HTTP client for communicating with Blossom servers
+type Client struct {
+	httpClient   *http.Client
+	mirrorClient *http.Client // Used for mirror requests if set (see SetTimeouts)
+	baseURL      string       // Used for building URLs in responses
+	connectURL   string       // Used for actual HTTP connections (if set, otherwise uses baseURL)
+	verbose      DebugLogger
+
+	// Transport settings (see SetTimeouts, ForceHTTP1 and SetCompression) and connection statistics
+	timeouts    Timeouts
+	forceHTTP1  bool
+	compression string // Response compression policy (see SetCompression)
+
+	// Response body size limit (see SetMaxResponseBytes)
+	maxResponseBytes int64
+
+	// Identification headers added to every request (see SetIdentity)
+	identity Identity
+
+	// Skips the server after repeated timeouts (see SetCircuitBreaker, nil = disabled)
+	breaker *circuitBreaker
+	// Caps the requests sent to the server (see SetRequestBudget, nil = unlimited)
+	budget *requestBudget
+	conns  *connTracker
+
+	// Spool unknown-length upload bodies to disk to send a Content-Length (see BufferUnknownLength)
+	bufferUnknownLength bool
+	bufferDir           string
+}
+
+// New creates a new Blossom client
+// baseURL is the official URL used for building URLs in responses
+// connectURL is an optional alternative address for actual connections (if empty, uses baseURL)
+func New(baseURL string, connectURL string, timeout time.Duration, verbose bool) *Client {
+	conns := newConnTracker()
+	client := &Client{
+		httpClient: &http.Client{Timeout: timeout},
+		baseURL:    baseURL,
+		verbose:    slogDebugLogger{on: verbose},
+		conns:      conns,
+	}
+	client.httpClient.Transport = &budgetTransport{
+		base: &breakerTransport{
+			base: &identityTransport{
+				base:   &trackingTransport{base: http.DefaultTransport, tracker: conns},
+				client: client,
+			},
+			client: client,
+		},
+		client: client,
+	}
+
+	// If connectURL is provided, use it; otherwise use baseURL for connections
+	if connectURL != "" {
+		client.connectURL = connectURL
+	} else {
+		client.connectURL = baseURL
+	}
+
+	return client
+}
+
+// WrapTransport replaces the client's transport with wrap(current transport)
+// Used to inject behaviour such as chaos testing faults into every request
+func (c *Client) WrapTransport(wrap func(http.RoundTripper) http.RoundTripper) {
+	c.httpClient.Transport = wrap(c.httpClient.Transport)
+	if c.mirrorClient != nil {
+		c.mirrorClient.Transport = wrap(c.mirrorClient.Transport)
+	}
+}
+
+// getConnectURL returns the URL to use for making HTTP connections
+// It replaces the hostname in baseURL with the hostname from connectURL.
+// Trims trailing slashes from the base and ensures path has one leading slash to avoid duplication.
+func (c *Client) getConnectURL(path string) (string, error) {
+	path = "/" + strings.TrimLeft(path, "/")
+	if c.connectURL == c.baseURL {
+		base := strings.TrimRight(c.baseURL, "/")
+		return base + path, nil
+	}
+
+	// Parse the connect URL
+	connectParsed, err := url.Parse(c.connectURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse connectURL: %w", err)
+	}
+
+	// Build the connection URL using the connectURL's scheme and host, with the provided path
+	base := strings.TrimRight(connectParsed.Host, "/")
+	connectURL := fmt.Sprintf("%s://%s%s", connectParsed.Scheme, base, path)
+
+	return connectURL, nil
+}
+
+// Upload uploads a blob to the Blossom server
+// The request should include the file data and Nostr event in the body
+// contentLength should be set if known (>= 0), otherwise -1 to use chunked encoding
+// (or to spool the body first, see BufferUnknownLength)
+// Returns the response body on success
+func (c *Client) Upload(ctx context.Context, body io.Reader, contentType string, contentLength int64, headers map[string]string) ([]byte, error) {
+	responseBody, _, err := c.UploadWithStatus(ctx, body, contentType, contentLength, headers)
+	return responseBody, err
+}
+
+// UploadWithStatus is like Upload but also returns the HTTP status code of the response
+// (e.g., 202 Accepted when the server queued the blob for asynchronous processing)
+func (c *Client) UploadWithStatus(ctx context.Context, body io.Reader, contentType string, contentLength int64, headers map[string]string) ([]byte, int, error) {
+	connectURL, err := c.getConnectURL("/upload")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	c.verbose.Debugf(ctx, "Client.Upload: %s (connect via %s) - method=PUT, content-type=%s, content-length=%d", c.baseURL, connectURL, contentType, contentLength)
+	c.verbose.Debugf(ctx, "Client.Upload: headers=%v", headers)
+
+	// Servers that can't accept chunked uploads get the body from a spool file instead
+	if contentLength < 0 && c.bufferUnknownLength {
+		f, size, cleanup, err := c.spoolBody(body)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer cleanup()
+		body = f
+		contentLength = size
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", connectURL, body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set Content-Length explicitly if provided
+	// This prevents Go from using chunked transfer encoding, which some upstream servers reject
+	if contentLength >= 0 {
+		req.ContentLength = contentLength
+		req.Header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
+		c.verbose.Debugf(ctx, "Client.Upload: set Content-Length to %d", contentLength)
+	} else if c.verbose.Enabled() {
+		c.verbose.Debugf(ctx, "Client.Upload: Content-Length not provided (value=%d), will use chunked encoding", contentLength)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	// Copy additional headers (e.g., Nostr event headers)
+	// Skip Accept-Encoding, which follows the client's compression policy
+	for k, v := range headers {
+		if strings.ToLower(k) != "accept-encoding" {
+			req.Header.Set(k, v)
+		}
+	}
+	c.setAcceptEncoding(req)
+
+	c.verbose.Debugf(ctx, "Client.Upload: sending request to %s", connectURL)
+
+	startTime := time.Now()
+	resp, err := c.httpClient.Do(req)
+	duration := time.Since(startTime)
+
+	if err != nil {
+		c.verbose.Debugf(ctx, "Client.Upload: request failed after %v: %v", duration, err)
+		return nil, 0, fmt.Errorf("upload request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	c.verbose.Debugf(ctx, "Client.Upload: response received after %v - status=%d, headers=%v", duration, resp.StatusCode, resp.Header)
+
+	// Read response body (decompressed by Go's http client or readBody)
+	bodyBytes, err := c.readBody(resp)
+	if err != nil {
+		c.verbose.Debugf(ctx, "Client.Upload: failed to read response body: %v", err)
+		if !errors.Is(err, ErrResponseTooLarge) {
+			bodyBytes = nil
+		}
+	}
+
+	// Accept 200, 201, and 202 as success status codes
+	// 200 = OK, 201 = Created, 202 = Accepted (queued for processing)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusAccepted {
+		bodyStr := string(bodyBytes)
+		if bodyStr == "" {
+			bodyStr = "(empty response body)"
+		}
+		c.verbose.Debugf(ctx, "Client.Upload: upload failed - status=%d, body=%s", resp.StatusCode, bodyStr)
+		return nil, resp.StatusCode, NewHTTPError(resp.StatusCode, bodyStr)
+	}
+
+	// A success response too large for a blob descriptor counts as a failed upload
+	if errors.Is(err, ErrResponseTooLarge) {
+		return nil, resp.StatusCode, err
+	}
+
+	c.verbose.Debugf(ctx, "Client.Upload: upload successful, response body: %s", string(bodyBytes))
+
+	return bodyBytes, resp.StatusCode, nil
+}
+
+// Download checks if a blob exists at the server (returns the URL)
+// Returns the official baseURL, not the connection URL
+func (c *Client) Download(ctx context.Context, hash string) (string, error) {
+	connectURL, err := c.getConnectURL(fmt.Sprintf("/%s", hash))
+	if err != nil {
+		return "", err
+	}
+
+	// Return the official URL, not the connection URL
+	officialURL := fmt.Sprintf("%s/%s", c.baseURL, hash)
+
+	c.verbose.Debugf(ctx, "Client.Download: checking %s (connect via %s) for hash %s", c.baseURL, connectURL, hash)
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", connectURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.verbose.Debugf(ctx, "Client.Download: request failed: %v", err)
+		return "", fmt.Errorf("download check failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	c.verbose.Debugf(ctx, "Client.Download: response status=%d", resp.StatusCode)
+
+	if resp.StatusCode == http.StatusOK {
+		return officialURL, nil
+	}
+
+	return "", fmt.Errorf("blob not found: status %d", resp.StatusCode)
+}
+
+// List retrieves the list of blobs for a given pubkey
+func (c *Client) List(ctx context.Context, pubkey string) ([]byte, error) {
+	connectURL, err := c.getConnectURL(fmt.Sprintf("/list/%s", pubkey))
+	if err != nil {
+		return nil, err
+	}
+
+	c.verbose.Debugf(ctx, "Client.List: listing blobs for pubkey %s on %s (connect via %s)", pubkey, c.baseURL, connectURL)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", connectURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	c.setAcceptEncoding(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.verbose.Debugf(ctx, "Client.List: request failed: %v", err)
+		return nil, fmt.Errorf("list request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	c.verbose.Debugf(ctx, "Client.List: response status=%d", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewHTTPError(resp.StatusCode, "list failed")
+	}
+
+	body, err := c.readBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	c.verbose.Debugf(ctx, "Client.List: received %d bytes", len(body))
+
+	return body, nil
+}
+
+// Delete deletes a blob from the server
+func (c *Client) Delete(ctx context.Context, hash string, headers map[string]string) error {
+	connectURL, err := c.getConnectURL(fmt.Sprintf("/%s", hash))
+	if err != nil {
+		return err
+	}
+
+	c.verbose.Debugf(ctx, "Client.Delete: deleting hash %s from %s (connect via %s)", hash, c.baseURL, connectURL)
+	c.verbose.Debugf(ctx, "Client.Delete: headers=%v", headers)
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", connectURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Copy headers (e.g., authentication headers)
+	// Skip Accept-Encoding, which follows the client's compression policy
+	for k, v := range headers {
+		if strings.ToLower(k) != "accept-encoding" {
+			req.Header.Set(k, v)
+		}
+	}
+	c.setAcceptEncoding(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.verbose.Debugf(ctx, "Client.Delete: request failed: %v", err)
+		return fmt.Errorf("delete request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	c.verbose.Debugf(ctx, "Client.Delete: response status=%d", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		bodyBytes, _ := c.readBody(resp)
+		c.verbose.Debugf(ctx, "Client.Delete: delete failed - status=%d, body=%s", resp.StatusCode, string(bodyBytes))
+		return NewHTTPError(resp.StatusCode, fmt.Sprintf("delete failed: %s", string(bodyBytes)))
+	}
+
+	c.verbose.Debugf(ctx, "Client.Delete: delete successful")
+
+	return nil
+}
+
+// CheckHealth checks if the server is reachable
+func (c *Client) CheckHealth(ctx context.Context) error {
+	// Try to access a non-existent blob to check if server responds
+	connectURL, err := c.getConnectURL("/0000000000000000000000000000000000000000000000000000000000000000")
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", connectURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Any response (even 404) means the server is reachable
+	return nil
+}
+
+// GetBaseURL returns the base URL of the client
+func (c *Client) GetBaseURL() string {
+	return c.baseURL
+}
+
+// Head performs a HEAD request to check if a blob exists at the given path and returns the response
+// The path may include an extension (e.g., "hash.mp4")
+func (c *Client) Head(ctx context.Context, path string) (*http.Response, error) {
+	return c.HeadWithHeaders(ctx, path, nil)
+}
+
+// HeadWithHeaders performs a HEAD request for a blob with additional request headers
+// (e.g., Range, If-None-Match) and returns the response
+func (c *Client) HeadWithHeaders(ctx context.Context, path string, headers map[string]string) (*http.Response, error) {
+	connectURL, err := c.getConnectURL(fmt.Sprintf("/%s", path))
+	if err != nil {
+		return nil, err
+	}
+
+	c.verbose.Debugf(ctx, "Client.Head: checking %s (connect via %s) for path %s", c.baseURL, connectURL, path)
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", connectURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.verbose.Debugf(ctx, "Client.Head: request failed: %v", err)
+		return nil, fmt.Errorf("head request failed: %w", err)
+	}
+
+	c.verbose.Debugf(ctx, "Client.Head: response status=%d, headers=%v", resp.StatusCode, resp.Header)
+
+	return resp, nil
+}
+
+// Get performs a GET request for a blob at the given path and returns the response
+// The caller is responsible for closing the response body
+// The path may include an extension (e.g., "hash.mp4")
+func (c *Client) Get(ctx context.Context, path string) (*http.Response, error) {
+	return c.GetWithHeaders(ctx, path, nil)
+}
+
+// GetWithHeaders performs a GET request for a blob with additional request headers
+// (e.g., Range) and returns the response, whose body the caller must close
+func (c *Client) GetWithHeaders(ctx context.Context, path string, headers map[string]string) (*http.Response, error) {
+	connectURL, err := c.getConnectURL(fmt.Sprintf("/%s", path))
+	if err != nil {
+		return nil, err
+	}
+
+	c.verbose.Debugf(ctx, "Client.Get: fetching %s (connect via %s) for path %s", c.baseURL, connectURL, path)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", connectURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.verbose.Debugf(ctx, "Client.Get: request failed: %v", err)
+		return nil, fmt.Errorf("get request failed: %w", err)
+	}
+
+	c.verbose.Debugf(ctx, "Client.Get: response status=%d, content-length=%d", resp.StatusCode, resp.ContentLength)
+
+	return resp, nil
+}
+
+// HeadUpload performs a HEAD request to /upload to check upload requirements (BUD-06)
+// The request should include headers: X-SHA-256, X-Content-Length, X-Content-Type
+// Returns the HTTP response with headers including X-Reason if rejected
+func (c *Client) HeadUpload(ctx context.Context, headers map[string]string) (*http.Response, error) {
+	connectURL, err := c.getConnectURL("/upload")
+	if err != nil {
+		return nil, err
+	}
+
+	c.verbose.Debugf(ctx, "Client.HeadUpload: checking %s (connect via %s) with headers: %v", c.baseURL, connectURL, headers)
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", connectURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Copy headers (X-SHA-256, X-Content-Length, X-Content-Type, etc.)
+	// Skip Accept-Encoding to let Go's HTTP client handle it automatically
+	for k, v := range headers {
+		if strings.ToLower(k) != "accept-encoding" {
+			req.Header.Set(k, v)
+		}
+	}
+
+	c.verbose.Debugf(ctx, "Client.HeadUpload: sending HEAD request to %s", connectURL)
+
+	startTime := time.Now()
+	resp, err := c.httpClient.Do(req)
+	duration := time.Since(startTime)
+
+	if err != nil {
+		c.verbose.Debugf(ctx, "Client.HeadUpload: request failed after %v: %v", duration, err)
+		return nil, fmt.Errorf("head upload request failed: %w", err)
+	}
+
+	c.verbose.Debugf(ctx, "Client.HeadUpload: response received after %v - status=%d, headers=%v", duration, resp.StatusCode, resp.Header)
+
+	return resp, nil
+}
+
+// Mirror sends a PUT /mirror request to request mirroring of a blob (BUD-04)
+// The request body should contain blob metadata/hash
+// Headers should include authentication (Nostr event)
+// Returns the response body on success
+func (c *Client) Mirror(ctx context.Context, body io.Reader, contentType string, headers map[string]string) ([]byte, error) {
+	responseBody, _, err := c.MirrorWithStatus(ctx, body, contentType, headers)
+	return responseBody, err
+}
+
+// MirrorWithStatus is like Mirror but also returns the HTTP status code of the response
+// (e.g., 202 Accepted when the server queued the blob for asynchronous processing)
+func (c *Client) MirrorWithStatus(ctx context.Context, body io.Reader, contentType string, headers map[string]string) ([]byte, int, error) {
+	connectURL, err := c.getConnectURL("/mirror")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	c.verbose.Debugf(ctx, "Client.Mirror: %s (connect via %s) - method=PUT, content-type=%s", c.baseURL, connectURL, contentType)
+	c.verbose.Debugf(ctx, "Client.Mirror: headers=%v", headers)
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", connectURL, body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	// Copy additional headers (e.g., Nostr event headers)
+	// Skip Accept-Encoding, which follows the client's compression policy
+	for k, v := range headers {
+		if strings.ToLower(k) != "accept-encoding" {
+			req.Header.Set(k, v)
+		}
+	}
+	c.setAcceptEncoding(req)
+
+	c.verbose.Debugf(ctx, "Client.Mirror: sending request to %s", connectURL)
+
+	// The server only answers after fetching the blob, so don't apply the response header timeout
+	httpClient := c.httpClient
+	if c.mirrorClient != nil {
+		httpClient = c.mirrorClient
+	}
+	startTime := time.Now()
+	resp, err := httpClient.Do(req)
+	duration := time.Since(startTime)
+
+	if err != nil {
+		c.verbose.Debugf(ctx, "Client.Mirror: request failed after %v: %v", duration, err)
+		return nil, 0, fmt.Errorf("mirror request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	c.verbose.Debugf(ctx, "Client.Mirror: response received after %v - status=%d, headers=%v", duration, resp.StatusCode, resp.Header)
+
+	// Read response body (decompressed by Go's http client or readBody)
+	bodyBytes, err := c.readBody(resp)
+	if err != nil {
+		c.verbose.Debugf(ctx, "Client.Mirror: failed to read response body: %v", err)
+		if !errors.Is(err, ErrResponseTooLarge) {
+			bodyBytes = nil
+		}
+	}
+
+	// Accept 200, 201, and 202 as success status codes
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusAccepted {
+		bodyStr := string(bodyBytes)
+		if bodyStr == "" {
+			bodyStr = "(empty response body)"
+		}
+		c.verbose.Debugf(ctx, "Client.Mirror: mirror request failed - status=%d, body=%s", resp.StatusCode, bodyStr)
+		return nil, resp.StatusCode, NewHTTPError(resp.StatusCode, bodyStr)
+	}
+
+	// A success response too large for a blob descriptor counts as a failed mirror
+	if errors.Is(err, ErrResponseTooLarge) {
+		return nil, resp.StatusCode, err
+	}
+
+	c.verbose.Debugf(ctx, "Client.Mirror: mirror request successful, response body: %s", string(bodyBytes))
+
+	return bodyBytes, resp.StatusCode, nil
+}
+
+// UploadWithBody uploads using a byte slice body
+func (c *Client) UploadWithBody(ctx context.Context, body []byte, contentType string, headers map[string]string) ([]byte, error) {
+	return c.Upload(ctx, bytes.NewReader(body), contentType, int64(len(body)), headers)
+}
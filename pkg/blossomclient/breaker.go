@@ -0,0 +1,162 @@
+package blossomclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the server while its circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open: server skipped after repeated timeouts")
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"    // Requests go through
+	BreakerOpen     = "open"      // Requests fail immediately until the cool-down ends
+	BreakerHalfOpen = "half_open" // One probe request goes through; its outcome closes or reopens the breaker
+)
+
+// BreakerStatus describes a client's circuit breaker
+type BreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"` // Timeouts and connection errors in a row
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`  // When the breaker last opened (while not closed)
+	RetryAt             *time.Time `json:"retry_at,omitempty"`   // When the next probe is allowed (while open)
+	Opens               int64      `json:"opens"`                // Times the breaker opened
+	Rejected            int64      `json:"rejected"`             // Requests failed without contacting the server
+}
+
+// circuitBreaker stops sending requests to a server after threshold timeouts or connection
+// errors in a row, for cooldown; then a single probe request decides whether it recovered
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool // The half-open probe is in flight
+	opens    int64
+	rejected int64
+}
+
+// SetCircuitBreaker makes the client skip the server for cooldown once threshold requests
+// in a row failed with a timeout or a connection error (threshold 0 disables the breaker)
+// While the breaker is open, requests fail with ErrCircuitOpen without being sent. HTTP
+// error statuses don't count: the server answered
+func (c *Client) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	if threshold <= 0 {
+		c.breaker = nil
+		return
+	}
+	c.breaker = &circuitBreaker{threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+// BreakerStatus returns the state of the client's circuit breaker (nil if it has none)
+func (c *Client) BreakerStatus() *BreakerStatus {
+	if c.breaker == nil {
+		return nil
+	}
+	return c.breaker.status()
+}
+
+// allow reports whether a request may be sent; in half-open state only one probe is let through
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.state = BreakerHalfOpen
+	}
+	switch {
+	case b.state == BreakerClosed:
+		return true
+	case b.state == BreakerHalfOpen && !b.probing:
+		b.probing = true
+		return true
+	}
+	b.rejected++
+	return false
+}
+
+// record updates the breaker with the outcome of a request that was let through
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	probe := b.state == BreakerHalfOpen && b.probing
+	if probe {
+		b.probing = false
+	}
+
+	switch {
+	case err == nil:
+		b.failures = 0
+		b.state = BreakerClosed
+	case isUnreachable(err):
+		b.failures++
+		if probe || (b.state == BreakerClosed && b.failures >= b.threshold) {
+			b.state = BreakerOpen
+			b.openedAt = time.Now()
+			b.opens++
+		}
+	}
+	// Other errors (the caller gave up) say nothing about the server: a half-open breaker
+	// lets the next request probe instead
+}
+
+// status returns a snapshot of the breaker
+func (b *circuitBreaker) status() *BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := &BreakerStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Opens:               b.opens,
+		Rejected:            b.rejected,
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	if b.state == BreakerOpen {
+		retryAt := b.openedAt.Add(b.cooldown)
+		status.RetryAt = &retryAt
+	}
+	return status
+}
+
+// isUnreachable reports whether a request failed because the server timed out or couldn't
+// be connected to, rather than because the caller cancelled it
+func isUnreachable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr)
+}
+
+// breakerTransport fails requests immediately while the client's circuit breaker is open
+type breakerTransport struct {
+	base   http.RoundTripper
+	client *Client
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	breaker := t.client.breaker
+	if breaker == nil {
+		return t.base.RoundTrip(req)
+	}
+	if !breaker.allow() {
+		// The transport must always close the request body, even on error
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrCircuitOpen
+	}
+	resp, err := t.base.RoundTrip(req)
+	breaker.record(err)
+	return resp, err
+}
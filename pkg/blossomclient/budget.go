@@ -0,0 +1,224 @@
+package blossomclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBudgetExceeded is returned without contacting the server when a request found no
+// room in the client's request budget within its queue timeout
+var ErrBudgetExceeded = errors.New("request budget exceeded: too many requests to this server")
+
+// Budget caps the requests a client sends to its server
+type Budget struct {
+	MaxConcurrent     int           // Requests in flight at once, until their response body is closed (0 = unlimited)
+	RequestsPerSecond float64       // Requests started per second, with bursts of as many (0 = unlimited)
+	QueueTimeout      time.Duration // How long a request waits for room before failing (0 = fail fast)
+}
+
+// BudgetStatus describes a client's request budget
+type BudgetStatus struct {
+	MaxConcurrent     int     `json:"max_concurrent,omitempty"`
+	RequestsPerSecond float64 `json:"requests_per_second,omitempty"`
+	InFlight          int     `json:"in_flight"` // Requests holding a slot
+	Waiting           int64   `json:"waiting"`   // Requests waiting for room
+	Delayed           int64   `json:"delayed"`   // Requests that had to wait for room
+	Rejected          int64   `json:"rejected"`  // Requests failed without contacting the server
+}
+
+// requestBudget enforces a Budget: a semaphore for the concurrency ceiling and a token
+// bucket for the rate ceiling
+type requestBudget struct {
+	budget Budget
+	slots  chan struct{} // nil without concurrency ceiling
+
+	mu         sync.Mutex
+	tokens     float64 // May go negative: requests waiting for a token have reserved it
+	refilledAt time.Time
+
+	waiting  atomic.Int64
+	delayed  atomic.Int64
+	rejected atomic.Int64
+}
+
+// SetRequestBudget caps the requests sent to the server (a zero Budget removes the caps)
+// Requests beyond the caps wait up to budget.QueueTimeout for room, then fail with
+// ErrBudgetExceeded without being sent
+func (c *Client) SetRequestBudget(budget Budget) {
+	if budget.MaxConcurrent <= 0 && budget.RequestsPerSecond <= 0 {
+		c.budget = nil
+		return
+	}
+	b := &requestBudget{budget: budget, refilledAt: time.Now()}
+	if budget.MaxConcurrent > 0 {
+		b.slots = make(chan struct{}, budget.MaxConcurrent)
+	}
+	b.tokens = b.burst()
+	c.budget = b
+}
+
+// BudgetStatus returns the state of the client's request budget (nil if it has none)
+func (c *Client) BudgetStatus() *BudgetStatus {
+	b := c.budget
+	if b == nil {
+		return nil
+	}
+	return &BudgetStatus{
+		MaxConcurrent:     b.budget.MaxConcurrent,
+		RequestsPerSecond: b.budget.RequestsPerSecond,
+		InFlight:          len(b.slots),
+		Waiting:           b.waiting.Load(),
+		Delayed:           b.delayed.Load(),
+		Rejected:          b.rejected.Load(),
+	}
+}
+
+// burst is the number of requests the rate ceiling lets through at once
+func (b *requestBudget) burst() float64 {
+	return math.Max(1, b.budget.RequestsPerSecond)
+}
+
+// acquire waits for room for a request, until the queue timeout or the request's context
+// ends. Returns a function releasing the request's slot
+func (b *requestBudget) acquire(ctx context.Context) (func(), error) {
+	deadline := time.Now().Add(b.budget.QueueTimeout)
+	waited := false
+
+	if b.budget.RequestsPerSecond > 0 {
+		wait := b.reserveToken()
+		if wait > 0 {
+			if b.budget.QueueTimeout <= 0 || time.Now().Add(wait).After(deadline) {
+				b.returnToken()
+				b.rejected.Add(1)
+				return nil, ErrBudgetExceeded
+			}
+			waited = true
+			if err := b.sleep(ctx, wait); err != nil {
+				b.returnToken()
+				return nil, err
+			}
+		}
+	}
+
+	if b.slots != nil {
+		select {
+		case b.slots <- struct{}{}:
+		default:
+			remaining := time.Until(deadline)
+			if b.budget.QueueTimeout <= 0 || remaining <= 0 {
+				b.rejected.Add(1)
+				return nil, ErrBudgetExceeded
+			}
+			waited = true
+			b.waiting.Add(1)
+			timer := time.NewTimer(remaining)
+			select {
+			case b.slots <- struct{}{}:
+				timer.Stop()
+				b.waiting.Add(-1)
+			case <-timer.C:
+				b.waiting.Add(-1)
+				b.rejected.Add(1)
+				return nil, ErrBudgetExceeded
+			case <-ctx.Done():
+				timer.Stop()
+				b.waiting.Add(-1)
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	if waited {
+		b.delayed.Add(1)
+	}
+	if b.slots == nil {
+		return func() {}, nil
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-b.slots })
+	}, nil
+}
+
+// reserveToken takes a token from the bucket, and returns how long to wait until it is
+// actually available
+func (b *requestBudget) reserveToken() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst(), b.tokens+now.Sub(b.refilledAt).Seconds()*b.budget.RequestsPerSecond)
+	b.refilledAt = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.budget.RequestsPerSecond * float64(time.Second))
+}
+
+// returnToken gives back a token reserved by a request that isn't sent
+func (b *requestBudget) returnToken() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+}
+
+// sleep waits for d unless ctx ends first
+func (b *requestBudget) sleep(ctx context.Context, d time.Duration) error {
+	b.waiting.Add(1)
+	defer b.waiting.Add(-1)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// budgetTransport holds requests until the client's request budget has room for them
+// A request's slot is released when its response body is closed, or when it fails
+type budgetTransport struct {
+	base   http.RoundTripper
+	client *Client
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	budget := t.client.budget
+	if budget == nil {
+		return t.base.RoundTrip(req)
+	}
+	release, err := budget.acquire(req.Context())
+	if err != nil {
+		// The transport must always close the request body, even on error
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody releases a request's budget slot once the response body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
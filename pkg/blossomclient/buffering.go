@@ -0,0 +1,48 @@
+package blossomclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// BufferUnknownLength makes uploads of unknown length (contentLength -1) spool the body to
+// a temporary file in dir ("" = system temp directory) first, so the request is sent with a
+// Content-Length instead of chunked transfer encoding (for servers that reject chunked
+// uploads, e.g. behind some nginx setups). The upload starts once the whole body was read
+func (c *Client) BufferUnknownLength(dir string) {
+	c.bufferUnknownLength = true
+	c.bufferDir = dir
+}
+
+// BuffersUnknownLength reports whether uploads of unknown length are spooled before sending
+func (c *Client) BuffersUnknownLength() bool {
+	return c.bufferUnknownLength
+}
+
+// spoolBody copies body to a temporary file and returns it rewound, with its size
+// The caller calls the returned cleanup function, which closes and removes the file
+func (c *Client) spoolBody(body io.Reader) (*os.File, int64, func(), error) {
+	f, err := os.CreateTemp(c.bufferDir, "blossomclient-upload-*")
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to create upload spool file: %w", err)
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	size, err := io.Copy(f, body)
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, fmt.Errorf("failed to spool upload body: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, 0, nil, fmt.Errorf("failed to rewind upload spool file: %w", err)
+	}
+
+	c.verbose.Debugf(context.Background(), "Client.Upload: spooled %d bytes of unknown-length body to %s to avoid chunked encoding", size, f.Name())
+	return f, size, cleanup, nil
+}
@@ -0,0 +1,142 @@
+package blossomclient
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Compression policies for response bodies (see SetCompression)
+const (
+	// CompressionAuto lets Go's transport request gzip and decompress it transparently
+	// (the default); bodies a server compresses anyway with another encoding are decoded too
+	CompressionAuto = "auto"
+	// CompressionOff asks for uncompressed responses (Accept-Encoding: identity), for servers
+	// whose compressed responses are broken; compressed bodies sent regardless are decoded
+	CompressionOff = "off"
+	// CompressionForce always asks for gzip or deflate and decodes the response itself, for
+	// servers that only compress when asked explicitly
+	CompressionForce = "force"
+)
+
+// SetCompression sets the response compression policy: CompressionAuto, CompressionOff or
+// CompressionForce. Call before WrapTransport, which wraps the new transport
+func (c *Client) SetCompression(policy string) error {
+	switch policy {
+	case "", CompressionAuto:
+		policy = CompressionAuto
+	case CompressionOff, CompressionForce:
+	default:
+		return fmt.Errorf("unknown compression policy %q (expected auto, off or force)", policy)
+	}
+	c.compression = policy
+	c.rebuildTransport()
+	return nil
+}
+
+// setAcceptEncoding sets the Accept-Encoding header of a request whose response body the
+// client reads itself (see readBody); in auto mode the transport sets it
+func (c *Client) setAcceptEncoding(req *http.Request) {
+	switch c.compression {
+	case CompressionOff:
+		req.Header.Set("Accept-Encoding", "identity")
+	case CompressionForce:
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+}
+
+// readBody reads a response body, decoding gzip or deflate content the transport didn't
+// already decompress (explicit Accept-Encoding, or a server compressing unasked)
+// Bodies are limited to the client's response size limit (see SetMaxResponseBytes), before
+// and after decoding; a larger body is cut there and returned with ErrResponseTooLarge
+func (c *Client) readBody(resp *http.Response) ([]byte, error) {
+	if resp.Uncompressed {
+		return c.readLimited(resp.Body)
+	}
+
+	var reader io.Reader = c.limitReader(resp.Body)
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return c.readLimited(reader)
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			if err == io.EOF {
+				// Empty body labelled as gzip
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed to decode gzip response: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	case "deflate":
+		// Most servers send zlib-wrapped deflate, a few raw deflate
+		fr, err := newDeflateReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode deflate response: %w", err)
+		}
+		defer fr.Close()
+		reader = fr
+	default:
+		return nil, fmt.Errorf("unsupported response content encoding %q", encoding)
+	}
+
+	body, err := c.readLimited(reader)
+	if err != nil && !errors.Is(err, ErrResponseTooLarge) {
+		return nil, fmt.Errorf("failed to decode %s response: %w", resp.Header.Get("Content-Encoding"), err)
+	}
+	return body, err
+}
+
+// DecodedBody returns a reader for the bytes of a blob response, decoding a gzip or deflate
+// Content-Encoding the transport didn't already remove (a server compressing unasked), so
+// the blob can be hashed. Closing it closes the response body
+func DecodedBody(resp *http.Response) (io.ReadCloser, error) {
+	if resp.Uncompressed {
+		return resp.Body, nil
+	}
+	var decoder io.ReadCloser
+	var err error
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip", "x-gzip":
+		decoder, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		decoder, err = newDeflateReader(resp.Body)
+	default:
+		err = fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to decode %s body: %w", resp.Header.Get("Content-Encoding"), err)
+	}
+	return &decodedBody{ReadCloser: decoder, body: resp.Body}, nil
+}
+
+// decodedBody closes both the decoder and the response body it reads
+type decodedBody struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (d *decodedBody) Close() error {
+	d.ReadCloser.Close()
+	return d.body.Close()
+}
+
+// newDeflateReader returns a reader for a zlib-wrapped or raw deflate stream
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	// A zlib header names the deflate method and is a multiple of 31
+	if header, err := br.Peek(2); err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
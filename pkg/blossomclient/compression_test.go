@@ -0,0 +1,189 @@
+package blossomclient
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// encode compresses data with a Content-Encoding ("gzip", "deflate" for zlib-wrapped
+// deflate, "raw-deflate" for deflate without the zlib wrapper)
+func encode(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		var err error
+		if w, err = flate.NewWriter(&buf, flate.DefaultCompression); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// compressingServer serves body for every request, compressed with encoding when the
+// request accepts it or always with unasked. The Accept-Encoding of the last request is
+// stored in accepted
+func compressingServer(t *testing.T, encoding string, unasked bool, body []byte, accepted *string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		*accepted = r.Header.Get("Accept-Encoding")
+		header := strings.TrimPrefix(encoding, "raw-")
+		if unasked || strings.Contains(*accepted, header) {
+			w.Header().Set("Content-Encoding", header)
+			w.Write(encode(t, encoding, body))
+			return
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestClient returns a client for srv with the given compression policy
+func newTestClient(t *testing.T, srv *httptest.Server, policy string) *Client {
+	t.Helper()
+	c := New(srv.URL, "", 0, false)
+	if err := c.SetCompression(policy); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestUploadDecodesCompressedResponses(t *testing.T) {
+	descriptor := []byte(`{"url":"https://example.com/blob","sha256":"` + strings.Repeat("ab", 32) + `","size":3}`)
+	for _, tc := range []struct {
+		policy         string
+		encoding       string
+		unasked        bool
+		acceptEncoding string
+	}{
+		{CompressionAuto, "gzip", false, "gzip"},
+		{CompressionAuto, "gzip", true, "gzip"},
+		{CompressionAuto, "deflate", true, "gzip"},
+		{CompressionOff, "gzip", false, "identity"},
+		{CompressionOff, "gzip", true, "identity"},
+		{CompressionOff, "raw-deflate", true, "identity"},
+		{CompressionForce, "gzip", false, "gzip, deflate"},
+		{CompressionForce, "deflate", false, "gzip, deflate"},
+		{CompressionForce, "raw-deflate", false, "gzip, deflate"},
+	} {
+		name := fmt.Sprintf("%s/%s/unasked=%t", tc.policy, tc.encoding, tc.unasked)
+		t.Run(name, func(t *testing.T) {
+			var accepted string
+			srv := compressingServer(t, tc.encoding, tc.unasked, descriptor, &accepted)
+			c := newTestClient(t, srv, tc.policy)
+
+			body, status, err := c.UploadWithStatus(context.Background(), strings.NewReader("abc"), "text/plain", 3, nil)
+			if err != nil {
+				t.Fatalf("upload failed: %v", err)
+			}
+			if status != http.StatusOK {
+				t.Errorf("status = %d, want 200", status)
+			}
+			if !bytes.Equal(body, descriptor) {
+				t.Errorf("response = %q, want the decoded descriptor %q", body, descriptor)
+			}
+			if accepted != tc.acceptEncoding {
+				t.Errorf("Accept-Encoding = %q, want %q", accepted, tc.acceptEncoding)
+			}
+		})
+	}
+}
+
+func TestUploadIgnoresCallerAcceptEncoding(t *testing.T) {
+	var accepted string
+	descriptor := []byte(`{"size":3}`)
+	srv := compressingServer(t, "gzip", false, descriptor, &accepted)
+	c := newTestClient(t, srv, CompressionOff)
+
+	body, _, err := c.UploadWithStatus(context.Background(), strings.NewReader("abc"), "text/plain", 3,
+		map[string]string{"Accept-Encoding": "gzip"})
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if accepted != "identity" {
+		t.Errorf("Accept-Encoding = %q, want the policy's identity", accepted)
+	}
+	if !bytes.Equal(body, descriptor) {
+		t.Errorf("response = %q, want %q", body, descriptor)
+	}
+}
+
+func TestDecodedBodyHashesDecodedBlob(t *testing.T) {
+	blob := bytes.Repeat([]byte("blossom blob "), 4096)
+	sum := sha256.Sum256(blob)
+	hash := hex.EncodeToString(sum[:])
+	for _, tc := range []struct {
+		policy   string
+		encoding string
+		unasked  bool
+	}{
+		{CompressionAuto, "gzip", false},
+		{CompressionAuto, "gzip", true},
+		{CompressionOff, "gzip", true},
+		{CompressionOff, "deflate", true},
+		{CompressionOff, "raw-deflate", true},
+		{CompressionForce, "gzip", true},
+		{CompressionOff, "gzip", false}, // Not compressed: passed through unchanged
+	} {
+		name := fmt.Sprintf("%s/%s/unasked=%t", tc.policy, tc.encoding, tc.unasked)
+		t.Run(name, func(t *testing.T) {
+			var accepted string
+			srv := compressingServer(t, tc.encoding, tc.unasked, blob, &accepted)
+			c := newTestClient(t, srv, tc.policy)
+
+			resp, err := c.Get(context.Background(), hash)
+			if err != nil {
+				t.Fatalf("get failed: %v", err)
+			}
+			body, err := DecodedBody(resp)
+			if err != nil {
+				t.Fatalf("DecodedBody: %v", err)
+			}
+			defer body.Close()
+
+			hasher := sha256.New()
+			if _, err := io.Copy(hasher, body); err != nil {
+				t.Fatalf("reading blob: %v", err)
+			}
+			if got := hex.EncodeToString(hasher.Sum(nil)); got != hash {
+				t.Errorf("hash of the decoded blob = %s, want %s", got, hash)
+			}
+		})
+	}
+}
+
+func TestDecodedBodyRejectsUnknownEncoding(t *testing.T) {
+	resp := &http.Response{
+		Header: http.Header{"Content-Encoding": []string{"br"}},
+		Body:   io.NopCloser(strings.NewReader("data")),
+	}
+	if _, err := DecodedBody(resp); err == nil {
+		t.Error("DecodedBody accepted an unsupported encoding")
+	}
+}
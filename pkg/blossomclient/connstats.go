@@ -0,0 +1,80 @@
+package blossomclient
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+// ConnStats counts how a client's requests were carried: over new or reused connections,
+// and with which protocol (e.g., "HTTP/1.1", "HTTP/2.0")
+type ConnStats struct {
+	NewConns    int64            `json:"new_connections"`
+	ReusedConns int64            `json:"reused_connections"`
+	Protocols   map[string]int64 `json:"protocols"`
+}
+
+// connTracker records connection statistics shared by all transports of a client
+type connTracker struct {
+	mu    sync.Mutex
+	stats ConnStats
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{stats: ConnStats{Protocols: make(map[string]int64)}}
+}
+
+// gotConn records whether a request got a new or an idle (reused) connection
+func (t *connTracker) gotConn(info httptrace.GotConnInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if info.Reused {
+		t.stats.ReusedConns++
+	} else {
+		t.stats.NewConns++
+	}
+}
+
+// gotResponse records the protocol a response was received with
+func (t *connTracker) gotResponse(resp *http.Response) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats.Protocols[resp.Proto]++
+}
+
+// snapshot returns a copy of the statistics
+func (t *connTracker) snapshot() ConnStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	protocols := make(map[string]int64, len(t.stats.Protocols))
+	for proto, n := range t.stats.Protocols {
+		protocols[proto] = n
+	}
+	return ConnStats{
+		NewConns:    t.stats.NewConns,
+		ReusedConns: t.stats.ReusedConns,
+		Protocols:   protocols,
+	}
+}
+
+// trackingTransport is a RoundTripper that feeds a connTracker
+type trackingTransport struct {
+	base    http.RoundTripper
+	tracker *connTracker
+}
+
+func (t *trackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{GotConn: t.tracker.gotConn}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		t.tracker.gotResponse(resp)
+	}
+	return resp, err
+}
+
+// ConnStats returns the connection statistics of the client, so operators can confirm
+// connections to the server are actually reused and which protocol is negotiated
+func (c *Client) ConnStats() ConnStats {
+	return c.conns.snapshot()
+}
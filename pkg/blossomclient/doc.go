@@ -0,0 +1,17 @@
+// Package blossomclient is a client for Blossom media servers (BUD-01, 02, 04 and 06)
+//
+// Client talks to a single server. ServerSet runs uploads, mirrors, lists and deletes
+// against several servers in parallel and reports per-server results, succeeding when a
+// minimum number of servers succeed. Uploads are streamed to every server at once, so the
+// body is read only once and never buffered in memory:
+//
+//	servers := blossomclient.NewServerSet([]string{
+//		"https://blossom1.example.com",
+//		"https://blossom2.example.com",
+//	}, 2, false)
+//	results, err := servers.Upload(ctx, file, "image/png", size, map[string]string{
+//		"Authorization": authHeader,
+//	})
+//
+// This is the same client the espelhator proxy uses to talk to its upstream servers.
+package blossomclient
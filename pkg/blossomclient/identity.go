@@ -0,0 +1,43 @@
+package blossomclient
+
+import "net/http"
+
+// Identity describes how the client identifies itself to the server, so mirror operators
+// can recognize (and rate-limit) traffic from a specific proxy deployment
+type Identity struct {
+	UserAgent string // User-Agent for every request ("" = Go's default)
+	// Value of an X-Forwarded-By header added to every request ("" = not sent)
+	ForwardedBy string
+	// Keep a User-Agent passed in the request headers (the original client's) instead of
+	// replacing it with UserAgent; requests without one still get UserAgent
+	ForwardClientUserAgent bool
+}
+
+// SetIdentity sets the headers identifying the client on all requests
+func (c *Client) SetIdentity(id Identity) {
+	c.identity = id
+}
+
+// identityTransport adds the client's identification headers to every request
+type identityTransport struct {
+	base   http.RoundTripper
+	client *Client
+}
+
+func (t *identityTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := t.client.identity
+	keepUserAgent := id.ForwardClientUserAgent && req.Header.Get("User-Agent") != ""
+	if (id.UserAgent == "" || keepUserAgent) && id.ForwardedBy == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	// A RoundTripper must not modify the caller's request
+	req = req.Clone(req.Context())
+	if id.UserAgent != "" && !keepUserAgent {
+		req.Header.Set("User-Agent", id.UserAgent)
+	}
+	if id.ForwardedBy != "" {
+		req.Header.Set("X-Forwarded-By", id.ForwardedBy)
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,40 @@
+package blossomclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrResponseTooLarge is returned when an upstream response body exceeds the client's
+// response size limit
+var ErrResponseTooLarge = errors.New("upstream response too large")
+
+// SetMaxResponseBytes limits how many bytes the client reads from upload, mirror, list and
+// delete response bodies (after decompression), so a misbehaving server can't make the proxy
+// buffer gigabytes. 0 or negative means no limit (the default)
+func (c *Client) SetMaxResponseBytes(n int64) {
+	c.maxResponseBytes = n
+}
+
+// limitReader caps r at one byte over the response size limit, so readLimited can tell
+// a body of exactly the limit from a larger one
+func (c *Client) limitReader(r io.Reader) io.Reader {
+	if c.maxResponseBytes <= 0 {
+		return r
+	}
+	return io.LimitReader(r, c.maxResponseBytes+1)
+}
+
+// readLimited reads r up to the response size limit; a larger body is cut at the limit and
+// returned together with ErrResponseTooLarge
+func (c *Client) readLimited(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(c.limitReader(r))
+	if err != nil {
+		return body, err
+	}
+	if c.maxResponseBytes > 0 && int64(len(body)) > c.maxResponseBytes {
+		return body[:c.maxResponseBytes], fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, c.maxResponseBytes)
+	}
+	return body, nil
+}
@@ -0,0 +1,39 @@
+package blossomclient
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// DebugLogger receives the client's debug output (see SetDebugLogger)
+type DebugLogger interface {
+	// Enabled reports whether debug lines are logged, so costly ones can be skipped
+	Enabled() bool
+	// Debugf logs a debug line; ctx is the context of the request it is about
+	Debugf(ctx context.Context, format string, args ...any)
+}
+
+// slogDebugLogger logs debug lines through the default slog logger, if on
+type slogDebugLogger struct {
+	on bool
+}
+
+func (l slogDebugLogger) Enabled() bool {
+	return l.on
+}
+
+func (l slogDebugLogger) Debugf(ctx context.Context, format string, args ...any) {
+	if l.on {
+		slog.Default().DebugContext(ctx, fmt.Sprintf(format, args...), slog.String("component", "client"))
+	}
+}
+
+// SetDebugLogger makes the client log debug output through logger instead of the fixed
+// setting it was created with, e.g. to follow changes made at runtime (nil turns it off)
+func (c *Client) SetDebugLogger(logger DebugLogger) {
+	if logger == nil {
+		logger = slogDebugLogger{}
+	}
+	c.verbose = logger
+}
@@ -0,0 +1,266 @@
+package blossomclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of an operation on a single server of a ServerSet
+type Result struct {
+	ServerURL  string
+	Body       []byte // Response body (blob descriptor for uploads and mirrors, JSON array for lists)
+	StatusCode int    // HTTP status of the response (0 if no response was received)
+	Err        error  // nil if the operation succeeded on this server
+}
+
+// QuorumError is returned when fewer servers than required succeeded
+// Results holds the per-server outcomes, including the failures
+type QuorumError struct {
+	Succeeded int
+	Required  int
+	Results   []Result
+}
+
+func (e *QuorumError) Error() string {
+	return fmt.Sprintf("only %d servers succeeded, need at least %d", e.Succeeded, e.Required)
+}
+
+// ServerSet runs Blossom operations against several servers in parallel
+// An operation succeeds when at least MinSuccess servers succeed
+type ServerSet struct {
+	clients    []*Client
+	minSuccess int
+	logger     *slog.Logger
+}
+
+// NewServerSet creates a ServerSet for the given server URLs
+// minSuccess is the number of servers an operation must succeed on (values below 1 mean 1)
+// Clients have no overall timeout; pass a context with a deadline to bound each operation
+func NewServerSet(serverURLs []string, minSuccess int, verbose bool) *ServerSet {
+	clients := make([]*Client, 0, len(serverURLs))
+	for _, serverURL := range serverURLs {
+		clients = append(clients, New(serverURL, "", 0, verbose))
+	}
+	return NewServerSetFromClients(clients, minSuccess)
+}
+
+// NewServerSetFromClients creates a ServerSet from already configured clients
+// (e.g., clients using an alternative connect address)
+func NewServerSetFromClients(clients []*Client, minSuccess int) *ServerSet {
+	if minSuccess < 1 {
+		minSuccess = 1
+	}
+	return &ServerSet{
+		clients:    clients,
+		minSuccess: minSuccess,
+		logger:     slog.Default(),
+	}
+}
+
+// SetLogger makes the set log warnings (e.g., a recovered panic) through logger instead
+// of the default slog logger (nil restores the default)
+func (s *ServerSet) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	s.logger = logger
+}
+
+// Clients returns the clients of the set
+func (s *ServerSet) Clients() []*Client {
+	return s.clients
+}
+
+// Upload streams body to every server in parallel (BUD-02 PUT /upload)
+// The body is read once and fanned out as it arrives; a server that fails mid-stream
+// doesn't stop the others. If the body can't be read to the end, every upload is aborted
+// and the read error is returned
+func (s *ServerSet) Upload(ctx context.Context, body io.Reader, contentType string, contentLength int64, headers map[string]string) ([]Result, error) {
+	uploadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	readers := make([]*io.PipeReader, len(s.clients))
+	writers := make([]io.Writer, len(s.clients))
+	pipeWriters := make([]*tolerantWriter, len(s.clients))
+	for i := range s.clients {
+		r, w := io.Pipe()
+		readers[i] = r
+		pipeWriters[i] = &tolerantWriter{w: w}
+		writers[i] = pipeWriters[i]
+	}
+
+	results := make([]Result, len(s.clients))
+	var wg sync.WaitGroup
+	for i, c := range s.clients {
+		wg.Add(1)
+		go func(i int, c *Client) {
+			defer wg.Done()
+			defer readers[i].Close()
+			defer s.recoverResult(c, &results[i])
+			respBody, status, err := c.UploadWithStatus(uploadCtx, readers[i], contentType, contentLength, headers)
+			results[i] = newResult(c, respBody, status, err)
+		}(i, c)
+	}
+
+	_, copyErr := io.Copy(io.MultiWriter(writers...), body)
+	for _, w := range pipeWriters {
+		if copyErr != nil {
+			// Never let a server see a clean EOF on a truncated body
+			w.CloseWithError(copyErr)
+		} else {
+			w.Close()
+		}
+	}
+	if copyErr != nil {
+		cancel()
+	}
+	wg.Wait()
+
+	if copyErr != nil {
+		return results, fmt.Errorf("failed to read upload body: %w", copyErr)
+	}
+	return results, s.checkQuorum(results)
+}
+
+// Mirror asks every server to mirror the blob at blobURL (BUD-04 PUT /mirror)
+func (s *ServerSet) Mirror(ctx context.Context, blobURL string, headers map[string]string) ([]Result, error) {
+	body, err := json.Marshal(map[string]string{"url": blobURL})
+	if err != nil {
+		return nil, err
+	}
+	results := s.each(func(c *Client) Result {
+		respBody, status, err := c.MirrorWithStatus(ctx, bytes.NewReader(body), "application/json", headers)
+		return newResult(c, respBody, status, err)
+	})
+	return results, s.checkQuorum(results)
+}
+
+// List lists the blobs of pubkey on every server (BUD-02 GET /list/<pubkey>)
+// Descriptors are merged by sha256; the first server to report a blob wins
+func (s *ServerSet) List(ctx context.Context, pubkey string) ([]map[string]interface{}, []Result, error) {
+	results := s.each(func(c *Client) Result {
+		respBody, err := c.List(ctx, pubkey)
+		return newResult(c, respBody, 0, err)
+	})
+
+	merged := make([]map[string]interface{}, 0)
+	seen := make(map[string]bool)
+	for i := range results {
+		if results[i].Err != nil {
+			continue
+		}
+		var descriptors []map[string]interface{}
+		if err := json.Unmarshal(results[i].Body, &descriptors); err != nil {
+			results[i].Err = fmt.Errorf("invalid list response: %w", err)
+			continue
+		}
+		for _, descriptor := range descriptors {
+			hash, _ := descriptor["sha256"].(string)
+			if hash == "" || seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			merged = append(merged, descriptor)
+		}
+	}
+	return merged, results, s.checkQuorum(results)
+}
+
+// Delete deletes the blob with the given hash from every server (BUD-02 DELETE /<sha256>)
+func (s *ServerSet) Delete(ctx context.Context, hash string, headers map[string]string) ([]Result, error) {
+	results := s.each(func(c *Client) Result {
+		return newResult(c, nil, 0, c.Delete(ctx, hash, headers))
+	})
+	return results, s.checkQuorum(results)
+}
+
+// each runs op against every server in parallel and returns the results in server order
+func (s *ServerSet) each(op func(c *Client) Result) []Result {
+	results := make([]Result, len(s.clients))
+	var wg sync.WaitGroup
+	for i, c := range s.clients {
+		wg.Add(1)
+		go func(i int, c *Client) {
+			defer wg.Done()
+			defer s.recoverResult(c, &results[i])
+			start := time.Now()
+			results[i] = op(c)
+			c.verbose.Debugf(context.Background(), "ServerSet: %s finished in %v (err=%v)", c.GetBaseURL(), time.Since(start), results[i].Err)
+		}(i, c)
+	}
+	wg.Wait()
+	return results
+}
+
+// recoverResult turns a panic in a per-server goroutine into a failed result for that server,
+// so one misbehaving server can't crash the caller's process
+func (s *ServerSet) recoverResult(c *Client, result *Result) {
+	if v := recover(); v != nil {
+		s.logger.Warn(fmt.Sprintf("ServerSet: recovered from panic for %s: %v", c.GetBaseURL(), v),
+			slog.String("component", "client"), slog.String("stack", string(debug.Stack())))
+		*result = newResult(c, nil, 0, fmt.Errorf("panic: %v", v))
+	}
+}
+
+// checkQuorum returns a QuorumError if fewer than minSuccess results succeeded
+func (s *ServerSet) checkQuorum(results []Result) error {
+	succeeded := 0
+	for _, result := range results {
+		if result.Err == nil {
+			succeeded++
+		}
+	}
+	if succeeded < s.minSuccess {
+		return &QuorumError{Succeeded: succeeded, Required: s.minSuccess, Results: results}
+	}
+	return nil
+}
+
+// newResult builds a Result, taking the status code from HTTP errors
+func newResult(c *Client, body []byte, status int, err error) Result {
+	if code, ok := ExtractStatusCode(err); ok {
+		status = code
+	}
+	return Result{
+		ServerURL:  c.GetBaseURL(),
+		Body:       body,
+		StatusCode: status,
+		Err:        err,
+	}
+}
+
+// tolerantWriter writes to a pipe but never reports errors, so one failed server
+// doesn't stop io.MultiWriter from feeding the others
+type tolerantWriter struct {
+	mu     sync.Mutex
+	w      *io.PipeWriter
+	failed bool
+}
+
+func (tw *tolerantWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.failed {
+		return len(p), nil
+	}
+	if _, err := tw.w.Write(p); err != nil {
+		tw.failed = true
+		tw.w.CloseWithError(err)
+	}
+	return len(p), nil
+}
+
+func (tw *tolerantWriter) Close() error {
+	return tw.w.Close()
+}
+
+func (tw *tolerantWriter) CloseWithError(err error) error {
+	return tw.w.CloseWithError(err)
+}
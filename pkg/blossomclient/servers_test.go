@@ -0,0 +1,200 @@
+package blossomclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeServer is a minimal Blossom server that fails every request with status if it is set
+type fakeServer struct {
+	status int
+	listed []string // Hashes returned by /list
+
+	mu       sync.Mutex
+	received [][]byte // Upload and mirror bodies
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return // Aborted upload
+	}
+	if f.status != 0 {
+		http.Error(w, "failing", f.status)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPut:
+		f.received = append(f.received, body)
+		sum := sha256.Sum256(body)
+		fmt.Fprintf(w, `{"sha256":"%x","size":%d}`, sum, len(body))
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/list/"):
+		descriptors := make([]map[string]string, 0, len(f.listed))
+		for _, hash := range f.listed {
+			descriptors = append(descriptors, map[string]string{"sha256": hash, "url": "http://" + r.Host + "/" + hash})
+		}
+		json.NewEncoder(w).Encode(descriptors)
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// bodies returns the upload and mirror bodies received so far
+func (f *fakeServer) bodies() [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]byte(nil), f.received...)
+}
+
+// newFakeServers starts the given fake servers and returns a ServerSet for them
+func newFakeServers(t *testing.T, minSuccess int, fakes ...*fakeServer) *ServerSet {
+	t.Helper()
+	urls := make([]string, 0, len(fakes))
+	for _, f := range fakes {
+		srv := httptest.NewServer(f)
+		t.Cleanup(srv.Close)
+		urls = append(urls, srv.URL)
+	}
+	return NewServerSet(urls, minSuccess, false)
+}
+
+func TestServerSetUploadStreamsToEveryServer(t *testing.T) {
+	fakes := []*fakeServer{{}, {}, {status: http.StatusInternalServerError}}
+	servers := newFakeServers(t, 2, fakes...)
+
+	blob := bytes.Repeat([]byte("fan out "), 32<<10)
+	results, err := servers.Upload(context.Background(), bytes.NewReader(blob), "application/octet-stream", int64(len(blob)), nil)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	for i, f := range fakes[:2] {
+		if received := f.bodies(); len(received) != 1 || !bytes.Equal(received[0], blob) {
+			t.Errorf("server %d did not receive the whole blob", i+1)
+		}
+	}
+	if results[2].Err == nil || results[2].StatusCode != http.StatusInternalServerError {
+		t.Errorf("failing server result = %+v, want a 500 error", results[2])
+	}
+	sum := sha256.Sum256(blob)
+	if !strings.Contains(string(results[0].Body), hex.EncodeToString(sum[:])) {
+		t.Errorf("result body = %s, want the blob descriptor", results[0].Body)
+	}
+}
+
+func TestServerSetQuorum(t *testing.T) {
+	servers := newFakeServers(t, 2, &fakeServer{}, &fakeServer{status: http.StatusBadGateway})
+
+	_, err := servers.Delete(context.Background(), strings.Repeat("a", 64), nil)
+	var quorumErr *QuorumError
+	if !errors.As(err, &quorumErr) {
+		t.Fatalf("Delete error = %v, want a QuorumError", err)
+	}
+	if quorumErr.Succeeded != 1 || quorumErr.Required != 2 || len(quorumErr.Results) != 2 {
+		t.Errorf("QuorumError = %+v, want 1 of 2 succeeded with both results", quorumErr)
+	}
+
+	if servers := NewServerSet(nil, 0, false); servers.minSuccess != 1 {
+		t.Errorf("minSuccess = %d, want values below 1 to mean 1", servers.minSuccess)
+	}
+}
+
+func TestServerSetUploadAbortsOnBodyError(t *testing.T) {
+	fakes := []*fakeServer{{}, {}}
+	servers := newFakeServers(t, 1, fakes...)
+
+	readErr := errors.New("client went away")
+	body := io.MultiReader(strings.NewReader("partial"), &failingReader{err: readErr})
+	_, err := servers.Upload(context.Background(), body, "", -1, nil)
+	if !errors.Is(err, readErr) {
+		t.Fatalf("Upload error = %v, want the body read error", err)
+	}
+	for i, f := range fakes {
+		if len(f.bodies()) != 0 {
+			t.Errorf("server %d stored a truncated body", i+1)
+		}
+	}
+}
+
+func TestServerSetMirror(t *testing.T) {
+	fake := &fakeServer{}
+	servers := newFakeServers(t, 1, fake)
+
+	blobURL := "https://origin.example.com/" + strings.Repeat("b", 64)
+	if _, err := servers.Mirror(context.Background(), blobURL, nil); err != nil {
+		t.Fatalf("Mirror: %v", err)
+	}
+	var request map[string]string
+	if received := fake.bodies(); len(received) != 1 || json.Unmarshal(received[0], &request) != nil || request["url"] != blobURL {
+		t.Errorf("mirror request = %q, want {\"url\": %q}", received, blobURL)
+	}
+}
+
+func TestServerSetListMergesByHash(t *testing.T) {
+	a, b, c := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64)
+	servers := newFakeServers(t, 1, &fakeServer{listed: []string{a, b}}, &fakeServer{listed: []string{b, c}}, &fakeServer{status: http.StatusNotFound})
+
+	merged, results, err := servers.List(context.Background(), strings.Repeat("f", 64))
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var hashes []string
+	for _, descriptor := range merged {
+		hashes = append(hashes, descriptor["sha256"].(string))
+	}
+	if strings.Join(hashes, ",") != strings.Join([]string{a, b, c}, ",") {
+		t.Errorf("merged hashes = %v, want each blob once in server order", hashes)
+	}
+	if results[2].Err == nil {
+		t.Error("failing server has no error in its result")
+	}
+}
+
+func TestServerSetRecoversPanics(t *testing.T) {
+	servers := newFakeServers(t, 1, &fakeServer{}, &fakeServer{})
+	servers.Clients()[1].WrapTransport(func(http.RoundTripper) http.RoundTripper {
+		return panickingTransport{}
+	})
+	var logged bytes.Buffer
+	servers.SetLogger(slog.New(slog.NewTextHandler(&logged, nil)))
+
+	results, err := servers.Delete(context.Background(), strings.Repeat("a", 64), nil)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if results[1].Err == nil || !strings.Contains(results[1].Err.Error(), "panic") {
+		t.Errorf("panicking server result = %+v, want a panic error", results[1])
+	}
+	if !strings.Contains(logged.String(), "recovered from panic") {
+		t.Errorf("log = %q, want the panic logged through the set's logger", logged.String())
+	}
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+type panickingTransport struct{}
+
+func (panickingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	panic("transport exploded")
+}
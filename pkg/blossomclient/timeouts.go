@@ -0,0 +1,85 @@
+package blossomclient
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Timeouts bounds the individual phases of a request, independently of its overall deadline
+// (the request context), so an unresponsive server is detected quickly while long body
+// transfers are unaffected. Zero values keep Go's defaults (no response header timeout)
+type Timeouts struct {
+	Dial           time.Duration // Establishing the TCP connection
+	TLSHandshake   time.Duration // Completing the TLS handshake
+	ResponseHeader time.Duration // Receiving response headers once the request, including its body, was sent
+}
+
+// SetTimeouts replaces the client's transport with one applying the given timeouts
+// Mirror requests don't use the response header timeout, since the server only answers
+// after downloading the blob. Call before WrapTransport, which wraps the new transport
+func (c *Client) SetTimeouts(t Timeouts) {
+	c.timeouts = t
+	c.rebuildTransport()
+}
+
+// ForceHTTP1 makes the client use HTTP/1.1 even if the server offers HTTP/2
+// (for servers with a broken HTTP/2 implementation). Call before WrapTransport
+func (c *Client) ForceHTTP1() {
+	c.forceHTTP1 = true
+	c.rebuildTransport()
+}
+
+// rebuildTransport replaces the client's transports according to its settings
+func (c *Client) rebuildTransport() {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.timeouts.Dial > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   c.timeouts.Dial,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if c.timeouts.TLSHandshake > 0 {
+		transport.TLSHandshakeTimeout = c.timeouts.TLSHandshake
+	}
+	// With compression off, the transport must not request gzip on its own
+	transport.DisableCompression = c.compression == CompressionOff
+	if c.forceHTTP1 {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		transport.Protocols = protocols
+		// The cloned TLS config may still offer h2 through ALPN
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	}
+
+	mirrorTransport := transport.Clone()
+	transport.ResponseHeaderTimeout = c.timeouts.ResponseHeader
+
+	c.httpClient.Transport = &budgetTransport{
+		base: &breakerTransport{
+			base: &identityTransport{
+				base:   &trackingTransport{base: transport, tracker: c.conns},
+				client: c,
+			},
+			client: c,
+		},
+		client: c,
+	}
+	c.mirrorClient = &http.Client{
+		Transport: &budgetTransport{
+			base: &breakerTransport{
+				base: &identityTransport{
+					base:   &trackingTransport{base: mirrorTransport, tracker: c.conns},
+					client: c,
+				},
+				client: c,
+			},
+			client: c,
+		},
+		Timeout: c.httpClient.Timeout,
+	}
+}
@@ -101,7 +101,12 @@ echo "[DEBUG] Using Blossom upload event format (kind 24242)" >&2
 KIND=24242
 
 # Action tag "t" must match the endpoint per BUD-01: list -> "list", upload/mirror -> "upload", delete -> "delete"
-if echo "$URL" | grep -qE '/list[/?]|/list$'; then
+# The proxy's admin API takes "admin" (bound to the method and URL), its status pages "status"
+if echo "$URL" | grep -qE '/admin/'; then
+    ACTION_TAG="admin"
+elif echo "$URL" | grep -qE '/stats([/?]|$)|/health([/?]|$)|/status\.json([/?]|$)|/metrics([/?]|$)'; then
+    ACTION_TAG="status"
+elif echo "$URL" | grep -qE '/list[/?]|/list$'; then
     ACTION_TAG="list"
 elif echo "$URL" | grep -qE '/mirror[/?]|/mirror$'; then
     ACTION_TAG="upload"
@@ -117,6 +122,9 @@ echo "[DEBUG] Action tag for URL: [\"t\",\"$ACTION_TAG\"]" >&2
 
 # Build tags for Blossom event: ["t", "<action>"], ["x", "hash"], ["expiration", "timestamp"]
 TAGS=("[\"t\",\"$ACTION_TAG\"]")
+if [ "$ACTION_TAG" = "admin" ]; then
+    TAGS+=("[\"method\",\"$METHOD\"]" "[\"u\",\"$URL\"]")
+fi
 
 # Determine the hash - priority: -h/--hash parameter > extract from JSON body URL > compute from file
 # Hash parameter is passed by mirror_hash.sh to use the hash from the blob URL, not the JSON file